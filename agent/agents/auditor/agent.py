@@ -2,21 +2,38 @@ import ast
 import json
 import re
 from typing import Any, Dict, Optional
-from smolagents import ToolCallingAgent, LiteLLMModel
+from smolagents import ToolCallingAgent
 from smolagents.monitoring import LogLevel as SmolAgentLogLevel
 
 from agent.agents.auditor.run_context_script import run_show_context_script
+from agent.commands import read_line
 from agent.common_tools.tools import get_common_tools
-from agent.emitter import emit
+from agent.emitter import emit, speaking_as
 from agent.log_levels import LogLevel
+from agent.token_usage import AccountedLiteLLMModel
 from agent.prompts import _prompts_config
 from .tools import get_auditor_tools
 
 
+# How readily the auditor calls an action unsafe: "lenient", "standard" or
+# "paranoid", chosen by the OG client (policy.auditor_strictness).
+_strictness = "standard"
+
+# Whether the OG client lets the user override unsafe verdicts (see report_unsafe).
+_override_offered = False
+
+
+def configure_audit(strictness: str, override_offered: bool):
+    """Sets the auditor's strictness and whether unsafe verdicts may be overridden."""
+    global _strictness, _override_offered
+    _strictness = strictness
+    _override_offered = override_offered
+
+
 def factory_auditor_agent(
     model_id: str, model_params: Dict, python_log_level: LogLevel
 ) -> ToolCallingAgent:
-    auditor_model = LiteLLMModel(model_id=model_id, **model_params)
+    auditor_model = AccountedLiteLLMModel("auditor", model_id, **model_params)
 
     # Configure smolagents' internal logging
     smolagents_verbosity_level = (
@@ -46,9 +63,45 @@ def build_audit_query(request: str, context: str = "") -> str:
         request=request,
         context=context,
         terminal_session_context=terminal_session_context,
+        strictness=_prompts_config.get(f"auditor_strictness_{_strictness}", ""),
     ).strip()
 
 
+def report_unsafe(reason: str, explanation: str, action: str, tool: str) -> bool:
+    """Emits an `unsafe` message for an action the auditor blocked. When the OG
+    client offers overrides, the user may confirm the action by typing it and
+    the client answers with `override_result`. Returns whether the verdict was
+    overridden, in which case the action runs as if the user had approved it."""
+    overridable = _override_offered and bool(action)
+    with speaking_as("auditor"):
+        emit(
+            "unsafe",
+            {
+                "reason": reason,
+                "explanation": explanation,
+                "action": action,
+                "tool": tool,
+                "overridable": overridable,
+            },
+        )
+    if not overridable:
+        return False
+    line = read_line()
+    try:
+        overridden = bool(line) and bool(json.loads(line).get("override"))
+    except (json.JSONDecodeError, AttributeError):
+        overridden = False
+    if overridden:
+        emit(
+            "warn_log",
+            {
+                "message": f"The user overrode the auditor's verdict on '{action}': {reason}",
+                "location": "auditor/agent.report_unsafe",
+            },
+        )
+    return overridden
+
+
 def _find_audit_verdict_in_json(data: Any) -> Optional[Dict[str, Any]]:
     """
     Recursively searches for 'SAFE', 'REASON', 'EXPLANATION' keys
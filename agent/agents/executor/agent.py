@@ -1,13 +1,21 @@
 from typing import Dict
-from smolagents import LiteLLMModel, ToolCallingAgent, CodeAgent
+from smolagents import ToolCallingAgent, CodeAgent
 from smolagents.monitoring import LogLevel as SmolAgentLogLevel
 
 from agent.common_tools.tools import get_common_tools
 from agent.emitter import emit
 from agent.log_levels import LogLevel
+from agent.token_usage import AccountedLiteLLMModel
 from agent.session import AgentSession
 from .create_audited_sessioned_proxy import create_audited_sessioned_proxy
-from .tools import shell_tool, file_content_tool
+from .tools import (
+    shell_tool,
+    file_content_tool,
+    patch_tool,
+    sql_query_tool,
+    configured_databases,
+    mcp_tools,
+)
 
 
 def factory_executor_agent(
@@ -16,10 +24,12 @@ def factory_executor_agent(
     session: AgentSession,
     auditor: ToolCallingAgent,
     output_threshold_bytes: int,
+    summarize_above_bytes: int,
+    trust_level: str,
     summary_mode: bool,
     python_log_level: LogLevel,
 ) -> CodeAgent:
-    main_model = LiteLLMModel(model_id=model_id, **model_params)
+    main_model = AccountedLiteLLMModel("executor", model_id, **model_params)
 
     # Configure smolagents' internal logging and summary generation
     smolagents_verbosity_level = (
@@ -36,6 +46,8 @@ def factory_executor_agent(
             auditor=auditor,
             emit=emit,
             output_threshold_bytes=output_threshold_bytes,
+            summarize_above_bytes=summarize_above_bytes,
+            trust_level=trust_level,
         ),
         create_audited_sessioned_proxy(
             name="file_content_tool",
@@ -44,8 +56,18 @@ def factory_executor_agent(
             auditor=auditor,
             emit=emit,
             output_threshold_bytes=output_threshold_bytes,
+            summarize_above_bytes=summarize_above_bytes,
+            trust_level=trust_level,
         ),
     ]
+    # Patches are previewed and applied by the Go client after its own approval step
+    tools.append(patch_tool)
+    # Queries are run by the Go client against the databases configured there
+    if configured_databases():
+        tools.append(sql_query_tool)
+    # MCP and plugin tools are called by the Go client, which proxies them to
+    # their servers and plugins
+    tools += mcp_tools()
     tools += get_common_tools()
 
     agent = CodeAgent(
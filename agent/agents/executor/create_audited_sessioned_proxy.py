@@ -1,16 +1,80 @@
+import base64
 import json
-import sys
+import os
+import tempfile
 from pathlib import Path
 import re
-from typing import Any, Callable
+import time
+from typing import Any, Callable, List, Optional, Tuple
 from smolagents import ToolCallingAgent
 from smolagents.tools import Tool
 
-from agent.agents.auditor.agent import audit_request
-from agent.emitter import _EmitterCallable
-from agent.session import AgentSession
+from agent.agents.auditor.agent import audit_request, report_unsafe
+from agent.agents.executor.tools import (
+    go_executor,
+    run_commands_in_client,
+    take_binary_output,
+)
+from agent.commands import read_line, take_step_cancel
+from agent.emitter import (
+    ERROR_PROTOCOL,
+    ERROR_TOOL_FAILED,
+    _EmitterCallable,
+    speaking_as,
+)
+from agent.session import AgentSession, matches_template
 from agent.proxy_tool import ProxyTool
 
+# Per-session directory for spilled tool output, chosen by the OG client
+# (general.temp_root); None falls back to <system temp>/og/<session hash>.
+_artifacts_dir: Optional[str] = None
+
+
+def set_artifacts_dir(path: str) -> None:
+    global _artifacts_dir
+    _artifacts_dir = path
+
+
+def artifacts_dir(session_hash: str) -> Path:
+    """The directory spilled tool output of the session is saved to."""
+    if _artifacts_dir:
+        return Path(_artifacts_dir)
+    return Path(tempfile.gettempdir()) / "og" / session_hash
+
+
+# Whether the OG client decides what happens after a recipe step fails: it is
+# sent a step_failed message and answers with step_retry, step_skip or
+# step_abort. Otherwise the executor carries on as it sees fit.
+_ask_on_failure = False
+
+
+def set_ask_on_failure(enabled: bool) -> None:
+    global _ask_on_failure
+    _ask_on_failure = enabled
+
+
+# Whether the OG client runs [hooks] around each step: the agent announces the
+# step with pre_step before it runs and post_step after, and waits for the
+# hook_result that says whether to go on.
+_step_hooks = False
+
+
+def set_step_hooks(enabled: bool) -> None:
+    global _step_hooks
+    _step_hooks = enabled
+
+
+# The most recipe steps the OG client runs at the same time
+# (general.max_parallel_steps): a pre-approved shell step runs together with
+# the steps after it that need none of the others, when the OG client runs
+# shell commands.
+_max_parallel_steps = 1
+
+
+def set_max_parallel_steps(n: int) -> None:
+    global _max_parallel_steps
+    _max_parallel_steps = max(n, 1)
+
 
 def create_audited_sessioned_proxy(
     name: str,
@@ -19,6 +83,8 @@ def create_audited_sessioned_proxy(
     auditor: ToolCallingAgent,
     emit: _EmitterCallable,
     output_threshold_bytes: int,
+    summarize_above_bytes: int = 0,
+    trust_level: str = "default",
 ) -> ProxyTool:
     """
     Factory function to create a ProxyTool instance configured with agent
@@ -36,6 +102,138 @@ def create_audited_sessioned_proxy(
             or "an unknown action"
         )
 
+    def _run_step_hooks(kind: str, step: dict) -> Optional[str]:
+        """Has the OG client run its hooks of kind ("pre_step" or "post_step")
+        for step, when it runs hooks. Returns why the session ends, when a
+        hook failed and ends it, else None."""
+        if not _step_hooks:
+            return None
+        emit(kind, step)
+        line = read_line()
+        if not line:
+            return "no response from the OG client"
+        try:
+            resp = json.loads(line)
+        except json.JSONDecodeError:
+            return f"invalid hook_result from the OG client: {line.strip()}"
+        if resp.get("proceed"):
+            return None
+        return resp.get("reason") or "a step hook failed"
+
+    def _post_step(result_msg: dict, step_id: int) -> dict:
+        """The post_step message of the step whose result is result_msg."""
+        keys = ("tool", "action", "status", "exit_code", "duration_ms", "step")
+        post = {k: result_msg[k] for k in keys if k in result_msg}
+        post["step_id"] = step_id
+        return post
+
+    def _skip_unmet_condition(step_idx: Optional[int]) -> Optional[str]:
+        """Asks the OG client whether the condition of the recipe step at
+        step_idx holds, once per step. Returns the message for the executor
+        if the step is skipped, else None."""
+        if step_idx is None:
+            return None
+        condition = session.current_recipe[step_idx].get("condition")
+        if not condition:
+            return None
+        if step_idx not in session.condition_results:
+            emit("check_condition", {"step": step_idx + 1, "condition": condition})
+            met, reason = False, "no response from the OG client"
+            line = read_line()
+            if line:
+                try:
+                    resp = json.loads(line)
+                    met, reason = bool(resp.get("met")), resp.get("reason", "")
+                except json.JSONDecodeError:
+                    reason = f"invalid condition_result from the OG client: {line.strip()}"
+            session.set_condition_result(step_idx, met, reason)
+        met, reason = session.condition_results[step_idx]
+        if met:
+            return None
+        return (
+            f"[SKIPPED] Step {step_idx + 1} only runs if {condition}, which does not hold "
+            f"({reason}). It was not run; continue with the next step of the recipe."
+        )
+
+    def _refuse_loop_iteration(step_idx: Optional[int], action_str: str) -> Optional[str]:
+        """Asks the OG client for another iteration of the loop step at
+        step_idx, when action_str begins one. Returns the message for the
+        executor if the loop reached its limit, else None."""
+        if step_idx is None:
+            return None
+        step = session.current_recipe[step_idx]
+        loop = step.get("loop")
+        first_command = step.get("action", "").strip().split("\n")[0]
+        if not loop or not matches_template(first_command, action_str):
+            return None
+        emit("check_iteration", {"step": step_idx + 1, "loop": loop, "action": action_str})
+        allowed, iteration, limit = False, 0, 0
+        reason = "no response from the OG client"
+        line = read_line()
+        if line:
+            try:
+                resp = json.loads(line)
+                allowed = bool(resp.get("allowed"))
+                iteration, limit = int(resp.get("iteration", 0)), int(resp.get("limit", 0))
+                reason = resp.get("reason", "")
+            except (json.JSONDecodeError, TypeError, ValueError):
+                reason = f"invalid iteration_result from the OG client: {line.strip()}"
+        session.set_loop_iteration(step_idx, iteration, limit, allowed)
+        if allowed:
+            return None
+        return (
+            f"[LOOP LIMIT] Step {step_idx + 1} ({loop}) may not run again: {reason}. "
+            "It was not run; stop repeating it and continue with the next step of the recipe."
+        )
+
+    def _skip_without_inputs(step_idx: Optional[int], action_str: str) -> Optional[str]:
+        """Asks the OG client for the values of the inputs of the recipe step
+        at step_idx that action_str uses. Returns the message for the executor
+        if the step is skipped for want of one, else None."""
+        if step_idx is None:
+            return None
+        for spec in session.current_recipe[step_idx].get("inputs", []):
+            name = spec.get("name", "")
+            if "{" + name + "}" not in action_str:
+                continue
+            emit("request_input", {"step": step_idx + 1, "input": spec})
+            provided, value, reason = False, "", "no response from the OG client"
+            line = read_line()
+            if line:
+                try:
+                    resp = json.loads(line)
+                    provided, value = bool(resp.get("provided")), str(resp.get("value", ""))
+                    reason = resp.get("reason", "")
+                except json.JSONDecodeError:
+                    reason = f"invalid input_result from the OG client: {line.strip()}"
+            session.set_input_value(step_idx, name, value if provided else None)
+            if not provided:
+                return (
+                    f"[NO INPUT] Step {step_idx + 1} needs {{{name}}} from the user: {reason}. "
+                    "It was not run; continue with the next step of the recipe."
+                )
+        return None
+
+    def _ask_after_failure(
+        step_idx: int, tool: str, action_str: str, exit_code: Optional[int]
+    ) -> str:
+        """Asks the OG client what to do about the recipe step at step_idx,
+        which failed. Returns the type of its answer, or "" when it gave none."""
+        failed = {"step": step_idx + 1, "tool": tool, "action": action_str}
+        if exit_code is not None:
+            failed["exit_code"] = exit_code
+        emit("step_failed", failed)
+        line = read_line()
+        if not line:
+            return ""
+        try:
+            choice = json.loads(line).get("type", "")
+        except (json.JSONDecodeError, AttributeError):
+            return ""
+        if choice not in ("step_retry", "step_skip", "step_abort"):
+            return ""
+        return choice
+
     def _around_hook(
         proxy_instance: ProxyTool, proceed_callable: Callable, *args, **kwargs
     ) -> Any:
@@ -43,37 +241,60 @@ def create_audited_sessioned_proxy(
         [Around Cut] - This hook encapsulates all the logic for auditing, user approval, execution.
         """
         action_str = _get_action_string(*args, **kwargs)
+
+        # 0. Steps whose condition does not hold are skipped, without an audit
+        step_idx = session.find_recipe_step(proxy_instance.name, action_str)
+        skipped = _skip_unmet_condition(step_idx)
+        if skipped:
+            return skipped
+        if step_idx is not None:
+            session.leave_loop(step_idx)
+        refused = _refuse_loop_iteration(step_idx, action_str)
+        if refused:
+            return refused
+        missing = _skip_without_inputs(step_idx, action_str)
+        if missing:
+            return missing
+        filled = session.fill_inputs(action_str)
+        if filled != action_str:
+            args = tuple(session.fill_inputs(a) if isinstance(a, str) else a for a in args)
+            kwargs = {
+                k: session.fill_inputs(v) if isinstance(v, str) else v
+                for k, v in kwargs.items()
+            }
+            action_str = filled
+
         context = session.get_execution_context()
 
         # 1. Always perform a security audit using the Auditor Agent
-        audit_res = audit_request(auditor, action_str, context)
+        with speaking_as("auditor"):
+            audit_res = audit_request(auditor, action_str, context)
 
-        if audit_res.get("log_message"):
-            emit(
-                "warn_log",
-                {
-                    "message": audit_res["log_message"],
-                    "location": "executor/create_audited_sessioned_proxy._around_hook",
-                },
-            )
+            if audit_res.get("log_message"):
+                emit(
+                    "warn_log",
+                    {
+                        "message": audit_res["log_message"],
+                        "location": "executor/create_audited_sessioned_proxy._around_hook",
+                    },
+                )
 
+        overridden = False
         if not audit_res.get("safe", False):
             if not session.deviation_occurred:
                 session.set_deviation_occurred(True)
-            emit(
-                "unsafe",
-                {
-                    "reason": audit_res.get(
-                        "reason", "Action deemed unsafe by auditor"
-                    ),
-                    "explanation": audit_res.get("explanation", context or action_str),
-                },
-            )
-            emit(
-                "deny_current_action",
-                {"message": "Action was deemed unsafe by auditor."},
+            overridden = report_unsafe(
+                audit_res.get("reason", "Action deemed unsafe by auditor"),
+                audit_res.get("explanation", context or action_str),
+                action_str,
+                proxy_instance.name,
             )
-            return None
+            if not overridden:
+                emit(
+                    "deny_current_action",
+                    {"message": "Action was deemed unsafe by auditor."},
+                )
+                return None
 
         # 2. Determine if user approval is required for this specific action
         should_request_approval = True
@@ -85,10 +306,7 @@ def create_audited_sessioned_proxy(
             if proxy_instance.name == expected_step.get("tool", ""):
                 expected_subcommand = session.get_expected_subcommand()
 
-                if (
-                    expected_subcommand is not None
-                    and action_str.strip() == expected_subcommand
-                ):
+                if session.is_expected_action(action_str):
                     is_current_action_expected_by_recipe = True
 
                     if (
@@ -149,6 +367,21 @@ def create_audited_sessioned_proxy(
                 session.set_deviation_occurred(True)
             should_request_approval = True
 
+        # In untrusted workspaces nothing is auto-approved, even pre-approved recipe steps
+        if trust_level == "untrusted" and not should_request_approval:
+            emit(
+                "info_log",
+                {
+                    "message": f"Workspace is untrusted; requesting explicit approval for '{action_str}'.",
+                    "location": "executor/create_audited_sessioned_proxy._around_hook",
+                },
+            )
+            should_request_approval = True
+
+        # The user confirmed the action by typing it when overriding the auditor
+        if overridden:
+            should_request_approval = False
+
         # --- If approval is still required, interact with user ---
         if should_request_approval:
             desc = f"{proxy_instance.name} -> {action_str}"
@@ -164,12 +397,13 @@ def create_audited_sessioned_proxy(
 
             resp = {}
             try:
-                resp_line = sys.stdin.readline()
+                resp_line = read_line()
                 if not resp_line:
                     emit(
                         "error",
                         {
                             "message": "Received EOF or empty line from stdin during approval. Go client might have terminated unexpectedly.",
+                            "kind": ERROR_PROTOCOL,
                             "location": "executor/create_audited_sessioned_proxy._around_hook",
                         },
                     )
@@ -184,6 +418,7 @@ def create_audited_sessioned_proxy(
                     "error",
                     {
                         "message": f"Failed to parse approval response from stdin: '{resp_line.strip()}'",
+                        "kind": ERROR_PROTOCOL,
                         "location": "executor/create_audited_sessioned_proxy._around_hook",
                     },
                 )
@@ -197,6 +432,7 @@ def create_audited_sessioned_proxy(
                     "error",
                     {
                         "message": f"Failed to read approval response: {e}",
+                        "kind": ERROR_PROTOCOL,
                         "location": "executor/create_audited_sessioned_proxy._around_hook",
                     },
                 )
@@ -217,118 +453,374 @@ def create_audited_sessioned_proxy(
                 )
                 return None
 
-        # 3. Execute Underlying Tool and Handle Outcome (only if approved or auto-approved)
-        try:
-            res = proceed_callable(*args, **kwargs)
-
-            interpret_message = f"Executed {proxy_instance.name}"
-            status = "success"
-
-            if proxy_instance.name == "shell_tool" and isinstance(res, str):
-                stdout_match = re.search(
-                    r"--- STDOUT ---\n(.*?)(?=\n--- STDERR ---|\n--- Command exited|\Z)",
-                    res,
-                    re.DOTALL,
+        # A pre-approved shell step runs together with the steps after it that
+        # need none of the others
+        if (
+            not should_request_approval
+            and not overridden
+            and is_current_action_expected_by_recipe
+            and proxy_instance.name == "shell_tool"
+            and _max_parallel_steps > 1
+            and go_executor()
+        ):
+            wave = _parallel_wave(step_idx, action_str)
+            if len(wave) > 1:
+                return _run_wave(proxy_instance, proceed_callable, wave)
+
+        # 3. Execute Underlying Tool and Handle Outcome (only if approved or auto-approved).
+        # When a recipe step fails, the user decides whether it runs again, is
+        # skipped or ends the recipe, if the OG client asks them.
+        while True:
+            res, status, exit_code = _execute(
+                proxy_instance, proceed_callable, action_str, step_idx, args, kwargs
+            )
+            if status == "hook_failed":
+                emit(
+                    "deny_current_action",
+                    {"message": f"A step hook failed around '{action_str}'."},
+                )
+                return None
+            # The OG client killed a step that ran too long and the user chose
+            # how to go on; otherwise the step is handled like any failed one
+            choice = {"retry": "step_retry", "abort": "timeout_abort"}.get(
+                take_step_cancel(), ""
+            )
+            if choice == "timeout_abort":
+                emit(
+                    "deny_current_action",
+                    {"message": f"The user aborted '{action_str}' after it ran too long."},
+                )
+                return None
+            if (
+                not choice
+                and status == "failure"
+                and step_idx is not None
+                and _ask_on_failure
+            ):
+                choice = _ask_after_failure(
+                    step_idx, proxy_instance.name, action_str, exit_code
                 )
-                stderr_match = re.search(
-                    r"--- STDERR ---\n(.*?)(?=\n--- Command exited|\Z)", res, re.DOTALL
+            if choice == "step_retry":
+                what = f"step {step_idx + 1}" if step_idx is not None else "the action"
+                session.add_to_history("user", f"Retry {what}: {action_str}")
+                continue
+            if choice == "step_skip":
+                session.skip_recipe_step(step_idx)
+                return (
+                    f"[SKIPPED BY USER] Step {step_idx + 1} failed and the user chose to skip it. "
+                    "Do not run it again; continue with the next step of the recipe."
                 )
-                exit_code_match = re.search(
-                    r"--- Command exited with status: (\d+) ---", res
+            if choice == "step_abort":
+                emit(
+                    "deny_current_action",
+                    {"message": f"The user aborted the recipe after step {step_idx + 1} failed."},
                 )
+                return None
+            if is_current_action_expected_by_recipe and status != "error":
+                session.finish_subcommand()
+            return res
 
-                stdout_content = stdout_match.group(1).strip() if stdout_match else None
-                stderr_content = stderr_match.group(1).strip() if stderr_match else None
-                exit_code = int(exit_code_match.group(1)) if exit_code_match else 0
-
-                if stdout_content and stderr_content:
-                    interpret_message = (
-                        f"Executed {proxy_instance.name} with stdout and stderr"
+    def _parallel_wave(step_idx: int, action_str: str) -> List[Tuple[int, str]]:
+        """The recipe steps that run at the same time as the approved step at
+        step_idx, with their commands: it and the steps after it that need
+        none of the others, up to the first the auditor does not find safe,
+        which runs on its own."""
+        wave = [(step_idx, action_str)]
+        for idx in session.parallel_wave(step_idx, _max_parallel_steps)[1:]:
+            action = session.current_recipe[idx]["action"].strip()
+            with speaking_as("auditor"):
+                audit_res = audit_request(auditor, action, session.get_execution_context())
+            if not audit_res.get("safe", False):
+                break
+            wave.append((idx, action))
+        return wave
+
+    def _run_wave(
+        proxy_instance: ProxyTool,
+        proceed_callable: Callable,
+        wave: List[Tuple[int, str]],
+    ) -> Optional[str]:
+        """Has the OG client run the recipe steps of wave at the same time and
+        reports their results in order. A step that fails is handled as when
+        it runs on its own, except that a retry runs it alone. Returns what the
+        executor is told of the steps, or None when the recipe ended."""
+        base = len(session.executed_actions) + 1
+        commands = []
+        for k, (idx, action) in enumerate(wave):
+            if not _start_step(proxy_instance.name, action, idx, base + k):
+                emit(
+                    "deny_current_action",
+                    {"message": f"A step hook failed around '{action}'."},
+                )
+                return None
+            commands.append({"step": idx + 1, "step_id": base + k, "action": action})
+        emit(
+            "info_log",
+            {
+                "message": f"Running recipe steps {', '.join(str(idx + 1) for idx, _ in wave)} at the same time",
+                "location": "executor/create_audited_sessioned_proxy._run_wave",
+            },
+        )
+        outputs = run_commands_in_client(commands)
+        take_step_cancel()  # Steps that ran too long were killed; no one was asked
+
+        # All of the results are reported before any failure is dealt with,
+        # as the steps have all run
+        finished = []
+        for k, ((idx, action), (res, binary, duration_ms)) in enumerate(zip(wave, outputs)):
+            status, exit_code = _finish_step(
+                proxy_instance.name, res, binary, action, idx, base + k, duration_ms
+            )
+            if status == "hook_failed":
+                emit(
+                    "deny_current_action",
+                    {"message": f"A step hook failed around '{action}'."},
+                )
+                return None
+            session.finish_subcommand()
+            finished.append((res, status, exit_code))
+
+        reports = [
+            f"[RAN TOGETHER] Recipe steps {', '.join(str(idx + 1) for idx, _ in wave)} "
+            "ran at the same time. Do not run them again; continue with the next step of the recipe."
+        ]
+        for (idx, action), (res, status, exit_code) in zip(wave, finished):
+            while status == "failure" and _ask_on_failure:
+                choice = _ask_after_failure(idx, proxy_instance.name, action, exit_code)
+                if choice == "step_retry":
+                    session.add_to_history("user", f"Retry step {idx + 1}: {action}")
+                    res, status, exit_code = _execute(
+                        proxy_instance, proceed_callable, action, idx, (), {"command": action}
                     )
-                elif stdout_content:
-                    interpret_message = f"Executed {proxy_instance.name} with stdout"
-                elif stderr_content:
-                    interpret_message = f"Executed {proxy_instance.name} with stderr"
-                else:
-                    interpret_message = f"Executed {proxy_instance.name}"
-
-                if exit_code != 0:
-                    status = "failure"
-                    interpret_message += f" (Exit code: {exit_code})"
-
-                if res.strip() == "[Command executed with no output]":
-                    interpret_message += " (no output)"
-                    status = "success"
+                    if status == "hook_failed":
+                        emit(
+                            "deny_current_action",
+                            {"message": f"A step hook failed around '{action}'."},
+                        )
+                        return None
+                    continue
+                if choice == "step_skip":
+                    res = f"[SKIPPED BY USER] Step {idx + 1} failed and the user chose to skip it.\n{res}"
+                elif choice == "step_abort":
+                    emit(
+                        "deny_current_action",
+                        {"message": f"The user aborted the recipe after step {idx + 1} failed."},
+                    )
+                    return None
+                break
+            reports.append(f"--- Step {idx + 1}: {action} ---\n{res}")
+        return "\n\n".join(reports)
+
+    def _binary_result(binary: dict, step_id: int, tool_name: str) -> dict:
+        """The "binary" of a result: the output base64-encoded or, when it is
+        longer than output_threshold_bytes and the OG client has not saved it,
+        its head and the file it was saved to."""
+        data = binary["data"]
+        msg = {"size": binary["size"]}
+        if binary.get("file"):
+            msg["file"] = binary["file"]
+        elif output_threshold_bytes > 0 and len(data) > output_threshold_bytes:
+            path = artifacts_dir(session.session_hash) / f"{step_id}_{tool_name}.bin"
+            try:
+                path.parent.mkdir(parents=True, exist_ok=True)
+                path.write_bytes(data)
+                msg["file"] = str(path)
+                data = data[:4096]
+            except OSError as e:
+                emit(
+                    "warn_log",
+                    {
+                        "message": f"Failed to save binary tool output to {path}: {e}. Sending all of it.",
+                        "location": "executor/create_audited_sessioned_proxy._binary_result",
+                    },
+                )
+        msg["data"] = base64.b64encode(data).decode("ascii")
+        return msg
+
+    def _start_step(
+        tool_name: str, action_str: str, step_idx: Optional[int], step_id: int
+    ) -> bool:
+        """Announces the step numbered step_id, once its pre_step hooks let it
+        run. Returns False when a hook ended the session."""
+        started_msg = {
+            "tool": tool_name,
+            "action": action_str,
+            "step_id": step_id,
+        }
+        if step_idx is not None:
+            started_msg["step"] = step_idx + 1
+        if _run_step_hooks("pre_step", started_msg):
+            return False
+        emit("step_started", started_msg)  # The OG client times the step from here
+        return True
+
+    def _finish_step(
+        tool_name: str,
+        res: Any,
+        binary: Optional[dict],
+        action_str: str,
+        step_idx: Optional[int],
+        step_id: int,
+        duration_ms: int,
+    ) -> Tuple[str, Optional[int]]:
+        """Records and reports what the step numbered step_id returned, res,
+        and runs its post_step hooks. Returns its status ("success",
+        "failure", or "hook_failed" when a hook ended the session) and, for
+        shell steps, the exit code."""
+        interpret_message = f"Executed {tool_name}"
+        status = "success"
+        exit_code = None
+
+        if tool_name == "shell_tool" and isinstance(res, str):
+            stdout_match = re.search(
+                r"--- STDOUT ---\n(.*?)(?=\n--- STDERR ---|\n--- Command exited|\Z)",
+                res,
+                re.DOTALL,
+            )
+            stderr_match = re.search(
+                r"--- STDERR ---\n(.*?)(?=\n--- Command exited|\Z)", res, re.DOTALL
+            )
+            exit_code_match = re.search(
+                r"--- Command exited with status: (-?\d+) ---", res
+            )
 
-            result_str = str(res) if res is not None else "completed"
+            stdout_content = stdout_match.group(1).strip() if stdout_match else None
+            stderr_content = stderr_match.group(1).strip() if stderr_match else None
+            exit_code = int(exit_code_match.group(1)) if exit_code_match else 0
 
+            if stdout_content and stderr_content:
+                interpret_message = (
+                    f"Executed {tool_name} with stdout and stderr"
+                )
+            elif stdout_content:
+                interpret_message = f"Executed {tool_name} with stdout"
+            elif stderr_content:
+                interpret_message = f"Executed {tool_name} with stderr"
+            else:
+                interpret_message = f"Executed {tool_name}"
+
+            if exit_code != 0:
+                status = "failure"
+                interpret_message += f" (Exit code: {exit_code})"
+
+            if res.strip() == "[Command executed with no output]":
+                interpret_message += " (no output)"
+                status = "success"
+            elif res.startswith("[ERROR] "):  # The OG client did not run it
+                interpret_message = res[len("[ERROR] ") :]
+                status = "failure"
+
+        result_str = str(res) if res is not None else "completed"
+        shown_str = None  # What the OG client shows, when not result_str
+
+        if (
+            isinstance(res, str)
+            and res.strip()
+            and res.strip() != "[Command executed with no output]"
+        ):
+            output_bytes = res.encode("utf-8")
             if (
-                isinstance(res, str)
-                and res.strip()
-                and res.strip() != "[Command executed with no output]"
+                output_threshold_bytes > 0
+                and len(output_bytes) > output_threshold_bytes
             ):
-                output_bytes = res.encode("utf-8")
-                if (
-                    output_threshold_bytes > 0
-                    and len(output_bytes) > output_threshold_bytes
-                ):
-                    temp_dir_path = Path("/tmp") / "og" / session.session_hash
-                    temp_dir_path.mkdir(parents=True, exist_ok=True)
-
-                    turn_index = len(session.executed_actions)
-                    file_name = (
-                        f"{turn_index + 1}_{proxy_instance.name.replace(' ', '_')}.txt"
+                temp_dir_path = artifacts_dir(session.session_hash)
+                temp_dir_path.mkdir(parents=True, exist_ok=True)
+
+                file_name = f"{step_id}_{tool_name.replace(' ', '_')}.txt"
+                temp_file_path = temp_dir_path / file_name
+
+                try:
+                    temp_file_path.write_bytes(output_bytes)
+                    result_str = (
+                        f"-- out saved to {temp_file_path} because at "
+                        f"{(len(output_bytes) / 1024):.2f} KB, it is too long to include. "
+                        f"Use tools (for example perhaps `grep` or `cat {temp_file_path}`) to find the details that you require --"
                     )
-                    temp_file_path = temp_dir_path / file_name
-
-                    try:
-                        temp_file_path.write_bytes(output_bytes)
-                        result_str = (
-                            f"-- out saved to {temp_file_path} because at "
-                            f"{(len(output_bytes) / 1024):.2f} KB, it is too long to include. "
-                            f"Use tools (for example perhaps `grep` or `cat {temp_file_path}`) to find the details that you require --"
-                        )
-                        emit(
-                            "info_log",
-                            {
-                                "message": f"Tool output saved to temporary file: {temp_file_path}",
-                                "location": "executor/create_audited_sessioned_proxy._around_hook",
-                            },
-                        )
-                    except Exception as file_e:
-                        emit(
-                            "error",
-                            {
-                                "message": f"Failed to save large tool output to {temp_file_path}: {file_e}. Returning full output.",
-                                "location": "executor/create_audited_sessioned_proxy._around_hook",
-                            },
-                        )
-                        result_str = str(res)
-
-            session.add_executed_action(proxy_instance.name, action_str, result_str)
-
-            if is_current_action_expected_by_recipe:
-                session.increment_subcommand_idx()
-
-                expected_step_after_increment = session.get_expected_recipe_step()
-                if expected_step_after_increment:
-                    planned_commands = (
-                        expected_step_after_increment.get("action", "")
-                        .strip()
-                        .split("\n")
+                    # The user sees the head and the tail, rather than only the note
+                    preview = min(4096, max(output_threshold_bytes // 4, 1))
+                    head = output_bytes[:preview].decode("utf-8", errors="ignore")
+                    tail = output_bytes[-preview:].decode("utf-8", errors="ignore")
+                    shown_str = (
+                        f"{head}\n-- {len(output_bytes) - 2 * preview} bytes omitted; "
+                        f"the whole output ({len(output_bytes)} bytes) is in {temp_file_path} --\n{tail}"
                     )
-                    if session.next_expected_subcommand_idx >= len(planned_commands):
-                        session.increment_recipe_step()
+                    emit(
+                        "info_log",
+                        {
+                            "message": f"Tool output saved to temporary file: {temp_file_path}",
+                            "location": "executor/create_audited_sessioned_proxy._around_hook",
+                        },
+                    )
+                except Exception as file_e:
+                    emit(
+                        "warn_log",
+                        {
+                            "message": f"Failed to save large tool output to {temp_file_path}: {file_e}. Returning full output.",
+                            "location": "executor/create_audited_sessioned_proxy._around_hook",
+                        },
+                    )
+                    result_str = str(res)
+            elif (
+                summarize_above_bytes > 0
+                and len(output_bytes) > summarize_above_bytes
+            ):
+                head_tail = summarize_above_bytes // 2
+                head = output_bytes[:head_tail].decode("utf-8", errors="ignore")
+                tail = output_bytes[-head_tail:].decode("utf-8", errors="ignore")
+                omitted = len(output_bytes) - 2 * head_tail
+                result_str = (
+                    f"{head}\n-- {omitted} bytes omitted from the middle of this output --\n{tail}"
+                )
 
-            emit(
-                "result",
-                {
-                    "status": status,
-                    "interpret_message": interpret_message,
-                    "output": result_str,
-                },
+        session.add_executed_action(tool_name, action_str, result_str)
+
+        result_msg = {
+            "status": status,
+            "interpret_message": interpret_message,
+            "output": shown_str or result_str,
+            "tool": tool_name,
+            "action": action_str,
+            "duration_ms": duration_ms,
+        }
+        if exit_code is not None:
+            result_msg["exit_code"] = exit_code
+        if step_idx is not None:
+            result_msg["step"] = step_idx + 1
+        if binary:
+            result_msg["binary"] = _binary_result(binary, step_id, tool_name)
+        emit("result", result_msg)
+        if _run_step_hooks("post_step", _post_step(result_msg, step_id)):
+            return "hook_failed", None
+        return status, exit_code
+
+    def _execute(
+        proxy_instance: ProxyTool,
+        proceed_callable: Callable,
+        action_str: str,
+        step_idx: Optional[int],
+        args: tuple,
+        kwargs: dict,
+    ) -> Tuple[Any, str, Optional[int]]:
+        """Runs the approved action and reports its result. Returns what the
+        tool returned, the status ("success", "failure", "error" when the
+        tool raised, or "hook_failed" when a step hook ended the session)
+        and, for shell steps, the exit code."""
+        # Numbered like the artifacts of the actions; commands the tool runs see it
+        step_id = len(session.executed_actions) + 1
+        os.environ["OG_STEP_ID"] = str(step_id)
+        if not _start_step(proxy_instance.name, action_str, step_idx, step_id):
+            return None, "hook_failed", None
+        started = time.monotonic()
+        try:
+            res = proceed_callable(*args, **kwargs)
+            duration_ms = int((time.monotonic() - started) * 1000)
+            binary = take_binary_output() if proxy_instance.name == "shell_tool" else None
+            status, exit_code = _finish_step(
+                proxy_instance.name, res, binary, action_str, step_idx, step_id, duration_ms
             )
-            return res
+            if status == "hook_failed":
+                return None, status, None
+            return res, status, exit_code
 
         except Exception as e:
             error_msg = f"Tool execution failed: {type(e).__name__}: {e}"
@@ -336,18 +828,28 @@ def create_audited_sessioned_proxy(
                 "error",
                 {
                     "message": error_msg,
+                    "kind": ERROR_TOOL_FAILED,
                     "location": "executor/create_audited_sessioned_proxy._around_hook",
                 },
             )
             session.add_executed_action(
                 proxy_instance.name, action_str, f"ERROR: {error_msg}"
             )
-            emit(
-                "result",
-                {"status": "failure", "interpret_message": error_msg, "output": ""},
-            )
+            failure_msg = {
+                "status": "failure",
+                "interpret_message": error_msg,
+                "output": "",
+                "tool": proxy_instance.name,
+                "action": action_str,
+                "duration_ms": int((time.monotonic() - started) * 1000),
+            }
+            if step_idx is not None:
+                failure_msg["step"] = step_idx + 1
+            emit("result", failure_msg)
             session.set_deviation_occurred(True)
-            return None
+            if _run_step_hooks("post_step", _post_step(failure_msg, step_id)):
+                return None, "hook_failed", None
+            return None, "error", None
 
     underlying_description = getattr(tool, "description", None)
     if not underlying_description:
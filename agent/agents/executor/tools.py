@@ -1,6 +1,129 @@
+import base64
+import json
+import os
 import subprocess
+import threading
+import time
 from pathlib import Path
-from smolagents.tools import tool
+from typing import List, Optional, Tuple
+from smolagents.tools import Tool, tool
+
+from agent.commands import note_step_cancel, read_line, step_cancelled, track_step
+from agent.emitter import emit
+
+# Databases configured in the OG client, by name, with their driver.
+_databases: dict = {}
+
+
+def set_databases(databases: dict) -> None:
+    """Registers the databases sql_query_tool may query and lists them in its description."""
+    _databases.clear()
+    _databases.update(databases)
+    names = ", ".join(f"{name} ({driver})" for name, driver in sorted(databases.items()))
+    sql_query_tool.description = f"{_SQL_QUERY_DESCRIPTION} Configured databases: {names}."
+
+
+def configured_databases() -> dict:
+    return dict(_databases)
+
+
+# Whether shell commands are run by the OG client (general.executor = "go").
+_go_executor = False
+
+
+def set_go_executor(enabled: bool) -> None:
+    global _go_executor
+    _go_executor = enabled
+
+
+def go_executor() -> bool:
+    return _go_executor
+
+
+# Whether the OG client takes outputs that are not text in the "binary" of
+# results, instead of having them decoded as text.
+_binary_outputs = False
+
+# The stdout of the last shell command, when it was not text.
+_last_binary: Optional[dict] = None
+
+# How much of an output _is_binary looks at.
+_BINARY_SNIFF_BYTES = 8 << 10
+
+
+# Whether shell commands run here report their progress with "progress"
+# messages while they run (--progress).
+_progress = False
+
+# Commands that end sooner report no progress; then one report every
+# _PROGRESS_EVERY seconds.
+_PROGRESS_AFTER = 2.0
+_PROGRESS_EVERY = 5.0
+
+
+def set_progress(enabled: bool) -> None:
+    global _progress
+    _progress = enabled
+
+
+def _report_progress() -> Optional[threading.Event]:
+    """Emits "progress" for the running shell step, with how long it has run,
+    until the returned event is set; None when progress is not reported."""
+    if not _progress:
+        return None
+    done = threading.Event()
+    started = time.monotonic()
+    step_id = int(os.environ.get("OG_STEP_ID") or 0)
+
+    def report():
+        wait = _PROGRESS_AFTER
+        while not done.wait(wait):
+            msg = {
+                "tool": "shell_tool",
+                "duration_ms": int((time.monotonic() - started) * 1000),
+            }
+            if step_id:
+                msg["step_id"] = step_id
+            emit("progress", msg)
+            wait = _PROGRESS_EVERY
+
+    threading.Thread(target=report, daemon=True).start()
+    return done
+
+
+def set_binary_outputs(enabled: bool) -> None:
+    global _binary_outputs
+    _binary_outputs = enabled
+
+
+def take_binary_output() -> Optional[dict]:
+    """The stdout of the last shell command when it was not text, with its
+    "data", "size" and, when the OG client saved it, "file"; None otherwise.
+    It is only returned once."""
+    global _last_binary
+    binary, _last_binary = _last_binary, None
+    return binary
+
+
+def _is_binary(output: bytes) -> bool:
+    """Whether output, or its head, is not text: it has NUL bytes or is not UTF-8."""
+    head = output[:_BINARY_SNIFF_BYTES]
+    if b"\0" in head:
+        return True
+    # A character cut at the end of the head does not make it binary
+    for cut in range(4):
+        try:
+            head[: max(len(head) - cut, 0)].decode("utf-8")
+            return False
+        except UnicodeDecodeError:
+            pass
+    return True
+
+
+def _binary_placeholder(size: int, file: str = "") -> str:
+    if file:
+        return f"[binary output of {size} bytes, not shown; it is in {file}]"
+    return f"[binary output of {size} bytes, not shown]"
 
 
 @tool
@@ -17,32 +140,127 @@ def shell_tool(command: str) -> str:
         If the command has no output, it returns a placeholder message.
         If the command exits with a non-zero status, this is also noted.
     """
-    result = subprocess.run(
+    global _last_binary
+    _last_binary = None
+    if _go_executor:
+        return _run_in_client(command)
+    # In a process group of its own, so that the OG client can have the step
+    # killed with what it started when it runs too long (cancel_step)
+    proc = subprocess.Popen(
         command,
         shell=True,
-        capture_output=True,
-        text=True,
-        check=False,  # Do not raise CalledProcessError on non-zero exit codes,
-        # instead capture and report the returncode.
+        stdout=subprocess.PIPE,
+        stderr=subprocess.PIPE,
+        text=not _binary_outputs,
+        start_new_session=True,
+        creationflags=getattr(subprocess, "CREATE_NEW_PROCESS_GROUP", 0),
+    )
+    track_step(proc)
+    reporting = _report_progress()
+    try:
+        stdout, stderr = proc.communicate()
+    finally:
+        track_step(None)
+        if reporting:
+            reporting.set()
+    if _binary_outputs:
+        if _is_binary(stdout):
+            _last_binary = {"data": stdout, "size": len(stdout)}
+            stdout = _binary_placeholder(len(stdout))
+        else:
+            stdout = stdout.decode("utf-8", errors="replace")
+        stderr = stderr.decode("utf-8", errors="replace")
+    output = _format_output(stdout, stderr, proc.returncode)
+    if step_cancelled():
+        output += "\n--- Command was killed for exceeding its time limit ---"
+    return output
+
+
+def _run_in_client(command: str) -> str:
+    """Has the OG client run command and formats its command_result like the
+    output of a command run here."""
+    emit("run_command", {"action": command})
+    line = read_line()
+    if not line:
+        return "[ERROR] No response from the OG client; the command was not run."
+    try:
+        resp = json.loads(line)
+    except json.JSONDecodeError:
+        return f"[ERROR] Invalid command_result from the OG client: {line.strip()}"
+    return _client_output(resp)
+
+
+def run_commands_in_client(
+    commands: List[dict],
+) -> List[Tuple[str, Optional[dict], int]]:
+    """Has the OG client run the commands of independent recipe steps at the
+    same time (run_commands), each a dict with its "step", "step_id" and
+    "action". Returns, in the order of commands, the output of each like that
+    of shell_tool, its binary output, as take_binary_output would, and how
+    many milliseconds it ran."""
+    emit("run_commands", {"commands": commands})
+    line = read_line()
+    failed = "[ERROR] No response from the OG client; the command was not run."
+    results = []
+    if line:
+        try:
+            resp = json.loads(line)
+            results = resp.get("results") or []
+            if resp.get("error"):
+                failed = f"[ERROR] {resp['error']}"
+        except (json.JSONDecodeError, AttributeError):
+            failed = f"[ERROR] Invalid command_results from the OG client: {line.strip()}"
+    outputs = []
+    for i in range(len(commands)):
+        if i < len(results) and isinstance(results[i], dict):
+            output = _client_output(results[i])
+            outputs.append((output, take_binary_output(), results[i].get("duration_ms", 0)))
+        else:
+            outputs.append((failed, None, 0))
+    return outputs
+
+
+def _client_output(resp: dict) -> str:
+    """Formats a command_result of the OG client like the output of a command
+    run here, keeping its binary output for take_binary_output."""
+    global _last_binary
+    if resp.get("error"):
+        return f"[ERROR] {resp['error']}"
+    if resp.get("binary"):
+        binary = resp["binary"]
+        _last_binary = {
+            "data": base64.b64decode(binary.get("data", "")),
+            "size": binary.get("size", 0),
+        }
+        if binary.get("file"):
+            _last_binary["file"] = binary["file"]
+    output = _format_output(
+        resp.get("stdout", ""), resp.get("stderr", ""), resp.get("exit_code", 0)
     )
+    if resp.get("timed_out"):
+        output += "\n--- Command was killed for exceeding its time limit ---"
+        note_step_cancel(resp.get("then") or "")
+    return output
+
 
+def _format_output(stdout: str, stderr: str, returncode: int) -> str:
     combined_output_parts = []
 
-    if result.stdout:
+    if stdout:
         combined_output_parts.append("--- STDOUT ---")
-        combined_output_parts.append(result.stdout.strip())
+        combined_output_parts.append(stdout.strip())
 
-    if result.stderr:
+    if stderr:
         # Only add STDERR header if there's actual stderr content
         # unless STDOUT was also empty, then always show it.
-        if result.stdout or result.stderr.strip():
+        if stdout or stderr.strip():
             combined_output_parts.append("--- STDERR ---")
-            combined_output_parts.append(result.stderr.strip())
+            combined_output_parts.append(stderr.strip())
 
     # Add exit code if it's not 0
-    if result.returncode != 0:
+    if returncode != 0:
         combined_output_parts.append(
-            f"--- Command exited with status: {result.returncode} ---"
+            f"--- Command exited with status: {returncode} ---"
         )
 
     # If no output at all (neither stdout, stderr, nor non-zero exit code indicator)
@@ -70,3 +288,144 @@ def file_content_tool(path: str) -> str:
         return p.read_text()
     except Exception as e:
         return f"[ERROR] {e}"
+
+
+@tool
+def patch_tool(patch: str, description: str) -> str:
+    """
+    Proposes changes to one or more files as a unified diff. The user is shown a
+    colorized preview, and the OG client applies the diff only if they accept it.
+    Paths in the diff must be relative to the working directory.
+
+    Args:
+        patch: A unified diff (`--- a/path`, `+++ b/path`, `@@` hunks). Use /dev/null as the old path for new files.
+        description: A one-line description of the change.
+
+    Returns:
+        A message saying whether the patch was applied, or why not. For multi-file
+        patches the user may approve only some of the files.
+    """
+    emit("proposed_patch", {"patch": patch, "description": description})
+    line = read_line()
+    if not line:
+        return "[ERROR] No response from the OG client; the patch was not applied."
+    try:
+        resp = json.loads(line)
+    except json.JSONDecodeError:
+        return f"[ERROR] Invalid patch_result from the OG client: {line.strip()}"
+    if resp.get("applied"):
+        applied = f"Patch applied to: {', '.join(resp.get('files', []))}"
+        if resp.get("skipped"):
+            applied += f". The user excluded these files, which were left unchanged: {', '.join(resp['skipped'])}"
+        if resp.get("edited"):
+            applied += f". The user then edited these files by hand; read them again before changing them further: {', '.join(resp['edited'])}"
+        return applied
+    return f"Patch not applied: {resp.get('error', 'unknown reason')}"
+
+
+@tool
+def sql_query_tool(database: str, query: str) -> str:
+    """
+    Runs a SQL query against a database configured in OG and returns the rows as a
+    table. Queries run in a read-only transaction with a row limit unless the
+    database is configured to allow writes. Prefer this over database CLIs in shell_tool.
+
+    Args:
+        database: The name of a configured database.
+        query: A single SQL statement in the database's dialect.
+
+    Returns:
+        The result rows as a text table, noting when rows were cut off by the limit,
+        or an error message.
+    """
+    emit("sql_query", {"database": database, "query": query})
+    line = read_line()
+    if not line:
+        return "[ERROR] No response from the OG client; the query was not run."
+    try:
+        resp = json.loads(line)
+    except json.JSONDecodeError:
+        return f"[ERROR] Invalid sql_result from the OG client: {line.strip()}"
+    if resp.get("ok"):
+        return resp.get("output", "")
+    return f"[ERROR] {resp.get('error', 'query failed')}"
+
+
+_SQL_QUERY_DESCRIPTION = sql_query_tool.description
+
+
+# JSON Schema types as smolagents names them in a tool's inputs
+_SCHEMA_TYPES = {
+    "string": "string",
+    "integer": "integer",
+    "number": "number",
+    "boolean": "boolean",
+    "array": "array",
+    "object": "object",
+    "null": "null",
+}
+
+_mcp_tools: list = []
+
+
+class MCPTool(Tool):
+    """A tool of an MCP server or Go plugin configured in OG ([mcp_servers],
+    [plugins]). OG calls it after its own approval step: the call is sent as
+    mcp_call and answered with mcp_result."""
+
+    output_type = "string"
+    skip_forward_signature_validation = True
+
+    def __init__(self, spec: dict):
+        self.name = spec["name"]
+        self.description = (
+            spec.get("description") or f"Tool {spec['tool']} of MCP server {spec['server']}."
+        )
+        self.inputs = _mcp_inputs(spec.get("input_schema") or {})
+        super().__init__()
+
+    def forward(self, **kwargs) -> str:
+        arguments = {k: v for k, v in kwargs.items() if v is not None}
+        emit("mcp_call", {"tool": self.name, "arguments": arguments})
+        line = read_line()
+        if not line:
+            return "[ERROR] No response from the OG client; the tool was not called."
+        try:
+            resp = json.loads(line)
+        except json.JSONDecodeError:
+            return f"[ERROR] Invalid mcp_result from the OG client: {line.strip()}"
+        if resp.get("ok"):
+            return resp.get("output", "")
+        output = resp.get("output", "")
+        error = f"[ERROR] {resp.get('error', 'the tool failed')}"
+        return f"{error}\n{output}" if output and output not in error else error
+
+
+def _mcp_inputs(schema: dict) -> dict:
+    """Describes the properties of a tool's JSON Schema as smolagents inputs;
+    those the schema does not require are nullable."""
+    required = set(schema.get("required", []))
+    inputs = {}
+    for name, prop in (schema.get("properties") or {}).items():
+        kind = prop.get("type", "any")
+        if isinstance(kind, list):
+            kinds = [k for k in kind if k != "null"]
+            kind = kinds[0] if len(kinds) == 1 else "any"
+        entry = {
+            "type": _SCHEMA_TYPES.get(kind, "any"),
+            "description": prop.get("description") or name,
+        }
+        if name not in required:
+            entry["nullable"] = True
+        inputs[name] = entry
+    return inputs
+
+
+def set_mcp_tools(tools: list) -> None:
+    """Registers the MCP tools OG sent with the mcp_tools command."""
+    _mcp_tools.clear()
+    _mcp_tools.extend(MCPTool(spec) for spec in tools)
+
+
+def mcp_tools() -> list:
+    return list(_mcp_tools)
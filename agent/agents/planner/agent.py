@@ -1,15 +1,16 @@
-from smolagents import CodeAgent, LiteLLMModel
+from smolagents import CodeAgent
 from smolagents.monitoring import LogLevel as SmolAgentLogLevel
 from typing import Dict
 
 from agent.common_tools.tools import get_common_tools
 from agent.log_levels import LogLevel
+from agent.token_usage import AccountedLiteLLMModel
 
 
 def factory_planner_agent(
     model_id: str, model_params: Dict, python_log_level: LogLevel
 ) -> CodeAgent:
-    planner_model = LiteLLMModel(model_id=model_id, **model_params)
+    planner_model = AccountedLiteLLMModel("planner", model_id, **model_params)
 
     # Configure smolagents' internal logging
     smolagents_verbosity_level = (
@@ -0,0 +1,179 @@
+"""
+Commands from the OG client, read from stdin or, with the socket transport,
+the control channel (see transport.py).
+
+A reader thread reads the commands so that a `cancel` command is noticed while
+the agent is busy planning, waiting for a model or running a tool, and not only
+when it waits for a command. Cancelling interrupts the main thread, and the
+commands it runs, like a Ctrl-C; every other line is handed to read_line in
+order.
+
+A `cancel_step` command kills only the shell step that is running, when the OG
+client times it out; its "then" says how the step's caller goes on, see
+take_step_cancel.
+"""
+
+import _thread
+import json
+import os
+import queue
+import signal
+import subprocess
+import sys
+import threading
+from typing import Optional
+
+from agent.emitter import emit
+
+_lines: "queue.Queue[str]" = queue.Queue()
+_cancel_requested = threading.Event()
+_reader: Optional[threading.Thread] = None
+
+_step_lock = threading.Lock()
+_step_proc: "Optional[subprocess.Popen]" = None
+_step_cancel: Optional[str] = None
+
+
+def start_reader(fd: Optional[int] = None) -> None:
+    """Start reading stdin, or the file descriptor fd, in the background. Until
+    it is called, read_line reads stdin directly."""
+    global _reader
+    if _reader is None:
+        if fd is None:
+            fd = sys.stdin.fileno()
+        _reader = threading.Thread(
+            target=_read_commands, args=(fd,), name="og-commands", daemon=True
+        )
+        _reader.start()
+
+
+def read_line() -> str:
+    """Return the next line sent by the OG client, or "" once stdin is closed."""
+    if _reader is None:
+        return sys.stdin.readline()
+    while True:
+        # A timeout keeps the wait interruptible on every platform
+        try:
+            return _lines.get(timeout=0.5)
+        except queue.Empty:
+            continue
+
+
+def cancel_requested() -> bool:
+    """Whether the OG client asked to cancel the session."""
+    return _cancel_requested.is_set()
+
+
+def track_step(proc: "Optional[subprocess.Popen]") -> None:
+    """Register the process of the shell step that is running, started in its
+    own process group, or None once it ended. cancel_step and cancel kill it."""
+    global _step_proc, _step_cancel
+    with _step_lock:
+        if proc is not None:
+            _step_cancel = None
+        _step_proc = proc
+
+
+def note_step_cancel(then: str) -> None:
+    """Record that the OG client killed the last step, for steps it ran itself."""
+    global _step_cancel
+    with _step_lock:
+        _step_cancel = then
+
+
+def step_cancelled() -> bool:
+    """Whether the OG client killed the last step for running too long."""
+    with _step_lock:
+        return _step_cancel is not None
+
+
+def take_step_cancel() -> str:
+    """Return how to go on after the OG client killed the last step: "retry",
+    "abort", or "" to go on as after any failed step, which is also the answer
+    when it was not killed. The answer is given once."""
+    global _step_cancel
+    with _step_lock:
+        then, _step_cancel = _step_cancel or "", None
+    return then
+
+
+def _read_commands(fd: int) -> None:
+    # Unbuffered reads of the file descriptor, since a daemon thread blocked
+    # inside sys.stdin's buffer would hold its lock at interpreter shutdown
+    pending = b""
+    while True:
+        try:
+            chunk = os.read(fd, 65536)
+        except OSError:
+            chunk = b""
+        if not chunk:
+            if pending:
+                _dispatch(pending.decode("utf-8", errors="replace"))
+            _lines.put("")
+            return
+        pending += chunk
+        while b"\n" in pending:
+            line, pending = pending.split(b"\n", 1)
+            _dispatch(line.decode("utf-8", errors="replace") + "\n")
+
+
+def _dispatch(line: str) -> None:
+    try:
+        command = json.loads(line)
+    except json.JSONDecodeError:
+        command = None
+    if isinstance(command, dict) and command.get("type") == "cancel":
+        _cancel()
+        return
+    if isinstance(command, dict) and command.get("type") == "cancel_step":
+        _cancel_step(str(command.get("then") or ""))
+        return
+    _lines.put(line)
+
+
+def _cancel_step(then: str) -> None:
+    global _step_cancel
+    with _step_lock:
+        if _step_proc is None or _step_proc.poll() is not None:
+            return  # The step ended before the command arrived
+        _step_cancel = then
+        _kill_step(signal.SIGKILL if os.name != "nt" else None)
+
+
+def _kill_step(sig: Optional[int]) -> None:
+    """Send sig to the process group of the running step; on Windows, end its
+    process tree. The caller holds _step_lock."""
+    if _step_proc is None:
+        return
+    try:
+        if os.name == "nt":
+            subprocess.run(
+                ["taskkill", "/T", "/F", "/PID", str(_step_proc.pid)],
+                capture_output=True,
+                check=False,
+            )
+        else:
+            os.killpg(_step_proc.pid, sig)
+    except (OSError, subprocess.SubprocessError):
+        pass
+
+
+def _cancel() -> None:
+    if _cancel_requested.is_set():
+        return
+    _cancel_requested.set()
+    emit(
+        "cancelling",
+        {"message": "Stopping the current step and saving the session."},
+    )
+    with _step_lock:
+        # The running step has a process group of its own
+        _kill_step(signal.SIGINT if os.name != "nt" else None)
+    if os.name == "nt":
+        _thread.interrupt_main()
+    elif os.getpgrp() == os.getpid():
+        # The OG client runs the agent in its own process group, with the
+        # commands it starts: interrupt them all
+        os.killpg(os.getpgrp(), signal.SIGINT)
+    else:
+        os.kill(os.getpid(), signal.SIGINT)
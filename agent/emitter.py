@@ -1,10 +1,41 @@
 import json
-from typing import Any, Callable, Dict
+import sys
+import threading
+import time
+from contextlib import contextmanager
+from typing import Any, Callable, Dict, Optional
 from agent.log_levels import LogLevel
+from agent.redact import redact_obj
+
+# Version of the stdin/stdout protocol spoken with the OG client. Bump it when
+# messages or commands change incompatibly; the client compares it to its own.
+PROTOCOL_VERSION = 28
 
 # This global variable will store the Python agent's configured log level.
 _python_log_level: LogLevel = LogLevel.INFO
 
+# Optional path of a file that receives every emitted message, regardless of log level.
+_agent_log_file: Optional[str] = None
+
+# Messages are emitted from the stdin reader thread too (see commands.py); the
+# lock keeps each one on a line of its own.
+_emit_lock = threading.Lock()
+
+# With length framing, the binary stdout that messages are written to; None
+# for NDJSON lines. See set_framing.
+_framed_stdout = None
+
+# With the socket transport, the connections messages are written to, log
+# messages on a channel of their own; None on stdout. See use_channels.
+_control_channel = None
+_log_channel = None
+
+_LOG_TYPES = ("debug_log", "info_log", "warn_log")
+
+# The agent role (planner, executor, auditor) whose work is under way; messages
+# carry it as "role" so the client can show who said what. See speaking_as.
+_role: str = ""
+
 
 def set_python_log_level(level_str: str):
     """Sets the Python agent's internal log level based on string input."""
@@ -17,10 +48,74 @@ def set_python_log_level(level_str: str):
         _python_log_level = LogLevel.INFO
 
 
+def set_agent_log_file(path: Optional[str]):
+    """Sets the file that all emitted messages are appended to (None disables it)."""
+    global _agent_log_file
+    _agent_log_file = path or None
+
+
+def set_framing(mode: str):
+    """Switches stdout to the framing the OG client offered. With "length", each
+    message is written as a line with its length in bytes, the JSON and a newline,
+    so messages of any size reach the client; anything else printed to stdout
+    goes to stderr instead, where it cannot break the framing."""
+    global _framed_stdout
+    if mode != "length":
+        return
+    print(json.dumps({"type": "framing", "mode": "length"}), flush=True)
+    _framed_stdout = sys.stdout.buffer
+    sys.stdout = sys.stderr
+
+
+def use_channels(control, log):
+    """Writes messages, length-framed, to the binary files of the socket
+    transport's control and log channels instead of stdout (see transport.py)."""
+    global _control_channel, _log_channel
+    _control_channel, _log_channel = control, log
+
+
+@contextmanager
+def speaking_as(role: str):
+    """Attributes the messages emitted in the block to an agent role. Blocks
+    nest: the auditor checking an executor's action speaks for the auditor, and
+    the executor again after it."""
+    global _role
+    outer, _role = _role, role
+    try:
+        yield
+    finally:
+        _role = outer
+
+
+def _write(payload: dict):
+    data = json.dumps(payload)
+    out = _framed_stdout
+    if _control_channel is not None:
+        out = _log_channel if payload["type"] in _LOG_TYPES else _control_channel
+    if out is None:
+        print(data, flush=True)
+        return
+    encoded = data.encode("utf-8")
+    out.write(b"%d\n%s\n" % (len(encoded), encoded))
+    out.flush()
+
+
+def _append_to_agent_log(payload: dict):
+    """Appends a message to the agent log file; failures never affect the protocol."""
+    if not _agent_log_file:
+        return
+    try:
+        with open(_agent_log_file, "a", encoding="utf-8") as f:
+            f.write(json.dumps({"ts": time.time(), **redact_obj(payload)}) + "\n")
+    except OSError:
+        pass
+
+
 def emit(msg_type: str, data: dict):
     """
-    Emits a structured message to stdout as NDJSON.
+    Emits a structured message to stdout, as NDJSON or length-framed (see set_framing).
     Filters certain log message types based on the configured Python log level.
+    Messages emitted within speaking_as carry the agent role's name as "role".
     """
     # Map Python log types to LogLevel for filtering
     log_type_map = {
@@ -29,16 +124,47 @@ def emit(msg_type: str, data: dict):
         "warn_log": LogLevel.WARN,
     }
 
-    # If it's a categorized log message, check against the current Python log level
-    if msg_type in log_type_map:
-        if log_type_map[msg_type] >= _python_log_level:
+    if _role and "role" not in data:
+        data = {**data, "role": _role}
+
+    with _emit_lock:
+        _append_to_agent_log({"type": msg_type, **data})
+
+        # If it's a categorized log message, check against the current Python log level
+        if msg_type in log_type_map:
+            if log_type_map[msg_type] >= _python_log_level:
+                payload = {"type": msg_type, **data}
+                _write(payload)
+        else:
+            # Core messages (error, unsafe, plan, result etc.) always emit regardless of Python log level.
+            # Go client handles final filtering/display for these.
             payload = {"type": msg_type, **data}
-            print(json.dumps(payload), flush=True)
-    else:
-        # Core messages (error, unsafe, plan, result etc.) always emit regardless of Python log level.
-        # Go client handles final filtering/display for these.
-        payload = {"type": msg_type, **data}
-        print(json.dumps(payload), flush=True)
+            _write(payload)
 
 
 _EmitterCallable = Callable[[str, Dict[str, Any]], None]
+
+# Kinds of `error` messages. The client maps them to distinct console treatments,
+# history statuses and exit codes.
+ERROR_MODEL_UNREACHABLE = "model_unreachable"  # A model call failed, after any retries
+ERROR_TOOL_FAILED = "tool_failed"  # A tool raised instead of returning a result
+ERROR_PROTOCOL = "protocol"  # A command from the client could not be understood
+ERROR_ABORTED = "aborted"  # The user interrupted the agent
+ERROR_INTERNAL = "internal"  # Anything else
+
+
+def error_kind(error: BaseException) -> str:
+    """Classifies an exception for an `error` message. Exceptions are marked with
+    an `og_error_kind` attribute where they are raised; wrappers such as
+    smolagents' AgentGenerationError are looked through via their causes."""
+    seen = set()
+    e: Optional[BaseException] = error
+    while e is not None and id(e) not in seen:
+        seen.add(id(e))
+        if isinstance(e, KeyboardInterrupt):
+            return ERROR_ABORTED
+        kind = getattr(e, "og_error_kind", None)
+        if kind:
+            return kind
+        e = e.__cause__ or e.__context__
+    return ERROR_INTERNAL
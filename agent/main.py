@@ -6,13 +6,39 @@ plus **HDF5‑backed session snapshots**.
 
 import argparse
 import json
+import os
 import sys
 import traceback
 
 from agent.log_levels import LogLevel
 from agent.orchestrator.agent_orchestrator import AgentOrchestrator
-from .emitter import emit, set_python_log_level
+from .emitter import (
+    ERROR_ABORTED,
+    emit,
+    error_kind,
+    set_agent_log_file,
+    set_framing,
+    set_python_log_level,
+)
+from agent.agents.auditor.agent import configure_audit
+from agent.agents.executor.create_audited_sessioned_proxy import (
+    set_artifacts_dir,
+    set_ask_on_failure,
+    set_max_parallel_steps,
+    set_step_hooks,
+)
+from agent.agents.executor.tools import (
+    set_binary_outputs,
+    set_databases,
+    set_go_executor,
+    set_mcp_tools,
+    set_progress,
+)
+from .commands import cancel_requested, read_line, start_reader
+from .prompts import use_query_tag
+from .redact import set_redaction_patterns
 from .session import check_session_exists_in_h5
+from .transport import connect
 
 
 def run_orchestration(
@@ -27,9 +53,13 @@ def run_orchestration(
     session_hash: str,
     workdir: str,
     output_threshold_bytes: int,
+    summarize_above_bytes: int,
+    trust_level: str,
     json_logs_enabled: bool,
     cache_directory: str,
     summary_mode: bool,
+    resume: bool,
+    followups: bool = False,
 ) -> None:
     """Main orchestration function."""
     orchestrator = AgentOrchestrator(
@@ -45,10 +75,13 @@ def run_orchestration(
         json_logs_enabled,
         cache_directory,
         output_threshold_bytes,
+        summarize_above_bytes,
+        trust_level,
         summary_mode,
+        followups,
     )
 
-    orchestrator.run(query)
+    orchestrator.run(query, resume)
 
 
 def parse_model_params(params_str: str, param_name: str) -> dict:
@@ -63,14 +96,152 @@ def parse_model_params(params_str: str, param_name: str) -> dict:
         sys.exit(1)
 
 
+def attach_stdin_context(query: str, path: str) -> str:
+    """Attaches the input piped to og, saved by the OG client at path, to the
+    query as a document the request refers to."""
+    with open(path, encoding="utf-8", errors="replace") as f:
+        document = f.read()
+    return (
+        f"{query}\n\n"
+        "The user piped the following input to og; the request refers to it:\n"
+        f"<piped-input>\n{document}\n</piped-input>"
+    )
+
+
+def attach_files_context(query: str, path: str) -> str:
+    """Attaches the files and directory listings the user attached with og
+    --file and --dir, saved by the OG client at path, to the query."""
+    with open(path, encoding="utf-8", errors="replace") as f:
+        document = f.read()
+    return (
+        f"{query}\n\n"
+        "The user attached the following files and directory listings to the request:\n"
+        f"<attachments>\n{document}</attachments>"
+    )
+
+
+def attach_last_command_context(query: str, path: str) -> str:
+    """Attaches the command the user ran in their shell before og, and its exit
+    status, saved by the OG client at path, to the query."""
+    with open(path, encoding="utf-8", errors="replace") as f:
+        document = f.read()
+    return (
+        f"{query}\n\n"
+        "Before this request, the user ran the following command in their shell; "
+        "the request may refer to it:\n"
+        f"<last-command>\n{document}</last-command>"
+    )
+
+
+def attach_refusals_context(query: str, path: str) -> str:
+    """Attaches the actions the user refused in earlier sessions in the working
+    directory, and why, saved by the OG client at path, to the query so the plan
+    avoids them."""
+    with open(path, encoding="utf-8", errors="replace") as f:
+        document = f.read()
+    return (
+        f"{query}\n\n"
+        "In earlier sessions in this directory, the user refused the following actions. "
+        "Do not propose them, or approaches like them, again unless the request asks for them:\n"
+        f"<earlier-refusals>\n{document}</earlier-refusals>"
+    )
+
+
+def attach_read_only_notice(query: str) -> str:
+    """Tells the agent up front that the OG client runs the session read-only
+    and denies every step that writes."""
+    return (
+        f"{query}\n\n"
+        "This session is read-only: the OG client denies every step that writes, "
+        "deletes or moves files, installs software, changes services or sends "
+        "data that changes remote state, and database queries run in read-only "
+        "transactions. Plan only steps that read and inspect; where the request "
+        "needs a change, describe it instead of making it."
+    )
+
+
+def preload_model_client() -> None:
+    """Imports litellm, which the model clients import on first use, so that a
+    warm agent has it imported too. The clients themselves are made by the
+    session, as its models and the environment with their API keys only come
+    with the launch."""
+    try:
+        import litellm  # noqa: F401
+    except ImportError:
+        pass  # Reported when the session makes its models
+
+
+def wait_for_launch() -> None:
+    """Wait for og daemon to hand this agent a session, with the agent's
+    dependencies already imported (--warm). og daemon writes one JSON line to
+    stdin with the session's arguments, working directory and environment,
+    which replace this process's own."""
+    preload_model_client()
+    line = sys.stdin.readline()
+    if not line:
+        sys.exit(0)  # og daemon stopped
+    launch = json.loads(line)
+    os.chdir(launch["cwd"])
+    os.environ.clear()
+    os.environ.update(launch["env"])
+    sys.argv = sys.argv[:1] + launch["argv"]
+
+
+def read_mcp_tools() -> None:
+    """Registers the MCP tools the OG client sends first with the mcp_tools
+    command. Without them the agent plans with its own tools only."""
+    line = read_line()
+    try:
+        command = json.loads(line) if line else {}
+    except json.JSONDecodeError:
+        command = {}
+    if command.get("type") != "mcp_tools":
+        emit(
+            "warn_log",
+            {
+                "message": f"Expected the mcp_tools command, got: {line.strip()[:200]}",
+                "location": "main.read_mcp_tools",
+            },
+        )
+        return
+    set_mcp_tools(command.get("tools") or [])
+
+
 def main():
     """CLI entry point."""
+    if sys.argv[1:] == ["--warm"]:
+        wait_for_launch()
+
     parser = argparse.ArgumentParser(description="OG CLI – multi-agent v6")
     parser.add_argument(
         "--query",
         required=False,
         help="Initial query (required for new sessions, ignored for resumed)",
     )
+    parser.add_argument(
+        "--context-stdin",
+        type=str,
+        default=None,
+        help="File holding input piped to og, attached to the query as context",
+    )
+    parser.add_argument(
+        "--context-files",
+        type=str,
+        default=None,
+        help="File holding the files and directory listings attached with og --file and --dir",
+    )
+    parser.add_argument(
+        "--context-last-command",
+        type=str,
+        default=None,
+        help="File holding the last command run in the user's shell and its exit status",
+    )
+    parser.add_argument(
+        "--context-refusals",
+        type=str,
+        default=None,
+        help="File holding the actions the user refused in earlier sessions in the directory, and why",
+    )
 
     # Executor Agent Model Config
     parser.add_argument(
@@ -97,6 +268,23 @@ def main():
     )
 
     parser.add_argument("--workdir", required=True, help="Current working directory")
+    parser.add_argument(
+        "--trust-level",
+        default="default",
+        choices=["default", "trusted", "untrusted"],
+        help="Trust level of the workdir; 'untrusted' requires approval for every action",
+    )
+    parser.add_argument(
+        "--auditor-strictness",
+        default="standard",
+        choices=["lenient", "standard", "paranoid"],
+        help="How readily the auditor calls an action unsafe",
+    )
+    parser.add_argument(
+        "--unsafe-override",
+        action="store_true",
+        help="Let the user override the auditor's unsafe verdicts; the OG client asks and answers with override_result",
+    )
     parser.add_argument(
         "--verbosity",
         default="info",
@@ -110,11 +298,72 @@ def main():
     parser.add_argument(
         "--session-hash", required=True, help="Unique hash for the current session"
     )
+    parser.add_argument(
+        "--query-tag",
+        default=None,
+        help="Tag the OG client classified the query with; selects [prompts.tags.<tag>] overrides",
+    )
+    parser.add_argument(
+        "--resume",
+        action="store_true",
+        help="Resume the saved state of the session after a crash and wait for a command instead of planning",
+    )
+    parser.add_argument(
+        "--followups",
+        action="store_true",
+        help="Keep the session open after a successful final summary, for followup_query commands",
+    )
+    parser.add_argument(
+        "--ask-on-failure",
+        action="store_true",
+        help="When a recipe step fails, send step_failed and wait for step_retry, step_skip or step_abort",
+    )
+    parser.add_argument(
+        "--go-executor",
+        action="store_true",
+        help="Leave shell commands to the OG client: send run_command and wait for its command_result",
+    )
+    parser.add_argument(
+        "--mcp-tools",
+        action="store_true",
+        help="Read the MCP tools the OG client offers from its mcp_tools command before planning",
+    )
+    parser.add_argument(
+        "--step-hooks",
+        action="store_true",
+        help="Send pre_step and post_step around each step and wait for the OG client's hook_result",
+    )
+    parser.add_argument(
+        "--binary-output",
+        action="store_true",
+        help="Send shell output that is not text base64-encoded in the binary of results",
+    )
+    parser.add_argument(
+        "--progress",
+        action="store_true",
+        help="Send progress messages while shell commands run here for longer than a few seconds",
+    )
+    parser.add_argument(
+        "--max-parallel-steps",
+        type=int,
+        default=1,
+        help="Have the OG client run up to this many independent recipe steps at the same time",
+    )
+    parser.add_argument(
+        "--read-only",
+        action="store_true",
+        help="The OG client denies steps that write; plan with that constraint",
+    )
     parser.add_argument(
         "--output-threshold-bytes",
         type=int,
         default=16768,
-        help="Threshold for tool output size before saving to file",
+        help="Threshold for tool output size before saving to file (deprecated: use --output-settings)",
+    )
+    parser.add_argument(
+        "--output-settings",
+        default=None,
+        help="JSON object with inline_max_bytes, summarize_above_bytes and spill_to_file_above_bytes",
     )
 
     parser.add_argument(
@@ -130,7 +379,70 @@ def main():
         help="Directory for storing JSON session logs",
     )
 
+    parser.add_argument(
+        "--framing",
+        choices=["lines", "length"],
+        default="lines",
+        help="How messages are written to stdout: NDJSON lines, or length-prefixed for messages of any size",
+    )
+
+    parser.add_argument(
+        "--socket",
+        type=str,
+        default=None,
+        help="Unix domain socket of the OG client to exchange messages and commands over, instead of stdout and stdin",
+    )
+
+    parser.add_argument(
+        "--artifacts-dir",
+        type=str,
+        default=None,
+        help="Per-session directory for spilled tool output (default: <system temp>/og/<session hash>)",
+    )
+
+    parser.add_argument(
+        "--redact-patterns",
+        type=str,
+        default=None,
+        help="JSON array of regexes for secrets masked in the agent log and session JSON",
+    )
+
+    parser.add_argument(
+        "--databases",
+        type=str,
+        default=None,
+        help="JSON object mapping configured database names to their driver, for sql_query_tool",
+    )
+
+    parser.add_argument(
+        "--agent-log-file",
+        type=str,
+        default=None,
+        help="File that receives every emitted message as JSON lines (all log levels)",
+    )
+
     args = parser.parse_args()
+    if args.socket:
+        connect(args.socket)
+    else:
+        set_framing(args.framing)
+
+    if args.redact_patterns:
+        set_redaction_patterns(json.loads(args.redact_patterns))
+    set_agent_log_file(args.agent_log_file)
+    if args.databases:
+        set_databases(json.loads(args.databases))
+    if args.artifacts_dir:
+        set_artifacts_dir(args.artifacts_dir)
+    set_ask_on_failure(args.ask_on_failure)
+    set_step_hooks(args.step_hooks)
+    set_go_executor(args.go_executor)
+    set_binary_outputs(args.binary_output)
+    set_progress(args.progress)
+    set_max_parallel_steps(args.max_parallel_steps)
+    if args.query_tag:
+        use_query_tag(args.query_tag)
+    configure_audit(args.auditor_strictness, args.unsafe_override)
 
     # Configure the Python agent's global log level immediately
     set_python_log_level(args.verbosity)
@@ -157,9 +469,35 @@ def main():
     planner_model_params = parse_model_params(args.planner_params, "planner-params")
     auditor_model_params = parse_model_params(args.auditor_params, "auditor-params")
 
+    output_threshold_bytes = args.output_threshold_bytes
+    summarize_above_bytes = 0
+    if args.output_settings:
+        output_settings = parse_model_params(args.output_settings, "output-settings")
+        output_threshold_bytes = int(
+            output_settings.get("spill_to_file_above_bytes", output_threshold_bytes)
+        )
+        summarize_above_bytes = int(output_settings.get("summarize_above_bytes", 0))
+
+    query = args.query
+    if query and args.context_stdin:
+        query = attach_stdin_context(query, args.context_stdin)
+    if query and args.context_files:
+        query = attach_files_context(query, args.context_files)
+    if query and args.context_last_command:
+        query = attach_last_command_context(query, args.context_last_command)
+    if query and args.context_refusals:
+        query = attach_refusals_context(query, args.context_refusals)
+    if query and args.read_only:
+        query = attach_read_only_notice(query)
+
+    # Commands are read in the background from here on, so a cancel is seen at any time
+    start_reader()
+    if args.mcp_tools:
+        read_mcp_tools()
+
     try:
         run_orchestration(
-            query=args.query,
+            query=query,
             executor_model_id=args.executor_model,
             executor_model_params=executor_model_params,
             planner_model_id=args.planner_model,
@@ -169,16 +507,41 @@ def main():
             verbosity=args.verbosity,
             session_hash=args.session_hash,
             workdir=args.workdir,
-            output_threshold_bytes=args.output_threshold_bytes,
+            output_threshold_bytes=output_threshold_bytes,
+            summarize_above_bytes=summarize_above_bytes,
+            trust_level=args.trust_level,
             summary_mode=args.summary_mode,
             json_logs_enabled=args.json_logs_enabled.lower() == "true",
             cache_directory=args.cache_directory,
+            resume=args.resume,
+            followups=args.followups,
+        )
+    except KeyboardInterrupt:
+        if cancel_requested():
+            # Cancelled before the session was set up
+            emit(
+                "cancelled",
+                {"message": "Cancelled by the user.", "steps": 0, "artifacts": []},
+            )
+            sys.exit(130)
+        emit(
+            "error",
+            {
+                "message": "Agent interrupted by the user.",
+                "kind": ERROR_ABORTED,
+                "location": "main.main",
+            },
         )
+        sys.exit(130)
     except Exception as e:
         tb = traceback.format_exc()
         emit(
             "error",
-            {"message": f"Agent execution failed: {e}", "location": "main.main"},
+            {
+                "message": f"Agent execution failed: {e}",
+                "kind": error_kind(e),
+                "location": "main.main",
+            },
         )
         # Only emit full stack trace if verbosity is debug or warn
         if LogLevel[args.verbosity.upper()] <= LogLevel.WARN:
@@ -5,8 +5,10 @@ from typing import Optional
 
 from agent.agents.auditor.agent import factory_auditor_agent
 from agent.agents.executor.agent import factory_executor_agent
+from agent.agents.executor.create_audited_sessioned_proxy import artifacts_dir
 from agent.agents.planner.agent import factory_planner_agent
-from agent.emitter import emit
+from agent.commands import cancel_requested, read_line
+from agent.emitter import ERROR_PROTOCOL, emit
 from agent.log_levels import LogLevel
 from agent.orchestrator.command_handler import CommandHandler
 from agent.orchestrator.initial_plan_handler import InitialPlanHandler
@@ -30,7 +32,10 @@ class AgentOrchestrator:
         json_logs_enabled: bool,
         cache_directory: str,
         output_threshold_bytes: int,
+        summarize_above_bytes: int,
+        trust_level: str,
         summary_mode: bool,
+        followups: bool = False,
     ):
         self.workdir = workdir
         self.python_log_level = LogLevel[verbosity.upper()]
@@ -50,6 +55,8 @@ class AgentOrchestrator:
             self.session,
             self.auditor_agent,
             output_threshold_bytes,
+            summarize_above_bytes,
+            trust_level,
             summary_mode,
             self.python_log_level,
         )
@@ -62,12 +69,28 @@ class AgentOrchestrator:
             self.planner_agent, self.auditor_agent, self.session, self.python_log_level
         )
         self.command_handler = CommandHandler(
-            self.executor_agent, self.session, self.python_log_level
+            self.executor_agent, self.session, self.python_log_level, followups
         )
 
-    def run(self, query: Optional[str]) -> None:
+    def run(self, query: Optional[str], resume: bool = False) -> None:
         """Main orchestration entry point."""
-        if self._is_initial_plan_request():
+        try:
+            self._run(query, resume)
+        except KeyboardInterrupt:
+            if not cancel_requested():
+                raise
+            self._finish_cancelled()
+
+    def _run(self, query: Optional[str], resume: bool) -> None:
+        if self._is_initial_plan_request(resume):
+            if resume:
+                emit(
+                    "warn_log",
+                    {
+                        "message": f"No saved plan to resume for session '{self.session.session_hash}'; planning again.",
+                        "location": "orchestrator/agent_orchestrator.run",
+                    },
+                )
             self._handle_initial_planning(query)
         else:
             emit(
@@ -80,8 +103,33 @@ class AgentOrchestrator:
 
         self._process_commands()
 
-    def _is_initial_plan_request(self) -> bool:
-        """Check if this is an initial plan request."""
+    def _finish_cancelled(self) -> None:
+        """Record a session the OG client cancelled and report what it got done:
+        the steps executed so far and the files left in the artifacts directory."""
+        steps = len(self.session.executed_actions)
+        reason = "Cancelled by the user."
+        final = {
+            "summary": f"{reason} {steps} step(s) were executed before the session was cancelled.",
+            "nutshell": f"Session cancelled: {reason}",
+            "reason": reason,
+            "status": "cancelled",
+        }
+        self.session.set_final_summary(final)  # Saves the transcript
+        directory = artifacts_dir(self.session.session_hash)
+        artifacts = (
+            sorted(str(p) for p in directory.rglob("*") if p.is_file())
+            if directory.is_dir()
+            else []
+        )
+        emit(
+            "cancelled",
+            {"message": final["summary"], "steps": steps, "artifacts": artifacts},
+        )
+
+    def _is_initial_plan_request(self, resume: bool) -> bool:
+        """Check if this is an initial plan request, i.e. there is no saved plan to resume."""
+        if resume:
+            return not self.session.current_recipe
         return not check_session_exists_in_h5(self.session.session_hash)
 
     def _handle_initial_planning(self, query: Optional[str]) -> None:
@@ -101,7 +149,7 @@ class AgentOrchestrator:
     def _process_commands(self) -> None:
         """Process incoming commands from Go client."""
         while True:
-            line = sys.stdin.readline()
+            line = read_line()
             if not line:
                 emit(
                     "info_log",
@@ -122,6 +170,7 @@ class AgentOrchestrator:
                     "error",
                     {
                         "message": f"Failed to parse JSON command from Go: '{line.strip()}'",
+                        "kind": ERROR_PROTOCOL,
                         "location": "orchestrator/agent_orchestrator._process_commands",
                     },
                 )
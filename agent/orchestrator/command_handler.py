@@ -1,10 +1,11 @@
 import sys
 from typing import Dict
 
-from agent.emitter import emit
+from agent.emitter import ERROR_PROTOCOL, emit, error_kind, speaking_as
 from agent.log_levels import LogLevel
 from agent.prompts import (
     prepare_fallback_continuation_query,
+    prepare_followup_query,
     prepare_recipe_continuation_query,
 )
 from agent.session import AgentSession
@@ -14,11 +15,18 @@ class CommandHandler:
     """Handles incoming commands from Go client."""
 
     def __init__(
-        self, executor_agent, session: AgentSession, python_log_level: LogLevel
+        self,
+        executor_agent,
+        session: AgentSession,
+        python_log_level: LogLevel,
+        followups: bool = False,
     ):
         self.executor_agent = executor_agent
         self.session = session
         self.python_log_level = python_log_level
+        # Whether the session stays open for followup_query commands after a
+        # successful final summary, instead of ending with it
+        self.followups = followups
 
     def handle_command(self, command: Dict) -> bool:
         """Handle a single command. Returns True if should continue, False if should exit."""
@@ -34,10 +42,12 @@ class CommandHandler:
 
         handlers = {
             "execute_recipe": self._handle_execute_recipe,
+            "execute_recipe_subset": self._handle_execute_recipe_subset,
             "execute_single_action": self._handle_execute_single_action,
             "execute_fallback": self._handle_execute_fallback,
             "user_approval_response": self._handle_user_approval,
             "deny_current_action": self._handle_deny_current_action,
+            "followup_query": self._handle_followup_query,
         }
 
         handler = handlers.get(cmd_type)
@@ -48,6 +58,7 @@ class CommandHandler:
                 "error",
                 {
                     "message": f"Python agent received unhandled command type: {cmd_type}",
+                    "kind": ERROR_PROTOCOL,
                     "location": "orchestrator/command_handler.handle_command",
                 },
             )
@@ -55,10 +66,11 @@ class CommandHandler:
 
     def _handle_execute_recipe(self, command: Dict) -> bool:
         """Handle execute_recipe command: user approved multi-step recipe."""
-        self.session.set_single_step_plan_status(False)
-        self.session.set_recipe_preapproved(True)
-        self.session.increment_recipe_step()
-        self.session.set_deviation_occurred(False)
+        if not self._resume(command):
+            self.session.set_single_step_plan_status(False)
+            self.session.set_recipe_preapproved(True)
+            self.session.increment_recipe_step()
+            self.session.set_deviation_occurred(False)
 
         emit(
             "info_log",
@@ -70,14 +82,23 @@ class CommandHandler:
 
         continuation_query = prepare_recipe_continuation_query(self.session)
         self._execute_and_emit_finale(continuation_query, "recipe execution")
-        return False
+        return self.followups
+
+    def _handle_execute_recipe_subset(self, command: Dict) -> bool:
+        """Handle execute_recipe_subset command: user approved the recipe after
+        removing or reordering its steps. "steps" holds the numbers of the steps
+        to run, in order."""
+        if not command.get("resume"):
+            self.session.select_recipe_steps(command.get("steps", []))
+        return self._handle_execute_recipe(command)
 
     def _handle_execute_single_action(self, command: Dict) -> bool:
         """Handle execute_single_action command: Go frontend decided to auto-proceed to individual step approval."""
-        self.session.set_single_step_plan_status(True)
-        self.session.set_recipe_preapproved(False)
-        self.session.increment_recipe_step()
-        self.session.set_deviation_occurred(False)
+        if not self._resume(command):
+            self.session.set_single_step_plan_status(True)
+            self.session.set_recipe_preapproved(False)
+            self.session.increment_recipe_step()
+            self.session.set_deviation_occurred(False)
 
         emit(
             "info_log",
@@ -89,14 +110,15 @@ class CommandHandler:
 
         continuation_query = prepare_recipe_continuation_query(self.session)
         self._execute_and_emit_finale(continuation_query, "single action execution")
-        return False
+        return self.followups
 
     def _handle_execute_fallback(self, command: Dict) -> bool:
         """Handle execute_fallback command."""
-        self.session.set_single_step_plan_status(False)
-        self.session.set_recipe_preapproved(False)
-        self.session.increment_recipe_step()
-        self.session.set_deviation_occurred(True)
+        if not self._resume(command):
+            self.session.set_single_step_plan_status(False)
+            self.session.set_recipe_preapproved(False)
+            self.session.increment_recipe_step()
+            self.session.set_deviation_occurred(True)
         emit(
             "info_log",
             {
@@ -106,7 +128,46 @@ class CommandHandler:
         )
         continuation_query = prepare_fallback_continuation_query(self.session)
         self._execute_and_emit_finale(continuation_query, "fallback continuation")
-        return False
+        return self.followups
+
+    def _handle_followup_query(self, command: Dict) -> bool:
+        """Handle followup_query command: the user asked a follow-up after the
+        final summary. The executor carries on with its memory of the session,
+        and every action it takes needs approval, since none of it was planned."""
+        query = command.get("query", "")
+        if not self._resume(command):
+            self.session.set_single_step_plan_status(False)
+            self.session.set_recipe_preapproved(False)
+            self.session.set_deviation_occurred(True)
+            self.session.add_to_history("user", query)
+        emit(
+            "info_log",
+            {
+                "message": f"Continuing session '{self.session.session_hash}' with a follow-up.",
+                "location": "orchestrator/command_handler._handle_followup_query",
+            },
+        )
+        followup_query = prepare_followup_query(self.session, query)
+        self._execute_and_emit_finale(followup_query, "follow-up", reset=False)
+        if self.session.final_summary:
+            self.session.add_to_history(
+                "assistant", self.session.final_summary.get("summary") or ""
+            )
+        return self.followups
+
+    def _resume(self, command: Dict) -> bool:
+        """Return True if Go resent this phase command to an agent restarted after a
+        crash; the saved session state already records the phase's progress."""
+        if not command.get("resume"):
+            return False
+        emit(
+            "info_log",
+            {
+                "message": f"Resuming session '{self.session.session_hash}' after {len(self.session.executed_actions)} executed action(s).",
+                "location": "orchestrator/command_handler._resume",
+            },
+        )
+        return True
 
     def _handle_user_approval(self, command: Dict) -> bool:
         """Handle user_approval_response command: This is consumed by the ProxyTool."""
@@ -137,31 +198,31 @@ class CommandHandler:
         nutshell = f"Session cancelled: {reason}"
         if self.session.executed_actions:
             nutshell += f" Last action: {self.session.executed_actions[-1]['action']}"
-        emit(
-            "final_summary",
-            {
-                "summary": summary,
-                "nutshell": nutshell,
-                "reason": reason,
-                "status": "cancelled",
-            },
-        )
+        final = {
+            "summary": summary,
+            "nutshell": nutshell,
+            "reason": reason,
+            "status": "cancelled",
+        }
+        self.session.set_final_summary(final)
+        emit("final_summary", final)
 
     def _execute_and_emit_finale(
-        self, continuation_query: str, execution_type: str
+        self, continuation_query: str, execution_type: str, reset: bool = True
     ) -> None:
-        """Execute query and emit final summary when the agent finishes."""
+        """Execute query and emit final summary when the agent finishes. With
+        reset False, the executor keeps the memory of its earlier runs."""
         try:
-            finale = self.executor_agent.run(continuation_query)
+            with speaking_as("executor"):
+                finale = self.executor_agent.run(continuation_query, reset=reset)
             lines = finale.splitlines() if finale else []
-            emit(
-                "final_summary",
-                {
-                    "summary": finale,
-                    "nutshell": lines[0] if len(lines) > 1 else "",
-                    "status": "success",
-                },
-            )
+            final = {
+                "summary": finale,
+                "nutshell": lines[0] if len(lines) > 1 else "",
+                "status": "success",
+            }
+            self.session.set_final_summary(final)
+            emit("final_summary", final)
         except Exception as e:
             import traceback
 
@@ -170,6 +231,7 @@ class CommandHandler:
                 "error",
                 {
                     "message": f"Agent execution failed during {execution_type}: {e}",
+                    "kind": error_kind(e),
                     "location": "orchestrator/command_handler._execute_and_emit_finale",
                 },
             )
@@ -1,8 +1,8 @@
 import sys
 from typing import Dict, List, Optional, Tuple
 
-from agent.agents.auditor.agent import audit_request
-from agent.emitter import emit
+from agent.agents.auditor.agent import audit_request, report_unsafe
+from agent.emitter import emit, error_kind, speaking_as
 from agent.log_levels import LogLevel
 from agent.prompts import prepare_planning_prompt
 from agent.session import AgentSession
@@ -27,11 +27,14 @@ class InitialPlanHandler:
     def create_and_audit_plan(self, query: str) -> None:
         """Create initial plan and perform safety audit."""
         try:
-            plan_str = self._generate_plan(query)
-            recipe_steps, fallback_action = self._parse_plan(plan_str)
-            self._validate_plan(recipe_steps, fallback_action, query)
-            self._audit_initial_action(recipe_steps, fallback_action)
-            self._store_and_emit_plan(recipe_steps, fallback_action, query)
+            with speaking_as("planner"):
+                plan_str = self._generate_plan(query)
+                recipe_steps, fallback_action = self._parse_plan(plan_str)
+                self._validate_plan(recipe_steps, fallback_action, query)
+            with speaking_as("auditor"):
+                self._audit_initial_action(recipe_steps, fallback_action)
+            with speaking_as("planner"):
+                self._store_and_emit_plan(recipe_steps, fallback_action, query)
 
         except Exception as e:
             self._handle_planning_error(e)
@@ -62,19 +65,23 @@ class InitialPlanHandler:
 
     def _get_first_action(
         self, recipe_steps: List[Dict], fallback_action: Optional[Dict]
-    ) -> Tuple[str, str]:
-        """Get the first action that would be executed."""
+    ) -> Tuple[str, str, str]:
+        """Get the first action that would be executed, its description and tool."""
         if recipe_steps:
             first_step = recipe_steps[0]
-            return first_step.get("action", ""), first_step.get(
-                "description", "First step of recipe"
+            return (
+                first_step.get("action", ""),
+                first_step.get("description", "First step of recipe"),
+                first_step.get("tool", ""),
             )
         elif fallback_action:
-            return fallback_action.get("action", ""), fallback_action.get(
-                "description", "Fallback action"
+            return (
+                fallback_action.get("action", ""),
+                fallback_action.get("description", "Fallback action"),
+                fallback_action.get("tool", ""),
             )
         else:
-            return "", "No action available"
+            return "", "No action available", ""
 
     def _validate_plan(
         self, recipe_steps: List[Dict], fallback_action: Optional[Dict], query: str
@@ -101,7 +108,7 @@ class InitialPlanHandler:
         self, recipe_steps: List[Dict], fallback_action: Optional[Dict]
     ) -> None:
         """Audit the first action that would be taken."""
-        action_to_audit, action_description = self._get_first_action(
+        action_to_audit, action_description, tool = self._get_first_action(
             recipe_steps, fallback_action
         )
 
@@ -126,17 +133,15 @@ class InitialPlanHandler:
                 },
             )
 
-        if not audit_result.get("safe", False):
-            emit(
-                "unsafe",
-                {
-                    "reason": audit_result.get("reason", "Initial plan deemed unsafe"),
-                    "explanation": audit_result.get(
-                        "explanation",
-                        f"Initial action proposed: '{action_description}' was found unsafe.",
-                    ),
-                },
-            )
+        if not audit_result.get("safe", False) and not report_unsafe(
+            audit_result.get("reason", "Initial plan deemed unsafe"),
+            audit_result.get(
+                "explanation",
+                f"Initial action proposed: '{action_description}' was found unsafe.",
+            ),
+            action_to_audit,
+            tool,
+        ):
             sys.exit(0)
 
     def _store_and_emit_plan(
@@ -157,15 +162,24 @@ class InitialPlanHandler:
 
     def _format_steps_for_go(self, recipe_steps: List[Dict]) -> List[Dict]:
         """Format recipe steps for Go client."""
-        return [
-            {
+        formatted = []
+        for step in recipe_steps:
+            item = {
                 "description": step.get("description", ""),
                 "expected_outcome": step.get("expected_outcome", ""),
                 "action": step.get("action", ""),
                 "tool": step.get("tool", ""),
             }
-            for step in recipe_steps
-        ]
+            if step.get("condition"):
+                item["condition"] = step["condition"]
+            if step.get("loop"):
+                item["loop"] = step["loop"]
+            if step.get("inputs"):
+                item["inputs"] = step["inputs"]
+            if step.get("needs") is not None:  # [AFTER NONE] is sent too
+                item["needs"] = step["needs"]
+            formatted.append(item)
+        return formatted
 
     def _format_fallback_for_go(
         self, fallback_action: Optional[Dict]
@@ -190,6 +204,7 @@ class InitialPlanHandler:
             "error",
             {
                 "message": f"Agent planning or initial audit failed: {error}",
+                "kind": error_kind(error),
                 "location": "orchestrator/initial_plan_handler._handle_planning_error",
             },
         )
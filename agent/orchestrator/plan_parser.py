@@ -3,12 +3,56 @@ from typing import Dict, List, Optional, Tuple
 
 from agent.emitter import emit
 
+# A step that only runs under a condition starts with [IF <condition>]; the OG
+# client evaluates the condition when the executor reaches the step.
+_condition_pattern = re.compile(r"^\[IF\s+(.+?)\]\s*$", re.IGNORECASE)
+
+# A step the executor may run more than once starts with [FOR EACH <items> MAX
+# <n>] or [REPEAT MAX <n>]; the OG client counts its iterations and caps them.
+_loop_pattern = re.compile(r"^\[((?:FOR\s+EACH|REPEAT)\b.*?)\]\s*$", re.IGNORECASE)
+
+# A step that needs a value from the user starts with [ASK {<name>} "<prompt>"
+# MATCHING <regex>], one line per value, and uses {<name>} in its commands; the
+# OG client asks the user when the executor reaches the step.
+_input_pattern = re.compile(
+    r'^\[ASK\s+\{(\w+)\}\s+"([^"]*)"(?:\s+MATCHING\s+(.+?))?\]\s*$', re.IGNORECASE
+)
+
+# A step that needs only some of the steps before it starts with [AFTER <n>,
+# ...], or [AFTER NONE] when it needs none of them; the OG client may run it at
+# the same time as other steps. A step without one needs every step before it.
+_after_pattern = re.compile(r"^\[AFTER\s+(.+?)\]\s*$", re.IGNORECASE)
+
+
+def _parse_needs(after: str, number: int) -> List[int]:
+    """The numbers of the steps before step number that an [AFTER ...] lists;
+    others are left out with a warning."""
+    if after.strip().upper() == "NONE":
+        return []
+    needs = []
+    for word in re.split(r"[\s,]+", after.strip()):
+        if word.isdigit() and 1 <= int(word) < number:
+            needs.append(int(word))
+        elif word:
+            emit(
+                "warn_log",
+                {
+                    "message": f"Step {number} is after '{word}', which is not a step before it; ignoring it.",
+                    "location": "orchestrator/plan_parser.parse_plan",
+                },
+            )
+    return sorted(set(needs))
+
 
 def parse_plan(plan_str: str) -> Tuple[List[Dict], Optional[Dict]]:
     """
     Parse the plan string into recipe steps based on the prompt format.
     The prompt expects a multi-line string of commands, potentially separated by '[STEP]' markers.
     Each block of commands separated by [STEP] becomes a single recipe step.
+    A block whose first line is [IF <condition>] becomes a conditional step, and
+    one whose first line is [FOR EACH ...] or [REPEAT ...] a loop step; a block
+    may start with both, with [ASK ...] lines declaring the values it needs
+    from the user, and with [AFTER ...] naming the steps it needs.
     """
     emit(
         "debug_log",
@@ -40,14 +84,38 @@ def parse_plan(plan_str: str) -> Tuple[List[Dict], Optional[Dict]]:
         return [], None
 
     for i, segment_content in enumerate(processed_segments):
-        recipe_steps.append(
-            {
-                "description": f"Execute command block {i + 1}",
-                "expected_outcome": f"Command block {i + 1} executed successfully",
-                "action": segment_content,
-                "tool": "shell_tool",
-            }
-        )
+        step = {
+            "description": f"Execute command block {i + 1}",
+            "expected_outcome": f"Command block {i + 1} executed successfully",
+            "action": segment_content,
+            "tool": "shell_tool",
+        }
+        while True:
+            first_line, _, rest = step["action"].partition("\n")
+            if not rest.strip():
+                break
+            condition = _condition_pattern.match(first_line.strip())
+            loop = _loop_pattern.match(first_line.strip())
+            ask = _input_pattern.match(first_line.strip())
+            after = _after_pattern.match(first_line.strip())
+            if condition and "condition" not in step:
+                step["condition"] = condition.group(1).strip()
+            elif loop and "loop" not in step:
+                step["loop"] = " ".join(loop.group(1).split())
+            elif ask:
+                step.setdefault("inputs", []).append(
+                    {
+                        "name": ask.group(1),
+                        "prompt": ask.group(2).strip(),
+                        "pattern": (ask.group(3) or "").strip(),
+                    }
+                )
+            elif after and "needs" not in step:
+                step["needs"] = _parse_needs(after.group(1), i + 1)
+            else:
+                break
+            step["action"] = rest.strip()
+        recipe_steps.append(step)
 
     emit(
         "debug_log",
@@ -1,3 +1,4 @@
+import os
 from pathlib import Path
 import toml
 from typing import Dict
@@ -38,7 +39,10 @@ def _get_common_tools() -> str:
 
 
 def _get_prompts_config_path() -> Path:
-    """Determine the path to the prompts.toml file in the user's data directory."""
+    """Determine the path to the prompts.toml file: the one chosen by the OG client
+    ($OG_PROMPTS_FILE), else the one in the user's data directory."""
+    if os.environ.get("OG_PROMPTS_FILE"):
+        return Path(os.environ["OG_PROMPTS_FILE"])
     home_dir = Path.home()
     return home_dir / ".local" / "share" / "og" / "prompts" / "prompts.toml"
 
@@ -64,6 +68,14 @@ def load_prompts():
 load_prompts()
 
 
+def use_query_tag(tag: str) -> None:
+    """Apply the prompt overrides for queries the OG client classified with tag,
+    from the [prompts.tags.<tag>] table. The prompts are updated in place, since
+    agents hold a reference to them."""
+    overrides = _prompts_config.get("tags", {}).get(tag, {})
+    _prompts_config.update(overrides)
+
+
 def prepare_planning_prompt(query: str) -> str:
     """
     Prepares the prompt for the PlannerAgent to generate the initial recipe.
@@ -111,3 +123,25 @@ def prepare_fallback_continuation_query(session: AgentSession) -> str:
         execution_context=session.get_execution_context(),
         tools_section_str=tools_section_str,
     )
+
+
+def prepare_followup_query(session: AgentSession, followup: str) -> str:
+    """
+    Prepares the query for the ExecutorAgent when the user asks a follow-up
+    after the final summary.
+    """
+    template = _prompts_config["followup_query_template"]
+
+    previous_answer = (
+        session.final_summary.get("summary") if session.final_summary else None
+    )
+
+    return template.format(
+        original_request_line=session.original_query.strip()
+        if session.original_query
+        else "N/A",
+        previous_answer=(previous_answer or "N/A").strip(),
+        followup=followup.strip(),
+        execution_context=session.get_execution_context(),
+        tools_section_str=_get_common_tools(),
+    )
@@ -0,0 +1,47 @@
+import re
+from typing import Any, List, Pattern
+
+PLACEHOLDER = "[REDACTED]"
+
+# Patterns are supplied by the Go client (--redact-patterns) so both sides mask the same secrets.
+_patterns: List[Pattern] = []
+
+
+def set_redaction_patterns(patterns: List[str]):
+    """Compiles the redaction patterns; invalid ones are skipped."""
+    global _patterns
+    compiled = []
+    for p in patterns:
+        try:
+            compiled.append(re.compile(p))
+        except re.error:
+            continue
+    _patterns = compiled
+
+
+def _replace(match: "re.Match") -> str:
+    # Only the "secret" group is masked when the pattern defines one
+    if "secret" not in match.re.groupindex or match.group("secret") is None:
+        return PLACEHOLDER
+    start, end = match.span("secret")
+    offset = match.start()
+    text = match.group(0)
+    return text[: start - offset] + PLACEHOLDER + text[end - offset :]
+
+
+def redact(text: str) -> str:
+    """Returns text with every secret replaced by the placeholder."""
+    for pattern in _patterns:
+        text = pattern.sub(_replace, text)
+    return text
+
+
+def redact_obj(obj: Any) -> Any:
+    """Redacts every string inside a JSON-like structure of dicts and lists."""
+    if isinstance(obj, str):
+        return redact(obj)
+    if isinstance(obj, dict):
+        return {k: redact_obj(v) for k, v in obj.items()}
+    if isinstance(obj, list):
+        return [redact_obj(v) for v in obj]
+    return obj
@@ -1,10 +1,12 @@
 import h5py
 import json
 from pathlib import Path
+import re
 import time
-from typing import Dict, List, Optional
+from typing import Dict, List, Optional, Tuple
 
 from .emitter import _EmitterCallable
+from .redact import redact_obj
 
 
 def check_session_exists_in_h5(session_hash: str) -> bool:
@@ -50,6 +52,7 @@ class AgentSession:
         self.fallback_action: Optional[Dict[str, str]] = None
         self.executed_actions: List[Dict[str, str]] = []
         self.original_query: Optional[str] = None
+        self.final_summary: Optional[Dict[str, str]] = None  # How the session ended
 
         # State for recipe approval and progress tracking
         self.is_single_step_plan: bool = (
@@ -67,6 +70,13 @@ class AgentSession:
         self.deviation_occurred: bool = (
             False  # Flag to track if agent deviated from pre-approved recipe
         )
+        # Whether the condition of each conditional step held, and why, once
+        # the OG client evaluated it
+        self.condition_results: Dict[int, Tuple[bool, str]] = {}
+        # Iterations of each loop step the OG client allowed, and its limit
+        self.loop_iterations: Dict[int, Tuple[int, int]] = {}
+        # Values the user gave for the inputs of the recipe's steps, by name
+        self.input_values: Dict[str, str] = {}
 
         self._load_session()
 
@@ -108,6 +118,7 @@ class AgentSession:
                             self._h5_load_json(grp, "executed") or []
                         )
                         self.original_query = self._h5_load_json(grp, "original_query")
+                        self.final_summary = self._h5_load_json(grp, "final_summary")
 
                         # Load state variables
                         self.is_single_step_plan = grp.attrs.get(
@@ -143,16 +154,20 @@ class AgentSession:
                     },
                 )
 
-        # --- Fallback: JSON file ---
-        if not self.json_path.exists():
+        # --- Fallback: JSON file, as written to the cache directory ---
+        json_path = self.cache_directory_path / self.json_path.name
+        if not json_path.exists():
+            json_path = self.json_path
+        if not json_path.exists():
             return
         try:
-            data = json.loads(self.json_path.read_text())
+            data = json.loads(json_path.read_text())
             self.conversation_history = data.get("conversation_history", [])
             self.current_recipe = data.get("current_recipe")
             self.fallback_action = data.get("fallback_action")
             self.executed_actions = data.get("executed_actions", [])
             self.original_query = data.get("original_query")
+            self.final_summary = data.get("final_summary")
 
             # Load state variables from JSON (if present, else defaults)
             self.is_single_step_plan = data.get("is_single_step_plan", False)
@@ -192,6 +207,7 @@ class AgentSession:
             "fallback_action": self.fallback_action,
             "executed_actions": self.executed_actions,
             "original_query": self.original_query,
+            "final_summary": self.final_summary,
             # Save state variables to JSON
             "is_single_step_plan": self.is_single_step_plan,
             "recipe_preapproved": self.recipe_preapproved,
@@ -202,7 +218,7 @@ class AgentSession:
         # --- JSON backup ---
         try:
             (self.cache_directory_path / self.json_path.name).write_text(
-                json.dumps(payload, indent=2, ensure_ascii=False)
+                json.dumps(redact_obj(payload), indent=2, ensure_ascii=False)
             )
         except Exception as e:
             self._emit(
@@ -235,6 +251,7 @@ class AgentSession:
                 self._h5_write_json(grp, "fallback", self.fallback_action)
                 self._h5_write_json(grp, "executed", self.executed_actions)
                 self._h5_write_json(grp, "original_query", self.original_query)
+                self._h5_write_json(grp, "final_summary", self.final_summary)
         except Exception as e:
             self._emit(
                 "error",
@@ -276,12 +293,42 @@ class AgentSession:
         self.next_expected_recipe_step_idx = 0
         self.next_expected_subcommand_idx = 0
         self.deviation_occurred = False
+        self.condition_results = {}
+        self.loop_iterations = {}
+        self.input_values = {}
+        self._save_session()
+
+    def select_recipe_steps(self, numbers: List[int]):
+        """Keeps the recipe steps with the given 1-based numbers, in the order
+        given, as the user edited the recipe before approving it."""
+        if not self.current_recipe:
+            return
+        kept = [
+            n for n in numbers if isinstance(n, int) and 1 <= n <= len(self.current_recipe)
+        ]
+        recipe = []
+        for position, n in enumerate(kept, 1):
+            step = dict(self.current_recipe[n - 1])
+            # The steps it needs are renumbered; a step that now comes before
+            # one it needs runs after all the steps before it
+            if step.get("needs") is not None:
+                needs = [kept.index(m) + 1 for m in step["needs"] if m in kept]
+                if all(m < position for m in needs):
+                    step["needs"] = needs
+                else:
+                    del step["needs"]
+            recipe.append(step)
+        self.current_recipe = recipe
         self._save_session()
 
     def set_original_query(self, query: str):
         self.original_query = query
         self._save_session()
 
+    def set_final_summary(self, summary: Dict[str, str]):
+        self.final_summary = summary
+        self._save_session()
+
     # setters for session state
     def set_recipe_preapproved(self, status: bool):
         self.recipe_preapproved = status
@@ -314,6 +361,136 @@ class AgentSession:
             return self.current_recipe[self.next_expected_recipe_step_idx]
         return None
 
+    def find_recipe_step(self, tool: str, action: str) -> Optional[int]:
+        """Returns the index of the recipe step that plans action with tool:
+        the expected step if it does, else the first step that does."""
+        if not self.current_recipe:
+            return None
+        action = self.fill_inputs(action).strip()
+        start = min(self.next_expected_recipe_step_idx, len(self.current_recipe))
+        for idx in list(range(start, len(self.current_recipe))) + list(range(start)):
+            step = self.current_recipe[idx]
+            if step.get("tool") != tool:
+                continue
+            planned_action = self.fill_inputs(step.get("action", "")).strip()
+            planned = [line.strip() for line in planned_action.split("\n")]
+            if action in planned or action == planned_action:
+                return idx
+            if step.get("loop") and any(
+                matches_template(line, action) for line in planned
+            ):
+                return idx
+        return None
+
+    def is_expected_action(self, action: str) -> bool:
+        """Whether action is the expected subcommand, or, in a loop step, an
+        iteration of it."""
+        expected = self.get_expected_subcommand()
+        if expected is None:
+            return False
+        expected, action = self.fill_inputs(expected), self.fill_inputs(action)
+        if action.strip() == expected:
+            return True
+        step = self.get_expected_recipe_step()
+        return bool(step and step.get("loop")) and matches_template(expected, action)
+
+    def finish_subcommand(self):
+        """Moves on after the expected subcommand ran: to the next subcommand,
+        or the next step after the last one. A loop step starts over instead,
+        and is left when the executor runs a later step or reaches its limit."""
+        self.increment_subcommand_idx()
+        step = self.get_expected_recipe_step()
+        if not step:
+            return
+        planned_commands = step.get("action", "").strip().split("\n")
+        if self.next_expected_subcommand_idx >= len(planned_commands):
+            if step.get("loop"):
+                self.next_expected_subcommand_idx = 0
+                self._save_session()
+            else:
+                self.increment_recipe_step()
+
+    def leave_loop(self, step_idx: int):
+        """Moves past the expected loop step when the executor runs the later
+        step at step_idx."""
+        step = self.get_expected_recipe_step()
+        if step and step.get("loop") and step_idx > self.next_expected_recipe_step_idx:
+            self.next_expected_recipe_step_idx = step_idx
+            self.next_expected_subcommand_idx = 0
+            self._save_session()
+
+    def set_loop_iteration(self, step_idx: int, iteration: int, limit: int, allowed: bool):
+        """Records the iterations of a loop step the OG client allowed. A loop
+        at its limit that is the expected step is passed over."""
+        self.loop_iterations[step_idx] = (iteration, limit)
+        if not allowed and step_idx == self.next_expected_recipe_step_idx:
+            self.increment_recipe_step()
+
+    def set_input_value(self, step_idx: int, name: str, value: Optional[str]):
+        """Records the value the user gave for an input of a recipe step. A
+        step without it (value None) that is the expected one is passed over."""
+        if value is not None:
+            self.input_values[name] = value
+        elif step_idx == self.next_expected_recipe_step_idx:
+            self.increment_recipe_step()
+
+    def fill_inputs(self, text: str) -> str:
+        """Replaces the {name} placeholders of the inputs the user gave in text."""
+        for name, value in self.input_values.items():
+            text = text.replace("{" + name + "}", value)
+        return text
+
+    def set_condition_result(self, step_idx: int, met: bool, reason: str):
+        """Records whether the condition of a recipe step held. A skipped step
+        that is the expected one is passed over."""
+        self.condition_results[step_idx] = (met, reason)
+        if not met and step_idx == self.next_expected_recipe_step_idx:
+            self.increment_recipe_step()
+
+    def skip_recipe_step(self, step_idx: int):
+        """Passes over the recipe step at step_idx, which failed and the user
+        chose to skip, when it is the expected one."""
+        if step_idx == self.next_expected_recipe_step_idx:
+            self.increment_recipe_step()
+
+    def parallel_wave(self, step_idx: int, limit: int) -> List[int]:
+        """Returns the indexes of the recipe steps that may run at the same time
+        as the expected step at step_idx, which has not started: it and up to
+        limit - 1 steps right after it that need none of the others. Only steps
+        of a single shell command, without a condition, a loop or inputs, run
+        at the same time."""
+
+        def simple(step: Dict) -> bool:
+            return (
+                step.get("tool") == "shell_tool"
+                and "\n" not in step.get("action", "").strip()
+                and not step.get("condition")
+                and not step.get("loop")
+                and not step.get("inputs")
+            )
+
+        if (
+            step_idx != self.next_expected_recipe_step_idx
+            or self.next_expected_subcommand_idx != 0
+            or not self.current_recipe
+            or step_idx >= len(self.current_recipe)
+            or not simple(self.current_recipe[step_idx])
+        ):
+            return [step_idx]
+        wave = [step_idx]
+        for idx in range(step_idx + 1, len(self.current_recipe)):
+            step = self.current_recipe[idx]
+            needs = step.get("needs")
+            if (
+                len(wave) >= limit
+                or needs is None
+                or not simple(step)
+                or any(n - 1 in wave for n in needs)
+            ):
+                break
+            wave.append(idx)
+        return wave
+
     def get_expected_subcommand(self) -> Optional[str]:
         """
         Returns the expected subcommand string based on current step and subcommand index.
@@ -381,6 +558,31 @@ class AgentSession:
                     context_parts.append(
                         f"{prefix} {i}. {step.get('description', 'No description')}: {step.get('action', 'N/A')} ({step.get('tool', 'N/A')})"
                     )
+                if step.get("condition"):
+                    decided = self.condition_results.get(i - 1)
+                    if decided is None:
+                        note = "checked by OG when you run it"
+                    elif decided[0]:
+                        note = f"holds: {decided[1]}"
+                    else:
+                        note = f"does not hold, skipped: {decided[1]}"
+                    context_parts.append(
+                        f"       Only if {step['condition']} ({note})"
+                    )
+                if step.get("loop"):
+                    ran, limit = self.loop_iterations.get(i - 1, (0, 0))
+                    note = "OG counts the iterations"
+                    if limit:
+                        note = f"{ran} of at most {limit} iterations ran"
+                    context_parts.append(f"       Repeats: {step['loop']} ({note})")
+                for spec in step.get("inputs", []):
+                    value = self.input_values.get(spec["name"])
+                    note = "OG asks the user when you run it"
+                    if value is not None:
+                        note = f"the user gave {value}"
+                    context_parts.append(
+                        f"       Asks: {{{spec['name']}}} {spec.get('prompt', '')} ({note})"
+                    )
             if self.fallback_action:
                 context_parts.append(
                     f"\nInitial fallback action provided to user: {self.fallback_action.get('action', 'N/A')} ({self.fallback_action.get('tool', 'N/A')})"
@@ -395,3 +597,18 @@ class AgentSession:
             if context_parts
             else "No prior actions or initial recipe available"
         )
+
+
+# What {item} may stand for in the command of a loop step: a word such as a
+# path, never shell syntax, so an approved template cannot run other commands
+_item_pattern = r"[\w./:@%+=,~-]+"
+
+
+def matches_template(template: str, action: str) -> bool:
+    """Whether action is the planned command template of a loop step, with
+    {item} standing for a word such as a path."""
+    template = template.strip()
+    if "{item}" not in template:
+        return action.strip() == template
+    pattern = _item_pattern.join(re.escape(part) for part in template.split("{item}"))
+    return re.fullmatch(pattern, action.strip()) is not None
@@ -0,0 +1,76 @@
+"""Reports the tokens consumed by each model call to the Go client, which
+accounts for them per agent role and prices them, and lets the Go client decide
+whether a failed model call is retried."""
+
+import json
+
+from smolagents import LiteLLMModel
+
+from agent.commands import read_line
+from agent.emitter import ERROR_MODEL_UNREACHABLE, emit
+
+
+class AccountedLiteLLMModel(LiteLLMModel):
+    """A LiteLLMModel that emits a `token_usage` message after every call, and a
+    `model_error` message when a call fails."""
+
+    def __init__(self, role: str, model_id: str, **kwargs):
+        super().__init__(model_id=model_id, **kwargs)
+        self.role = role
+
+    def generate(self, *args, **kwargs):
+        attempt = 1
+        while True:
+            try:
+                message = super().generate(*args, **kwargs)
+                break
+            except Exception as e:
+                if not self._should_retry(e, attempt):
+                    e.og_error_kind = ERROR_MODEL_UNREACHABLE
+                    raise
+                attempt += 1
+        self._report(message)
+        return message
+
+    def _should_retry(self, error: Exception, attempt: int) -> bool:
+        """Reports a failed call to the Go client, which classifies the error,
+        waits out a backoff for transient ones and answers with `model_retry`."""
+        status = getattr(error, "status_code", None)
+        emit(
+            "model_error",
+            {
+                "role": self.role,
+                "model": self.model_id,
+                "message": str(error),
+                "error_type": type(error).__name__,
+                "status_code": status if isinstance(status, int) else 0,
+                "attempt": attempt,
+            },
+        )
+        line = read_line()
+        if not line:
+            return False
+        try:
+            return bool(json.loads(line).get("retry"))
+        except (json.JSONDecodeError, AttributeError):
+            return False
+
+    def _report(self, message):
+        usage = getattr(message, "token_usage", None)
+        if usage is not None:
+            prompt, completion = usage.input_tokens, usage.output_tokens
+        else:
+            # Older smolagents versions keep the counts of the last call on the model
+            prompt = getattr(self, "last_input_token_count", None)
+            completion = getattr(self, "last_output_token_count", None)
+        if prompt is None and completion is None:
+            return  # The provider did not report usage
+        emit(
+            "token_usage",
+            {
+                "role": self.role,
+                "model": self.model_id,
+                "prompt_tokens": int(prompt or 0),
+                "completion_tokens": int(completion or 0),
+            },
+        )
@@ -0,0 +1,35 @@
+"""
+The socket transport, which the OG client offers with --socket instead of
+stdin and stdout (general.agent_transport = "socket").
+
+The agent connects back to the client's Unix domain socket twice, naming each
+connection's channel on its first line: "control" carries messages to the
+client and its commands, "log" carries log messages only. Messages on both are
+length-framed like on stdout (see emitter.set_framing), so stdout and stderr
+are left to Python's own prints, which the client shows as agent output.
+"""
+
+import json
+import socket
+
+from agent.commands import start_reader
+from agent.emitter import use_channels
+
+_connections = []  # Kept open for the lifetime of the agent
+
+
+def _open_channel(path: str, channel: str) -> socket.socket:
+    conn = socket.socket(socket.AF_UNIX, socket.SOCK_STREAM)
+    conn.connect(path)
+    conn.sendall(json.dumps({"channel": channel}).encode("utf-8") + b"\n")
+    _connections.append(conn)
+    return conn
+
+
+def connect(path: str) -> None:
+    """Connect to the OG client's socket at path and use it for all messages
+    and commands from now on."""
+    control = _open_channel(path, "control")
+    log = _open_channel(path, "log")
+    use_channels(control.makefile("wb"), log.makefile("wb"))
+    start_reader(control.fileno())
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/attach"
+	"github.com/robbiemu/original_gangster/og/internal/maintenance"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// pathList is a flag that may be given more than once, as in
+// `og --file main.go --file 'internal/**/*.go' <prompt>`.
+type pathList []string
+
+func (l *pathList) String() string {
+	return strings.Join(*l, ", ")
+}
+
+func (l *pathList) Set(path string) error {
+	*l = append(*l, path)
+	return nil
+}
+
+// collectAttachments reads the files and directory listings attached with
+// --file and --dir, within general.attach_max_bytes, and reports those that
+// were cut or left out. It returns nil when nothing was attached.
+func collectAttachments(consoleUI *ui.ConsoleUI, files, dirs []string, budget int) (*attach.Document, error) {
+	if len(files) == 0 && len(dirs) == 0 {
+		return nil, nil
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := attach.Collect(cwd, files, dirs, budget)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range doc.Items {
+		switch {
+		case it.Skipped != "":
+			consoleUI.PrintColored(consoleUI.Yellow, "⚠️  Not attached: %s (%s)\n", it.Path, it.Skipped)
+		case it.Kept < it.Size:
+			consoleUI.PrintColored(consoleUI.Yellow, "⚠️  Attached %s of %s: %s (general.attach_max_bytes = %d)\n", maintenance.FormatSize(it.Kept), maintenance.FormatSize(it.Size), it.Path, budget)
+		}
+	}
+	return doc, nil
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// runAudit implements `og audit`, which queries the execution audit log.
+func runAudit(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("audit", flag.ContinueOnError)
+	session := fs.String("session", "", "only entries of this session (hash or prefix)")
+	tool := fs.String("tool", "", "only entries for this tool")
+	event := fs.String("event", "", "only 'approval', 'execution', 'trust' or 'edit' entries")
+	since := fs.String("since", "", "only entries newer than a duration (e.g. 24h, 7d) or date (YYYY-MM-DD)")
+	grep := fs.String("grep", "", "only entries whose command contains this text")
+	failed := fs.Bool("failed", false, "only denied approvals and failed executions")
+	count := fs.Int("n", 50, "number of entries to show (0 for all)")
+	asJSON := fs.Bool("json", false, "print matching entries as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	var cutoff time.Time
+	if *since != "" {
+		t, err := history.ParseTimeBound(*since, time.Now())
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		cutoff = t
+	}
+
+	entries, err := audit.ReadEntries()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read audit log: %v\n", err)
+		return 1
+	}
+
+	var shown []audit.Entry
+	for _, e := range entries {
+		if *session != "" && !strings.HasPrefix(e.Session, *session) {
+			continue
+		}
+		if *tool != "" && e.Tool != *tool {
+			continue
+		}
+		if *event != "" && e.Event != *event {
+			continue
+		}
+		if *grep != "" && !strings.Contains(e.Command, *grep) {
+			continue
+		}
+		if *failed && e.Decision != "denied" && e.Status != "failure" {
+			continue
+		}
+		if !cutoff.IsZero() {
+			if ts, err := time.Parse(time.RFC3339, e.TS); err != nil || ts.Before(cutoff) {
+				continue
+			}
+		}
+		shown = append(shown, e)
+	}
+	if *count > 0 && len(shown) > *count {
+		shown = shown[len(shown)-*count:]
+	}
+
+	if *asJSON {
+		for _, e := range shown {
+			b, _ := json.Marshal(e)
+			fmt.Println(string(b))
+		}
+		return 0
+	}
+	if len(shown) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No audit entries found.\n")
+		return 0
+	}
+	width := ui.TerminalWidth()
+	for _, e := range shown {
+		prefix := fmt.Sprintf("%s  %s  %s  %s  %s  ", e.TS, shortHash(e.Session), ui.PadRight(e.Event, 9), ui.PadRight(auditOutcome(e), 11), ui.PadRight(ui.Truncate(auditApprover(e), 20), 20))
+		subject := strings.ReplaceAll(e.Command, "\n", "; ")
+		if e.Tool != "" {
+			subject = e.Tool + ": " + subject
+		}
+		fmt.Println(prefix + ui.Truncate(subject, width-ui.DisplayWidth(prefix)))
+	}
+	return 0
+}
+
+// auditOutcome summarizes an entry: the decision of an approval, the status
+// and exit code of an execution, or whether an edit changed anything.
+func auditOutcome(e audit.Entry) string {
+	if e.Event == audit.EventEdit {
+		return e.Status
+	}
+	if e.Event != audit.EventExecution {
+		return e.Decision
+	}
+	if e.ExitCode != nil {
+		return fmt.Sprintf("%s(%d)", e.Status, *e.ExitCode)
+	}
+	return e.Status
+}
+
+// auditApprover describes who (or what) approved an entry.
+func auditApprover(e audit.Entry) string {
+	switch {
+	case e.Identity != "" && e.Role != "" && e.Identity != e.Role:
+		return e.Identity + " (" + e.Role + ")"
+	case e.Identity != "":
+		return e.Identity
+	default:
+		return e.Role
+	}
+}
+
+func shortHash(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/bench"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/usage"
+)
+
+const benchUsage = "Usage: og bench --models <model,model,...> [--replay-last 10] [--timeout 5m] [--json]\n"
+
+// runBench implements `og bench`, which has each of several planner models plan
+// the requests of recent read-only sessions again, without running anything,
+// and compares their plans.
+func runBench(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	modelList := fs.String("models", "", "comma-separated planner models to compare, e.g. ollama/gemma3,ollama/llama3")
+	last := fs.Int("replay-last", 10, "how many of the most recent read-only sessions to replay")
+	timeout := fs.Duration("timeout", 5*time.Minute, "how long a model may take to plan one request")
+	asJSON := fs.Bool("json", false, "print each result and the summary as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	var models []string
+	for _, m := range strings.Split(*modelList, ",") {
+		if m = strings.TrimSpace(m); m != "" {
+			models = append(models, m)
+		}
+	}
+	if fs.NArg() > 0 || len(models) == 0 || *last < 1 || *timeout <= 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, benchUsage)
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	records, err := st.History().List()
+	st.Close()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	replay := benchSessions(records, cfg.Storage.User, *last)
+	if len(replay) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No read-only sessions in the history to replay.\n")
+		return 0
+	}
+	if !*asJSON {
+		consoleUI.PrintColored(consoleUI.Blue, "🏁 Planning %d request(s) with %d model(s); nothing is run.\n", len(replay), len(models))
+	}
+
+	var results []bench.Result
+	for i, rec := range replay {
+		if !*asJSON {
+			fmt.Printf("\n%s %s\n", consoleUI.Yellow(fmt.Sprintf("[%d/%d]", i+1, len(replay))), ui.Truncate(oneLine(rec.Query), 100))
+		}
+		for _, m := range models {
+			r := bench.Plan(cfg, consoleUI, rec, m, *timeout)
+			results = append(results, r)
+			if !*asJSON {
+				printBenchResult(consoleUI, r)
+			}
+		}
+	}
+
+	summaries := bench.Summarize(models, results)
+	if *asJSON {
+		b, _ := json.MarshalIndent(map[string]interface{}{"results": results, "summary": summaries}, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	fmt.Println(consoleUI.Yellow("\nSummary:"))
+	fmt.Printf("  %s %8s %7s %6s %6s %6s %8s  %s\n", ui.PadRight("MODEL", 28), "PLANNED", "UNSAFE", "FAILED", "STEPS", "FLAGS", "LATENCY", "TOKENS IN / OUT")
+	for _, s := range summaries {
+		fmt.Printf("  %s %4d/%-3d %7d %6d %6.1f %6d %7.1fs  %s / %s\n", ui.PadRight(ui.Truncate(s.Model, 28), 28),
+			s.Planned, s.Sessions, s.Unsafe, s.Failed, s.AvgSteps, s.Flags, s.AvgSeconds,
+			usage.FormatCount(s.PromptTokens), usage.FormatCount(s.CompletionTokens))
+	}
+	fmt.Println("  STEPS is the average over the plans made; LATENCY is the average time to a plan or a failure.")
+	return 0
+}
+
+// benchSessions returns up to n of user's most recent read-only sessions, oldest
+// first, leaving out those that repeat a request in the same directory.
+func benchSessions(records []history.HistoryRecord, user string, n int) []history.HistoryRecord {
+	var out []history.HistoryRecord
+	seen := map[string]bool{}
+	for i := len(records) - 1; i >= 0 && len(out) < n; i-- {
+		rec := records[i]
+		if rec.User != "" && rec.User != user {
+			continue
+		}
+		key := rec.CWD + "\x00" + rec.Query
+		if seen[key] || !bench.ReadOnly(rec) {
+			continue
+		}
+		seen[key] = true
+		out = append(out, rec)
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return out
+}
+
+// printBenchResult prints how a model planned a request, on one line.
+func printBenchResult(consoleUI *ui.ConsoleUI, r bench.Result) {
+	name := ui.PadRight(ui.Truncate(r.Model, 28), 28)
+	tokens := fmt.Sprintf("%s in / %s out", usage.FormatCount(r.PromptTokens), usage.FormatCount(r.CompletionTokens))
+	switch {
+	case r.Planned:
+		fmt.Printf("  %s %s %d step(s), %d flag(s), %.1fs, %s\n", name, consoleUI.Green("✅"), r.Steps, r.Flags, r.Seconds, tokens)
+	case r.Unsafe:
+		fmt.Printf("  %s %s unsafe per the auditor, %.1fs, %s\n", name, consoleUI.Yellow("⚠️ "), r.Seconds, tokens)
+	default:
+		fmt.Printf("  %s %s %s (%.1fs)\n", name, consoleUI.Red("❌"), r.Error, r.Seconds)
+	}
+}
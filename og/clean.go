@@ -0,0 +1,138 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/maintenance"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const cleanUsage = "Usage: og clean [--cache] [--history] [--older-than 30d] [--dry-run]\n"
+
+// largestShown is how many of the sessions taking the most space --dry-run lists.
+const largestShown = 5
+
+// runClean implements `og clean`: removing old cache files, logs and crash
+// bundles (--cache) and old sessions from the history, with everything they left
+// on disk (--history).
+func runClean(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("clean", flag.ContinueOnError)
+	cache := fs.Bool("cache", false, "remove session JSON, agent and session logs, crash bundles and leftover artifact directories")
+	hist := fs.Bool("history", false, "remove history records, stored transcripts, index entries, artifacts and logs of old sessions")
+	olderThan := fs.String("older-than", "30d", "only remove what is older than a duration (e.g. 30d, 2w) or date (YYYY-MM-DD)")
+	dryRun := fs.Bool("dry-run", false, "list what would be removed without removing it")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() > 0 || (!*cache && !*hist) {
+		consoleUI.PrintColored(consoleUI.Yellow, cleanUsage)
+		return 1
+	}
+	threshold, err := history.ParseTimeBound(*olderThan, time.Now())
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Invalid --older-than: %v\n", err)
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	verb := "Deleted"
+	if *dryRun {
+		verb = "Would delete"
+	}
+	consoleUI.PrintColored(consoleUI.Blue, "Cleaning up what is older than %s...\n", threshold.Format("2006-01-02 15:04:05"))
+
+	failed := false
+	var usage []maintenance.SessionUsage
+	if *cache {
+		var items []maintenance.Item
+		files, err := maintenance.ExpiredCacheFiles(cfg.Cache.Directory, threshold)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		items = append(items, files...)
+		// Dir("") is the root that holds every session's artifact directory
+		dirs, err := maintenance.ExpiredArtifactDirs(st.Artifacts().Dir(""), threshold)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		items = append(items, dirs...)
+
+		var total int64
+		removed := 0
+		for _, item := range items {
+			if !*dryRun {
+				if err := maintenance.Remove(item); err != nil {
+					consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+					failed = true
+					continue
+				}
+			}
+			removed++
+			total += item.Size
+			fmt.Printf("%s %s  %s  %s\n", verb, consoleUI.Cyan(item.Path), item.ModTime.Format("2006-01-02"), maintenance.FormatSize(item.Size))
+		}
+		consoleUI.PrintColored(consoleUI.Green, "Cache: %s %d item(s), %s.\n", verb, removed, maintenance.FormatSize(total))
+		usage = append(usage, maintenance.GroupBySession(items)...)
+	}
+
+	if *hist {
+		records, err := st.History().List()
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+			return 1
+		}
+		expired := maintenance.ExpiredSessions(records, threshold)
+		hashes := make([]string, len(expired))
+		for i, rec := range expired {
+			hashes[i] = rec.Hash
+		}
+		sessions, err := maintenance.SessionsUsage(st, cfg.Cache.Directory, hashes)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to size sessions: %v\n", err)
+			return 1
+		}
+		var total int64
+		for i, rec := range expired {
+			total += sessions[i].Size
+			fmt.Printf("%s session %s  %s  %s  %s\n", verb, rec.Hash, rec.TS, maintenance.FormatSize(sessions[i].Size), ui.Truncate(rec.Query, 60))
+		}
+		if !*dryRun && len(hashes) > 0 {
+			if err := maintenance.PruneSessions(st, cfg.Cache.Directory, hashes); err != nil {
+				consoleUI.PrintColored(consoleUI.Red, "Failed to remove sessions from history: %v\n", err)
+				return 1
+			}
+		}
+		consoleUI.PrintColored(consoleUI.Green, "History: %s %d of %d session(s) from the '%s' store, %s.\n", verb, len(hashes), len(records), store.BackendName(cfg), maintenance.FormatSize(total))
+		usage = append(usage, sessions...)
+	}
+
+	if *dryRun {
+		if largest := maintenance.Largest(usage, largestShown); len(largest) > 0 && largest[0].Size > 0 {
+			consoleUI.PrintColored(consoleUI.Blue, "Sessions taking the most space:\n")
+			for _, u := range largest {
+				if u.Size == 0 {
+					break
+				}
+				fmt.Printf("  %s  %s in %d item(s)\n", consoleUI.Cyan(u.Hash), maintenance.FormatSize(u.Size), len(u.Items))
+			}
+		}
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
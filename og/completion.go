@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/dbquery"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/timeline"
+	"github.com/robbiemu/original_gangster/og/internal/transcript"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const completionUsage = "Usage: og completion bash|zsh|fish\n"
+
+// completer returns candidates for a position on the command line, each a value
+// optionally followed by a tab and a description. cfg may be nil.
+type completer func(cfg *config.OGConfig) []string
+
+// anyValue marks flags that take a value which cannot be completed (a duration,
+// a search string, ...); the shell then falls back to file names.
+func anyValue(*config.OGConfig) []string { return nil }
+
+func words(values ...string) completer {
+	return func(*config.OGConfig) []string { return values }
+}
+
+// command describes the completions of a command or subcommand action. flags maps
+// each flag to its value completer, or nil for boolean flags.
+type command struct {
+	actions  map[string]*command
+	flags    map[string]completer
+	arg      completer // First positional argument
+	operands operands  // The positional arguments it takes; see subcommandLine
+}
+
+var (
+	userFlags = map[string]completer{"user": anyValue, "all-users": nil}
+	sinceFlag = map[string]completer{"since": anyValue, "until": anyValue}
+	statuses  = words("completed", "denied", "quit", "unsafe", "model_unreachable", "tool_failed", "protocol_error", "hook_failed", "aborted", "error", "failed", "incomplete")
+)
+
+// completionSpec mirrors the flags and operands of the subcommands, which
+// subcommandLine also relies on to tell a subcommand from a prompt. Keep it in
+// sync when a subcommand gains a flag, action or argument.
+var completionSpec = &command{
+	flags: map[string]completer{
+		"help": nil, "h": nil, "version": nil, "sandbox-copy": nil, "read-only": nil, "strict-config": nil, "no-stdin": nil, "ascii": nil,
+		"prompt": nil, "p": nil, "file": anyValue, "dir": anyValue, "no-last-command": nil,
+		"verbosity": words("debug", "info", "warn", "none"),
+	},
+	actions: map[string]*command{
+		"init":     {},
+		"continue": {operands: anyOperands},
+		"version":  {flags: map[string]completer{"json": nil}},
+		"completion": {
+			arg: words("bash", "zsh", "fish"), operands: argOperand,
+		},
+		"hook": {
+			arg: words("bash", "zsh", "fish"), operands: argOperand,
+		},
+		"audit": {flags: map[string]completer{
+			"session": completeHashes, "tool": anyValue, "event": words("approval", "execution", "trust", "edit"),
+			"since": anyValue, "grep": anyValue, "failed": nil, "n": anyValue, "json": nil,
+		}},
+		"bench": {flags: map[string]completer{"models": anyValue, "replay-last": anyValue, "timeout": anyValue, "json": nil}},
+		"config": {actions: map[string]*command{
+			"diff":     {flags: map[string]completer{"all": nil}},
+			"rollback": {arg: completeConfigBackups, flags: map[string]completer{"list": nil}, operands: oneOperand},
+		}},
+		"prompts": {actions: map[string]*command{"diff": {flags: map[string]completer{"all": nil}}}},
+		"clean":   {flags: map[string]completer{"cache": nil, "history": nil, "older-than": anyValue, "dry-run": nil}},
+		"db": {actions: map[string]*command{
+			"list":    {},
+			"set-dsn": {arg: completeDatabases, operands: anyOperands},
+			"query":   {arg: completeDatabases, operands: anyOperands},
+		}},
+		"distill": {flags: map[string]completer{"min": anyValue, "name": anyValue, "makefile": nil, "taskfile": nil}},
+		"daemon":  {actions: map[string]*command{"start": {}, "status": {}, "stop": {}}},
+		"debug": {actions: map[string]*command{
+			"tail": {flags: map[string]completer{"n": anyValue, "f": nil, "og": nil}, arg: completeHashes, operands: hashOperand},
+		}},
+		"export": {
+			flags:    map[string]completer{"format": words(transcript.Formats...), "script": nil, "o": anyValue},
+			arg:      completeHashes,
+			operands: hashOperand,
+		},
+		"history": {actions: map[string]*command{
+			"list": {flags: merge(userFlags, map[string]completer{"n": anyValue})},
+			"search": {flags: merge(userFlags, sinceFlag, map[string]completer{
+				"cwd": anyValue, "status": statuses, "n": anyValue,
+			}), operands: anyOperands},
+			"show":   {arg: completeHashes, operands: hashOperand},
+			"steps":  {flags: map[string]completer{"v": nil}, arg: completeHashes, operands: hashOperand},
+			"export": {flags: map[string]completer{"o": anyValue}},
+		}},
+		"policy": {actions: map[string]*command{
+			"test": {flags: map[string]completer{"n": anyValue, "v": nil}, operands: oneOperand},
+		}},
+		"refusals": {flags: map[string]completer{"forget": anyValue, "clear": nil}, operands: oneOperand},
+		"resume":   {arg: completeHashes, operands: hashOperand},
+		"selftest": {},
+		"stats":    {flags: merge(userFlags, sinceFlag, map[string]completer{"json": nil})},
+		"timeline": {flags: merge(userFlags, sinceFlag, map[string]completer{
+			"cwd": anyValue, "format": words(timeline.Formats...), "o": anyValue,
+		})},
+		"trust": {flags: map[string]completer{"for": anyValue, "list": nil, "revoke": nil}, operands: oneOperand},
+		"undo":  {flags: map[string]completer{"list": nil}, arg: completeHashes, operands: hashOperand},
+	},
+}
+
+func merge(maps ...map[string]completer) map[string]completer {
+	merged := map[string]completer{}
+	for _, m := range maps {
+		for k, v := range m {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// maxCompletedHashes bounds how many recent sessions are offered.
+const maxCompletedHashes = 200
+
+// completeHashes offers the hashes of recent sessions, newest first, described by their query.
+func completeHashes(cfg *config.OGConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+	st, err := store.Open(cfg)
+	if err != nil {
+		return nil
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		return nil
+	}
+	var out []string
+	for i := len(records) - 1; i >= 0 && len(out) < maxCompletedHashes; i-- {
+		query := strings.Join(strings.Fields(records[i].Query), " ")
+		out = append(out, records[i].Hash+"\t"+ui.Truncate(query, 60))
+	}
+	return out
+}
+
+func completeConfigBackups(*config.OGConfig) []string {
+	backups, _ := config.ListConfigBackups()
+	out := make([]string, len(backups))
+	for i, b := range backups {
+		out[i] = filepath.Base(b)
+	}
+	return out
+}
+
+func completeDatabases(cfg *config.OGConfig) []string {
+	if cfg == nil {
+		return nil
+	}
+	return dbquery.Names(cfg.Databases)
+}
+
+// complete returns the candidates for the last of args, given the words before it.
+func complete(cfg *config.OGConfig, args []string) []string {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	current, done := args[len(args)-1], args[:len(args)-1]
+	cmd := completionSpec
+	positional := 0
+	for i := 0; i < len(done); i++ {
+		w := done[i]
+		if name, ok := flagName(w); ok {
+			if value, known := cmd.flags[name]; known && value != nil && !strings.Contains(w, "=") {
+				i++ // Skip the flag's value
+			}
+			continue
+		}
+		if sub, ok := cmd.actions[w]; ok && positional == 0 {
+			cmd = sub
+			continue
+		}
+		positional++
+	}
+
+	// The value of a flag
+	if n := len(done); n > 0 {
+		if name, ok := flagName(done[n-1]); ok && !strings.Contains(done[n-1], "=") {
+			if value := cmd.flags[name]; value != nil {
+				return value(cfg)
+			}
+		}
+	}
+	if strings.HasPrefix(current, "-") {
+		var out []string
+		for name := range cmd.flags {
+			if len(name) == 1 {
+				out = append(out, "-"+name)
+			} else {
+				out = append(out, "--"+name)
+			}
+		}
+		sort.Strings(out)
+		return out
+	}
+	if positional > 0 {
+		return nil
+	}
+	out := make([]string, 0, len(cmd.actions))
+	for name := range cmd.actions {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	if cmd.arg != nil {
+		out = append(out, cmd.arg(cfg)...)
+	}
+	return out
+}
+
+// flagName returns the name of a flag argument ("-n", "--since=7d").
+func flagName(w string) (string, bool) {
+	if len(w) < 2 || w[0] != '-' || w == "--" {
+		return "", false
+	}
+	name := strings.TrimLeft(w, "-")
+	name, _, _ = strings.Cut(name, "=")
+	return name, true
+}
+
+// runComplete implements the hidden `og __complete <words...>` used by the
+// completion scripts: it prints the candidates for the last word, one per line.
+// An empty result lets the shell complete file names.
+func runComplete(cfg *config.OGConfig, args []string) int {
+	for _, c := range complete(cfg, args) {
+		fmt.Println(c)
+	}
+	return 0
+}
+
+// runCompletion implements `og completion bash|zsh|fish`, which prints a script
+// that completes subcommands, flags and session hashes by calling back into og.
+func runCompletion(consoleUI *ui.ConsoleUI, args []string) int {
+	shells := map[string]string{"bash": bashCompletion, "zsh": zshCompletion, "fish": fishCompletion}
+	if len(args) != 1 || shells[args[0]] == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, completionUsage)
+		return 1
+	}
+	fmt.Fprint(os.Stdout, shells[args[0]])
+	return 0
+}
+
+// isCompletionCommand reports whether args start with a command handled before
+// the config is loaded.
+func isCompletionCommand(args []string) bool {
+	return len(args) >= 1 && slices.Contains([]string{"completion", "__complete"}, args[0])
+}
+
+const bashCompletion = `# og bash completion. Load it with:
+#   source <(og completion bash)
+_og_complete() {
+    local cur=${COMP_WORDS[COMP_CWORD]}
+    local IFS=$'\n'
+    local candidates
+    candidates=($(og __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null | cut -f1))
+    if [ ${#candidates[@]} -eq 0 ]; then
+        COMPREPLY=($(compgen -f -- "$cur"))
+    else
+        COMPREPLY=($(compgen -W "${candidates[*]}" -- "$cur"))
+    fi
+}
+complete -o filenames -F _og_complete og
+`
+
+const zshCompletion = `#compdef og
+# og zsh completion. Load it with:
+#   source <(og completion zsh)
+# or save it as _og in a directory of your $fpath.
+_og() {
+    local -a candidates
+    local line
+    for line in "${(@f)$(og __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}"; do
+        [[ -n $line ]] && candidates+=("${line/$'\t'/:}")
+    done
+    if (( ${#candidates} )); then
+        _describe 'og' candidates
+    else
+        _files
+    fi
+}
+if [ "$funcstack[1]" = "_og" ]; then
+    _og "$@"
+else
+    compdef _og og
+fi
+`
+
+const fishCompletion = `# og fish completion. Load it with:
+#   og completion fish | source
+# or save it as ~/.config/fish/completions/og.fish
+function __og_complete
+    set -l tokens (commandline -opc)
+    set -e tokens[1]
+    set -l cur (commandline -ct)
+    set -l candidates (og __complete $tokens "$cur" 2>/dev/null)
+    if test (count $candidates) -eq 0
+        __fish_complete_path "$cur"
+    else
+        printf '%s\n' $candidates
+    end
+end
+complete -c og -f -a '(__og_complete)'
+`
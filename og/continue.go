@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/transcript"
+)
+
+// continueCommand starts og continue <prompt>, which follows up on the most
+// recent session: "now also do X".
+const continueCommand = "continue"
+
+// The output of the earlier session's last steps is given to the agent, each
+// cut to its beginning.
+const (
+	maxContinuedSteps  = 20
+	maxContinuedOutput = 1500
+)
+
+// continuation is an earlier session that a new one follows up on.
+type continuation struct {
+	rec     history.HistoryRecord
+	context string // What the earlier session did, for the agent
+}
+
+// lastSession returns the most recent session of the user in the history, with
+// what it did: its request, plan, the commands it ran and their output, and its
+// answer, as far as they were kept.
+func lastSession(cfg *config.OGConfig, st store.Store, redactor *redact.Redactor) (*continuation, error) {
+	records, err := st.History().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	var rec *history.HistoryRecord
+	for i := len(records) - 1; i >= 0; i-- {
+		if records[i].User == "" || records[i].User == cfg.Storage.User {
+			rec = &records[i]
+			break
+		}
+	}
+	if rec == nil {
+		return nil, fmt.Errorf("there is no earlier session to continue")
+	}
+	// Like og export, a session is continued with whatever was kept of it
+	data, err := st.Transcripts().Get(rec.Hash)
+	if err != nil {
+		data = nil
+	}
+	var steps []store.Step
+	if sessions := store.Sessions(st); sessions != nil {
+		steps, _ = sessions.Steps(rec.Hash)
+	}
+	status := ""
+	if statuses, err := history.Statuses(); err == nil {
+		status = statuses[rec.Hash]
+	}
+	doc, err := transcript.Build(*rec, status, data, steps, nil)
+	if err != nil {
+		return nil, err
+	}
+	doc.Redact(redactor.String)
+	return &continuation{rec: *rec, context: continuationContext(doc)}, nil
+}
+
+// continuationContext describes an earlier session to the agent of the session
+// that follows up on it.
+func continuationContext(doc *transcript.Document) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "This request follows up on the user's previous og session, which ran in %s at %s", doc.CWD, doc.TS)
+	if doc.Status != "" {
+		fmt.Fprintf(&b, " and ended as %s", doc.Status)
+	}
+	fmt.Fprintf(&b, ".\nIts request: %q\n", doc.Query)
+	if len(doc.Plan) > 0 {
+		b.WriteString("Its plan:\n")
+		for i, p := range doc.Plan {
+			fmt.Fprintf(&b, "%d. %s: %s\n", i+1, p.Description, strings.ReplaceAll(p.Action, "\n", "; "))
+		}
+	}
+	steps := doc.Steps
+	if len(steps) > maxContinuedSteps {
+		fmt.Fprintf(&b, "It ran %d actions; the last %d were:\n", len(steps), maxContinuedSteps)
+		steps = steps[len(steps)-maxContinuedSteps:]
+	} else if len(steps) > 0 {
+		b.WriteString("The actions it ran:\n")
+	}
+	for _, s := range steps {
+		fmt.Fprintf(&b, "- %s: %s", s.Tool, s.Command)
+		if s.Status != "" {
+			fmt.Fprintf(&b, " (%s", s.Status)
+			if s.ExitCode != nil {
+				fmt.Fprintf(&b, ", exit %d", *s.ExitCode)
+			}
+			b.WriteString(")")
+		}
+		b.WriteString("\n")
+		if out := strings.TrimSpace(s.Output); out != "" {
+			if len(out) > maxContinuedOutput {
+				out = strings.ToValidUTF8(out[:maxContinuedOutput], "") + "\n[... cut]"
+			}
+			b.WriteString("  Output:\n  " + strings.ReplaceAll(out, "\n", "\n  ") + "\n")
+		}
+	}
+	if doc.Summary != nil {
+		fmt.Fprintf(&b, "Its answer:\n%s\n", strings.TrimSpace(doc.Summary.Text))
+	}
+	return b.String()
+}
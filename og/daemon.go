@@ -0,0 +1,92 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const daemonUsage = "Usage: og daemon [start|status|stop]\n"
+
+// runDaemon implements `og daemon`, which keeps an agent warm for the next
+// session. Sessions use it whenever it is running and start their own agent
+// otherwise.
+func runDaemon(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	action := "start"
+	if len(args) > 0 {
+		action = args[0]
+	}
+	path, err := agent.DaemonSocketPath()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to locate the daemon socket: %v\n", err)
+		return 1
+	}
+	switch action {
+	case "start":
+		return runDaemonStart(consoleUI, cfg, path)
+	case "status":
+		status, err := agent.QueryDaemon(path)
+		if errors.Is(err, os.ErrNotExist) {
+			consoleUI.PrintColored(consoleUI.Yellow, "og daemon is not running; sessions start their own agent.\n")
+			return 1
+		}
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		fmt.Printf("og daemon %d, up %s, listening at %s\n", status.Pid, time.Since(status.Since).Round(time.Second), path)
+		fmt.Printf("  agent:    %s\n  python:   %s\n  sessions: %d\n", status.Agent, status.Interpreter, status.Served)
+		if status.Waiting {
+			fmt.Printf("  %s\n", consoleUI.Green("a warm agent is waiting"))
+		} else {
+			fmt.Printf("  %s\n", consoleUI.Yellow("the next agent is starting"))
+		}
+		return 0
+	case "stop":
+		err := agent.StopDaemon(path)
+		if errors.Is(err, os.ErrNotExist) {
+			consoleUI.PrintColored(consoleUI.Yellow, "og daemon is not running.\n")
+			return 0
+		}
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		consoleUI.PrintColored(consoleUI.Green, "og daemon stopped.\n")
+		return 0
+	default:
+		consoleUI.PrintColored(consoleUI.Yellow, daemonUsage)
+		return 1
+	}
+}
+
+// runDaemonStart runs og daemon in the foreground until it is interrupted or
+// stopped with `og daemon stop`.
+func runDaemonStart(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, path string) int {
+	d, err := agent.NewDaemon(cfg, consoleUI, path)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to start og daemon: %v\n", err)
+		return 1
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		d.Stop()
+	}()
+	consoleUI.PrintColored(consoleUI.Green, "🔥 og daemon is listening at %s; sessions now start with a warm agent.\n", consoleUI.Cyan(path))
+	if err := d.Serve(); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "og daemon failed: %v\n", err)
+		d.Stop()
+		return 1
+	}
+	consoleUI.PrintColored(consoleUI.Green, "og daemon stopped.\n")
+	return 0
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+	"golang.org/x/term"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/dbquery"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const dbUsage = "Usage: og db list\n       og db set-dsn <name>\n       og db query <name> <sql>\n"
+
+// runDB implements `og db <action>` for the databases in [databases].
+func runDB(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) < 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, dbUsage)
+		return 1
+	}
+	switch args[0] {
+	case "list":
+		return runDBList(consoleUI, cfg)
+	case "set-dsn":
+		return runDBSetDSN(consoleUI, cfg, args[1:])
+	case "query":
+		return runDBQuery(consoleUI, cfg, args[1:])
+	default:
+		consoleUI.PrintColored(consoleUI.Red, "Unknown db action '%s'\n", args[0])
+		return 1
+	}
+}
+
+// runDBList prints the configured databases and where their DSN comes from.
+func runDBList(consoleUI *ui.ConsoleUI, cfg *config.OGConfig) int {
+	if len(cfg.Databases) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No databases configured. Add a [databases.<name>] section to the config.\n")
+		return 0
+	}
+	for _, name := range dbquery.Names(cfg.Databases) {
+		db := cfg.Databases[name]
+		source := "config"
+		if db.DSN == "" {
+			source = "keyring '" + dbquery.KeyringUser(name, db) + "'"
+			if _, err := keyring.Get(dbquery.KeyringService, dbquery.KeyringUser(name, db)); err != nil {
+				source += consoleUI.Red(" (not set)")
+			}
+		}
+		mode := "read-only"
+		if db.AllowWrites {
+			mode = consoleUI.Yellow("writes allowed")
+		}
+		fmt.Printf("%s  %s  %s  DSN from %s\n", ui.PadRight(name, 16), ui.PadRight(db.Driver, 8), mode, source)
+	}
+	return 0
+}
+
+// runDBSetDSN stores a database's DSN in the keyring, read without echo from the
+// terminal or from stdin when it is not a terminal.
+func runDBSetDSN(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) != 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, dbUsage)
+		return 1
+	}
+	name := args[0]
+	db, ok := cfg.Databases[name]
+	if !ok {
+		consoleUI.PrintColored(consoleUI.Red, "Database '%s' is not configured. Add a [databases.%s] section first.\n", name, name)
+		return 1
+	}
+
+	var dsn string
+	if fd := int(os.Stdin.Fd()); term.IsTerminal(fd) {
+		fmt.Printf("DSN for %s: ", name)
+		b, err := term.ReadPassword(fd)
+		fmt.Println()
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to read DSN: %v\n", err)
+			return 1
+		}
+		dsn = string(b)
+	} else {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to read DSN from stdin: %v\n", err)
+			return 1
+		}
+		dsn = line
+	}
+	dsn = strings.TrimSpace(dsn)
+	if dsn == "" {
+		consoleUI.PrintColored(consoleUI.Red, "Empty DSN; nothing stored.\n")
+		return 1
+	}
+	if err := keyring.Set(dbquery.KeyringService, dbquery.KeyringUser(name, db), dsn); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to store DSN in the keyring: %v\n", err)
+		return 1
+	}
+	consoleUI.PrintColored(consoleUI.Green, "✨ Stored the DSN of '%s' in the keyring.\n", name)
+	return 0
+}
+
+// runDBQuery runs a query the way sql_query_tool does, to check a database's setup.
+func runDBQuery(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) < 2 {
+		consoleUI.PrintColored(consoleUI.Yellow, dbUsage)
+		return 1
+	}
+	name := args[0]
+	dbCfg, ok := cfg.Databases[name]
+	if !ok {
+		consoleUI.PrintColored(consoleUI.Red, "Database '%s' is not configured.\n", name)
+		return 1
+	}
+	db, err := dbquery.Open(name, dbCfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	defer db.Close()
+	res, err := dbquery.Run(db, dbCfg, strings.Join(args[1:], " "))
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Query failed: %v\n", err)
+		return 1
+	}
+	fmt.Println(res.Format())
+	return 0
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/sessionlog"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// runDebug implements `og debug <action>`.
+func runDebug(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) < 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, "Usage: og debug tail <hash> [-n lines] [-f] [--og]\n")
+		return 1
+	}
+	switch args[0] {
+	case "tail":
+		return runDebugTail(consoleUI, cfg, args[1:])
+	default:
+		consoleUI.PrintColored(consoleUI.Red, "Unknown debug action '%s'\n", args[0])
+		return 1
+	}
+}
+
+// runDebugTail prints the end of a session's agent log, or with --og of og's
+// own session log, optionally following it.
+func runDebugTail(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("debug tail", flag.ContinueOnError)
+	lines := fs.Int("n", 20, "number of lines to show")
+	follow := fs.Bool("f", false, "keep printing new lines as they are written")
+	ogLog := fs.Bool("og", false, "show og's session log instead of the agent's")
+	hash, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if hash == "" && fs.NArg() > 0 {
+		hash = fs.Arg(0)
+	}
+	if hash == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, "Usage: og debug tail <hash> [-n lines] [-f] [--og]\n")
+		return 1
+	}
+
+	entry, _ := history.LookupIndexEntry(hash)
+	name, path := "agent log", agent.AgentLogPath(cfg.Cache.Directory, hash)
+	if entry.AgentLogPath != "" {
+		path = entry.AgentLogPath
+	}
+	if *ogLog {
+		name, path = "session log", sessionlog.Path(cfg.Cache.Directory, hash)
+		if entry.LogPath != "" {
+			path = entry.LogPath
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open %s: %v\n", name, err)
+		if *ogLog && !cfg.Log.File {
+			consoleUI.PrintColored(consoleUI.Yellow, "Session logs are only written when 'log.file' is enabled.\n")
+		} else if !*ogLog && !cfg.Cache.JSONLogs {
+			consoleUI.PrintColored(consoleUI.Yellow, "Agent logs are only written when 'cache.json_logs' is enabled.\n")
+		}
+		return 1
+	}
+	defer f.Close()
+
+	// Keep only the last N lines in a ring buffer.
+	ring := make([]string, 0, *lines)
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" && *lines > 0 {
+			if len(ring) == *lines {
+				ring = ring[1:]
+			}
+			ring = append(ring, line)
+		}
+		if err != nil {
+			break
+		}
+	}
+	for _, line := range ring {
+		fmt.Print(line)
+	}
+
+	if !*follow {
+		return 0
+	}
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			fmt.Print(line)
+		}
+		if err == io.EOF {
+			time.Sleep(500 * time.Millisecond)
+			continue
+		}
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Error reading %s: %v\n", name, err)
+			return 1
+		}
+	}
+}
+
+// splitPositional pulls a leading positional argument off args so flags may
+// follow it (the flag package stops parsing at the first non-flag argument).
+func splitPositional(args []string) (string, []string) {
+	if len(args) > 0 && len(args[0]) > 0 && args[0][0] != '-' {
+		return args[0], args[1:]
+	}
+	return "", args
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/configdiff"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const (
+	configUsage  = "Usage: og config diff [--all] | og config rollback [--list] [<backup>]\n"
+	promptsUsage = "Usage: og prompts diff [--all]\n"
+)
+
+// runConfig implements `og config <action>`. It does not need a loaded config,
+// so that a config this og fails to load can still be compared.
+func runConfig(consoleUI *ui.ConsoleUI, _ *config.OGConfig, args []string) int {
+	if len(args) >= 1 && args[0] == "rollback" {
+		return runConfigRollback(consoleUI, args[1:])
+	}
+	if len(args) < 1 || args[0] != "diff" {
+		consoleUI.PrintColored(consoleUI.Yellow, configUsage)
+		return 1
+	}
+	defaults, err := config.DefaultConfigTOML()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	path, err := config.GetConfigPath()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to determine config path: %v\n", err)
+		return 1
+	}
+	return runDiff(consoleUI, "config diff", args[1:], defaults, path)
+}
+
+// runConfigRollback implements `og config rollback`: restoring og_config.toml
+// from the newest backup, or the one named, or listing the backups.
+func runConfigRollback(consoleUI *ui.ConsoleUI, args []string) int {
+	flags := flag.NewFlagSet("config rollback", flag.ContinueOnError)
+	list := flags.Bool("list", false, "list the backups, newest first, without restoring any")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() > 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, configUsage)
+		return 1
+	}
+	backups, err := config.ListConfigBackups()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if len(backups) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "There are no backups of og_config.toml yet; og backs the config up whenever it rewrites it.\n")
+		return 1
+	}
+	if *list {
+		for _, b := range backups {
+			when := "?"
+			if t, err := config.BackupTime(b); err == nil {
+				when = t.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s  %s\n", when, consoleUI.Cyan(filepath.Base(b)))
+		}
+		return 0
+	}
+
+	backup := backups[0]
+	if flags.NArg() == 1 {
+		backup = ""
+		for _, b := range backups {
+			if name := flags.Arg(0); filepath.Base(b) == name || b == name {
+				backup = b
+			}
+		}
+		if backup == "" {
+			consoleUI.PrintColored(consoleUI.Red, "No backup named %s; `og config rollback --list` lists them.\n", flags.Arg(0))
+			return 1
+		}
+	}
+	path, err := config.GetConfigPath()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to determine config path: %v\n", err)
+		return 1
+	}
+	current, _ := os.ReadFile(path)
+	restored, err := os.ReadFile(backup)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read %s: %v\n", backup, err)
+		return 1
+	}
+	saved, err := config.RollbackConfig(backup)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if changes := configdiff.Lines(strings.TrimSuffix(string(current), "\n"), strings.TrimSuffix(string(restored), "\n"), 3); changes != "" {
+		fmt.Printf("%s\n", ui.FormatDiff(fmt.Sprintf("--- a/%s\n+++ b/%s\n%s", filepath.Base(path), filepath.Base(path), changes)))
+	}
+	consoleUI.PrintColored(consoleUI.Green, "✅ Restored %s from %s.\n", path, consoleUI.Cyan(filepath.Base(backup)))
+	if saved != "" {
+		consoleUI.PrintColored(consoleUI.Blue, "The config it replaced was backed up to %s; `og config rollback` again undoes this.\n", consoleUI.Cyan(filepath.Base(saved)))
+	}
+	return 0
+}
+
+// runPrompts implements `og prompts <action>`.
+func runPrompts(consoleUI *ui.ConsoleUI, _ *config.OGConfig, args []string) int {
+	if len(args) < 1 || args[0] != "diff" {
+		consoleUI.PrintColored(consoleUI.Yellow, promptsUsage)
+		return 1
+	}
+	defaults, err := embeddedPromptsFS.ReadFile("prompts/prompts.toml")
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read the built-in prompts: %v\n", err)
+		return 1
+	}
+	path, err := config.GetPromptsPath()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to determine prompts path: %v\n", err)
+		return 1
+	}
+	return runDiff(consoleUI, "prompts diff", args[1:], defaults, path)
+}
+
+// runDiff prints how the file at path differs from the defaults of this og.
+func runDiff(consoleUI *ui.ConsoleUI, name string, args []string, defaults []byte, path string) int {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	all := flags.Bool("all", false, "also list the default settings the file leaves out")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+
+	yours, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		consoleUI.PrintColored(consoleUI.Yellow, "%s does not exist, so the defaults apply. `og init` writes them.\n", path)
+		return 0
+	}
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read %s: %v\n", path, err)
+		return 1
+	}
+	entries, err := configdiff.Compare(defaults, yours)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to parse %s: %v\n", path, err)
+		return 1
+	}
+
+	version := currentBuildInfo().Version
+	notSet, differ := 0, 0
+	for _, e := range entries {
+		if e.Kind == configdiff.NotSet {
+			notSet++
+		} else {
+			differ++
+		}
+	}
+	if differ == 0 && (notSet == 0 || !*all) {
+		consoleUI.PrintColored(consoleUI.Green, "✅ %s matches the defaults of og %s.\n", path, version)
+	} else {
+		fmt.Println(ui.FormatDiff(configdiff.Format(entries, "og "+version+" defaults", path, *all)))
+	}
+	if notSet > 0 && !*all {
+		consoleUI.PrintColored(consoleUI.Blue, "%d default setting(s) are not in the file and apply as shipped; --all lists them.\n", notSet)
+	}
+	return 0
+}
+
+// printConfigWarnings shows the warnings LoadConfig returned, one per line.
+// With strict (og --strict-config) they are errors, and it returns false.
+func printConfigWarnings(consoleUI *ui.ConsoleUI, warnings config.Warnings, strict bool) bool {
+	if len(warnings) == 0 {
+		return true
+	}
+	colorFn, icon := consoleUI.Yellow, "⚠️ "
+	if strict {
+		colorFn, icon = consoleUI.Red, "❌"
+		consoleUI.PrintColored(colorFn, "og_config.toml has %d warning(s), which --strict-config makes errors:\n", len(warnings))
+	}
+	for _, w := range warnings {
+		consoleUI.PrintColored(colorFn, "%s og_config.toml: %s: %s\n", icon, consoleUI.Cyan(w.Key), w.Message)
+	}
+	return !strict
+}
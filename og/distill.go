@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/configdiff"
+	"github.com/robbiemu/original_gangster/og/internal/distill"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const distillUsage = "Usage: og distill [--min <n>] [--name <target>] [--makefile | --taskfile]\n"
+
+// defaultDistillMin is how many runs og distill looks for when
+// general.distill_after is 0.
+const defaultDistillMin = 3
+
+// runDistill implements `og distill`, which turns the shell commands that
+// sessions in the current directory ran several times into a Makefile target
+// or Taskfile task there, shown as a diff before it is written.
+func runDistill(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	flags := flag.NewFlagSet("distill", flag.ContinueOnError)
+	minRuns := flags.Int("min", cfg.General.DistillAfter, "how many sessions must have run the same commands")
+	name := flags.String("name", "", "the name of the target or task (suggested from the request when empty)")
+	makefile := flags.Bool("makefile", false, "write a target to the Makefile")
+	taskfile := flags.Bool("taskfile", false, "write a task to the Taskfile")
+	if err := flags.Parse(args); err != nil {
+		return 1
+	}
+	if flags.NArg() > 0 || (*makefile && *taskfile) {
+		consoleUI.PrintColored(consoleUI.Yellow, distillUsage)
+		return 1
+	}
+	if *minRuns <= 0 {
+		*minRuns = defaultDistillMin
+	}
+	dir, err := os.Getwd()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to get current working directory: %v\n", err)
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	statuses, err := history.Statuses()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read session index: %v\n", err)
+		return 1
+	}
+	runs, err := distill.Runs(st, statuses, dir, cfg.Storage.User)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	recipes := distill.Recurring(runs, *minRuns)
+	if len(recipes) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No completed sessions in %s ran the same commands %d times yet.\n", dir, *minRuns)
+		return 0
+	}
+
+	recipe := recipes[0]
+	if len(recipes) > 1 {
+		for i, r := range recipes {
+			fmt.Printf("%3d. %d runs, the latest %s: %q\n", i+1, len(r.Runs), r.Runs[len(r.Runs)-1].TS, r.Runs[len(r.Runs)-1].Query)
+			for _, c := range r.Commands {
+				fmt.Printf("       %s\n", consoleUI.Cyan(oneLine(c)))
+			}
+		}
+		answer, ok := consoleUI.PromptForInput("Which one? (number, enter for 1)")
+		if !ok {
+			return 1
+		}
+		if answer != "" {
+			n, err := strconv.Atoi(answer)
+			if err != nil || n < 1 || n > len(recipes) {
+				consoleUI.PrintColored(consoleUI.Yellow, "No recipe %s.\n", answer)
+				return 1
+			}
+			recipe = recipes[n-1]
+		}
+	}
+
+	if *name == "" {
+		*name = distill.Name(recipe)
+		answer, ok := consoleUI.PromptForInput(fmt.Sprintf("Name of the target? (enter for %s)", *name))
+		if !ok {
+			return 1
+		}
+		if answer != "" {
+			*name = answer
+		}
+	}
+	if !distill.ValidName(*name) {
+		consoleUI.PrintColored(consoleUI.Yellow, "%q cannot name a target; use letters, digits, '-', '_' and '.'.\n", *name)
+		return 1
+	}
+
+	path, render := distillTarget(dir, *makefile, *taskfile)
+	old, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read %s: %v\n", path, err)
+		return 1
+	}
+	updated, err := render(string(old), *name, recipe)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Yellow, "%v\n", err)
+		return 1
+	}
+	rel := filepath.Base(path)
+	diff := fmt.Sprintf("--- a/%s\n+++ b/%s\n%s", rel, rel, configdiff.Lines(strings.TrimSuffix(string(old), "\n"), strings.TrimSuffix(updated, "\n"), 3))
+	fmt.Printf("\n%s\n", ui.FormatDiff(diff))
+	if !consoleUI.PromptForApproval(fmt.Sprintf("Write this to %s?", path)) {
+		consoleUI.PrintColored(consoleUI.Yellow, "Nothing written.\n")
+		return 1
+	}
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := os.WriteFile(path, []byte(updated), mode); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to write %s: %v\n", path, err)
+		return 1
+	}
+	if err := distill.MarkDistilled(st.Memory(), dir, recipe.Commands, *name); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to remember the distilled recipe: %v\n", err)
+	}
+	runWith := "make " + *name
+	if path != filepath.Join(dir, "Makefile") {
+		runWith = "task " + *name
+	}
+	consoleUI.PrintColored(consoleUI.Green, "✅ Wrote %s to %s; run it with %s.\n", *name, rel, consoleUI.Cyan(runWith))
+	return 0
+}
+
+// distillTarget returns the file og distill adds to in dir and how: the
+// Makefile, unless --taskfile is given or there is a Taskfile and no Makefile.
+func distillTarget(dir string, makefile, taskfile bool) (string, func(string, string, distill.Recipe) (string, error)) {
+	mk := filepath.Join(dir, "Makefile")
+	if !makefile {
+		_, noMakefile := os.Stat(mk)
+		for _, name := range []string{"Taskfile.yml", "Taskfile.yaml"} {
+			path := filepath.Join(dir, name)
+			if _, err := os.Stat(path); err == nil && (taskfile || noMakefile != nil) {
+				return path, distill.Taskfile
+			}
+		}
+		if taskfile {
+			return filepath.Join(dir, "Taskfile.yml"), distill.Taskfile
+		}
+	}
+	return mk, distill.Makefile
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/transcript"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const exportUsage = "Usage: og export <hash> [--format md|json|html|sh] [--script] [-o file]\n"
+
+// runExport implements `og export`: writing a session's plan, approvals, command
+// outputs and final summary as a shareable transcript, or its shell commands as
+// a script that reruns them (--script).
+func runExport(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "md", "output format: "+strings.Join(transcript.Formats, ", "))
+	script := fs.Bool("script", false, "export the shell commands that ran as a standalone script (--format sh)")
+	output := fs.String("o", "", "write to this file instead of stdout")
+	hash, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if hash == "" && fs.NArg() > 0 {
+		hash = fs.Arg(0)
+	}
+	if hash == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, exportUsage)
+		return 1
+	}
+	if *script {
+		*format = "sh"
+	}
+	if !slices.Contains(transcript.Formats, *format) {
+		consoleUI.PrintColored(consoleUI.Red, "Unknown format '%s' (use %s)\n", *format, strings.Join(transcript.Formats, ", "))
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	rec, err := findSession(records, hash)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if rec.User == "" {
+		rec.User = cfg.Storage.User
+	}
+
+	// Each source is optional: a session is exported with whatever was kept of it.
+	// Warnings go to stderr since the transcript may be written to stdout.
+	warn := func(format string, a ...any) {
+		fmt.Fprint(os.Stderr, consoleUI.Yellow(fmt.Sprintf("⚠️  "+format, a...)))
+	}
+	session, err := st.Transcripts().Get(rec.Hash)
+	if err != nil {
+		session = nil
+		reason := ""
+		if !cfg.Cache.JSONLogs {
+			reason = " ('cache.json_logs' is disabled)"
+		}
+		warn("No stored session JSON%s; the transcript will lack the plan and summary.\n", reason)
+	}
+	var steps []store.Step
+	if sessions := store.Sessions(st); sessions != nil {
+		if steps, err = sessions.Steps(rec.Hash); err != nil {
+			warn("Session steps unavailable: %v\n", err)
+		}
+	}
+	entries, err := audit.ReadEntries()
+	if err != nil {
+		warn("Audit log unavailable: %v\n", err)
+	}
+	status := ""
+	if statuses, err := history.Statuses(); err == nil {
+		status = statuses[rec.Hash]
+	}
+	if *format == "sh" && status != "" && status != "completed" {
+		warn("The session ended as %s; the script only has the commands that ran.\n", status)
+	}
+
+	doc, err := transcript.Build(rec, status, session, steps, entries)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	// Transcripts are meant to be shared, so secrets are redacted even if they slipped into stored data
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Invalid [redaction] config: %v\n", err)
+		return 1
+	}
+	doc.Redact(redactor.String)
+	data, err := doc.Render(*format)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+
+	if *output == "" {
+		os.Stdout.Write(data)
+		return 0
+	}
+	mode := os.FileMode(0o644)
+	if *format == "sh" {
+		mode = 0o755
+	}
+	if err := os.WriteFile(*output, data, mode); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	consoleUI.PrintColored(consoleUI.Green, "Exported session %s to %s\n", shortHash(rec.Hash), consoleUI.Cyan(*output))
+	return 0
+}
+
+// findSession returns the history record of a session given its full hash or an
+// unambiguous prefix of it.
+func findSession(records []history.HistoryRecord, hashOrPrefix string) (history.HistoryRecord, error) {
+	var matches []history.HistoryRecord
+	for _, rec := range records {
+		if rec.Hash == hashOrPrefix {
+			return rec, nil
+		}
+		if strings.HasPrefix(rec.Hash, hashOrPrefix) && !slices.ContainsFunc(matches, func(m history.HistoryRecord) bool { return m.Hash == rec.Hash }) {
+			matches = append(matches, rec)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return history.HistoryRecord{}, fmt.Errorf("no session '%s' in history", hashOrPrefix)
+	case 1:
+		return matches[0], nil
+	default:
+		hashes := make([]string, len(matches))
+		for i, m := range matches {
+			hashes[i] = m.Hash
+		}
+		return history.HistoryRecord{}, fmt.Errorf("'%s' is ambiguous: matches %s", hashOrPrefix, strings.Join(hashes, ", "))
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const historyUsage = "Usage: og history list [--user name | --all-users] [-n count]\n       og history search <words> [--since 7d] [--until date] [--cwd dir] [--status s] [--user name | --all-users] [-n count]\n       og history show <hash>\n       og history steps [-v] <hash>\n       og history export [-o file]\n"
+
+// runHistory implements `og history <action>`.
+func runHistory(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) < 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, historyUsage)
+		return 1
+	}
+	switch args[0] {
+	case "list":
+		return runHistoryList(consoleUI, cfg, args[1:])
+	case "search":
+		return runHistorySearch(consoleUI, cfg, args[1:])
+	case "show":
+		return runHistoryShow(consoleUI, cfg, args[1:])
+	case "steps":
+		return runHistorySteps(consoleUI, cfg, args[1:])
+	case "export":
+		return runHistoryExport(consoleUI, cfg, args[1:])
+	default:
+		consoleUI.PrintColored(consoleUI.Red, "Unknown history action '%s'\n", args[0])
+		return 1
+	}
+}
+
+// runHistoryList prints past sessions, newest last. By default only the current
+// user's sessions are shown.
+func runHistoryList(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("history list", flag.ContinueOnError)
+	userName := fs.String("user", cfg.Storage.User, "only show sessions run by this user")
+	allUsers := fs.Bool("all-users", false, "show sessions from every user of the store")
+	count := fs.Int("n", 20, "number of sessions to show (0 for all)")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	q := history.Query{}
+	if !*allUsers {
+		q.User = *userName
+	}
+	return searchHistory(consoleUI, cfg, q, *allUsers, *count)
+}
+
+// runHistorySearch prints past sessions matching keywords and filters, newest last.
+func runHistorySearch(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("history search", flag.ContinueOnError)
+	since := fs.String("since", "", "only sessions newer than a duration (e.g. 36h, 7d) or date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only sessions older than a duration or date")
+	cwd := fs.String("cwd", "", "only sessions run in this directory or below it ('.' for the current one)")
+	status := fs.String("status", "", "only sessions that ended this way (completed, denied, quit, unsafe, model_unreachable, tool_failed, protocol_error, hook_failed, aborted, error, failed, incomplete)")
+	userName := fs.String("user", cfg.Storage.User, "only sessions run by this user")
+	allUsers := fs.Bool("all-users", false, "search sessions from every user of the store")
+	count := fs.Int("n", 20, "number of sessions to show (0 for all)")
+
+	// Words and flags may be mixed: `og history search gitignore --since 7d rust`
+	var words []string
+	rest := args
+	for {
+		if err := fs.Parse(rest); err != nil {
+			return 1
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		words = append(words, strings.Fields(fs.Arg(0))...)
+		rest = fs.Args()[1:]
+	}
+
+	q := history.Query{Words: words, Status: *status}
+	if !*allUsers {
+		q.User = *userName
+	}
+	if err := setTimeBounds(&q, *since, *until); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if *cwd != "" {
+		dir, err := filepath.Abs(*cwd)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Invalid --cwd: %v\n", err)
+			return 1
+		}
+		q.CWD = dir
+	}
+	return searchHistory(consoleUI, cfg, q, *allUsers, *count)
+}
+
+// setTimeBounds sets the Since and Until of q from --since and --until values,
+// which are durations back from now or dates. Empty values leave a bound unset.
+func setTimeBounds(q *history.Query, since, until string) error {
+	now := time.Now()
+	for _, bound := range []struct {
+		value string
+		dest  *time.Time
+	}{{since, &q.Since}, {until, &q.Until}} {
+		if bound.value == "" {
+			continue
+		}
+		t, err := history.ParseTimeBound(bound.value, now)
+		if err != nil {
+			return err
+		}
+		*bound.dest = t
+	}
+	return nil
+}
+
+// searchHistory prints the sessions matching q. Records without a user predate
+// attribution and belong to the local user.
+func searchHistory(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, q history.Query, showUser bool, count int) int {
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	for i := range records {
+		if records[i].User == "" {
+			records[i].User = cfg.Storage.User
+		}
+	}
+	statuses, err := history.Statuses()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Yellow, "⚠️  Session statuses unavailable: %v\n", err)
+	}
+
+	shown := history.Search(records, q, statuses)
+	if count > 0 && len(shown) > count {
+		shown = shown[len(shown)-count:]
+	}
+	if len(shown) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No sessions found.\n")
+		return 0
+	}
+
+	width := ui.TerminalWidth()
+	for _, rec := range shown {
+		status := statuses[rec.Hash]
+		if status == "" {
+			status = "-"
+		}
+		prefix := fmt.Sprintf("%s  %s  %s  ", rec.Hash, rec.TS, ui.PadRight(status, 10))
+		if showUser {
+			prefix += ui.PadRight(ui.Truncate(rec.User, 12), 12) + "  "
+		}
+		query := strings.ReplaceAll(rec.Query, "\n", " ")
+		if rec.Parent != "" {
+			query = "↳ " + query // A later stage of a pipeline, see runPipeline
+		}
+		fmt.Println(prefix + ui.Truncate(query, width-ui.DisplayWidth(prefix)))
+	}
+	return 0
+}
+
+// runHistoryShow prints the stored transcript of one session.
+func runHistoryShow(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) != 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, historyUsage)
+		return 1
+	}
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	data, err := st.Transcripts().Get(args[0])
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to load transcript: %v\n", err)
+		if !cfg.Cache.JSONLogs {
+			consoleUI.PrintColored(consoleUI.Yellow, "Transcripts are only stored when 'cache.json_logs' is enabled.\n")
+		}
+		return 1
+	}
+	os.Stdout.Write(data)
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		fmt.Println()
+	}
+	return 0
+}
+
+// runHistorySteps prints the actions a session executed and their results, for
+// backends that keep session metadata.
+func runHistorySteps(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("history steps", flag.ContinueOnError)
+	verbose := fs.Bool("v", false, "also print each step's output")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() != 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, historyUsage)
+		return 1
+	}
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	sessions := store.Sessions(st)
+	if sessions == nil {
+		consoleUI.PrintColored(consoleUI.Yellow, "The '%s' storage backend does not record session steps; use 'og audit --session %s'.\n", store.BackendName(cfg), fs.Arg(0))
+		return 1
+	}
+	steps, err := sessions.Steps(fs.Arg(0))
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read session steps: %v\n", err)
+		return 1
+	}
+	if len(steps) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No steps recorded for session %s.\n", fs.Arg(0))
+		return 0
+	}
+	width := ui.TerminalWidth()
+	for i, step := range steps {
+		color := consoleUI.Green
+		if step.Status != "success" {
+			color = consoleUI.Red
+		}
+		exit := "-"
+		if step.ExitCode != nil {
+			exit = fmt.Sprint(*step.ExitCode)
+		}
+		prefix := fmt.Sprintf("%2d  %s  %s  exit %-3s %6dms  %s: ", i+1, step.TS, color(ui.PadRight(step.Status, 7)), exit, step.DurationMs, step.Tool)
+		command := strings.ReplaceAll(step.Command, "\n", " ")
+		fmt.Println(prefix + ui.Truncate(command, width-ui.DisplayWidth(prefix)))
+		if *verbose && step.Output != "" {
+			fmt.Println(strings.TrimRight(step.Output, "\n"))
+			fmt.Println()
+		}
+	}
+	return 0
+}
+
+// runHistoryExport writes every history record as JSON lines in the format of
+// the filesystem backend's history.json, whichever backend is in use.
+func runHistoryExport(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("history export", flag.ContinueOnError)
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	w := os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to create %s: %v\n", *output, err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	enc := json.NewEncoder(w)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to write history: %v\n", err)
+			return 1
+		}
+	}
+	if *output != "" {
+		consoleUI.PrintColored(consoleUI.Green, "Exported %d history records to %s\n", len(records), consoleUI.Cyan(*output))
+	}
+	return 0
+}
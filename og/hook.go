@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const hookUsage = "Usage: og hook bash|zsh|fish\n"
+
+// The variables the shell hook exports before each prompt.
+const (
+	lastCommandEnv = "OG_LAST_COMMAND"
+	lastStatusEnv  = "OG_LAST_STATUS"
+)
+
+// runHook implements `og hook bash|zsh|fish`, which prints a script that
+// exports the last command run in the shell, other than og itself, and its exit
+// status, so that og can attach them to the prompt.
+func runHook(consoleUI *ui.ConsoleUI, args []string) int {
+	shells := map[string]string{"bash": bashHook, "zsh": zshHook, "fish": fishHook}
+	if len(args) != 1 || shells[args[0]] == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, hookUsage)
+		return 1
+	}
+	fmt.Fprint(os.Stdout, shells[args[0]])
+	return 0
+}
+
+// lastShellCommand returns the last command the shell hook exported, or nil
+// when the hook is not loaded.
+func lastShellCommand() *session.LastCommand {
+	command := strings.TrimSpace(os.Getenv(lastCommandEnv))
+	if command == "" {
+		return nil
+	}
+	return &session.LastCommand{Command: command, Status: strings.TrimSpace(os.Getenv(lastStatusEnv))}
+}
+
+const bashHook = `# og shell integration for bash. Load it in ~/.bashrc with:
+#   eval "$(og hook bash)"
+# Before each prompt, it exports the last command you ran and its exit status
+# (OG_LAST_COMMAND, OG_LAST_STATUS), which og attaches to its prompt.
+_og_hook() {
+    local og_status=$? og_entry og_re='^ *[0-9]+\*? +(.*)$'
+    og_entry=$(HISTTIMEFORMAT= builtin history 1)
+    [[ $og_entry == "$_og_last_entry" ]] && return
+    _og_last_entry=$og_entry
+    [[ $og_entry =~ $og_re ]] || return
+    case ${BASH_REMATCH[1]} in
+        og|og\ *) ;;
+        *) export OG_LAST_COMMAND=${BASH_REMATCH[1]} OG_LAST_STATUS=$og_status ;;
+    esac
+}
+_og_last_entry=$(HISTTIMEFORMAT= builtin history 1)
+if [[ $PROMPT_COMMAND != *_og_hook* ]]; then
+    PROMPT_COMMAND="_og_hook${PROMPT_COMMAND:+;$PROMPT_COMMAND}"
+fi
+`
+
+const zshHook = `# og shell integration for zsh. Load it in ~/.zshrc with:
+#   eval "$(og hook zsh)"
+# Before each prompt, it exports the last command you ran and its exit status
+# (OG_LAST_COMMAND, OG_LAST_STATUS), which og attaches to its prompt.
+_og_preexec() {
+    _og_command=$1
+}
+_og_precmd() {
+    local og_status=$?
+    [[ -z $_og_command ]] && return
+    case $_og_command in
+        og|og\ *) ;;
+        *) export OG_LAST_COMMAND=$_og_command OG_LAST_STATUS=$og_status ;;
+    esac
+    _og_command=
+}
+autoload -Uz add-zsh-hook
+add-zsh-hook preexec _og_preexec
+add-zsh-hook precmd _og_precmd
+`
+
+const fishHook = `# og shell integration for fish. Load it in ~/.config/fish/config.fish with:
+#   og hook fish | source
+# After each command, it exports the command and its exit status
+# (OG_LAST_COMMAND, OG_LAST_STATUS), which og attaches to its prompt.
+function __og_postexec --on-event fish_postexec
+    set -l og_status $status
+    string match -qr '^og(\s|$)' -- $argv[1]; and return
+    test -z "$argv[1]"; and return
+    set -gx OG_LAST_COMMAND $argv[1]
+    set -gx OG_LAST_STATUS $og_status
+end
+`
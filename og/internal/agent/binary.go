@@ -0,0 +1,54 @@
+package agent
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/term"
+
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// offerSaveBinary offers to save the binary output of a step to a file, as it
+// is not printed. It has no effect when stdin is not a terminal or og does not
+// have the whole output.
+func (mp *MessageProcessor) offerSaveBinary(b *ui.BinaryOutput) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) || (b.File == "" && int64(len(b.Data)) < b.Size) {
+		return
+	}
+	path, ok := mp.ui.PromptForInput("Save the binary output to a file? (a path, enter to skip)")
+	if !ok || path == "" {
+		return
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(mp.info.Workdir, path)
+	}
+	if err := saveBinary(b, path); err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "Could not save the binary output: %v\n", err)
+		return
+	}
+	mp.ui.PrintColored(mp.ui.Green, "Saved the binary output in %s\n", path)
+}
+
+// saveBinary writes the whole of b to path: its data, or a copy of the file
+// that has it.
+func saveBinary(b *ui.BinaryOutput, path string) error {
+	if b.File == "" {
+		return os.WriteFile(path, b.Data, 0o644)
+	}
+	src, err := os.Open(b.File)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
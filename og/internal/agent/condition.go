@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Condition is the condition of a recipe step, under which the step runs. The
+// planner writes it on the first line of the step as [IF <condition>], in one
+// of these forms:
+//
+//	step 3 failed             step 3 exited with a non-zero code
+//	step 3 succeeded          step 3 exited with 0
+//	step 3 exit != 0          step 3's exit code compares to a number (==, !=, <, >)
+//	exists path/to/file       the file or directory exists
+//	missing path/to/file      it does not
+//
+// Conditions are evaluated by og, not the model, when the agent reaches the step.
+type Condition struct {
+	Step int    // The earlier step whose exit code is tested, 0 for a file condition
+	Op   string // How the exit code compares to Code: ==, !=, < or >
+	Code int
+	Path string // The file tested, relative to the working directory
+	Want bool   // Whether Path must exist
+}
+
+var (
+	stepOutcome  = regexp.MustCompile(`^step\s+(\d+)\s+(failed|fails|succeeded|succeeds)$`)
+	stepExitCode = regexp.MustCompile(`^step\s+(\d+)(?:'s)?\s+exit(?:\s+code)?\s*(==|=|!=|≠|<|>)\s*(-?\d+)$`)
+	fileTest     = regexp.MustCompile(`^(exists|missing)\s+(.+)$`)
+)
+
+// ParseCondition parses the text of a step's [IF ...] line.
+func ParseCondition(text string) (Condition, error) {
+	s := strings.TrimSpace(text)
+	lower := strings.ToLower(s)
+	if m := stepOutcome.FindStringSubmatch(lower); m != nil {
+		step, _ := strconv.Atoi(m[1])
+		if strings.HasPrefix(m[2], "fail") {
+			return Condition{Step: step, Op: "!=", Code: 0}, nil
+		}
+		return Condition{Step: step, Op: "==", Code: 0}, nil
+	}
+	if m := stepExitCode.FindStringSubmatch(lower); m != nil {
+		step, _ := strconv.Atoi(m[1])
+		code, _ := strconv.Atoi(m[3])
+		op := m[2]
+		switch op {
+		case "=":
+			op = "=="
+		case "≠":
+			op = "!="
+		}
+		return Condition{Step: step, Op: op, Code: code}, nil
+	}
+	if m := fileTest.FindStringSubmatch(lower); m != nil {
+		path := strings.Trim(strings.TrimSpace(s[len(m[1]):]), `"'`) // Paths keep their case
+		return Condition{Path: path, Want: m[1] == "exists"}, nil
+	}
+	return Condition{}, fmt.Errorf("unrecognized condition %q", s)
+}
+
+// String returns the condition in the syntax ParseCondition reads.
+func (c Condition) String() string {
+	switch {
+	case c.Path != "" && c.Want:
+		return "exists " + c.Path
+	case c.Path != "":
+		return "missing " + c.Path
+	case c.Op == "!=" && c.Code == 0:
+		return fmt.Sprintf("step %d failed", c.Step)
+	case c.Op == "==" && c.Code == 0:
+		return fmt.Sprintf("step %d succeeded", c.Step)
+	default:
+		return fmt.Sprintf("step %d exit %s %d", c.Step, c.Op, c.Code)
+	}
+}
+
+// Eval reports whether the condition holds for the step numbered step, given
+// the exit codes of the steps that ran so far and the working directory, with
+// the facts it was decided on.
+func (c Condition) Eval(step int, exitCodes map[int]int, workdir string) (bool, string) {
+	if c.Path != "" {
+		path := c.Path
+		if rest, ok := strings.CutPrefix(path, "~/"); ok {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, rest)
+			}
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(workdir, path)
+		}
+		_, err := os.Stat(path)
+		if err == nil {
+			return c.Want, c.Path + " exists"
+		}
+		return !c.Want, c.Path + " does not exist"
+	}
+	if c.Step >= step {
+		return false, fmt.Sprintf("step %d can only test an earlier step", step)
+	}
+	code, ok := exitCodes[c.Step]
+	if !ok {
+		return false, fmt.Sprintf("step %d did not run", c.Step)
+	}
+	facts := fmt.Sprintf("step %d exited with %d", c.Step, code)
+	switch c.Op {
+	case "==":
+		return code == c.Code, facts
+	case "!=":
+		return code != c.Code, facts
+	case "<":
+		return code < c.Code, facts
+	default:
+		return code > c.Code, facts
+	}
+}
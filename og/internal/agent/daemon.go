@@ -0,0 +1,519 @@
+package agent
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// warmVersion is the first protocol version whose agents accept --warm: they
+// import their dependencies, then wait on stdin for the session to run.
+const warmVersion = 10
+
+// daemonRequest is what a client sends og daemon, one JSON line per message.
+type daemonRequest struct {
+	Type string `json:"type"` // launch, signal, status or stop
+
+	// launch
+	Agent       string            `json:"agent,omitempty"`       // general.python_agent_path of the client
+	Interpreter string            `json:"interpreter,omitempty"` // The Python the client chose for it
+	Argv        []string          `json:"argv,omitempty"`        // The agent's arguments, after -m <module>
+	Cwd         string            `json:"cwd,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+
+	Signal string `json:"signal,omitempty"` // interrupt, terminate or kill
+}
+
+// daemonReply is what og daemon answers, one JSON line per message.
+type daemonReply struct {
+	Type string `json:"type"` // started, output, exited, status, stopping or error
+
+	Message string `json:"message,omitempty"` // error
+	Pid     int    `json:"pid,omitempty"`     // started: the agent's; status: the daemon's
+	Warm    bool   `json:"warm,omitempty"`    // started: the agent was waiting, not started for the request
+	Line    string `json:"line,omitempty"`    // output: a line of the agent's stdout or stderr
+	Error   string `json:"error,omitempty"`   // exited: how the agent exited, "" for exit status 0
+
+	// status
+	Agent       string    `json:"agent,omitempty"`
+	Interpreter string    `json:"interpreter,omitempty"`
+	Since       time.Time `json:"since,omitzero"`
+	Served      int       `json:"served,omitempty"`
+	Waiting     bool      `json:"waiting,omitempty"` // A warm agent is waiting for the next session
+}
+
+// DaemonSocketPath returns the socket og daemon listens on, in the data directory.
+func DaemonSocketPath() (string, error) {
+	dir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "daemon.sock"), nil
+}
+
+// DaemonStatus describes a running og daemon.
+type DaemonStatus struct {
+	Pid         int
+	Agent       string
+	Interpreter string
+	Since       time.Time
+	Served      int  // Sessions it started an agent for
+	Waiting     bool // A warm agent is ready
+}
+
+// QueryDaemon returns the status of the og daemon listening at path. The
+// error is os.ErrNotExist when none is.
+func QueryDaemon(path string) (DaemonStatus, error) {
+	var reply daemonReply
+	if err := askDaemon(path, daemonRequest{Type: "status"}, &reply); err != nil {
+		return DaemonStatus{}, err
+	}
+	return DaemonStatus{reply.Pid, reply.Agent, reply.Interpreter, reply.Since, reply.Served, reply.Waiting}, nil
+}
+
+// StopDaemon asks the og daemon listening at path to exit. The error is
+// os.ErrNotExist when none is.
+func StopDaemon(path string) error {
+	var reply daemonReply
+	return askDaemon(path, daemonRequest{Type: "stop"}, &reply)
+}
+
+// askDaemon sends a request to og daemon and reads its one-line reply.
+func askDaemon(path string, req daemonRequest, reply *daemonReply) error {
+	conn, err := dialDaemon(path)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return fmt.Errorf("failed to reach og daemon: %w", err)
+	}
+	if err := json.NewDecoder(conn).Decode(reply); err != nil {
+		return fmt.Errorf("og daemon did not answer: %w", err)
+	}
+	if reply.Type == "error" {
+		return errors.New(reply.Message)
+	}
+	return nil
+}
+
+// dialDaemon connects to og daemon, failing with os.ErrNotExist when none is
+// listening at path.
+func dialDaemon(path string) (*net.UnixConn, error) {
+	if runtime.GOOS == "windows" {
+		return nil, os.ErrNotExist
+	}
+	conn, err := net.DialTimeout("unix", path, time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("og daemon is not running: %w", os.ErrNotExist)
+	}
+	return conn.(*net.UnixConn), nil
+}
+
+// warmProcess is an agent og daemon started for this client. It forwards the
+// agent's output and exit, and signals to it, over the client's connection.
+type warmProcess struct {
+	conn   *net.UnixConn
+	pid    int
+	warm   bool
+	output *io.PipeReader // The agent's stdout and stderr, line by line
+	mu     sync.Mutex     // Serializes writes to conn
+	exit   chan error     // Receives how the agent exited
+}
+
+// launchWarm asks the og daemon at path to hand a warm agent the arguments
+// argv, run in workdir with env. Commands and messages then go over the socket
+// transport, which argv must point the agent to.
+func launchWarm(path, agentPath string, interpreter Interpreter, argv []string, workdir string, env []string) (*warmProcess, error) {
+	conn, err := dialDaemon(path)
+	if err != nil {
+		return nil, err
+	}
+	environ := make(map[string]string, len(env))
+	for _, e := range env {
+		if k, v, ok := strings.Cut(e, "="); ok {
+			environ[k] = v
+		}
+	}
+	req := daemonRequest{Type: "launch", Agent: agentPath, Interpreter: interpreter.String(), Argv: argv, Cwd: workdir, Env: environ}
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to reach og daemon: %w", err)
+	}
+	dec := json.NewDecoder(conn)
+	var reply daemonReply
+	conn.SetReadDeadline(time.Now().Add(connectTimeout))
+	if err := dec.Decode(&reply); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("og daemon did not answer: %w", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+	if reply.Type != "started" {
+		conn.Close()
+		return nil, errors.New(reply.Message)
+	}
+	outputR, outputW := io.Pipe()
+	p := &warmProcess{conn: conn, pid: reply.Pid, warm: reply.Warm, output: outputR, exit: make(chan error, 1)}
+	go func() {
+		defer outputW.Close()
+		for {
+			var reply daemonReply
+			if err := dec.Decode(&reply); err != nil {
+				p.exit <- fmt.Errorf("lost og daemon: %w", err)
+				return
+			}
+			switch reply.Type {
+			case "output":
+				fmt.Fprintln(outputW, reply.Line)
+			case "exited":
+				if reply.Error != "" {
+					p.exit <- errors.New(reply.Error)
+				} else {
+					p.exit <- nil
+				}
+				return
+			}
+		}
+	}()
+	return p, nil
+}
+
+func (p *warmProcess) signal(sig string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return json.NewEncoder(p.conn).Encode(daemonRequest{Type: "signal", Signal: sig})
+}
+
+func (p *warmProcess) Interrupt() error { return p.signal("interrupt") }
+func (p *warmProcess) Terminate() error { return p.signal("terminate") }
+func (p *warmProcess) Kill() error      { return p.signal("kill") }
+
+// Wait waits for the agent to exit; closing the connection afterwards lets
+// og daemon end what the agent left running, so signals sent after Wait
+// returned are not delivered.
+func (p *warmProcess) Wait() error {
+	err := <-p.exit
+	p.conn.Close()
+	return err
+}
+
+// Daemon keeps an agent warm, its dependencies imported, and hands it to the
+// next og session, so that sessions do not wait for Python to start. It then
+// starts another one for the session after.
+type Daemon struct {
+	ui          ui.UI
+	path        string
+	ln          *net.UnixListener
+	agentPath   string
+	module      string // The agent's module, run with python -m
+	interpreter Interpreter
+	env         []string
+	since       time.Time
+
+	mu      sync.Mutex
+	next    *warmAgent // Waiting for a session, nil while one is started
+	modTime time.Time  // Of the agent's sources when next was started
+	served  int
+	stopped bool
+}
+
+// warmAgent is an agent started with --warm by og daemon.
+type warmAgent struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	output chan string   // Lines of its stdout and stderr, closed once both ended
+	exited chan struct{} // Closed once it exited
+	err    error         // Set before exited is closed
+}
+
+// NewDaemon prepares og daemon for the agent configured in cfg, listening at
+// path. It fails if another og daemon already listens there.
+func NewDaemon(cfg *config.OGConfig, console ui.UI, path string) (*Daemon, error) {
+	if runtime.GOOS == "windows" {
+		return nil, errors.New("og daemon needs Unix domain sockets, which og does not use on Windows")
+	}
+	agentPath := cfg.General.PythonAgentPath
+	version, err := AgentProtocolVersion(agentPath)
+	if err != nil {
+		return nil, err
+	}
+	if version < warmVersion {
+		return nil, fmt.Errorf("%s speaks protocol version %d; og daemon needs %d or later", agentPath, version, warmVersion)
+	}
+	interpreter, err := FindInterpreter(cfg.General.PythonInterpreter, agentPath)
+	if err != nil {
+		return nil, err
+	}
+	if conn, err := dialDaemon(path); err == nil {
+		conn.Close()
+		return nil, fmt.Errorf("og daemon is already running at %s", path)
+	}
+	os.Remove(path) // Left behind by a daemon that did not stop cleanly
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen at %s: %w", path, err)
+	}
+	os.Chmod(path, 0o600) // Whoever connects runs commands as this user
+
+	packageDir := filepath.Dir(agentPath)
+	env := os.Environ()
+	pythonPath := filepath.Dir(packageDir)
+	if existing := os.Getenv("PYTHONPATH"); existing != "" {
+		pythonPath = existing + string(os.PathListSeparator) + pythonPath
+	}
+	return &Daemon{
+		ui:          console,
+		path:        path,
+		ln:          ln,
+		agentPath:   agentPath,
+		module:      filepath.Base(packageDir) + "." + strings.TrimSuffix(filepath.Base(agentPath), ".py"),
+		interpreter: interpreter,
+		env:         setEnv(env, "PYTHONPATH", pythonPath),
+		since:       time.Now(),
+	}, nil
+}
+
+// Serve starts the first warm agent and serves clients until Stop is called
+// or a client asks the daemon to stop.
+func (d *Daemon) Serve() error {
+	d.refill()
+	for {
+		conn, err := d.ln.AcceptUnix()
+		if err != nil {
+			d.mu.Lock()
+			stopped := d.stopped
+			d.mu.Unlock()
+			if stopped {
+				return nil
+			}
+			return err
+		}
+		go d.serveConn(conn)
+	}
+}
+
+// Stop stops listening and ends the waiting agent. Agents handed to sessions
+// keep running until their session ends.
+func (d *Daemon) Stop() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped {
+		return
+	}
+	d.stopped = true
+	d.ln.Close()
+	os.Remove(d.path)
+	if d.next != nil {
+		d.next.stdin.Close() // It exits on the end of its input
+		_ = killProcess(d.next.cmd.Process)
+		d.next = nil
+	}
+}
+
+// refill starts a warm agent for the next session, unless one is waiting.
+func (d *Daemon) refill() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopped || d.next != nil {
+		return
+	}
+	w, err := d.startAgent()
+	if err != nil {
+		d.ui.PrintColored(d.ui.Red, "Failed to start a warm agent: %v\n", err)
+		return
+	}
+	d.next = w
+	d.modTime = agentModTime(d.agentPath)
+	d.ui.PrintColored(d.ui.Magenta, "Warm agent %d is waiting for a session.\n", w.cmd.Process.Pid)
+}
+
+// take returns the waiting agent, or starts one if none is waiting or the agent
+// was updated since it started.
+func (d *Daemon) take() (*warmAgent, bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	w := d.next
+	d.next = nil
+	if w != nil {
+		if agentModTime(d.agentPath).Equal(d.modTime) {
+			return w, true, nil
+		}
+		d.ui.PrintColored(d.ui.Yellow, "The agent was updated; replacing warm agent %d.\n", w.cmd.Process.Pid)
+		w.stdin.Close()
+		_ = killProcess(w.cmd.Process)
+	}
+	w, err := d.startAgent()
+	return w, false, err
+}
+
+// agentModTime returns when the agent's Python sources last changed.
+func agentModTime(agentPath string) time.Time {
+	var latest time.Time
+	filepath.WalkDir(filepath.Dir(agentPath), func(path string, e os.DirEntry, err error) error {
+		if err != nil || e.IsDir() || filepath.Ext(path) != ".py" {
+			return nil
+		}
+		if info, err := e.Info(); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+		return nil
+	})
+	return latest
+}
+
+// startAgent starts an agent with --warm; its output is kept until a session
+// takes it.
+func (d *Daemon) startAgent() (*warmAgent, error) {
+	args := slices.Concat(d.interpreter.Command, []string{"-m", d.module, "--warm"})
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = filepath.Dir(filepath.Dir(d.agentPath))
+	cmd.Env = d.env
+	configureProcess(cmd)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", d.interpreter, err)
+	}
+	w := &warmAgent{cmd: cmd, stdin: stdin, output: make(chan string, 256), exited: make(chan struct{})}
+	var output sync.WaitGroup
+	for _, r := range []io.Reader{stdout, stderr} {
+		output.Add(1)
+		go func() {
+			defer output.Done()
+			scanner := bufio.NewScanner(r)
+			scanner.Buffer(nil, maxLineSize)
+			for scanner.Scan() {
+				w.output <- scanner.Text()
+			}
+			io.Copy(io.Discard, r) // Past a line too long to scan, so the agent does not block
+		}()
+	}
+	go func() {
+		output.Wait() // Wait closes the pipes, so the output is read first
+		w.err = cmd.Wait()
+		close(w.output)
+		close(w.exited)
+	}()
+	return w, nil
+}
+
+// serveConn answers a client's request.
+func (d *Daemon) serveConn(conn *net.UnixConn) {
+	defer conn.Close()
+	dec := json.NewDecoder(conn)
+	enc := json.NewEncoder(conn)
+	var req daemonRequest
+	if err := dec.Decode(&req); err != nil {
+		return
+	}
+	switch req.Type {
+	case "status":
+		d.mu.Lock()
+		enc.Encode(daemonReply{Type: "status", Pid: os.Getpid(), Agent: d.agentPath, Interpreter: d.interpreter.String(), Since: d.since, Served: d.served, Waiting: d.next != nil})
+		d.mu.Unlock()
+	case "stop":
+		enc.Encode(daemonReply{Type: "stopping"})
+		d.Stop()
+	case "launch":
+		d.launch(conn, dec, enc, req)
+	default:
+		enc.Encode(daemonReply{Type: "error", Message: fmt.Sprintf("unknown request %q", req.Type)})
+	}
+}
+
+// launch hands a warm agent the client's session, then forwards the agent's
+// output and exit to the client and the client's signals to the agent.
+func (d *Daemon) launch(conn *net.UnixConn, dec *json.Decoder, enc *json.Encoder, req daemonRequest) {
+	if req.Agent != d.agentPath || req.Interpreter != d.interpreter.String() {
+		enc.Encode(daemonReply{Type: "error", Message: fmt.Sprintf("og daemon runs %s with %s", d.agentPath, d.interpreter)})
+		return
+	}
+	w, warm, err := d.take()
+	if err != nil {
+		enc.Encode(daemonReply{Type: "error", Message: err.Error()})
+		return
+	}
+	d.mu.Lock()
+	d.served++
+	d.mu.Unlock()
+	go d.refill()
+
+	start, _ := json.Marshal(map[string]any{"argv": req.Argv, "cwd": req.Cwd, "env": req.Env})
+	fmt.Fprintf(w.stdin, "%s\n", start) // An agent that already exited says why in its output
+	w.stdin.Close()
+	d.ui.PrintColored(d.ui.Magenta, "Agent %d runs a session in %s.\n", w.cmd.Process.Pid, req.Cwd)
+	if enc.Encode(daemonReply{Type: "started", Pid: w.cmd.Process.Pid, Warm: warm}) != nil {
+		_ = killProcess(w.cmd.Process)
+		return
+	}
+
+	// The client's signals, until it closes the connection; the agent's
+	// process group is then ended, as og does when the agent exits
+	clientDone := make(chan struct{})
+	go func() {
+		defer close(clientDone)
+		for {
+			var req daemonRequest
+			if err := dec.Decode(&req); err != nil {
+				break
+			}
+			switch req.Signal {
+			case "interrupt":
+				_ = interruptProcess(w.cmd.Process)
+			case "terminate":
+				_ = terminateProcess(w.cmd.Process)
+			case "kill":
+				_ = killProcess(w.cmd.Process)
+			}
+		}
+		select {
+		case <-w.exited:
+			_ = terminateProcess(w.cmd.Process)
+		default:
+			_ = killProcess(w.cmd.Process)
+		}
+	}()
+
+	for line := range w.output {
+		if enc.Encode(daemonReply{Type: "output", Line: line}) != nil {
+			for range w.output {
+				// The client is gone and the agent is being killed
+			}
+		}
+	}
+	<-w.exited
+	exited := daemonReply{Type: "exited"}
+	if w.err != nil {
+		exited.Error = w.err.Error()
+	}
+	enc.Encode(exited)
+	<-clientDone // Its last signals are delivered
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// Framing modes of the agent's output, see FrameScanner.
+const (
+	FramingLines  = "lines"  // One JSON message per line (NDJSON), up to maxLineSize
+	FramingLength = "length" // Each message is preceded by a line with its length in bytes
+)
+
+// framingVersion is the first protocol version whose agents accept --framing.
+const framingVersion = 7
+
+const (
+	maxLineSize  = 1024 * 1024       // Longest message of the lines framing
+	maxFrameSize = 512 * 1024 * 1024 // Longest message of the length framing, against a corrupt header
+)
+
+// FrameScanner reads the messages the agent writes to stdout, like a
+// bufio.Scanner. Output starts as NDJSON, whose lines are limited to
+// maxLineSize. An agent started with --framing length announces the switch
+// with {"type": "framing", "mode": "length"}; every message after it is a line
+// with the message's length in bytes, the message and a newline, so messages
+// of any size, such as large tool output, are read whole.
+type FrameScanner struct {
+	r     *bufio.Reader
+	mode  string
+	frame []byte
+	err   error
+	tee   func(frame []byte) // Given every message read, if set
+}
+
+// NewFrameScanner returns a FrameScanner reading r in the lines framing.
+func NewFrameScanner(r io.Reader) *FrameScanner {
+	return &FrameScanner{r: bufio.NewReaderSize(r, maxLineSize), mode: FramingLines}
+}
+
+// Mode returns the framing in use.
+func (s *FrameScanner) Mode() string {
+	return s.mode
+}
+
+// Scan advances to the next message, which is then available through Bytes or
+// Text. It returns false at the end of the output or on an error, see Err.
+func (s *FrameScanner) Scan() bool {
+	ok := s.scan()
+	if ok && s.tee != nil {
+		s.tee(s.frame)
+	}
+	return ok
+}
+
+// scan is Scan without the tee.
+func (s *FrameScanner) scan() bool {
+	for s.err == nil {
+		if s.mode == FramingLength {
+			return s.scanFrame()
+		}
+		line, err := s.r.ReadSlice('\n')
+		if err == bufio.ErrBufferFull {
+			s.err = bufio.ErrTooLong
+			return false
+		}
+		if err != nil && (err != io.EOF || len(line) == 0) {
+			s.err = err
+			return false
+		}
+		line = bytes.TrimSuffix(bytes.TrimSuffix(line, []byte("\n")), []byte("\r"))
+		if mode, ok := framingAnnouncement(line); ok {
+			s.mode = mode
+			continue
+		}
+		s.frame = line
+		return true
+	}
+	return false
+}
+
+// scanFrame reads a message of the length framing.
+func (s *FrameScanner) scanFrame() bool {
+	header, err := s.r.ReadSlice('\n')
+	if err != nil {
+		if err == io.EOF && len(header) > 0 {
+			err = io.ErrUnexpectedEOF
+		}
+		s.err = err
+		return false
+	}
+	n, err := strconv.Atoi(string(bytes.TrimSpace(header)))
+	if err != nil || n < 0 || n > maxFrameSize {
+		s.err = fmt.Errorf("invalid frame header %q", bytes.TrimSpace(header))
+		return false
+	}
+	frame := make([]byte, n+1) // The message and its newline
+	if _, err := io.ReadFull(s.r, frame); err != nil {
+		s.err = fmt.Errorf("message of %d bytes cut off: %w", n, io.ErrUnexpectedEOF)
+		return false
+	}
+	s.frame = frame[:n]
+	return true
+}
+
+// framingAnnouncement reports the framing a line switches to, if it is the
+// agent's announcement.
+func framingAnnouncement(line []byte) (string, bool) {
+	if !bytes.Contains(line, []byte(`"framing"`)) {
+		return "", false
+	}
+	var msg struct {
+		Type string `json:"type"`
+		Mode string `json:"mode"`
+	}
+	if json.Unmarshal(line, &msg) != nil || msg.Type != "framing" || msg.Mode != FramingLength {
+		return "", false
+	}
+	return msg.Mode, true
+}
+
+// Bytes returns the last message read by Scan. It is only valid until the
+// next call to Scan.
+func (s *FrameScanner) Bytes() []byte {
+	return s.frame
+}
+
+// Text returns the last message read by Scan as a string.
+func (s *FrameScanner) Text() string {
+	return string(s.frame)
+}
+
+// Err returns the error that stopped Scan, or nil at the end of the output.
+func (s *FrameScanner) Err() error {
+	if s.err == io.EOF {
+		return nil
+	}
+	return s.err
+}
@@ -0,0 +1,121 @@
+package agent
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// lengthFrame returns msg in the length framing.
+func lengthFrame(msg string) string {
+	return fmt.Sprintf("%d\n%s\n", len(msg), msg)
+}
+
+const lengthAnnouncement = `{"type": "framing", "mode": "length"}` + "\n"
+
+func TestFrameScanner(t *testing.T) {
+	large := `{"type": "tool_output", "output": "` + strings.Repeat("x", 2*maxLineSize) + `"}`
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+		mode   string
+		err    error // Matched with errors.Is; nil for none
+		errMsg string
+	}{
+		{
+			name:   "lines",
+			output: "{\"a\": 1}\r\n{\"b\": 2}\n{\"c\": 3}",
+			want:   []string{`{"a": 1}`, `{"b": 2}`, `{"c": 3}`},
+			mode:   FramingLines,
+		},
+		{
+			name:   "switch from lines to length",
+			output: "{\"a\": 1}\n" + lengthAnnouncement + lengthFrame("{\"b\":\n2}") + lengthFrame(""),
+			want:   []string{`{"a": 1}`, "{\"b\":\n2}", ""},
+			mode:   FramingLength,
+		},
+		{
+			name:   "other framing messages are lines",
+			output: `{"type": "framing", "mode": "xml"}` + "\n",
+			want:   []string{`{"type": "framing", "mode": "xml"}`},
+			mode:   FramingLines,
+		},
+		{
+			name:   "line over 1 MB",
+			output: "{\"a\": 1}\n" + large + "\n",
+			want:   []string{`{"a": 1}`},
+			mode:   FramingLines,
+			err:    bufio.ErrTooLong,
+		},
+		{
+			name:   "frame over 1 MB",
+			output: lengthAnnouncement + lengthFrame(large) + lengthFrame("{}"),
+			want:   []string{large, "{}"},
+			mode:   FramingLength,
+		},
+		{
+			name:   "header not a number",
+			output: lengthAnnouncement + "twelve\n{}\n",
+			mode:   FramingLength,
+			errMsg: `invalid frame header "twelve"`,
+		},
+		{
+			name:   "negative header",
+			output: lengthAnnouncement + "-1\n",
+			mode:   FramingLength,
+			errMsg: `invalid frame header "-1"`,
+		},
+		{
+			name:   "header over the frame limit",
+			output: lengthAnnouncement + fmt.Sprintf("%d\n", maxFrameSize+1),
+			mode:   FramingLength,
+			errMsg: "invalid frame header",
+		},
+		{
+			name:   "message cut off",
+			output: lengthAnnouncement + lengthFrame("{}") + "10\n{\"a\"",
+			want:   []string{"{}"},
+			mode:   FramingLength,
+			err:    io.ErrUnexpectedEOF,
+		},
+		{
+			name:   "header cut off",
+			output: lengthAnnouncement + "10",
+			mode:   FramingLength,
+			err:    io.ErrUnexpectedEOF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFrameScanner(strings.NewReader(tt.output))
+			var got []string
+			for s.Scan() {
+				got = append(got, s.Text())
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d messages, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("message %d = %.60q, want %.60q", i, got[i], tt.want[i])
+				}
+			}
+			if s.Mode() != tt.mode {
+				t.Errorf("Mode() = %q, want %q", s.Mode(), tt.mode)
+			}
+			err := s.Err()
+			switch {
+			case tt.err != nil && !errors.Is(err, tt.err):
+				t.Errorf("Err() = %v, want %v", err, tt.err)
+			case tt.errMsg != "" && (err == nil || !strings.Contains(err.Error(), tt.errMsg)):
+				t.Errorf("Err() = %v, want %q", err, tt.errMsg)
+			case tt.err == nil && tt.errMsg == "" && err != nil:
+				t.Errorf("Err() = %v, want nil", err)
+			}
+		})
+	}
+}
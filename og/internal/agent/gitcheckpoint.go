@@ -0,0 +1,53 @@
+package agent
+
+import (
+	"github.com/robbiemu/original_gangster/og/internal/gitcheckpoint"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// SetGitCheckpoint has the git repository the session runs in recorded per
+// mode (undo.git_checkpoint) before the first step that writes.
+func (mp *MessageProcessor) SetGitCheckpoint(mode string) {
+	mp.gitMode = mode
+}
+
+// GitCheckpoint returns how the git repository was recorded, or nil when it
+// was not: no step wrote, the session does not run in a repository, or
+// undo.git_checkpoint is "off".
+func (mp *MessageProcessor) GitCheckpoint() *gitcheckpoint.Checkpoint {
+	return mp.gitCheckpoint
+}
+
+// checkpointStep records the git repository before the shell step of a
+// "pre_step" when it looks like it writes, see policy.ClassifyWrite.
+func (mp *MessageProcessor) checkpointStep(msg ui.AgentMessage) {
+	if msg.Type != "pre_step" || msg.Tool != "shell_tool" {
+		return
+	}
+	if _, writes := policy.ClassifyWrite(msg.Action); writes {
+		mp.checkpointGit()
+	}
+}
+
+// checkpointGit records the git repository, once per session, before the
+// first step that writes.
+func (mp *MessageProcessor) checkpointGit() {
+	if mp.gitMode == "" || mp.gitMode == gitcheckpoint.ModeOff {
+		return
+	}
+	mp.gitOnce.Do(func() {
+		c, err := gitcheckpoint.Create(mp.info.Workdir, mp.info.Hash, mp.gitMode)
+		if err != nil {
+			mp.log.Warn("git checkpoint failed", "mode", mp.gitMode, "error", err.Error())
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Could not record the git repository before the step: %v\n", err)
+			return
+		}
+		if c == nil {
+			return // Not a repository
+		}
+		mp.gitCheckpoint = c
+		mp.log.Info("git checkpoint", "mode", c.Mode, "ref", c.Ref, "commit", c.Commit)
+		mp.ui.PrintColored(mp.ui.Blue, "🛟 Recorded the git repository before the first step that writes: %s\n", c.Describe())
+	})
+}
@@ -0,0 +1,149 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Inputs are values a recipe step gets from the user when the agent reaches
+// it, rather than from the planner's guess. The planner declares each on a
+// line at the start of the step and writes {name} in the commands:
+//
+//	[ASK {version} "Version number to tag" MATCHING v\d+\.\d+\.\d+]
+//	git tag {version} && git push origin {version}
+//
+// The value must match the pattern in full. og asks for it once per session
+// and, in every step that uses it, checks the commands it completes against
+// the policy before the agent runs them.
+
+// maxInputAttempts is how many values that do not match its pattern the user
+// may give for an input before the step is skipped.
+const maxInputAttempts = 3
+
+// compileInputPattern compiles the pattern of an input, anchored so that it
+// matches whole values. An input without a pattern yields nil.
+func compileInputPattern(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
+	if _, err := regexp.Compile(pattern); err != nil {
+		return nil, err
+	}
+	return regexp.Compile(`^(?:` + pattern + `)$`)
+}
+
+// fillInputs replaces the {name} placeholders of command with their values.
+func fillInputs(command string, values map[string]string) string {
+	for name, value := range values {
+		command = strings.ReplaceAll(command, "{"+name+"}", value)
+	}
+	return command
+}
+
+// checkInputs warns about the inputs of a plan's steps that og cannot ask for;
+// such steps are skipped when the agent reaches them.
+func (mp *MessageProcessor) checkInputs(steps []ui.AgentAction) {
+	for i, step := range steps {
+		for _, in := range step.Inputs {
+			if _, err := compileInputPattern(in.Pattern); err != nil {
+				mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Step %d will be skipped: the pattern of {%s} is invalid: %v.\n", i+1, in.Name, err)
+			} else if !strings.Contains(step.Action, "{"+in.Name+"}") {
+				mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Step %d asks for {%s} but does not use it.\n", i+1, in.Name)
+			}
+		}
+	}
+}
+
+// handleRequestInput asks the user for the value of an input of the recipe
+// step the agent reached and sends it with an "input_result". Without a value
+// that matches the input's pattern, or when the policy refuses the commands it
+// completes, the agent skips the step.
+func (mp *MessageProcessor) handleRequestInput(msg ui.AgentMessage) error {
+	skip := func(reason string) error {
+		mp.ui.PrintColored(mp.ui.Yellow, "⏭️  Step %d skipped: %s.\n", msg.Step, reason)
+		return mp.processManager.SendCommand("input_result", map[string]interface{}{"provided": false, "reason": reason})
+	}
+	in := msg.Input
+	if in == nil || in.Name == "" {
+		return skip("the agent asked for an unnamed value")
+	}
+	if value, ok := mp.inputs[in.Name]; ok {
+		// Given for an earlier step; the commands of this one still need checking
+		if reason := mp.checkFilledStep(msg.Step, in.Name, value); reason != "" {
+			return skip(reason)
+		}
+		return mp.processManager.SendCommand("input_result", map[string]interface{}{"provided": true, "value": value})
+	}
+	pattern, err := compileInputPattern(in.Pattern)
+	if err != nil {
+		return skip(fmt.Sprintf("the pattern of {%s} is invalid: %v", in.Name, err))
+	}
+	question := fmt.Sprintf("✍️  Step %d needs {%s}: %s", msg.Step, in.Name, in.Prompt)
+	if in.Pattern != "" {
+		question += fmt.Sprintf(" (must match %s)", in.Pattern)
+	}
+	question += "\nLeave it empty to skip the step."
+	for attempt := 1; ; attempt++ {
+		value, ok := mp.ui.PromptForInput(question)
+		if !ok || value == "" {
+			return skip(fmt.Sprintf("the user gave no value for {%s}", in.Name))
+		}
+		if pattern != nil && !pattern.MatchString(value) {
+			if attempt == maxInputAttempts {
+				return skip(fmt.Sprintf("none of the values given for {%s} matched %s", in.Name, in.Pattern))
+			}
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  %q does not match %s.\n", value, in.Pattern)
+			continue
+		}
+		if reason := mp.checkFilledStep(msg.Step, in.Name, value); reason != "" {
+			return skip(reason)
+		}
+		mp.inputs[in.Name] = value
+		mp.ui.PrintColored(mp.ui.Cyan, "✍️  {%s} = %s\n", in.Name, value)
+		return mp.processManager.SendCommand("input_result", map[string]interface{}{"provided": true, "value": value})
+	}
+}
+
+// checkFilledStep evaluates the commands of a recipe step, completed with the
+// value of an input, which the approval of the recipe did not cover. It returns
+// why they may not run, or "" if they may.
+func (mp *MessageProcessor) checkFilledStep(stepNum int, name, value string) string {
+	if stepNum < 1 || stepNum > len(mp.recipe) {
+		return ""
+	}
+	step := mp.recipe[stepNum-1]
+	values := map[string]string{name: value}
+	for n, v := range mp.inputs {
+		if n != name {
+			values[n] = v
+		}
+	}
+	for _, line := range strings.Split(step.Action, "\n") {
+		if !strings.Contains(line, "{"+name+"}") {
+			continue
+		}
+		action := policy.Action{Tool: step.Tool, Command: strings.TrimSpace(fillInputs(line, values))}
+		if res := mp.policy.Evaluate(action); res.Decision == policy.DecisionDeny {
+			mp.recordApproval(action, false, "policy", "policy", res.Reason)
+			return fmt.Sprintf("with {%s} = %s, the policy denies %q (%s)", name, value, action.Command, res.Reason)
+		}
+		if _, wasDangerous := policy.ClassifyDanger(line); wasDangerous {
+			continue // Confirmed with the recipe
+		}
+		if danger, dangerous := policy.ClassifyDanger(action.Command); dangerous {
+			if !mp.secondOpinionPasses(action) {
+				return fmt.Sprintf("the audits of %q do not agree", action.Command)
+			}
+			approved := mp.ui.PromptForTypedConfirmation(fmt.Sprintf("⚠️  With {%s} = %s, step %d runs a dangerous command (%s).", name, value, stepNum, danger), action.Command, policy.ConfirmationPhrase)
+			mp.recordApproval(action, approved, mp.info.User, "user", "dangerous command: "+danger)
+			if !approved {
+				return fmt.Sprintf("the user did not confirm %q", action.Command)
+			}
+		}
+	}
+	return ""
+}
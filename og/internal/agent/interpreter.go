@@ -0,0 +1,133 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+)
+
+// Interpreter is the Python command that runs the agent.
+type Interpreter struct {
+	Command []string // The interpreter, possibly behind a launcher such as `uv run`
+	Source  string   // Why it was chosen, shown in errors and by `og version`
+}
+
+func (i Interpreter) String() string { return strings.Join(i.Command, " ") }
+
+// venvDirs are the environment directories looked for next to the agent, in order.
+var venvDirs = []string{".venv", "venv", ".conda"}
+
+// FindInterpreter chooses the Python that runs the agent at agentPath:
+//
+//  1. configured (general.python_interpreter), a path or a command on PATH;
+//  2. a virtualenv, uv or conda prefix environment (.venv, venv, .conda) in the
+//     agent's directory or a parent, up to the project root (pyproject.toml);
+//  3. `uv run` in that project, when it has a pyproject.toml but no environment yet;
+//  4. the active environment ($VIRTUAL_ENV, $CONDA_PREFIX);
+//  5. python3 (python on Windows) on PATH.
+func FindInterpreter(configured, agentPath string) (Interpreter, error) {
+	if configured != "" {
+		path, err := exec.LookPath(configured)
+		if err != nil {
+			return Interpreter{}, fmt.Errorf("general.python_interpreter %q cannot be run: %w", configured, err)
+		}
+		return Interpreter{Command: []string{path}, Source: "general.python_interpreter"}, nil
+	}
+
+	project := ""
+	for dir := filepath.Dir(agentPath); ; dir = filepath.Dir(dir) {
+		for _, name := range venvDirs {
+			if python := envPython(filepath.Join(dir, name)); python != "" {
+				return Interpreter{Command: []string{python}, Source: "environment next to the agent"}, nil
+			}
+		}
+		if _, err := os.Stat(filepath.Join(dir, "pyproject.toml")); err == nil {
+			project = dir
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+	if project != "" {
+		if uv, err := exec.LookPath("uv"); err == nil {
+			return Interpreter{Command: []string{uv, "run", "--project", project, "python"}, Source: "uv project " + project}, nil
+		}
+	}
+
+	for _, name := range []string{"VIRTUAL_ENV", "CONDA_PREFIX"} {
+		if prefix := os.Getenv(name); prefix != "" {
+			if python := envPython(prefix); python != "" {
+				return Interpreter{Command: []string{python}, Source: "$" + name}, nil
+			}
+		}
+	}
+
+	name := "python3"
+	if runtime.GOOS == "windows" {
+		name = "python"
+	}
+	path, err := exec.LookPath(name)
+	if err != nil {
+		return Interpreter{}, fmt.Errorf("no Python interpreter found: %s is not on PATH and there is no environment next to %s; set general.python_interpreter", name, agentPath)
+	}
+	return Interpreter{Command: []string{path}, Source: "PATH"}, nil
+}
+
+// envPython returns the interpreter of the environment at prefix, or "".
+func envPython(prefix string) string {
+	candidates := []string{filepath.Join(prefix, "bin", "python3"), filepath.Join(prefix, "bin", "python")}
+	if runtime.GOOS == "windows" {
+		candidates = []string{filepath.Join(prefix, "Scripts", "python.exe"), filepath.Join(prefix, "python.exe")}
+	}
+	for _, c := range candidates {
+		if info, err := os.Stat(c); err == nil && !info.IsDir() {
+			return c
+		}
+	}
+	return ""
+}
+
+// missingModule matches the error Python prints when an import fails.
+var missingModule = regexp.MustCompile(`^(?:ModuleNotFoundError|ImportError): No module named '([^']+)'`)
+
+// ImportError describes an agent that could not import one of its dependencies.
+type ImportError struct {
+	Module      string
+	Interpreter Interpreter
+	Project     string // Directory with the agent's pyproject.toml, if any
+}
+
+func (e *ImportError) Error() string {
+	msg := fmt.Sprintf("the agent could not import %q with %s (chosen from %s)", e.Module, e.Interpreter, e.Interpreter.Source)
+	if e.Project != "" {
+		return msg + fmt.Sprintf("; install its dependencies with `uv sync` in %s, or set general.python_interpreter to the Python that has them", e.Project)
+	}
+	return msg + "; install the agent's dependencies for that Python, or set general.python_interpreter to the Python that has them"
+}
+
+// scanImportError reports the first failed import in the agent's stderr lines.
+func scanImportError(line string) (string, bool) {
+	m := missingModule.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", false
+	}
+	module, _, _ := strings.Cut(m[1], ".")
+	return module, true
+}
+
+// projectDir returns the directory of the pyproject.toml above agentPath, or "".
+func projectDir(agentPath string) string {
+	for dir := filepath.Dir(agentPath); ; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "pyproject.toml")); err == nil {
+			return dir
+		}
+		if filepath.Dir(dir) == dir {
+			return ""
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package agent
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Loop is the repetition of a recipe step, which the agent may run more than
+// once. The planner writes it on the first line of the step, in one of these
+// forms:
+//
+//	[FOR EACH failing test file MAX 10]   once per item, {item} in the command stands for it
+//	[REPEAT MAX 5]                        again as needed, e.g. until the command passes
+//
+// MAX may be left out; policy.max_loop_iterations caps every loop, whatever its
+// own limit. og counts the iterations and refuses those beyond the limit.
+type Loop struct {
+	Items string // What the step runs once for, "" for a plain repetition
+	Max   int    // The most iterations the planner allowed, 0 if it set none
+}
+
+var (
+	loopForEach = regexp.MustCompile(`(?i)^for\s+each\s+(.+?)(?:\s+max\s+(\d+))?$`)
+	loopRepeat  = regexp.MustCompile(`(?i)^repeat(?:\s+max\s+(\d+))?$`)
+)
+
+// ParseLoop parses the text of a step's [FOR EACH ...] or [REPEAT ...] line,
+// without the brackets.
+func ParseLoop(text string) (Loop, error) {
+	s := strings.Join(strings.Fields(text), " ")
+	if m := loopForEach.FindStringSubmatch(s); m != nil {
+		max, _ := strconv.Atoi(m[2])
+		return Loop{Items: m[1], Max: max}, nil
+	}
+	if m := loopRepeat.FindStringSubmatch(s); m != nil {
+		max, _ := strconv.Atoi(m[1])
+		return Loop{Max: max}, nil
+	}
+	return Loop{}, fmt.Errorf("unrecognized loop %q", s)
+}
+
+// String returns the loop in the syntax ParseLoop reads.
+func (l Loop) String() string {
+	s := "REPEAT"
+	if l.Items != "" {
+		s = "FOR EACH " + l.Items
+	}
+	if l.Max > 0 {
+		s += fmt.Sprintf(" MAX %d", l.Max)
+	}
+	return s
+}
+
+// Limit returns how many iterations the loop may run under a policy cap.
+func (l Loop) Limit(policyCap int) int {
+	if l.Max > 0 && l.Max < policyCap {
+		return l.Max
+	}
+	return policyCap
+}
+
+// Describe returns the loop in words, with its limit under a policy cap.
+func (l Loop) Describe(policyCap int) string {
+	what := "repeated as needed"
+	if l.Items != "" {
+		what = "once for each " + l.Items
+	}
+	limit := fmt.Sprintf("at most %d times", l.Limit(policyCap))
+	if l.Limit(policyCap) == 1 {
+		limit = "at most once"
+	}
+	if l.Max > policyCap {
+		return fmt.Sprintf("%s, %s (the planner asked for %d, policy caps it)", what, limit, l.Max)
+	}
+	return fmt.Sprintf("%s, %s", what, limit)
+}
@@ -1,13 +1,41 @@
 package agent
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
+	"github.com/robbiemu/original_gangster/og/internal/approval"
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/checkpoint"
+	"github.com/robbiemu/original_gangster/og/internal/cloud"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/dbquery"
+	"github.com/robbiemu/original_gangster/og/internal/editor"
+	"github.com/robbiemu/original_gangster/og/internal/executor"
+	"github.com/robbiemu/original_gangster/og/internal/gitcheckpoint"
+	"github.com/robbiemu/original_gangster/og/internal/hooks"
+	"github.com/robbiemu/original_gangster/og/internal/iac"
+	"github.com/robbiemu/original_gangster/og/internal/mcp"
+	"github.com/robbiemu/original_gangster/og/internal/patch"
+	"github.com/robbiemu/original_gangster/og/internal/plugins"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/retry"
+	"github.com/robbiemu/original_gangster/og/internal/secondopinion"
+	"github.com/robbiemu/original_gangster/og/internal/sessionlog"
 	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/undo"
+	"golang.org/x/term"
 )
 
 // MessageProcessor handles messages received from the Python agent.
@@ -15,23 +43,239 @@ type MessageProcessor struct {
 	processManager *ProcessManager
 	ui             ui.UI
 	minGoLogLevel  ui.LogLevel
+	policy         *policy.Engine
+	alwaysApproved *policy.SessionAllowList
+	delegator      *approval.Delegator
+	redactor       *redact.Redactor
+	info           SessionInfo
+	log            *slog.Logger // See SetLogger
+
+	outcome   string                 // How the session ended, see Outcome
+	summary   string                 // The agent's final summary, see Summary
+	phase     string                 // The last phase command sent, replayed by Resume
+	phaseArgs map[string]interface{} // Its arguments
+
+	scanMu       sync.Mutex    // Held while reading the agent's output, see Cancel
+	cancelled    chan struct{} // Closed when the agent reports that it cancelled the session
+	cancelOnce   sync.Once
+	cancelReport ui.AgentMessage
+
+	// Approvals that allowed actions to run, so executions can be attributed in the audit log
+	approvals      map[string]audit.Entry
+	recipeApproval *audit.Entry
+
+	stepExits   map[int]int       // Exit code of each recipe step that ran, for the steps' conditions
+	loopCap     int               // See SetLoopCap
+	maxParallel int               // See SetMaxParallelSteps
+	loopRuns    map[int]int       // Iterations of each loop step allowed so far
+	recipe      []ui.AgentAction  // Steps of the plan, for the commands their inputs complete
+	inputs      map[string]string // Values the user gave for the steps' inputs, by name
+	checkpoint  *checkpoint.Log   // See SetCheckpoint
+
+	onExecution  func(entry audit.Entry, output string)             // See OnExecution
+	onTokenUsage func(role, model string, prompt, completion int64) // See OnTokenUsage
+	onRefusal    func(action policy.Action, reason string)          // See OnRefusal
+	iacPlans     bool                                               // See EnableIaCPlans
+	iacTimeout   time.Duration
+	databases    map[string]config.DatabaseCfg // Databases sql_query_tool may query
+	mcp          *mcp.Servers                  // MCP servers whose tools the agent may call, see SetMCP
+	plugins      *plugins.Plugins              // Tool plugins the agent may call, see SetPlugins
+	hooks        *hooks.Runner                 // Runs [hooks] around steps, see SetHooks
+	executor     *executor.Executor            // Runs shell steps for the agent, see SetExecutor
+	cloud        cloud.Context                 // Active cloud CLI contexts, see SetCloudContext
+	editors      []editor.Editor               // Offered after steps that write files, see EnableEditorFollowUp
+	retry        retry.Policy                  // Retries of model calls failing with transient errors, see SetRetryPolicy
+	undo         *undo.Journal                 // Backs up the files steps change, see SetUndo
+
+	gitMode       string                    // How the git repository is recorded before the first step that writes, see SetGitCheckpoint
+	gitOnce       sync.Once                 // Records it once
+	gitCheckpoint *gitcheckpoint.Checkpoint // How it was recorded, see GitCheckpoint
+
+	stepMu   sync.Mutex
+	stopStep context.CancelFunc // Kills the shell step og is running, see Cancel
+
+	stepTimeout  time.Duration // See SetStepTimeout
+	askOnTimeout bool
+	stepTimer    *time.Timer // Times the shell step the agent runs; guarded by stepMu
+	stepRun      int         // Counts the agent's steps, so that a late timer leaves the next one be
+	stepAborted  bool        // The user aborted a step that ran too long
+	stepID       int         // Of the action the agent last started, for OG_STEP_ID
+
+	secondOpinion  *secondopinion.Auditor           // See SetSecondOpinion
+	secondOpinions map[string]secondopinion.Verdict // Verdicts so far, by approvalKey
 }
 
-// NewMessageProcessor creates a new MessageProcessor.
-func NewMessageProcessor(pm *ProcessManager, ui ui.UI, minGoLogLevel ui.LogLevel) *MessageProcessor {
+// SessionInfo identifies the session a MessageProcessor works for.
+type SessionInfo struct {
+	Hash    string
+	User    string
+	Workdir string
+}
+
+// NewMessageProcessor creates a new MessageProcessor. delegator may be nil when
+// no second approver is configured, redactor when redaction is disabled.
+func NewMessageProcessor(pm *ProcessManager, ui ui.UI, minGoLogLevel ui.LogLevel, policyEngine *policy.Engine, delegator *approval.Delegator, redactor *redact.Redactor, info SessionInfo) *MessageProcessor {
+	pm.trace.redact = redactor.String
 	return &MessageProcessor{
 		processManager: pm,
 		ui:             ui,
 		minGoLogLevel:  minGoLogLevel,
+		policy:         policyEngine,
+		alwaysApproved: policy.NewSessionAllowList(),
+		delegator:      delegator,
+		redactor:       redactor,
+		info:           info,
+		log:            sessionlog.Discard(),
+		approvals:      make(map[string]audit.Entry),
+		cancelled:      make(chan struct{}),
+		stepExits:      make(map[int]int),
+		loopCap:        1,
+		loopRuns:       make(map[int]int),
+		inputs:         make(map[string]string),
+		secondOpinions: make(map[string]secondopinion.Verdict),
 	}
 }
 
+// OnExecution registers a function that is called with the audit entry and the
+// output of every executed action, e.g. to persist session steps.
+func (mp *MessageProcessor) OnExecution(fn func(entry audit.Entry, output string)) {
+	mp.onExecution = fn
+}
+
+// OnTokenUsage registers a function that is called with the tokens of every model
+// call the agent makes, e.g. to account for the session's cost.
+func (mp *MessageProcessor) OnTokenUsage(fn func(role, model string, prompt, completion int64)) {
+	mp.onTokenUsage = fn
+}
+
+// EnableIaCPlans makes Terraform/OpenTofu/Pulumi apply-class commands always prompt,
+// after showing the resource changes of the tool's own plan. A zero timeout means no limit.
+func (mp *MessageProcessor) EnableIaCPlans(timeout time.Duration) {
+	mp.iacPlans = true
+	mp.iacTimeout = timeout
+}
+
+// SetDatabases configures the databases that the agent's sql_query_tool may query.
+func (mp *MessageProcessor) SetDatabases(databases map[string]config.DatabaseCfg) {
+	mp.databases = databases
+}
+
+// SetMCP configures the MCP servers whose tools the agent calls with "mcp_call".
+func (mp *MessageProcessor) SetMCP(servers *mcp.Servers) {
+	mp.mcp = servers
+}
+
+// SetPlugins configures the tool plugins whose tools the agent calls with
+// "mcp_call", as it calls those of MCP servers.
+func (mp *MessageProcessor) SetPlugins(p *plugins.Plugins) {
+	mp.plugins = p
+}
+
+// SetHooks has the hooks of r run before and after each step, as the agent
+// asks with "pre_step" and "post_step"; r may be nil.
+func (mp *MessageProcessor) SetHooks(r *hooks.Runner) {
+	mp.hooks = r
+}
+
+// SetStepTimeout limits how long each shell step may run (general.step_timeout_seconds);
+// 0 means no limit. When stdin is a terminal, the user is asked whether to give
+// a step that runs too long more time, run it again or abort; otherwise it is
+// killed and the agent told that it timed out. Steps og runs are timed by its
+// executor, which must be set to the same timeout, steps the agent runs by og,
+// which has the agent cancel them.
+func (mp *MessageProcessor) SetStepTimeout(timeout time.Duration) {
+	mp.stepTimeout = timeout
+	mp.askOnTimeout = term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// SetLogger sets the session log, which gets every message of the agent,
+// every approval decision and every executed action.
+func (mp *MessageProcessor) SetLogger(log *slog.Logger) {
+	mp.log = log
+}
+
+// SetExecutor has og run the agent's shell steps with e, for an agent started
+// with general.executor = "go".
+func (mp *MessageProcessor) SetExecutor(e *executor.Executor) {
+	mp.executor = e
+}
+
+// SetCloudContext sets the active cloud CLI contexts, which are shown before the
+// user approves commands that invoke those CLIs.
+func (mp *MessageProcessor) SetCloudContext(ctx cloud.Context) {
+	mp.cloud = ctx
+}
+
+// SetRetryPolicy sets how often and after how long the agent retries model calls
+// that failed with transient errors. Without it, failed model calls end the session.
+func (mp *MessageProcessor) SetRetryPolicy(p retry.Policy) {
+	mp.retry = p
+}
+
+// SetLoopCap sets policy.max_loop_iterations, the most iterations of any loop
+// step. Without it, loop steps run once.
+func (mp *MessageProcessor) SetLoopCap(n int) {
+	mp.loopCap = n
+}
+
+// EnableEditorFollowUp offers to open the files a step wrote or patched in an
+// editor (command, or $VISUAL/$EDITOR when empty, and VS Code when installed),
+// recording whether the user changed them. It has no effect when stdin is not a
+// terminal or no editor is available.
+func (mp *MessageProcessor) EnableEditorFollowUp(command string) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return
+	}
+	mp.editors = editor.Available(command)
+}
+
+// Session outcomes reported by Outcome.
+const (
+	OutcomeCompleted = "completed" // The agent delivered its final summary
+	OutcomeDenied    = "denied"    // A plan or step was denied by the user or policy
+	OutcomeQuit      = "quit"      // The user ended the session at an approval prompt
+	OutcomeUnsafe    = "unsafe"    // The auditor flagged the request as unsafe
+	OutcomeError     = "error"     // The agent reported an error of no specific kind
+
+	OutcomeModelUnreachable = "model_unreachable" // A model call failed, after any retries
+	OutcomeToolFailed       = "tool_failed"       // A tool raised instead of returning a result
+	OutcomeProtocolError    = "protocol_error"    // og and the agent could not understand each other
+	OutcomeAborted          = "aborted"           // The user interrupted the session
+	OutcomeHookFailed       = "hook_failed"       // A step hook failed and hooks.on_failure is "abort"
+)
+
+// errorOutcomes maps the kinds of "error" messages to session outcomes.
+var errorOutcomes = map[string]string{
+	"model_unreachable": OutcomeModelUnreachable,
+	"tool_failed":       OutcomeToolFailed,
+	"protocol":          OutcomeProtocolError,
+	"aborted":           OutcomeAborted,
+}
+
+// Outcome returns how the session ended, or "" if the agent stopped without saying.
+func (mp *MessageProcessor) Outcome() string {
+	return mp.outcome
+}
+
+// Summary returns the nutshell of the agent's final summary, or the summary
+// itself when it has none; it is empty until the session completed.
+func (mp *MessageProcessor) Summary() string {
+	return mp.summary
+}
+
 // ProcessMessages reads messages from the Python agent's stdout and processes them.
 // It returns true if the session should continue, false otherwise.
 func (mp *MessageProcessor) ProcessMessages() error {
+	defer mp.stopStepTimer()
 	scanner := mp.processManager.StdoutScanner()
-	for scanner.Scan() {
+	for {
+		mp.scanMu.Lock()
+		ok := scanner.Scan()
 		line := strings.TrimSpace(scanner.Text())
+		mp.scanMu.Unlock()
+		if !ok {
+			break
+		}
 		if line == "" {
 			continue
 		}
@@ -42,56 +286,1398 @@ func (mp *MessageProcessor) ProcessMessages() error {
 			if mp.minGoLogLevel <= ui.LogLevelDebug {
 				fmt.Fprintln(os.Stderr, line)
 			}
+			mp.log.Debug("agent output", "line", line)
 			continue
 		}
 
 		cont, err := mp.HandleMessage(msg)
 		if err != nil {
-			return err
+			return mp.agentExit(err) // A command could not be sent to an agent that died
 		}
 		if !cont {
 			return nil // Agent signalled session end, no error.
 		}
 	}
 	if err := scanner.Err(); err != nil && err != io.EOF {
+		mp.outcome = OutcomeProtocolError // e.g. a message longer than the scanner's buffer
 		return fmt.Errorf("error reading from stdout scanner: %w", err)
 	}
+	if mp.outcome == "" {
+		return mp.agentExit(nil) // The agent closed its output without ending the session
+	}
 	return nil
 }
 
+// agentExit returns an *ExitError if the agent process has exited, or within a few
+// seconds exits, and err otherwise.
+func (mp *MessageProcessor) agentExit(err error) error {
+	select {
+	case <-mp.processManager.Exited():
+		return &ExitError{Err: mp.processManager.ExitErr(), Phase: mp.phase}
+	case <-time.After(5 * time.Second):
+		return err
+	}
+}
+
+// Resume tells an agent restarted after an *ExitError to carry on with the phase
+// it was in. An agent that died while planning plans again by itself.
+func (mp *MessageProcessor) Resume() error {
+	if mp.phase == "" {
+		return nil
+	}
+	args := map[string]interface{}{"resume": true}
+	for k, v := range mp.phaseArgs {
+		args[k] = v
+	}
+	return mp.processManager.SendCommand(mp.phase, args)
+}
+
+// SetCheckpoint has the state of the recipe written to l as it runs: its
+// steps, their approval and their results, for og resume.
+func (mp *MessageProcessor) SetCheckpoint(l *checkpoint.Log) {
+	mp.checkpoint = l
+}
+
+// Restore takes up the recipe of an earlier run of the session from its
+// checkpoint (og resume): its steps, the exit codes of those that ran, for
+// the conditions of the rest, who approved it and the phase Resume tells the
+// agent to carry on with.
+func (mp *MessageProcessor) Restore(state *checkpoint.State) {
+	mp.recipe = make([]ui.AgentAction, len(state.Steps))
+	for i, step := range state.Steps {
+		mp.recipe[i] = step.AgentAction
+		if step.State != checkpoint.StateExecuted {
+			continue
+		}
+		code := 0
+		switch {
+		case step.ExitCode != nil:
+			code = *step.ExitCode
+		case step.Status != "success":
+			code = 1
+		}
+		mp.stepExits[i+1] = code
+	}
+	mp.phase, mp.phaseArgs = state.Phase, state.Args
+	mp.recipeApproval = state.Approval
+}
+
+// askFollowup offers the user to ask a follow-up after a completed session,
+// which the agent answers with the context of the session so far. An empty
+// answer, or the end of the input, ends the session.
+func (mp *MessageProcessor) askFollowup() (bool, error) {
+	query, ok := mp.ui.PromptForInput("Ask a follow-up? (enter to finish)")
+	if !ok || query == "" {
+		return false, nil
+	}
+	mp.outcome = ""
+	return true, mp.sendPhase("followup_query", map[string]interface{}{"query": query})
+}
+
+// Cancel asks the agent to cancel the session. The agent acknowledges with
+// "cancelling", interrupts the step it is running, saves the session and
+// reports what it got done with "cancelled". Cancel waits for that report, which
+// ProcessMessages handles or, while the session is blocked at a prompt, Cancel
+// reads itself, and returns it. It returns nil if ctx ends first or the agent
+// exits without a report; the caller should then stop the agent.
+func (mp *MessageProcessor) Cancel(ctx context.Context) *ui.AgentMessage {
+	mp.stepMu.Lock()
+	if mp.stopStep != nil {
+		mp.stopStep()
+	}
+	mp.stepMu.Unlock()
+	if err := mp.processManager.SendCommand("cancel", nil); err != nil {
+		return nil
+	}
+	select {
+	case <-mp.cancelled:
+		return &mp.cancelReport
+	case <-ctx.Done():
+		return nil
+	case <-mp.processManager.Exited():
+	}
+
+	// The agent is gone; read the rest of its output unless ProcessMessages is
+	// reading it, in which case it handles the report
+	if !mp.scanMu.TryLock() {
+		select {
+		case <-mp.cancelled:
+			return &mp.cancelReport
+		case <-ctx.Done():
+			return nil
+		}
+	}
+	defer mp.scanMu.Unlock()
+	scanner := mp.processManager.StdoutScanner()
+	for scanner.Scan() {
+		var msg ui.AgentMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "cancelling":
+			mp.ui.PrintAgentMessage(msg, mp.minGoLogLevel)
+		case "cancelled":
+			mp.ui.PrintAgentMessage(msg, mp.minGoLogLevel)
+			mp.reportCancelled(msg)
+		}
+	}
+	select {
+	case <-mp.cancelled:
+		return &mp.cancelReport
+	default:
+		return nil
+	}
+}
+
+// reportCancelled records the agent's report that it cancelled the session.
+func (mp *MessageProcessor) reportCancelled(msg ui.AgentMessage) {
+	mp.cancelOnce.Do(func() {
+		mp.outcome = OutcomeAborted
+		mp.cancelReport = msg
+		close(mp.cancelled)
+	})
+}
+
+// sendPhase sends a command that starts a phase of the session, remembering it
+// for Resume first, since an agent that died fails the send.
+func (mp *MessageProcessor) sendPhase(cmdType string, args map[string]interface{}) error {
+	mp.phase, mp.phaseArgs = cmdType, args
+	all := cmdType == "execute_recipe" || cmdType == "execute_recipe_subset"
+	mp.checkpoint.Approved(cmdType, args, mp.recipe, all, mp.recipeApproval)
+	return mp.processManager.SendCommand(cmdType, args)
+}
+
+// messageLevels are the levels at which the session log records the agent's
+// messages, by type; the rest are recorded at info.
+var messageLevels = map[string]slog.Level{
+	"error":           slog.LevelError,
+	"unsafe":          slog.LevelWarn,
+	"warn_log":        slog.LevelWarn,
+	"model_error":     slog.LevelWarn,
+	"debug_log":       slog.LevelDebug,
+	"token_usage":     slog.LevelDebug,
+	"check_condition": slog.LevelDebug,
+	"check_iteration": slog.LevelDebug,
+	"step_started":    slog.LevelDebug,
+}
+
+// logMessage records a message of the agent in the session log, with those of
+// its fields that are set.
+func logMessage(log *slog.Logger, msg ui.AgentMessage) {
+	level, ok := messageLevels[msg.Type]
+	if !ok {
+		level = slog.LevelInfo
+	}
+	var attrs []slog.Attr
+	for _, f := range []struct{ key, value string }{
+		{"role", msg.Role},
+		{"kind", msg.Kind},
+		{"tool", msg.Tool},
+		{"action", msg.Action},
+		{"status", msg.Status},
+		{"message", msg.Message},
+		{"reason", msg.Reason},
+		{"location", msg.Location},
+		{"model", msg.Model},
+		{"nutshell", msg.Nutshell},
+	} {
+		if f.value != "" {
+			attrs = append(attrs, slog.String(f.key, f.value))
+		}
+	}
+	if msg.Step > 0 {
+		attrs = append(attrs, slog.Int("step", msg.Step))
+	}
+	if msg.StepID > 0 {
+		attrs = append(attrs, slog.Int("step_id", msg.StepID))
+	}
+	if msg.ExitCode != nil {
+		attrs = append(attrs, slog.Int("exit_code", *msg.ExitCode))
+	}
+	if msg.Percent != nil {
+		attrs = append(attrs, slog.Float64("percent", *msg.Percent))
+	}
+	if msg.DurationMs > 0 {
+		attrs = append(attrs, slog.Int64("duration_ms", msg.DurationMs))
+	}
+	if len(msg.RecipeSteps) > 0 {
+		attrs = append(attrs, slog.Int("recipe_steps", len(msg.RecipeSteps)))
+	}
+	if msg.PromptTokens > 0 || msg.CompletionTokens > 0 {
+		attrs = append(attrs, slog.Int64("prompt_tokens", msg.PromptTokens), slog.Int64("completion_tokens", msg.CompletionTokens))
+	}
+	log.LogAttrs(context.Background(), level, msg.Type, attrs...)
+}
+
 // HandleMessage processes a single AgentMessage from Python.
 // Returns true if the session should continue, false if it should terminate.
 func (mp *MessageProcessor) HandleMessage(msg ui.AgentMessage) (bool, error) {
 	mp.ui.PrintAgentMessage(msg, mp.minGoLogLevel) // Delegate display to UI
+	logMessage(mp.log, msg)
+	mp.processManager.trace.received(msg)
 
 	switch msg.Type {
 	case "error":
+		mp.outcome = OutcomeError
+		if outcome, ok := errorOutcomes[msg.Kind]; ok {
+			mp.outcome = outcome
+		}
 		return false, nil // End session on error
 	case "unsafe":
-		return false, nil // End session on unsafe
+		return mp.handleUnsafe(msg)
 	case "plan":
+		var steps []policy.Action
+		for _, step := range msg.RecipeSteps {
+			steps = append(steps, policy.Action{Tool: step.Tool, Command: step.Action})
+		}
+		mp.checkConditions(msg.RecipeSteps)
+		mp.checkLoops(msg.RecipeSteps)
+		mp.checkInputs(msg.RecipeSteps)
+		mp.checkNeeds(msg.RecipeSteps)
+		mp.recipe = msg.RecipeSteps
+		mp.checkpoint.Planned(msg.RecipeSteps)
+		res := mp.policy.EvaluateAll(steps)
+		recipe := policy.Action{Tool: "recipe", Command: recipeCommands(steps)}
+		if res.Decision == policy.DecisionDeny {
+			mp.recordApproval(recipe, false, "policy", "policy", res.Reason)
+			mp.ui.PrintColored(mp.ui.Red, "🚫 Plan rejected (%s). Session ending.\n", res.Reason)
+			mp.outcome = OutcomeDenied
+			return false, nil
+		}
+		// Approved plan steps are executed without further prompts, so dangerous ones are confirmed up front.
+		danger := recipeDanger(steps)
+		if danger != "" && !mp.secondOpinionPasses(steps...) {
+			mp.ui.PrintColored(mp.ui.Yellow, "🚫 Plan rejected. Session ending.\n")
+			mp.outcome = OutcomeDenied
+			return false, nil
+		}
+		planned := false
+		for _, step := range steps {
+			planned = mp.showIaCPlan(step) || planned
+		}
+
 		// Determine if this is a multi-step recipe for approval flow
 		isMultiStepRecipe := len(msg.RecipeSteps) > 1 || msg.FallbackAction != nil
 		if isMultiStepRecipe {
-			if mp.ui.PromptForApproval("Proceed with recipe?") {
-				return true, mp.processManager.SendCommand("execute_recipe", nil)
+			mp.showCloudContext(steps...)
+			approved := false
+			switch {
+			case res.Decision == policy.DecisionEscalate:
+				approved = mp.escalate(recipe, res.Reason, danger)
+			case danger != "":
+				approved = mp.ui.PromptForTypedConfirmation(fmt.Sprintf("⚠️  This recipe contains a dangerous command (%s).", danger), "", policy.ConfirmationPhrase)
+				mp.recordApproval(recipe, approved, mp.info.User, "user", "dangerous command: "+danger)
+				if !approved {
+					mp.askWhyRefused(recipe)
+				}
+			case res.Decision == policy.DecisionApprove && !planned:
+				mp.ui.PrintColored(mp.ui.Green, "✅ Recipe auto-approved (%s).\n", res.Reason)
+				approved = true
+				mp.recordApproval(recipe, approved, "policy", "policy", res.Reason)
+			case mp.processManager.AgentVersion() >= recipeSubsetVersion:
+				var selected []int
+				selected, approved = mp.ui.PromptForRecipeSelection("Proceed with recipe?", len(msg.RecipeSteps))
+				if approved && !allStepsInOrder(selected) {
+					return true, mp.runRecipeSubset(msg.RecipeSteps, selected)
+				}
+				mp.recordApproval(recipe, approved, mp.info.User, "user", "")
+				if !approved {
+					mp.askWhyRefused(recipe)
+				}
+			default:
+				approved = mp.ui.PromptForApproval("Proceed with recipe?")
+				mp.recordApproval(recipe, approved, mp.info.User, "user", "")
+				if !approved {
+					mp.askWhyRefused(recipe)
+				}
+			}
+			if approved {
+				return true, mp.sendPhase("execute_recipe", nil)
 			} else {
 				mp.ui.PrintColored(mp.ui.Yellow, "🚫 Recipe denied by user. Session ending.\n")
+				mp.outcome = OutcomeDenied
 				return false, nil // User denied, end session
 			}
 		} else {
+			if res.Decision == policy.DecisionEscalate {
+				// The agent runs single-step plans without asking again, so escalate before it starts.
+				if !mp.escalate(steps[0], res.Reason, danger) {
+					mp.ui.PrintColored(mp.ui.Yellow, "🚫 Action denied. Session ending.\n")
+					mp.outcome = OutcomeDenied
+					return false, nil
+				}
+				return true, mp.sendPhase("execute_single_action", nil)
+			}
+			if danger != "" {
+				mp.showCloudContext(steps[0])
+				approved := mp.ui.PromptForTypedConfirmation(fmt.Sprintf("⚠️  Dangerous command detected (%s).", danger), msg.RecipeSteps[0].Action, policy.ConfirmationPhrase)
+				mp.recordApproval(steps[0], approved, mp.info.User, "user", "dangerous command: "+danger)
+				if !approved {
+					mp.askWhyRefused(steps[0])
+					mp.ui.PrintColored(mp.ui.Yellow, "🚫 Action denied by user. Session ending.\n")
+					mp.outcome = OutcomeDenied
+					return false, nil
+				}
+			} else if planned {
+				mp.showCloudContext(steps[0])
+				approved := mp.ui.PromptForApproval("Apply these infrastructure changes?")
+				mp.recordApproval(steps[0], approved, mp.info.User, "user", "infrastructure plan reviewed")
+				if !approved {
+					mp.askWhyRefused(steps[0])
+					mp.ui.PrintColored(mp.ui.Yellow, "🚫 Action denied by user. Session ending.\n")
+					mp.outcome = OutcomeDenied
+					return false, nil
+				}
+			} else {
+				mp.recordApproval(steps[0], true, "", "auto", "single-step plan")
+			}
 			// Single-step plan, auto-proceed to individual step approval (handled by ProxyTool)
-			return true, mp.processManager.SendCommand("execute_single_action", nil)
+			return true, mp.sendPhase("execute_single_action", nil)
 		}
 	case "request_approval":
-		approved := mp.ui.PromptForApproval("Execute step?")
-		return true, mp.processManager.SendCommand("user_approval_response", map[string]interface{}{"approved": approved})
+		approved, quit := mp.resolveApproval(policy.Action{Tool: msg.Tool, Command: msg.Action})
+		if err := mp.processManager.SendCommand("user_approval_response", map[string]interface{}{"approved": approved}); err != nil {
+			return false, err
+		}
+		if quit {
+			mp.ui.PrintColored(mp.ui.Yellow, "🚫 Session ended by user.\n")
+			mp.outcome = OutcomeQuit
+			return false, nil
+		}
+		return true, nil
+	case "proposed_patch":
+		return mp.handleProposedPatch(msg)
+	case "sql_query":
+		return mp.handleSQLQuery(msg)
+	case "mcp_call":
+		return mp.handleMCPCall(msg)
+	case "check_condition":
+		return true, mp.handleCheckCondition(msg)
+	case "check_iteration":
+		return true, mp.handleCheckIteration(msg)
+	case "step_failed":
+		return mp.handleStepFailed(msg)
+	case "run_command":
+		return mp.handleRunCommand(msg)
+	case "pre_step", "post_step":
+		return mp.handleStepHooks(msg)
+	case "run_commands":
+		return mp.handleRunCommands(msg)
+	case "step_started":
+		mp.stepID = msg.StepID
+		mp.startStepTimer(msg)
+		return true, nil
+	case "request_input":
+		return true, mp.handleRequestInput(msg)
+	case "result":
+		mp.stopStepTimer()
+		if msg.Tool != "" { // Results without a tool report cancellations, not executions
+			mp.recordStepExit(msg)
+			if msg.Step > 0 {
+				mp.checkpoint.Executed(msg.Step, msg.Status, msg.ExitCode)
+			}
+			mp.recordExecution(policy.Action{Tool: msg.Tool, Command: msg.Action}, msg.Status, msg.ExitCode, msg.DurationMs, msg.Output)
+			if msg.Tool == "shell_tool" && msg.Status == "success" && len(mp.editors) > 0 {
+				var files []editor.Location
+				for _, f := range editor.WrittenFiles(msg.Action, mp.info.Workdir) {
+					files = append(files, editor.Location{Path: f})
+				}
+				mp.offerEditor(files)
+			}
+			if msg.Binary != nil {
+				mp.offerSaveBinary(msg.Binary)
+			}
+		}
+		return true, nil
+	case "model_error":
+		return true, mp.handleModelError(msg)
+	case "token_usage":
+		if mp.onTokenUsage != nil {
+			mp.onTokenUsage(msg.Role, msg.Model, msg.PromptTokens, msg.CompletionTokens)
+		}
+		return true, nil
 	case "final_summary":
+		mp.outcome = OutcomeCompleted
+		mp.summary = msg.Nutshell
+		if mp.summary == "" {
+			mp.summary = msg.Summary
+		}
+		if msg.Status == "success" && mp.processManager.Followups() {
+			return mp.askFollowup()
+		}
 		return false, nil // Session ended cleanly
+	case "cancelled":
+		mp.reportCancelled(msg)
+		return false, nil
 	case "deny_current_action": // Specific message from Python to indicate user denial handled by Python
+		mp.outcome = OutcomeDenied
+		mp.stepMu.Lock()
+		if mp.stepAborted {
+			mp.ui.PrintColored(mp.ui.Yellow, "🚫 Recipe aborted by user.\n")
+			mp.outcome = OutcomeQuit
+		}
+		mp.stepMu.Unlock()
 		return false, nil // Python already knows, just terminate Go side loop
 	default:
 		// For other types like "log" or "result", just continue
 		return true, nil
 	}
 }
+
+// resolveApproval consults the policy and the session's "always approve" choices for a
+// single action and only prompts the user when neither decides. The second return value
+// is true when the user asked to end the session.
+func (mp *MessageProcessor) resolveApproval(action policy.Action) (bool, bool) {
+	return mp.resolveApprovalWith(action, mp.promptForStep)
+}
+
+// resolveApprovalWith is resolveApproval with a custom prompt, used when neither the
+// policy nor the session's choices decide. The prompt records its own decision.
+func (mp *MessageProcessor) resolveApprovalWith(action policy.Action, prompt func(action policy.Action, untrusted bool) (bool, bool)) (bool, bool) {
+	res := mp.policy.Evaluate(action)
+	// Infrastructure applies are always confirmed against a fresh plan
+	planned := res.Decision != policy.DecisionDeny && mp.showIaCPlan(action)
+	if _, dangerous := policy.ClassifyDanger(action.Command); dangerous && res.Decision != policy.DecisionDeny && !mp.secondOpinionPasses(action) {
+		return false, false
+	}
+	if res.Decision == policy.DecisionEscalate {
+		danger, _ := policy.ClassifyDanger(action.Command)
+		return mp.escalate(action, res.Reason, danger), false
+	}
+	if reason, dangerous := policy.ClassifyDanger(action.Command); dangerous && res.Decision != policy.DecisionDeny {
+		// Dangerous commands are never auto-approved and need more than a single keypress.
+		mp.showCloudContext(action)
+		approved := mp.ui.PromptForTypedConfirmation(fmt.Sprintf("⚠️  Dangerous command detected (%s).", reason), action.Command, policy.ConfirmationPhrase)
+		mp.recordApproval(action, approved, mp.info.User, "user", "dangerous command: "+reason)
+		if !approved {
+			mp.askWhyRefused(action)
+		}
+		return approved, false
+	}
+	switch {
+	case res.Decision == policy.DecisionApprove && !planned:
+		mp.ui.PrintColored(mp.ui.Green, "✅ Step auto-approved (%s).\n", res.Reason)
+		mp.recordApproval(action, true, "policy", "policy", res.Reason)
+		return true, false
+	case res.Decision == policy.DecisionDeny:
+		mp.ui.PrintColored(mp.ui.Red, "🚫 Step denied (%s).\n", res.Reason)
+		mp.recordApproval(action, false, "policy", "policy", res.Reason)
+		return false, false
+	}
+
+	if planned {
+		mp.showCloudContext(action)
+		return prompt(action, true) // Never remembered: the next apply gets its own plan
+	}
+	untrusted := mp.policy.TrustLevel() == policy.TrustUntrusted
+	if pattern, ok := mp.alwaysApproved.Match(action); ok && !untrusted {
+		mp.ui.PrintColored(mp.ui.Green, "✅ Step auto-approved (always approved this session: %s).\n", pattern)
+		mp.recordApproval(action, true, mp.info.User, "session_allow", pattern)
+		return true, false
+	}
+	mp.showCloudContext(action)
+	return prompt(action, untrusted)
+}
+
+// promptForStep asks the user about a single step, offering to always approve
+// similar steps for the rest of the session unless the workspace is untrusted.
+func (mp *MessageProcessor) promptForStep(action policy.Action, untrusted bool) (bool, bool) {
+	choice := mp.ui.PromptForApprovalChoice("Execute step?", !untrusted)
+	switch choice {
+	case ui.ApprovalYes:
+		mp.recordApproval(action, true, mp.info.User, "user", "")
+		return true, false
+	case ui.ApprovalAlways:
+		pattern := mp.alwaysApproved.Remember(action)
+		mp.ui.PrintColored(mp.ui.Green, "Will auto-approve '%s' for the rest of this session.\n", pattern)
+		mp.recordApproval(action, true, mp.info.User, "user", "always approve "+pattern)
+		return true, false
+	case ui.ApprovalQuit:
+		mp.recordApproval(action, false, mp.info.User, "user", "quit session")
+		return false, true
+	default:
+		mp.recordApproval(action, false, mp.info.User, "user", "")
+		mp.askWhyRefused(action)
+		return false, false
+	}
+}
+
+// cloudContextFor describes the cloud contexts that the shell commands among actions
+// would act on. It reports false if none of them invokes a cloud CLI.
+func (mp *MessageProcessor) cloudContextFor(actions ...policy.Action) (string, bool) {
+	var providers []cloud.Provider
+	for _, a := range actions {
+		if a.Tool != "shell_tool" {
+			continue
+		}
+		for _, p := range cloud.Touches(a.Command) {
+			if !slices.Contains(providers, p) {
+				providers = append(providers, p)
+			}
+		}
+	}
+	if len(providers) == 0 {
+		return "", false
+	}
+	if desc := mp.cloud.Describe(providers...); desc != "" {
+		return desc, true
+	}
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = string(p)
+	}
+	return "no active context detected for " + strings.Join(names, ", "), true
+}
+
+// showCloudContext prints which accounts the cloud CLI commands among actions would
+// act on, so they are approved knowing where they run.
+func (mp *MessageProcessor) showCloudContext(actions ...policy.Action) {
+	if desc, ok := mp.cloudContextFor(actions...); ok {
+		mp.ui.PrintColored(mp.ui.Yellow, "☁️  Cloud context: %s\n", mp.ui.Cyan(desc))
+	}
+}
+
+// showIaCPlan runs the plan or preview for a Terraform/OpenTofu/Pulumi apply-class
+// shell command and prints its resource changes. It reports whether action is such
+// a command, in which case it must be confirmed by the user even if the plan failed.
+func (mp *MessageProcessor) showIaCPlan(action policy.Action) bool {
+	if !mp.iacPlans || action.Tool != "shell_tool" {
+		return false
+	}
+	apply, ok := iac.Match(action.Command, mp.info.Workdir)
+	if !ok || !iac.Detect(apply.Dir) {
+		return false
+	}
+	mp.ui.PrintColored(mp.ui.Blue, "🏗️  Previewing infrastructure changes in %s...\n", mp.ui.Cyan(apply.Dir))
+	plan, err := iac.Run(apply, mp.iacTimeout)
+	if err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "⚠️  %s could not produce a plan: %v\n", apply.Tool, err)
+		return true
+	}
+	mp.ui.PrintColored(mp.ui.Yellow, "🏗️  %s: %s (%s)\n", plan.Command, plan.Summary(), mp.ui.Cyan(action.Command))
+	const maxShown = 25
+	for i, ch := range plan.Changes {
+		if i == maxShown {
+			mp.ui.PrintColored(mp.ui.Yellow, "    ... and %d more\n", len(plan.Changes)-maxShown)
+			break
+		}
+		switch ch.Action {
+		case iac.Create:
+			mp.ui.PrintColored(mp.ui.Green, "    + %s\n", ch.Address)
+		case iac.Update:
+			mp.ui.PrintColored(mp.ui.Yellow, "    ~ %s\n", ch.Address)
+		case iac.Delete:
+			mp.ui.PrintColored(mp.ui.Red, "    - %s\n", ch.Address)
+		case iac.Replace:
+			mp.ui.PrintColored(mp.ui.Magenta, "  -/+ %s\n", ch.Address)
+		}
+	}
+	return true
+}
+
+// escalate handles actions that policy marks as needing a second approver: the local
+// user approves first, then the request is relayed to a designated approver. Both
+// decisions are recorded in the audit log with the identity of whoever made them.
+func (mp *MessageProcessor) escalate(action policy.Action, reason, danger string) bool {
+	mp.ui.PrintColored(mp.ui.Yellow, "👥 This action needs a second approver (%s).\n", reason)
+	cloudContext, touchesCloud := mp.cloudContextFor(action)
+	if touchesCloud {
+		mp.ui.PrintColored(mp.ui.Yellow, "☁️  Cloud context: %s\n", mp.ui.Cyan(cloudContext))
+	}
+
+	var approved bool
+	if danger != "" {
+		confirmCommand := action.Command
+		if strings.Contains(confirmCommand, "\n") {
+			confirmCommand = ""
+		}
+		approved = mp.ui.PromptForTypedConfirmation(fmt.Sprintf("⚠️  Dangerous command detected (%s).", danger), confirmCommand, policy.ConfirmationPhrase)
+	} else {
+		approved = mp.ui.PromptForApproval("Request approval from a second approver?")
+	}
+	mp.recordApproval(action, approved, mp.info.User, "requester", reason)
+	if !approved {
+		return false
+	}
+
+	if mp.delegator == nil {
+		mp.ui.PrintColored(mp.ui.Red, "🚫 No second approver is configured ([delegation] webhook_url); denying.\n")
+		mp.recordApproval(action, false, "", "second_approver", "no delegation webhook configured")
+		return false
+	}
+
+	mp.ui.PrintColored(mp.ui.Blue, "⏳ Waiting up to %s for a second approver...\n", mp.delegator.Timeout())
+	decision, err := mp.delegator.Ask(approval.Request{
+		Session:   mp.info.Hash,
+		Requester: mp.info.User,
+		Tool:      action.Tool,
+		Command:   action.Command,
+		Reason:    reason,
+		Workdir:   mp.info.Workdir,
+		Cloud:     cloudContext,
+	})
+	if err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "🚫 Second approval failed: %v\n", err)
+		mp.recordApproval(action, false, decision.Approver, "second_approver", err.Error())
+		return false
+	}
+	mp.recordApproval(action, decision.Approved, decision.Approver, "second_approver", decision.Comment)
+	if !decision.Approved {
+		mp.ui.PrintColored(mp.ui.Red, "🚫 Denied by %s.\n", decision.Approver)
+		return false
+	}
+	mp.ui.PrintColored(mp.ui.Green, "✅ Approved by %s.\n", decision.Approver)
+	return true
+}
+
+// recordApproval appends an approval decision to the audit log and remembers approvals
+// so the executions they allow can be attributed. Failures to write are reported but
+// do not change the decision.
+func (mp *MessageProcessor) recordApproval(action policy.Action, approved bool, identity, role, reason string) {
+	decision := "denied"
+	if approved {
+		decision = "approved"
+	}
+	entry := audit.Entry{
+		Session:  mp.info.Hash,
+		Event:    audit.EventApproval,
+		Tool:     action.Tool,
+		Command:  mp.redactor.String(action.Command),
+		Decision: decision,
+		Identity: identity,
+		Role:     role,
+		Reason:   mp.redactor.String(reason),
+	}
+	mp.log.Info("approval", "tool", action.Tool, "command", action.Command, "decision", decision, "identity", identity, "role", role, "reason", reason)
+	if approved {
+		if action.Tool == "recipe" {
+			mp.recipeApproval = &entry
+		} else {
+			mp.approvals[approvalKey(action)] = entry
+		}
+	}
+	mp.appendAudit(entry)
+}
+
+// recordExecution appends an executed action to the audit log, attributed to the
+// approval that allowed it: its own, or that of the recipe it belongs to.
+func (mp *MessageProcessor) recordExecution(action policy.Action, status string, exitCode *int, durationMs int64, output string) {
+	entry := audit.Entry{
+		TS:         time.Now().UTC().Format(time.RFC3339),
+		Session:    mp.info.Hash,
+		Event:      audit.EventExecution,
+		Tool:       action.Tool,
+		Command:    mp.redactor.String(action.Command),
+		Status:     status,
+		ExitCode:   exitCode,
+		DurationMs: durationMs,
+	}
+	approval, ok := mp.approvals[approvalKey(action)]
+	if !ok && mp.recipeApproval != nil {
+		approval, ok = *mp.recipeApproval, true
+	}
+	if ok {
+		entry.Decision = approval.Decision
+		entry.Identity = approval.Identity
+		entry.Role = approval.Role
+	}
+	level := slog.LevelInfo
+	if status != "success" {
+		level = slog.LevelWarn
+	}
+	attrs := []slog.Attr{slog.String("tool", action.Tool), slog.String("command", action.Command), slog.String("status", status), slog.Int64("duration_ms", durationMs)}
+	if exitCode != nil {
+		attrs = append(attrs, slog.Int("exit_code", *exitCode))
+	}
+	mp.log.LogAttrs(context.Background(), level, "executed", attrs...)
+	mp.appendAudit(entry)
+	if mp.onExecution != nil {
+		mp.onExecution(entry, mp.redactor.String(output))
+	}
+}
+
+func (mp *MessageProcessor) appendAudit(entry audit.Entry) {
+	if err := audit.Append(entry); err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "Failed to write audit log: %v\n", err)
+	}
+}
+
+// approvalKey identifies an action for matching executions to approvals.
+func approvalKey(action policy.Action) string {
+	return action.Tool + "\x00" + strings.TrimSpace(action.Command)
+}
+
+// excludedPaths returns the paths in all that are not in kept.
+func excludedPaths(all, kept []string) []string {
+	var excluded []string
+	for _, p := range all {
+		if !slices.Contains(kept, p) {
+			excluded = append(excluded, p)
+		}
+	}
+	return excluded
+}
+
+// recipeCommands joins the commands of every recipe step, one per line.
+func recipeCommands(steps []policy.Action) string {
+	var cmds []string
+	for _, step := range steps {
+		cmds = append(cmds, step.Command)
+	}
+	return strings.Join(cmds, "\n")
+}
+
+// recipeDanger returns the reason the first dangerous step in a recipe was flagged, if any.
+func recipeDanger(steps []policy.Action) string {
+	for _, step := range steps {
+		if reason, dangerous := policy.ClassifyDanger(step.Command); dangerous {
+			return reason
+		}
+	}
+	return ""
+}
+
+// handleProposedPatch asks for approval of a unified diff and, if accepted, applies
+// it on the Go side. The outcome is reported back with a "patch_result" command.
+func (mp *MessageProcessor) handleProposedPatch(msg ui.AgentMessage) (bool, error) {
+	patches, err := patch.Parse(msg.Patch)
+	if err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "Could not parse proposed patch: %v\n", err)
+		return true, mp.processManager.SendCommand("patch_result", map[string]interface{}{"applied": false, "error": err.Error()})
+	}
+
+	paths := patch.Paths(patches)
+	action := policy.Action{Tool: "apply_patch", Command: strings.Join(paths, " ")}
+	var approved, quit bool
+	if len(patches) > 1 {
+		// One prompt for the whole batch, listing every file with per-path opt-out
+		selected := paths
+		approved, quit = mp.resolveApprovalWith(action, func(action policy.Action, _ bool) (bool, bool) {
+			var quit bool
+			selected, quit = mp.ui.PromptForPathSelection(fmt.Sprintf("Apply changes to %d files?", len(paths)), paths)
+			reason := ""
+			if len(selected) > 0 && len(selected) < len(paths) {
+				reason = fmt.Sprintf("approved %d of %d files", len(selected), len(paths))
+			}
+			mp.recordApproval(policy.Action{Tool: action.Tool, Command: strings.Join(selected, " ")}, len(selected) > 0, mp.info.User, "user", reason)
+			return len(selected) > 0, quit
+		})
+		if approved && len(selected) < len(paths) {
+			patches = patch.Select(patches, selected)
+			action.Command = strings.Join(selected, " ")
+		}
+	} else {
+		approved, quit = mp.resolveApproval(action)
+	}
+	if !approved {
+		if err := mp.processManager.SendCommand("patch_result", map[string]interface{}{"applied": false, "error": "denied by user"}); err != nil {
+			return false, err
+		}
+		return !quit, nil
+	}
+
+	mp.checkpointGit()
+	mp.backUpPatch(patches)
+	start := time.Now()
+	err = patch.Apply(mp.info.Workdir, patches)
+	status, output := "success", ""
+	if err != nil {
+		status, output = "failure", err.Error()
+	}
+	mp.recordExecution(action, status, nil, time.Since(start).Milliseconds(), output)
+	if err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "❌ Failed to apply patch: %v\n", err)
+		return true, mp.processManager.SendCommand("patch_result", map[string]interface{}{"applied": false, "error": err.Error()})
+	}
+	mp.ui.PrintColored(mp.ui.Green, "✅ Patch applied to %d file(s).\n", len(patches))
+	result := map[string]interface{}{"applied": true, "files": patch.Paths(patches)}
+	if skipped := excludedPaths(paths, patch.Paths(patches)); len(skipped) > 0 {
+		result["skipped"] = skipped
+	}
+	if edited := mp.offerEditor(patchLocations(patches)); len(edited) > 0 {
+		result["edited"] = edited
+	}
+	return true, mp.processManager.SendCommand("patch_result", result)
+}
+
+// patchLocations returns the files a patch wrote, each at its first hunk.
+// Deleted files are left out.
+func patchLocations(patches []patch.FilePatch) []editor.Location {
+	var files []editor.Location
+	for _, fp := range patches {
+		if fp.NewPath == "" {
+			continue
+		}
+		loc := editor.Location{Path: fp.NewPath}
+		if len(fp.Hunks) > 0 {
+			loc.Line = fp.Hunks[0].OldStart
+		}
+		files = append(files, loc)
+	}
+	return files
+}
+
+// offerEditor offers to open files a step wrote (relative to the working
+// directory) in one of the available editors, and returns the files the user
+// changed there. The outcome is recorded in the audit log.
+func (mp *MessageProcessor) offerEditor(files []editor.Location) []string {
+	if len(mp.editors) == 0 || len(files) == 0 {
+		return nil
+	}
+	names := make([]string, len(mp.editors))
+	for i, e := range mp.editors {
+		names[i] = e.Name
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.Path
+	}
+	choice := mp.ui.PromptForEditor(paths, names)
+	if choice < 0 {
+		return nil
+	}
+	ed := mp.editors[choice]
+
+	open := make([]editor.Location, len(files))
+	before := make([]string, len(files))
+	for i, f := range files {
+		open[i] = editor.Location{Path: filepath.Join(mp.info.Workdir, f.Path), Line: f.Line}
+		before[i] = editor.Fingerprint(open[i].Path)
+	}
+	if err := ed.Open(open); err != nil {
+		mp.ui.PrintColored(mp.ui.Red, "Could not open editor: %v\n", err)
+		return nil
+	}
+	var edited []string
+	for i, f := range open {
+		if editor.Fingerprint(f.Path) != before[i] {
+			edited = append(edited, paths[i])
+		}
+	}
+
+	status := "unchanged"
+	if len(edited) > 0 {
+		status = "edited"
+		mp.ui.PrintColored(mp.ui.Cyan, "✏️  Edited: %s\n", strings.Join(edited, ", "))
+	}
+	mp.appendAudit(audit.Entry{
+		TS:       time.Now().UTC().Format(time.RFC3339),
+		Session:  mp.info.Hash,
+		Event:    audit.EventEdit,
+		Tool:     ed.Name,
+		Command:  strings.Join(paths, " "),
+		Identity: mp.info.User,
+		Status:   status,
+	})
+	return edited
+}
+
+// handleModelError answers a "model_error", sent by the agent when a model call
+// fails, with a "model_retry" command. Transient errors are retried after a
+// backoff that is counted down on the console, until the retries are used up;
+// the agent re-raises the error when told not to retry.
+func (mp *MessageProcessor) handleModelError(msg ui.AgentMessage) error {
+	reason, transient := retry.Transient(msg.StatusCode, msg.ErrorType, msg.Message)
+	if !transient || mp.retry.MaxRetries == 0 {
+		return mp.processManager.SendCommand("model_retry", map[string]interface{}{"retry": false})
+	}
+	if msg.Attempt > mp.retry.MaxRetries {
+		mp.ui.PrintColored(mp.ui.Red, "❌ The %s model (%s) is still unavailable (%s) after %d retries.\n", msg.Role, msg.Model, reason, mp.retry.MaxRetries)
+		return mp.processManager.SendCommand("model_retry", map[string]interface{}{"retry": false})
+	}
+	delay := mp.retry.Delay(msg.Attempt)
+	prefix := fmt.Sprintf("⏳ The %s model (%s) is unavailable (%s).", msg.Role, msg.Model, reason)
+	mp.ui.PrintCountdown(delay, func(left time.Duration) string {
+		return mp.ui.Yellow(fmt.Sprintf("%s Retrying in %ds (retry %d/%d)...", prefix, int((left+time.Second-1)/time.Second), msg.Attempt, mp.retry.MaxRetries))
+	})
+	mp.ui.PrintColored(mp.ui.Yellow, "%s Retrying now.\n", prefix)
+	return mp.processManager.SendCommand("model_retry", map[string]interface{}{"retry": true})
+}
+
+// checkConditions warns about the conditions of a plan's steps that og cannot
+// evaluate; such steps are skipped when the agent reaches them.
+func (mp *MessageProcessor) checkConditions(steps []ui.AgentAction) {
+	for i, step := range steps {
+		if step.Condition == "" {
+			continue
+		}
+		cond, err := ParseCondition(step.Condition)
+		if err == nil && cond.Path == "" && cond.Step > i {
+			err = fmt.Errorf("step %d can only test an earlier step", i+1)
+		}
+		if err != nil {
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Step %d will be skipped: %v.\n", i+1, err)
+		}
+	}
+}
+
+// recordStepExit keeps the exit code of a recipe step's command for the
+// conditions of later steps. A step of several commands failed if any did.
+func (mp *MessageProcessor) recordStepExit(msg ui.AgentMessage) {
+	if msg.Step <= 0 {
+		return
+	}
+	code := 0
+	switch {
+	case msg.ExitCode != nil:
+		code = *msg.ExitCode
+	case msg.Status != "success":
+		code = 1
+	}
+	if prev, ok := mp.stepExits[msg.Step]; !ok || prev == 0 {
+		mp.stepExits[msg.Step] = code
+	}
+}
+
+// handleCheckCondition evaluates the condition of the recipe step the agent
+// reached and tells it whether to run the step, with a "condition_result".
+func (mp *MessageProcessor) handleCheckCondition(msg ui.AgentMessage) error {
+	cond, err := ParseCondition(msg.Condition)
+	met, facts := false, ""
+	if err != nil {
+		facts = err.Error()
+	} else {
+		met, facts = cond.Eval(msg.Step, mp.stepExits, mp.info.Workdir)
+	}
+	if met {
+		mp.ui.PrintColored(mp.ui.Cyan, "🔀 Step %d runs: %s (%s).\n", msg.Step, msg.Condition, facts)
+	} else {
+		mp.ui.PrintColored(mp.ui.Yellow, "⏭️  Step %d skipped: it runs only if %s (%s).\n", msg.Step, msg.Condition, facts)
+	}
+	return mp.processManager.SendCommand("condition_result", map[string]interface{}{"met": met, "reason": facts})
+}
+
+// handleRunCommand runs a shell step that the agent leaves to og and tells it
+// how the step ran with a "command_result". The agent had the step approved
+// before; the policy's denials are checked again, as og runs it. A step that
+// runs too long is extended, run again or aborted as the user decides, see
+// SetStepTimeout; aborting ends the session.
+func (mp *MessageProcessor) handleRunCommand(msg ui.AgentMessage) (bool, error) {
+	fail := func(err error) (bool, error) {
+		return true, mp.processManager.SendCommand("command_result", map[string]interface{}{"error": err.Error()})
+	}
+	if mp.executor == nil {
+		return fail(fmt.Errorf("og does not run shell steps in this session"))
+	}
+	if res := mp.policy.Evaluate(policy.Action{Tool: "shell_tool", Command: msg.Action}); res.Decision == policy.DecisionDeny {
+		mp.ui.PrintColored(mp.ui.Red, "🚫 Step denied (%s).\n", res.Reason)
+		return fail(fmt.Errorf("og refused to run the command: %s", res.Reason))
+	}
+
+	run := *mp.executor
+	if mp.stepID > 0 {
+		run.Env = append(slices.Clone(run.Env), fmt.Sprintf("OG_STEP_ID=%d", mp.stepID))
+		run.SpillName = fmt.Sprintf("%d_shell_tool", mp.stepID) // Like the files the agent spills outputs to
+	}
+	stepID := mp.stepID
+	run.OnProgress = func(p executor.Progress) {
+		mp.showProgress(progressMessage(stepID, p))
+	}
+	choice := ui.TimeoutAbort
+	if mp.askOnTimeout {
+		run.OnTimeout = func(ran time.Duration) time.Duration {
+			if choice = mp.askAboutTimeout(msg.Action, ran); choice == ui.TimeoutExtend {
+				return run.Timeout
+			}
+			return 0
+		}
+	}
+	for {
+		ctx, cancel := context.WithCancel(context.Background())
+		mp.stepMu.Lock()
+		mp.stopStep = cancel
+		mp.stepMu.Unlock()
+		res, err := run.Run(ctx, msg.Action)
+		mp.stepMu.Lock()
+		mp.stopStep = nil
+		mp.stepMu.Unlock()
+		cancel()
+		if err != nil {
+			return fail(err)
+		}
+		if !res.TimedOut {
+			return true, mp.sendCommandResult(res, "")
+		}
+		mp.ui.PrintColored(mp.ui.Yellow, "⏱️  The command was killed after %s (general.step_timeout_seconds).\n", res.Duration.Round(time.Second))
+		switch {
+		case !mp.askOnTimeout:
+			return true, mp.sendCommandResult(res, "")
+		case choice == ui.TimeoutRetry:
+			mp.ui.PrintColored(mp.ui.Cyan, "🔁 Running the command again.\n")
+			continue
+		}
+		if err := mp.sendCommandResult(res, "abort"); err != nil {
+			return false, err
+		}
+		mp.ui.PrintColored(mp.ui.Yellow, "🚫 Recipe aborted by user.\n")
+		mp.outcome = OutcomeQuit
+		return false, nil
+	}
+}
+
+// sendCommandResult tells the agent how a shell step og ran went. then is
+// "abort" when the user aborted the step for running too long.
+func (mp *MessageProcessor) sendCommandResult(res executor.Result, then string) error {
+	return mp.processManager.SendCommand("command_result", mp.commandResult(res, then))
+}
+
+// commandResult returns what the agent is told of how a shell step og ran
+// went, see sendCommandResult.
+func (mp *MessageProcessor) commandResult(res executor.Result, then string) map[string]interface{} {
+	data := map[string]interface{}{
+		"stdout":      res.Stdout,
+		"stderr":      res.Stderr,
+		"exit_code":   res.ExitCode,
+		"timed_out":   res.TimedOut,
+		"duration_ms": res.Duration.Milliseconds(),
+	}
+	if then != "" {
+		data["then"] = then
+	}
+	if res.Binary != nil && mp.processManager.AgentVersion() >= binaryOutputVersion {
+		data["binary"] = ui.BinaryOutput{Data: res.Binary.Data, File: res.Binary.File, Size: res.Binary.Size}
+	}
+	return data
+}
+
+// askAboutTimeout asks the user what to do about a step that has run for ran,
+// past general.step_timeout_seconds.
+func (mp *MessageProcessor) askAboutTimeout(command string, ran time.Duration) ui.TimeoutChoice {
+	return mp.ui.PromptForTimeoutChoice(fmt.Sprintf("⏱️  The step has run for %s, longer than general.step_timeout_seconds: %s",
+		ran.Round(time.Second), mp.redactor.String(command)))
+}
+
+// startStepTimer times a shell step the agent started running itself, as
+// "step_started" reports. When it runs past the step timeout, the agent is
+// told to kill it with a "cancel_step", whose "then" says whether to run it
+// again ("retry") or end the session ("abort"), unless the user gives it more
+// time.
+func (mp *MessageProcessor) startStepTimer(msg ui.AgentMessage) {
+	if mp.stepTimeout <= 0 || mp.executor != nil || msg.Tool != "shell_tool" {
+		return
+	}
+	mp.stepMu.Lock()
+	defer mp.stepMu.Unlock()
+	mp.stepRun++
+	run, started := mp.stepRun, time.Now()
+	var expire func()
+	expire = func() {
+		then := ""
+		if mp.askOnTimeout {
+			switch mp.askAboutTimeout(msg.Action, time.Since(started)) {
+			case ui.TimeoutExtend:
+				mp.stepMu.Lock()
+				if mp.stepRun == run {
+					mp.stepTimer = time.AfterFunc(mp.stepTimeout, expire)
+				}
+				mp.stepMu.Unlock()
+				return
+			case ui.TimeoutRetry:
+				then = "retry"
+			default:
+				then = "abort"
+			}
+		}
+		mp.stepMu.Lock()
+		running := mp.stepRun == run
+		if running && then == "abort" {
+			mp.stepAborted = true
+		}
+		mp.stepMu.Unlock()
+		if !running {
+			mp.ui.PrintColored(mp.ui.Blue, "The step ended in the meantime.\n")
+			return
+		}
+		mp.ui.PrintColored(mp.ui.Yellow, "⏱️  Killing the step after %s (general.step_timeout_seconds).\n", time.Since(started).Round(time.Second))
+		if err := mp.processManager.SendCommand("cancel_step", map[string]interface{}{"then": then}); err != nil {
+			mp.ui.PrintColored(mp.ui.Red, "Error cancelling the step: %v\n", err)
+		}
+	}
+	mp.stepTimer = time.AfterFunc(mp.stepTimeout, expire)
+}
+
+// stopStepTimer stops timing the step the agent ran, once it reports the result.
+func (mp *MessageProcessor) stopStepTimer() {
+	mp.stepMu.Lock()
+	defer mp.stepMu.Unlock()
+	if mp.stepTimer != nil {
+		mp.stepTimer.Stop()
+		mp.stepTimer = nil
+	}
+	mp.stepRun++
+}
+
+// handleStepFailed asks the user how to go on after a recipe step failed and
+// tells the agent with a "step_retry", "step_skip" or "step_abort". Aborting
+// ends the session.
+func (mp *MessageProcessor) handleStepFailed(msg ui.AgentMessage) (bool, error) {
+	what := fmt.Sprintf("Step %d failed", msg.Step)
+	if msg.ExitCode != nil {
+		what += fmt.Sprintf(" with exit code %d", *msg.ExitCode)
+	}
+	if msg.Action != "" {
+		what += ": " + mp.redactor.String(msg.Action)
+	}
+	switch mp.ui.PromptForFailureChoice(what) {
+	case ui.FailureRetry:
+		mp.ui.PrintColored(mp.ui.Cyan, "🔁 Retrying step %d.\n", msg.Step)
+		return true, mp.processManager.SendCommand("step_retry", nil)
+	case ui.FailureSkip:
+		mp.ui.PrintColored(mp.ui.Yellow, "⏭️  Skipping step %d.\n", msg.Step)
+		return true, mp.processManager.SendCommand("step_skip", nil)
+	default:
+		if err := mp.processManager.SendCommand("step_abort", nil); err != nil {
+			return false, err
+		}
+		mp.ui.PrintColored(mp.ui.Yellow, "🚫 Recipe aborted by user.\n")
+		mp.outcome = OutcomeQuit
+		return false, nil
+	}
+}
+
+// checkLoops tells how the loops of a plan's steps are bounded where policy
+// lowers the planner's limit or og cannot read them; such steps run once.
+func (mp *MessageProcessor) checkLoops(steps []ui.AgentAction) {
+	for i, step := range steps {
+		if step.Loop == "" {
+			continue
+		}
+		loop, err := ParseLoop(step.Loop)
+		switch {
+		case err != nil:
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Step %d will run once: %v.\n", i+1, err)
+		case loop.Max > mp.loopCap:
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Step %d runs %s.\n", i+1, loop.Describe(mp.loopCap))
+		}
+	}
+}
+
+// handleCheckIteration counts an iteration of the loop step the agent is about
+// to run again and tells it whether the loop's limit allows it, with an
+// "iteration_result".
+func (mp *MessageProcessor) handleCheckIteration(msg ui.AgentMessage) error {
+	loop, err := ParseLoop(msg.Loop)
+	if err != nil {
+		loop = Loop{Max: 1}
+	}
+	limit := loop.Limit(mp.loopCap)
+	ran := mp.loopRuns[msg.Step]
+	if ran >= limit {
+		reason := fmt.Sprintf("it ran %d of at most %d times", ran, limit)
+		mp.ui.PrintColored(mp.ui.Yellow, "⛔ Step %d reached its limit: %s; the agent moves on.\n", msg.Step, reason)
+		return mp.processManager.SendCommand("iteration_result", map[string]interface{}{"allowed": false, "iteration": ran, "limit": limit, "reason": reason})
+	}
+	ran++
+	mp.loopRuns[msg.Step] = ran
+	if loop.Items != "" {
+		mp.ui.PrintColored(mp.ui.Cyan, "🔁 Step %d, iteration %d/%d (for each %s): %s\n", msg.Step, ran, limit, loop.Items, msg.Action)
+	} else {
+		mp.ui.PrintColored(mp.ui.Cyan, "🔁 Step %d, iteration %d/%d: %s\n", msg.Step, ran, limit, msg.Action)
+	}
+	return mp.processManager.SendCommand("iteration_result", map[string]interface{}{"allowed": true, "iteration": ran, "limit": limit})
+}
+
+// handleSQLQuery runs a query from sql_query_tool and sends the result back as
+// "sql_result". Read-only queries only need approval where policy asks for it or
+// the workspace is untrusted; databases that allow writes are always prompted.
+func (mp *MessageProcessor) handleSQLQuery(msg ui.AgentMessage) (bool, error) {
+	reply := func(output string, err error) error {
+		if err != nil {
+			return mp.processManager.SendCommand("sql_result", map[string]interface{}{"ok": false, "error": err.Error()})
+		}
+		return mp.processManager.SendCommand("sql_result", map[string]interface{}{"ok": true, "output": output})
+	}
+	cfg, ok := mp.databases[msg.Database]
+	if !ok {
+		return true, reply("", fmt.Errorf("unknown database '%s' (configured: %s)", msg.Database, strings.Join(dbquery.Names(mp.databases), ", ")))
+	}
+
+	action := policy.Action{Tool: "sql_query_tool", Command: msg.Database + ": " + msg.Query}
+	var approved, quit bool
+	if cfg.AllowWrites {
+		approved, quit = mp.resolveApproval(action)
+	} else {
+		approved, quit = mp.resolveApprovalWith(action, func(action policy.Action, untrusted bool) (bool, bool) {
+			if untrusted {
+				return mp.promptForStep(action, untrusted)
+			}
+			mp.recordApproval(action, true, "", "auto", "read-only query")
+			return true, false
+		})
+	}
+	if !approved {
+		if err := reply("", fmt.Errorf("the query was denied")); err != nil {
+			return false, err
+		}
+		if quit {
+			mp.outcome = OutcomeQuit
+		}
+		return !quit, nil
+	}
+
+	start := time.Now()
+	db, err := dbquery.Open(msg.Database, cfg)
+	var res *dbquery.Result
+	if err == nil {
+		res, err = dbquery.Run(db, cfg, msg.Query)
+		db.Close()
+	}
+	status, output := "success", ""
+	if err != nil {
+		status, output = "failure", err.Error()
+	} else {
+		output = res.Format()
+	}
+	mp.recordExecution(action, status, nil, time.Since(start).Milliseconds(), output)
+
+	mode := "read-only"
+	if cfg.AllowWrites {
+		mode = "writes allowed"
+	}
+	mp.ui.PrintAgentMessage(ui.AgentMessage{
+		Type:             "result",
+		Status:           status,
+		InterpretMessage: fmt.Sprintf("SQL query on %s (%s)", msg.Database, mode),
+		Output:           output,
+	}, mp.minGoLogLevel)
+	return true, reply(output, err)
+}
+
+// handleMCPCall calls an MCP or plugin tool for the agent and sends the
+// result back as "mcp_result". The call is approved like a step, as tool
+// mcp_tool, or mcp_read_tool when its server marks it read-only, with the
+// command "<server>/<tool> <arguments>"; a plugin's tool is approved as
+// plugin_tool or plugin_read_tool, with the command "<plugin>/<tool> <arguments>".
+func (mp *MessageProcessor) handleMCPCall(msg ui.AgentMessage) (bool, error) {
+	reply := func(output string, err error) error {
+		if err != nil {
+			return mp.processManager.SendCommand("mcp_result", map[string]interface{}{"ok": false, "output": output, "error": err.Error()})
+		}
+		return mp.processManager.SendCommand("mcp_result", map[string]interface{}{"ok": true, "output": output})
+	}
+	var tool mcp.Tool
+	ok, plugin := false, false
+	if mp.mcp != nil {
+		tool, ok = mp.mcp.Lookup(msg.Tool)
+	}
+	if !ok && mp.plugins != nil {
+		tool, ok = mp.plugins.Lookup(msg.Tool)
+		plugin = ok
+	}
+	if !ok {
+		return true, reply("", fmt.Errorf("unknown MCP tool '%s'", msg.Tool))
+	}
+
+	kind := "mcp"
+	if plugin {
+		kind = "plugin"
+	}
+	action := policy.Action{Tool: kind + "_tool", Command: tool.Server + "/" + tool.Tool + " " + string(msg.Arguments)}
+	if tool.ReadOnly {
+		action.Tool = kind + "_read_tool"
+	}
+	approved, quit := mp.resolveApproval(action)
+	if !approved {
+		if err := reply("", fmt.Errorf("the call was denied")); err != nil {
+			return false, err
+		}
+		if quit {
+			mp.outcome = OutcomeQuit
+		}
+		return !quit, nil
+	}
+
+	start := time.Now()
+	var output string
+	var err error
+	if plugin {
+		output, err = mp.plugins.Call(tool, msg.Arguments)
+	} else {
+		output, err = mp.mcp.Call(tool, msg.Arguments)
+	}
+	status := "success"
+	if err != nil {
+		status = "failure"
+	}
+	recorded := output
+	if err != nil && output == "" {
+		recorded = err.Error()
+	}
+	mp.recordExecution(action, status, nil, time.Since(start).Milliseconds(), recorded)
+
+	interpretation := fmt.Sprintf("MCP tool %s of %s", tool.Tool, tool.Server)
+	if plugin {
+		interpretation = fmt.Sprintf("Tool %s of plugin %s", tool.Tool, tool.Server)
+	}
+	mp.ui.PrintAgentMessage(ui.AgentMessage{
+		Type:             "result",
+		Status:           status,
+		InterpretMessage: interpretation,
+		Output:           recorded,
+	}, mp.minGoLogLevel)
+	return true, reply(output, err)
+}
+
+// handleStepHooks runs the hooks of a "pre_step" or "post_step" and tells the
+// agent with a "hook_result" whether to go on. A failing hook is warned about,
+// or ends the session when hooks.on_failure is "abort"; a step whose pre_step
+// hooks failed is then not run.
+func (mp *MessageProcessor) handleStepHooks(msg ui.AgentMessage) (bool, error) {
+	reply := func(proceed bool, reason string) error {
+		return mp.processManager.SendCommand("hook_result", map[string]interface{}{"proceed": proceed, "reason": reason})
+	}
+	mp.checkpointStep(msg)
+	mp.backUpStep(msg)
+	if mp.hooks == nil {
+		return true, reply(true, "")
+	}
+	err := mp.hooks.Run(msg.Type, hooks.Step{
+		ID:         msg.StepID,
+		Number:     msg.Step,
+		Tool:       msg.Tool,
+		Command:    msg.Action,
+		Status:     msg.Status,
+		ExitCode:   msg.ExitCode,
+		DurationMs: msg.DurationMs,
+	})
+	if err == nil {
+		return true, reply(true, "")
+	}
+	mp.log.Warn("step hook failed", "hook", msg.Type, "step_id", msg.StepID, "error", err.Error())
+	if !mp.hooks.Aborts() {
+		mp.ui.PrintColored(mp.ui.Yellow, "⚠️  %s\n", mp.redactor.String(err.Error()))
+		return true, reply(true, "")
+	}
+	mp.ui.PrintColored(mp.ui.Red, "🚫 %s\n🚫 Session ended (hooks.on_failure = \"abort\").\n", mp.redactor.String(err.Error()))
+	mp.outcome = OutcomeHookFailed
+	return false, reply(false, err.Error())
+}
+
+// allStepsInOrder reports whether selected numbers every step of a recipe of
+// len(selected) steps in the planned order.
+func allStepsInOrder(selected []int) bool {
+	for i, step := range selected {
+		if step != i+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// runRecipeSubset has the agent run the steps of recipe the user selected, by
+// number, in the order given, and skip the others.
+func (mp *MessageProcessor) runRecipeSubset(recipe []ui.AgentAction, selected []int) error {
+	var edited []ui.AgentAction
+	var steps []policy.Action
+	numbers := make([]string, len(selected))
+	for i, n := range selected {
+		edited = append(edited, recipe[n-1])
+		steps = append(steps, policy.Action{Tool: recipe[n-1].Tool, Command: recipe[n-1].Action})
+		numbers[i] = strconv.Itoa(n)
+	}
+	mp.recipe = edited
+	mp.recordApproval(policy.Action{Tool: "recipe", Command: recipeCommands(steps)}, true, mp.info.User, "user", "edited: steps "+strings.Join(numbers, " "))
+	mp.ui.PrintColored(mp.ui.Green, "✂️  Running steps %s of the recipe, in that order.\n", strings.Join(numbers, ", "))
+	return mp.sendPhase("execute_recipe_subset", map[string]interface{}{"steps": selected})
+}
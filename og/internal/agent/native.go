@@ -0,0 +1,141 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/native"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// NativeBackend reports whether og's native agent runs the sessions of cfg
+// (general.backend) and, when "auto" chose it, why.
+func NativeBackend(cfg *config.OGConfig) (bool, string) {
+	switch cfg.General.Backend {
+	case "native":
+		return true, ""
+	case "auto":
+		if _, err := os.Stat(cfg.General.PythonAgentPath); err != nil {
+			return true, fmt.Sprintf("the Python agent is missing (%s)", cfg.General.PythonAgentPath)
+		}
+		if _, err := FindInterpreter(cfg.General.PythonInterpreter, cfg.General.PythonAgentPath); err != nil {
+			return true, "no Python for the agent was found"
+		}
+	}
+	return false, ""
+}
+
+// SetNative has og's native agent run the session instead of the Python
+// agent. It leaves shell steps to og, so the session needs an executor. It
+// must be called before Start.
+func (pm *ProcessManager) SetNative(on bool) {
+	pm.native = on
+}
+
+// SetEchoAgent has og's native agent run the session with canned replies
+// instead of models (og selftest): it plans native.EchoCommand and echoes its
+// output in the summary. It must be called before Start.
+func (pm *ProcessManager) SetEchoAgent() {
+	pm.native, pm.echo = true, true
+}
+
+// nativeModelTimeout bounds each model call of the native agent.
+const nativeModelTimeout = 5 * time.Minute
+
+// inProcessAgent is an agent that runs in a goroutine of og: its native agent,
+// or the replay of a recording.
+type inProcessAgent struct {
+	cancel context.CancelFunc
+	done   chan struct{}
+	err    error // Set before done is closed
+}
+
+func (p *inProcessAgent) Interrupt() error { p.cancel(); return nil }
+func (p *inProcessAgent) Terminate() error { p.cancel(); return nil }
+func (p *inProcessAgent) Kill() error      { p.cancel(); return nil }
+func (p *inProcessAgent) Wait() error {
+	<-p.done
+	return p.err
+}
+
+// startNative runs og's native agent; pm.mu must be held. It talks over pipes
+// as the Python agent does over stdio.
+func (pm *ProcessManager) startNative(resume bool) error {
+	l := pm.launch
+	if resume {
+		return fmt.Errorf("og's native agent cannot resume a session")
+	}
+	promptsFile := pm.promptsFile
+	if promptsFile == "" {
+		path, err := config.GetPromptsPath()
+		if err != nil {
+			return err
+		}
+		promptsFile = path
+	}
+	query := l.query
+	if pm.stdinFile != "" {
+		if data, err := os.ReadFile(pm.stdinFile); err == nil {
+			query += "\n\nThe user piped the following input to og; the request refers to it:\n<piped-input>\n" + string(data) + "\n</piped-input>"
+		}
+	}
+	if pm.filesFile != "" {
+		if data, err := os.ReadFile(pm.filesFile); err == nil {
+			query += "\n\nThe user attached the following files and directory listings to the request:\n<attachments>\n" + string(data) + "</attachments>"
+		}
+	}
+	cfg := native.Config{
+		Query:       query,
+		Workdir:     l.workdir,
+		Planner:     l.cfg.PlannerAgent,
+		Executor:    l.cfg.ExecutorAgent,
+		Auditor:     l.cfg.AuditorAgent,
+		PromptsFile: promptsFile,
+		QueryTag:    pm.queryTag,
+		Strictness:  l.cfg.Policy.AuditorStrictness,
+		Overridable: l.cfg.Policy.AllowUnsafeOverride && l.trustLevel != policy.TrustUntrusted.String(),
+		ReadOnly:    pm.readOnly,
+		StepHooks:   stepHooks(l.cfg),
+		Echo:        pm.echo,
+		Timeout:     nativeModelTimeout,
+	}
+	if err := cfg.CheckModels(); err != nil {
+		return err
+	}
+	if len(l.cfg.Databases) > 0 || len(pm.mcpTools) > 0 {
+		pm.ui.PrintColored(pm.ui.Yellow, "⚠️  og's native agent only runs shell commands; [databases], [mcp_servers] and [plugins] need the Python agent.\n")
+	}
+	if l.cfg.General.InteractiveFollowups {
+		pm.ui.PrintColored(pm.ui.Yellow, "⚠️  og's native agent does not take follow-ups.\n")
+	}
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	proc := &inProcessAgent{cancel: cancel, done: make(chan struct{})}
+	go func() {
+		proc.err = native.Run(ctx, cfg, stdinR, stdoutW)
+		stdoutW.Close()
+		stderrW.Close()
+		stdinR.Close()
+		close(proc.done)
+	}()
+
+	pm.version = ProtocolVersion
+	pm.followups = false
+	pm.stdinPipe = stdinW
+	pm.stdout = stdoutR
+	pm.stdoutScanner = pm.newScanner(stdoutR)
+	if pm.minGoLogLevel <= ui.LogLevelDebug && pm.echo {
+		pm.ui.PrintColored(pm.ui.Magenta, "Agent: og's echo agent\n")
+	} else if pm.minGoLogLevel <= ui.LogLevelDebug {
+		pm.ui.PrintColored(pm.ui.Magenta, "Agent: og's native agent\n")
+	}
+	return pm.watch(proc, stderrR, nil, Interpreter{Source: "native"}, "native")
+}
@@ -0,0 +1,39 @@
+package agent
+
+import (
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// handleUnsafe ends the session on an action the auditor called unsafe, unless
+// the agent offers to override the verdict (policy.allow_unsafe_override) and
+// the user confirms the action by typing it. Overrides are recorded in the
+// audit log with the role "override"; the policy's denials and second
+// approvers cannot be overridden.
+func (mp *MessageProcessor) handleUnsafe(msg ui.AgentMessage) (bool, error) {
+	if !msg.Overridable {
+		mp.outcome = OutcomeUnsafe
+		return false, nil
+	}
+	action := policy.Action{Tool: msg.Tool, Command: msg.Action}
+	overridden := false
+	switch res := mp.policy.EvaluateAll([]policy.Action{action}); res.Decision {
+	case policy.DecisionDeny:
+		mp.recordApproval(action, false, "policy", "policy", res.Reason)
+		mp.ui.PrintColored(mp.ui.Red, "🚫 The policy denies it as well (%s); the auditor's verdict stands.\n", res.Reason)
+	case policy.DecisionEscalate:
+		mp.ui.PrintColored(mp.ui.Red, "🚫 It needs a second approver (%s), which an override cannot stand in for; the auditor's verdict stands.\n", res.Reason)
+	default:
+		overridden = mp.ui.PromptForTypedConfirmation("⚠️  The auditor blocked this action. Override its verdict and run it anyway?", msg.Action, policy.ConfirmationPhrase)
+		mp.recordApproval(action, overridden, mp.info.User, "override", "auditor: "+msg.Reason)
+	}
+	if err := mp.processManager.SendCommand("override_result", map[string]interface{}{"override": overridden}); err != nil {
+		return false, err
+	}
+	if !overridden {
+		mp.outcome = OutcomeUnsafe
+		return false, nil
+	}
+	mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Auditor overridden; the action runs.\n")
+	return true, nil
+}
@@ -0,0 +1,93 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// SetMaxParallelSteps sets general.max_parallel_steps, the most recipe steps
+// og runs at the same time when the agent sends independent ones together.
+func (mp *MessageProcessor) SetMaxParallelSteps(n int) {
+	mp.maxParallel = n
+}
+
+// checkNeeds says when the steps of a plan that need no step before them
+// will still run one at a time.
+func (mp *MessageProcessor) checkNeeds(steps []ui.AgentAction) {
+	for _, step := range steps {
+		if step.Needs == nil {
+			continue
+		}
+		if mp.executor == nil && mp.maxParallel > 1 {
+			mp.ui.PrintColored(mp.ui.Yellow, "⚠️  The steps will run one at a time: steps run at the same time need general.executor = \"go\".\n")
+		}
+		return
+	}
+}
+
+// handleRunCommands runs the commands of independent recipe steps, which the
+// agent sends together with "run_commands", at most max_parallel_steps at a
+// time. Their output is printed as it comes, each line with the number of its
+// step, and their results are returned in order with "command_results". No
+// one is asked about steps that run too long, as several may at once: they
+// are killed.
+func (mp *MessageProcessor) handleRunCommands(msg ui.AgentMessage) (bool, error) {
+	if mp.executor == nil {
+		return true, mp.processManager.SendCommand("command_results", map[string]interface{}{"error": "og does not run shell steps in this session"})
+	}
+	numbers := make([]string, len(msg.Commands))
+	for i, c := range msg.Commands {
+		numbers[i] = strconv.Itoa(c.Step)
+	}
+	mp.ui.PrintColored(mp.ui.Cyan, "⏩ Running steps %s at the same time.\n", strings.Join(numbers, ", "))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mp.stepMu.Lock()
+	mp.stopStep = cancel
+	mp.stepMu.Unlock()
+
+	results := make([]map[string]interface{}, len(msg.Commands))
+	slots := make(chan struct{}, max(mp.maxParallel, 1))
+	var wg sync.WaitGroup
+	for i, c := range msg.Commands {
+		if res := mp.policy.Evaluate(policy.Action{Tool: "shell_tool", Command: c.Action}); res.Decision == policy.DecisionDeny {
+			mp.ui.PrintColored(mp.ui.Red, "🚫 Step %d denied (%s).\n", c.Step, res.Reason)
+			results[i] = map[string]interface{}{"error": fmt.Sprintf("og refused to run the command: %s", res.Reason)}
+			continue
+		}
+		run := *mp.executor
+		run.Env = append(slices.Clone(run.Env), fmt.Sprintf("OG_STEP_ID=%d", c.StepID))
+		run.SpillName = fmt.Sprintf("%d_shell_tool", c.StepID)
+		run.OnLine = func(line string) { mp.ui.PrintStepLine(c.Step, line) }
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			res, err := run.Run(ctx, c.Action)
+			if err != nil {
+				results[i] = map[string]interface{}{"error": err.Error()}
+				return
+			}
+			if res.TimedOut {
+				mp.ui.PrintColored(mp.ui.Yellow, "⏱️  Step %d was killed after %s (general.step_timeout_seconds).\n", c.Step, res.Duration.Round(time.Second))
+			}
+			results[i] = mp.commandResult(res, "")
+		}()
+	}
+	wg.Wait()
+
+	mp.stepMu.Lock()
+	mp.stopStep = nil
+	mp.stepMu.Unlock()
+	return true, mp.processManager.SendCommand("command_results", map[string]interface{}{"results": results})
+}
@@ -0,0 +1,39 @@
+//go:build !windows
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// configureProcess starts the agent in its own process group, so that the
+// commands it runs are signalled together with it and none is left behind.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interruptProcess forwards a Ctrl-C to the agent's process group; being in its
+// own group, the agent does not receive the terminal's.
+func interruptProcess(p *os.Process) error {
+	return signalGroup(p, syscall.SIGINT)
+}
+
+// terminateProcess asks the agent's process group to exit, giving it a chance
+// to clean up. It also ends commands left running after the agent exited.
+func terminateProcess(p *os.Process) error {
+	return signalGroup(p, syscall.SIGTERM)
+}
+
+// killProcess ends the agent's process group immediately.
+func killProcess(p *os.Process) error {
+	return signalGroup(p, syscall.SIGKILL)
+}
+
+func signalGroup(p *os.Process, sig syscall.Signal) error {
+	if err := syscall.Kill(-p.Pid, sig); err != nil && err != syscall.ESRCH {
+		return p.Signal(sig)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+//go:build windows
+
+package agent
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+var generateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// configureProcess starts the agent in its own process group, which lets
+// terminateProcess send it CTRL_BREAK without also interrupting og.
+func configureProcess(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+}
+
+// interruptProcess forwards a Ctrl-C to the agent, which does not receive the
+// console's in its own process group. Windows only delivers CTRL_BREAK to a group.
+func interruptProcess(p *os.Process) error {
+	return terminateProcess(p)
+}
+
+// terminateProcess asks the agent to exit with CTRL_BREAK, which Python turns
+// into an orderly shutdown.
+func terminateProcess(p *os.Process) error {
+	if r, _, err := generateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// killProcess ends the agent and the processes it started; Windows does not
+// end child processes with their parent.
+func killProcess(p *os.Process) error {
+	if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(p.Pid)).Run(); err != nil {
+		return p.Kill()
+	}
+	return nil
+}
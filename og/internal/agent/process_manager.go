@@ -2,45 +2,340 @@ package agent
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/limits"
+	"github.com/robbiemu/original_gangster/og/internal/mcp"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/sessionlog"
 	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"golang.org/x/term"
 )
 
 // AgentProcessManager manages the Python agent's process.
 type ProcessManager struct {
-	cmd           *exec.Cmd
+	proc          agentProcess
 	stdinPipe     io.WriteCloser
-	stdoutScanner *bufio.Scanner
-	stderrScanner *bufio.Scanner
+	stdoutScanner *FrameScanner
 	mu            sync.Mutex
 	ui            ui.UI // Dependency injection for UI
 	minGoLogLevel ui.LogLevel
+	log           *slog.Logger // See SetLogger
+	trace         *agentTrace  // See SetTraceContext
+	stopped       bool
+	exited        chan struct{} // Closed once the process has exited, see Exited
+	exitErr       error         // Set before exited is closed
+	stdout        io.Closer     // Read end of the agent's stdout
+	stderrDone    chan struct{} // Closed once all of the agent's stderr (and, with the socket transport, stdout) was read
+	launch        launch        // How the agent was started, for Restart
+	socket        *agentSocket  // With the socket transport, see general.agent_transport
+	control       *net.UnixConn // The socket transport's control channel, once the agent connected
+	logConn       *net.UnixConn // The socket transport's log channel
+
+	interpreter Interpreter
+	promptsFile string     // See SetPromptsFile
+	queryTag    string     // See SetQueryTag
+	artifacts   string     // See SetArtifactsDir
+	stdinFile   string     // See SetStdinContext
+	filesFile   string     // See SetFilesContext
+	lastCmdFile string     // See SetLastCommandContext
+	refusalFile string     // See SetRefusalsContext
+	readOnly    bool       // See SetReadOnly
+	resume      bool       // See SetResume
+	native      bool       // See SetNative
+	echo        bool       // See SetEchoAgent
+	stepEnv     []string   // See SetStepEnv
+	mcpTools    []mcp.Tool // See SetMCPTools
+	recorder    *recorder  // See SetRecording
+	replay      *Recording // See SetReplay
+	replayed    bool       // The recording was replayed; it cannot be again
+	followups   bool       // Whether the agent stays open for follow-ups, see Followups
+	version     int        // The agent's protocol version, see AgentVersion
+	importMu    sync.Mutex
+	importErr   *ImportError // The agent failed to import a dependency, see ImportErr
+}
+
+// launch holds the arguments of Start, so that Restart can start the agent again.
+type launch struct {
+	cfg             *config.OGConfig
+	sessionHash     string
+	query           string
+	workdir         string
+	trustLevel      string
+	jsonLogsEnabled bool
+	cacheDirPath    string
+}
+
+// agentProcess is the agent's process, started by og or handed to it by og
+// daemon.
+type agentProcess interface {
+	Interrupt() error // Forwards a Ctrl-C
+	Terminate() error // Asks it and the commands it runs to exit
+	Kill() error      // Ends it and the commands it runs immediately
+	Wait() error      // Waits for it to exit
+}
+
+// localProcess is an agent started by og.
+type localProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p localProcess) Interrupt() error { return interruptProcess(p.cmd.Process) }
+func (p localProcess) Terminate() error { return terminateProcess(p.cmd.Process) }
+func (p localProcess) Kill() error      { return killProcess(p.cmd.Process) }
+func (p localProcess) Wait() error      { return p.cmd.Wait() }
+
+// ExitError reports an agent process that exited without ending the session,
+// i.e. before a final summary, an error message or a denial.
+type ExitError struct {
+	Err   error  // From waiting for the process; nil for exit status 0
+	Phase string // The last phase command sent to the agent, "" while it was planning
+}
+
+func (e *ExitError) Error() string {
+	status := "exit status 0"
+	if e.Err != nil {
+		status = e.Err.Error()
+	}
+	return fmt.Sprintf("the agent exited unexpectedly while %s (%s)", phaseDescriptions[e.Phase], status)
+}
+
+func (e *ExitError) Unwrap() error { return e.Err }
+
+// phaseDescriptions describe what the agent does after each phase command.
+var phaseDescriptions = map[string]string{
+	"":                      "planning",
+	"execute_recipe":        "executing the recipe",
+	"execute_single_action": "executing the plan",
+	"execute_fallback":      "executing the fallback",
+}
+
+// AgentLogPath returns where the Python agent writes its own log for a session.
+func AgentLogPath(cacheDir, sessionHash string) string {
+	return filepath.Join(cacheDir, sessionHash+".agent.log")
 }
 
 // NewProcessManager creates a new ProcessManager.
 func NewProcessManager(ui ui.UI, minGoLogLevel ui.LogLevel) *ProcessManager {
-	return &ProcessManager{ui: ui, minGoLogLevel: minGoLogLevel}
+	return &ProcessManager{ui: ui, minGoLogLevel: minGoLogLevel, log: sessionlog.Discard(), trace: newAgentTrace()}
+}
+
+// SetTraceContext makes the spans of the agent's start, its protocol
+// round-trips and the steps it executes children of the span in ctx, the
+// session's ([telemetry]). It must be called before Start.
+func (pm *ProcessManager) SetTraceContext(ctx context.Context) {
+	pm.trace.ctx = ctx
+}
+
+// SetLogger sets the session log, which records when the agent starts and
+// exits and, at debug level, what it writes to stderr.
+func (pm *ProcessManager) SetLogger(log *slog.Logger) {
+	pm.log = log
+}
+
+// SetPromptsFile sets the prompts file the agent loads, instead of the one in the
+// data directory. It must be called before Start.
+func (pm *ProcessManager) SetPromptsFile(path string) {
+	pm.promptsFile = path
+}
+
+// SetStepEnv adds env ("KEY=value" entries) to the agent's environment, which
+// the commands it runs inherit. It must be called before Start.
+func (pm *ProcessManager) SetStepEnv(env []string) {
+	pm.stepEnv = env
+}
+
+// SetQueryTag passes the tag the query was classified with, which selects the
+// agent's prompt overrides for it. It must be called before Start.
+func (pm *ProcessManager) SetQueryTag(tag string) {
+	pm.queryTag = tag
+}
+
+// SetArtifactsDir sets the session's temp and artifact directory, where the agent
+// saves spilled tool output. It must be called before Start.
+func (pm *ProcessManager) SetArtifactsDir(dir string) {
+	pm.artifacts = dir
+}
+
+// stdinContextVersion is the first protocol version whose agents accept
+// --context-stdin.
+const stdinContextVersion = 13
+
+// auditOverrideVersion is the first protocol version whose agents accept
+// --auditor-strictness and --unsafe-override.
+const auditOverrideVersion = 14
+
+// SetStdinContext passes the file holding the input piped to og, which the
+// agent attaches to the query as context. It must be called before Start.
+func (pm *ProcessManager) SetStdinContext(path string) {
+	pm.stdinFile = path
+}
+
+// filesContextVersion is the first protocol version whose agents accept
+// --context-files.
+const filesContextVersion = 15
+
+// SetFilesContext passes the file holding the files and directory listings
+// attached with --file and --dir, which the agent attaches to the query as
+// context. It must be called before Start.
+func (pm *ProcessManager) SetFilesContext(path string) {
+	pm.filesFile = path
+}
+
+// lastCommandVersion is the first protocol version whose agents accept
+// --context-last-command.
+const lastCommandVersion = 16
+
+// SetLastCommandContext passes the file holding the command the user ran in
+// their shell before og and its exit status, which the agent attaches to the
+// query as context. It must be called before Start.
+func (pm *ProcessManager) SetLastCommandContext(path string) {
+	pm.lastCmdFile = path
+}
+
+// refusalsVersion is the first protocol version whose agents accept
+// --context-refusals.
+const refusalsVersion = 18
+
+// SetRefusalsContext passes the file holding the actions the user refused in
+// earlier sessions in the directory, and why, which the agent attaches to the
+// query as context. It must be called before Start.
+func (pm *ProcessManager) SetRefusalsContext(path string) {
+	pm.refusalFile = path
+}
+
+// followupsVersion is the first protocol version whose agents accept
+// --followups and the followup_query command.
+const followupsVersion = 17
+
+// Followups reports whether the agent keeps the session open for followup_query
+// commands after a successful final summary (general.interactive_followups).
+func (pm *ProcessManager) Followups() bool {
+	return pm.followups
+}
+
+// recipeSubsetVersion is the first protocol version whose agents accept the
+// execute_recipe_subset command.
+const recipeSubsetVersion = 19
+
+// stepFailureVersion is the first protocol version whose agents accept
+// --ask-on-failure and the step_retry, step_skip and step_abort commands.
+const stepFailureVersion = 20
+
+// goExecutorVersion is the first protocol version whose agents accept
+// --go-executor and leave shell steps to og with "run_command".
+const goExecutorVersion = 21
+
+// readOnlyVersion is the first protocol version whose agents accept
+// --read-only.
+const readOnlyVersion = 22
+
+// stepTimeoutVersion is the first protocol version whose agents report the
+// shell steps they run with "step_started" and accept the cancel_step command,
+// so that og can time them.
+const stepTimeoutVersion = 23
+
+// mcpVersion is the first protocol version whose agents accept --mcp-tools and
+// the mcp_tools command, and call MCP tools with "mcp_call".
+const mcpVersion = 24
+
+// stepHooksVersion is the first protocol version whose agents accept
+// --step-hooks and wait for og to run the [hooks] around each step.
+const stepHooksVersion = 25
+
+// binaryOutputVersion is the first protocol version whose agents accept
+// --binary-output and send the output of steps that is not text base64-encoded
+// in the "binary" of their results.
+const binaryOutputVersion = 26
+
+// progressVersion is the first protocol version whose agents accept
+// --progress and send "progress" while the shell steps they run take long.
+const progressVersion = 27
+
+// parallelStepsVersion is the first protocol version whose agents accept
+// --max-parallel-steps and send independent recipe steps together with
+// "run_commands".
+const parallelStepsVersion = 28
+
+// SetMCPTools sets the tools of the session's MCP servers and tool plugins,
+// which the agent is sent with the mcp_tools command once it started. It must
+// be called before Start.
+func (pm *ProcessManager) SetMCPTools(tools []mcp.Tool) {
+	pm.mcpTools = tools
+}
+
+// SetReadOnly has the agent told that the session is read-only (og --read-only),
+// so that it plans without writing. It must be called before Start.
+func (pm *ProcessManager) SetReadOnly(on bool) {
+	pm.readOnly = on
+}
+
+// SetResume has Start resume the session, as Restart does after a crash: the
+// agent reloads the state it saved and waits for a command instead of planning
+// (og resume). It must be called before Start.
+func (pm *ProcessManager) SetResume() {
+	pm.resume = true
+}
+
+// AgentVersion returns the protocol version of the running agent, or 0 when it
+// does not declare one.
+func (pm *ProcessManager) AgentVersion() int {
+	return pm.version
 }
 
 // Start initiates the Python agent process.
-func (pm *ProcessManager) Start(cfg *config.OGConfig, sessionHash, query, workdir string, jsonLogsEnabled bool, cacheDirPath string) error {
+func (pm *ProcessManager) Start(cfg *config.OGConfig, sessionHash, query, workdir, trustLevel string, jsonLogsEnabled bool, cacheDirPath string) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	pm.launch = launch{cfg, sessionHash, query, workdir, trustLevel, jsonLogsEnabled, cacheDirPath}
+	return pm.startTraced("agent.start", pm.resume)
+}
+
+// Restart starts the agent again with the arguments of Start, after the previous
+// process exited and Stop returned. With resume, the agent reloads the session
+// state it saved and waits for a command instead of planning again.
+func (pm *ProcessManager) Restart(resume bool) error {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	pm.stopped = false
+	pm.exitErr = nil
+	pm.importMu.Lock()
+	pm.importErr = nil
+	pm.importMu.Unlock()
+	return pm.startTraced("agent.restart", resume)
+}
+
+// start runs the agent; pm.mu must be held.
+func (pm *ProcessManager) start(resume bool) error {
+	if pm.replay != nil {
+		return pm.startReplay(resume)
+	}
+	if pm.native {
+		return pm.startNative(resume)
+	}
+	l := pm.launch
+	cfg, sessionHash, query, workdir, trustLevel, jsonLogsEnabled, cacheDirPath := l.cfg, l.sessionHash, l.query, l.workdir, l.trustLevel, l.jsonLogsEnabled, l.cacheDirPath
 
 	// Marshal parameters for each agent
 	executorParams, _ := json.Marshal(cfg.ExecutorAgent.Params)
 	plannerParams, _ := json.Marshal(cfg.PlannerAgent.Params)
 	auditorParams, _ := json.Marshal(cfg.AuditorAgent.Params)
+	outputSettings, _ := json.Marshal(cfg.Output)
 
 	pythonAgentFilePath := cfg.General.PythonAgentPath
 
@@ -54,13 +349,21 @@ func (pm *ProcessManager) Start(cfg *config.OGConfig, sessionHash, query, workdi
 
 	fullModulePath := fmt.Sprintf("%s.%s", packageName, moduleName)
 
-	cmdArgs := []string{
-		"python3",
-		"-m",
-		fullModulePath,
+	interpreter, err := FindInterpreter(cfg.General.PythonInterpreter, pythonAgentFilePath)
+	if err != nil {
+		return err
+	}
+	pm.interpreter = interpreter
+	if pm.minGoLogLevel <= ui.LogLevelDebug {
+		pm.ui.PrintColored(pm.ui.Magenta, "Python: %s (%s)\n", pm.ui.Cyan(interpreter.String()), interpreter.Source)
+	}
+
+	cmdArgs := slices.Concat(interpreter.Command, []string{"-m", fullModulePath})
+	agentArgs := []string{
 		"--session-hash", sessionHash,
 		"--query", query,
 		"--workdir", workdir,
+		"--trust-level", trustLevel,
 		// Pass models and params for each agent
 		"--executor-model", cfg.ExecutorAgent.Model,
 		"--executor-params", string(executorParams),
@@ -68,95 +371,508 @@ func (pm *ProcessManager) Start(cfg *config.OGConfig, sessionHash, query, workdi
 		"--planner-params", string(plannerParams),
 		"--auditor-model", cfg.AuditorAgent.Model,
 		"--auditor-params", string(auditorParams),
-		"--output-threshold-bytes", fmt.Sprintf("%d", cfg.General.OutputThresholdBytes),
+		"--output-settings", string(outputSettings),
 		"--json-logs-enabled", fmt.Sprintf("%t", jsonLogsEnabled),
 		"--cache-directory", cacheDirPath,
 	}
 
-	cmdArgs = append(cmdArgs, "--verbosity", cfg.General.VerbosityLevel.String())
+	agentArgs = append(agentArgs, "--verbosity", cfg.General.VerbosityLevel.String())
 
 	if cfg.General.SummaryMode {
-		cmdArgs = append(cmdArgs, "--summary-mode")
+		agentArgs = append(agentArgs, "--summary-mode")
+	}
+	if resume {
+		agentArgs = append(agentArgs, "--resume")
+	}
+	if pm.queryTag != "" {
+		agentArgs = append(agentArgs, "--query-tag", pm.queryTag)
+	}
+	if pm.artifacts != "" {
+		agentArgs = append(agentArgs, "--artifacts-dir", pm.artifacts)
+	}
+
+	// The agent masks the same secrets in the files it writes (agent log, session JSON)
+	if cfg.Redaction.Enabled {
+		redactPatterns, _ := json.Marshal(slices.Concat(redact.BuiltinPatterns, cfg.Redaction.Patterns))
+		agentArgs = append(agentArgs, "--redact-patterns", string(redactPatterns))
 	}
 
-	pm.cmd = exec.Command(cmdArgs[0], cmdArgs[1:]...)
+	// The agent only offers sql_query_tool when databases are configured
+	if len(cfg.Databases) > 0 {
+		databases := make(map[string]string, len(cfg.Databases))
+		for name, db := range cfg.Databases {
+			databases[name] = db.Driver
+		}
+		databasesJSON, _ := json.Marshal(databases)
+		agentArgs = append(agentArgs, "--databases", string(databasesJSON))
+	}
 
-	env := os.Environ()
-	existingPythonPath := ""
-	for _, e := range env {
-		if strings.HasPrefix(e, "PYTHONPATH=") {
-			existingPythonPath = strings.TrimPrefix(e, "PYTHONPATH=")
-			break
+	if jsonLogsEnabled {
+		agentLogPath := AgentLogPath(cacheDirPath, sessionHash)
+		agentArgs = append(agentArgs, "--agent-log-file", agentLogPath)
+		if pm.minGoLogLevel <= ui.LogLevelDebug {
+			pm.ui.PrintColored(pm.ui.Magenta, "Agent log: %s\n", pm.ui.Cyan(agentLogPath))
 		}
 	}
 
+	// A running og daemon has an agent waiting, which talks over the socket transport
+	daemonPath := ""
+	pm.version = 0
+	if v, err := AgentProtocolVersion(pythonAgentFilePath); err == nil {
+		pm.version = v
+		if v != ProtocolVersion {
+			pm.ui.PrintColored(pm.ui.Yellow, "⚠️  %s speaks protocol version %d but this og speaks %d; update them together (see 'og version').\n", pythonAgentFilePath, v, ProtocolVersion)
+		}
+		if pm.stdinFile != "" {
+			if v >= stdinContextVersion {
+				agentArgs = append(agentArgs, "--context-stdin", pm.stdinFile)
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent ignores piped input before protocol version %d.\n", stdinContextVersion)
+			}
+		}
+		if pm.filesFile != "" {
+			if v >= filesContextVersion {
+				agentArgs = append(agentArgs, "--context-files", pm.filesFile)
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent ignores --file and --dir before protocol version %d.\n", filesContextVersion)
+			}
+		}
+		if pm.lastCmdFile != "" {
+			if v >= lastCommandVersion {
+				agentArgs = append(agentArgs, "--context-last-command", pm.lastCmdFile)
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent ignores the last shell command before protocol version %d.\n", lastCommandVersion)
+			}
+		}
+		if pm.refusalFile != "" {
+			if v >= refusalsVersion {
+				agentArgs = append(agentArgs, "--context-refusals", pm.refusalFile)
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent ignores earlier refusals before protocol version %d.\n", refusalsVersion)
+			}
+		}
+		pm.followups = cfg.General.InteractiveFollowups && v >= followupsVersion
+		if pm.followups {
+			agentArgs = append(agentArgs, "--followups")
+		} else if cfg.General.InteractiveFollowups {
+			pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent does not take follow-ups before protocol version %d.\n", followupsVersion)
+		}
+		if cfg.General.Executor == "go" {
+			if v >= goExecutorVersion {
+				agentArgs = append(agentArgs, "--go-executor")
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent runs shell steps itself before protocol version %d (general.executor).\n", goExecutorVersion)
+			}
+		}
+		if cfg.General.StepTimeoutSeconds > 0 && cfg.General.Executor != "go" && v < stepTimeoutVersion {
+			pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent's shell steps are not timed before protocol version %d (general.step_timeout_seconds).\n", stepTimeoutVersion)
+		}
+		if len(pm.mcpTools) > 0 {
+			if v >= mcpVersion {
+				agentArgs = append(agentArgs, "--mcp-tools")
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent cannot call MCP or plugin tools before protocol version %d ([mcp_servers], [plugins]).\n", mcpVersion)
+			}
+		}
+		if stepHooks(cfg) {
+			switch {
+			case v >= stepHooksVersion:
+				agentArgs = append(agentArgs, "--step-hooks")
+			case len(cfg.Hooks.PreStep) > 0 || len(cfg.Hooks.PostStep) > 0:
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent's steps run without [hooks] before protocol version %d.\n", stepHooksVersion)
+			case cfg.Undo.Enabled:
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  og cannot back up the files the agent's shell steps change before protocol version %d (undo.enabled).\n", stepHooksVersion)
+			default:
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  og cannot record the git repository before the agent's shell steps before protocol version %d (undo.git_checkpoint).\n", stepHooksVersion)
+			}
+		}
+		if v >= binaryOutputVersion {
+			agentArgs = append(agentArgs, "--binary-output")
+		}
+		if v >= progressVersion && cfg.General.Executor != "go" {
+			agentArgs = append(agentArgs, "--progress")
+		}
+		// Only og runs steps at the same time; the agent runs its own in turn
+		if v >= parallelStepsVersion && cfg.General.Executor == "go" && cfg.General.MaxParallelSteps > 1 {
+			agentArgs = append(agentArgs, "--max-parallel-steps", fmt.Sprint(cfg.General.MaxParallelSteps))
+		}
+		if pm.readOnly {
+			if v >= readOnlyVersion {
+				agentArgs = append(agentArgs, "--read-only")
+			} else {
+				pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent is not told about read-only mode before protocol version %d; og still denies steps that write.\n", readOnlyVersion)
+			}
+		}
+		// Without a terminal to ask on, the agent decides how to go on after a
+		// failed step, as it did before
+		if v >= stepFailureVersion && term.IsTerminal(int(os.Stdin.Fd())) {
+			agentArgs = append(agentArgs, "--ask-on-failure")
+		}
+		if v >= auditOverrideVersion {
+			agentArgs = append(agentArgs, "--auditor-strictness", cfg.Policy.AuditorStrictness)
+			// In untrusted directories the auditor's verdicts stand
+			if cfg.Policy.AllowUnsafeOverride && trustLevel != policy.TrustUntrusted.String() {
+				agentArgs = append(agentArgs, "--unsafe-override")
+			}
+		} else if cfg.Policy.AuditorStrictness != "standard" {
+			pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent ignores policy.auditor_strictness before protocol version %d.\n", auditOverrideVersion)
+		}
+		if v >= warmVersion && runtime.GOOS != "windows" {
+			if path, err := DaemonSocketPath(); err == nil {
+				if conn, err := dialDaemon(path); err == nil {
+					conn.Close()
+					daemonPath = path
+				}
+			}
+		}
+		switch {
+		case (cfg.General.AgentTransport == TransportSocket || daemonPath != "") && v >= socketVersion && runtime.GOOS != "windows":
+			pm.socket, err = listenSocket()
+			if err != nil {
+				return err
+			}
+			agentArgs = append(agentArgs, "--socket", pm.socket.Path())
+		case v >= framingVersion:
+			// Offer length-prefixed messages, which are not limited in size; the agent announces the switch
+			agentArgs = append(agentArgs, "--framing", FramingLength)
+		}
+	}
+	// An older agent would run the steps itself, on the host
+	if cfg.General.Sandbox != "none" && pm.version < goExecutorVersion {
+		pm.closeSocket()
+		return fmt.Errorf("general.sandbox needs an agent of protocol version %d or later", goExecutorVersion)
+	}
+	if cfg.General.AgentTransport == TransportSocket && pm.socket == nil {
+		pm.ui.PrintColored(pm.ui.Yellow, "⚠️  general.agent_transport = \"socket\" needs a Unix system and an agent of protocol version %d or later; using stdio.\n", socketVersion)
+	}
+
 	newPythonPathValue := pythonPackageRootPath
-	if existingPythonPath != "" {
+	if existingPythonPath := os.Getenv("PYTHONPATH"); existingPythonPath != "" {
 		newPythonPathValue = existingPythonPath + string(os.PathListSeparator) + pythonPackageRootPath
 	}
-	pm.cmd.Env = append(env, "PYTHONPATH="+newPythonPathValue)
+	env := setEnv(os.Environ(), "PYTHONPATH", newPythonPathValue)
+	if pm.promptsFile != "" {
+		env = setEnv(env, "OG_PROMPTS_FILE", pm.promptsFile)
+	}
+	for _, e := range pm.stepEnv {
+		k, v, _ := strings.Cut(e, "=")
+		env = setEnv(env, k, v)
+	}
 
-	stdin, err := pm.cmd.StdinPipe()
-	if err != nil {
-		return fmt.Errorf("failed to create stdin pipe: %w", err)
+	if daemonPath != "" {
+		warm, err := launchWarm(daemonPath, pythonAgentFilePath, interpreter, agentArgs, workdir, env)
+		if err == nil {
+			if pm.minGoLogLevel <= ui.LogLevelDebug {
+				state := "warm"
+				if !warm.warm {
+					state = "started for this session"
+				}
+				pm.ui.PrintColored(pm.ui.Magenta, "Agent: %s from og daemon (pid %d)\n", state, warm.pid)
+			}
+			pm.stdinPipe = nil // Commands go over the control channel once the agent connected
+			pm.stdout = nil
+			pm.applyLimits(warm.pid, cfg.Limits)
+			if err := pm.watch(warm, warm.output, nil, interpreter, pythonAgentFilePath); err != nil {
+				return err
+			}
+			pm.sendMCPTools()
+			return nil
+		}
+		pm.ui.PrintColored(pm.ui.Yellow, "⚠️  og daemon could not run the agent (%v); starting it here.\n", err)
 	}
-	pm.stdinPipe = stdin
 
-	stdout, err := pm.cmd.StdoutPipe()
+	cmd := exec.Command(cmdArgs[0], slices.Concat(cmdArgs[1:], agentArgs)...)
+	cmd.Dir = workdir // Tools run relative to the process directory
+	cmd.Env = env
+	configureProcess(cmd)
+	if cfg.Limits.Any() {
+		// Started through og __limit, the agent and the commands it runs are limited from the start
+		if path, args, err := limits.Wrap(cfg.Limits, cmd.Path, cmd.Args); err == nil {
+			cmd.Path, cmd.Args = path, args
+		} else {
+			pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent runs without [limits]: %v\n", err)
+		}
+	}
+
+	if pm.socket == nil {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return fmt.Errorf("failed to create stdin pipe: %w", err)
+		}
+		pm.stdinPipe = stdin
+	} else {
+		pm.stdinPipe = nil // Commands go over the control channel once the agent connected
+	}
+
+	// Plain pipes rather than StdoutPipe/StderrPipe: the watcher below waits for the
+	// process while its output is still being read, and Wait closes those pipes.
+	stdoutR, stdoutW, err := os.Pipe()
 	if err != nil {
 		return fmt.Errorf("failed to create stdout pipe: %w", err)
 	}
-	// Increase the buffer size for stdout scanner to handle potentially large JSON lines.
-	const maxScanTokenSize = 1024 * 1024 // 1 MB
-	buf := make([]byte, 0, maxScanTokenSize)
-	pm.stdoutScanner = bufio.NewScanner(stdout)
-	pm.stdoutScanner.Buffer(buf, maxScanTokenSize)
-
-	stderr, err := pm.cmd.StderrPipe()
+	stderrR, stderrW, err := os.Pipe()
 	if err != nil {
+		stdoutR.Close()
+		stdoutW.Close()
 		return fmt.Errorf("failed to create stderr pipe: %w", err)
 	}
-	pm.stderrScanner = bufio.NewScanner(stderr)
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+	pm.stdout = nil
+	if pm.socket == nil {
+		pm.stdout = stdoutR
+		pm.stdoutScanner = pm.newScanner(stdoutR)
+	}
+
+	err = cmd.Start()
+	// The agent has its own copies of the write ends; reads see EOF once it closes them
+	stdoutW.Close()
+	stderrW.Close()
+	if err != nil {
+		stdoutR.Close()
+		stderrR.Close()
+		pm.closeSocket()
+		return fmt.Errorf("failed to start python agent command with %s (chosen from %s): %w", interpreter, interpreter.Source, err)
+	}
+	var stdout io.ReadCloser
+	if pm.socket != nil {
+		stdout = stdoutR
+	}
+	if err := pm.watch(localProcess{cmd}, stderrR, stdout, interpreter, pythonAgentFilePath); err != nil {
+		return err
+	}
+	pm.sendMCPTools()
+	return nil
+}
+
+// sendMCPTools sends the agent the MCP tools it may call, when it was started
+// with --mcp-tools; pm.mu must be held. The agent reads them before it plans.
+func (pm *ProcessManager) sendMCPTools() {
+	if len(pm.mcpTools) == 0 || pm.version < mcpVersion {
+		return
+	}
+	if err := pm.send("mcp_tools", map[string]interface{}{"tools": pm.mcpTools}); err != nil {
+		// An agent that exited before it connected is reported by the message loop
+		pm.log.Warn("failed to send MCP tools", "error", err.Error())
+	}
+}
+
+// applyLimits caps the resources of the agent the og daemon runs, pid, and so
+// of the commands it runs from now on ([limits]). An agent they cannot be
+// applied to runs without them.
+func (pm *ProcessManager) applyLimits(pid int, l config.LimitsCfg) {
+	if !l.Any() {
+		return
+	}
+	if err := limits.Apply(pid, l); err != nil {
+		pm.ui.PrintColored(pm.ui.Yellow, "⚠️  The agent runs without [limits]: %v\n", err)
+	}
+}
+
+// watch prints the agent's output and waits for it to exit, then, with the
+// socket transport, for it to connect; pm.mu must be held. stdout is only
+// printed when the agent's messages use the socket.
+func (pm *ProcessManager) watch(proc agentProcess, stderr, stdout io.ReadCloser, interpreter Interpreter, pythonAgentFilePath string) error {
+	pm.proc = proc
+	pm.log.Info("agent started", "agent", pythonAgentFilePath, "interpreter", interpreter.String(), "protocol", pm.version)
+
+	// Print what the agent writes to stderr and, when its messages use the
+	// socket, to stdout: tracebacks, warnings and stray prints
+	var output sync.WaitGroup
+	printOutput := func(r io.ReadCloser) {
+		defer output.Done()
+		defer r.Close()
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(nil, maxLineSize)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if module, ok := scanImportError(line); ok {
+				pm.importMu.Lock()
+				if pm.importErr == nil {
+					pm.importErr = &ImportError{Module: module, Interpreter: interpreter, Project: projectDir(pythonAgentFilePath)}
+				}
+				pm.importMu.Unlock()
+			}
+			pm.ui.PrintStderr(line, pm.minGoLogLevel)
+			pm.log.Debug("agent stderr", "line", line)
+		}
+		// Keep draining a line too long to scan, or the agent blocks writing it
+		if err := scanner.Err(); err != nil {
+			pm.log.Warn("agent output not shown", "error", err.Error())
+			io.Copy(io.Discard, r)
+		}
+	}
+	output.Add(1)
+	go printOutput(stderr)
+	if stdout != nil {
+		output.Add(1)
+		go printOutput(stdout)
+	}
+	stderrDone := make(chan struct{})
+	pm.stderrDone = stderrDone
+	go func() {
+		output.Wait()
+		close(stderrDone)
+	}()
+
+	// Watch for the agent exiting, whether Stop asked it to or it died
+	exited := make(chan struct{})
+	pm.exited = exited
 	go func() {
-		for pm.stderrScanner.Scan() {
-			pm.ui.PrintStderr(pm.stderrScanner.Text(), pm.minGoLogLevel)
+		err := proc.Wait()
+		if err != nil {
+			pm.log.Warn("agent exited", "error", err.Error())
+		} else {
+			pm.log.Info("agent exited")
 		}
+		pm.exitErr = err
+		close(exited)
 	}()
 
-	if err := pm.cmd.Start(); err != nil {
-		return fmt.Errorf("failed to start python agent command: %w", err)
+	if pm.socket != nil {
+		return pm.acceptAgent(exited)
+	}
+	return nil
+}
+
+// acceptAgent waits for the agent to connect to the socket transport; pm.mu
+// must be held. An agent that exits first leaves no messages to read, and its
+// stderr explains why.
+func (pm *ProcessManager) acceptAgent(exited <-chan struct{}) error {
+	control, logConn, err := pm.socket.accept(exited)
+	if err != nil {
+		_ = pm.proc.Kill()
+		<-exited
+		pm.closeSocket()
+		return err
+	}
+	if control == nil {
+		pm.stdoutScanner = pm.newScanner(strings.NewReader(""))
+		return nil
 	}
+	pm.control, pm.logConn = control, logConn
+	pm.stdinPipe = controlWriter{control}
+	pm.stdoutScanner = pm.newScanner(control)
+	pm.stdoutScanner.mode = FramingLength
+	go printLogChannel(logConn, pm.ui, pm.minGoLogLevel, pm.log)
 	return nil
 }
 
-// Stop cleans up the Python agent process.
+// closeSocket closes the socket transport's connections and socket, if any.
+func (pm *ProcessManager) closeSocket() {
+	for _, c := range []*net.UnixConn{pm.control, pm.logConn} {
+		if c != nil {
+			c.Close()
+		}
+	}
+	pm.control, pm.logConn = nil, nil
+	if pm.socket != nil {
+		pm.socket.Close()
+		pm.socket = nil
+	}
+}
+
+// Interrupt forwards a Ctrl-C to the agent and the commands it runs, which are
+// in their own process group and do not receive the terminal's.
+func (pm *ProcessManager) Interrupt() {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	if !pm.stopped && pm.proc != nil {
+		_ = pm.proc.Interrupt()
+	}
+}
+
+// Stop cleans up the Python agent process. It is safe to call more than once.
 func (pm *ProcessManager) Stop() {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	if pm.stopped {
+		return
+	}
+	pm.stopped = true
 	if pm.stdinPipe != nil {
 		pm.stdinPipe.Close()
 	}
-	if pm.cmd != nil && pm.cmd.Process != nil {
-		done := make(chan struct{})
-		go func() {
-			pm.cmd.Wait()
-			close(done)
-		}()
+	if pm.proc != nil && pm.exited != nil {
+		done := pm.exited
 		select {
 		case <-done:
-			// Python exited cleanly
+			// Python exited cleanly; end any command it left running in its group
+			_ = pm.proc.Terminate()
 		case <-time.After(5 * time.Second):
-			// Timeout, force kill
-			pm.ui.PrintColored(pm.ui.Yellow, "Python agent did not exit gracefully, forcing kill.\n")
-			pm.cmd.Process.Kill()
+			// Timeout, ask it to terminate, then force kill
+			_ = pm.proc.Terminate()
+			select {
+			case <-done:
+			case <-time.After(3 * time.Second):
+				pm.ui.PrintColored(pm.ui.Yellow, "Python agent did not exit gracefully, forcing kill.\n")
+				_ = pm.proc.Kill()
+				<-done
+			}
 		}
+		// Let the last lines of stderr, such as a traceback, reach the console
+		select {
+		case <-pm.stderrDone:
+		case <-time.After(time.Second):
+		}
+		if pm.stdout != nil {
+			pm.stdout.Close()
+		}
+	}
+	pm.trace.end()
+	pm.closeSocket()
+}
+
+// Exited returns a channel that is closed once the agent process has exited,
+// for whatever reason. It must be called after Start.
+func (pm *ProcessManager) Exited() <-chan struct{} {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	return pm.exited
+}
+
+// setEnv returns env with key set to value, replacing any existing entry. Keys
+// are matched case-insensitively on Windows, where the environment is.
+func setEnv(env []string, key, value string) []string {
+	out := make([]string, 0, len(env)+1)
+	for _, e := range env {
+		k, _, _ := strings.Cut(e, "=")
+		if k == key || (runtime.GOOS == "windows" && strings.EqualFold(k, key)) {
+			continue
+		}
+		out = append(out, e)
+	}
+	return append(out, key+"="+value)
+}
+
+// ExitErr returns the error reported by the agent process on exit (nil for a clean exit).
+// It is only meaningful once the process has exited, see Exited.
+func (pm *ProcessManager) ExitErr() error {
+	select {
+	case <-pm.Exited():
+		return pm.exitErr
+	default:
+		return nil
 	}
 }
 
+// ImportErr returns the first dependency the agent failed to import, or nil. It is
+// only meaningful after Stop has returned.
+func (pm *ProcessManager) ImportErr() *ImportError {
+	pm.importMu.Lock()
+	defer pm.importMu.Unlock()
+	return pm.importErr
+}
+
 // SendCommand marshals and sends a generic command to Python.
 func (pm *ProcessManager) SendCommand(cmdType string, data map[string]interface{}) error {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
+	return pm.send(cmdType, data)
+}
+
+// send sends a command to Python; pm.mu must be held.
+func (pm *ProcessManager) send(cmdType string, data map[string]interface{}) error {
 
 	payload := map[string]interface{}{"type": cmdType}
 	for k, v := range data {
@@ -167,13 +883,26 @@ func (pm *ProcessManager) SendCommand(cmdType string, data map[string]interface{
 	if err != nil {
 		return fmt.Errorf("failed to marshal command payload: %w", err)
 	}
+	if pm.stdinPipe == nil {
+		return errNotConnected
+	}
 	if _, err := fmt.Fprintf(pm.stdinPipe, "%s\n", string(b)); err != nil {
 		return fmt.Errorf("failed to write command to python stdin: %w", err)
 	}
+	pm.record(fromOG, b)
+	pm.trace.begin(cmdType)
 	return nil
 }
 
+// newScanner returns the scanner of the agent's messages read from r, which
+// are recorded, see SetRecording.
+func (pm *ProcessManager) newScanner(r io.Reader) *FrameScanner {
+	s := NewFrameScanner(r)
+	s.tee = func(frame []byte) { pm.record(fromAgent, frame) }
+	return s
+}
+
 // StdoutScanner returns the scanner for Python's stdout.
-func (pm *ProcessManager) StdoutScanner() *bufio.Scanner {
+func (pm *ProcessManager) StdoutScanner() *FrameScanner {
 	return pm.stdoutScanner
 }
@@ -0,0 +1,43 @@
+package agent
+
+import (
+	"fmt"
+
+	"github.com/robbiemu/original_gangster/og/internal/executor"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// progressMessage returns the "progress" message of the shell step stepID
+// that og runs, as the agent sends it for the steps it runs itself.
+func progressMessage(stepID int, p executor.Progress) ui.AgentMessage {
+	msg := ui.AgentMessage{Type: "progress", Tool: "shell_tool", StepID: stepID, DurationMs: p.Ran.Milliseconds()}
+	if p.Percent >= 0 {
+		percent := p.Percent
+		msg.Percent = &percent
+	}
+	if p.Output > 0 {
+		msg.Message = byteSize(p.Output) + " of output"
+	}
+	return msg
+}
+
+// showProgress shows and logs the progress of a running step. Unlike
+// HandleMessage, it may be called while another message is handled.
+func (mp *MessageProcessor) showProgress(msg ui.AgentMessage) {
+	mp.ui.PrintAgentMessage(msg, mp.minGoLogLevel)
+	logMessage(mp.log, msg)
+	mp.processManager.trace.received(msg)
+}
+
+// byteSize formats n bytes for people, e.g. "3.2 MB".
+func byteSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d bytes", n)
+	}
+	size, prefix := float64(n)/unit, 0
+	for ; size >= unit && prefix < 3; prefix++ {
+		size /= unit
+	}
+	return fmt.Sprintf("%.1f %cB", size, "KMGT"[prefix])
+}
@@ -0,0 +1,37 @@
+package agent
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+)
+
+// ProtocolVersion is the version of the NDJSON stdout / JSON stdin protocol this
+// client speaks. It must match PROTOCOL_VERSION in the agent's emitter.py.
+const ProtocolVersion = 28
+
+// protocolDecl matches the declaration in emitter.py.
+var protocolDecl = regexp.MustCompile(`^PROTOCOL_VERSION\s*=\s*(\d+)`)
+
+// AgentProtocolVersion reads the protocol version declared by the agent whose
+// entry point is agentPath, from emitter.py next to it. Reading the source avoids
+// starting Python and importing the agent's dependencies. Agents that predate
+// versioning declare none and yield 0.
+func AgentProtocolVersion(agentPath string) (int, error) {
+	path := filepath.Join(filepath.Dir(agentPath), "emitter.py")
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read agent protocol version: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m := protocolDecl.FindStringSubmatch(scanner.Text()); m != nil {
+			return strconv.Atoi(m[1])
+		}
+	}
+	return 0, scanner.Err()
+}
@@ -0,0 +1,228 @@
+package agent
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// recordingVersion is the version of the format of recordings.
+const recordingVersion = 1
+
+// Directions of the entries of a recording.
+const (
+	fromAgent = "agent" // A message of the agent
+	fromOG    = "og"    // A command og sent
+)
+
+// recordingHeader is the first line of a recording.
+type recordingHeader struct {
+	Recording int    `json:"og_recording"` // recordingVersion
+	Protocol  int    `json:"protocol"`     // The agent's protocol version
+	Query     string `json:"query"`        // The query the agent was given
+	Recorded  string `json:"recorded_at"`
+}
+
+// recordingEntry is a line of a recording after the header.
+type recordingEntry struct {
+	From    string          `json:"from"`  // fromAgent or fromOG
+	AtMs    int64           `json:"at_ms"` // Since the agent started
+	Message json.RawMessage `json:"message"`
+}
+
+// Recording is what og and an agent said to each other in a session, as
+// og --record writes it: a header line and then one line per message, in the
+// order og read and sent them.
+type Recording struct {
+	Protocol int
+	Query    string
+	entries  []recordingEntry
+}
+
+// LoadRecording reads the recording at path.
+func LoadRecording(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), maxFrameSize)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("%s is empty", path)
+	}
+	var header recordingHeader
+	if err := json.Unmarshal(scanner.Bytes(), &header); err != nil || header.Recording == 0 {
+		return nil, fmt.Errorf("%s is not a recording of og --record", path)
+	}
+	if header.Recording > recordingVersion {
+		return nil, fmt.Errorf("%s was recorded by a newer og (format %d)", path, header.Recording)
+	}
+	r := &Recording{Protocol: header.Protocol, Query: header.Query}
+	for line := 2; scanner.Scan(); line++ {
+		var e recordingEntry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || (e.From != fromAgent && e.From != fromOG) {
+			return nil, fmt.Errorf("%s:%d is not an entry of a recording", path, line)
+		}
+		r.entries = append(r.entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// recorder writes the messages og and the agent exchange to a recording.
+type recorder struct {
+	mu      sync.Mutex
+	w       io.Writer
+	started time.Time
+	header  bool // Written
+	err     error
+}
+
+// SetRecording has every message of the agent, and every command og sends it,
+// written to w (og --record), with secrets masked as in the session log. It
+// must be called before Start.
+func (pm *ProcessManager) SetRecording(w io.Writer) {
+	pm.recorder = &recorder{w: w}
+}
+
+// record adds an entry to the recording, if there is one; pm.mu need not be
+// held, as the agent's messages are read without it.
+func (pm *ProcessManager) record(from string, message []byte) {
+	r := pm.recorder
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.err != nil {
+		return
+	}
+	if !r.header {
+		r.header, r.started = true, time.Now()
+		r.write(recordingHeader{
+			Recording: recordingVersion,
+			Protocol:  pm.version,
+			Query:     pm.trace.redact(pm.launch.query),
+			Recorded:  r.started.Format(time.RFC3339),
+		})
+	}
+	masked := []byte(pm.trace.redact(string(message)))
+	if !json.Valid(masked) {
+		masked = message
+	}
+	r.write(recordingEntry{From: from, AtMs: time.Since(r.started).Milliseconds(), Message: masked})
+	if r.err != nil {
+		pm.ui.PrintColored(pm.ui.Red, "Error writing the recording: %v\n", r.err)
+	}
+}
+
+// write writes a line of the recording; r.mu must be held.
+func (r *recorder) write(line interface{}) {
+	b, err := json.Marshal(line)
+	if err == nil {
+		_, err = r.w.Write(append(b, '\n'))
+	}
+	r.err = err
+}
+
+// SetReplay has Start replay rec instead of starting an agent (og --replay):
+// the recorded messages of the agent are sent to og in order, and each
+// command og sends is checked against the recorded one. The session can be
+// replayed once. It must be called before Start.
+func (pm *ProcessManager) SetReplay(rec *Recording) {
+	pm.replay = rec
+}
+
+// startReplay starts replaying pm.replay; pm.mu must be held. It talks over
+// pipes as the Python agent does over stdio.
+func (pm *ProcessManager) startReplay(resume bool) error {
+	if resume || pm.replayed {
+		return fmt.Errorf("a replayed session cannot be restarted")
+	}
+	pm.replayed = true
+
+	stdinR, stdinW := io.Pipe()
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	proc := &inProcessAgent{done: make(chan struct{})}
+	proc.cancel = func() {
+		cancel()
+		stdoutW.Close()
+		stdinR.Close()
+	}
+	go func() {
+		proc.err = replay(ctx, pm.replay, stdinR, stdoutW)
+		stdoutW.Close()
+		stderrW.Close()
+		stdinR.Close()
+		close(proc.done)
+	}()
+
+	pm.version = pm.replay.Protocol
+	pm.followups = false
+	pm.stdinPipe = stdinW
+	pm.stdout = stdoutR
+	pm.stdoutScanner = pm.newScanner(stdoutR)
+	pm.stdoutScanner.mode = FramingLength // Recorded messages may exceed a line
+	if pm.minGoLogLevel <= ui.LogLevelDebug {
+		pm.ui.PrintColored(pm.ui.Magenta, "Agent: replay of a recording (protocol version %d)\n", pm.replay.Protocol)
+	}
+	return pm.watch(proc, stderrR, nil, Interpreter{Source: "replay"}, "replay")
+}
+
+// replay plays the agent's side of rec: it writes the agent's messages to out,
+// in the length framing, and reads og's commands from in, until the recording
+// or og's commands end. A command that is not the recorded one ends the replay with a "protocol"
+// error, as the session went differently than when it was recorded.
+func replay(ctx context.Context, rec *Recording, in io.Reader, out io.Writer) error {
+	commands := bufio.NewScanner(in)
+	commands.Buffer(make([]byte, 64*1024), maxFrameSize)
+	for i, e := range rec.entries {
+		if ctx.Err() != nil {
+			return nil
+		}
+		if e.From == fromAgent {
+			if err := writeFrame(out, e.Message); err != nil {
+				return nil // og stopped reading
+			}
+			continue
+		}
+		if !commands.Scan() {
+			return nil // og is done with the agent
+		}
+		got, want := commandType(commands.Bytes()), commandType(e.Message)
+		if got != want {
+			err := fmt.Errorf("the replay diverged at entry %d of the recording: og sent %q where %q was recorded", i+1, got, want)
+			msg, _ := json.Marshal(ui.AgentMessage{Type: "error", Kind: "protocol", Message: err.Error(), Location: "replay"})
+			_ = writeFrame(out, msg)
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFrame writes msg in the length framing.
+func writeFrame(w io.Writer, msg []byte) error {
+	_, err := fmt.Fprintf(w, "%d\n%s\n", len(msg), msg)
+	return err
+}
+
+// commandType returns the type of a command og sent.
+func commandType(command []byte) string {
+	var c struct {
+		Type string `json:"type"`
+	}
+	_ = json.Unmarshal(command, &c)
+	return c.Type
+}
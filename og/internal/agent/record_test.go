@@ -0,0 +1,129 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/executor"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// approvingUI approves every step it is asked about.
+type approvingUI struct {
+	*ui.ConsoleUI
+}
+
+func (approvingUI) PromptForApproval(string) bool { return true }
+func (approvingUI) PromptForApprovalChoice(string, bool) ui.ApprovalChoice {
+	return ui.ApprovalYes
+}
+
+// echoSession is a recording of a session of the echo agent (og selftest),
+// made with og --record: a plan of one step, its approval, the step run by og
+// and the summary.
+const echoSession = "testdata/echo_session.jsonl"
+
+func TestReplayRecording(t *testing.T) {
+	rec, err := LoadRecording(echoSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Protocol != 28 || rec.Query != "Check that og works (og selftest)" {
+		t.Fatalf("LoadRecording: protocol %d, query %q", rec.Protocol, rec.Query)
+	}
+
+	dir := t.TempDir()
+	cfg := &config.OGConfig{}
+	console := approvingUI{ui.NewConsoleUI()}
+	engine, err := policy.New(cfg.Policy, policy.TrustDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pm := NewProcessManager(console, ui.LogLevelNone)
+	pm.SetReplay(rec)
+	mp := NewMessageProcessor(pm, console, ui.LogLevelNone, engine, nil, redactor, SessionInfo{Hash: "replay", Workdir: dir})
+	mp.SetExecutor(executor.New(dir, time.Minute, redactor.String))
+	var ran []string
+	mp.OnExecution(func(entry audit.Entry, output string) {
+		ran = append(ran, entry.Command+" => "+strings.TrimSpace(output))
+	})
+
+	if err := pm.Start(cfg, "replay", rec.Query, dir, policy.TrustDefault.String(), false, dir); err != nil {
+		t.Fatal(err)
+	}
+	err = mp.ProcessMessages()
+	pm.Stop()
+	if err != nil {
+		t.Fatalf("ProcessMessages: %v", err)
+	}
+	if got := mp.Outcome(); got != OutcomeCompleted {
+		t.Errorf("Outcome() = %q, want %q", got, OutcomeCompleted)
+	}
+	if len(ran) != 1 || !strings.HasPrefix(ran[0], "echo og selftest => ") || !strings.Contains(ran[0], "og selftest") {
+		t.Errorf("executed %q, want the recorded step echo og selftest", ran)
+	}
+	if err := pm.Restart(false); err == nil {
+		t.Error("a replayed session restarted")
+	}
+}
+
+func TestReplayDiverges(t *testing.T) {
+	rec, err := LoadRecording(echoSession)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// og quits at the plan instead of executing it as recorded
+	in := strings.NewReader(`{"type":"abort"}` + "\n")
+	outR, outW := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		done <- replay(context.Background(), rec, in, outW)
+		outW.Close()
+	}()
+	frames := NewFrameScanner(outR)
+	frames.mode = FramingLength
+	var last ui.AgentMessage
+	for frames.Scan() {
+		if err := json.Unmarshal(frames.Bytes(), &last); err != nil {
+			t.Fatalf("replay wrote %q: %v", frames.Bytes(), err)
+		}
+	}
+	if err := <-done; err == nil || !strings.Contains(err.Error(), `og sent "abort" where "execute_single_action" was recorded`) {
+		t.Errorf("replay returned %v, want a divergence", err)
+	}
+	if last.Type != "error" || last.Kind != "protocol" {
+		t.Errorf("the last message of the replay is %+v, want a protocol error", last)
+	}
+}
+
+func TestLoadRecordingRejects(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"empty":   "",
+		"log":     `{"level":"info","msg":"hello"}` + "\n",
+		"newer":   `{"og_recording":99,"protocol":28}` + "\n",
+		"entries": `{"og_recording":1,"protocol":28}` + "\n" + `{"from":"nobody","message":{}}` + "\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadRecording(path); err == nil {
+			t.Errorf("LoadRecording accepts a file that is %s", name)
+		}
+	}
+}
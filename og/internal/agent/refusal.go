@@ -0,0 +1,25 @@
+package agent
+
+import "github.com/robbiemu/original_gangster/og/internal/policy"
+
+// OnRefusal registers a function that is called with the actions the user
+// refuses and why, e.g. to remember them for later sessions in the directory.
+// While one is registered, og asks the user why after each refusal.
+func (mp *MessageProcessor) OnRefusal(fn func(action policy.Action, reason string)) {
+	mp.onRefusal = fn
+}
+
+// askWhyRefused asks the user why they refused action and passes both on to the
+// OnRefusal function, with secrets masked. Refusals the user gives no reason
+// for are not passed on: they may be about this session only.
+func (mp *MessageProcessor) askWhyRefused(action policy.Action) {
+	if mp.onRefusal == nil {
+		return
+	}
+	reason, ok := mp.ui.PromptForInput("Why not? The agent is told in later sessions here (enter to skip)")
+	if !ok || reason == "" {
+		return
+	}
+	action.Command = mp.redactor.String(action.Command)
+	mp.onRefusal(action, mp.redactor.String(reason))
+}
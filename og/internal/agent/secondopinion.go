@@ -0,0 +1,73 @@
+package agent
+
+import (
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/secondopinion"
+)
+
+// SetSecondOpinion has a second model audit dangerous actions before the user
+// confirms them. Without it, the agent's auditor decides alone.
+func (mp *MessageProcessor) SetSecondOpinion(a *secondopinion.Auditor) {
+	mp.secondOpinion = a
+}
+
+// secondOpinionPasses has the second auditor judge the dangerous actions among
+// actions, each once per session, and reports whether they may go on to the
+// user's confirmation. A verdict that disagrees with the agent's auditor, or
+// none at all, is shown before the user decides, or denies the actions when
+// second_opinion.on_disagreement is "deny". Verdicts are recorded in the audit
+// log with the role "second_opinion".
+func (mp *MessageProcessor) secondOpinionPasses(actions ...policy.Action) bool {
+	if mp.secondOpinion == nil {
+		return true
+	}
+	name := mp.secondOpinion.Name()
+	passes := true
+	for _, action := range actions {
+		danger, dangerous := policy.ClassifyDanger(action.Command)
+		if !dangerous {
+			continue
+		}
+		key := approvalKey(action)
+		if v, seen := mp.secondOpinions[key]; seen {
+			if !v.Safe {
+				mp.ui.PrintColored(mp.ui.Red, "⚠️  %s disagreed with the auditor on %s earlier: %s\n", name, mp.ui.Cyan(action.Command), v.Reason)
+				passes = false
+			}
+			continue
+		}
+		mp.ui.PrintColored(mp.ui.Blue, "🔍 Asking %s for a second opinion on %s...\n", name, mp.ui.Cyan(action.Command))
+		v, err := mp.secondOpinion.Audit(action, danger, mp.info.Workdir)
+		switch {
+		case err != nil:
+			v = secondopinion.Verdict{Reason: "no verdict: " + err.Error()}
+			mp.ui.PrintColored(mp.ui.Red, "⚠️  No second opinion from %s (%v); the audits do not agree.\n", name, err)
+		case v.Safe:
+			mp.ui.PrintColored(mp.ui.Green, "✅ %s agrees with the auditor: %s\n", name, v.Reason)
+		default:
+			mp.ui.PrintColored(mp.ui.Red, "⚠️  The auditors disagree: %s calls it unsafe: %s\n", name, v.Reason)
+		}
+		mp.secondOpinions[key] = v
+		decision := "denied"
+		if v.Safe {
+			decision = "approved"
+		}
+		mp.appendAudit(audit.Entry{
+			Session:  mp.info.Hash,
+			Event:    audit.EventApproval,
+			Tool:     action.Tool,
+			Command:  mp.redactor.String(action.Command),
+			Decision: decision,
+			Identity: name,
+			Role:     "second_opinion",
+			Reason:   mp.redactor.String(v.Reason),
+		})
+		passes = passes && v.Safe
+	}
+	if !passes && mp.secondOpinion.Deny {
+		mp.ui.PrintColored(mp.ui.Red, "🚫 Both audits must pass (second_opinion.on_disagreement = \"deny\").\n")
+		return false
+	}
+	return true
+}
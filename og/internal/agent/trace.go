@@ -0,0 +1,166 @@
+package agent
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/robbiemu/original_gangster/og/internal/telemetry"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// agentTrace traces what the agent does for a session ([telemetry]): each
+// protocol round-trip, from og handing the agent the query or a command until
+// the agent next waits for og, and each step it executes. Within a round-trip,
+// the time up to each message is attributed to the agent role that sent it, so
+// that planning, auditing and execution can be told apart.
+type agentTrace struct {
+	mu     sync.Mutex
+	ctx    context.Context     // The session's span, see SetTraceContext
+	redact func(string) string // Masks secrets in the commands of steps
+
+	trip     trace.Span // The open round-trip, nil while og has the turn
+	tripCtx  context.Context
+	role     string    // The role the open segment of the round-trip is attributed to
+	segStart time.Time // Of the open segment
+	segEnd   time.Time
+	step     trace.Span // The step being executed
+}
+
+// waitingMessages are the messages after which the agent waits for og: for an
+// answer, to run a command, or to end the session.
+var waitingMessages = map[string]bool{
+	"error": true, "unsafe": true, "plan": true, "request_approval": true,
+	"proposed_patch": true, "sql_query": true, "mcp_call": true, "check_condition": true,
+	"check_iteration": true, "step_failed": true, "run_command": true,
+	"request_input": true, "final_summary": true, "cancelled": true,
+	"deny_current_action": true, "pre_step": true, "post_step": true,
+}
+
+func newAgentTrace() *agentTrace {
+	return &agentTrace{ctx: context.Background(), redact: func(s string) string { return s }}
+}
+
+// begin opens a round-trip for what og handed the agent: "query" when it
+// starts, or a command. A command sent while the agent has the turn, such as
+// cancel, is recorded as an event of the open round-trip.
+func (t *agentTrace) begin(what string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.trip != nil {
+		t.trip.AddEvent(what)
+		return
+	}
+	t.tripCtx, t.trip = telemetry.Tracer().Start(t.ctx, "agent."+what)
+	t.role, t.segStart = "", time.Now()
+}
+
+// received records a message of the agent.
+func (t *agentTrace) received(msg ui.AgentMessage) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	switch msg.Type {
+	case "step_started":
+		t.endStep("")
+		_, t.step = telemetry.Tracer().Start(t.ctx, "step", trace.WithAttributes(
+			attribute.String("og.tool", msg.Tool),
+			attribute.String("og.command", t.redact(msg.Action)),
+			attribute.Int("og.step", msg.Step),
+			attribute.Int("og.step_id", msg.StepID),
+		))
+	case "result":
+		if t.step != nil && msg.ExitCode != nil {
+			t.step.SetAttributes(attribute.Int("og.exit_code", *msg.ExitCode))
+		}
+		t.endStep(msg.Status)
+	case "progress":
+		if t.step != nil {
+			attrs := []attribute.KeyValue{attribute.Int64("og.ran_ms", msg.DurationMs)}
+			if msg.Percent != nil {
+				attrs = append(attrs, attribute.Float64("og.percent", *msg.Percent))
+			}
+			t.step.AddEvent("progress", trace.WithAttributes(attrs...))
+		}
+		return // Progress is not a reply to og
+	}
+	if t.trip == nil {
+		return
+	}
+	if msg.Role != "" {
+		if t.role != "" && msg.Role != t.role {
+			t.segment()
+			t.segStart = t.segEnd
+		}
+		t.role, t.segEnd = msg.Role, now
+	}
+	if waitingMessages[msg.Type] {
+		t.trip.SetAttributes(attribute.String("og.reply", msg.Type))
+		t.endTrip()
+	}
+}
+
+// segment records the open segment of the round-trip as a span named after
+// its role.
+func (t *agentTrace) segment() {
+	if t.role == "" {
+		return
+	}
+	_, span := telemetry.Tracer().Start(t.tripCtx, t.role, trace.WithTimestamp(t.segStart))
+	span.End(trace.WithTimestamp(t.segEnd))
+	t.role = ""
+}
+
+func (t *agentTrace) endTrip() {
+	if t.trip == nil {
+		return
+	}
+	t.segment()
+	t.trip.End()
+	t.trip = nil
+}
+
+// endStep ends the span of the step being executed, with its status.
+func (t *agentTrace) endStep(status string) {
+	if t.step == nil {
+		return
+	}
+	if status != "" {
+		t.step.SetAttributes(attribute.String("og.status", status))
+	}
+	if status != "" && status != "success" {
+		t.step.SetStatus(codes.Error, status)
+	}
+	t.step.End()
+	t.step = nil
+}
+
+// startTraced starts the agent in a span named name, and, unless it resumes
+// the session, opens the round-trip of the query it plans for; pm.mu must be
+// held.
+func (pm *ProcessManager) startTraced(name string, resume bool) error {
+	_, span := telemetry.Tracer().Start(pm.trace.ctx, name, trace.WithAttributes(attribute.Bool("og.resume", resume)))
+	defer span.End()
+	if err := pm.start(resume); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return err
+	}
+	span.SetAttributes(attribute.Int("og.protocol", pm.version))
+	if !resume {
+		pm.trace.begin("query")
+	}
+	return nil
+}
+
+// end ends the round-trip and step that are open when the agent is stopped.
+func (t *agentTrace) end() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.endStep("")
+	t.endTrip()
+}
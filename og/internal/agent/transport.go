@@ -0,0 +1,191 @@
+package agent
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Transports between og and the agent, see general.agent_transport.
+const (
+	TransportStdio  = "stdio"  // Messages on the agent's stdout, commands on its stdin
+	TransportSocket = "socket" // A Unix domain socket the agent connects back to
+)
+
+// socketVersion is the first protocol version whose agents accept --socket.
+const socketVersion = 9
+
+// connectTimeout is how long the agent may take to connect to the socket,
+// which it does once its imports are done.
+const connectTimeout = 2 * time.Minute
+
+// Channels of the socket transport. The agent opens a connection for each and
+// names it on its first line, {"channel": "control"}; messages on both are
+// length-framed from the start.
+const (
+	channelControl = "control" // Messages to og and commands to the agent
+	channelLog     = "log"     // Log messages, which never hold up the session
+)
+
+// errNotConnected is returned by SendCommand when the agent exited before it
+// connected to the socket.
+var errNotConnected = errors.New("the agent is not connected")
+
+// agentSocket is the Unix domain socket og listens on for the agent's channels.
+type agentSocket struct {
+	dir string // Private directory holding the socket
+	ln  *net.UnixListener
+}
+
+// listenSocket creates a socket in a directory of its own, readable by the
+// user only. Its path is kept short, since socket paths are limited to about
+// 100 bytes.
+func listenSocket() (*agentSocket, error) {
+	dir, err := os.MkdirTemp("", "og-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: filepath.Join(dir, "agent.sock"), Net: "unix"})
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen for the agent: %w", err)
+	}
+	return &agentSocket{dir: dir, ln: ln}, nil
+}
+
+// Path returns the path the agent connects to.
+func (s *agentSocket) Path() string {
+	return s.ln.Addr().String()
+}
+
+// accept waits for the agent to open its control and log channels. It returns
+// nil connections without an error if the agent exits first.
+func (s *agentSocket) accept(exited <-chan struct{}) (control, log *net.UnixConn, err error) {
+	type channel struct {
+		name string
+		conn *net.UnixConn
+		err  error
+	}
+	accepted := make(chan channel, 2)
+	go func() {
+		defer close(accepted)
+		for range 2 {
+			conn, err := s.ln.AcceptUnix()
+			if err != nil {
+				accepted <- channel{err: err}
+				return
+			}
+			name, err := readChannelName(conn)
+			accepted <- channel{name, conn, err}
+		}
+	}()
+	timeout := time.After(connectTimeout)
+	closeAll := func() {
+		for _, c := range []*net.UnixConn{control, log} {
+			if c != nil {
+				c.Close()
+			}
+		}
+		s.ln.Close() // Ends the accepting goroutine
+		go func() {
+			for c := range accepted {
+				if c.conn != nil {
+					c.conn.Close()
+				}
+			}
+		}()
+	}
+	for control == nil || log == nil {
+		select {
+		case c := <-accepted:
+			if c.err != nil {
+				if c.conn != nil {
+					c.conn.Close()
+				}
+				closeAll()
+				return nil, nil, fmt.Errorf("failed to accept the agent's connection: %w", c.err)
+			}
+			switch {
+			case c.name == channelControl && control == nil:
+				control = c.conn
+			case c.name == channelLog && log == nil:
+				log = c.conn
+			default:
+				c.conn.Close()
+				closeAll()
+				return nil, nil, fmt.Errorf("the agent opened an unexpected %q channel", c.name)
+			}
+		case <-exited:
+			closeAll()
+			return nil, nil, nil
+		case <-timeout:
+			closeAll()
+			return nil, nil, fmt.Errorf("the agent did not connect to %s within %s", s.Path(), connectTimeout)
+		}
+	}
+	return control, log, nil
+}
+
+// readChannelName reads the first line of a connection, which names its
+// channel. It reads a byte at a time, leaving the messages that follow to the
+// channel's reader.
+func readChannelName(conn *net.UnixConn) (string, error) {
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	defer conn.SetReadDeadline(time.Time{})
+	var line []byte
+	b := make([]byte, 1)
+	for len(line) < 256 {
+		if _, err := conn.Read(b); err != nil {
+			return "", fmt.Errorf("failed to read the channel name: %w", err)
+		}
+		if b[0] == '\n' {
+			var hello struct {
+				Channel string `json:"channel"`
+			}
+			if err := json.Unmarshal(line, &hello); err != nil {
+				return "", fmt.Errorf("invalid channel name %q", line)
+			}
+			return hello.Channel, nil
+		}
+		line = append(line, b[0])
+	}
+	return "", fmt.Errorf("channel name too long")
+}
+
+// Close stops listening and removes the socket's directory.
+func (s *agentSocket) Close() {
+	s.ln.Close()
+	os.RemoveAll(s.dir)
+}
+
+// controlWriter sends commands over the control channel. Closing it only
+// closes the agent's side for reading, so the agent sees the end of its
+// commands while its last messages can still be read.
+type controlWriter struct {
+	*net.UnixConn
+}
+
+func (w controlWriter) Close() error {
+	return w.CloseWrite()
+}
+
+// printLogChannel prints the messages of the log channel, and records them in
+// the session log, until it closes.
+func printLogChannel(conn *net.UnixConn, console ui.UI, minGoLogLevel ui.LogLevel, log *slog.Logger) {
+	scanner := NewFrameScanner(conn)
+	scanner.mode = FramingLength
+	for scanner.Scan() {
+		var msg ui.AgentMessage
+		if json.Unmarshal(scanner.Bytes(), &msg) == nil {
+			console.PrintAgentMessage(msg, minGoLogLevel)
+			logMessage(log, msg)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package agent
+
+import (
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/patch"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/undo"
+)
+
+// stepHooks reports whether the agent announces each step with "pre_step"
+// and "post_step" and waits for og: to run [hooks], to back up the files the
+// step changes (undo.enabled), or to record the git repository before it
+// (undo.git_checkpoint).
+func stepHooks(cfg *config.OGConfig) bool {
+	return len(cfg.Hooks.PreStep) > 0 || len(cfg.Hooks.PostStep) > 0 || cfg.Undo.Enabled ||
+		cfg.Undo.GitCheckpoint != "" && cfg.Undo.GitCheckpoint != "off"
+}
+
+// SetUndo has the files steps are about to change backed up in j, for og
+// undo.
+func (mp *MessageProcessor) SetUndo(j *undo.Journal) {
+	mp.undo = j
+}
+
+// backUpStep backs up the files the shell step of a "pre_step" looks like it
+// changes, see undo.Targets.
+func (mp *MessageProcessor) backUpStep(msg ui.AgentMessage) {
+	if msg.Type == "pre_step" && msg.Tool == "shell_tool" && mp.undo != nil {
+		mp.backUp(msg.Tool, undo.Targets(msg.Action, mp.info.Workdir))
+	}
+}
+
+// backUpPatch backs up the files patches change, before they are applied.
+func (mp *MessageProcessor) backUpPatch(patches []patch.FilePatch) {
+	if mp.undo == nil {
+		return
+	}
+	var paths []string
+	for _, fp := range patches {
+		for _, p := range []string{fp.OldPath, fp.NewPath} {
+			if p != "" {
+				paths = append(paths, filepath.Join(mp.info.Workdir, p))
+			}
+		}
+	}
+	mp.backUp("apply_patch", paths)
+}
+
+// backUp backs up paths before a step of tool changes them. The files it
+// cannot back up are named, as og undo cannot restore them.
+func (mp *MessageProcessor) backUp(tool string, paths []string) {
+	if len(paths) == 0 {
+		return
+	}
+	skipped, err := mp.undo.Snapshot(tool, paths)
+	if err != nil {
+		mp.log.Warn("backup failed", "tool", tool, "error", err.Error())
+		mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Could not back up the files the step changes, for og undo: %v\n", err)
+	}
+	if len(skipped) > 0 {
+		for i, p := range skipped {
+			if rel, err := filepath.Rel(mp.info.Workdir, p); err == nil {
+				skipped[i] = rel
+			}
+		}
+		mp.ui.PrintColored(mp.ui.Yellow, "⚠️  Not backed up, so og undo cannot restore them: %s\n", strings.Join(skipped, ", "))
+	}
+}
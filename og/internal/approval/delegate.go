@@ -0,0 +1,116 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+const defaultDelegationTimeout = 10 * time.Minute
+
+// Request is the JSON body POSTed to the delegation webhook.
+type Request struct {
+	Session   string   `json:"session"`
+	Requester string   `json:"requester"`
+	Tool      string   `json:"tool"`
+	Command   string   `json:"command"`
+	Reason    string   `json:"reason"`
+	Workdir   string   `json:"workdir"`
+	Cloud     string   `json:"cloud,omitempty"` // Cloud CLI contexts the command acts on, e.g. "kubectl context prod"
+	Approvers []string `json:"approvers,omitempty"`
+}
+
+// Decision is the JSON body the webhook answers with once an approver has decided.
+type Decision struct {
+	Approved bool   `json:"approved"`
+	Approver string `json:"approver"`
+	Comment  string `json:"comment,omitempty"`
+}
+
+// Delegator relays approval requests to a second approver through a webhook. The
+// webhook (for example a small Slack or chat bridge) holds the request open until
+// a designated approver answers, then responds with a Decision.
+type Delegator struct {
+	url       string
+	token     string
+	approvers []string
+	timeout   time.Duration
+	client    *http.Client
+}
+
+// NewDelegator returns a Delegator for the config, or nil if no webhook is configured.
+func NewDelegator(cfg config.DelegationCfg) *Delegator {
+	if cfg.WebhookURL == "" {
+		return nil
+	}
+	timeout := time.Duration(cfg.TimeoutMinutes) * time.Minute
+	if timeout <= 0 {
+		timeout = defaultDelegationTimeout
+	}
+	return &Delegator{
+		url:       cfg.WebhookURL,
+		token:     cfg.Token,
+		approvers: cfg.Approvers,
+		timeout:   timeout,
+		client:    &http.Client{},
+	}
+}
+
+// Timeout returns how long Ask waits for a decision.
+func (d *Delegator) Timeout() time.Duration {
+	return d.timeout
+}
+
+// Ask sends the request to the webhook and waits for the second approver's decision.
+// A decision from the requester themselves, or from someone not in the configured
+// approvers list, is rejected.
+func (d *Delegator) Ask(req Request) (Decision, error) {
+	req.Approvers = d.approvers
+	body, err := json.Marshal(req)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to marshal approval request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), d.timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, d.url, bytes.NewReader(body))
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to create approval request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if d.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+d.token)
+	}
+
+	resp, err := d.client.Do(httpReq)
+	if err != nil {
+		return Decision{}, fmt.Errorf("failed to reach approval webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Decision{}, fmt.Errorf("approval webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, fmt.Errorf("failed to decode approval decision: %w", err)
+	}
+	if decision.Approver == "" {
+		return Decision{}, fmt.Errorf("approval decision did not identify the approver")
+	}
+	if decision.Approver == req.Requester {
+		return decision, fmt.Errorf("approver '%s' cannot approve their own request", decision.Approver)
+	}
+	if len(d.approvers) > 0 && !slices.Contains(d.approvers, decision.Approver) {
+		return decision, fmt.Errorf("'%s' is not a designated approver", decision.Approver)
+	}
+	return decision, nil
+}
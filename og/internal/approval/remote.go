@@ -0,0 +1,383 @@
+package approval
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// callbackPath is where the listener of a Remote accepts decisions, followed by
+// the ID of the request they decide.
+const callbackPath = "/og/approvals/"
+
+// RemoteRequest is the JSON body POSTed to the remote approval webhook.
+type RemoteRequest struct {
+	ID          string   `json:"id"`
+	Session     string   `json:"session"`
+	Requester   string   `json:"requester"`
+	Kind        string   `json:"kind"`    // "approval", or "typed_confirmation" for dangerous commands
+	Message     string   `json:"message"` // What the console would have asked
+	Tool        string   `json:"tool,omitempty"`
+	Command     string   `json:"command,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Steps       []string `json:"steps,omitempty"` // The commands of a recipe, for approvals of a plan
+	Workdir     string   `json:"workdir"`
+	CallbackURL string   `json:"callback_url,omitempty"` // Where to send the Decision, with callback = "listen"
+	Expires     string   `json:"expires"`                // RFC3339; the request is denied when undecided by then
+}
+
+// Remote has approval requests decided by someone who is not at og's terminal
+// ([remote_approval]). Each request is POSTed to the webhook, and the decision
+// comes back on a local HTTP listener, at CallbackURL, or from polling the
+// poll URL, which answers 202 or 404 until a decision was made.
+type Remote struct {
+	cfg       config.RemoteApprovalCfg
+	session   string
+	requester string
+	workdir   string
+	timeout   time.Duration
+	client    *http.Client
+
+	mu       sync.Mutex
+	server   *http.Server               // Started by the first request, with callback = "listen"
+	pending  map[string]*pendingRequest // Requests waiting for a callback, by ID
+	closeErr error
+}
+
+// pendingRequest is a request waiting for its decision on the listener.
+type pendingRequest struct {
+	req     RemoteRequest
+	decided chan Decision
+}
+
+// NewRemote returns a Remote for the config, or nil if approvals are not to be
+// decided remotely: the mode is "off", or "headless" while stdin is a terminal.
+func NewRemote(cfg config.RemoteApprovalCfg, session, requester, workdir string) *Remote {
+	switch cfg.Mode {
+	case "always":
+	case "headless":
+		if term.IsTerminal(int(os.Stdin.Fd())) {
+			return nil
+		}
+	default:
+		return nil
+	}
+	return &Remote{
+		cfg:       cfg,
+		session:   session,
+		requester: requester,
+		workdir:   workdir,
+		timeout:   time.Duration(cfg.TimeoutSeconds) * time.Second,
+		client:    &http.Client{Timeout: 30 * time.Second},
+		pending:   make(map[string]*pendingRequest),
+	}
+}
+
+// Timeout returns how long Ask waits for a decision.
+func (r *Remote) Timeout() time.Duration {
+	return r.timeout
+}
+
+// AllowsDangerous reports whether typed confirmations of dangerous commands
+// are relayed; they are denied otherwise.
+func (r *Remote) AllowsDangerous() bool {
+	return r.cfg.AllowDangerous
+}
+
+// Ask sends the request to the webhook and waits for the decision. A request
+// still undecided after the timeout is denied with an error.
+func (r *Remote) Ask(req RemoteRequest) (Decision, error) {
+	id, err := newRequestID()
+	if err != nil {
+		return Decision{}, err
+	}
+	req.ID, req.Session, req.Requester, req.Workdir = id, r.session, r.requester, r.workdir
+	req.Expires = time.Now().Add(r.timeout).UTC().Format(time.RFC3339)
+
+	var decided chan Decision
+	if r.cfg.Callback == "listen" {
+		if err := r.listen(); err != nil {
+			return Decision{}, err
+		}
+		req.CallbackURL = r.callbackBase() + callbackPath + id
+		decided = make(chan Decision, 1)
+		r.mu.Lock()
+		r.pending[id] = &pendingRequest{req: req, decided: decided}
+		r.mu.Unlock()
+		defer func() {
+			r.mu.Lock()
+			delete(r.pending, id)
+			r.mu.Unlock()
+		}()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	if err := r.post(ctx, req); err != nil {
+		return Decision{}, err
+	}
+
+	var decision Decision
+	if decided != nil {
+		select {
+		case decision = <-decided:
+		case <-ctx.Done():
+			return Decision{}, fmt.Errorf("no decision within %s", r.timeout)
+		}
+	} else if decision, err = r.poll(ctx, id); err != nil {
+		return Decision{}, err
+	}
+	if decision.Approver == "" {
+		return Decision{}, fmt.Errorf("the decision did not identify the approver")
+	}
+	return decision, nil
+}
+
+// post sends req to the webhook.
+func (r *Remote) post(ctx context.Context, req RemoteRequest) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal approval request: %w", err)
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create approval request: %w", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	r.authorize(httpReq)
+	resp, err := r.client.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to reach approval webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("approval webhook returned %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// poll asks the poll URL for the decision on request id every poll interval,
+// until it has one or ctx ends. Errors reaching it are retried.
+func (r *Remote) poll(ctx context.Context, id string) (Decision, error) {
+	pollURL := r.cfg.PollURL
+	if strings.Contains(pollURL, "{id}") {
+		pollURL = strings.ReplaceAll(pollURL, "{id}", url.PathEscape(id))
+	} else if u, err := url.Parse(pollURL); err == nil {
+		q := u.Query()
+		q.Set("id", id)
+		u.RawQuery = q.Encode()
+		pollURL = u.String()
+	}
+	ticker := time.NewTicker(time.Duration(r.cfg.PollIntervalSeconds) * time.Second)
+	defer ticker.Stop()
+	var lastErr error
+	for {
+		select {
+		case <-ctx.Done():
+			if lastErr != nil {
+				return Decision{}, fmt.Errorf("no decision within %s (last poll: %v)", r.timeout, lastErr)
+			}
+			return Decision{}, fmt.Errorf("no decision within %s", r.timeout)
+		case <-ticker.C:
+		}
+		decision, ok, err := r.pollOnce(ctx, pollURL)
+		if ok {
+			return decision, nil
+		}
+		if err != nil {
+			lastErr = err
+		}
+	}
+}
+
+// pollOnce asks the poll URL once. It reports false while no decision was made.
+func (r *Remote) pollOnce(ctx context.Context, pollURL string) (Decision, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pollURL, nil)
+	if err != nil {
+		return Decision{}, false, err
+	}
+	r.authorize(req)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return Decision{}, false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusAccepted || resp.StatusCode == http.StatusNoContent || resp.StatusCode == http.StatusNotFound:
+		return Decision{}, false, nil
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return Decision{}, false, fmt.Errorf("poll URL returned %s", resp.Status)
+	}
+	var decision Decision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return Decision{}, false, fmt.Errorf("failed to decode approval decision: %w", err)
+	}
+	return decision, true, nil
+}
+
+// authorize adds the bearer token, if any, to req.
+func (r *Remote) authorize(req *http.Request) {
+	if r.cfg.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+r.cfg.Token)
+	}
+}
+
+// callbackBase returns the listener's URL as the relay reaches it.
+func (r *Remote) callbackBase() string {
+	if r.cfg.CallbackURL != "" {
+		return strings.TrimSuffix(r.cfg.CallbackURL, "/")
+	}
+	return "http://" + r.cfg.ListenAddr
+}
+
+// listen starts the callback listener, once.
+func (r *Remote) listen() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.server != nil {
+		return nil
+	}
+	ln, err := net.Listen("tcp", r.cfg.ListenAddr)
+	if err != nil {
+		return fmt.Errorf("cannot listen for approval decisions on %s: %w", r.cfg.ListenAddr, err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(callbackPath, r.handleCallback)
+	r.server = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := r.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.mu.Lock()
+			r.closeErr = err
+			r.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+// handleCallback takes the decision on the request named by the path. It is
+// POSTed as a JSON Decision, or as the form or query parameters approved (true
+// or false), approver and comment. A GET, as when a link in a chat message is
+// opened, or fetched for its preview, decides nothing: it shows the request with
+// a form that POSTs the decision, and a GET carrying a decision is refused. The
+// request's ID, which is random, is what authorizes the decision.
+func (r *Remote) handleCallback(w http.ResponseWriter, req *http.Request) {
+	id := strings.TrimPrefix(req.URL.Path, callbackPath)
+	r.mu.Lock()
+	pending, ok := r.pending[id]
+	r.mu.Unlock()
+	if !ok {
+		http.Error(w, "no pending approval request with this ID", http.StatusNotFound)
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPost:
+	case http.MethodGet, http.MethodHead:
+		if req.URL.Query().Has("approved") {
+			w.Header().Set("Allow", http.MethodPost)
+			http.Error(w, "decisions are only taken over POST; open the link without approved to decide", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		if err := confirmPage.Execute(w, pending.req); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "POST a decision", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var decision Decision
+	if !strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") && !req.URL.Query().Has("approved") {
+		if err := json.NewDecoder(io.LimitReader(req.Body, 1<<16)).Decode(&decision); err != nil {
+			http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		req.Body = http.MaxBytesReader(w, req.Body, 1<<16)
+		if err := req.ParseForm(); err != nil {
+			http.Error(w, "invalid decision: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		approved, err := strconv.ParseBool(req.Form.Get("approved"))
+		if err != nil {
+			http.Error(w, "approved must be true or false", http.StatusBadRequest)
+			return
+		}
+		decision = Decision{Approved: approved, Approver: req.Form.Get("approver"), Comment: req.Form.Get("comment")}
+	}
+
+	select {
+	case pending.decided <- decision:
+		fmt.Fprintln(w, "Decision recorded.")
+	default:
+		http.Error(w, "this request was already decided", http.StatusConflict)
+	}
+}
+
+// confirmPage shows a pending request, with a form that POSTs the decision to
+// the URL it was opened at.
+var confirmPage = template.Must(template.New("confirm").Parse(`<!DOCTYPE html>
+<html><head><meta charset="utf-8"><meta name="robots" content="noindex"><title>og approval request</title></head>
+<body>
+<h1>{{.Requester}} asks for your approval</h1>
+<p>{{.Message}}</p>
+{{with .Description}}<p>{{.}}</p>{{end}}
+{{with .Tool}}<p>Tool: <code>{{.}}</code></p>{{end}}
+{{with .Command}}<pre>{{.}}</pre>{{end}}
+{{with .Steps}}<ol>{{range .}}<li><code>{{.}}</code></li>{{end}}</ol>{{end}}
+<p>In <code>{{.Workdir}}</code>, session {{.Session}}. Expires {{.Expires}}.</p>
+<form method="post">
+<p><label>Your name <input name="approver" required></label></p>
+<p><label>Comment <input name="comment"></label></p>
+<p><button name="approved" value="true">Approve</button> <button name="approved" value="false">Deny</button></p>
+</form>
+</body></html>
+`))
+
+// Close stops the callback listener, if it was started.
+func (r *Remote) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.server == nil {
+		return r.closeErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := r.server.Shutdown(ctx); err != nil {
+		return err
+	}
+	return r.closeErr
+}
+
+// newRequestID returns a random ID that is hard to guess, as it authorizes
+// the decision sent to the listener.
+func newRequestID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate an approval request ID: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,87 @@
+package approval
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// pendingRemote returns a Remote with a request pending under id.
+func pendingRemote(id string) (*Remote, chan Decision) {
+	decided := make(chan Decision, 1)
+	r := &Remote{pending: map[string]*pendingRequest{
+		id: {req: RemoteRequest{ID: id, Requester: "alice", Message: "Execute step?", Command: "make <deploy>"}, decided: decided},
+	}}
+	return r, decided
+}
+
+func TestCallbackDecisions(t *testing.T) {
+	tests := []struct {
+		name        string
+		method, url string
+		contentType string
+		body        string
+		status      int
+		decided     *Decision
+	}{
+		{"link preview with a decision", "GET", "/og/approvals/abc?approved=true&approver=bob", "", "", http.StatusMethodNotAllowed, nil},
+		{"link preview denying", "GET", "/og/approvals/abc?approved=false&approver=bob", "", "", http.StatusMethodNotAllowed, nil},
+		{"link preview", "GET", "/og/approvals/abc", "", "", http.StatusOK, nil},
+		{"head", "HEAD", "/og/approvals/abc", "", "", http.StatusOK, nil},
+		{"put", "PUT", "/og/approvals/abc", "application/json", `{"approved":true,"approver":"bob"}`, http.StatusMethodNotAllowed, nil},
+		{"unknown request", "POST", "/og/approvals/xyz", "application/json", `{"approved":true,"approver":"bob"}`, http.StatusNotFound, nil},
+		{"confirmation form", "POST", "/og/approvals/abc", "application/x-www-form-urlencoded", "approved=true&approver=bob&comment=ok", http.StatusOK, &Decision{Approved: true, Approver: "bob", Comment: "ok"}},
+		{"query parameters", "POST", "/og/approvals/abc?approved=false&approver=bob", "", "", http.StatusOK, &Decision{Approved: false, Approver: "bob"}},
+		{"json", "POST", "/og/approvals/abc", "application/json", `{"approved":true,"approver":"bob"}`, http.StatusOK, &Decision{Approved: true, Approver: "bob"}},
+		{"json without a content type", "POST", "/og/approvals/abc", "", `{"approved":true,"approver":"bob"}`, http.StatusOK, &Decision{Approved: true, Approver: "bob"}},
+		{"bad form", "POST", "/og/approvals/abc", "application/x-www-form-urlencoded", "approved=maybe", http.StatusBadRequest, nil},
+		{"bad json", "POST", "/og/approvals/abc", "application/json", "{", http.StatusBadRequest, nil},
+	}
+	for _, tt := range tests {
+		r, decided := pendingRemote("abc")
+		req := httptest.NewRequest(tt.method, tt.url, strings.NewReader(tt.body))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		w := httptest.NewRecorder()
+		r.handleCallback(w, req)
+		if w.Code != tt.status {
+			t.Errorf("%s: status %d, want %d (%s)", tt.name, w.Code, tt.status, w.Body)
+		}
+		select {
+		case d := <-decided:
+			if tt.decided == nil || d != *tt.decided {
+				t.Errorf("%s: decided %+v, want %+v", tt.name, d, tt.decided)
+			}
+		default:
+			if tt.decided != nil {
+				t.Errorf("%s: nothing decided, want %+v", tt.name, *tt.decided)
+			}
+		}
+	}
+}
+
+func TestCallbackPage(t *testing.T) {
+	r, _ := pendingRemote("abc")
+	w := httptest.NewRecorder()
+	r.handleCallback(w, httptest.NewRequest("GET", "/og/approvals/abc", nil))
+	page := w.Body.String()
+	for _, want := range []string{`<form method="post">`, `name="approved" value="true"`, "make &lt;deploy&gt;", "alice"} {
+		if !strings.Contains(page, want) {
+			t.Errorf("the confirmation page lacks %q:\n%s", want, page)
+		}
+	}
+}
+
+func TestCallbackDecidesOnce(t *testing.T) {
+	r, _ := pendingRemote("abc")
+	for i, want := range []int{http.StatusOK, http.StatusConflict} {
+		req := httptest.NewRequest("POST", "/og/approvals/abc", strings.NewReader(`{"approved":true,"approver":"bob"}`))
+		w := httptest.NewRecorder()
+		r.handleCallback(w, req)
+		if w.Code != want {
+			t.Errorf("decision %d: status %d, want %d", i+1, w.Code, want)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package approval
+
+import (
+	"fmt"
+
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// UI returns u with its approval prompts decided through r. The other prompts,
+// such as what to do about a failed step, are still asked on the console.
+func (r *Remote) UI(u ui.UI) ui.UI {
+	return &remoteUI{UI: u, r: r}
+}
+
+// remoteUI relays the approval prompts of the UI it wraps. The prompts only
+// carry their question, so it keeps the last message of the agent that asked
+// for an approval to tell the approver what is asked about.
+type remoteUI struct {
+	ui.UI
+	r    *Remote
+	last ui.AgentMessage
+}
+
+func (p *remoteUI) PrintAgentMessage(msg ui.AgentMessage, minGoLogLevel ui.LogLevel) {
+	switch msg.Type {
+	case "plan", "request_approval", "proposed_patch", "sql_query", "mcp_call":
+		p.last = msg
+	}
+	p.UI.PrintAgentMessage(msg, minGoLogLevel)
+}
+
+// ask relays a prompt and reports whether it was approved.
+func (p *remoteUI) ask(kind, message, command string) bool {
+	req := RemoteRequest{Kind: kind, Message: message, Description: p.last.Description}
+	switch p.last.Type {
+	case "plan":
+		req.Description = p.last.Request
+		for _, step := range p.last.RecipeSteps {
+			req.Steps = append(req.Steps, fmt.Sprintf("%s (%s)", step.Action, step.Tool))
+		}
+		if p.last.FallbackAction != nil {
+			req.Steps = append(req.Steps, fmt.Sprintf("fallback: %s (%s)", p.last.FallbackAction.Action, p.last.FallbackAction.Tool))
+		}
+	case "request_approval":
+		req.Tool, req.Command = p.last.Tool, p.last.Action
+	case "proposed_patch":
+		req.Tool, req.Command = "apply_patch", p.last.Patch
+	case "sql_query":
+		req.Tool, req.Command = "sql_query_tool", p.last.Database+": "+p.last.Query
+	case "mcp_call":
+		req.Tool, req.Command = p.last.Tool, string(p.last.Arguments)
+	}
+	if command != "" {
+		req.Command = command
+	}
+
+	p.PrintColored(p.Yellow, "\n%s\n", message)
+	p.PrintColored(p.Blue, "📨 Sent for remote approval; waiting up to %s...\n", p.r.Timeout())
+	decision, err := p.r.Ask(req)
+	switch {
+	case err != nil:
+		p.PrintColored(p.Red, "🚫 No remote decision (%v); denied.\n", err)
+		return false
+	case !decision.Approved:
+		p.PrintColored(p.Red, "🚫 Denied remotely by %s%s.\n", decision.Approver, comment(decision))
+		return false
+	}
+	p.PrintColored(p.Green, "✅ Approved remotely by %s%s.\n", decision.Approver, comment(decision))
+	return true
+}
+
+// comment formats the approver's comment, if any, to follow their name.
+func comment(d Decision) string {
+	if d.Comment == "" {
+		return ""
+	}
+	return ": " + d.Comment
+}
+
+func (p *remoteUI) PromptForApproval(message string) bool {
+	return p.ask("approval", message, "")
+}
+
+func (p *remoteUI) PromptForApprovalChoice(message string, allowAlways bool) ui.ApprovalChoice {
+	if p.ask("approval", message, "") {
+		return ui.ApprovalYes
+	}
+	return ui.ApprovalNo
+}
+
+func (p *remoteUI) PromptForTypedConfirmation(message, command, _ string) bool {
+	if !p.r.AllowsDangerous() {
+		p.PrintColored(p.Red, "\n%s\n🚫 Dangerous commands are not approved remotely (remote_approval.allow_dangerous); denied.\n", message)
+		return false
+	}
+	return p.ask("typed_confirmation", message, command)
+}
+
+func (p *remoteUI) PromptForPathSelection(message string, paths []string) ([]string, bool) {
+	if p.ask("approval", message, "") {
+		return paths, false
+	}
+	return nil, false
+}
+
+func (p *remoteUI) PromptForRecipeSelection(message string, steps int) ([]int, bool) {
+	if !p.ask("approval", message, "") {
+		return nil, false
+	}
+	all := make([]int, steps)
+	for i := range all {
+		all[i] = i + 1
+	}
+	return all, true
+}
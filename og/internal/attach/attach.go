@@ -0,0 +1,284 @@
+// Package attach collects the files and directory listings attached to a query
+// with og --file and og --dir into one document for the agent, within a budget
+// of bytes.
+package attach
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/robbiemu/original_gangster/og/internal/maintenance"
+)
+
+// sniffBytes is how much of a file is checked for NUL bytes, which mark it as
+// binary.
+const sniffBytes = 8000
+
+// Item is a file or directory listing that was asked for.
+type Item struct {
+	Path    string // Relative to the working directory when it is inside it
+	Dir     bool   // A directory listing rather than a file's contents
+	Size    int64  // Bytes of the file, or of the whole listing
+	Kept    int64  // Bytes of it in the document, less than Size when the budget cut it
+	Skipped string // Why it is not in the document at all, or ""
+}
+
+// Document is the attachments of a query.
+type Document struct {
+	Text  []byte
+	Items []Item
+}
+
+// Bytes returns how many bytes of contents the document holds.
+func (d *Document) Bytes() int64 {
+	var n int64
+	for _, it := range d.Items {
+		n += it.Kept
+	}
+	return n
+}
+
+// Collect attaches the files matching the patterns in files and the listings of
+// the directories in dirs, in that order, resolving relative paths against cwd.
+// Patterns are globs in which "**" spans directories; the files that patterns
+// with wildcards match, and the entries of listings, are left out when an
+// .ogignore file leaves them out. The document holds at most budget bytes of
+// contents: the file or listing that reaches it is cut, and those after it are
+// skipped.
+func Collect(cwd string, files, dirs []string, budget int) (*Document, error) {
+	doc := &Document{}
+	var text bytes.Buffer
+	left := int64(budget)
+	add := func(item Item, kind string, content []byte) {
+		if left <= 0 {
+			item.Skipped = "over general.attach_max_bytes"
+			doc.Items = append(doc.Items, item)
+			return
+		}
+		if int64(len(content)) > left {
+			content = cut(content, int(left), item.Dir)
+		}
+		item.Kept = int64(len(content))
+		left -= item.Kept
+		fmt.Fprintf(&text, "<%s path=%q>\n%s", kind, item.Path, content)
+		if len(content) > 0 && content[len(content)-1] != '\n' {
+			text.WriteByte('\n')
+		}
+		if item.Kept < item.Size {
+			fmt.Fprintf(&text, "-- cut: %s of %s attached (general.attach_max_bytes) --\n", maintenance.FormatSize(item.Kept), maintenance.FormatSize(item.Size))
+		}
+		fmt.Fprintf(&text, "</%s>\n", kind)
+		doc.Items = append(doc.Items, item)
+	}
+
+	seen := map[string]bool{}
+	for _, pattern := range files {
+		paths, err := expand(cwd, pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, path := range paths {
+			if seen[path] {
+				continue
+			}
+			seen[path] = true
+			item := Item{Path: display(cwd, path)}
+			content, size, err := readFile(path, left)
+			item.Size = size
+			switch {
+			case errors.Is(err, errBinary):
+				item.Skipped = "binary"
+				doc.Items = append(doc.Items, item)
+			case err != nil:
+				return nil, err
+			default:
+				add(item, "file", content)
+			}
+		}
+	}
+	for _, dir := range dirs {
+		root := absolute(cwd, dir)
+		info, err := os.Stat(root)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory; attach it with --file", dir)
+		}
+		list, err := listing(cwd, root)
+		if err != nil {
+			return nil, err
+		}
+		add(Item{Path: display(cwd, root), Dir: true, Size: int64(len(list))}, "directory", list)
+	}
+	doc.Text = text.Bytes()
+	return doc, nil
+}
+
+var errBinary = errors.New("binary file")
+
+// readFile reads the first limit bytes of the file at path, and a few more for
+// cut to end on a whole character, and returns them with the file's size.
+// Binary files yield errBinary.
+func readFile(path string, limit int64) ([]byte, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	content, err := io.ReadAll(io.LimitReader(f, max(limit+utf8.UTFMax, sniffBytes)))
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if bytes.IndexByte(content[:min(len(content), sniffBytes)], 0) >= 0 {
+		return nil, info.Size(), errBinary
+	}
+	return content, info.Size(), nil
+}
+
+// cut shortens content to at most n < len(content) bytes, at the end of a
+// line for listings and of a character otherwise.
+func cut(content []byte, n int, lines bool) []byte {
+	if lines {
+		if i := bytes.LastIndexByte(content[:n], '\n'); i >= 0 {
+			return content[:i+1]
+		}
+	}
+	for n > 0 && !utf8.RuneStart(content[n]) {
+		n--
+	}
+	return content[:n]
+}
+
+// expand returns the files a --file pattern names, sorted.
+func expand(cwd, pattern string) ([]string, error) {
+	abs := absolute(cwd, pattern)
+	if !strings.ContainsAny(pattern, "*?[") {
+		info, err := os.Stat(abs)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return nil, fmt.Errorf("%s is a directory; attach its listing with --dir", pattern)
+		}
+		return []string{abs}, nil
+	}
+	// Walk from the deepest directory of the pattern without wildcards
+	elems := strings.Split(filepath.ToSlash(abs), "/")
+	i := 0
+	for i < len(elems) && !strings.ContainsAny(elems[i], "*?[") {
+		i++
+	}
+	root := filepath.FromSlash(strings.Join(elems[:i], "/"))
+	if root == "" {
+		root = string(filepath.Separator)
+	}
+	match := globRegexp(strings.Join(elems[i:], "/"))
+	var paths []string
+	err := walk(cwd, root, func(path string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(root, path)
+		if err == nil && d.Type().IsRegular() && match.MatchString(filepath.ToSlash(rel)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+// listing lists the entries below root, one per line: directories with a
+// trailing slash, files with their size.
+func listing(cwd, root string) ([]byte, error) {
+	var b bytes.Buffer
+	err := walk(cwd, root, func(path string, d fs.DirEntry) error {
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			fmt.Fprintf(&b, "%s/\n", rel)
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			fmt.Fprintf(&b, "%s\n", rel)
+			return nil
+		}
+		fmt.Fprintf(&b, "%s (%s)\n", rel, maintenance.FormatSize(info.Size()))
+		return nil
+	})
+	return b.Bytes(), err
+}
+
+// walk calls fn for the entries below root in lexical order, leaving out .git
+// directories and what the .ogignore files from cwd down leave out.
+func walk(cwd, root string, fn func(path string, d fs.DirEntry) error) error {
+	ig := &ignorer{}
+	// The ignore files of the directories from cwd down to root apply too
+	if rel, err := filepath.Rel(cwd, root); err == nil && rel != "." && !strings.HasPrefix(rel, "..") {
+		dir := cwd
+		for _, elem := range strings.Split(rel, string(filepath.Separator)) {
+			if err := ig.load(dir); err != nil {
+				return err
+			}
+			dir = filepath.Join(dir, elem)
+		}
+	}
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // Unreadable entries are left out
+		}
+		if path == root {
+			return ig.load(path)
+		}
+		if d.IsDir() && d.Name() == ".git" || ig.ignored(path, d.IsDir()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			if err := ig.load(path); err != nil {
+				return err
+			}
+		}
+		return fn(path, d)
+	})
+}
+
+func absolute(cwd, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(cwd, path)
+}
+
+// display returns path relative to cwd when it is inside it.
+func display(cwd, path string) string {
+	if rel, err := filepath.Rel(cwd, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+	return path
+}
@@ -0,0 +1,152 @@
+package attach
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// IgnoreFile is the name of the files listing paths that directory listings
+// leave out, in the syntax of .gitignore. Each applies to the directory it is
+// in and those below it.
+const IgnoreFile = ".ogignore"
+
+// ignoreRule is a line of an ignore file.
+type ignoreRule struct {
+	pattern *regexp.Regexp // Matches slash-separated paths relative to the file's directory
+	negate  bool           // A "!" line, which includes paths again
+	dirOnly bool           // A line ending in "/", which only matches directories
+}
+
+// ignoreList is the rules of an ignore file and the directory it applies to.
+type ignoreList struct {
+	dir   string
+	rules []ignoreRule
+}
+
+// ignorer decides which paths a walk leaves out, according to the ignore files
+// of the directories it passes through, the innermost deciding last.
+type ignorer struct {
+	lists []ignoreList
+}
+
+// load adds the rules of the ignore file in dir, if it has one.
+func (ig *ignorer) load(dir string) error {
+	f, err := os.Open(filepath.Join(dir, IgnoreFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	list := ignoreList{dir: dir}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			list.rules = append(list.rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if len(list.rules) > 0 {
+		ig.lists = append(ig.lists, list)
+	}
+	return nil
+}
+
+// ignored reports whether the ignore files loaded so far leave out path.
+func (ig *ignorer) ignored(path string, isDir bool) bool {
+	ignored := false
+	for _, list := range ig.lists {
+		rel, err := filepath.Rel(list.dir, path)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, rule := range list.rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			if rule.pattern.MatchString(rel) {
+				ignored = !rule.negate
+			}
+		}
+	}
+	return ignored
+}
+
+// parseIgnoreRule parses a line of an ignore file; blank lines and comments
+// yield no rule.
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ignoreRule{}, false
+	}
+	var rule ignoreRule
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	// A pattern without a slash matches a name at any depth; one with a slash
+	// is relative to the ignore file's directory
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	if line == "" {
+		return ignoreRule{}, false
+	}
+	rule.pattern = globRegexp(line)
+	return rule, true
+}
+
+// globRegexp converts a slash-separated path glob into an anchored regular
+// expression: "*" matches within a path element, "?" a single character of
+// one, "[...]" a class of characters, and "**" any number of elements.
+func globRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			sb.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(glob[i:], "**"):
+			sb.WriteString(".*")
+			i++
+		case c == '*':
+			sb.WriteString("[^/]*")
+		case c == '?':
+			sb.WriteString("[^/]")
+		case c == '[':
+			if end := strings.IndexByte(glob[i+1:], ']'); end >= 0 {
+				class := glob[i+1 : i+1+end]
+				if strings.HasPrefix(class, "!") {
+					class = "^" + class[1:]
+				}
+				sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+				i += end + 1
+				continue
+			}
+			sb.WriteString(regexp.QuoteMeta("["))
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return regexp.MustCompile("^" + regexp.QuoteMeta(glob) + "$")
+	}
+	return re
+}
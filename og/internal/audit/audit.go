@@ -0,0 +1,107 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+const auditFileName = "audit.jsonl"
+
+// Event types recorded in the audit log.
+const (
+	EventApproval  = "approval"  // A decision on whether an action may run
+	EventExecution = "execution" // An action that was run, with its outcome
+	EventTrust     = "trust"     // A temporary trust grant was created or revoked
+	EventEdit      = "edit"      // Generated files were opened in an editor, with whether the user changed them
+)
+
+// Entry is a single line of the append-only audit log. Execution entries carry the
+// decision, identity and role of the approval that allowed them to run.
+type Entry struct {
+	TS         string `json:"ts"`
+	Session    string `json:"session"`
+	Event      string `json:"event"`
+	Tool       string `json:"tool,omitempty"`
+	Command    string `json:"command,omitempty"`
+	Decision   string `json:"decision,omitempty"` // "approved" or "denied"
+	Identity   string `json:"identity,omitempty"` // Who made the decision
+	Role       string `json:"role,omitempty"`     // "user", "policy", "session_allow", "requester", "second_approver", "second_opinion" or "override"
+	Reason     string `json:"reason,omitempty"`
+	Status     string `json:"status,omitempty"`    // Outcome of an execution ("success" or "failure") or edit ("edited" or "unchanged")
+	ExitCode   *int   `json:"exit_code,omitempty"` // Exit status of shell commands
+	DurationMs int64  `json:"duration_ms,omitempty"`
+}
+
+// GetAuditPath returns the full path to the audit log.
+func GetAuditPath() (string, error) {
+	dir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, auditFileName), nil
+}
+
+// Append writes an entry to the audit log, filling in the timestamp if unset.
+func Append(e Entry) error {
+	if e.TS == "" {
+		e.TS = time.Now().UTC().Format(time.RFC3339)
+	}
+	path, err := GetAuditPath()
+	if err != nil {
+		return fmt.Errorf("failed to get audit log path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create audit log directory: %w", err)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// ReadEntries reads every entry of the audit log, skipping malformed lines.
+// A missing log yields no entries.
+func ReadEntries() ([]Entry, error) {
+	path, err := GetAuditPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get audit log path: %w", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open audit log %s: %w", path, err)
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log %s: %w", path, err)
+	}
+	return entries, nil
+}
@@ -0,0 +1,189 @@
+// Package bench replays the requests of earlier sessions to other planner
+// models and compares the plans they make, so that the models og uses by
+// default can be chosen on the user's own tasks (see og bench). The agent only
+// plans: nothing it proposes is run.
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/classify"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Result is how one model planned one session's request.
+type Result struct {
+	Session          string  `json:"session"`
+	Model            string  `json:"model"`
+	Planned          bool    `json:"planned"`         // The model made a plan the auditor let through
+	Steps            int     `json:"steps"`           // Recipe steps, or 1 for a single fallback action
+	Flags            int     `json:"auditor_flags"`   // Warnings and unsafe verdicts of the auditor
+	Unsafe           bool    `json:"unsafe"`          // The auditor found the plan unsafe
+	Seconds          float64 `json:"latency_seconds"` // Until the plan, or the failure, arrived
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Error            string  `json:"error,omitempty"`
+}
+
+// ReadOnly reports whether a session only asked for information, by its tag or,
+// for sessions that were not classified, the keyword heuristics. Only those are
+// replayed: planning a request again is harmless, but its plan is judged
+// against a directory that may have moved on.
+func ReadOnly(rec history.HistoryRecord) bool {
+	tag := rec.Tag
+	if tag == "" {
+		tag, _ = classify.Heuristic{}.Classify(context.Background(), rec.Query)
+	}
+	return tag == classify.Question
+}
+
+// Plan has an agent whose planner runs model plan the request of rec, in its
+// directory, and stops the agent once the plan, or the auditor's verdict on
+// it, arrives. The agent's messages other than warnings are not shown.
+func Plan(cfg *config.OGConfig, u ui.UI, rec history.HistoryRecord, model string, timeout time.Duration) Result {
+	res := Result{Session: rec.Hash, Model: model}
+	runCfg := *cfg
+	runCfg.PlannerAgent = config.ModelCfg{Model: model, Params: cfg.PlannerAgent.Params}
+	runCfg.General.InteractiveFollowups = false
+	runCfg.General.VerbosityLevel = ui.LogLevelWarn
+
+	workdir := rec.CWD
+	if info, err := os.Stat(workdir); err != nil || !info.IsDir() {
+		res.Error = fmt.Sprintf("%s no longer exists", workdir)
+		return res
+	}
+	// The agent keeps its session state here; a replay leaves none behind
+	cacheDir, err := os.MkdirTemp(cfg.General.TempRoot, "og-bench-")
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer os.RemoveAll(cacheDir)
+
+	pm := agent.NewProcessManager(u, ui.LogLevelWarn)
+	pm.SetQueryTag(rec.Tag)
+	pm.SetArtifactsDir(cacheDir)
+	hash := history.GenerateSessionHash(rec.Query+"\x00"+model, time.Now())
+	trust := policy.ResolveTrust(cfg.Trust, workdir).String()
+	start := time.Now()
+	if err := pm.Start(&runCfg, hash, rec.Query, workdir, trust, false, cacheDir); err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	defer pm.Stop()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		res.read(pm)
+	}()
+	select {
+	case <-done:
+		res.Seconds = time.Since(start).Seconds()
+	case <-time.After(timeout):
+		pm.Stop()
+		<-done
+		res.Seconds = timeout.Seconds()
+		res.Error = fmt.Sprintf("no plan after %s", timeout)
+	}
+	return res
+}
+
+// read takes the agent's messages until it planned, or failed to.
+func (res *Result) read(pm *agent.ProcessManager) {
+	scanner := pm.StdoutScanner()
+	for scanner.Scan() {
+		var msg ui.AgentMessage
+		if json.Unmarshal([]byte(strings.TrimSpace(scanner.Text())), &msg) != nil {
+			continue
+		}
+		switch msg.Type {
+		case "token_usage":
+			res.PromptTokens += msg.PromptTokens
+			res.CompletionTokens += msg.CompletionTokens
+		case "warn_log":
+			if msg.Role == "auditor" {
+				res.Flags++
+			}
+		case "model_error":
+			// A model that is unavailable now is measured as such, not waited for
+			_ = pm.SendCommand("model_retry", map[string]interface{}{"retry": false})
+		case "unsafe":
+			res.Flags++
+			res.Unsafe = true
+			return
+		case "plan":
+			res.Planned = true
+			res.Steps = len(msg.RecipeSteps)
+			if res.Steps == 0 && msg.FallbackAction != nil {
+				res.Steps = 1
+			}
+			return
+		case "error":
+			res.Error = msg.Message
+			return
+		}
+	}
+	if res.Error == "" {
+		res.Error = "the agent exited without a plan"
+	}
+}
+
+// Summary is how one model did over all the sessions replayed.
+type Summary struct {
+	Model            string  `json:"model"`
+	Sessions         int     `json:"sessions"`
+	Planned          int     `json:"planned"`
+	Unsafe           int     `json:"unsafe"`
+	Failed           int     `json:"failed"`
+	AvgSteps         float64 `json:"avg_steps"`     // Over the sessions planned
+	Flags            int     `json:"auditor_flags"` // In all sessions
+	AvgSeconds       float64 `json:"avg_latency_seconds"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+}
+
+// Summarize totals results per model, in the order of models.
+func Summarize(models []string, results []Result) []Summary {
+	out := make([]Summary, len(models))
+	for i, m := range models {
+		s := Summary{Model: m}
+		steps, seconds := 0, 0.0
+		for _, r := range results {
+			if r.Model != m {
+				continue
+			}
+			s.Sessions++
+			switch {
+			case r.Planned:
+				s.Planned++
+				steps += r.Steps
+			case r.Unsafe:
+				s.Unsafe++
+			default:
+				s.Failed++
+			}
+			s.Flags += r.Flags
+			seconds += r.Seconds
+			s.PromptTokens += r.PromptTokens
+			s.CompletionTokens += r.CompletionTokens
+		}
+		if s.Planned > 0 {
+			s.AvgSteps = float64(steps) / float64(s.Planned)
+		}
+		if s.Sessions > 0 {
+			s.AvgSeconds = seconds / float64(s.Sessions)
+		}
+		out[i] = s
+	}
+	return out
+}
@@ -0,0 +1,220 @@
+// Package checkpoint keeps the state of a session's recipe in the cache
+// directory as the recipe runs: the planned steps, which of them were
+// approved, which ran and how they ended. It is written as <hash>.checkpoint.jsonl,
+// one event per line, so that what a crash or Ctrl-C cut short can be resumed
+// later from the last completed step (og resume).
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Suffix ends the name of a session's checkpoint file.
+const Suffix = ".checkpoint.jsonl"
+
+// Path returns where the checkpoint of session hash is written in cacheDir.
+func Path(cacheDir, hash string) string {
+	return filepath.Join(cacheDir, hash+Suffix)
+}
+
+// Events of a checkpoint.
+const (
+	EventPlanned  = "planned"  // The agent planned the recipe
+	EventApproved = "approved" // og sent the command that runs it
+	EventExecuted = "executed" // A step of it ran
+	EventEnded    = "ended"    // The session ended
+)
+
+// States of a step.
+const (
+	StatePending  = "pending"  // Planned, and not approved as part of the recipe
+	StateApproved = "approved" // Approved with the recipe, and not run yet
+	StateExecuted = "executed" // Ran, with a result
+)
+
+// event is a line of a checkpoint.
+type event struct {
+	Event    string                 `json:"event"`
+	TS       string                 `json:"ts"`
+	Steps    []ui.AgentAction       `json:"steps,omitempty"`    // planned, approved: the recipe
+	Phase    string                 `json:"phase,omitempty"`    // approved: the command og sent
+	Args     map[string]interface{} `json:"args,omitempty"`     // Its arguments
+	All      bool                   `json:"all,omitempty"`      // approved: every step was
+	Approval *audit.Entry           `json:"approval,omitempty"` // approved: who approved the recipe
+	Step     int                    `json:"step,omitempty"`     // executed: its number
+	Status   string                 `json:"status,omitempty"`   // executed: its result; ended: how the session ended
+	ExitCode *int                   `json:"exit_code,omitempty"`
+}
+
+// Log appends the events of a session to its checkpoint. A nil *Log records
+// nothing.
+type Log struct {
+	mu  sync.Mutex
+	f   *os.File
+	err error // Of the first write that failed; later ones are not tried
+}
+
+// Open opens the checkpoint of session hash in cacheDir, appending to it when
+// an earlier run of the session left one. The caller closes it once the
+// session ended.
+func Open(cacheDir, hash string) (*Log, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory %s: %w", cacheDir, err)
+	}
+	path := Path(cacheDir, hash)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open checkpoint %s: %w", path, err)
+	}
+	return &Log{f: f}, nil
+}
+
+// Planned records the steps the agent planned, all pending.
+func (l *Log) Planned(steps []ui.AgentAction) {
+	l.write(event{Event: EventPlanned, Steps: steps})
+}
+
+// Approved records the command og sent to have the agent run steps, with its
+// arguments; all says whether every step was approved with it, rather than
+// each when it comes up. approval is who approved the recipe, if anyone did.
+func (l *Log) Approved(phase string, args map[string]interface{}, steps []ui.AgentAction, all bool, approval *audit.Entry) {
+	l.write(event{Event: EventApproved, Phase: phase, Args: args, Steps: steps, All: all, Approval: approval})
+}
+
+// Executed records the result of the recipe step numbered step.
+func (l *Log) Executed(step int, status string, exitCode *int) {
+	l.write(event{Event: EventExecuted, Step: step, Status: status, ExitCode: exitCode})
+}
+
+// Ended records how the session ended.
+func (l *Log) Ended(status string) {
+	l.write(event{Event: EventEnded, Status: status})
+}
+
+// Err returns the error of the first write that failed, if any.
+func (l *Log) Err() error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.err
+}
+
+// Close closes the checkpoint.
+func (l *Log) Close() error {
+	if l == nil {
+		return nil
+	}
+	return l.f.Close()
+}
+
+func (l *Log) write(e event) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.err != nil {
+		return
+	}
+	e.TS = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.Marshal(e)
+	if err == nil {
+		_, err = l.f.Write(append(b, '\n'))
+	}
+	l.err = err
+}
+
+// Step is a step of the recipe in a checkpoint.
+type Step struct {
+	ui.AgentAction
+	State    string // StatePending, StateApproved or StateExecuted
+	Status   string // StateExecuted: "success" or "failure"
+	ExitCode *int
+}
+
+// State is the state of a session's recipe, as its checkpoint last recorded it.
+type State struct {
+	Steps    []Step
+	Phase    string                 // The command og sent to run the recipe; "" before it was approved
+	Args     map[string]interface{} // Its arguments
+	Approval *audit.Entry           // Who approved the recipe, if anyone did
+	Status   string                 // How the session last ended; "" when it did not, as when og crashed
+}
+
+// Load reads the checkpoint of session hash in cacheDir. It returns an error
+// that os.IsNotExist recognizes when the session left none.
+func Load(cacheDir, hash string) (*State, error) {
+	f, err := os.Open(Path(cacheDir, hash))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := &State{}
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16<<20)
+	for scanner.Scan() {
+		var e event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue // A line cut short by a crash
+		}
+		if e.Event != EventEnded {
+			s.Status = "" // The session was resumed since
+		}
+		switch e.Event {
+		case EventPlanned:
+			*s = State{Steps: steps(e.Steps, StatePending)}
+		case EventApproved:
+			state := StatePending
+			if e.All {
+				state = StateApproved
+			}
+			s.Steps = steps(e.Steps, state)
+			s.Phase, s.Args, s.Approval = e.Phase, e.Args, e.Approval
+		case EventExecuted:
+			if e.Step >= 1 && e.Step <= len(s.Steps) {
+				step := &s.Steps[e.Step-1]
+				// A step of several commands failed if any did
+				if step.State != StateExecuted || step.Status == "success" {
+					step.Status, step.ExitCode = e.Status, e.ExitCode
+				}
+				step.State = StateExecuted
+			}
+		case EventEnded:
+			s.Status = e.Status
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func steps(actions []ui.AgentAction, state string) []Step {
+	steps := make([]Step, len(actions))
+	for i, a := range actions {
+		steps[i] = Step{AgentAction: a, State: state}
+	}
+	return steps
+}
+
+// Next returns the number of the first step that has not run, or 0 when all
+// of them have.
+func (s *State) Next() int {
+	for i, step := range s.Steps {
+		if step.State != StateExecuted {
+			return i + 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,157 @@
+// Package classify tags a query before planning, e.g. as a question or as
+// system administration. The tag selects the models, the default policy
+// strictness and the prompts of the session (see [classifier.tags] in the
+// config), and is recorded in the history for `og stats`.
+//
+// Queries are classified by keyword heuristics, by a cheap model, or by an
+// external command; the heuristics stand in when the others fail.
+package classify
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/modelcheck"
+)
+
+// Built-in tags.
+const (
+	Question    = "question"     // Asks for information; nothing needs to change
+	FileEdit    = "file_edit"    // Changes existing files
+	SystemAdmin = "system_admin" // Manages the machine: packages, services, processes, permissions
+	CodeGen     = "code_gen"     // Writes new code, scripts or config files
+)
+
+// Tags are the built-in tags, the most consequential first.
+var Tags = []string{SystemAdmin, FileEdit, CodeGen, Question}
+
+// Classifier tags queries. An empty tag means the query fits none.
+type Classifier interface {
+	Classify(ctx context.Context, query string) (string, error)
+	Name() string // How queries are classified, shown with the tag
+}
+
+// New returns the classifier configured in [classifier], or nil when
+// classification is off.
+func New(cfg config.ClassifierCfg) Classifier {
+	tags := slices.Clone(Tags)
+	for tag := range cfg.Tags {
+		if !slices.Contains(tags, tag) {
+			tags = append(tags, tag)
+		}
+	}
+	slices.Sort(tags[len(Tags):])
+	switch cfg.Method {
+	case "off":
+		return nil
+	case "model":
+		return &Model{Model: cfg.ModelCfg, Tags: tags}
+	case "command":
+		return &Command{Argv: cfg.Command}
+	default:
+		return Heuristic{}
+	}
+}
+
+// Classify tags query with c within timeout. When c fails, it falls back to the
+// heuristics and returns c's error alongside their tag.
+func Classify(c Classifier, query string, timeout time.Duration) (tag, source string, err error) {
+	if c == nil {
+		return "", "", nil
+	}
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	tag, err = c.Classify(ctx, query)
+	if err == nil {
+		return tag, c.Name(), nil
+	}
+	tag, _ = Heuristic{}.Classify(ctx, query)
+	return tag, Heuristic{}.Name(), fmt.Errorf("%s classifier failed: %w", c.Name(), err)
+}
+
+// validTag matches the tags a model or command may return.
+var validTag = regexp.MustCompile(`^[a-z0-9_]*$`)
+
+// Command classifies queries with an external program, which reads the query
+// on stdin and prints the tag (or nothing) on stdout.
+type Command struct {
+	Argv []string
+}
+
+func (c *Command) Name() string { return "command" }
+
+func (c *Command) Classify(ctx context.Context, query string) (string, error) {
+	cmd := exec.CommandContext(ctx, c.Argv[0], c.Argv[1:]...)
+	cmd.Stdin = strings.NewReader(query)
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", c.Argv[0], err)
+	}
+	tag, _, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	tag = strings.TrimSpace(tag)
+	if !validTag.MatchString(tag) {
+		return "", fmt.Errorf("%s printed %q, not a tag", c.Argv[0], tag)
+	}
+	return tag, nil
+}
+
+// Model classifies queries with a model served by Ollama or an OpenAI-compatible
+// endpoint, which og queries itself before starting the agent.
+type Model struct {
+	Model config.ModelCfg
+	Tags  []string // The tags the model chooses from
+}
+
+func (m *Model) Name() string { return m.Model.Model }
+
+// modelPrompt asks for a single tag; %s are the tags and the query.
+const modelPrompt = `Classify the following request to a command-line assistant into exactly one category:
+%s
+
+Reply with the category name only.
+
+Request: %s`
+
+// tagDescriptions explain the built-in tags to the model.
+var tagDescriptions = map[string]string{
+	Question:    "asks for information; nothing needs to change",
+	FileEdit:    "changes existing files",
+	SystemAdmin: "manages the machine: packages, services, processes, users, permissions, networking",
+	CodeGen:     "writes new code, scripts or configuration files",
+}
+
+func (m *Model) Classify(ctx context.Context, query string) (string, error) {
+	ep, name, ok := modelcheck.Resolve(m.Model)
+	if !ok {
+		return "", fmt.Errorf("og can only query Ollama and OpenAI-compatible models itself, not %q", m.Model.Model)
+	}
+	var categories []string
+	for _, tag := range m.Tags {
+		line := "- " + tag
+		if d, ok := tagDescriptions[tag]; ok {
+			line += ": " + d
+		}
+		categories = append(categories, line)
+	}
+	reply, err := modelcheck.Complete(ctx, ep, name, fmt.Sprintf(modelPrompt, strings.Join(categories, "\n"), query), 16)
+	if err != nil {
+		return "", err
+	}
+	// Accept "File edit", "`file-edit`." and the like
+	normalized := strings.NewReplacer("-", "_", " ", "_").Replace(strings.ToLower(reply))
+	for _, tag := range m.Tags {
+		if strings.Contains(normalized, tag) {
+			return tag, nil
+		}
+	}
+	return "", fmt.Errorf("unrecognized reply %q", strings.TrimSpace(reply))
+}
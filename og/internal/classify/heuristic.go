@@ -0,0 +1,86 @@
+package classify
+
+import (
+	"context"
+	"regexp"
+	"strings"
+)
+
+// Heuristic classifies queries by their words: each keyword of a tag scores a
+// point, and the tag with the most points wins, the most consequential one on
+// a tie. Queries without any keyword get no tag.
+type Heuristic struct{}
+
+func (Heuristic) Name() string { return "heuristic" }
+
+// keywords are the words that point to each tag.
+var keywords = map[string][]string{
+	SystemAdmin: {
+		"install", "uninstall", "reinstall", "upgrade", "brew", "apt", "apt-get", "yum", "dnf", "pacman", "pip", "npm",
+		"service", "services", "systemctl", "launchctl", "daemon", "cron", "crontab", "restart", "reboot", "shutdown",
+		"process", "processes", "kill", "pid", "port", "ports", "firewall", "network", "dns", "ssh", "sudo",
+		"chmod", "chown", "permission", "permissions", "user", "users", "group", "groups", "disk", "disks",
+		"partition", "mount", "docker", "kubectl", "container", "containers", "memory", "cpu", "uptime",
+	},
+	FileEdit: {
+		"edit", "modify", "change", "replace", "rename", "update", "fix", "refactor", "delete", "remove",
+		"move", "insert", "append", "patch", "reformat", "format", "bump", "comment", "uncomment",
+	},
+	CodeGen: {
+		"write", "generate", "create", "scaffold", "implement", "bootstrap", "template", "boilerplate",
+		"script", "function", "program", "class", "module", "test", "tests", "snippet", "regex", "dockerfile",
+		"makefile", "gitignore", "component", "cli", "api", "endpoint",
+	},
+	Question: {
+		"what", "why", "how", "which", "who", "where", "when", "explain", "describe", "summarize", "summarise",
+		"show", "list", "find", "count", "tell", "compare", "difference",
+	},
+}
+
+// interrogatives start questions, and score twice at the start of a query.
+var interrogatives = map[string]bool{
+	"what": true, "why": true, "how": true, "which": true, "who": true, "where": true, "when": true,
+	"is": true, "are": true, "does": true, "do": true, "can": true, "could": true, "should": true,
+}
+
+// fileName matches words that name a file, such as main.go or ./config.yaml.
+var fileName = regexp.MustCompile(`^[\w./~-]*\.[a-z0-9]{1,8}$`)
+
+func (Heuristic) Classify(_ context.Context, query string) (string, error) {
+	lower := strings.ToLower(query)
+	words := strings.FieldsFunc(lower, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./~", r))
+	})
+	scores := map[string]int{}
+	names := false
+	for i, w := range words {
+		w = strings.TrimRight(w, ".")
+		for tag, list := range keywords {
+			for _, k := range list {
+				if w == k {
+					scores[tag]++
+				}
+			}
+		}
+		if i == 0 && interrogatives[w] {
+			scores[Question] += 2
+		}
+		if strings.Contains(w, ".") && fileName.MatchString(w) {
+			names = true
+		}
+	}
+	if strings.HasSuffix(strings.TrimSpace(lower), "?") {
+		scores[Question] += 2
+	}
+	if names && scores[FileEdit] > 0 {
+		scores[FileEdit]++ // "fix the typo in README.md"
+	}
+
+	best, bestScore := "", 0
+	for _, tag := range Tags {
+		if scores[tag] > bestScore {
+			best, bestScore = tag, scores[tag]
+		}
+	}
+	return best, nil
+}
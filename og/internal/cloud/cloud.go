@@ -0,0 +1,257 @@
+// Package cloud detects the accounts that cloud CLIs (aws, gcloud, kubectl) are
+// currently pointed at, and which of those CLIs a shell command invokes.
+//
+// Contexts are read from environment variables and the CLIs' config files rather
+// than by running the CLIs, which would slow down every session start.
+package cloud
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Provider is a cloud CLI whose active context OG tracks.
+type Provider string
+
+const (
+	AWS     Provider = "aws"
+	GCloud  Provider = "gcloud"
+	Kubectl Provider = "kubectl"
+)
+
+// Keys under which contexts are matched by policy rules.
+const (
+	KeyAWSProfile    = "aws_profile"
+	KeyGCloudProject = "gcloud_project"
+	KeyKubeContext   = "kube_context"
+)
+
+// Keys lists every context key, in display order.
+var Keys = []string{KeyAWSProfile, KeyGCloudProject, KeyKubeContext}
+
+// keyProvider maps each context key to the CLI it belongs to.
+var keyProvider = map[string]Provider{
+	KeyAWSProfile:    AWS,
+	KeyGCloudProject: GCloud,
+	KeyKubeContext:   Kubectl,
+}
+
+// commands maps executables to the provider whose context they act on.
+var commands = map[string]Provider{
+	"aws": AWS, "sam": AWS, "cdk": AWS, "eksctl": AWS, "copilot": AWS,
+	"gcloud": GCloud, "gsutil": GCloud, "bq": GCloud,
+	"kubectl": Kubectl, "helm": Kubectl, "kustomize": Kubectl, "k9s": Kubectl,
+	"kubectx": Kubectl, "kubens": Kubectl, "oc": Kubectl, "skaffold": Kubectl, "stern": Kubectl,
+}
+
+// Context is the set of active cloud contexts. Empty fields are not configured.
+type Context struct {
+	AWSProfile    string
+	GCloudProject string
+	KubeContext   string
+}
+
+// Detect reads the active AWS profile, gcloud project and kubectl context.
+func Detect() Context {
+	return Context{
+		AWSProfile:    awsProfile(),
+		GCloudProject: gcloudProject(),
+		KubeContext:   kubeContext(),
+	}
+}
+
+// Values returns the configured contexts keyed by Keys.
+func (c Context) Values() map[string]string {
+	values := map[string]string{}
+	for key, v := range map[string]string{
+		KeyAWSProfile:    c.AWSProfile,
+		KeyGCloudProject: c.GCloudProject,
+		KeyKubeContext:   c.KubeContext,
+	} {
+		if v != "" {
+			values[key] = v
+		}
+	}
+	return values
+}
+
+// Empty reports whether no cloud CLI is configured.
+func (c Context) Empty() bool {
+	return len(c.Values()) == 0
+}
+
+// Describe renders the contexts of the given providers, or of all of them when
+// none are given, e.g. "aws profile prod-admin, kubectl context prod".
+func (c Context) Describe(providers ...Provider) string {
+	values := c.Values()
+	var parts []string
+	for _, key := range Keys {
+		v, ok := values[key]
+		if !ok {
+			continue
+		}
+		if len(providers) > 0 && !slices.Contains(providers, keyProvider[key]) {
+			continue
+		}
+		parts = append(parts, describeKey(key)+" "+v)
+	}
+	return strings.Join(parts, ", ")
+}
+
+func describeKey(key string) string {
+	switch key {
+	case KeyAWSProfile:
+		return "aws profile"
+	case KeyGCloudProject:
+		return "gcloud project"
+	default:
+		return "kubectl context"
+	}
+}
+
+// segmentSep splits a shell command into simple commands.
+var segmentSep = regexp.MustCompile("[;&|()\n`]|\\$\\(")
+
+// wrappers are commands that run the command that follows them.
+var wrappers = map[string]bool{"sudo": true, "env": true, "time": true, "command": true, "exec": true, "nohup": true, "xargs": true}
+
+// Touches returns the providers whose CLIs a shell command invokes, in the order
+// they first appear.
+func Touches(command string) []Provider {
+	var found []Provider
+	for _, segment := range segmentSep.Split(command, -1) {
+		for _, word := range strings.Fields(segment) {
+			if strings.Contains(word, "=") && !strings.HasPrefix(word, "=") {
+				continue // Variable assignment, e.g. AWS_PROFILE=prod aws ...
+			}
+			name := filepath.Base(word)
+			if wrappers[name] || strings.HasPrefix(word, "-") {
+				continue
+			}
+			if p, ok := commands[name]; ok && !slices.Contains(found, p) {
+				found = append(found, p)
+			}
+			break
+		}
+	}
+	return found
+}
+
+// awsProfile follows the AWS CLI: AWS_PROFILE, then AWS_DEFAULT_PROFILE, then
+// "default" if a config or credentials file exists.
+func awsProfile() string {
+	for _, env := range []string{"AWS_PROFILE", "AWS_DEFAULT_PROFILE"} {
+		if v := os.Getenv(env); v != "" {
+			return v
+		}
+	}
+	home, _ := os.UserHomeDir()
+	files := []string{os.Getenv("AWS_CONFIG_FILE"), os.Getenv("AWS_SHARED_CREDENTIALS_FILE")}
+	if home != "" {
+		files = append(files, filepath.Join(home, ".aws", "config"), filepath.Join(home, ".aws", "credentials"))
+	}
+	for _, f := range files {
+		if f == "" {
+			continue
+		}
+		if _, err := os.Stat(f); err == nil {
+			return "default"
+		}
+	}
+	return ""
+}
+
+// gcloudProject follows gcloud: CLOUDSDK_CORE_PROJECT, then the project of the
+// active named configuration.
+func gcloudProject() string {
+	if v := os.Getenv("CLOUDSDK_CORE_PROJECT"); v != "" {
+		return v
+	}
+	dir := os.Getenv("CLOUDSDK_CONFIG")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(home, ".config", "gcloud")
+	}
+	name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME")
+	if name == "" {
+		b, err := os.ReadFile(filepath.Join(dir, "active_config"))
+		name = strings.TrimSpace(string(b))
+		if err != nil || name == "" {
+			name = "default"
+		}
+	}
+	return iniValue(filepath.Join(dir, "configurations", "config_"+name), "core", "project")
+}
+
+// kubeContext follows kubectl: the current-context of the first file in
+// KUBECONFIG that sets one, or of ~/.kube/config.
+func kubeContext() string {
+	var files []string
+	if v := os.Getenv("KUBECONFIG"); v != "" {
+		files = filepath.SplitList(v)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = []string{filepath.Join(home, ".kube", "config")}
+	}
+	for _, f := range files {
+		if ctx := yamlTopLevel(f, "current-context"); ctx != "" {
+			return ctx
+		}
+	}
+	return ""
+}
+
+// iniValue returns key from section of an INI file, or "" if it is not set.
+func iniValue(path, section, key string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		if current != section {
+			continue
+		}
+		if k, v, ok := strings.Cut(line, "="); ok && strings.TrimSpace(k) == key {
+			return strings.TrimSpace(v)
+		}
+	}
+	return ""
+}
+
+// yamlTopLevel returns a top-level scalar of a YAML file, which is all that is
+// needed from a kubeconfig.
+func yamlTopLevel(path, key string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024) // Kubeconfigs embed long certificates
+	for scanner.Scan() {
+		k, v, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || k != key {
+			continue
+		}
+		v = strings.TrimSpace(v)
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+		return strings.Trim(v, `"'`)
+	}
+	return ""
+}
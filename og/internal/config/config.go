@@ -3,10 +3,14 @@ package config
 import (
 	"embed"
 	"fmt"
+	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
+	"runtime"
 	"strings"
 
+	"github.com/fatih/color"
 	"github.com/pelletier/go-toml/v2"
 	"github.com/robbiemu/original_gangster/og/internal/ui"
 )
@@ -18,12 +22,45 @@ type ModelCfg struct {
 }
 
 type GeneralCfg struct {
-	PythonAgentPath      string `toml:"python_agent_path"`
-	SummaryMode          bool   `toml:"summary_mode"`
-	VerbosityLevelStr    string `toml:"verbosity_level"`
-	VerbosityLevel       ui.LogLevel
-	SessionTimeout       int `toml:"session_timeout_minutes"`
-	OutputThresholdBytes int `toml:"output_threshold_bytes"`
+	PythonAgentPath        string `toml:"python_agent_path"`
+	PythonInterpreter      string `toml:"python_interpreter"` // Python that runs the agent; detected next to the agent when empty
+	SummaryMode            bool   `toml:"summary_mode"`
+	VerbosityLevelStr      string `toml:"verbosity_level"`
+	VerbosityLevel         ui.LogLevel
+	SessionTimeout         int    `toml:"session_timeout_minutes"`
+	CheckModels            bool   `toml:"check_models"`                     // Verify at session start that the configured models exist on their endpoints
+	TempRoot               string `toml:"temp_root"`                        // Where per-session temp and artifact directories are created; see TempDir
+	AgentTransport         string `toml:"agent_transport"`                  // How og and the agent talk: "stdio" or "socket"
+	ConcurrentSessions     string `toml:"concurrent_sessions"`              // What a session does while another runs on the same data directory: "queue", "refuse" or "allow"
+	StdinMaxBytes          int    `toml:"stdin_max_bytes"`                  // Most bytes of piped input attached to the prompt; 0 ignores piped input
+	AttachMaxBytes         int    `toml:"attach_max_bytes"`                 // Most bytes of the files and listings attached with --file and --dir
+	InteractiveFollowups   bool   `toml:"interactive_followups"`            // After a completed session, ask for follow-ups that the same agent answers
+	RememberRefusals       bool   `toml:"remember_refusals"`                // Ask why after each refusal and tell the agent in later sessions in the directory
+	DistillAfter           int    `toml:"distill_after"`                    // Suggest og distill once this many sessions in a directory ran the same commands; 0 never does
+	Executor               string `toml:"executor"`                         // Who runs shell steps: "python" (the agent) or "go" (og, see package executor)
+	Backend                string `toml:"backend"`                          // Which agent runs the session: "python", "native" (og's built-in agent, see package native) or "auto" (native without a Python agent)
+	StepTimeoutSeconds     int    `toml:"step_timeout_seconds"`             // Longest a shell step og runs may take; 0 means no limit
+	MaxParallelSteps       int    `toml:"max_parallel_steps"`               // Most independent recipe steps og runs at once; 1 runs them one at a time
+	ApprovalTimeoutSeconds int    `toml:"approval_timeout_seconds"`         // How long prompts wait for an answer; 0 waits forever
+	ApprovalTimeoutDefault string `toml:"approval_timeout_default"`         // What an approval prompt left unanswered does: "deny" or "approve"
+	Sandbox                string `toml:"sandbox"`                          // Where og runs shell steps: "none" (on the host), "docker" or "podman" (see [container]), "bwrap" or "firejail" (see [jail])
+	OutputThresholdBytes   int    `toml:"output_threshold_bytes,omitempty"` // Deprecated: use [output]
+}
+
+// DefaultMaxParallelSteps is the default of general.max_parallel_steps.
+const DefaultMaxParallelSteps = 4
+
+// DefaultStdinMaxBytes is the default of general.stdin_max_bytes.
+const DefaultStdinMaxBytes = 65536 // 64KB
+
+// DefaultAttachMaxBytes is the default of general.attach_max_bytes.
+const DefaultAttachMaxBytes = 131072 // 128KB
+
+// OutputCfg controls how tool output is handled. A value of 0 disables the respective behavior.
+type OutputCfg struct {
+	InlineMaxBytes        int `toml:"inline_max_bytes" json:"inline_max_bytes"`                   // Max bytes of a result printed on the console
+	SummarizeAboveBytes   int `toml:"summarize_above_bytes" json:"summarize_above_bytes"`         // Above this, the agent sees an abbreviated head/tail of the output
+	SpillToFileAboveBytes int `toml:"spill_to_file_above_bytes" json:"spill_to_file_above_bytes"` // Above this, output is saved to a file instead
 }
 
 type CacheCfg struct {
@@ -32,20 +69,516 @@ type CacheCfg struct {
 	Expiration int    `toml:"expiration"` // Days, 0 means no expiration
 }
 
+// LogCfg controls the log file og writes for each session, <hash>.og.log in
+// the cache directory, whatever the console shows.
+type LogCfg struct {
+	File   bool   `toml:"file"`   // Write the log file
+	Format string `toml:"format"` // "text" or "json"
+	Level  string `toml:"level"`  // Least level logged: "debug", "info", "warn" or "none"
+}
+
+// DefaultLogCfg returns the log settings used when the [log] section is absent.
+func DefaultLogCfg() LogCfg {
+	return LogCfg{File: true, Format: "text", Level: ui.LogLevelInfo.String()}
+}
+
+// PolicyRuleCfg is a single approval rule. Tool, Command (a glob), Regex and Context
+// are combined; an unset field matches anything. Decision is "approve", "deny", "prompt"
+// or "escalate" (require a second approver).
+type PolicyRuleCfg struct {
+	Tool     string            `toml:"tool"`
+	Command  string            `toml:"command"`
+	Regex    string            `toml:"regex"`
+	Context  map[string]string `toml:"context"` // Globs the active cloud contexts must match, e.g. kube_context = "prod*"
+	Decision string            `toml:"decision"`
+}
+
+// DefaultMaxLoopIterations is the default of policy.max_loop_iterations.
+const DefaultMaxLoopIterations = 20
+
+type PolicyCfg struct {
+	AutoApprove []string        `toml:"auto_approve"` // Tool names or command globs approved without prompting
+	AlwaysDeny  []string        `toml:"always_deny"`  // Tool names or command globs denied without prompting
+	Rules       []PolicyRuleCfg `toml:"rules"`
+
+	TrustedAutoApprove []string `toml:"trusted_auto_approve"` // Extra auto_approve entries honored only in trusted directories
+
+	RequireSecondApprover []string `toml:"require_second_approver"` // Tool names or command globs that also need a designated approver
+
+	Code string `toml:"code"` // Rules written as code: a TOML file of CEL rules, or a rego module (.rego)
+
+	MaxLoopIterations int `toml:"max_loop_iterations"` // Most iterations of any loop step, whatever the planner asked for
+
+	AuditorStrictness   string `toml:"auditor_strictness"`    // "lenient", "standard" or "paranoid": how readily the auditor calls an action unsafe
+	AllowUnsafeOverride bool   `toml:"allow_unsafe_override"` // Opt in to letting the user run an action the auditor called unsafe by typing it; never in untrusted directories
+}
+
+// DelegationCfg configures how approval requests are relayed to a second approver.
+type DelegationCfg struct {
+	WebhookURL     string   `toml:"webhook_url"`     // Endpoint that relays the request and answers with the decision
+	Token          string   `toml:"token"`           // Optional bearer token sent with each request
+	Approvers      []string `toml:"approvers"`       // Identities allowed to approve; empty allows anyone but the requester
+	TimeoutMinutes int      `toml:"timeout_minutes"` // How long to wait for a decision before denying
+}
+
+// RemoteApprovalCfg has approvals answered from elsewhere, for hosts without
+// anyone at the terminal: og POSTs each approval request to a webhook and waits
+// for the decision on a local HTTP listener, or by polling a URL.
+type RemoteApprovalCfg struct {
+	Mode                string `toml:"mode"`                  // "off", "headless" (when stdin is not a terminal) or "always"
+	WebhookURL          string `toml:"webhook_url"`           // Gets each request, e.g. a Slack or chat bridge
+	Token               string `toml:"token"`                 // Optional bearer token sent to the webhook and the poll URL
+	Callback            string `toml:"callback"`              // How the decision comes back: "listen" or "poll"
+	ListenAddr          string `toml:"listen_addr"`           // For "listen": where og accepts decisions
+	CallbackURL         string `toml:"callback_url"`          // For "listen": the listener as the relay reaches it; defaults to http://<listen_addr>
+	PollURL             string `toml:"poll_url"`              // For "poll": answers with the decision; {id} is replaced by the request's ID
+	PollIntervalSeconds int    `toml:"poll_interval_seconds"` // For "poll"
+	TimeoutSeconds      int    `toml:"timeout_seconds"`       // Requests still undecided by then are denied
+	AllowDangerous      bool   `toml:"allow_dangerous"`       // Relay typed confirmations of dangerous commands too; they are denied otherwise
+}
+
+// DefaultRemoteApprovalCfg returns the settings used when the [remote_approval] section is absent.
+func DefaultRemoteApprovalCfg() RemoteApprovalCfg {
+	return RemoteApprovalCfg{Mode: "off", Callback: "listen", ListenAddr: "127.0.0.1:8787", PollIntervalSeconds: 5, TimeoutSeconds: 600}
+}
+
+// Validate checks the mode and callback, and that the URLs they need are set.
+func (r RemoteApprovalCfg) Validate() error {
+	switch r.Mode {
+	case "off":
+		return nil
+	case "headless", "always":
+	default:
+		return fmt.Errorf("remote_approval.mode must be \"off\", \"headless\" or \"always\", not %q", r.Mode)
+	}
+	if !httpURL(r.WebhookURL) {
+		return fmt.Errorf("remote_approval.webhook_url must be an http:// or https:// URL, not %q", r.WebhookURL)
+	}
+	switch r.Callback {
+	case "listen":
+		if r.ListenAddr == "" {
+			return fmt.Errorf("remote_approval.listen_addr must be set for callback = \"listen\"")
+		}
+		if r.CallbackURL != "" && !httpURL(r.CallbackURL) {
+			return fmt.Errorf("remote_approval.callback_url must be an http:// or https:// URL, not %q", r.CallbackURL)
+		}
+	case "poll":
+		if !httpURL(strings.ReplaceAll(r.PollURL, "{id}", "id")) {
+			return fmt.Errorf("remote_approval.poll_url must be an http:// or https:// URL for callback = \"poll\", not %q", r.PollURL)
+		}
+		if r.PollIntervalSeconds < 1 {
+			return fmt.Errorf("remote_approval.poll_interval_seconds must be at least 1, not %d", r.PollIntervalSeconds)
+		}
+	default:
+		return fmt.Errorf("remote_approval.callback must be \"listen\" or \"poll\", not %q", r.Callback)
+	}
+	if r.TimeoutSeconds < 1 {
+		return fmt.Errorf("remote_approval.timeout_seconds must be at least 1, not %d", r.TimeoutSeconds)
+	}
+	return nil
+}
+
+// httpURL reports whether s is an absolute http:// or https:// URL.
+func httpURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+type TrustCfg struct {
+	TrustedPaths   []string `toml:"trusted_paths"`   // Directories (and their descendants) with relaxed approval
+	UntrustedPaths []string `toml:"untrusted_paths"` // Directories where every step is explicitly approved
+
+	MaxElevationMinutes int `toml:"max_elevation_minutes"` // Longest `og trust --for` grant; 0 means no limit
+}
+
+type StorageCfg struct {
+	Backend string `toml:"backend"` // "sqlite" (default), "filesystem", "shared" or "postgres"
+	Path    string `toml:"path"`    // Backend-specific location, e.g. the sqlite database file or shared directory
+	DSN     string `toml:"dsn"`     // Connection string for database backends such as postgres
+	User    string `toml:"user"`    // Name sessions are attributed to; defaults to the OS user
+}
+
+// IaCCfg controls the plan/preview shown before Terraform, OpenTofu and Pulumi applies.
+type IaCCfg struct {
+	PlanBeforeApply    bool `toml:"plan_before_apply"`    // Run the tool's plan and always prompt before apply-class commands
+	PlanTimeoutSeconds int  `toml:"plan_timeout_seconds"` // How long the plan may take; 0 means no limit
+}
+
+// UICfg controls console presentation.
+type UICfg struct {
+	Banner bool     `toml:"banner"` // Summarize config, models, trust, policy and git branch when a session starts
+	ASCII  bool     `toml:"ascii"`  // Print ASCII tags such as [OK] instead of emoji
+	Theme  ThemeCfg `toml:"theme"`
+}
+
+// ThemeCfg remaps the colors of the console output: a preset of ui.Themes,
+// with the colors set here in place of its own (see ui.ParseColor).
+type ThemeCfg struct {
+	Preset   string `toml:"preset"`             // "default" or "high-contrast"
+	Plan     string `toml:"plan,omitempty"`     // Headings of plans
+	Approval string `toml:"approval,omitempty"` // Approval prompts and what they ask about
+	Error    string `toml:"error,omitempty"`    // Errors and blocked steps
+	Summary  string `toml:"summary,omitempty"`  // The final summary and the results of steps
+	Logs     string `toml:"logs,omitempty"`     // Tags of log lines; empty colors them by level
+}
+
+// colors returns the colors of t by key, for validation.
+func (t *ThemeCfg) colors() map[string]*string {
+	return map[string]*string{"plan": &t.Plan, "approval": &t.Approval, "error": &t.Error, "summary": &t.Summary, "logs": &t.Logs}
+}
+
+// Theme returns the theme t describes; LoadConfig has dropped the colors it
+// could not parse.
+func (t ThemeCfg) Theme() ui.Theme {
+	theme, ok := ui.Themes[t.Preset]
+	if !ok {
+		theme = ui.Themes["default"]
+	}
+	set := func(c **color.Color, s string) {
+		if parsed, err := ui.ParseColor(s); err == nil {
+			*c = parsed
+		}
+	}
+	set(&theme.Plan, t.Plan)
+	set(&theme.Approval, t.Approval)
+	set(&theme.Error, t.Error)
+	set(&theme.Summary, t.Summary)
+	set(&theme.Logs, t.Logs)
+	return theme
+}
+
+// NotificationsCfg controls the desktop notifications og shows when a session
+// needs the user, or ended, while the terminal is not focused.
+type NotificationsCfg struct {
+	Enabled              bool `toml:"enabled"`
+	OnApproval           bool `toml:"on_approval"`            // When a prompt is still unanswered after approval_delay_seconds
+	OnFinish             bool `toml:"on_finish"`              // When a session that ran at least min_session_seconds ends
+	ApprovalDelaySeconds int  `toml:"approval_delay_seconds"` // 0 notifies as soon as a prompt waits
+	MinSessionSeconds    int  `toml:"min_session_seconds"`
+}
+
+// DefaultNotificationsCfg returns the settings used when the [notifications]
+// section is absent: no notifications, but once enabled, for prompts left
+// unanswered for 10 seconds and sessions that ran a minute or more.
+func DefaultNotificationsCfg() NotificationsCfg {
+	return NotificationsCfg{OnApproval: true, OnFinish: true, ApprovalDelaySeconds: 10, MinSessionSeconds: 60}
+}
+
+// HooksCfg configures shell commands og runs on the host before and after each
+// step, with the step described in OG_STEP_* environment variables.
+type HooksCfg struct {
+	PreStep        []string `toml:"pre_step"`        // Run in order before each step, e.g. "git stash"
+	PostStep       []string `toml:"post_step"`       // Run in order after each step, however it went
+	OnFailure      string   `toml:"on_failure"`      // What a failing hook does: "warn" and go on, or "abort" the session
+	TimeoutSeconds int      `toml:"timeout_seconds"` // Bound on each hook
+}
+
+// DefaultHooksCfg returns the settings used when the [hooks] section is
+// absent: no hooks, and once there are, failures are warned about and hooks
+// may run for a minute.
+func DefaultHooksCfg() HooksCfg {
+	return HooksCfg{OnFailure: "warn", TimeoutSeconds: 60}
+}
+
+// RetryCfg controls the retries of model calls that fail with transient errors
+// (connection refused, HTTP 429/503, timeouts), and the restarts of an agent
+// process that exits unexpectedly. Both wait with the same backoff.
+type RetryCfg struct {
+	MaxRetries          int `toml:"max_retries"`           // Retries after the first failure; 0 fails the session at once
+	InitialDelaySeconds int `toml:"initial_delay_seconds"` // Wait before the first retry, doubled for each further one
+	MaxDelaySeconds     int `toml:"max_delay_seconds"`     // Upper bound of the wait
+	AgentRestarts       int `toml:"agent_restarts"`        // Restarts of a crashed agent per session; 0 ends the session
+}
+
+// ClassifierCfg controls the classification of queries before planning. The tag
+// a query gets selects a route in Tags and is recorded in the session history.
+type ClassifierCfg struct {
+	Method         string   `toml:"method"` // "heuristic", "model", "command" or "off"
+	ModelCfg                // For "model": a cheap model; defaults to default_agent
+	Command        []string `toml:"command"`         // For "command": reads the query on stdin and prints a tag
+	TimeoutSeconds int      `toml:"timeout_seconds"` // For "model" and "command"; the heuristic is used after it
+
+	Tags map[string]TagRouteCfg `toml:"tags"` // Keyed by tag, e.g. [classifier.tags.system_admin]
+}
+
+// SecondOpinionCfg configures a second audit of dangerous actions by a model
+// other than the auditor's, which og asks itself before the user confirms them.
+type SecondOpinionCfg struct {
+	ModelCfg              // Served by Ollama or an OpenAI-compatible endpoint; no second audit while unset
+	OnDisagreement string `toml:"on_disagreement"` // "ask" shows both verdicts and lets the user decide; "deny" requires both audits to pass
+	TimeoutSeconds int    `toml:"timeout_seconds"` // A model that does not answer in time disagrees
+}
+
+// TagRouteCfg says how sessions whose query got a tag are run.
+type TagRouteCfg struct {
+	PlannerAgent  ModelCfg `toml:"planner_agent"`  // Replaces [planner_agent] when its model is set
+	ExecutorAgent ModelCfg `toml:"executor_agent"` // Replaces [executor_agent] when its model is set
+	Strictness    string   `toml:"strictness"`     // "strict", "standard" or "relaxed", for directories of default trust
+}
+
+// ContainerCfg configures the container that shell steps run in when
+// general.sandbox is "docker" or "podman".
+type ContainerCfg struct {
+	Image    string `toml:"image"`     // Needs sh, cat and kill
+	ReadOnly bool   `toml:"read_only"` // Mount the working directory read-only
+	Network  bool   `toml:"network"`   // Give the container network access; it has none by default
+}
+
+// DefaultContainerCfg returns the container settings used when the [container] section is absent.
+func DefaultContainerCfg() ContainerCfg {
+	return ContainerCfg{Image: "debian:stable-slim"}
+}
+
+// JailCfg configures, per trust level of the working directory, what shell
+// steps may reach when general.sandbox is "bwrap" or "firejail". Outside the
+// working directory the filesystem is read-only and $HOME is hidden.
+type JailCfg struct {
+	Untrusted JailProfileCfg `toml:"untrusted"`
+	Default   JailProfileCfg `toml:"default"`
+	Trusted   JailProfileCfg `toml:"trusted"`
+}
+
+// JailProfileCfg is what the steps of one trust level may reach.
+type JailProfileCfg struct {
+	ReadOnly bool `toml:"read_only"` // Make the working directory read-only too
+	Network  bool `toml:"network"`   // Give the steps network access
+}
+
+// DefaultJailCfg returns the profiles used when the [jail] section is absent:
+// no network unless the directory is trusted, and a read-only working
+// directory when it is untrusted.
+func DefaultJailCfg() JailCfg {
+	return JailCfg{
+		Untrusted: JailProfileCfg{ReadOnly: true},
+		Trusted:   JailProfileCfg{Network: true},
+	}
+}
+
+// Profile returns the profile of trust level trust ("untrusted", "default" or
+// "trusted").
+func (c JailCfg) Profile(trust string) JailProfileCfg {
+	switch trust {
+	case "untrusted":
+		return c.Untrusted
+	case "trusted":
+		return c.Trusted
+	}
+	return c.Default
+}
+
+// LimitsCfg caps the resources of the agent and of the shell steps og runs, so
+// that a runaway command cannot take the machine down. Zero leaves a resource
+// unlimited. Limits are applied on Linux only.
+type LimitsCfg struct {
+	MemoryMB     int `toml:"memory_mb"`      // Data memory (heap and private mappings) of each process
+	Nice         int `toml:"nice"`           // CPU niceness, from 0 (unchanged) to 19 (lowest priority)
+	MaxOpenFiles int `toml:"max_open_files"` // Open files of each process
+}
+
+// Any reports whether a limit is set.
+func (l LimitsCfg) Any() bool {
+	return l != LimitsCfg{}
+}
+
+// TelemetryCfg configures the OpenTelemetry traces of sessions: a span for the
+// session, the agent's start, each protocol round-trip and each executed step.
+// No traces are sent while Endpoint is unset.
+type TelemetryCfg struct {
+	Endpoint    string            `toml:"endpoint"`     // OTLP/HTTP collector, e.g. "http://localhost:4318"
+	Headers     map[string]string `toml:"headers"`      // Sent with each export, e.g. an API key
+	ServiceName string            `toml:"service_name"` // service.name of the traces
+}
+
+// EditorCfg controls the offer to open files a step wrote in an editor.
+type EditorCfg struct {
+	FollowUp bool   `toml:"follow_up"` // Offer to open written or patched files after a step, in interactive sessions
+	Command  string `toml:"command"`   // Editor command; defaults to $VISUAL, then $EDITOR
+}
+
+// UndoCfg controls the backups of files steps change, which og undo restores.
+type UndoCfg struct {
+	Enabled  bool  `toml:"enabled"`    // Back up the files a step is about to change, in the session's temp directory
+	AskAtEnd bool  `toml:"ask_at_end"` // Ask which changes to undo when the session ends, in interactive sessions
+	MaxBytes int64 `toml:"max_bytes"`  // Most the backups of a session take; files past it are not backed up. 0 is unbounded
+
+	GitCheckpoint string `toml:"git_checkpoint"` // "off", "stash" or "commit": how the git repository is recorded before the first step that writes
+}
+
+// DefaultUndoCfg returns the undo settings used when the [undo] section is absent.
+func DefaultUndoCfg() UndoCfg {
+	return UndoCfg{Enabled: true, MaxBytes: 256 << 20, GitCheckpoint: "off"}
+}
+
+// DatabaseCfg configures a database that the agent can query with sql_query_tool.
+type DatabaseCfg struct {
+	Driver         string `toml:"driver"`          // "postgres", "mysql" or "sqlite3"
+	Keyring        string `toml:"keyring"`         // Keyring entry holding the DSN (service "og"); defaults to the database name
+	DSN            string `toml:"dsn"`             // Plain DSN, used instead of the keyring when set (e.g. a sqlite file)
+	AllowWrites    bool   `toml:"allow_writes"`    // Run queries outside read-only transactions, after approval
+	MaxRows        int    `toml:"max_rows"`        // Rows returned to the agent; defaults to 200
+	TimeoutSeconds int    `toml:"timeout_seconds"` // Query timeout; defaults to 30
+}
+
+// MCPServerCfg configures a Model Context Protocol server whose tools the agent
+// may call through og, keyed in [mcp_servers] by a name of letters, digits, _ and -.
+type MCPServerCfg struct {
+	Command        []string          `toml:"command"`         // Program and arguments; the server speaks MCP on its stdin and stdout
+	Env            map[string]string `toml:"env"`             // Added to og's environment, e.g. an API token
+	Tools          []string          `toml:"tools"`           // Globs of the tools offered to the agent; all of them when empty
+	TimeoutSeconds int               `toml:"timeout_seconds"` // Bound on starting the server and on each call; defaults to 60
+}
+
+// PluginCfg configures a tool plugin, a program built with og's toolplugin
+// package whose tool the agent may call through og, keyed in [plugins] by a
+// name of letters, digits, _ and -.
+type PluginCfg struct {
+	Command        []string          `toml:"command"`         // Program and arguments of the plugin
+	Env            map[string]string `toml:"env"`             // Added to og's environment, e.g. an API token
+	ReadOnly       bool              `toml:"read_only"`       // The tool does not change anything, so read-only sessions may call it
+	TimeoutSeconds int               `toml:"timeout_seconds"` // Bound on starting the plugin and on each call; defaults to 60
+}
+
+// serverName reports whether name is fit to name an MCP server or a plugin:
+// it is not empty and has only letters, digits, _ and -.
+func serverName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// PricingCfg is the price of a model's tokens, keyed in [pricing] by model ID.
+type PricingCfg struct {
+	InputPerMillion  float64 `toml:"input_per_million"`  // Price of one million prompt tokens
+	OutputPerMillion float64 `toml:"output_per_million"` // Price of one million completion tokens
+}
+
+// RedactionCfg controls masking of secrets in console output and files OG writes.
+type RedactionCfg struct {
+	Enabled  bool     `toml:"enabled"`
+	Patterns []string `toml:"patterns"` // Extra regexes; a group named "secret" limits what is masked
+}
+
 type OGConfig struct {
-	DefaultAgent  ModelCfg   `toml:"default_agent"`
-	ExecutorAgent ModelCfg   `toml:"executor_agent"`
-	PlannerAgent  ModelCfg   `toml:"planner_agent"`
-	AuditorAgent  ModelCfg   `toml:"auditor_agent"`
-	General       GeneralCfg `toml:"general"`
-	Cache         CacheCfg   `toml:"cache"`
+	DefaultAgent   ModelCfg          `toml:"default_agent"`
+	ExecutorAgent  ModelCfg          `toml:"executor_agent"`
+	PlannerAgent   ModelCfg          `toml:"planner_agent"`
+	AuditorAgent   ModelCfg          `toml:"auditor_agent"`
+	General        GeneralCfg        `toml:"general"`
+	Output         OutputCfg         `toml:"output"`
+	Cache          CacheCfg          `toml:"cache"`
+	Log            LogCfg            `toml:"log"`
+	Policy         PolicyCfg         `toml:"policy"`
+	Trust          TrustCfg          `toml:"trust"`
+	Storage        StorageCfg        `toml:"storage"`
+	Delegation     DelegationCfg     `toml:"delegation"`
+	RemoteApproval RemoteApprovalCfg `toml:"remote_approval"`
+	Redaction      RedactionCfg      `toml:"redaction"`
+	IaC            IaCCfg            `toml:"iac"`
+	Editor         EditorCfg         `toml:"editor"`
+	Undo           UndoCfg           `toml:"undo"`
+	Container      ContainerCfg      `toml:"container"`
+	Jail           JailCfg           `toml:"jail"`
+	Limits         LimitsCfg         `toml:"limits"`
+	Telemetry      TelemetryCfg      `toml:"telemetry"`
+	UI             UICfg             `toml:"ui"`
+	Notifications  NotificationsCfg  `toml:"notifications"`
+	Hooks          HooksCfg          `toml:"hooks"`
+	Retry          RetryCfg          `toml:"retry"`
+	Classifier     ClassifierCfg     `toml:"classifier"`
+	SecondOpinion  SecondOpinionCfg  `toml:"second_opinion"`
+
+	Databases  map[string]DatabaseCfg  `toml:"databases"`
+	MCPServers map[string]MCPServerCfg `toml:"mcp_servers"`
+	Plugins    map[string]PluginCfg    `toml:"plugins"`
+	Pricing    map[string]PricingCfg   `toml:"pricing"`
 }
 
 const configFileName = "og_config.toml"
+
+// DefaultOutputCfg returns the output settings used when the [output] section is absent.
+func DefaultOutputCfg() OutputCfg {
+	return OutputCfg{
+		InlineMaxBytes:        16384,  // 16KB
+		SummarizeAboveBytes:   32768,  // 32KB
+		SpillToFileAboveBytes: 131072, // 128KB
+	}
+}
+
+// Validate checks that the output thresholds are consistent with each other.
+func (o OutputCfg) Validate() error {
+	if o.InlineMaxBytes < 0 || o.SummarizeAboveBytes < 0 || o.SpillToFileAboveBytes < 0 {
+		return fmt.Errorf("[output] thresholds must not be negative")
+	}
+	if o.SummarizeAboveBytes > 0 && o.SpillToFileAboveBytes > 0 && o.SummarizeAboveBytes > o.SpillToFileAboveBytes {
+		return fmt.Errorf("[output] summarize_above_bytes (%d) must not exceed spill_to_file_above_bytes (%d)", o.SummarizeAboveBytes, o.SpillToFileAboveBytes)
+	}
+	return nil
+}
+
+// Validate checks the classification method and the strictness of each route.
+func (c ClassifierCfg) Validate() error {
+	switch c.Method {
+	case "heuristic", "model", "off":
+	case "command":
+		if len(c.Command) == 0 {
+			return fmt.Errorf("[classifier] method \"command\" requires 'command'")
+		}
+	default:
+		return fmt.Errorf("[classifier] unknown method %q (heuristic, model, command or off)", c.Method)
+	}
+	for tag, route := range c.Tags {
+		switch route.Strictness {
+		case "", "strict", "standard", "relaxed":
+		default:
+			return fmt.Errorf("[classifier.tags.%s] unknown strictness %q (strict, standard or relaxed)", tag, route.Strictness)
+		}
+	}
+	return nil
+}
+
+// DefaultClassifierCfg returns the classifier settings used when the [classifier] section is absent.
+func DefaultClassifierCfg() ClassifierCfg {
+	return ClassifierCfg{Method: "heuristic", TimeoutSeconds: 10}
+}
+
+// DefaultSecondOpinionCfg returns the second audit settings used when the [second_opinion] section is absent.
+func DefaultSecondOpinionCfg() SecondOpinionCfg {
+	return SecondOpinionCfg{OnDisagreement: "ask", TimeoutSeconds: 30}
+}
+
+// DefaultRetryCfg returns the retry settings used when the [retry] section is absent.
+func DefaultRetryCfg() RetryCfg {
+	return RetryCfg{MaxRetries: 4, InitialDelaySeconds: 2, MaxDelaySeconds: 30, AgentRestarts: 2}
+}
+
+// TempDir returns the directory that holds the per-session temp and artifact
+// directories: temp_root, or og in the system temp directory ($TMPDIR on Unix,
+// %TEMP% on Windows).
+func (g GeneralCfg) TempDir() string {
+	if g.TempRoot != "" {
+		return g.TempRoot
+	}
+	return filepath.Join(os.TempDir(), "og")
+}
+
 const defaultPromptsFileName = "prompts.toml"
 
-// GetDataDir returns the base data directory for OG.
+// GetDataDir returns the base data directory for OG: ~/.local/share/og, or
+// %APPDATA%\og on Windows.
 func GetDataDir() (string, error) {
+	if runtime.GOOS == "windows" {
+		appData, err := os.UserConfigDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(appData, "og"), nil
+	}
 	home, err := os.UserHomeDir()
 	if err != nil {
 		return "", err
@@ -53,6 +586,19 @@ func GetDataDir() (string, error) {
 	return filepath.Join(home, ".local", "share", "og"), nil
 }
 
+// ExpandPath replaces a leading "~" (alone, or followed by / or \) with the
+// user's home directory.
+func ExpandPath(p string) string {
+	if p != "~" && !strings.HasPrefix(p, "~/") && !strings.HasPrefix(p, `~\`) {
+		return p
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return p
+	}
+	return filepath.Join(home, filepath.FromSlash(p[1:]))
+}
+
 // GetConfigPath returns the full path to the main configuration file.
 func GetConfigPath() (string, error) {
 	dir, err := GetDataDir()
@@ -71,13 +617,9 @@ func GetPromptsDir() (string, error) {
 	return filepath.Join(dir, "prompts"), nil
 }
 
-// SaveDefaultConfig writes a default OGConfig to the specified path and copies default prompts.
-func SaveDefaultConfig(path string, embeddedPromptsFS embed.FS) error {
-	dir := filepath.Dir(path)
-	if err := os.MkdirAll(dir, 0o755); err != nil {
-		return fmt.Errorf("failed to create config directory %s: %w", dir, err)
-	}
-
+// DefaultConfigTOML returns the config `og init` writes, which `og config diff`
+// compares the user's config with.
+func DefaultConfigTOML() ([]byte, error) {
 	defaults := OGConfig{
 		DefaultAgent: ModelCfg{
 			Model: "ollama/gemma3:12b-it-qat",
@@ -101,95 +643,406 @@ func SaveDefaultConfig(path string, embeddedPromptsFS embed.FS) error {
 			},
 		},
 		General: GeneralCfg{
-			PythonAgentPath:      "~/.local/share/og/agent.py",
-			SummaryMode:          true,
-			VerbosityLevelStr:    ui.LogLevelInfo.String(),
-			SessionTimeout:       30,
-			OutputThresholdBytes: 4096,
+			PythonAgentPath:        "~/.local/share/og/agent.py",
+			SummaryMode:            true,
+			VerbosityLevelStr:      ui.LogLevelInfo.String(),
+			SessionTimeout:         30,
+			CheckModels:            true,
+			AgentTransport:         "stdio",
+			ConcurrentSessions:     "queue",
+			StdinMaxBytes:          DefaultStdinMaxBytes,
+			AttachMaxBytes:         DefaultAttachMaxBytes,
+			RememberRefusals:       true,
+			DistillAfter:           3,
+			Executor:               "python",
+			Backend:                "python",
+			Sandbox:                "none",
+			ApprovalTimeoutDefault: "deny",
+			MaxParallelSteps:       DefaultMaxParallelSteps,
 		},
 
+		Output: DefaultOutputCfg(),
+
 		Cache: CacheCfg{
 			JSONLogs:   true,
 			Directory:  "", // Default to base data dir (~/.local/share/og/)
 			Expiration: 0,  // No expiration by default
 		},
+
+		Log: DefaultLogCfg(),
+
+		Policy: PolicyCfg{
+			AutoApprove:       []string{"final_answer"},
+			AlwaysDeny:        []string{"sudo *"},
+			MaxLoopIterations: DefaultMaxLoopIterations,
+			AuditorStrictness: "standard",
+		},
+
+		Trust: TrustCfg{
+			TrustedPaths:        []string{},
+			UntrustedPaths:      []string{},
+			MaxElevationMinutes: 240,
+		},
+
+		Storage: StorageCfg{
+			Backend: "sqlite",
+		},
+
+		Delegation: DelegationCfg{
+			Approvers:      []string{},
+			TimeoutMinutes: 10,
+		},
+
+		RemoteApproval: DefaultRemoteApprovalCfg(),
+
+		Redaction: RedactionCfg{
+			Enabled:  true,
+			Patterns: []string{},
+		},
+
+		IaC: IaCCfg{
+			PlanBeforeApply:    true,
+			PlanTimeoutSeconds: 300,
+		},
+
+		Editor: EditorCfg{
+			FollowUp: true,
+		},
+
+		Undo: DefaultUndoCfg(),
+
+		Container: DefaultContainerCfg(),
+
+		Jail: DefaultJailCfg(),
+
+		Telemetry: TelemetryCfg{
+			ServiceName: "og",
+		},
+
+		UI: UICfg{
+			Banner: true,
+			Theme:  ThemeCfg{Preset: "default"},
+		},
+
+		Notifications: DefaultNotificationsCfg(),
+
+		Hooks: DefaultHooksCfg(),
+
+		Retry: DefaultRetryCfg(),
+
+		Classifier: DefaultClassifierCfg(),
+
+		SecondOpinion: DefaultSecondOpinionCfg(),
 	}
 
 	b, err := toml.Marshal(defaults)
 	if err != nil {
-		return fmt.Errorf("failed to marshal default config: %w", err)
+		return nil, fmt.Errorf("failed to marshal default config: %w", err)
+	}
+	return b, nil
+}
+
+// SaveDefaultConfig writes a default OGConfig to the specified path and copies default prompts.
+// A config already at path is backed up first (see WriteConfigFile); the path of
+// that backup is returned, or "" when there was none.
+func SaveDefaultConfig(path string, embeddedPromptsFS embed.FS) (string, error) {
+	b, err := DefaultConfigTOML()
+	if err != nil {
+		return "", err
 	}
-	if err := os.WriteFile(path, b, 0o644); err != nil {
-		return fmt.Errorf("failed to write default config to %s: %w", path, err)
+	backup, err := WriteConfigFile(path, b)
+	if err != nil {
+		return "", fmt.Errorf("failed to write default config to %s: %w", path, err)
 	}
 
 	promptsDir, err := GetPromptsDir()
 	if err != nil {
-		return fmt.Errorf("failed to get prompts directory: %w", err)
+		return backup, fmt.Errorf("failed to get prompts directory: %w", err)
 	}
 	if err := os.MkdirAll(promptsDir, 0o755); err != nil {
-		return fmt.Errorf("failed to create prompts directory %s: %w", promptsDir, err)
+		return backup, fmt.Errorf("failed to create prompts directory %s: %w", promptsDir, err)
 	}
 
 	sourcePromptsContent, err := embeddedPromptsFS.ReadFile("prompts/" + defaultPromptsFileName)
 	if err != nil {
-		return fmt.Errorf("failed to read embedded prompts file: %w", err)
+		return backup, fmt.Errorf("failed to read embedded prompts file: %w", err)
 	}
 
 	destinationPromptsPath := filepath.Join(promptsDir, defaultPromptsFileName)
 
 	if err := os.WriteFile(destinationPromptsPath, sourcePromptsContent, 0o644); err != nil {
-		return fmt.Errorf("failed to write prompts file to %s: %w", destinationPromptsPath, err)
+		return backup, fmt.Errorf("failed to write prompts file to %s: %w", destinationPromptsPath, err)
 	}
 
-	return nil
+	return backup, nil
 }
 
-// LoadConfig loads the OGConfig from the default path.
-func LoadConfig() (*OGConfig, error) {
+// LoadConfig loads the OGConfig from the default path. Problems it works around,
+// such as an unknown verbosity level, are returned as warnings for the caller
+// to show.
+func LoadConfig() (*OGConfig, Warnings, error) {
 	path, err := GetConfigPath()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get config path: %w", err)
+		return nil, nil, fmt.Errorf("failed to get config path: %w", err)
 	}
 	data, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		return nil, nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	// Pre-populate defaults for sections whose zero values are meaningful;
+	// keys present in the file override them.
+	cfg := OGConfig{
+		General:        GeneralCfg{CheckModels: true, AgentTransport: "stdio", ConcurrentSessions: "queue", StdinMaxBytes: DefaultStdinMaxBytes, AttachMaxBytes: DefaultAttachMaxBytes, RememberRefusals: true, DistillAfter: 3, Executor: "python", Backend: "python", Sandbox: "none", ApprovalTimeoutDefault: "deny", MaxParallelSteps: DefaultMaxParallelSteps},
+		Output:         DefaultOutputCfg(),
+		Log:            DefaultLogCfg(),
+		Telemetry:      TelemetryCfg{ServiceName: "og"},
+		Redaction:      RedactionCfg{Enabled: true},
+		RemoteApproval: DefaultRemoteApprovalCfg(),
+		IaC:            IaCCfg{PlanBeforeApply: true, PlanTimeoutSeconds: 300},
+		Editor:         EditorCfg{FollowUp: true},
+		Undo:           DefaultUndoCfg(),
+		Container:      DefaultContainerCfg(),
+		Jail:           DefaultJailCfg(),
+		UI:             UICfg{Banner: true, Theme: ThemeCfg{Preset: "default"}},
+		Notifications:  DefaultNotificationsCfg(),
+		Hooks:          DefaultHooksCfg(),
+		Retry:          DefaultRetryCfg(),
+		Classifier:     DefaultClassifierCfg(),
+		SecondOpinion:  DefaultSecondOpinionCfg(),
+		Policy:         PolicyCfg{MaxLoopIterations: DefaultMaxLoopIterations, AuditorStrictness: "standard"},
 	}
-	var cfg OGConfig
 	if err := toml.Unmarshal(data, &cfg); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal config: %w", err)
+		return nil, nil, fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 
 	// Apply defaults where specific agent configs are missing
 	applyDefaultModelConfig(&cfg.ExecutorAgent, cfg.DefaultAgent)
 	applyDefaultModelConfig(&cfg.PlannerAgent, cfg.DefaultAgent)
 	applyDefaultModelConfig(&cfg.AuditorAgent, cfg.DefaultAgent)
+	applyDefaultModelConfig(&cfg.Classifier.ModelCfg, cfg.DefaultAgent)
+	if cfg.SecondOpinion.Model != "" {
+		applyDefaultModelConfig(&cfg.SecondOpinion.ModelCfg, ModelCfg{Params: cfg.DefaultAgent.Params})
+	}
+	for tag, route := range cfg.Classifier.Tags {
+		for _, m := range []*ModelCfg{&route.PlannerAgent, &route.ExecutorAgent} {
+			if m.Model != "" {
+				applyDefaultModelConfig(m, cfg.DefaultAgent)
+			}
+		}
+		cfg.Classifier.Tags[tag] = route
+	}
 
-	expandPath := func(p string) string {
-		if strings.HasPrefix(p, "~/") {
-			home, _ := os.UserHomeDir()
-			return filepath.Join(home, p[2:])
+	var warnings Warnings
+	warnings.checkExpanded("general.python_agent_path", cfg.General.PythonAgentPath)
+	warnings.checkExpanded("general.python_interpreter", cfg.General.PythonInterpreter)
+	warnings.checkExpanded("general.temp_root", cfg.General.TempRoot)
+	warnings.checkExpanded("storage.path", cfg.Storage.Path)
+	warnings.checkExpanded("cache.directory", cfg.Cache.Directory)
+	warnings.checkExpanded("policy.code", cfg.Policy.Code)
+	cfg.General.PythonAgentPath = ExpandPath(cfg.General.PythonAgentPath)
+	cfg.General.PythonInterpreter = ExpandPath(cfg.General.PythonInterpreter)
+	cfg.General.TempRoot = ExpandPath(cfg.General.TempRoot)
+	cfg.Policy.Code = codePath(cfg.Policy.Code, path)
+	if cfg.General.TempRoot != "" && !filepath.IsAbs(cfg.General.TempRoot) {
+		return nil, nil, fmt.Errorf("general.temp_root must be an absolute path, not %q", cfg.General.TempRoot)
+	}
+	if cfg.General.AgentTransport != "stdio" && cfg.General.AgentTransport != "socket" {
+		return nil, nil, fmt.Errorf("general.agent_transport must be \"stdio\" or \"socket\", not %q", cfg.General.AgentTransport)
+	}
+	if cfg.General.DistillAfter < 0 {
+		return nil, nil, fmt.Errorf("general.distill_after must not be negative, not %d", cfg.General.DistillAfter)
+	}
+	if cfg.General.StdinMaxBytes < 0 {
+		return nil, nil, fmt.Errorf("general.stdin_max_bytes must not be negative, not %d", cfg.General.StdinMaxBytes)
+	}
+	if cfg.General.AttachMaxBytes < 1 {
+		return nil, nil, fmt.Errorf("general.attach_max_bytes must be at least 1, not %d", cfg.General.AttachMaxBytes)
+	}
+	if cfg.General.Executor != "python" && cfg.General.Executor != "go" {
+		return nil, nil, fmt.Errorf("general.executor must be \"python\" or \"go\", not %q", cfg.General.Executor)
+	}
+	switch cfg.General.Backend {
+	case "python", "native", "auto":
+	default:
+		return nil, nil, fmt.Errorf("general.backend must be \"python\", \"native\" or \"auto\", not %q", cfg.General.Backend)
+	}
+	switch cfg.General.Sandbox {
+	case "none":
+	case "docker", "podman":
+		if cfg.General.Executor != "go" && cfg.General.Backend != "native" {
+			return nil, nil, fmt.Errorf("general.sandbox = %q needs general.executor = \"go\", as og runs the steps in the container", cfg.General.Sandbox)
+		}
+		if cfg.Container.Image == "" {
+			return nil, nil, fmt.Errorf("[container] image must be set for general.sandbox = %q", cfg.General.Sandbox)
+		}
+	case "bwrap", "firejail":
+		if cfg.General.Executor != "go" && cfg.General.Backend != "native" {
+			return nil, nil, fmt.Errorf("general.sandbox = %q needs general.executor = \"go\", as og runs the steps in the sandbox", cfg.General.Sandbox)
+		}
+		if runtime.GOOS != "linux" {
+			return nil, nil, fmt.Errorf("general.sandbox = %q is only available on Linux", cfg.General.Sandbox)
+		}
+	default:
+		return nil, nil, fmt.Errorf("general.sandbox must be \"none\", \"docker\", \"podman\", \"bwrap\" or \"firejail\", not %q", cfg.General.Sandbox)
+	}
+	if cfg.General.ApprovalTimeoutSeconds < 0 {
+		return nil, nil, fmt.Errorf("general.approval_timeout_seconds must not be negative, not %d", cfg.General.ApprovalTimeoutSeconds)
+	}
+	if d := cfg.General.ApprovalTimeoutDefault; d != "deny" && d != "approve" {
+		warnings.add(WarningInvalid, "general.approval_timeout_default", "must be \"deny\" or \"approve\", not %q; using \"deny\"", d)
+		cfg.General.ApprovalTimeoutDefault = "deny"
+	}
+	if cfg.General.ApprovalTimeoutDefault == "approve" && cfg.General.ApprovalTimeoutSeconds == 0 {
+		warnings.add(WarningIneffective, "general.approval_timeout_default", "is \"approve\", but prompts wait forever while general.approval_timeout_seconds is 0")
+	}
+	if cfg.General.StepTimeoutSeconds < 0 {
+		return nil, nil, fmt.Errorf("general.step_timeout_seconds must not be negative, not %d", cfg.General.StepTimeoutSeconds)
+	}
+	if cfg.General.MaxParallelSteps < 1 {
+		return nil, nil, fmt.Errorf("general.max_parallel_steps must be at least 1, not %d", cfg.General.MaxParallelSteps)
+	}
+	if cfg.Undo.MaxBytes < 0 {
+		return nil, nil, fmt.Errorf("undo.max_bytes must not be negative, not %d", cfg.Undo.MaxBytes)
+	}
+	switch cfg.Undo.GitCheckpoint {
+	case "off", "stash", "commit":
+	default:
+		return nil, nil, fmt.Errorf("undo.git_checkpoint must be \"off\", \"stash\" or \"commit\", not %q", cfg.Undo.GitCheckpoint)
+	}
+	if l := cfg.Limits; l.MemoryMB < 0 || l.MaxOpenFiles < 0 {
+		return nil, nil, fmt.Errorf("[limits] memory_mb and max_open_files must not be negative")
+	}
+	if cfg.Limits.Nice < 0 || cfg.Limits.Nice > 19 {
+		return nil, nil, fmt.Errorf("limits.nice must be between 0 and 19, not %d", cfg.Limits.Nice)
+	}
+	if cfg.Limits.Any() && runtime.GOOS != "linux" {
+		warnings.add(WarningIneffective, "limits", "resource limits are only applied on Linux")
+	}
+	if e := cfg.Telemetry.Endpoint; e != "" {
+		if !httpURL(e) {
+			return nil, nil, fmt.Errorf("telemetry.endpoint must be an http:// or https:// URL, not %q", e)
+		}
+	}
+	if cfg.Telemetry.ServiceName == "" {
+		cfg.Telemetry.ServiceName = "og"
+	}
+	switch cfg.General.ConcurrentSessions {
+	case "queue", "refuse", "allow":
+	default:
+		return nil, nil, fmt.Errorf("general.concurrent_sessions must be \"queue\", \"refuse\" or \"allow\", not %q", cfg.General.ConcurrentSessions)
+	}
+	cfg.Storage.Path = ExpandPath(cfg.Storage.Path)
+	if cfg.Storage.User == "" {
+		if u, err := user.Current(); err == nil {
+			cfg.Storage.User = u.Username
 		}
-		return p
 	}
-	cfg.General.PythonAgentPath = expandPath(cfg.General.PythonAgentPath)
 
-	// Set a default for OutputThresholdBytes if not present in config (for older configs)
-	if cfg.General.OutputThresholdBytes == 0 {
-		cfg.General.OutputThresholdBytes = 131072 // 128KB
+	// Honor the deprecated general.output_threshold_bytes when no [output] section overrides it
+	if cfg.General.OutputThresholdBytes != 0 && cfg.Output == DefaultOutputCfg() {
+		warnings.add(WarningDeprecated, "general.output_threshold_bytes", "deprecated; use [output] spill_to_file_above_bytes instead")
+		cfg.Output.SpillToFileAboveBytes = cfg.General.OutputThresholdBytes
+		if cfg.Output.SummarizeAboveBytes > cfg.Output.SpillToFileAboveBytes {
+			cfg.Output.SummarizeAboveBytes = 0
+		}
+	}
+	if err := cfg.Output.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.Classifier.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := cfg.RemoteApproval.Validate(); err != nil {
+		return nil, nil, err
+	}
+	for name, server := range cfg.MCPServers {
+		if !serverName(name) {
+			return nil, nil, fmt.Errorf("mcp_servers names must consist of letters, digits, _ and -, not %q", name)
+		}
+		if len(server.Command) == 0 || server.Command[0] == "" {
+			return nil, nil, fmt.Errorf("mcp_servers.%s.command must name the server's program", name)
+		}
+		if server.TimeoutSeconds < 0 {
+			return nil, nil, fmt.Errorf("mcp_servers.%s.timeout_seconds must not be negative", name)
+		}
+	}
+	for name, p := range cfg.Plugins {
+		if !serverName(name) {
+			return nil, nil, fmt.Errorf("plugins names must consist of letters, digits, _ and -, not %q", name)
+		}
+		if _, ok := cfg.MCPServers[name]; ok {
+			return nil, nil, fmt.Errorf("plugins.%s is also the name of an MCP server; their tools would clash", name)
+		}
+		if len(p.Command) == 0 || p.Command[0] == "" {
+			return nil, nil, fmt.Errorf("plugins.%s.command must name the plugin's program", name)
+		}
+		if p.TimeoutSeconds < 0 {
+			return nil, nil, fmt.Errorf("plugins.%s.timeout_seconds must not be negative", name)
+		}
+	}
+	if cfg.Policy.MaxLoopIterations < 1 {
+		return nil, nil, fmt.Errorf("policy.max_loop_iterations must be at least 1, not %d", cfg.Policy.MaxLoopIterations)
+	}
+	switch cfg.Policy.AuditorStrictness {
+	case "lenient", "standard", "paranoid":
+	default:
+		return nil, nil, fmt.Errorf("policy.auditor_strictness must be \"lenient\", \"standard\" or \"paranoid\", not %q", cfg.Policy.AuditorStrictness)
+	}
+	switch cfg.SecondOpinion.OnDisagreement {
+	case "ask", "deny":
+	default:
+		return nil, nil, fmt.Errorf("second_opinion.on_disagreement must be \"ask\" or \"deny\", not %q", cfg.SecondOpinion.OnDisagreement)
+	}
+	if cfg.SecondOpinion.Model != "" && cfg.SecondOpinion.Model == cfg.AuditorAgent.Model {
+		warnings.add(WarningIneffective, "second_opinion.model", "the auditor's model (%s); a different model makes the second audit worthwhile", cfg.SecondOpinion.Model)
+	}
+	if n := cfg.Notifications; n.ApprovalDelaySeconds < 0 || n.MinSessionSeconds < 0 {
+		return nil, nil, fmt.Errorf("[notifications] values must not be negative")
+	}
+	switch cfg.Hooks.OnFailure {
+	case "warn", "abort":
+	default:
+		return nil, nil, fmt.Errorf("hooks.on_failure must be \"warn\" or \"abort\", not %q", cfg.Hooks.OnFailure)
+	}
+	if cfg.Hooks.TimeoutSeconds < 1 {
+		return nil, nil, fmt.Errorf("hooks.timeout_seconds must be at least 1, not %d", cfg.Hooks.TimeoutSeconds)
+	}
+	if r := cfg.Retry; r.MaxRetries < 0 || r.InitialDelaySeconds < 0 || r.MaxDelaySeconds < 0 || r.AgentRestarts < 0 {
+		return nil, nil, fmt.Errorf("[retry] values must not be negative")
 	}
 
 	// Parse VerbosityLevel from string after unmarshaling
 	parsedLevel, err := ui.ParseLogLevel(cfg.General.VerbosityLevelStr)
 	if err != nil {
 		// If parsing fails (e.g., invalid string in TOML or empty string if not present),
-		// log a warning and default to LogLevelInfo.
+		// warn and default to LogLevelInfo.
 		// This covers cases where 'verbosity_level' is missing or malformed in the TOML.
-		fmt.Fprintf(os.Stderr, "Warning: %v. Defaulting verbosity to 'info'.\n", err)
+		warnings.add(WarningInvalid, "general.verbosity_level", "%v", err)
 		cfg.General.VerbosityLevel = ui.LogLevelInfo
 	} else {
 		cfg.General.VerbosityLevel = parsedLevel
 	}
+	if _, err := ui.ParseLogLevel(cfg.Log.Level); err != nil {
+		warnings.add(WarningInvalid, "log.level", "%v", err)
+		cfg.Log.Level = ui.LogLevelInfo.String()
+	}
+	if cfg.Log.Format != "text" && cfg.Log.Format != "json" {
+		warnings.add(WarningInvalid, "log.format", "must be \"text\" or \"json\", not %q; using \"text\"", cfg.Log.Format)
+		cfg.Log.Format = "text"
+	}
+	if _, ok := ui.Themes[cfg.UI.Theme.Preset]; !ok {
+		warnings.add(WarningInvalid, "ui.theme.preset", "must be \"default\" or \"high-contrast\", not %q; using \"default\"", cfg.UI.Theme.Preset)
+		cfg.UI.Theme.Preset = "default"
+	}
+	for key, c := range cfg.UI.Theme.colors() {
+		if *c == "" {
+			continue
+		}
+		if _, err := ui.ParseColor(*c); err != nil {
+			warnings.add(WarningInvalid, "ui.theme."+key, "%v; using the preset's color", err)
+			*c = ""
+		}
+	}
 
 	// Apply defaults and resolve path for CacheCfg
 	// If Cache.Directory is empty in TOML, it defaults to "" by unmarshaling.
@@ -197,17 +1050,17 @@ func LoadConfig() (*OGConfig, error) {
 	// Otherwise, it's a subdirectory relative to the base data directory.
 	baseDataDir, err := GetDataDir()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get base data directory for cache path resolution: %w", err)
+		return nil, nil, fmt.Errorf("failed to get base data directory for cache path resolution: %w", err)
 	}
 
 	if cfg.Cache.Directory != "" {
-		cfg.Cache.Directory = expandPath(cfg.Cache.Directory) // Expand potential ~/
+		cfg.Cache.Directory = ExpandPath(cfg.Cache.Directory) // Expand potential ~/
 		cfg.Cache.Directory = filepath.Join(baseDataDir, cfg.Cache.Directory)
 	} else {
 		cfg.Cache.Directory = baseDataDir // If unset, default to base data dir
 	}
 
-	return &cfg, nil
+	return &cfg, warnings, nil
 }
 
 // applyDefaultModelConfig applies default model and params if target is missing them.
@@ -230,3 +1083,41 @@ func applyDefaultModelConfig(target *ModelCfg, defaults ModelCfg) {
 		target.Params = mergedParams
 	}
 }
+
+// LoadPolicyFile reads a proposed policy from a TOML file. The file may be a full
+// og_config.toml (its [policy] and [trust] sections are used) or contain just the
+// keys of the [policy] section at the top level. The returned TrustCfg is nil if
+// the file has no [trust] section.
+func LoadPolicyFile(path string) (PolicyCfg, *TrustCfg, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return PolicyCfg{}, nil, fmt.Errorf("failed to read policy file %s: %w", path, err)
+	}
+	var full struct {
+		Policy *PolicyCfg `toml:"policy"`
+		Trust  *TrustCfg  `toml:"trust"`
+	}
+	if err := toml.Unmarshal(data, &full); err != nil {
+		return PolicyCfg{}, nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	if full.Policy != nil {
+		full.Policy.Code = codePath(full.Policy.Code, path)
+		return *full.Policy, full.Trust, nil
+	}
+	var policy PolicyCfg
+	if err := toml.Unmarshal(data, &policy); err != nil {
+		return PolicyCfg{}, nil, fmt.Errorf("failed to parse policy file %s: %w", path, err)
+	}
+	policy.Code = codePath(policy.Code, path)
+	return policy, full.Trust, nil
+}
+
+// codePath resolves policy.code, which is relative to the directory of the
+// file that sets it.
+func codePath(code, file string) string {
+	code = ExpandPath(code)
+	if code == "" || filepath.IsAbs(code) {
+		return code
+	}
+	return filepath.Join(filepath.Dir(file), code)
+}
@@ -0,0 +1,29 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// GetPromptsPath returns the full path to the prompts file the agent loads.
+func GetPromptsPath() (string, error) {
+	dir, err := GetPromptsDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, defaultPromptsFileName), nil
+}
+
+// PromptsVersion returns the version declared at the top of a prompts file.
+// Files from before prompts were versioned declare none and yield 0.
+func PromptsVersion(data []byte) (int, error) {
+	var header struct {
+		Version int `toml:"version"`
+	}
+	if err := toml.Unmarshal(data, &header); err != nil {
+		return 0, fmt.Errorf("failed to parse prompts: %w", err)
+	}
+	return header.Version, nil
+}
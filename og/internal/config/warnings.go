@@ -0,0 +1,46 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WarningKind classifies a Warning.
+type WarningKind string
+
+const (
+	WarningInvalid     WarningKind = "invalid"         // A value og replaces with its default
+	WarningDeprecated  WarningKind = "deprecated"      // A key that still works but has a successor
+	WarningUnexpanded  WarningKind = "unexpanded_path" // A path with a variable og does not expand
+	WarningIneffective WarningKind = "ineffective"     // A setting that has little or no effect as set
+)
+
+// A Warning is a problem in the config that LoadConfig works around rather
+// than failing on.
+type Warning struct {
+	Kind    WarningKind
+	Key     string // Config key it concerns, e.g. "general.verbosity_level"
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("%s: %s", w.Key, w.Message)
+}
+
+// Warnings are the warnings of a config, in the order LoadConfig found them.
+type Warnings []Warning
+
+func (ws *Warnings) add(kind WarningKind, key, format string, a ...interface{}) {
+	*ws = append(*ws, Warning{Kind: kind, Key: key, Message: fmt.Sprintf(format, a...)})
+}
+
+// checkExpanded warns about a path that still holds an environment variable
+// or another user's home directory, which og takes literally.
+func (ws *Warnings) checkExpanded(key, path string) {
+	switch {
+	case strings.Contains(path, "$"), strings.Count(path, "%") >= 2:
+		ws.add(WarningUnexpanded, key, "%q contains an environment variable, which og does not expand", path)
+	case strings.HasPrefix(path, "~") && path != "~" && !strings.HasPrefix(path, "~/") && !strings.HasPrefix(path, `~\`):
+		ws.add(WarningUnexpanded, key, "%q starts with ~user, which og does not expand; only ~/ is", path)
+	}
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// ConfigBackups is how many backups of og_config.toml WriteConfigFile keeps.
+const ConfigBackups = 10
+
+// backupTimeFormat names backups so that they sort by age.
+const backupTimeFormat = "20060102-150405.000"
+
+// GetConfigBackupDir returns the directory that keeps the backups of og_config.toml.
+func GetConfigBackupDir() (string, error) {
+	dir, err := GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "config_backups"), nil
+}
+
+// WriteConfigFile replaces the config file at path with data atomically (temp
+// file + rename), so that a crash leaves either the old or the new file. An
+// existing file is first copied to the backup directory, of which the newest
+// ConfigBackups are kept; the path of that backup is returned, or "" when there
+// was no file to back up.
+func WriteConfigFile(path string, data []byte) (string, error) {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create config directory %s: %w", dir, err)
+	}
+	mode := os.FileMode(0o644)
+	backup := ""
+	if old, err := os.ReadFile(path); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		if backup, err = backupConfig(old, mode); err != nil {
+			return "", err
+		}
+	} else if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("failed to create a temporary config file in %s: %w", dir, err)
+	}
+	defer os.Remove(tmp.Name()) // Fails harmlessly once renamed
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), mode)
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write config file %s: %w", tmp.Name(), err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("failed to replace config file %s: %w", path, err)
+	}
+	return backup, nil
+}
+
+// backupConfig saves data as the newest backup and removes the oldest beyond
+// ConfigBackups.
+func backupConfig(data []byte, mode os.FileMode) (string, error) {
+	dir, err := GetConfigBackupDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get config backup directory: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create config backup directory %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, configFileName+"."+time.Now().Format(backupTimeFormat))
+	if err := os.WriteFile(path, data, mode); err != nil {
+		return "", fmt.Errorf("failed to back up the config to %s: %w", path, err)
+	}
+	backups, err := ListConfigBackups()
+	if err != nil {
+		return "", err
+	}
+	for _, old := range backups[min(len(backups), ConfigBackups):] {
+		os.Remove(old)
+	}
+	return path, nil
+}
+
+// ListConfigBackups returns the paths of the backups of og_config.toml, newest
+// first.
+func ListConfigBackups() ([]string, error) {
+	dir, err := GetConfigBackupDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get config backup directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read config backup directory %s: %w", dir, err)
+	}
+	var backups []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasPrefix(e.Name(), configFileName+".") {
+			backups = append(backups, filepath.Join(dir, e.Name()))
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// BackupTime returns when a backup was taken, from its name.
+func BackupTime(backup string) (time.Time, error) {
+	stamp := strings.TrimPrefix(filepath.Base(backup), configFileName+".")
+	return time.ParseInLocation(backupTimeFormat, stamp, time.Local)
+}
+
+// RollbackConfig restores og_config.toml from backup, after checking that it is
+// valid TOML. The config it replaces is backed up in turn, so a rollback can be
+// undone; the path of that backup is returned.
+func RollbackConfig(backup string) (string, error) {
+	data, err := os.ReadFile(backup)
+	if err != nil {
+		return "", fmt.Errorf("failed to read backup %s: %w", backup, err)
+	}
+	var doc map[string]interface{}
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return "", fmt.Errorf("backup %s is not valid TOML: %w", backup, err)
+	}
+	path, err := GetConfigPath()
+	if err != nil {
+		return "", fmt.Errorf("failed to determine config path: %w", err)
+	}
+	return WriteConfigFile(path, data)
+}
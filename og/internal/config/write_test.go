@@ -0,0 +1,205 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempDataDir points GetDataDir at a fresh directory for the test.
+func tempDataDir(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("APPDATA", home)
+	dir, err := GetDataDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		old     string // "" for no config file
+		mode    os.FileMode
+		writes  int // Times the new config is written
+		backups int // Backups left afterwards
+	}{
+		{name: "new file", writes: 1, backups: 0},
+		{name: "replaced file", old: "a = 1\n", mode: 0o644, writes: 1, backups: 1},
+		{name: "mode kept", old: "a = 1\n", mode: 0o600, writes: 1, backups: 1},
+		{name: "backups capped", old: "a = 1\n", mode: 0o644, writes: ConfigBackups + 3, backups: ConfigBackups},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDataDir(t)
+			path := filepath.Join(dir, configFileName)
+			if tt.old != "" {
+				if err := os.MkdirAll(dir, 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(path, []byte(tt.old), tt.mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var backup string
+			for i := range tt.writes {
+				data := fmt.Sprintf("a = %d\n", i+2)
+				b, err := WriteConfigFile(path, []byte(data))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if i == 0 {
+					backup = b
+				}
+				time.Sleep(2 * time.Millisecond) // Backups are named to the millisecond
+			}
+
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := fmt.Sprintf("a = %d\n", tt.writes+1); string(got) != want {
+				t.Errorf("config = %q, want %q", got, want)
+			}
+			if tt.old == "" {
+				if backup != "" {
+					t.Errorf("backup = %q, want none", backup)
+				}
+			} else if tt.writes == 1 {
+				saved, err := os.ReadFile(backup)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(saved) != tt.old {
+					t.Errorf("backup = %q, want %q", saved, tt.old)
+				}
+			}
+			if tt.mode != 0 {
+				info, err := os.Stat(path)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if info.Mode().Perm() != tt.mode {
+					t.Errorf("mode = %v, want %v", info.Mode().Perm(), tt.mode)
+				}
+			}
+			backups, err := ListConfigBackups()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(backups) != tt.backups {
+				t.Errorf("%d backups, want %d", len(backups), tt.backups)
+			}
+			entries, err := os.ReadDir(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, e := range entries {
+				if strings.HasSuffix(e.Name(), ".tmp") {
+					t.Errorf("temporary file %s left behind", e.Name())
+				}
+			}
+		})
+	}
+}
+
+func TestWriteConfigFileFailure(t *testing.T) {
+	dir := tempDataDir(t)
+	path := filepath.Join(dir, configFileName)
+	if err := os.MkdirAll(path, 0o755); err != nil { // A directory cannot be replaced by the rename
+		t.Fatal(err)
+	}
+	if _, err := WriteConfigFile(path, []byte("a = 1\n")); err == nil {
+		t.Fatal("WriteConfigFile succeeded over a directory")
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".tmp") {
+			t.Errorf("temporary file %s left behind", e.Name())
+		}
+	}
+}
+
+func TestRollbackConfig(t *testing.T) {
+	tests := []struct {
+		name    string
+		backup  string
+		wantErr string
+		want    string // The config afterwards
+	}{
+		{name: "valid backup", backup: "a = 1\n", want: "a = 1\n"},
+		{name: "invalid TOML", backup: "a = \n", wantErr: "not valid TOML", want: "a = 2\n"},
+		{name: "missing backup", wantErr: "failed to read backup", want: "a = 2\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := tempDataDir(t)
+			path := filepath.Join(dir, configFileName)
+			if _, err := WriteConfigFile(path, []byte("a = 2\n")); err != nil {
+				t.Fatal(err)
+			}
+			backup := filepath.Join(t.TempDir(), configFileName+".20260101-000000.000")
+			if tt.backup != "" {
+				if err := os.WriteFile(backup, []byte(tt.backup), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			replaced, err := RollbackConfig(backup)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("RollbackConfig() error = %v, want %q", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			got, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("config = %q, want %q", got, tt.want)
+			}
+			if tt.wantErr == "" {
+				// The replaced config is backed up, so the rollback can be undone
+				saved, err := os.ReadFile(replaced)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if string(saved) != "a = 2\n" {
+					t.Errorf("backup of the replaced config = %q, want %q", saved, "a = 2\n")
+				}
+			}
+		})
+	}
+}
+
+func TestBackupTime(t *testing.T) {
+	dir := tempDataDir(t)
+	path := filepath.Join(dir, configFileName)
+	for i := range 2 {
+		if _, err := WriteConfigFile(path, []byte(fmt.Sprintf("a = %d\n", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	backups, err := ListConfigBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 1 {
+		t.Fatalf("%d backups, want 1", len(backups))
+	}
+	if _, err := BackupTime(backups[0]); err != nil {
+		t.Errorf("BackupTime(%s): %v", backups[0], err)
+	}
+}
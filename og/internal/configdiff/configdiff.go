@@ -0,0 +1,202 @@
+// Package configdiff compares a user's TOML file (og_config.toml, prompts.toml)
+// with the defaults shipped in the binary. Files are compared setting by
+// setting rather than line by line, so comments, ordering and formatting do not
+// show up as differences; long multi-line values such as prompts are compared
+// line by line.
+package configdiff
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Kind says how a setting differs from the defaults.
+type Kind int
+
+const (
+	Changed Kind = iota // Set to a value other than the default
+	Added               // Not among the defaults: a table of the user's own, or an unknown or removed setting
+	NotSet              // Left out, so the default applies
+)
+
+// Entry is a setting that differs from the defaults.
+type Entry struct {
+	Key     string // Dotted path, e.g. "retry.max_retries"
+	Kind    Kind
+	Default any // Absent for Added
+	Yours   any // Absent for NotSet
+}
+
+// Compare returns the settings of yours that differ from defaults, sorted by key.
+func Compare(defaults, yours []byte) ([]Entry, error) {
+	d, err := flatten(defaults)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse the defaults: %w", err)
+	}
+	y, err := flatten(yours)
+	if err != nil {
+		return nil, err
+	}
+	var entries []Entry
+	for key, yv := range y {
+		dv, ok := d[key]
+		switch {
+		case !ok:
+			entries = append(entries, Entry{Key: key, Kind: Added, Yours: yv})
+		case !reflect.DeepEqual(dv, yv):
+			entries = append(entries, Entry{Key: key, Kind: Changed, Default: dv, Yours: yv})
+		}
+	}
+	for key, dv := range d {
+		if _, ok := y[key]; !ok {
+			entries = append(entries, Entry{Key: key, Kind: NotSet, Default: dv})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+// flatten parses a TOML document into its settings, keyed by dotted path.
+// Arrays, including arrays of tables, are single settings.
+func flatten(data []byte) (map[string]any, error) {
+	var doc map[string]any
+	if err := toml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	out := map[string]any{}
+	var walk func(prefix string, table map[string]any)
+	walk = func(prefix string, table map[string]any) {
+		for k, v := range table {
+			if sub, ok := v.(map[string]any); ok {
+				walk(prefix+k+".", sub) // An empty table sets nothing
+				continue
+			}
+			out[prefix+k] = v
+		}
+	}
+	walk("", doc)
+	return out, nil
+}
+
+// Format renders entries as a unified diff, defaults on the "-" side. Multi-line
+// strings that changed get a line diff in their own hunk. NotSet entries are
+// only listed with all.
+func Format(entries []Entry, defaultsLabel, yoursLabel string, all bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", defaultsLabel, yoursLabel)
+	for _, e := range entries {
+		switch e.Kind {
+		case Changed:
+			ds, dok := e.Default.(string)
+			ys, yok := e.Yours.(string)
+			if dok && yok && (strings.Contains(ds, "\n") || strings.Contains(ys, "\n")) {
+				fmt.Fprintf(&b, "@@ %s @@\n%s", e.Key, Lines(ds, ys, 3))
+				continue
+			}
+			fmt.Fprintf(&b, "-%s = %s\n+%s = %s\n", e.Key, value(e.Default), e.Key, value(e.Yours))
+		case Added:
+			fmt.Fprintf(&b, "+%s = %s\n", e.Key, value(e.Yours))
+		case NotSet:
+			if all {
+				fmt.Fprintf(&b, " %s = %s  (not set, the default applies)\n", e.Key, value(e.Default))
+			}
+		}
+	}
+	return b.String()
+}
+
+// value renders a setting as TOML, shortening multi-line strings.
+func value(v any) string {
+	if s, ok := v.(string); ok && strings.Contains(s, "\n") {
+		first, _, _ := strings.Cut(s, "\n")
+		return fmt.Sprintf("%q… (%d lines)", first, strings.Count(s, "\n")+1)
+	}
+	b, err := toml.Marshal(map[string]any{"v": v})
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(string(b), "v = "))
+}
+
+// Lines returns the body of a unified diff from a to b, without file headers:
+// changed lines prefixed with "-" and "+" and up to context unchanged lines
+// around them, each group of changes introduced by a "@@ -l,n +l,n @@" header.
+func Lines(a, b string, context int) string {
+	x, y := strings.Split(a, "\n"), strings.Split(b, "\n")
+
+	// lcs[i][j] is the length of the longest common subsequence of x[i:] and y[j:]
+	lcs := make([][]int, len(x)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(y)+1)
+	}
+	for i := len(x) - 1; i >= 0; i-- {
+		for j := len(y) - 1; j >= 0; j-- {
+			if x[i] == y[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	type op struct {
+		kind byte // ' ', '-' or '+'
+		line string
+		i, j int // Positions in x and y before the line
+	}
+	var ops []op
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && x[i] == y[j]:
+			ops = append(ops, op{' ', x[i], i, j})
+			i, j = i+1, j+1
+		case i < len(x) && (j == len(y) || lcs[i+1][j] >= lcs[i][j+1]):
+			ops = append(ops, op{'-', x[i], i, j})
+			i++
+		default:
+			ops = append(ops, op{'+', y[j], i, j})
+			j++
+		}
+	}
+
+	var out strings.Builder
+	for start := 0; start < len(ops); {
+		// Find the next change and extend the hunk while changes are close together
+		first := start
+		for first < len(ops) && ops[first].kind == ' ' {
+			first++
+		}
+		if first == len(ops) {
+			break
+		}
+		last := first
+		for k := first; k < len(ops); k++ {
+			if ops[k].kind != ' ' {
+				last = k
+			} else if k-last > 2*context {
+				break
+			}
+		}
+		from, to := max(first-context, start), min(last+context+1, len(ops))
+		var oldLen, newLen int
+		for _, o := range ops[from:to] {
+			if o.kind != '+' {
+				oldLen++
+			}
+			if o.kind != '-' {
+				newLen++
+			}
+		}
+		fmt.Fprintf(&out, "@@ -%d,%d +%d,%d @@\n", ops[from].i+1, oldLen, ops[from].j+1, newLen)
+		for _, o := range ops[from:to] {
+			fmt.Fprintf(&out, "%c%s\n", o.kind, o.line)
+		}
+		start = to
+	}
+	return out.String()
+}
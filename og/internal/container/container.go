@@ -0,0 +1,147 @@
+// Package container runs the shell steps of a session inside a Docker or
+// Podman container (general.sandbox), with the working directory mounted at
+// the same path, so that a step can only change what is mounted.
+package container
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// startTimeout is how long a created container may take to be running.
+const startTimeout = 30 * time.Second
+
+// Container is the container of a session. Its main process reads og's end of
+// a pipe, so it stops, and is removed, when og exits without removing it.
+type Container struct {
+	Runtime string // "docker" or "podman"
+	ID      string
+	Image   string
+
+	attached *exec.Cmd      // runtime start -ai, which keeps the container running
+	stdin    io.WriteCloser // Closing it stops the container
+}
+
+// Start creates and starts a container for session from cfg's image, with dir
+// mounted read-write (or read-only) at the same path and, unless cfg allows
+// it, no network. Creating it may pull the image first.
+func Start(runtime string, cfg config.ContainerCfg, dir, session string) (*Container, error) {
+	if _, err := exec.LookPath(runtime); err != nil {
+		return nil, fmt.Errorf("general.sandbox is %q, but %s is not installed", runtime, runtime)
+	}
+	mount := dir + ":" + dir
+	if cfg.ReadOnly {
+		mount += ":ro"
+	}
+	args := []string{"create", "--rm", "-i",
+		"--label", "og.session=" + session,
+		"--name", "og-" + session,
+		"-v", mount, "-w", dir,
+		"-e", "HOME=/tmp",
+	}
+	if !cfg.Network {
+		args = append(args, "--network", "none")
+	}
+	// Files the steps write belong to the user, not to root; Podman maps root
+	// in rootless containers to the user already
+	if uid, gid := os.Getuid(), os.Getgid(); runtime == "docker" && uid > 0 {
+		args = append(args, "--user", fmt.Sprintf("%d:%d", uid, gid))
+	}
+	args = append(args, cfg.Image, "sh", "-c", "exec cat >/dev/null")
+
+	var stdout, stderr bytes.Buffer
+	create := exec.Command(runtime, args...)
+	create.Stdout, create.Stderr = &stdout, &stderr
+	if err := create.Run(); err != nil {
+		return nil, fmt.Errorf("failed to create a container from %s: %s", cfg.Image, lastLine(stderr.String(), err))
+	}
+	c := &Container{Runtime: runtime, ID: strings.TrimSpace(stdout.String()), Image: cfg.Image}
+
+	c.attached = exec.Command(runtime, "start", "-ai", c.ID)
+	stdin, err := c.attached.StdinPipe()
+	if err == nil {
+		c.stdin = stdin
+		err = c.attached.Start()
+	}
+	if err != nil {
+		exec.Command(runtime, "rm", "-f", c.ID).Run()
+		return nil, fmt.Errorf("failed to start the container: %w", err)
+	}
+	if err := c.waitRunning(); err != nil {
+		c.Remove()
+		return nil, err
+	}
+	return c, nil
+}
+
+// waitRunning waits for the container to be running.
+func (c *Container) waitRunning() error {
+	deadline := time.Now().Add(startTimeout)
+	for time.Now().Before(deadline) {
+		out, err := exec.Command(c.Runtime, "inspect", "-f", "{{.State.Running}}", c.ID).Output()
+		if err == nil && strings.TrimSpace(string(out)) == "true" {
+			return nil
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return fmt.Errorf("the container did not start within %s", startTimeout)
+}
+
+// Command runs command with sh in dir inside the container, with env added to
+// its environment. When ctx is done, the step's processes in the container are
+// killed along with it.
+func (c *Container) Command(ctx context.Context, dir, command string, env []string) *exec.Cmd {
+	args := []string{"exec", "-w", dir}
+	for _, e := range env {
+		args = append(args, "-e", e)
+	}
+	cmd := exec.CommandContext(ctx, c.Runtime, append(args, c.ID, "sh", "-c", command)...)
+	cmd.Cancel = func() error {
+		// kill -1 spares the container's main process, so later steps still run
+		exec.Command(c.Runtime, "exec", c.ID, "kill", "-KILL", "-1").Run()
+		return cmd.Process.Kill()
+	}
+	return cmd
+}
+
+// Remove stops and removes the container.
+func (c *Container) Remove() error {
+	c.stdin.Close()
+	err := exec.Command(c.Runtime, "rm", "-f", c.ID).Run()
+	c.attached.Wait()
+	if err != nil {
+		return fmt.Errorf("failed to remove container %s: %w", c.ID, err)
+	}
+	return nil
+}
+
+// Describe says where the steps run, e.g. "docker container from
+// debian:stable-slim (workdir read-write, no network)".
+func Describe(runtime string, cfg config.ContainerCfg) string {
+	mode, network := "read-write", "no network"
+	if cfg.ReadOnly {
+		mode = "read-only"
+	}
+	if cfg.Network {
+		network = "network"
+	}
+	return fmt.Sprintf("%s container from %s (workdir %s, %s)", runtime, cfg.Image, mode, network)
+}
+
+// lastLine returns the last line the runtime printed, after any progress of a
+// pull: the reason it failed.
+func lastLine(s string, err error) string {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return err.Error()
+	}
+	return s[strings.LastIndexByte(s, '\n')+1:]
+}
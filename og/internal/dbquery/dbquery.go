@@ -0,0 +1,231 @@
+// Package dbquery runs the agent's SQL queries against configured databases,
+// in read-only transactions and with a row limit unless writes are allowed.
+package dbquery
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // Registers the "mysql" database/sql driver
+	_ "github.com/lib/pq"              // Registers the "postgres" database/sql driver
+	"github.com/zalando/go-keyring"
+	_ "modernc.org/sqlite" // Registers the pure Go "sqlite" database/sql driver
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// KeyringService is the keyring service under which DSNs are stored.
+const KeyringService = "og"
+
+const (
+	defaultMaxRows = 200
+	defaultTimeout = 30 * time.Second
+	maxCellWidth   = 60
+)
+
+// Drivers are the database/sql driver names a database may use.
+var Drivers = []string{"postgres", "mysql", "sqlite3"}
+
+// Result is the outcome of a query.
+type Result struct {
+	Columns   []string
+	Rows      [][]string
+	Truncated bool // More rows matched than the limit allowed
+}
+
+// KeyringUser returns the keyring entry that holds the DSN of a database.
+func KeyringUser(name string, cfg config.DatabaseCfg) string {
+	if cfg.Keyring != "" {
+		return cfg.Keyring
+	}
+	return name
+}
+
+// Names returns the configured database names, sorted.
+func Names(dbs map[string]config.DatabaseCfg) []string {
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Open connects to a configured database, reading its DSN from the config or the keyring.
+func Open(name string, cfg config.DatabaseCfg) (*sql.DB, error) {
+	if !slices.Contains(Drivers, cfg.Driver) {
+		return nil, fmt.Errorf("database '%s' has unsupported driver '%s' (use %s)", name, cfg.Driver, strings.Join(Drivers, ", "))
+	}
+	dsn := cfg.DSN
+	if dsn == "" {
+		var err error
+		dsn, err = keyring.Get(KeyringService, KeyringUser(name, cfg))
+		if errors.Is(err, keyring.ErrNotFound) {
+			return nil, fmt.Errorf("no DSN stored for database '%s'; run `og db set-dsn %s`", name, name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read DSN of database '%s' from the keyring: %w", name, err)
+		}
+	}
+	driver := cfg.Driver
+	if driver == "sqlite3" {
+		driver = "sqlite" // The name the pure Go driver registers
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database '%s': %w", name, err)
+	}
+	return db, nil
+}
+
+// Run runs a single statement. Unless cfg allows writes, it runs in a read-only
+// transaction that is always rolled back.
+func Run(db *sql.DB, cfg config.DatabaseCfg, query string) (*Result, error) {
+	timeout := defaultTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	maxRows := cfg.MaxRows
+	if maxRows <= 0 {
+		maxRows = defaultMaxRows
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	defer conn.Close()
+
+	readOnly := !cfg.AllowWrites
+	opts := &sql.TxOptions{ReadOnly: readOnly}
+	if readOnly && cfg.Driver == "sqlite3" {
+		// The sqlite3 driver has no read-only transactions; query_only is per connection
+		if _, err := conn.ExecContext(ctx, "PRAGMA query_only = ON"); err != nil {
+			return nil, fmt.Errorf("failed to make the connection read-only: %w", err)
+		}
+		defer conn.ExecContext(context.Background(), "PRAGMA query_only = OFF")
+		opts = nil
+	}
+	tx, err := conn.BeginTx(ctx, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	res, err := collectRows(ctx, tx, query, maxRows)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, fmt.Errorf("query timed out after %s", timeout)
+		}
+		return nil, err
+	}
+	if !readOnly {
+		if err := tx.Commit(); err != nil {
+			return nil, fmt.Errorf("failed to commit: %w", err)
+		}
+	}
+	return res, nil
+}
+
+func collectRows(ctx context.Context, tx *sql.Tx, query string, maxRows int) (*Result, error) {
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	res := &Result{Columns: cols}
+	values := make([]any, len(cols))
+	ptrs := make([]any, len(cols))
+	for i := range values {
+		ptrs[i] = &values[i]
+	}
+	for rows.Next() {
+		if len(res.Rows) == maxRows {
+			res.Truncated = true
+			break
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+		row := make([]string, len(cols))
+		for i, v := range values {
+			row[i] = formatValue(v)
+		}
+		res.Rows = append(res.Rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "NULL"
+	case []byte:
+		return string(v)
+	case time.Time:
+		return v.Format(time.RFC3339)
+	default:
+		return fmt.Sprint(v)
+	}
+}
+
+// Format renders the result as an aligned text table for the agent.
+func (r *Result) Format() string {
+	if len(r.Columns) == 0 {
+		return "[Statement executed; no rows returned]"
+	}
+	if len(r.Rows) == 0 {
+		return "[No rows] Columns: " + strings.Join(r.Columns, ", ")
+	}
+	widths := make([]int, len(r.Columns))
+	cell := func(s string) string {
+		return ui.Truncate(strings.ReplaceAll(s, "\n", " "), maxCellWidth)
+	}
+	for i, c := range r.Columns {
+		widths[i] = ui.DisplayWidth(cell(c))
+	}
+	for _, row := range r.Rows {
+		for i, v := range row {
+			widths[i] = max(widths[i], ui.DisplayWidth(cell(v)))
+		}
+	}
+	var b strings.Builder
+	line := func(values []string) {
+		padded := make([]string, len(values))
+		for i, v := range values {
+			padded[i] = ui.PadRight(cell(v), widths[i])
+		}
+		b.WriteString(strings.TrimRight(strings.Join(padded, " | "), " ") + "\n")
+	}
+	line(r.Columns)
+	sep := make([]string, len(widths))
+	for i, w := range widths {
+		sep[i] = strings.Repeat("-", w)
+	}
+	b.WriteString(strings.Join(sep, "-+-") + "\n")
+	for _, row := range r.Rows {
+		line(row)
+	}
+	fmt.Fprintf(&b, "(%d row(s)", len(r.Rows))
+	if r.Truncated {
+		b.WriteString("; more rows matched but were cut off by the row limit, add a LIMIT or aggregate")
+	}
+	b.WriteString(")")
+	return b.String()
+}
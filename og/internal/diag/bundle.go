@@ -0,0 +1,115 @@
+package diag
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// CrashBundleSuffix is the file name suffix of crash bundles written to the cache directory.
+const CrashBundleSuffix = ".crash.tar.gz"
+
+// CrashBundlePath returns the path of the crash bundle for a session.
+func CrashBundlePath(cacheDir, sessionHash string) string {
+	return filepath.Join(cacheDir, sessionHash+CrashBundleSuffix)
+}
+
+// WriteCrashBundle collects the given files (missing ones are skipped) plus a
+// short report into a gzipped tarball and returns its path.
+func WriteCrashBundle(cacheDir, sessionHash, report string, files []string) (string, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory %s: %w", cacheDir, err)
+	}
+	path := CrashBundlePath(cacheDir, sessionHash)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to create crash bundle %s: %w", path, err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	if err := addBytes(tw, "report.txt", []byte(report)); err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		if err := addFile(tw, file); err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize crash bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return "", fmt.Errorf("failed to finalize crash bundle: %w", err)
+	}
+	return path, nil
+}
+
+// addBytes writes an in-memory file into the archive.
+func addBytes(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s to crash bundle: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s to crash bundle: %w", name, err)
+	}
+	return nil
+}
+
+// addFile copies a file from disk into the archive under its base name.
+func addFile(tw *tar.Writer, path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	hdr, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to build header for %s: %w", path, err)
+	}
+	hdr.Name = filepath.Base(path)
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("failed to write %s to crash bundle: %w", path, err)
+	}
+	if _, err := io.Copy(tw, src); err != nil {
+		return fmt.Errorf("failed to write %s to crash bundle: %w", path, err)
+	}
+	return nil
+}
+
+// FormatReport renders a plain-text crash report.
+func FormatReport(sessionHash, query string, cause error, extra map[string]string) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "session: %s\n", sessionHash)
+	fmt.Fprintf(&sb, "time: %s\n", time.Now().Format(time.RFC3339))
+	fmt.Fprintf(&sb, "query: %s\n", query)
+	if cause != nil {
+		fmt.Fprintf(&sb, "error: %v\n", cause)
+	}
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(&sb, "%s: %s\n", k, extra[k])
+	}
+	return sb.String()
+}
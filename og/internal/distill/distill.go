@@ -0,0 +1,285 @@
+// Package distill finds the commands that sessions in a directory ran again and
+// again, and turns them into a Makefile target or Taskfile task, so that work
+// the agent keeps being asked to do becomes conventional automation (see
+// og distill and general.distill_after).
+package distill
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+)
+
+// Run is a completed session and the shell commands it ran successfully, in
+// the order it ran them.
+type Run struct {
+	Session  string
+	TS       string
+	Query    string
+	Commands []string
+}
+
+// Recipe is a sequence of shell commands that several sessions ran.
+type Recipe struct {
+	Commands []string
+	Runs     []Run // Oldest first
+}
+
+// Runs returns the completed sessions of user in dir, oldest first, with the
+// shell commands they ran. Sessions that ran none are left out. statuses maps
+// session hashes to how they ended, see history.Statuses.
+func Runs(st store.Store, statuses map[string]string, dir, user string) ([]Run, error) {
+	sessions := store.Sessions(st)
+	if sessions == nil {
+		return nil, fmt.Errorf("the storage backend does not keep the steps of sessions")
+	}
+	records, err := st.History().List()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	dir = filepath.Clean(dir)
+	var runs []Run
+	for _, rec := range records {
+		if filepath.Clean(rec.CWD) != dir || statuses[rec.Hash] != "completed" {
+			continue
+		}
+		if rec.User != "" && user != "" && rec.User != user {
+			continue
+		}
+		steps, err := sessions.Steps(rec.Hash)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the steps of session %s: %w", rec.Hash, err)
+		}
+		if run := runOf(rec, steps); len(run.Commands) > 0 {
+			runs = append(runs, run)
+		}
+	}
+	return runs, nil
+}
+
+// runOf returns the shell commands of a session's steps that succeeded.
+func runOf(rec history.HistoryRecord, steps []store.Step) Run {
+	run := Run{Session: rec.Hash, TS: rec.TS, Query: rec.Query}
+	for _, s := range steps {
+		if s.Tool == "shell_tool" && s.Status == "success" && strings.TrimSpace(s.Command) != "" {
+			run.Commands = append(run.Commands, strings.TrimSpace(s.Command))
+		}
+	}
+	return run
+}
+
+// key is what runs with the same commands have in common: the commands with
+// their whitespace collapsed.
+func key(commands []string) string {
+	normalized := make([]string, len(commands))
+	for i, c := range commands {
+		normalized[i] = strings.Join(strings.Fields(c), " ")
+	}
+	return strings.Join(normalized, "\n")
+}
+
+// Recurring returns the recipes that at least min of runs ran, those run most
+// often first.
+func Recurring(runs []Run, min int) []Recipe {
+	byKey := map[string]*Recipe{}
+	var keys []string
+	for _, run := range runs {
+		k := key(run.Commands)
+		r, ok := byKey[k]
+		if !ok {
+			r = &Recipe{Commands: run.Commands}
+			byKey[k] = r
+			keys = append(keys, k)
+		}
+		r.Runs = append(r.Runs, run)
+	}
+	var recipes []Recipe
+	for _, k := range keys {
+		if r := byKey[k]; len(r.Runs) >= min {
+			recipes = append(recipes, *r)
+		}
+	}
+	sort.SliceStable(recipes, func(i, j int) bool { return len(recipes[i].Runs) > len(recipes[j].Runs) })
+	return recipes
+}
+
+// Count returns how many of runs ran the same commands as run, run included.
+func Count(runs []Run, run Run) int {
+	k, n := key(run.Commands), 0
+	for _, r := range runs {
+		if key(r.Commands) == k {
+			n++
+		}
+	}
+	return n
+}
+
+// Distilled reports whether og distill already wrote a target for the commands
+// in dir, so that og stops suggesting it.
+func Distilled(mem store.MemoryStore, dir string, commands []string) bool {
+	_, ok, err := mem.Get(scope(dir), key(commands))
+	return ok && err == nil
+}
+
+// MarkDistilled records that a target named name runs the commands in dir.
+func MarkDistilled(mem store.MemoryStore, dir string, commands []string, name string) error {
+	return mem.Put(scope(dir), key(commands), name)
+}
+
+func scope(dir string) string {
+	return "distilled:" + filepath.Clean(dir)
+}
+
+var notNameChar = regexp.MustCompile(`[^a-z0-9]+`)
+
+// Name suggests a target name for a recipe from the request of its latest run:
+// its first few words, e.g. "run-the-tests" for "Run the tests, please".
+func Name(r Recipe) string {
+	query := strings.ToLower(r.Runs[len(r.Runs)-1].Query)
+	words := strings.Fields(notNameChar.ReplaceAllString(query, " "))
+	if len(words) > 4 {
+		words = words[:4]
+	}
+	if len(words) == 0 {
+		return "distilled"
+	}
+	return strings.Join(words, "-")
+}
+
+// ValidName reports whether name can be used as a Makefile target and a
+// Taskfile task alike.
+func ValidName(name string) bool {
+	return validName.MatchString(name)
+}
+
+var validName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// header is the comment above a generated target, saying where it came from.
+func header(r Recipe, prefix string) string {
+	last := r.Runs[len(r.Runs)-1]
+	var b strings.Builder
+	fmt.Fprintf(&b, "%sDistilled by og from %d sessions, the latest %s:\n", prefix, len(r.Runs), last.TS)
+	for _, line := range strings.Split(strings.TrimSpace(last.Query), "\n") {
+		fmt.Fprintf(&b, "%s  %s\n", prefix, line)
+	}
+	return b.String()
+}
+
+// Makefile returns the Makefile existing (empty for a new one) with a target
+// name that runs the recipe's commands appended.
+func Makefile(existing, name string, r Recipe) (string, error) {
+	target := regexp.MustCompile(`(?m)^` + regexp.QuoteMeta(name) + `\s*:([^=]|$)`)
+	if target.MatchString(existing) {
+		return "", fmt.Errorf("the Makefile already has a target %q", name)
+	}
+	var b strings.Builder
+	b.WriteString(existing)
+	if existing != "" {
+		if !strings.HasSuffix(existing, "\n") {
+			b.WriteString("\n")
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString(header(r, "# "))
+	fmt.Fprintf(&b, ".PHONY: %s\n%s:\n", name, name)
+	for _, c := range r.Commands {
+		fmt.Fprintf(&b, "\t%s\n", makeLine(c))
+	}
+	return b.String(), nil
+}
+
+// Lines ending in these go on on the next line without a separator, as do
+// lines ending in the keywords.
+var (
+	continuedOps      = []string{";", "&&", "||", "|", "{", "("}
+	continuedKeywords = []string{"then", "do", "else", "in"}
+)
+
+// makeLine writes a command as one line of a Makefile recipe, as each line runs
+// in its own shell: the lines of a multi-line command are joined with "; "
+// where needed and continued with a backslash, and make's $ is escaped.
+func makeLine(command string) string {
+	lines := strings.Split(strings.ReplaceAll(command, "$", "$$"), "\n")
+	var b strings.Builder
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t")
+		b.WriteString(line)
+		if i == len(lines)-1 {
+			break
+		}
+		sep := "; \\"
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			sep = "\\"
+		case strings.HasSuffix(line, "\\"):
+			sep = "" // Already continued
+		case slices.ContainsFunc(continuedOps, func(op string) bool { return strings.HasSuffix(line, op) }),
+			slices.Contains(continuedKeywords, fields[len(fields)-1]):
+			sep = " \\"
+		}
+		b.WriteString(sep + "\n\t")
+	}
+	return b.String()
+}
+
+// Taskfile returns the Taskfile existing (empty for a new one) with a task
+// name that runs the recipe's commands added at the end of its tasks. The
+// tasks must be the Taskfile's last section, which is how most are written;
+// the new task is indented like the first one.
+func Taskfile(existing, name string, r Recipe) (string, error) {
+	var b strings.Builder
+	indent := "  "
+	if strings.TrimSpace(existing) == "" {
+		b.WriteString("version: '3'\n\ntasks:\n")
+	} else {
+		sections := topLevelKey.FindAllStringIndex(existing, -1)
+		if len(sections) == 0 || existing[sections[len(sections)-1][0]:sections[len(sections)-1][1]] != "tasks:" {
+			return "", fmt.Errorf("the Taskfile's tasks are not its last section; add the task by hand")
+		}
+		tasks := existing[sections[len(sections)-1][1]:]
+		if m := firstIndent.FindStringSubmatch(tasks); m != nil {
+			indent = m[1]
+		}
+		if regexp.MustCompile(`(?m)^` + indent + regexp.QuoteMeta(name) + `:`).MatchString(tasks) {
+			return "", fmt.Errorf("the Taskfile already has a task %q", name)
+		}
+		b.WriteString(strings.TrimRight(existing, "\n") + "\n\n")
+	}
+	in := func(depth int) string { return strings.Repeat(indent, depth) }
+	b.WriteString(header(r, in(1)+"# "))
+	fmt.Fprintf(&b, "%s%s:\n", in(1), name)
+	fmt.Fprintf(&b, "%sdesc: %s\n", in(2), yamlString(oneLine(r.Runs[len(r.Runs)-1].Query)))
+	fmt.Fprintf(&b, "%scmds:\n", in(2))
+	for _, c := range r.Commands {
+		if !strings.Contains(c, "\n") {
+			fmt.Fprintf(&b, "%s- %s\n", in(3), yamlString(c))
+			continue
+		}
+		fmt.Fprintf(&b, "%s- |\n", in(3))
+		for _, line := range strings.Split(c, "\n") {
+			fmt.Fprintf(&b, "%s  %s\n", in(3), line)
+		}
+	}
+	return b.String(), nil
+}
+
+var (
+	topLevelKey = regexp.MustCompile(`(?m)^[A-Za-z_][A-Za-z0-9_]*:`)
+	firstIndent = regexp.MustCompile(`(?m)^([ \t]+)[^\s#]`)
+)
+
+// yamlString quotes s as a YAML scalar.
+func yamlString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,156 @@
+// Package editor opens files a session generated in the user's editor and tells
+// whether the user changed them there.
+package editor
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Location is a file to open, at a line when known (0 otherwise).
+type Location struct {
+	Path string
+	Line int
+}
+
+// Editor is a command that edits files and returns once the user is done.
+type Editor struct {
+	Name    string   // Shown in prompts, e.g. "vim" or "VS Code"
+	Command []string // Program and leading arguments
+	vscode  bool
+}
+
+// lineFlagEditors accept "+<line>" before a file to open it at that line.
+var lineFlagEditors = map[string]bool{"vi": true, "vim": true, "nvim": true, "nano": true, "emacs": true, "micro": true, "kak": true, "ne": true}
+
+// Available returns the editors that can be offered: command (or $VISUAL, then
+// $EDITOR, when empty) and VS Code when its `code` launcher is installed.
+func Available(command string) []Editor {
+	var editors []Editor
+	for _, c := range []string{command, os.Getenv("VISUAL"), os.Getenv("EDITOR")} {
+		if c == "" {
+			continue
+		}
+		args := strings.Fields(c)
+		editors = append(editors, Editor{Name: filepath.Base(args[0]), Command: args, vscode: isVSCode(args[0])})
+		break
+	}
+	if len(editors) == 0 || !editors[0].vscode {
+		if _, err := exec.LookPath("code"); err == nil {
+			editors = append(editors, Editor{Name: "VS Code", Command: []string{"code"}, vscode: true})
+		}
+	}
+	return editors
+}
+
+func isVSCode(program string) bool {
+	name := filepath.Base(program)
+	return name == "code" || name == "code-insiders" || name == "codium"
+}
+
+// Open edits files and waits for the editor to exit. VS Code is run with --wait
+// and --goto so each file opens at its line; terminal editors that support it open
+// the first file at its line.
+func (e Editor) Open(files []Location) error {
+	args := append([]string{}, e.Command[1:]...)
+	if e.vscode {
+		if !containsArg(args, "--wait", "-w") {
+			args = append(args, "--wait")
+		}
+		args = append(args, "--goto")
+		for _, f := range files {
+			line := max(f.Line, 1)
+			args = append(args, f.Path+":"+strconv.Itoa(line))
+		}
+	} else {
+		for i, f := range files {
+			if i == 0 && f.Line > 0 && lineFlagEditors[filepath.Base(e.Command[0])] {
+				args = append(args, "+"+strconv.Itoa(f.Line))
+			}
+			args = append(args, f.Path)
+		}
+	}
+	cmd := exec.Command(e.Command[0], args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s failed: %w", e.Name, err)
+	}
+	return nil
+}
+
+func containsArg(args []string, names ...string) bool {
+	for _, a := range args {
+		for _, n := range names {
+			if a == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Fingerprint identifies a file's content, so edits can be detected by comparing
+// fingerprints taken before and after opening it. Missing files have none.
+func Fingerprint(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+// redirectTarget and teeCommand find the files a shell command writes with
+// output redirections (> and >>) or tee.
+var (
+	redirectTarget = regexp.MustCompile(`(?:^|[^0-9&<>])[12]?>>?\s*([^\s;&|<>()]+)`)
+	teeCommand     = regexp.MustCompile(`(?:^|[|;&]\s*)tee((?:\s+[^\s;&|<>()]+)+)`)
+)
+
+// WrittenFiles returns the regular files inside workdir that a shell command wrote
+// through output redirections or tee, in the order they appear.
+func WrittenFiles(command, workdir string) []string {
+	var candidates []string
+	for _, m := range redirectTarget.FindAllStringSubmatch(command, -1) {
+		candidates = append(candidates, m[1])
+	}
+	for _, m := range teeCommand.FindAllStringSubmatch(command, -1) {
+		for _, arg := range strings.Fields(m[1]) {
+			if !strings.HasPrefix(arg, "-") {
+				candidates = append(candidates, arg)
+			}
+		}
+	}
+
+	root, err := filepath.Abs(workdir)
+	if err != nil {
+		return nil
+	}
+	var files []string
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		c = strings.Trim(c, `"'`)
+		if c == "" || strings.HasPrefix(c, "/dev/") || strings.ContainsAny(c, "$`*?") {
+			continue
+		}
+		path := c
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(root, path)
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue // Only files the session generated in its working directory
+		}
+		if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() || seen[rel] {
+			continue
+		}
+		seen[rel] = true
+		files = append(files, rel)
+	}
+	return files
+}
@@ -0,0 +1,28 @@
+//go:build !windows
+
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+)
+
+// ShellCommand runs command on the host with sh, see Command.
+func ShellCommand(ctx context.Context, command string) *exec.Cmd {
+	return Command(ctx, "/bin/sh", "-c", command)
+}
+
+// Command runs a program on the host in its own process group, so that when
+// ctx is done the processes it started are killed along with it.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	return cmd
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package executor
+
+import (
+	"context"
+	"os/exec"
+	"strconv"
+)
+
+// ShellCommand runs command on the host with cmd, see Command.
+func ShellCommand(ctx context.Context, command string) *exec.Cmd {
+	return Command(ctx, "cmd", "/C", command)
+}
+
+// Command runs a program on the host; when ctx is done the processes it
+// started are killed along with it, which Windows does not do for child
+// processes.
+func Command(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Cancel = func() error {
+		if err := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid)).Run(); err != nil {
+			return cmd.Process.Kill()
+		}
+		return nil
+	}
+	return cmd
+}
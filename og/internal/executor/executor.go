@@ -0,0 +1,481 @@
+// Package executor runs the shell commands of recipe steps on og's side when
+// general.executor is "go": the agent plans and asks, og runs the command and
+// returns its output. What runs, for how long and what the agent gets to see of
+// its output is then decided here rather than inside the Python agent.
+package executor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxOutputBytes is how much of each of a command's stdout and stderr
+// is kept when Executor.MaxOutputBytes is 0.
+const DefaultMaxOutputBytes = 8 << 20 // 8MB
+
+// maxPreviewBytes bounds the head and the tail kept of output that was
+// spilled to a file, see Executor.SpillDir.
+const maxPreviewBytes = 4 << 10 // 4KB
+
+// Executor runs shell commands in a directory.
+type Executor struct {
+	Dir            string              // Working directory of the commands
+	Timeout        time.Duration       // Longest a command may run; 0 means no limit
+	MaxOutputBytes int                 // Kept of each output stream; see DefaultMaxOutputBytes
+	Redact         func(string) string // Masks secrets in the output; may be nil
+	Sandbox        Sandbox             // Where commands run; on the host when nil
+	Env            []string            // "KEY=value" entries added to the environment of every command
+	Wrap           Wrapper             // Wraps each command, e.g. to limit its resources; may be nil
+
+	// SpillDir, when set, is where an output stream longer than SpillAboveBytes
+	// is written as the command runs, rather than kept in memory: the Result
+	// then has its head and tail, and the path of the file. The files are
+	// named SpillName (or "output") with .stdout.txt or .stderr.txt added, and
+	// are not masked with Redact.
+	SpillDir        string
+	SpillAboveBytes int
+	SpillName       string
+
+	// OnTimeout, when set, is called when a command runs past Timeout, with how
+	// long it has run. It returns how much longer the command may run; 0 kills
+	// it. Run waits for it to return, even if the command ends meanwhile.
+	OnTimeout func(ran time.Duration) time.Duration
+
+	// OnProgress, when set, is called while a command runs longer than
+	// progressAfter: when the last percentage it printed changes, and every
+	// progressEvery otherwise. It is not called while OnTimeout runs.
+	OnProgress func(Progress)
+
+	// OnLine, when set, is called with each line the command writes to stdout
+	// or stderr, as it writes it, without its line ending; of a line a
+	// progress bar rewrote with carriage returns, only the end is passed. It
+	// may be called from two goroutines at once.
+	OnLine func(line string)
+}
+
+// Progress is how far a running command got.
+type Progress struct {
+	Percent float64       // The last percentage the command printed, such as curl's or pip's, or -1
+	Ran     time.Duration // How long it has run
+	Output  int64         // Bytes of stdout and stderr it wrote
+}
+
+const (
+	progressAfter = 2 * time.Second // Commands that end sooner report no progress
+	progressEvery = 5 * time.Second // Between reports without a new percentage
+)
+
+// A Sandbox runs commands isolated from the host, e.g. in a container.
+type Sandbox interface {
+	// Command returns the command that runs command with sh in dir, with env
+	// added to its environment. Cancelling ctx ends it and the processes it
+	// started.
+	Command(ctx context.Context, dir, command string, env []string) *exec.Cmd
+}
+
+// A Wrapper returns the program and arguments (argv[0] included) that run the
+// program path with args, e.g. under resource limits.
+type Wrapper func(path string, args []string) (string, []string, error)
+
+// New returns an Executor for commands run in dir, each for at most timeout,
+// whose output is masked with redact.
+func New(dir string, timeout time.Duration, redact func(string) string) *Executor {
+	return &Executor{Dir: dir, Timeout: timeout, Redact: redact}
+}
+
+// Result is how a command ran.
+type Result struct {
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	TimedOut bool // The command was killed for running longer than the timeout
+	Duration time.Duration
+
+	// Binary is stdout when it is not text, such as an image; Stdout then only
+	// says so.
+	Binary *Binary
+}
+
+// Binary is an output stream that is not text.
+type Binary struct {
+	Data []byte // The output, or its head when File has it
+	File string // Where the whole output is, when it was spilled, see Executor.SpillDir
+	Size int64  // Length of the whole output
+}
+
+// Run runs command with the system shell (sh -c, or cmd /C on Windows), or in
+// the sandbox, and waits for it. A command that exits with a non-zero status,
+// or is killed for its timeout, is not an error; one that cannot be started
+// is. When ctx is done, or the timeout passes and OnTimeout does not extend
+// it, the command and the processes it started are killed.
+func (e *Executor) Run(ctx context.Context, command string) (Result, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	limit := e.MaxOutputBytes
+	if limit <= 0 {
+		limit = DefaultMaxOutputBytes
+	}
+	var stdout, stderr output = &capped{max: limit}, &capped{max: limit}
+	if e.SpillDir != "" && e.SpillAboveBytes > 0 {
+		name := e.SpillName
+		if name == "" {
+			name = "output"
+		}
+		stdout = e.spill(filepath.Join(e.SpillDir, name+".stdout.txt"))
+		stderr = e.spill(filepath.Join(e.SpillDir, name+".stderr.txt"))
+	}
+
+	var cmd *exec.Cmd
+	if e.Sandbox != nil {
+		cmd = e.Sandbox.Command(ctx, e.Dir, command, e.Env)
+	} else {
+		cmd = ShellCommand(ctx, command)
+		cmd.Dir = e.Dir
+		if len(e.Env) > 0 {
+			cmd.Env = append(os.Environ(), e.Env...)
+		}
+	}
+	if e.Wrap != nil {
+		path, args, err := e.Wrap(cmd.Path, cmd.Args)
+		if err != nil {
+			return Result{ExitCode: -1}, fmt.Errorf("failed to wrap the command: %w", err)
+		}
+		cmd.Path, cmd.Args = path, args
+	}
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	progress := &meter{percent: -1}
+	if e.OnProgress != nil {
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(cmd.Stdout, progress), io.MultiWriter(cmd.Stderr, progress)
+	}
+	if e.OnLine != nil {
+		outLines, errLines := &lines{emit: e.OnLine}, &lines{emit: e.OnLine}
+		defer outLines.flush()
+		defer errLines.flush()
+		cmd.Stdout, cmd.Stderr = io.MultiWriter(cmd.Stdout, outLines), io.MultiWriter(cmd.Stderr, errLines)
+	}
+	// Processes left holding the output pipes do not keep the step waiting
+	cmd.WaitDelay = 2 * time.Second
+
+	start := time.Now()
+	var timedOut atomic.Bool
+	done, watched := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(watched)
+		e.watch(start, done, progress, func() {
+			timedOut.Store(true)
+			cancel()
+		})
+	}()
+	err := cmd.Run()
+	stdout.Close()
+	stderr.Close()
+	res := Result{Duration: time.Since(start), ExitCode: -1}
+	killed := ctx.Err() != nil && timedOut.Load()
+	close(done)
+	<-watched
+	if cmd.ProcessState != nil {
+		res.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	var exitErr *exec.ExitError
+	switch {
+	case killed && cmd.ProcessState != nil:
+		res.TimedOut = true
+	case err != nil && !errors.As(err, &exitErr) && !errors.Is(err, exec.ErrWaitDelay):
+		return res, fmt.Errorf("failed to run the command: %w", err)
+	}
+	res.Stdout, res.Stderr = e.redact(stdout.String()), e.redact(stderr.String())
+	if head := stdout.Bytes(); isBinary(head) {
+		res.Binary = &Binary{Data: head, File: stdout.File(), Size: stdout.Size()}
+		res.Stdout = fmt.Sprintf("[binary output of %d bytes, not shown]", res.Binary.Size)
+		if res.Binary.File != "" {
+			res.Stdout = fmt.Sprintf("[binary output of %d bytes, not shown; it is in %s]", res.Binary.Size, res.Binary.File)
+		}
+	}
+	return res, nil
+}
+
+// watch calls kill when the command started at start runs past the timeout and
+// OnTimeout does not extend it, and reports its progress, until done is closed.
+func (e *Executor) watch(start time.Time, done <-chan struct{}, progress *meter, kill func()) {
+	var timeout, tick <-chan time.Time
+	var timer *time.Timer
+	if e.Timeout > 0 {
+		timer = time.NewTimer(e.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	if e.OnProgress != nil {
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	reported, reportedAt := -1.0, start
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-tick:
+			p := progress.at(now.Sub(start))
+			if p.Ran >= progressAfter && (p.Percent != reported || now.Sub(reportedAt) >= progressEvery) {
+				e.OnProgress(p)
+				reported, reportedAt = p.Percent, now
+			}
+			continue
+		case <-timeout:
+		}
+		var more time.Duration
+		if e.OnTimeout != nil {
+			more = e.OnTimeout(time.Since(start))
+		}
+		if more <= 0 {
+			kill()
+			return
+		}
+		timer.Reset(more)
+	}
+}
+
+// percentage matches a percentage such as progress bars print, e.g. " 45%" or
+// "12.5%".
+var percentage = regexp.MustCompile(`(?:^|[^\d.])(\d{1,3}(?:\.\d+)?)%`)
+
+// meter counts the output of a command and keeps the last percentage in it.
+type meter struct {
+	mu      sync.Mutex
+	written int64
+	percent float64
+	rest    []byte // The end of the last write, where a percentage may begin
+}
+
+func (m *meter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.written += int64(len(p))
+	chunk := append(m.rest, p...)
+	if all := percentage.FindAllSubmatch(chunk, -1); len(all) > 0 {
+		if v, err := strconv.ParseFloat(string(all[len(all)-1][1]), 64); err == nil && v <= 100 {
+			m.percent = v
+		}
+	}
+	m.rest = append([]byte(nil), chunk[max(len(chunk)-8, 0):]...)
+	return len(p), nil
+}
+
+// maxLineBytes is the longest line lines holds back; longer ones are passed
+// on in pieces.
+const maxLineBytes = 4 << 10
+
+// lines passes on the lines written to it, see Executor.OnLine.
+type lines struct {
+	emit    func(string)
+	partial []byte
+}
+
+func (l *lines) Write(p []byte) (int, error) {
+	l.partial = append(l.partial, p...)
+	for {
+		i := bytes.IndexByte(l.partial, '\n')
+		if i < 0 {
+			break
+		}
+		l.pass(l.partial[:i])
+		l.partial = l.partial[i+1:]
+	}
+	if len(l.partial) > maxLineBytes {
+		l.flush()
+	}
+	return len(p), nil
+}
+
+// flush passes on what was written after the last line ending.
+func (l *lines) flush() {
+	if len(l.partial) > 0 {
+		l.pass(l.partial)
+	}
+	l.partial = nil
+}
+
+func (l *lines) pass(line []byte) {
+	line = bytes.TrimSuffix(line, []byte("\r"))
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	l.emit(string(bytes.ToValidUTF8(line, []byte("?"))))
+}
+
+// at returns the progress of the command after it ran for ran.
+func (m *meter) at(ran time.Duration) Progress {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return Progress{Percent: m.percent, Ran: ran, Output: m.written}
+}
+
+func (e *Executor) redact(s string) string {
+	if e.Redact == nil {
+		return s
+	}
+	return e.Redact(s)
+}
+
+// output is where Run writes a stream of the command's output.
+type output interface {
+	Write(p []byte) (int, error)
+	String() string // What the Result has of the stream
+	Bytes() []byte  // What was kept of the stream, unchanged
+	Size() int64    // Length of the whole stream
+	File() string   // Where the whole stream is, when it was spilled
+	Close() error
+}
+
+// binarySniffBytes is how much of an output isBinary looks at.
+const binarySniffBytes = 8 << 10
+
+// isBinary reports whether output, or its head, is not text: it has NUL bytes
+// or is not UTF-8.
+func isBinary(output []byte) bool {
+	head := output[:min(len(output), binarySniffBytes)]
+	if bytes.IndexByte(head, 0) >= 0 {
+		return true
+	}
+	// A character cut at the end of the head does not make it binary
+	for i := 0; i < utf8.UTFMax && len(head) > 0 && !utf8.Valid(head); i++ {
+		head = head[:len(head)-1]
+	}
+	return !utf8.Valid(head)
+}
+
+// capped keeps the first max bytes written to it and counts the rest.
+type capped struct {
+	buf     bytes.Buffer
+	max     int
+	dropped int
+}
+
+func (c *capped) Write(p []byte) (int, error) {
+	if room := c.max - c.buf.Len(); room < len(p) {
+		c.dropped += len(p) - max(room, 0)
+		c.buf.Write(p[:max(room, 0)])
+		return len(p), nil
+	}
+	return c.buf.Write(p)
+}
+
+func (c *capped) String() string {
+	if c.dropped == 0 {
+		return c.buf.String()
+	}
+	return fmt.Sprintf("%s\n[... %d more bytes not kept]", bytes.ToValidUTF8(c.buf.Bytes(), nil), c.dropped)
+}
+
+func (c *capped) Bytes() []byte { return c.buf.Bytes() }
+func (c *capped) Size() int64   { return int64(c.buf.Len() + c.dropped) }
+func (c *capped) File() string  { return "" }
+func (c *capped) Close() error  { return nil }
+
+// spill returns the output of a stream spilled to the file at path once it
+// is longer than e.SpillAboveBytes.
+func (e *Executor) spill(path string) *spilled {
+	return &spilled{path: path, max: e.SpillAboveBytes, preview: min(maxPreviewBytes, max(e.SpillAboveBytes/4, 1))}
+}
+
+// spilled keeps what is written to it in memory until it is longer than max;
+// it then writes all of it to the file at path, and keeps only its first and
+// last preview bytes.
+type spilled struct {
+	path       string
+	max        int
+	preview    int
+	buf        bytes.Buffer // All that was written, until the file was created
+	head, tail []byte
+	size       int64
+	file       *os.File
+	err        error // Why the file could not be written
+}
+
+func (s *spilled) Write(p []byte) (int, error) {
+	s.size += int64(len(p))
+	if s.head == nil {
+		if s.buf.Len()+len(p) <= s.max {
+			return s.buf.Write(p)
+		}
+		s.buf.Write(p)
+		all := s.buf.Bytes()
+		s.head = bytes.Clone(all[:s.preview])
+		s.keepTail(all)
+		if s.err = os.MkdirAll(filepath.Dir(s.path), 0o700); s.err == nil {
+			s.file, s.err = os.OpenFile(s.path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		}
+		if s.err == nil {
+			_, s.err = s.file.Write(all)
+		}
+		s.buf = bytes.Buffer{}
+		return len(p), nil
+	}
+	s.keepTail(p)
+	if s.err == nil && s.file != nil {
+		_, s.err = s.file.Write(p)
+	}
+	return len(p), nil
+}
+
+// keepTail keeps the last s.preview bytes of what was written, p included.
+func (s *spilled) keepTail(p []byte) {
+	if len(p) >= s.preview {
+		s.tail = bytes.Clone(p[len(p)-s.preview:])
+		return
+	}
+	s.tail = append(s.tail, p...)
+	s.tail = s.tail[max(len(s.tail)-s.preview, 0):]
+}
+
+func (s *spilled) String() string {
+	if s.head == nil {
+		return s.buf.String()
+	}
+	omitted := s.size - int64(len(s.head)) - int64(len(s.tail))
+	note := fmt.Sprintf("-- %d bytes omitted; the whole output (%d bytes) is in %s --", omitted, s.size, s.path)
+	if s.err != nil {
+		note = fmt.Sprintf("-- %d bytes omitted, which could not be saved: %v --", omitted, s.err)
+	}
+	return fmt.Sprintf("%s\n%s\n%s", bytes.ToValidUTF8(s.head, nil), note, bytes.ToValidUTF8(s.tail, nil))
+}
+
+func (s *spilled) Bytes() []byte {
+	if s.head == nil {
+		return s.buf.Bytes()
+	}
+	return s.head
+}
+
+func (s *spilled) Size() int64 { return s.size }
+
+func (s *spilled) File() string {
+	if s.head == nil || s.err != nil {
+		return ""
+	}
+	return s.path
+}
+
+func (s *spilled) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	if s.err == nil {
+		s.err = err
+	}
+	return err
+}
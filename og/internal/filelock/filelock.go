@@ -0,0 +1,85 @@
+// Package filelock serializes og processes that share a data directory, with
+// advisory locks on files next to the data they protect.
+package filelock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+var ErrLocked = errors.New("locked by another process")
+
+// Lock is an exclusive lock on a file, held until Unlock or until the process
+// exits, which the operating system releases it on.
+type Lock struct {
+	f *os.File
+}
+
+// Acquire waits until it holds the lock on the file at path, creating the file.
+func Acquire(path string) (*Lock, error) {
+	return acquire(path, true)
+}
+
+// TryLock takes the lock on the file at path if no other process holds it, and
+// returns ErrLocked otherwise.
+func TryLock(path string) (*Lock, error) {
+	return acquire(path, false)
+}
+
+func acquire(path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open lock file %s: %w", path, err)
+	}
+	if err := lockFile(f, wait); err != nil {
+		f.Close()
+		if errors.Is(err, ErrLocked) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+	}
+	return &Lock{f: f}, nil
+}
+
+// SetOwner records who holds the lock in the lock file, for the processes
+// waiting for it to read with Owner.
+func (l *Lock) SetOwner(owner []byte) error {
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err := l.f.WriteAt(owner, 0)
+	return err
+}
+
+// Unlock releases the lock. The lock file is left in place: removing it would
+// let a process that opened it before lock a file no one else sees.
+func (l *Lock) Unlock() error {
+	l.f.Truncate(0)
+	unlockFile(l.f)
+	return l.f.Close()
+}
+
+// Owner returns what the holder of the lock on the file at path recorded with
+// SetOwner, or nil.
+func Owner(path string) []byte {
+	b, _ := os.ReadFile(path)
+	return b
+}
+
+// With runs fn holding the lock of the file at path, which is path with
+// ".lock" appended. It keeps the read-modify-write cycles of concurrent og
+// processes on the file from interleaving.
+func With(path string, fn func() error) error {
+	l, err := Acquire(path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer l.Unlock()
+	return fn()
+}
@@ -0,0 +1,148 @@
+// Package gitcheckpoint records the state of the git repository a session
+// runs in, before its first step that writes, so that what the session
+// changed can be recovered with git: as a stash entry, or as a commit on the
+// branch og/checkpoint-<hash>. Neither touches the working tree, the index
+// or the branch checked out.
+package gitcheckpoint
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Modes of undo.git_checkpoint.
+const (
+	ModeOff    = "off"
+	ModeStash  = "stash"  // A stash entry of the changes to tracked files, or HEAD when there are none
+	ModeCommit = "commit" // A commit of the whole working tree, untracked files included, on og/checkpoint-<hash>
+)
+
+// Modes lists the valid modes.
+var Modes = []string{ModeOff, ModeStash, ModeCommit}
+
+// Checkpoint is a state of a repository og recorded.
+type Checkpoint struct {
+	Mode    string
+	Ref     string // What to give git to recover the state: the branch, or the commit of the stash entry
+	Commit  string // The commit holding the state
+	Clean   bool   // ModeStash: there were no changes to stash, so Ref is HEAD
+	Earlier bool   // ModeCommit: the branch was made by an earlier run of the session, and kept
+}
+
+// Branch returns the branch ModeCommit records the state on for session hash.
+func Branch(hash string) string {
+	return "og/checkpoint-" + hash
+}
+
+// Describe tells how c was recorded and how to recover files from it.
+func (c *Checkpoint) Describe() string {
+	short := c.Commit[:min(len(c.Commit), 7)]
+	switch {
+	case c.Mode == ModeCommit:
+		return fmt.Sprintf("branch %s (%s); `git restore --source=%s --worktree -- .` brings back its files", c.Ref, short, c.Ref)
+	case c.Clean:
+		return fmt.Sprintf("HEAD (%s), as tracked files had no changes to stash; `git restore --source=%s --worktree -- .` brings back its files", short, short)
+	}
+	return fmt.Sprintf("stash entry %s; `git stash apply %s` brings back the changes it held", short, short)
+}
+
+// Create records the state of the repository containing dir, per mode, for
+// the session hash. It returns nil when dir is not in a git repository.
+func Create(dir, hash, mode string) (*Checkpoint, error) {
+	if mode == "" || mode == ModeOff {
+		return nil, nil
+	}
+	top, err := git(dir, nil, "rev-parse", "--show-toplevel")
+	if err != nil {
+		return nil, nil // Not a repository
+	}
+	head, err := git(top, nil, "rev-parse", "--verify", "--quiet", "HEAD")
+	if mode == ModeStash {
+		if err != nil {
+			return nil, fmt.Errorf("cannot stash in a repository without commits")
+		}
+		message := "og checkpoint " + hash
+		stash, err := git(top, nil, "stash", "create", message)
+		if err != nil {
+			return nil, err
+		}
+		if stash == "" {
+			return &Checkpoint{Mode: mode, Ref: head, Commit: head, Clean: true}, nil
+		}
+		if _, err := git(top, nil, "stash", "store", "-m", message, stash); err != nil {
+			return nil, err
+		}
+		return &Checkpoint{Mode: mode, Ref: stash, Commit: stash}, nil
+	}
+
+	branch := Branch(hash)
+	if commit, err := git(top, nil, "rev-parse", "--verify", "--quiet", "refs/heads/"+branch); err == nil {
+		return &Checkpoint{Mode: mode, Ref: branch, Commit: commit, Earlier: true}, nil
+	}
+	commit, err := snapshot(top, head, "og checkpoint "+hash)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := git(top, nil, "update-ref", "-m", "og checkpoint", "refs/heads/"+branch, commit, ""); err != nil {
+		return nil, err
+	}
+	return &Checkpoint{Mode: mode, Ref: branch, Commit: commit}, nil
+}
+
+// snapshot commits the working tree of the repository top, untracked but
+// not ignored files included, on top of head, if any. It stages the files in
+// a copy of the index, so the user's is left as it is.
+func snapshot(top, head, message string) (string, error) {
+	tmp, err := os.CreateTemp("", "og-checkpoint-index-")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+	if index, err := git(top, nil, "rev-parse", "--git-path", "index"); err == nil {
+		if !filepath.IsAbs(index) {
+			index = filepath.Join(top, index)
+		}
+		if b, err := os.ReadFile(index); err == nil {
+			// A copy keeps the index's file stats, so only changed files are read
+			if err := os.WriteFile(tmp.Name(), b, 0o600); err != nil {
+				return "", err
+			}
+		}
+	}
+	env := []string{"GIT_INDEX_FILE=" + tmp.Name()}
+	if _, err := git(top, env, "add", "--all", "--", "."); err != nil {
+		return "", err
+	}
+	tree, err := git(top, env, "write-tree")
+	if err != nil {
+		return "", err
+	}
+	args := []string{"commit-tree", tree, "-m", message}
+	if head != "" {
+		args = append(args, "-p", head)
+	}
+	if _, err := git(top, nil, "var", "GIT_COMMITTER_IDENT"); err != nil {
+		// No identity configured; the commit is og's
+		env = append(env, "GIT_AUTHOR_NAME=og", "GIT_AUTHOR_EMAIL=og@localhost", "GIT_COMMITTER_NAME=og", "GIT_COMMITTER_EMAIL=og@localhost")
+	}
+	return git(top, env, args...)
+}
+
+// git runs a git command in dir, with env added to og's environment, and
+// returns its trimmed stdout.
+func git(dir string, env []string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
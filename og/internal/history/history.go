@@ -1,22 +1,29 @@
 package history
 
 import (
+	"bufio"
+	"bytes"
 	"crypto/sha256"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
 
 	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/filelock"
 )
 
 // HistoryRecord defines the structure for a single history entry.
 type HistoryRecord struct {
-	TS    string `json:"ts"`
-	Hash  string `json:"hash"`
-	CWD   string `json:"cwd"`
-	Query string `json:"query"`
+	TS     string `json:"ts"`
+	Hash   string `json:"hash"`
+	CWD    string `json:"cwd"`
+	Query  string `json:"query"`
+	User   string `json:"user,omitempty"`   // Who ran the session (relevant for shared stores)
+	Tag    string `json:"tag,omitempty"`    // What kind of query it was, see the classify package
+	Parent string `json:"parent,omitempty"` // The session this one continues, for stages of `og ... --then ...`
 }
 
 // GetHistoryPath returns the full path to the history file.
@@ -28,34 +35,151 @@ func GetHistoryPath() (string, error) {
 	return filepath.Join(dir, "history.json"), nil
 }
 
-// AppendRecord appends a new history record to the history file.
-func AppendRecord(rec HistoryRecord) error {
+// AppendRecord appends a new history record to the history file and returns
+// the byte offset at which it was written.
+func AppendRecord(rec HistoryRecord) (int64, error) {
 	path, err := GetHistoryPath()
 	if err != nil {
-		return fmt.Errorf("failed to get history path: %w", err)
+		return 0, fmt.Errorf("failed to get history path: %w", err)
 	}
+	return AppendRecordTo(path, rec)
+}
+
+// AppendRecordTo appends a history record to the history file at path and returns
+// the byte offset at which it was written. The record and its newline go out in a
+// single write, under the file's lock, so records of concurrent sessions never
+// interleave and each offset is where its record starts.
+func AppendRecordTo(path string, rec HistoryRecord) (int64, error) {
 	dir := filepath.Dir(path)
 	if err := os.MkdirAll(dir, 0o755); err != nil { // Ensure directory exists
-		return fmt.Errorf("failed to create history directory %s: %w", dir, err)
+		return 0, fmt.Errorf("failed to create history directory %s: %w", dir, err)
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return 0, fmt.Errorf("failed to marshal history record: %w", err)
+	}
+	b = append(b, '\n')
+
+	var offset int64
+	err = filelock.With(path, func() error {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to open history file %s: %w", path, err)
+		}
+		defer f.Close()
+
+		if offset, err = f.Seek(0, io.SeekEnd); err != nil {
+			return fmt.Errorf("failed to determine history file size: %w", err)
+		}
+		if _, err := f.Write(b); err != nil {
+			return fmt.Errorf("failed to write history record to file: %w", err)
+		}
+		return nil
+	})
+	return offset, err
+}
+
+// RemoveRecords rewrites the history file at path without the records of the given
+// sessions, and returns the new byte offset of each remaining record by hash.
+// Malformed lines are kept as they are.
+func RemoveRecords(path string, hashes map[string]bool) (map[string]int64, error) {
+	var offsets map[string]int64
+	err := filelock.With(path, func() error {
+		var err error
+		offsets, err = removeRecords(path, hashes)
+		return err
+	})
+	return offsets, err
+}
+
+func removeRecords(path string, hashes map[string]bool) (map[string]int64, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return map[string]int64{}, nil
+		}
+		return nil, fmt.Errorf("failed to read history file %s: %w", path, err)
 	}
+	offsets := map[string]int64{}
+	var kept []byte
+	for _, line := range bytes.SplitAfter(data, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var rec HistoryRecord
+		if json.Unmarshal(line, &rec) == nil {
+			if hashes[rec.Hash] {
+				continue
+			}
+			offsets[rec.Hash] = int64(len(kept))
+		}
+		kept = append(kept, line...)
+		if kept[len(kept)-1] != '\n' {
+			kept = append(kept, '\n')
+		}
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0o644); err != nil {
+		return nil, fmt.Errorf("failed to write history file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("failed to replace history file %s: %w", path, err)
+	}
+	return offsets, nil
+}
 
-	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+// ReadRecordAt reads the history record starting at the given byte offset.
+func ReadRecordAt(offset int64) (HistoryRecord, error) {
+	var rec HistoryRecord
+	path, err := GetHistoryPath()
+	if err != nil {
+		return rec, fmt.Errorf("failed to get history path: %w", err)
+	}
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to open history file %s: %w", path, err)
+		return rec, fmt.Errorf("failed to open history file %s: %w", path, err)
 	}
 	defer f.Close()
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return rec, fmt.Errorf("failed to seek history file: %w", err)
+	}
+	line, err := bufio.NewReader(f).ReadBytes('\n')
+	if err != nil && err != io.EOF {
+		return rec, fmt.Errorf("failed to read history record: %w", err)
+	}
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return rec, fmt.Errorf("failed to parse history record at offset %d: %w", offset, err)
+	}
+	return rec, nil
+}
 
-	b, err := json.Marshal(rec)
+// ReadRecords reads every record from the history file at path, skipping malformed
+// lines. A missing file yields no records.
+func ReadRecords(path string) ([]HistoryRecord, error) {
+	f, err := os.Open(path)
 	if err != nil {
-		return fmt.Errorf("failed to marshal history record: %w", err)
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to open history file %s: %w", path, err)
 	}
-	if _, err := f.Write(b); err != nil {
-		return fmt.Errorf("failed to write history record to file: %w", err)
+	defer f.Close()
+
+	var records []HistoryRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var rec HistoryRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue // Skip malformed lines rather than failing the whole listing
+		}
+		records = append(records, rec)
 	}
-	if _, err := f.Write([]byte("\n")); err != nil {
-		return fmt.Errorf("failed to write newline to history file: %w", err)
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history file %s: %w", path, err)
 	}
-	return nil
+	return records, nil
 }
 
 // GenerateSessionHash creates a short unique hash for a session based on query and timestamp.
@@ -0,0 +1,194 @@
+package history
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/filelock"
+)
+
+// IndexEntry records where everything belonging to a session lives, so commands
+// that operate on past sessions don't need to know each module's file naming.
+type IndexEntry struct {
+	Hash           string `json:"hash"`
+	HistoryOffset  int64  `json:"history_offset"`            // Byte offset of the record in history.json
+	TranscriptPath string `json:"transcript_path,omitempty"` // Session JSON written by the agent
+	AgentLogPath   string `json:"agent_log_path,omitempty"`  // Agent log (only when JSON logs are enabled)
+	LogPath        string `json:"log_path,omitempty"`        // og's own log of the session (only when log.file is enabled)
+	ArtifactsDir   string `json:"artifacts_dir,omitempty"`   // Per-session temp/artifact directory
+	Status         string `json:"status,omitempty"`          // How the session ended, e.g. "completed" or "denied"
+	Usage          *Usage `json:"usage,omitempty"`           // Tokens the session's models consumed
+	DurationMs     int64  `json:"duration_ms,omitempty"`     // How long the session ran, from start until the agent stopped
+}
+
+// Usage is the total of the tokens a session's models consumed and what they cost.
+type Usage struct {
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost,omitempty"` // In the currency of [pricing]; 0 when no model is priced
+}
+
+// GetIndexPath returns the full path to the session index file.
+func GetIndexPath() (string, error) {
+	dir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "index.json"), nil
+}
+
+// LoadIndex reads the session index. A missing index is treated as empty.
+func LoadIndex() (map[string]IndexEntry, error) {
+	path, err := GetIndexPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get index path: %w", err)
+	}
+	idx := map[string]IndexEntry{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return idx, nil
+		}
+		return nil, fmt.Errorf("failed to read index file %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &idx); err != nil {
+		return nil, fmt.Errorf("failed to parse index file %s: %w", path, err)
+	}
+	return idx, nil
+}
+
+// saveIndex writes the index atomically (temp file + rename).
+func saveIndex(idx map[string]IndexEntry) error {
+	path, err := GetIndexPath()
+	if err != nil {
+		return fmt.Errorf("failed to get index path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create index directory: %w", err)
+	}
+	b, err := json.MarshalIndent(idx, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal index: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write index file %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace index file %s: %w", path, err)
+	}
+	return nil
+}
+
+// updateIndex applies change to the index and saves it, holding the index's
+// lock so concurrent og processes don't lose each other's updates. The index
+// is only saved when change reports that it changed it.
+func updateIndex(change func(idx map[string]IndexEntry) bool) error {
+	path, err := GetIndexPath()
+	if err != nil {
+		return fmt.Errorf("failed to get index path: %w", err)
+	}
+	return filelock.With(path, func() error {
+		idx, err := LoadIndex()
+		if err != nil {
+			return err
+		}
+		if !change(idx) {
+			return nil
+		}
+		return saveIndex(idx)
+	})
+}
+
+// updateIndexEntry applies change to the entry of a session. Sessions missing
+// from the index are ignored.
+func updateIndexEntry(hash string, change func(e *IndexEntry)) error {
+	return updateIndex(func(idx map[string]IndexEntry) bool {
+		e, ok := idx[hash]
+		if !ok {
+			return false
+		}
+		change(&e)
+		idx[hash] = e
+		return true
+	})
+}
+
+// PutIndexEntry adds or replaces the entry for a session.
+func PutIndexEntry(e IndexEntry) error {
+	return updateIndex(func(idx map[string]IndexEntry) bool {
+		idx[e.Hash] = e
+		return true
+	})
+}
+
+// SetIndexStatus records how a session ended. Sessions missing from the index are ignored.
+func SetIndexStatus(hash, status string) error {
+	return updateIndexEntry(hash, func(e *IndexEntry) { e.Status = status })
+}
+
+// SetIndexUsage records the tokens a session consumed. Sessions missing from the index are ignored.
+func SetIndexUsage(hash string, usage Usage) error {
+	return updateIndexEntry(hash, func(e *IndexEntry) { e.Usage = &usage })
+}
+
+// SetIndexDuration records how long a session ran. Sessions missing from the index are ignored.
+func SetIndexDuration(hash string, d time.Duration) error {
+	return updateIndexEntry(hash, func(e *IndexEntry) { e.DurationMs = d.Milliseconds() })
+}
+
+// SetIndexOffsets updates the history offsets of indexed sessions after the
+// history file was rewritten. Sessions missing from offsets are left unchanged.
+func SetIndexOffsets(offsets map[string]int64) error {
+	return updateIndex(func(idx map[string]IndexEntry) bool {
+		for h, e := range idx {
+			if off, ok := offsets[h]; ok {
+				e.HistoryOffset = off
+				idx[h] = e
+			}
+		}
+		return true
+	})
+}
+
+// RemoveIndexEntries drops the entries for the given sessions.
+func RemoveIndexEntries(hashes ...string) error {
+	return updateIndex(func(idx map[string]IndexEntry) bool {
+		for _, h := range hashes {
+			delete(idx, h)
+		}
+		return true
+	})
+}
+
+// LookupIndexEntry finds a session by full hash or unambiguous prefix.
+func LookupIndexEntry(hashOrPrefix string) (IndexEntry, error) {
+	idx, err := LoadIndex()
+	if err != nil {
+		return IndexEntry{}, err
+	}
+	if e, ok := idx[hashOrPrefix]; ok {
+		return e, nil
+	}
+	var matches []string
+	for h := range idx {
+		if strings.HasPrefix(h, hashOrPrefix) {
+			matches = append(matches, h)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return IndexEntry{}, fmt.Errorf("no session found for '%s'", hashOrPrefix)
+	case 1:
+		return idx[matches[0]], nil
+	default:
+		sort.Strings(matches)
+		return IndexEntry{}, fmt.Errorf("'%s' is ambiguous: matches %s", hashOrPrefix, strings.Join(matches, ", "))
+	}
+}
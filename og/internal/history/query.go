@@ -0,0 +1,112 @@
+package history
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Time parses the record's timestamp. Records written before timestamps were
+// RFC 3339 yield the zero time.
+func (r HistoryRecord) Time() time.Time {
+	t, err := time.Parse(time.RFC3339, r.TS)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Query selects history records. Zero-valued fields match everything.
+type Query struct {
+	Words  []string  // Every word must appear in the query (case-insensitive)
+	Since  time.Time // Only sessions started at or after this time
+	Until  time.Time // Only sessions started before this time
+	CWD    string    // Only sessions run in this directory or below it
+	User   string    // Only sessions attributed to this user
+	Status string    // Only sessions that ended this way (see the session index)
+}
+
+// Match reports whether rec satisfies the query. status is the session's outcome
+// from the index, or "" if unknown.
+func (q Query) Match(rec HistoryRecord, status string) bool {
+	if len(q.Words) > 0 {
+		text := strings.ToLower(rec.Query)
+		for _, w := range q.Words {
+			if !strings.Contains(text, strings.ToLower(w)) {
+				return false
+			}
+		}
+	}
+	if !q.Since.IsZero() || !q.Until.IsZero() {
+		ts := rec.Time()
+		if ts.IsZero() || (!q.Since.IsZero() && ts.Before(q.Since)) || (!q.Until.IsZero() && !ts.Before(q.Until)) {
+			return false
+		}
+	}
+	if q.CWD != "" {
+		rel, err := filepath.Rel(filepath.Clean(q.CWD), filepath.Clean(rec.CWD))
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return false
+		}
+	}
+	if q.User != "" && rec.User != q.User {
+		return false
+	}
+	if q.Status != "" && status != q.Status {
+		return false
+	}
+	return true
+}
+
+// Search returns the records matching q, in their original order. statuses maps
+// session hashes to outcomes and may be nil.
+func Search(records []HistoryRecord, q Query, statuses map[string]string) []HistoryRecord {
+	var out []HistoryRecord
+	for _, rec := range records {
+		if q.Match(rec, statuses[rec.Hash]) {
+			out = append(out, rec)
+		}
+	}
+	return out
+}
+
+// Statuses returns the outcome of every indexed session, keyed by hash.
+func Statuses() (map[string]string, error) {
+	idx, err := LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	statuses := make(map[string]string, len(idx))
+	for h, e := range idx {
+		statuses[h] = e.Status
+	}
+	return statuses, nil
+}
+
+// ParseTimeBound parses a point in time given either relative to now ("90m",
+// "36h", "7d", "2w") or as a date ("2006-01-02") or RFC 3339 timestamp.
+func ParseTimeBound(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+	if n := len(s); n > 1 {
+		if count, err := strconv.Atoi(s[:n-1]); err == nil {
+			switch s[n-1] {
+			case 'd':
+				return now.AddDate(0, 0, -count), nil
+			case 'w':
+				return now.AddDate(0, 0, -7*count), nil
+			}
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", s, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time '%s': use a duration (e.g. 36h, 7d, 2w) or a date (YYYY-MM-DD)", s)
+}
@@ -0,0 +1,124 @@
+// Package hooks runs the shell commands of [hooks] on the host before and
+// after each step of a session, such as `git stash` before and a notification
+// after. Hooks see the step in OG_STEP_* environment variables.
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/executor"
+)
+
+// Kinds of hooks, as the agent reports the steps they run around.
+const (
+	Pre  = "pre_step"
+	Post = "post_step"
+)
+
+// maxOutputBytes is how much of a failing hook's output its error carries.
+const maxOutputBytes = 2048
+
+// Step describes the step hooks run around.
+type Step struct {
+	ID         int    // Number of the step in the session, counting retries
+	Number     int    // Number of the recipe step, or 0 outside the recipe
+	Tool       string // The agent's tool, e.g. shell_tool
+	Command    string // The command or input the tool was given
+	Status     string // How the step went: success, failure or error; post_step only
+	ExitCode   *int   // Exit status of a shell step; post_step only
+	DurationMs int64  // How long the step ran; post_step only
+}
+
+// Runner runs the hooks of a session.
+type Runner struct {
+	cfg     config.HooksCfg
+	session string
+	workdir string
+}
+
+// New returns a Runner of the hooks of cfg for the session with the given
+// hash, run in workdir. It returns nil when cfg has no hooks.
+func New(cfg config.HooksCfg, session, workdir string) *Runner {
+	if len(cfg.PreStep) == 0 && len(cfg.PostStep) == 0 {
+		return nil
+	}
+	return &Runner{cfg: cfg, session: session, workdir: workdir}
+}
+
+// Aborts reports whether a failing hook ends the session (hooks.on_failure).
+func (r *Runner) Aborts() bool {
+	return r.cfg.OnFailure == "abort"
+}
+
+// Run runs the hooks of kind for step, in order, and stops at the first that
+// fails.
+func (r *Runner) Run(kind string, step Step) error {
+	commands := r.cfg.PreStep
+	if kind == Post {
+		commands = r.cfg.PostStep
+	}
+	env := r.env(kind, step)
+	for _, command := range commands {
+		if err := r.run(command, env); err != nil {
+			return fmt.Errorf("%s hook '%s' %w", kind, command, err)
+		}
+	}
+	return nil
+}
+
+// env returns the environment of the hooks of kind for step.
+func (r *Runner) env(kind string, step Step) []string {
+	env := append(os.Environ(),
+		"OG_HOOK="+kind,
+		"OG_SESSION_ID="+r.session,
+		"OG_STEP_ID="+strconv.Itoa(step.ID),
+		"OG_STEP_TOOL="+step.Tool,
+		"OG_STEP_COMMAND="+step.Command,
+	)
+	if step.Number > 0 {
+		env = append(env, "OG_STEP_NUMBER="+strconv.Itoa(step.Number))
+	}
+	if kind == Post {
+		env = append(env,
+			"OG_STEP_STATUS="+step.Status,
+			"OG_STEP_DURATION_MS="+strconv.FormatInt(step.DurationMs, 10),
+		)
+		if step.ExitCode != nil {
+			env = append(env, "OG_STEP_EXIT_CODE="+strconv.Itoa(*step.ExitCode))
+		}
+	}
+	return env
+}
+
+// run runs one hook and reports how it failed, with the end of its output.
+func (r *Runner) run(command string, env []string) error {
+	timeout := time.Duration(r.cfg.TimeoutSeconds) * time.Second
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cmd := executor.ShellCommand(ctx, command)
+	cmd.Dir = r.workdir
+	cmd.Env = env
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if ctx.Err() != nil {
+		err = fmt.Errorf("ran longer than %s (hooks.timeout_seconds)", timeout)
+	} else {
+		err = fmt.Errorf("failed: %w", err)
+	}
+	output := strings.TrimSpace(string(out))
+	if len(output) > maxOutputBytes {
+		output = "..." + output[len(output)-maxOutputBytes:]
+	}
+	if output != "" {
+		return fmt.Errorf("%w\n%s", err, output)
+	}
+	return err
+}
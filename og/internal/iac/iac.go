@@ -0,0 +1,310 @@
+// Package iac runs an infrastructure-as-code tool's own plan or preview before
+// an "apply"-class command, so its resource changes can be shown when approving.
+package iac
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Resource change actions, as shown in a Plan.
+const (
+	Create  = "create"
+	Update  = "update"
+	Delete  = "delete"
+	Replace = "replace"
+)
+
+// Apply is an apply-class command recognized by Match.
+type Apply struct {
+	Tool    string // "terraform", "tofu" or "pulumi"
+	Destroy bool   // terraform/pulumi destroy rather than apply/up
+	Dir     string // Directory the command runs in
+	Args    []string
+}
+
+// ResourceChange is one resource the plan would touch.
+type ResourceChange struct {
+	Address string // Terraform address or Pulumi URN
+	Action  string
+}
+
+// Plan is the parsed result of a plan or preview.
+type Plan struct {
+	Tool    string
+	Command string // The plan command that was run
+	Changes []ResourceChange
+	Add     int
+	Change  int
+	Destroy int
+}
+
+// Summary returns the counts in Terraform's wording.
+func (p *Plan) Summary() string {
+	if p.Add+p.Change+p.Destroy == 0 {
+		return "no changes"
+	}
+	return fmt.Sprintf("%d to add, %d to change, %d to destroy", p.Add, p.Change, p.Destroy)
+}
+
+var (
+	applyRe = regexp.MustCompile(`(?:^|[;&|]\s*|\s)(terraform|tofu|pulumi)\s+([^;&|]*)`)
+	cdRe    = regexp.MustCompile(`^\s*cd\s+("[^"]+"|'[^']+'|\S+)\s*&&`)
+)
+
+// Match reports whether command applies infrastructure changes with Terraform,
+// OpenTofu or Pulumi, resolving the directory it runs in against workdir.
+func Match(command, workdir string) (Apply, bool) {
+	dir := workdir
+	if m := cdRe.FindStringSubmatch(command); m != nil {
+		dir = resolveDir(workdir, strings.Trim(m[1], `"'`))
+	}
+	for _, m := range applyRe.FindAllStringSubmatch(command, -1) {
+		args := strings.Fields(m[2])
+		a := Apply{Tool: m[1], Dir: dir}
+		verb := ""
+		for _, arg := range args {
+			switch {
+			case strings.HasPrefix(arg, "-chdir="):
+				a.Dir = resolveDir(dir, strings.TrimPrefix(arg, "-chdir="))
+			case strings.HasPrefix(arg, "--cwd="):
+				a.Dir = resolveDir(dir, strings.TrimPrefix(arg, "--cwd="))
+			case verb == "" && !strings.HasPrefix(arg, "-"):
+				verb = arg
+			}
+		}
+		a.Args = args
+		switch {
+		case a.Tool == "pulumi" && (verb == "up" || verb == "update"):
+		case a.Tool == "pulumi" && verb == "destroy":
+			a.Destroy = true
+		case a.Tool != "pulumi" && verb == "apply":
+			a.Destroy = slices.Contains(args, "-destroy")
+		case a.Tool != "pulumi" && verb == "destroy":
+			a.Destroy = true
+		default:
+			continue
+		}
+		if a.Tool != "pulumi" && planFileArg(args) != "" {
+			continue // Applying a saved plan file: that plan was already reviewed
+		}
+		return a, true
+	}
+	return Apply{}, false
+}
+
+// Detect reports whether dir holds a Terraform/OpenTofu configuration or a Pulumi project.
+func Detect(dir string) bool {
+	if _, err := os.Stat(filepath.Join(dir, "Pulumi.yaml")); err == nil {
+		return true
+	}
+	tf, _ := filepath.Glob(filepath.Join(dir, "*.tf"))
+	return len(tf) > 0
+}
+
+// Run runs the plan or preview matching a and parses its resource changes.
+func Run(a Apply, timeout time.Duration) (*Plan, error) {
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	if a.Tool == "pulumi" {
+		return runPulumi(ctx, a)
+	}
+	return runTerraform(ctx, a)
+}
+
+var (
+	tfResourceRe = regexp.MustCompile(`^\s*# (\S+) (will be created|will be updated in-place|will be destroyed|must be replaced|will be replaced)`)
+	tfPlanRe     = regexp.MustCompile(`Plan: (\d+) to add, (\d+) to change, (\d+) to destroy`)
+)
+
+func runTerraform(ctx context.Context, a Apply) (*Plan, error) {
+	args := []string{"plan", "-no-color", "-input=false", "-lock=false"}
+	if a.Destroy {
+		args = append(args, "-destroy")
+	}
+	args = append(args, passThrough(a.Args, "-var=", "-var-file=", "-target=", "-replace=")...)
+	out, err := run(ctx, a.Dir, a.Tool, args...)
+	plan := &Plan{Tool: a.Tool, Command: a.Tool + " " + strings.Join(args, " ")}
+	if err != nil {
+		return plan, err
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if m := tfResourceRe.FindStringSubmatch(line); m != nil {
+			action := map[string]string{
+				"will be created":          Create,
+				"will be updated in-place": Update,
+				"will be destroyed":        Delete,
+				"must be replaced":         Replace,
+				"will be replaced":         Replace,
+			}[m[2]]
+			plan.Changes = append(plan.Changes, ResourceChange{Address: m[1], Action: action})
+			continue
+		}
+		if m := tfPlanRe.FindStringSubmatch(line); m != nil {
+			fmt.Sscan(m[1], &plan.Add)
+			fmt.Sscan(m[2], &plan.Change)
+			fmt.Sscan(m[3], &plan.Destroy)
+		}
+	}
+	return plan, nil
+}
+
+func runPulumi(ctx context.Context, a Apply) (*Plan, error) {
+	stack := passThrough(a.Args, "--stack=", "-s=")
+	for i, arg := range a.Args { // `--stack dev` form
+		if (arg == "--stack" || arg == "-s") && i+1 < len(a.Args) {
+			stack = append(stack, "--stack="+a.Args[i+1])
+		}
+	}
+	plan := &Plan{Tool: "pulumi"}
+
+	if a.Destroy {
+		// Destroy removes everything in the stack; list what the stack holds
+		args := append([]string{"stack", "export"}, stack...)
+		plan.Command = "pulumi " + strings.Join(args, " ")
+		out, err := run(ctx, a.Dir, "pulumi", args...)
+		if err != nil {
+			return plan, err
+		}
+		var state struct {
+			Deployment struct {
+				Resources []struct {
+					URN  string `json:"urn"`
+					Type string `json:"type"`
+				} `json:"resources"`
+			} `json:"deployment"`
+		}
+		if err := json.Unmarshal(out, &state); err != nil {
+			return plan, fmt.Errorf("failed to parse stack export: %w", err)
+		}
+		for _, r := range state.Deployment.Resources {
+			if r.Type == "pulumi:pulumi:Stack" || strings.HasPrefix(r.Type, "pulumi:providers:") {
+				continue
+			}
+			plan.Changes = append(plan.Changes, ResourceChange{Address: r.URN, Action: Delete})
+			plan.Destroy++
+		}
+		return plan, nil
+	}
+
+	args := append([]string{"preview", "--non-interactive", "--json"}, stack...)
+	plan.Command = "pulumi " + strings.Join(args, " ")
+	out, err := run(ctx, a.Dir, "pulumi", args...)
+	if err != nil {
+		return plan, err
+	}
+	var preview struct {
+		Steps []struct {
+			Op  string `json:"op"`
+			URN string `json:"urn"`
+		} `json:"steps"`
+	}
+	if err := json.Unmarshal(out, &preview); err != nil {
+		return plan, fmt.Errorf("failed to parse pulumi preview: %w", err)
+	}
+	for _, s := range preview.Steps {
+		var action string
+		switch s.Op {
+		case "create":
+			action = Create
+			plan.Add++
+		case "update":
+			action = Update
+			plan.Change++
+		case "delete":
+			action = Delete
+			plan.Destroy++
+		case "replace":
+			action = Replace
+			plan.Add++
+			plan.Destroy++
+		default:
+			continue // same, read, and the create/delete halves of a replacement
+		}
+		plan.Changes = append(plan.Changes, ResourceChange{Address: s.URN, Action: action})
+	}
+	return plan, nil
+}
+
+// run runs an IaC tool and returns its stdout, including stderr in the error on failure.
+func run(ctx context.Context, dir, name string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("%s timed out", name)
+	}
+	if err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = strings.TrimSpace(string(out))
+		}
+		return nil, fmt.Errorf("%s %s failed: %v: %s", name, args[0], err, lastLines(msg, 5))
+	}
+	return out, nil
+}
+
+// passThrough returns the arguments that start with one of the prefixes.
+func passThrough(args []string, prefixes ...string) []string {
+	var out []string
+	for _, arg := range args {
+		for _, p := range prefixes {
+			if strings.HasPrefix(arg, p) {
+				out = append(out, arg)
+				break
+			}
+		}
+	}
+	return out
+}
+
+// planFileArg returns the saved plan file given to `terraform apply`, if any.
+func planFileArg(args []string) string {
+	seenVerb := false
+	for _, arg := range args {
+		if strings.HasPrefix(arg, "-") {
+			continue
+		}
+		if !seenVerb {
+			seenVerb = true
+			continue
+		}
+		return arg
+	}
+	return ""
+}
+
+func resolveDir(base, dir string) string {
+	if filepath.IsAbs(dir) {
+		return dir
+	}
+	return filepath.Join(base, dir)
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(s, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
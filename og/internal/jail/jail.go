@@ -0,0 +1,151 @@
+// Package jail runs the shell steps of a session on the host under bubblewrap
+// or firejail (general.sandbox), for users without Docker: the filesystem
+// outside the working directory is read-only, $HOME and the sockets of
+// services such as Docker and D-Bus are hidden, and what else the steps may
+// reach is set per trust level of the working directory ([jail]).
+package jail
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/executor"
+)
+
+// Jail runs commands under Tool with the restrictions of Profile.
+type Jail struct {
+	Tool       string // "bwrap" or "firejail"
+	Profile    config.JailProfileCfg
+	Home       string // Hidden from the steps; may be empty
+	RuntimeDir string // $XDG_RUNTIME_DIR, hidden from the steps; may be empty
+}
+
+// runDirs hold the sockets of the services of the host, which a step could
+// otherwise use to escape the jail: the Docker or Podman socket starts a
+// privileged container, and D-Bus reaches the user's session.
+var runDirs = []string{"/run", "/var/run"}
+
+// resolveDir is where systemd-resolved keeps the resolv.conf /etc links to,
+// shown again in /run so that steps with network can resolve names.
+const resolveDir = "/run/systemd/resolve"
+
+// runSockets are the entries of the run directories firejail hides; it
+// cannot hide the directories as a whole, whose other entries it needs.
+var runSockets = []string{
+	"docker.sock", "docker", "containerd", "podman", "crio", "crio.sock", "buildkit",
+	"dbus", "user", "snapd.socket", "snapd-snap.socket", "libvirt", "lxd", "incus", "systemd/private",
+}
+
+// New returns a Jail for tool with profile, after checking that tool is
+// installed.
+func New(tool string, profile config.JailProfileCfg) (*Jail, error) {
+	if _, err := exec.LookPath(tool); err != nil {
+		return nil, fmt.Errorf("general.sandbox is %q, but %s is not installed", tool, tool)
+	}
+	home, _ := os.UserHomeDir()
+	return &Jail{Tool: tool, Profile: profile, Home: home, RuntimeDir: os.Getenv("XDG_RUNTIME_DIR")}, nil
+}
+
+// Command runs command with sh in dir under the jail, with env added to its
+// environment. When ctx is done, the jail and the processes it started are
+// killed.
+func (j *Jail) Command(ctx context.Context, dir, command string, env []string) *exec.Cmd {
+	var args []string
+	if j.Tool == "firejail" {
+		args = j.firejailArgs(dir)
+	} else {
+		args = j.bwrapArgs(dir)
+	}
+	cmd := executor.Command(ctx, j.Tool, append(args, "/bin/sh", "-c", command)...)
+	cmd.Dir = dir
+	if len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	return cmd
+}
+
+func (j *Jail) bwrapArgs(dir string) []string {
+	args := []string{"--ro-bind", "/", "/", "--dev", "/dev", "--proc", "/proc", "--tmpfs", "/tmp"}
+	for _, d := range runDirs {
+		// /var/run is usually a link to /run, hidden with it
+		if fi, err := os.Lstat(d); err == nil && fi.IsDir() {
+			args = append(args, "--tmpfs", d)
+		}
+	}
+	if j.RuntimeDir != "" && !within(j.RuntimeDir, "/run") && !within(j.RuntimeDir, "/var/run") {
+		args = append(args, "--tmpfs", j.RuntimeDir)
+	}
+	if j.Profile.Network {
+		args = append(args, "--ro-bind-try", resolveDir, resolveDir)
+	}
+	if j.Home != "" {
+		args = append(args, "--tmpfs", j.Home)
+	}
+	// Bound after /tmp, /run and $HOME, so that a working directory inside them is visible
+	bind := "--bind"
+	if j.Profile.ReadOnly {
+		bind = "--ro-bind"
+	}
+	args = append(args, bind, dir, dir)
+	if !j.Profile.Network {
+		args = append(args, "--unshare-net")
+	}
+	return append(args, "--unshare-pid", "--die-with-parent", "--new-session", "--chdir", dir)
+}
+
+func (j *Jail) firejailArgs(dir string) []string {
+	args := []string{"--quiet", "--noprofile", "--read-only=/"}
+	if !within(dir, os.TempDir()) {
+		args = append(args, "--private-tmp")
+	}
+	if within(dir, j.Home) {
+		// Everything in $HOME but the working directory is hidden
+		args = append(args, "--whitelist="+dir)
+	} else if j.Home != "" {
+		args = append(args, "--private")
+	}
+	for _, d := range runDirs {
+		for _, name := range runSockets {
+			if p := filepath.Join(d, name); !within(dir, p) {
+				args = append(args, "--blacklist="+p)
+			}
+		}
+	}
+	if j.RuntimeDir != "" && !within(dir, j.RuntimeDir) {
+		args = append(args, "--blacklist="+j.RuntimeDir)
+	}
+	if !j.Profile.ReadOnly {
+		args = append(args, "--read-write="+dir)
+	}
+	if !j.Profile.Network {
+		args = append(args, "--net=none")
+	}
+	return args
+}
+
+// within reports whether path is dir or inside it.
+func within(path, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// Describe says where the steps run, e.g. "bwrap (trust untrusted: workdir
+// read-only, no network)".
+func Describe(tool, trust string, profile config.JailProfileCfg) string {
+	mode, network := "read-write", "no network"
+	if profile.ReadOnly {
+		mode = "read-only"
+	}
+	if profile.Network {
+		network = "network"
+	}
+	return fmt.Sprintf("%s (trust %s: workdir %s, %s)", tool, trust, mode, network)
+}
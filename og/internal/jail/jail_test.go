@@ -0,0 +1,59 @@
+package jail
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// hasArg reports whether args has flag followed by value.
+func hasArg(args []string, flag, value string) bool {
+	for i := range len(args) - 1 {
+		if args[i] == flag && args[i+1] == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestBwrapHidesRunDirs(t *testing.T) {
+	j := &Jail{Tool: "bwrap", Home: "/home/u", RuntimeDir: "/var/xdg/1000"}
+	args := j.bwrapArgs("/work")
+	for _, d := range []string{"/run", "/home/u", "/var/xdg/1000"} {
+		if !hasArg(args, "--tmpfs", d) {
+			t.Errorf("bwrap args do not hide %s: %q", d, args)
+		}
+	}
+	if hasArg(args, "--ro-bind-try", resolveDir) {
+		t.Errorf("bwrap args show %s without network: %q", resolveDir, args)
+	}
+	j.Profile = config.JailProfileCfg{Network: true}
+	if args := j.bwrapArgs("/work"); !hasArg(args, "--ro-bind-try", resolveDir) {
+		t.Errorf("bwrap args with network do not show %s: %q", resolveDir, args)
+	}
+	// A working directory under /run is bound after it is hidden
+	args = j.bwrapArgs("/run/media/u/disk")
+	if i, k := slices.Index(args, "/run"), slices.Index(args, "/run/media/u/disk"); i < 0 || k < i {
+		t.Errorf("bwrap args bind the working directory before hiding /run: %q", args)
+	}
+}
+
+func TestFirejailHidesSockets(t *testing.T) {
+	j := &Jail{Tool: "firejail", Home: "/home/u", RuntimeDir: "/run/user/1000"}
+	args := j.firejailArgs("/work")
+	for _, p := range []string{"/run/docker.sock", "/var/run/docker.sock", "/run/podman", "/run/dbus", "/run/user", "/run/user/1000"} {
+		if !slices.Contains(args, "--blacklist="+p) {
+			t.Errorf("firejail args do not hide %s: %q", p, args)
+		}
+	}
+	for _, a := range j.firejailArgs("/run/user/1000/work") {
+		if a == "--blacklist=/run/user" || a == "--blacklist=/run/user/1000" {
+			t.Errorf("firejail args hide the working directory: %q", a)
+		}
+	}
+	if got := strings.Join(args, " "); strings.Contains(got, "--blacklist=/run ") {
+		t.Errorf("firejail args hide all of /run: %q", got)
+	}
+}
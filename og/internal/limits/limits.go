@@ -0,0 +1,63 @@
+// Package limits caps the resources of the agent and of the shell steps og
+// runs ([limits]): their memory, open files and CPU priority, so that a runaway
+// command cannot take the machine down. og starts them through itself, as the
+// hidden `og __limit`, which sets the limits on its own process and then
+// executes the program: they hold from the program's start and for everything
+// it starts. Limits can only be lowered, never raised above what og may use.
+package limits
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// Command is the hidden og subcommand that runs a program under limits, see
+// Wrap and Run.
+const Command = "__limit"
+
+// Describe says which limits are set, e.g. "memory 2048 MB, 256 open files,
+// nice 10".
+func Describe(l config.LimitsCfg) string {
+	var parts []string
+	if l.MemoryMB > 0 {
+		parts = append(parts, fmt.Sprintf("memory %d MB", l.MemoryMB))
+	}
+	if l.MaxOpenFiles > 0 {
+		parts = append(parts, fmt.Sprintf("%d open files", l.MaxOpenFiles))
+	}
+	if l.Nice > 0 {
+		parts = append(parts, fmt.Sprintf("nice %d", l.Nice))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Run implements `og __limit <memory_mb> <max_open_files> <nice> -- <path>
+// <argv...>`: it applies the limits to og's own process and replaces it with
+// the program at path, whose arguments, argv[0] included, follow. It only
+// returns on failure.
+func Run(args []string) error {
+	if len(args) < 6 || args[3] != "--" {
+		return fmt.Errorf("usage: og %s <memory_mb> <max_open_files> <nice> -- <path> <argv...>", Command)
+	}
+	var values [3]int
+	for i := range values {
+		v, err := strconv.Atoi(args[i])
+		if err != nil {
+			return fmt.Errorf("invalid limit %q", args[i])
+		}
+		values[i] = v
+	}
+	l := config.LimitsCfg{MemoryMB: values[0], MaxOpenFiles: values[1], Nice: values[2]}
+	if err := Apply(0, l); err != nil {
+		return err
+	}
+	return execute(args[4], args[5:])
+}
+
+// wrapArgs returns the arguments of og __limit that run path with args under l.
+func wrapArgs(l config.LimitsCfg, path string, args []string) []string {
+	return append([]string{Command, strconv.Itoa(l.MemoryMB), strconv.Itoa(l.MaxOpenFiles), strconv.Itoa(l.Nice), "--", path}, args...)
+}
@@ -0,0 +1,62 @@
+//go:build linux
+
+package limits
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// Wrap returns the program and arguments, argv[0] included, that run path with
+// args under l, for an exec.Cmd: og itself, as og __limit.
+func Wrap(l config.LimitsCfg, path string, args []string) (string, []string, error) {
+	self, err := os.Executable()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to find og's executable: %w", err)
+	}
+	return self, append([]string{self}, wrapArgs(l, path, args)...), nil
+}
+
+// Apply sets the limits of l on the process pid, or on og's own process when
+// pid is 0. Processes it starts afterwards inherit them.
+func Apply(pid int, l config.LimitsCfg) error {
+	if l.MemoryMB > 0 {
+		if err := lower(pid, unix.RLIMIT_DATA, uint64(l.MemoryMB)<<20); err != nil {
+			return fmt.Errorf("failed to limit memory: %w", err)
+		}
+	}
+	if l.MaxOpenFiles > 0 {
+		if err := lower(pid, unix.RLIMIT_NOFILE, uint64(l.MaxOpenFiles)); err != nil {
+			return fmt.Errorf("failed to limit open files: %w", err)
+		}
+	}
+	if l.Nice > 0 {
+		if err := unix.Setpriority(unix.PRIO_PROCESS, pid, l.Nice); err != nil {
+			return fmt.Errorf("failed to set niceness: %w", err)
+		}
+	}
+	return nil
+}
+
+// lower sets both the soft and the hard limit of resource to value, or to the
+// hard limit when that is lower already, since only root may raise it.
+func lower(pid, resource int, value uint64) error {
+	var cur unix.Rlimit
+	if err := unix.Prlimit(pid, resource, nil, &cur); err != nil {
+		return err
+	}
+	value = min(value, cur.Max)
+	return unix.Prlimit(pid, resource, &unix.Rlimit{Cur: value, Max: value}, nil)
+}
+
+func execute(path string, argv []string) error {
+	if err := syscall.Exec(path, argv, os.Environ()); err != nil {
+		return fmt.Errorf("failed to run %s: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+//go:build !linux
+
+package limits
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// Wrap returns path and args unchanged: limits are only applied on Linux,
+// which LoadConfig warns about.
+func Wrap(l config.LimitsCfg, path string, args []string) (string, []string, error) {
+	return path, args, nil
+}
+
+// Apply does nothing: limits are only applied on Linux.
+func Apply(pid int, l config.LimitsCfg) error {
+	return nil
+}
+
+func execute(path string, argv []string) error {
+	return fmt.Errorf("og %s is not available on %s", Command, runtime.GOOS)
+}
@@ -0,0 +1,281 @@
+// Package maintenance finds and removes what old sessions leave behind: cache
+// files, leftover artifact directories and history records, and accounts for
+// the space they take.
+package maintenance
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/checkpoint"
+	"github.com/robbiemu/original_gangster/og/internal/diag"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/sessionlog"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+)
+
+// Item is a file or directory that is due for removal.
+type Item struct {
+	Path    string
+	Size    int64 // Total size in bytes, including directory contents
+	ModTime time.Time
+}
+
+// dataFiles are kept in the data directory, which is also the default cache
+// directory, and must never be mistaken for session files.
+var dataFiles = map[string]bool{
+	"history.json":      true,
+	"index.json":        true,
+	"memory.json":       true,
+	"trust_grants.json": true,
+}
+
+// IsCacheArtifact reports whether a file in the cache directory belongs to a session
+// (session JSON, agent log, session log, checkpoint or crash bundle) and is therefore subject
+// to retention.
+func IsCacheArtifact(name string) bool {
+	if dataFiles[name] {
+		return false
+	}
+	return strings.HasSuffix(name, ".json") ||
+		strings.HasSuffix(name, ".agent.log") ||
+		strings.HasSuffix(name, sessionlog.Suffix) ||
+		strings.HasSuffix(name, checkpoint.Suffix) ||
+		strings.HasSuffix(name, diag.CrashBundleSuffix)
+}
+
+// ExpiredCacheFiles returns the session files in dir last modified before threshold.
+// A missing directory has none.
+func ExpiredCacheFiles(dir string, threshold time.Time) ([]Item, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read cache directory %s: %w", dir, err)
+	}
+	var items []Item
+	for _, e := range entries {
+		if e.IsDir() || !IsCacheArtifact(e.Name()) {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", e.Name(), err)
+		}
+		if info.ModTime().Before(threshold) {
+			items = append(items, Item{Path: filepath.Join(dir, e.Name()), Size: info.Size(), ModTime: info.ModTime()})
+		}
+	}
+	return items, nil
+}
+
+// ExpiredArtifactDirs returns the per-session artifact directories under root last
+// modified before threshold. Sessions remove their own directory when they end, so
+// these are left over from sessions that were killed, or hold backups for og undo.
+func ExpiredArtifactDirs(root string, threshold time.Time) ([]Item, error) {
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read artifact directory %s: %w", root, err)
+	}
+	var items []Item
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat %s: %w", e.Name(), err)
+		}
+		if info.ModTime().Before(threshold) {
+			path := filepath.Join(root, e.Name())
+			items = append(items, Item{Path: path, Size: dirSize(path), ModTime: info.ModTime()})
+		}
+	}
+	return items, nil
+}
+
+// Remove deletes an item, recursively for directories.
+func Remove(item Item) error {
+	if err := os.RemoveAll(item.Path); err != nil {
+		return fmt.Errorf("failed to delete %s: %w", item.Path, err)
+	}
+	return nil
+}
+
+// ExpiredSessions returns the records of sessions started before threshold.
+// Records without a valid timestamp are never considered expired.
+func ExpiredSessions(records []history.HistoryRecord, threshold time.Time) []history.HistoryRecord {
+	var expired []history.HistoryRecord
+	for _, rec := range records {
+		if t := rec.Time(); !t.IsZero() && t.Before(threshold) {
+			expired = append(expired, rec)
+		}
+	}
+	return expired
+}
+
+// SessionUsage is what a session left on disk besides its history record and
+// stored transcript, and the space it takes.
+type SessionUsage struct {
+	Hash  string
+	Items []Item
+	Size  int64
+}
+
+// SessionsUsage returns, for each of hashes, the session's artifact directory
+// (with any output it spilled) and its session JSON, agent log, session log and
+// crash bundle in cacheDir or wherever the session index recorded them. Only
+// what exists is included.
+func SessionsUsage(st store.Store, cacheDir string, hashes []string) ([]SessionUsage, error) {
+	idx, err := history.LoadIndex()
+	if err != nil {
+		return nil, err
+	}
+	usage := make([]SessionUsage, len(hashes))
+	for i, hash := range hashes {
+		e := idx[hash]
+		u := SessionUsage{Hash: hash}
+		seen := map[string]bool{}
+		for _, path := range []string{
+			st.Artifacts().Dir(hash),
+			e.ArtifactsDir,
+			filepath.Join(cacheDir, hash+".json"),
+			filepath.Join(cacheDir, hash+".agent.log"),
+			sessionlog.Path(cacheDir, hash),
+			checkpoint.Path(cacheDir, hash),
+			diag.CrashBundlePath(cacheDir, hash),
+			e.TranscriptPath,
+			e.AgentLogPath,
+			e.LogPath,
+		} {
+			if path == "" || seen[path] {
+				continue
+			}
+			seen[path] = true
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			item := Item{Path: path, Size: info.Size(), ModTime: info.ModTime()}
+			if info.IsDir() {
+				item.Size = dirSize(path)
+			}
+			u.Items = append(u.Items, item)
+			u.Size += item.Size
+		}
+		usage[i] = u
+	}
+	return usage, nil
+}
+
+// GroupBySession totals cache items per session, by the session hash their
+// names start with.
+func GroupBySession(items []Item) []SessionUsage {
+	var usage []SessionUsage
+	pos := map[string]int{}
+	for _, item := range items {
+		hash, _, _ := strings.Cut(filepath.Base(item.Path), ".")
+		i, ok := pos[hash]
+		if !ok {
+			i = len(usage)
+			pos[hash] = i
+			usage = append(usage, SessionUsage{Hash: hash})
+		}
+		usage[i].Items = append(usage[i].Items, item)
+		usage[i].Size += item.Size
+	}
+	return usage
+}
+
+// Largest merges the usage of the same session, counting a path once, and
+// returns the n sessions that take the most space, largest first.
+func Largest(usage []SessionUsage, n int) []SessionUsage {
+	var merged []SessionUsage
+	pos := map[string]int{}
+	seen := map[string]bool{}
+	for _, u := range usage {
+		i, ok := pos[u.Hash]
+		if !ok {
+			i = len(merged)
+			pos[u.Hash] = i
+			merged = append(merged, SessionUsage{Hash: u.Hash})
+		}
+		for _, item := range u.Items {
+			if !seen[item.Path] {
+				seen[item.Path] = true
+				merged[i].Items = append(merged[i].Items, item)
+				merged[i].Size += item.Size
+			}
+		}
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Size > merged[j].Size })
+	if len(merged) > n {
+		merged = merged[:n]
+	}
+	return merged
+}
+
+// PruneSessions deletes the given sessions' history records, stored transcripts
+// and session index entries, and what else they left on disk (see
+// SessionsUsage).
+func PruneSessions(st store.Store, cacheDir string, hashes []string) error {
+	usage, err := SessionsUsage(st, cacheDir, hashes)
+	if err != nil {
+		return err
+	}
+	for _, u := range usage {
+		for _, item := range u.Items {
+			if err := Remove(item); err != nil {
+				return err
+			}
+		}
+	}
+	for _, hash := range hashes {
+		if err := st.Transcripts().Delete(hash); err != nil {
+			return fmt.Errorf("failed to delete transcript of %s: %w", hash, err)
+		}
+	}
+	if err := st.History().Delete(hashes); err != nil {
+		return err
+	}
+	if err := history.RemoveIndexEntries(hashes...); err != nil {
+		return fmt.Errorf("failed to update session index: %w", err)
+	}
+	return nil
+}
+
+// FormatSize renders a byte count for humans, e.g. "1.5 MB".
+func FormatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func dirSize(path string) int64 {
+	var size int64
+	filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,471 @@
+// Package mcp connects og to Model Context Protocol servers ([mcp_servers]),
+// whose tools og offers to the agent and calls on its behalf once they were
+// approved. Servers are started as child processes that speak JSON-RPC 2.0,
+// one message per line, on their stdin and stdout (the stdio transport).
+package mcp
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// protocolVersion is the MCP revision og asks servers for; servers answer
+// with the revision they speak, which og accepts as long as tools work alike.
+const protocolVersion = "2024-11-05"
+
+// DefaultTimeout bounds starting a server and each call when the server's
+// timeout_seconds is unset.
+const DefaultTimeout = 60 * time.Second
+
+// maxMessageBytes is the longest message a server may send.
+const maxMessageBytes = 16 << 20
+
+// Tool is a tool of an MCP server, as offered to the agent.
+type Tool struct {
+	Name        string          `json:"name"`   // Unique among all servers and a Python identifier: <server>_<tool>
+	Server      string          `json:"server"` // The server's name in [mcp_servers]
+	Tool        string          `json:"tool"`   // The tool's name on its server
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`        // JSON Schema of the tool's arguments
+	ReadOnly    bool            `json:"read_only,omitempty"` // The server says the tool does not change anything (readOnlyHint)
+}
+
+// Server is a running MCP server.
+type Server struct {
+	name    string
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	timeout time.Duration
+	tools   []Tool
+
+	writeMu sync.Mutex
+	mu      sync.Mutex
+	nextID  int64
+	waiting map[int64]chan response // Calls waiting for their response, by ID
+	done    chan struct{}           // Closed once the server's output ended
+	readErr error                   // Why it ended
+}
+
+// response is a JSON-RPC response.
+type response struct {
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *rpcError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
+// message is any JSON-RPC message a server sends: a response to og, or a
+// request or notification of its own.
+type message struct {
+	ID     *json.RawMessage `json:"id"`
+	Method string           `json:"method"`
+	response
+}
+
+// Start starts the server name of cfg in workdir, and lists the tools of it
+// that cfg offers.
+func Start(name string, cfg config.MCPServerCfg, workdir string) (*Server, error) {
+	timeout := DefaultTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = workdir
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start %s: %w", cfg.Command[0], err)
+	}
+	s := &Server{name: name, cmd: cmd, stdin: stdin, timeout: timeout, waiting: make(map[int64]chan response), done: make(chan struct{})}
+	go s.read(stdout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := s.initialize(ctx); err != nil {
+		s.Close()
+		return nil, err
+	}
+	tools, err := s.listTools(ctx, cfg.Tools)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	s.tools = tools
+	return s, nil
+}
+
+// Name returns the server's name in [mcp_servers].
+func (s *Server) Name() string {
+	return s.name
+}
+
+// Tools returns the tools of the server offered to the agent.
+func (s *Server) Tools() []Tool {
+	return s.tools
+}
+
+// initialize performs the handshake that opens an MCP session.
+func (s *Server) initialize(ctx context.Context) error {
+	var result struct {
+		ProtocolVersion string `json:"protocolVersion"`
+		Capabilities    struct {
+			Tools *json.RawMessage `json:"tools"`
+		} `json:"capabilities"`
+	}
+	err := s.call(ctx, "initialize", map[string]interface{}{
+		"protocolVersion": protocolVersion,
+		"capabilities":    map[string]interface{}{},
+		"clientInfo":      map[string]string{"name": "og", "version": "1"},
+	}, &result)
+	if err != nil {
+		return fmt.Errorf("initialize failed: %w", err)
+	}
+	if result.Capabilities.Tools == nil {
+		return fmt.Errorf("the server offers no tools")
+	}
+	return s.notify("notifications/initialized", nil)
+}
+
+// listTools lists the server's tools matching the globs of offered, or all
+// of them when there are none.
+func (s *Server) listTools(ctx context.Context, offered []string) ([]Tool, error) {
+	var tools []Tool
+	cursor := ""
+	for {
+		params := map[string]interface{}{}
+		if cursor != "" {
+			params["cursor"] = cursor
+		}
+		var page struct {
+			Tools []struct {
+				Name        string          `json:"name"`
+				Description string          `json:"description"`
+				InputSchema json.RawMessage `json:"inputSchema"`
+				Annotations struct {
+					ReadOnlyHint bool `json:"readOnlyHint"`
+				} `json:"annotations"`
+			} `json:"tools"`
+			NextCursor string `json:"nextCursor"`
+		}
+		if err := s.call(ctx, "tools/list", params, &page); err != nil {
+			return nil, fmt.Errorf("tools/list failed: %w", err)
+		}
+		for _, t := range page.Tools {
+			if !offers(offered, t.Name) {
+				continue
+			}
+			tools = append(tools, Tool{
+				Name:        QualifiedName(s.name, t.Name),
+				Server:      s.name,
+				Tool:        t.Name,
+				Description: t.Description,
+				InputSchema: t.InputSchema,
+				ReadOnly:    t.Annotations.ReadOnlyHint,
+			})
+		}
+		if page.NextCursor == "" || page.NextCursor == cursor {
+			return tools, nil
+		}
+		cursor = page.NextCursor
+	}
+}
+
+// offers reports whether the globs of offered let the agent use tool.
+func offers(offered []string, tool string) bool {
+	if len(offered) == 0 {
+		return true
+	}
+	for _, glob := range offered {
+		if ok, _ := path.Match(glob, tool); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// nonIdentifier matches what may not appear in a Python identifier.
+var nonIdentifier = regexp.MustCompile(`[^A-Za-z0-9_]+`)
+
+// QualifiedName returns the name under which the agent knows tool of server.
+func QualifiedName(server, tool string) string {
+	return Identifier(server + "_" + tool)
+}
+
+// Identifier turns name into a Python identifier, under which the agent can
+// know a tool og calls for it.
+func Identifier(name string) string {
+	name = nonIdentifier.ReplaceAllString(name, "_")
+	if name[0] >= '0' && name[0] <= '9' {
+		name = "mcp_" + name
+	}
+	return name
+}
+
+// Call calls tool with args, a JSON object, and returns the text of its
+// result. A tool that reports failing returns its text with an error.
+func (s *Server) Call(tool string, args json.RawMessage) (string, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	var result struct {
+		Content []struct {
+			Type     string `json:"type"`
+			Text     string `json:"text"`
+			MimeType string `json:"mimeType"`
+			Resource struct {
+				URI  string `json:"uri"`
+				Text string `json:"text"`
+			} `json:"resource"`
+		} `json:"content"`
+		StructuredContent json.RawMessage `json:"structuredContent"`
+		IsError           bool            `json:"isError"`
+	}
+	if err := s.call(ctx, "tools/call", map[string]interface{}{"name": tool, "arguments": args}, &result); err != nil {
+		return "", err
+	}
+	var parts []string
+	for _, c := range result.Content {
+		switch c.Type {
+		case "text":
+			parts = append(parts, c.Text)
+		case "resource":
+			if c.Resource.Text != "" {
+				parts = append(parts, c.Resource.Text)
+			} else {
+				parts = append(parts, fmt.Sprintf("[resource %s]", c.Resource.URI))
+			}
+		default:
+			parts = append(parts, fmt.Sprintf("[%s content, %s]", c.Type, c.MimeType))
+		}
+	}
+	if len(parts) == 0 && len(result.StructuredContent) > 0 {
+		parts = append(parts, string(result.StructuredContent))
+	}
+	text := strings.Join(parts, "\n")
+	if result.IsError {
+		return text, fmt.Errorf("the tool failed: %s", text)
+	}
+	return text, nil
+}
+
+// call sends a request and decodes the result of its response into result.
+func (s *Server) call(ctx context.Context, method string, params interface{}, result interface{}) error {
+	s.mu.Lock()
+	if s.readErr != nil {
+		s.mu.Unlock()
+		return s.readErr
+	}
+	s.nextID++
+	id := s.nextID
+	ch := make(chan response, 1)
+	s.waiting[id] = ch
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.waiting, id)
+		s.mu.Unlock()
+	}()
+
+	if err := s.write(map[string]interface{}{"jsonrpc": "2.0", "id": id, "method": method, "params": params}); err != nil {
+		return err
+	}
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			return resp.Error
+		}
+		if result == nil {
+			return nil
+		}
+		return json.Unmarshal(resp.Result, result)
+	case <-s.done:
+		return s.readErr
+	case <-ctx.Done():
+		return fmt.Errorf("no answer within %s", s.timeout)
+	}
+}
+
+// notify sends a notification, which gets no response.
+func (s *Server) notify(method string, params interface{}) error {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "method": method}
+	if params != nil {
+		msg["params"] = params
+	}
+	return s.write(msg)
+}
+
+// write sends msg on its own line.
+func (s *Server) write(msg interface{}) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if _, err := s.stdin.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("failed to write to the server: %w", err)
+	}
+	return nil
+}
+
+// read hands the responses the server sends to the calls waiting for them
+// and answers its own requests, until its output ends.
+func (s *Server) read(stdout io.Reader) {
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), maxMessageBytes)
+	for scanner.Scan() {
+		var msg message
+		if json.Unmarshal(scanner.Bytes(), &msg) != nil {
+			continue // Servers may log to stdout by mistake
+		}
+		switch {
+		case msg.Method != "" && msg.ID != nil:
+			s.answer(*msg.ID, msg.Method)
+		case msg.Method != "":
+			// Notifications, such as progress or log messages, are not used
+		case msg.ID != nil:
+			var id int64
+			if json.Unmarshal(*msg.ID, &id) != nil {
+				continue
+			}
+			s.mu.Lock()
+			ch, ok := s.waiting[id]
+			s.mu.Unlock()
+			if ok {
+				ch <- msg.response
+			}
+		}
+	}
+	err := scanner.Err()
+	if err == nil {
+		err = errors.New("the server exited")
+	}
+	s.mu.Lock()
+	s.readErr = fmt.Errorf("MCP server %s: %w", s.name, err)
+	s.mu.Unlock()
+	close(s.done)
+}
+
+// answer answers a request of the server: pings, and that og offers it no
+// roots, sampling or other client features.
+func (s *Server) answer(id json.RawMessage, method string) {
+	msg := map[string]interface{}{"jsonrpc": "2.0", "id": id}
+	switch method {
+	case "ping":
+		msg["result"] = map[string]interface{}{}
+	case "roots/list":
+		msg["result"] = map[string]interface{}{"roots": []interface{}{}}
+	default:
+		msg["error"] = rpcError{Code: -32601, Message: "og does not support " + method}
+	}
+	_ = s.write(msg)
+}
+
+// Close stops the server: its stdin is closed, which asks it to exit, and it
+// is killed if it has not within a few seconds.
+func (s *Server) Close() error {
+	s.stdin.Close()
+	exited := make(chan error, 1)
+	go func() { exited <- s.cmd.Wait() }()
+	select {
+	case <-exited:
+	case <-time.After(3 * time.Second):
+		_ = s.cmd.Process.Kill()
+		<-exited
+	}
+	return nil
+}
+
+// Servers are the MCP servers of a session.
+type Servers struct {
+	servers map[string]*Server
+	tools   map[string]Tool // By qualified name
+}
+
+// StartAll starts the servers of cfgs in workdir. A server that cannot be
+// started is reported to failed and left out.
+func StartAll(cfgs map[string]config.MCPServerCfg, workdir string, failed func(name string, err error)) *Servers {
+	all := &Servers{servers: make(map[string]*Server), tools: make(map[string]Tool)}
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		s, err := Start(name, cfgs[name], workdir)
+		if err != nil {
+			failed(name, err)
+			continue
+		}
+		all.servers[name] = s
+		for _, t := range s.Tools() {
+			all.tools[t.Name] = t
+		}
+	}
+	return all
+}
+
+// Tools returns the tools of every server, ordered by name.
+func (all *Servers) Tools() []Tool {
+	tools := make([]Tool, 0, len(all.tools))
+	for _, t := range all.tools {
+		tools = append(tools, t)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// Lookup returns the tool the agent calls name.
+func (all *Servers) Lookup(name string) (Tool, bool) {
+	t, ok := all.tools[name]
+	return t, ok
+}
+
+// Call calls tool with args, see Server.Call.
+func (all *Servers) Call(tool Tool, args json.RawMessage) (string, error) {
+	s, ok := all.servers[tool.Server]
+	if !ok {
+		return "", fmt.Errorf("unknown MCP server %q", tool.Server)
+	}
+	return s.Call(tool.Tool, args)
+}
+
+// Close stops every server.
+func (all *Servers) Close() {
+	for _, s := range all.servers {
+		s.Close()
+	}
+}
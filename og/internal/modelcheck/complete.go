@@ -0,0 +1,62 @@
+package modelcheck
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// Complete sends a single-message chat to the endpoint, at temperature 0, and
+// returns the reply. maxTokens bounds the reply of OpenAI-compatible endpoints.
+func Complete(ctx context.Context, ep Endpoint, model, prompt string, maxTokens int) (string, error) {
+	messages := []map[string]string{{"role": "user", "content": prompt}}
+	var url string
+	var body any
+	switch ep.Kind {
+	case "ollama":
+		url = ep.Base + "/api/chat"
+		body = map[string]any{"model": model, "messages": messages, "stream": false, "options": map[string]any{"temperature": 0}}
+	default:
+		url = ep.Base + "/chat/completions"
+		body = map[string]any{"model": model, "messages": messages, "temperature": 0, "max_tokens": maxTokens}
+	}
+	b, err := json.Marshal(body)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if ep.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.APIKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	var reply struct {
+		Message struct { // Ollama
+			Content string `json:"content"`
+		} `json:"message"`
+		Choices []struct { // OpenAI
+			Message struct {
+				Content string `json:"content"`
+			} `json:"message"`
+		} `json:"choices"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&reply); err != nil {
+		return "", fmt.Errorf("failed to decode the reply of %s: %w", url, err)
+	}
+	if len(reply.Choices) > 0 {
+		return reply.Choices[0].Message.Content, nil
+	}
+	return reply.Message.Content, nil
+}
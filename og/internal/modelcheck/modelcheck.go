@@ -0,0 +1,347 @@
+// Package modelcheck verifies that the configured models exist on their
+// endpoints (Ollama's /api/tags, OpenAI-compatible /models) before a session
+// starts, so a typo is reported up front with suggestions instead of failing deep
+// inside the Python agent.
+package modelcheck
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// CacheTTL is how long the models listed by an endpoint are reused.
+const CacheTTL = 5 * time.Minute
+
+// requestTimeout bounds each endpoint query, so an unreachable endpoint delays
+// the session only briefly.
+const requestTimeout = 3 * time.Second
+
+const cacheFileName = "model_check.json"
+
+// Role is a model configured for an agent role.
+type Role struct {
+	Name  string // "planner", "executor" or "auditor"
+	Model config.ModelCfg
+}
+
+// endpoint is where a model is served and how to list the models there.
+type endpoint struct {
+	kind   string // "ollama" or "openai"
+	base   string
+	apiKey string
+}
+
+func (e endpoint) key() string { return e.kind + " " + e.base }
+
+// resolve finds the endpoint of a LiteLLM-style model ID ("ollama/llama3",
+// "openai/gpt-4o") and the model's name there. Providers whose model list cannot
+// be queried are not checked (ok is false).
+func resolve(m config.ModelCfg) (ep endpoint, name string, ok bool) {
+	provider, name, found := strings.Cut(m.Model, "/")
+	if !found || name == "" {
+		return endpoint{}, "", false
+	}
+	base := param(m.Params, "api_base", "base_url")
+	switch provider {
+	case "ollama", "ollama_chat":
+		if base == "" {
+			base = os.Getenv("OLLAMA_HOST")
+		}
+		if base == "" {
+			base = "http://localhost:11434"
+		}
+		if !strings.Contains(base, "://") {
+			base = "http://" + base
+		}
+		return endpoint{kind: "ollama", base: strings.TrimRight(base, "/")}, name, true
+	case "openai":
+		key := param(m.Params, "api_key")
+		if key == "" {
+			key = os.Getenv("OPENAI_API_KEY")
+		}
+		if base == "" {
+			base = os.Getenv("OPENAI_API_BASE")
+		}
+		if base == "" {
+			if key == "" {
+				return endpoint{}, "", false // api.openai.com cannot be listed without a key
+			}
+			base = "https://api.openai.com/v1"
+		}
+		return endpoint{kind: "openai", base: strings.TrimRight(base, "/"), apiKey: key}, name, true
+	default:
+		return endpoint{}, "", false
+	}
+}
+
+// Endpoint is where a model is served, for callers that query the model itself.
+type Endpoint struct {
+	Kind   string // "ollama" or "openai"
+	Base   string // Base URL, without a trailing slash
+	APIKey string
+}
+
+// Resolve returns the endpoint of a LiteLLM-style model ID and the model's name
+// there, with ok false for providers og cannot talk to directly.
+func Resolve(m config.ModelCfg) (ep Endpoint, name string, ok bool) {
+	e, name, ok := resolve(m)
+	return Endpoint{Kind: e.kind, Base: e.base, APIKey: e.apiKey}, name, ok
+}
+
+func param(params map[string]interface{}, names ...string) string {
+	for _, n := range names {
+		if v, ok := params[n].(string); ok && v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// Check returns a warning for every role whose model is missing from its
+// endpoint, and for every endpoint that could not be reached. Each endpoint is
+// queried at most once per CacheTTL.
+func Check(ctx context.Context, roles []Role) []string {
+	type lookup struct {
+		roles []string
+		name  string
+	}
+	endpoints := map[string]endpoint{}
+	var keys []string
+	wanted := map[string][]*lookup{} // By endpoint key
+	for _, r := range roles {
+		ep, name, ok := resolve(r.Model)
+		if !ok {
+			continue
+		}
+		k := ep.key()
+		if _, seen := endpoints[k]; !seen {
+			endpoints[k] = ep
+			keys = append(keys, k)
+		}
+		var found *lookup
+		for _, l := range wanted[k] {
+			if l.name == name {
+				found = l
+			}
+		}
+		if found == nil {
+			found = &lookup{name: name}
+			wanted[k] = append(wanted[k], found)
+		}
+		found.roles = append(found.roles, r.Name)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+
+	cache := loadCache()
+	lists := make(map[string][]string, len(keys))
+	errs := map[string]error{}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	now := time.Now()
+	for _, k := range keys {
+		if c, ok := cache[k]; ok && now.Sub(c.CheckedAt) < CacheTTL {
+			lists[k] = c.Models
+			continue
+		}
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			models, err := list(ctx, endpoints[k])
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs[k] = err
+				return
+			}
+			lists[k] = models
+			cache[k] = cacheEntry{CheckedAt: now, Models: models}
+		}(k)
+	}
+	wg.Wait()
+	saveCache(cache)
+
+	var warnings []string
+	for _, k := range keys {
+		ep := endpoints[k]
+		if err, ok := errs[k]; ok {
+			warnings = append(warnings, fmt.Sprintf("Could not list the models at %s (%s): %v", ep.base, ep.kind, err))
+			continue
+		}
+		for _, l := range wanted[k] {
+			if available(l.name, lists[k]) {
+				continue
+			}
+			msg := fmt.Sprintf("%s (%s) not found at %s", l.name, strings.Join(l.roles, ", "), ep.base)
+			if s := Suggest(l.name, lists[k]); len(s) > 0 {
+				msg += "; did you mean " + strings.Join(s, " or ") + "?"
+			} else if ep.kind == "ollama" {
+				msg += "; pull it with `ollama pull " + l.name + "`"
+			}
+			warnings = append(warnings, msg)
+		}
+	}
+	return warnings
+}
+
+// available reports whether name is among models. Ollama names without a tag
+// refer to ":latest".
+func available(name string, models []string) bool {
+	for _, m := range models {
+		if m == name || m == name+":latest" || strings.TrimSuffix(m, ":latest") == name {
+			return true
+		}
+	}
+	return false
+}
+
+// list queries the models an endpoint serves.
+func list(ctx context.Context, ep endpoint) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, requestTimeout)
+	defer cancel()
+	url := ep.base + "/api/tags"
+	if ep.kind == "openai" {
+		url = ep.base + "/models"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if ep.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+ep.apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+
+	var body struct {
+		Models []struct {
+			Name string `json:"name"`
+		} `json:"models"` // Ollama
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"` // OpenAI
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid model list from %s: %w", url, err)
+	}
+	var models []string
+	for _, m := range body.Models {
+		models = append(models, m.Name)
+	}
+	for _, m := range body.Data {
+		models = append(models, m.ID)
+	}
+	sort.Strings(models)
+	return models, nil
+}
+
+// Suggest returns up to three models whose names are close to name: those of the
+// same family (the part before ':' for Ollama tags), then those within a small
+// edit distance, closest first.
+func Suggest(name string, models []string) []string {
+	family, _, _ := strings.Cut(name, ":")
+	type scored struct {
+		model string
+		score int
+	}
+	var candidates []scored
+	for _, m := range models {
+		d := distance(strings.ToLower(name), strings.ToLower(m))
+		mFamily, _, _ := strings.Cut(m, ":")
+		switch {
+		case mFamily == family:
+			candidates = append(candidates, scored{m, d - 100}) // Same family ranks first
+		case d <= max(2, len(name)/3):
+			candidates = append(candidates, scored{m, d})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].score < candidates[j].score })
+	var out []string
+	for _, c := range candidates {
+		if len(out) == 3 {
+			break
+		}
+		out = append(out, c.model)
+	}
+	return out
+}
+
+// distance is the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// cacheEntry is the model list of one endpoint.
+type cacheEntry struct {
+	CheckedAt time.Time `json:"checked_at"`
+	Models    []string  `json:"models"`
+}
+
+func cachePath() (string, error) {
+	dir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, cacheFileName), nil
+}
+
+// loadCache reads the cached model lists. A missing or corrupt cache is empty.
+func loadCache() map[string]cacheEntry {
+	cache := map[string]cacheEntry{}
+	path, err := cachePath()
+	if err != nil {
+		return cache
+	}
+	if b, err := os.ReadFile(path); err == nil {
+		_ = json.Unmarshal(b, &cache)
+	}
+	return cache
+}
+
+// saveCache writes the model lists; failures only cost a later re-query.
+func saveCache(cache map[string]cacheEntry) {
+	path, err := cachePath()
+	if err != nil {
+		return
+	}
+	b, err := json.Marshal(cache)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err == nil {
+		_ = os.WriteFile(path, b, 0o644)
+	}
+}
@@ -0,0 +1,525 @@
+// Package native is og's built-in agent (general.backend), for systems
+// without the Python agent's stack. It speaks the agent's side of the
+// protocol, so the session approves, runs and records its steps like the
+// Python agent's, but covers only the simple flow: the planner proposes one
+// action, the auditor judges it, og asks for approval and runs it, and the
+// executor model summarizes the result. It talks to Ollama and
+// OpenAI-compatible chat APIs itself, with the prompts of prompts.toml, or
+// stands in for them with canned replies for og selftest (Config.Echo).
+package native
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/modelcheck"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// maxReplyTokens bounds the replies of OpenAI-compatible endpoints.
+const maxReplyTokens = 2048
+
+// maxSummaryInput is how much of a step's output the executor model is shown
+// for the summary: its head and its tail.
+const maxSummaryInput = 8192
+
+// Config is what the native agent is started with.
+type Config struct {
+	Query       string
+	Workdir     string
+	Planner     config.ModelCfg
+	Executor    config.ModelCfg
+	Auditor     config.ModelCfg
+	PromptsFile string // The prompts.toml whose [prompts] are used
+	QueryTag    string // Selects the [prompts.tags.<tag>] overrides
+	Strictness  string // policy.auditor_strictness
+	Overridable bool   // The user may override the auditor's verdicts
+	ReadOnly    bool   // og denies steps that write, see og --read-only
+	StepHooks   bool   // og runs [hooks] around the step, see "pre_step"
+	Echo        bool   // Canned replies stand in for the models, see EchoCommand
+	Timeout     time.Duration
+}
+
+// CheckModels reports an error for a model the native agent cannot talk to.
+func (c Config) CheckModels() error {
+	if c.Echo {
+		return nil
+	}
+	for _, m := range []config.ModelCfg{c.Planner, c.Executor, c.Auditor} {
+		if _, _, ok := modelcheck.Resolve(m); !ok {
+			return fmt.Errorf("the native agent only talks to Ollama and OpenAI-compatible models, not %q", m.Model)
+		}
+	}
+	return nil
+}
+
+// errCancelled ends the flow when og asked to cancel the session.
+var errCancelled = errors.New("cancelled")
+
+// Agent is a running native agent.
+type Agent struct {
+	cfg       Config
+	out       io.Writer
+	commands  chan map[string]interface{} // What og sent, except cancel
+	cancel    context.CancelFunc
+	cancelled atomic.Bool // og sent cancel, rather than the agent being interrupted
+	steps     int
+}
+
+// Run runs the agent until the session ends or ctx does: it reads og's
+// commands from in and writes its messages to out as JSON lines.
+func Run(ctx context.Context, cfg Config, in io.Reader, out io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	a := &Agent{cfg: cfg, out: out, commands: make(chan map[string]interface{}, 4), cancel: cancel}
+	go a.read(in)
+
+	err := a.run(ctx)
+	switch {
+	case err == nil || err == errAborted:
+		return nil
+	case a.cancelled.Load():
+		return a.emit(ui.AgentMessage{Type: "cancelled", Message: "Cancelled by the user.", Steps: a.steps})
+	case ctx.Err() != nil:
+		return a.emit(ui.AgentMessage{Type: "error", Kind: "aborted", Message: "Agent interrupted by the user."})
+	}
+	return err
+}
+
+// read hands og's commands to the flow, and cancels it when og sends cancel.
+// The channel is closed when og closes its end.
+func (a *Agent) read(in io.Reader) {
+	defer close(a.commands)
+	scanner := bufio.NewScanner(in)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20) // command_result carries whole outputs
+	for scanner.Scan() {
+		var command map[string]interface{}
+		if json.Unmarshal(scanner.Bytes(), &command) != nil {
+			continue
+		}
+		if command["type"] == "cancel" {
+			a.cancelled.Store(true)
+			_ = a.emit(ui.AgentMessage{Type: "cancelling", Message: "Cancelling the session..."})
+			a.cancel()
+			continue
+		}
+		a.commands <- command
+	}
+}
+
+// await returns the next command of og, which must be one of types. It
+// returns nil when og closed its end.
+func (a *Agent) await(ctx context.Context, types ...string) (map[string]interface{}, error) {
+	select {
+	case command, ok := <-a.commands:
+		if !ok {
+			return nil, nil
+		}
+		for _, t := range types {
+			if command["type"] == t {
+				return command, nil
+			}
+		}
+		return nil, a.fail("protocol", fmt.Errorf("expected %s from og, got %v", strings.Join(types, " or "), command["type"]))
+	case <-ctx.Done():
+		return nil, errCancelled
+	}
+}
+
+// emit writes a message for og.
+func (a *Agent) emit(msg ui.AgentMessage) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	_, err = a.out.Write(append(b, '\n'))
+	return err
+}
+
+// fail reports err to og as an "error" of the given kind and returns it.
+func (a *Agent) fail(kind string, err error) error {
+	_ = a.emit(ui.AgentMessage{Type: "error", Kind: kind, Message: err.Error(), Location: "native"})
+	return err
+}
+
+// run is the agent's flow.
+func (a *Agent) run(ctx context.Context) error {
+	p, err := loadPrompts(a.cfg.PromptsFile, a.cfg.QueryTag)
+	if err != nil {
+		return a.fail("internal", err)
+	}
+
+	action, err := a.plan(ctx, p)
+	if err != nil {
+		return err
+	}
+	a.emit(ui.AgentMessage{
+		Type:        "plan",
+		Request:     a.cfg.Query,
+		RecipeSteps: []ui.AgentAction{{Description: a.cfg.Query, Action: action, Tool: "shell_tool"}},
+	})
+	if command, err := a.await(ctx, "execute_single_action"); command == nil {
+		return err // The plan was denied
+	}
+
+	if ok, err := a.audit(ctx, p, action); !ok {
+		return err
+	}
+
+	a.emit(ui.AgentMessage{Type: "request_approval", Description: "shell_tool -> " + action, Action: action, Tool: "shell_tool"})
+	command, err := a.await(ctx, "user_approval_response")
+	if command == nil {
+		return err
+	}
+	if approved, _ := command["approved"].(bool); !approved {
+		a.emit(ui.AgentMessage{Type: "result", Status: "cancelled", InterpretMessage: "User denied execution"})
+		a.emit(ui.AgentMessage{Type: "deny_current_action", Message: "User explicitly denied the action."})
+		return nil
+	}
+
+	status, output, err := a.execute(ctx, action)
+	if err != nil {
+		return err
+	}
+	return a.summarize(ctx, action, status, output)
+}
+
+// plan asks the planner for the commands that fulfill the query, which the
+// native agent runs as one action.
+func (a *Agent) plan(ctx context.Context, p prompts) (string, error) {
+	query := a.cfg.Query
+	if a.cfg.ReadOnly {
+		query += "\n\nThis session is read-only: the OG client denies every step that writes, deletes or moves files, installs software, changes services or sends data that changes remote state. Plan only steps that read and inspect; where the request needs a change, describe it instead of making it."
+	}
+	prompt, err := p.format("planning_prompt_template", map[string]string{
+		"query":                      query,
+		"planning_tools_section_str": "None: the native agent plans from the request alone.",
+	})
+	if err != nil {
+		return "", a.fail("internal", err)
+	}
+	reply, err := a.complete(ctx, "planner", a.cfg.Planner, prompt)
+	if err != nil {
+		return "", err
+	}
+	var commands []string
+	for _, line := range strings.Split(finalAnswer(reply), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+		case directive.MatchString(line):
+			return "", a.fail("internal", fmt.Errorf("the plan needs %s, which only the Python agent carries out (general.backend = \"python\"):\n%s", line, reply))
+		default:
+			commands = append(commands, line)
+		}
+	}
+	if len(commands) == 0 {
+		return "", a.fail("internal", fmt.Errorf("the planner proposed no command:\n%s", reply))
+	}
+	return strings.Join(commands, "\n"), nil
+}
+
+// directive matches the plan lines of dynamic plans: [STEP], conditions,
+// loops and inputs.
+var directive = regexp.MustCompile(`^\[(STEP|IF |FOR EACH |REPEAT |ASK )`)
+
+var (
+	answerString = regexp.MustCompile(`(?s)answer\s*=\s*[rf]?(?:"""|''')\n?(.*?)(?:"""|''')`)
+	fencedBlock  = regexp.MustCompile("(?s)```[a-z]*\\n(.*?)```")
+)
+
+// finalAnswer returns the answer of a reply written for the Python agent's
+// final_answer tool, as the prompts ask, or of a plain reply.
+func finalAnswer(reply string) string {
+	if m := answerString.FindStringSubmatch(reply); m != nil {
+		return m[1]
+	}
+	if m := fencedBlock.FindStringSubmatch(reply); m != nil {
+		return m[1]
+	}
+	return reply
+}
+
+var (
+	safeLine        = regexp.MustCompile(`(?im)^[#\s*]*safe[\s*]*:[\s*]*(true|false|yes|no)\b`)
+	reasonLine      = regexp.MustCompile(`(?im)^[#\s*]*reason[\s*]*:[\s*]*(.*)$`)
+	explanationLine = regexp.MustCompile(`(?im)^[#\s*]*explanation[\s*]*:[\s*]*(.*)$`)
+)
+
+// audit has the auditor judge action. It reports false when the action must
+// not run: the auditor found it unsafe and it was not overridden.
+func (a *Agent) audit(ctx context.Context, p prompts, action string) (bool, error) {
+	prompt, err := p.format("auditor_query_template", map[string]string{
+		"strictness":               p["auditor_strictness_"+a.cfg.Strictness],
+		"terminal_session_context": fmt.Sprintf("Working directory: %s\nOperating system: %s", a.cfg.Workdir, runtime.GOOS),
+		"request":                  action,
+		"context":                  "Original request: " + a.cfg.Query + "\nThe native agent has no exploration tools; judge from the command and the request.",
+	})
+	if err != nil {
+		return false, a.fail("internal", err)
+	}
+	reply, err := a.complete(ctx, "auditor", a.cfg.Auditor, prompt)
+	if err != nil {
+		return false, err
+	}
+	verdict := finalAnswer(reply)
+	m := safeLine.FindStringSubmatch(verdict)
+	if m != nil && (strings.EqualFold(m[1], "true") || strings.EqualFold(m[1], "yes")) {
+		return true, nil
+	}
+	msg := ui.AgentMessage{Type: "unsafe", Action: action, Tool: "shell_tool", Overridable: a.cfg.Overridable, Role: "auditor"}
+	if m == nil {
+		msg.Reason = "The auditor's verdict could not be read"
+		msg.Explanation = strings.TrimSpace(reply)
+	} else {
+		if r := reasonLine.FindStringSubmatch(verdict); r != nil {
+			msg.Reason = strings.TrimSpace(r[1])
+		}
+		if e := explanationLine.FindStringSubmatch(verdict); e != nil {
+			msg.Explanation = strings.TrimSpace(e[1])
+		}
+	}
+	a.emit(msg)
+	if !msg.Overridable {
+		return false, nil
+	}
+	command, err := a.await(ctx, "override_result")
+	if command == nil {
+		return false, err
+	}
+	overridden, _ := command["override"].(bool)
+	return overridden, nil
+}
+
+// execute has og run action and reports its result. It returns the status of
+// the step and its output as the summary sees it.
+func (a *Agent) execute(ctx context.Context, action string) (string, string, error) {
+	a.steps++
+	if err := a.runHooks(ctx, ui.AgentMessage{Type: "pre_step", Tool: "shell_tool", Action: action, StepID: a.steps}); err != nil {
+		return "", "", err
+	}
+	a.emit(ui.AgentMessage{Type: "step_started", Tool: "shell_tool", Action: action, StepID: a.steps})
+	a.emit(ui.AgentMessage{Type: "run_command", Action: action})
+	command, err := a.await(ctx, "command_result")
+	if command == nil {
+		if err == nil {
+			err = errCancelled
+		}
+		return "", "", err
+	}
+	if then, _ := command["then"].(string); then == "abort" {
+		a.emit(ui.AgentMessage{Type: "deny_current_action", Message: fmt.Sprintf("The user aborted '%s' after it ran too long.", action)})
+		return "", "", errAborted
+	}
+
+	result := ui.AgentMessage{Type: "result", Tool: "shell_tool", Action: action}
+	if e, _ := command["error"].(string); e != "" {
+		result.Status, result.InterpretMessage = "failure", e
+		a.emit(result)
+		if err := a.runPostHooks(ctx, result); err != nil {
+			return "", "", err
+		}
+		return "failure", "[ERROR] " + e, nil
+	}
+	stdout, _ := command["stdout"].(string)
+	stderr, _ := command["stderr"].(string)
+	exitCode := 0
+	if n, ok := command["exit_code"].(float64); ok {
+		exitCode = int(n)
+	}
+	if ms, ok := command["duration_ms"].(float64); ok {
+		result.DurationMs = int64(ms)
+	}
+	result.ExitCode = &exitCode
+	result.Output = formatOutput(stdout, stderr, exitCode)
+	result.Status, result.InterpretMessage = "success", "Executed shell_tool"
+	if exitCode != 0 {
+		result.Status = "failure"
+		result.InterpretMessage += fmt.Sprintf(" (Exit code: %d)", exitCode)
+	}
+	if timedOut, _ := command["timed_out"].(bool); timedOut {
+		result.Output += "\n--- Command was killed for exceeding its time limit ---"
+	}
+	result.Binary = binaryOutput(command["binary"])
+	a.emit(result)
+	if err := a.runPostHooks(ctx, result); err != nil {
+		return "", "", err
+	}
+	return result.Status, result.Output, nil
+}
+
+// runHooks has og run its hooks for the "pre_step" or "post_step" msg, when
+// it runs hooks, and ends the flow when a failing hook ends the session.
+func (a *Agent) runHooks(ctx context.Context, msg ui.AgentMessage) error {
+	if !a.cfg.StepHooks {
+		return nil
+	}
+	a.emit(msg)
+	command, err := a.await(ctx, "hook_result")
+	if command == nil {
+		if err == nil {
+			err = errCancelled
+		}
+		return err
+	}
+	if proceed, _ := command["proceed"].(bool); !proceed {
+		a.emit(ui.AgentMessage{Type: "deny_current_action", Message: fmt.Sprintf("A step hook failed around '%s'.", msg.Action)})
+		return errAborted
+	}
+	return nil
+}
+
+// runPostHooks runs the "post_step" hooks of the step whose result is result.
+func (a *Agent) runPostHooks(ctx context.Context, result ui.AgentMessage) error {
+	return a.runHooks(ctx, ui.AgentMessage{
+		Type:       "post_step",
+		Tool:       result.Tool,
+		Action:     result.Action,
+		StepID:     a.steps,
+		Status:     result.Status,
+		ExitCode:   result.ExitCode,
+		DurationMs: result.DurationMs,
+	})
+}
+
+// binaryOutput decodes the "binary" of a command_result, which is nil when
+// the output was text.
+func binaryOutput(v interface{}) *ui.BinaryOutput {
+	if v == nil {
+		return nil
+	}
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	var b ui.BinaryOutput
+	if json.Unmarshal(raw, &b) != nil {
+		return nil
+	}
+	return &b
+}
+
+// errAborted ends the flow when the user aborted the step, or a step hook
+// ended the session; og was told.
+var errAborted = errors.New("aborted")
+
+// formatOutput labels a command's output like the Python agent's shell_tool.
+func formatOutput(stdout, stderr string, exitCode int) string {
+	var parts []string
+	if s := strings.TrimSpace(stdout); s != "" {
+		parts = append(parts, "--- STDOUT ---", s)
+	}
+	if s := strings.TrimSpace(stderr); s != "" {
+		parts = append(parts, "--- STDERR ---", s)
+	}
+	if exitCode != 0 {
+		parts = append(parts, fmt.Sprintf("--- Command exited with status: %d ---", exitCode))
+	}
+	if len(parts) == 0 {
+		return "[Command executed with no output]"
+	}
+	return strings.Join(parts, "\n")
+}
+
+// summaryPrompt asks the executor model for the final summary; %s are the
+// request, the command, its status and its output.
+const summaryPrompt = `You ran a command on the user's machine to fulfill their request. Tell them the outcome.
+
+Request: %s
+Command:
+%s
+Status: %s
+Output:
+%s
+
+Reply in exactly this format:
+NUTSHELL: one sentence with the outcome
+DETAILS: a few sentences with what the user needs to know, such as the values they asked for`
+
+var (
+	nutshellLine = regexp.MustCompile(`(?im)^[#\s*]*nutshell[\s*]*:[\s*]*(.*)$`)
+	detailsText  = regexp.MustCompile(`(?is)^[#\s*]*details[\s*]*:[\s*]*(.*)$`)
+)
+
+// summarize asks the executor model for the final summary of the session.
+func (a *Agent) summarize(ctx context.Context, action, status, output string) error {
+	if len(output) > maxSummaryInput {
+		output = output[:maxSummaryInput/2] + "\n-- output cut --\n" + output[len(output)-maxSummaryInput/2:]
+	}
+	reply, err := a.complete(ctx, "executor", a.cfg.Executor, fmt.Sprintf(summaryPrompt, a.cfg.Query, action, status, output))
+	if err != nil {
+		return err
+	}
+	msg := ui.AgentMessage{Type: "final_summary", Status: status, Summary: strings.TrimSpace(reply)}
+	if m := nutshellLine.FindStringSubmatch(reply); m != nil {
+		msg.Nutshell = strings.TrimSpace(m[1])
+		if i := strings.Index(reply, m[0]); i >= 0 {
+			if d := detailsText.FindStringSubmatch(strings.TrimSpace(reply[i+len(m[0]):])); d != nil {
+				msg.Summary = strings.TrimSpace(d[1])
+			}
+		}
+	}
+	return a.emit(msg)
+}
+
+// complete sends prompt to model for role and returns the reply. A failed call
+// is reported to og.
+func (a *Agent) complete(ctx context.Context, role string, model config.ModelCfg, prompt string) (string, error) {
+	if a.cfg.Echo {
+		return echoReply(role, prompt), nil
+	}
+	ep, name, ok := modelcheck.Resolve(model)
+	if !ok {
+		return "", a.fail("internal", fmt.Errorf("the native agent only talks to Ollama and OpenAI-compatible models, not %q", model.Model))
+	}
+	a.emit(ui.AgentMessage{Type: "debug_log", Role: role, Message: fmt.Sprintf("Asking %s (%s)", model.Model, ep.Base), Location: "native"})
+	callCtx := ctx
+	if a.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		callCtx, cancel = context.WithTimeout(ctx, a.cfg.Timeout)
+		defer cancel()
+	}
+	reply, err := modelcheck.Complete(callCtx, ep, name, prompt, maxReplyTokens)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", errCancelled
+		}
+		return "", a.fail("model_unreachable", fmt.Errorf("the %s model %s failed: %w", role, model.Model, err))
+	}
+	return reply, nil
+}
+
+// EchoCommand is the command the echo agent (Config.Echo) plans, whatever
+// the query: it runs the same on every shell og supports.
+const EchoCommand = "echo og selftest"
+
+// echoReply is the echo agent's reply to prompt for role: it plans
+// EchoCommand, finds it safe, and sums up by echoing the step's output, so a
+// session goes through the whole protocol without a model.
+func echoReply(role, prompt string) string {
+	switch role {
+	case "planner":
+		return EchoCommand
+	case "auditor":
+		return "SAFE: true\nREASON: The echo agent only plans " + EchoCommand + "."
+	}
+	_, output, _ := strings.Cut(prompt, "\nOutput:\n")
+	output, _, _ = strings.Cut(output, "\n\nReply in exactly this format:")
+	var printed []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" && !strings.HasPrefix(line, "---") {
+			printed = append(printed, line)
+		}
+	}
+	return fmt.Sprintf("NUTSHELL: The echo agent's command ran.\nDETAILS: It printed %q.", strings.Join(printed, " "))
+}
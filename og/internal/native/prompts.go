@@ -0,0 +1,59 @@
+package native
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+// prompts are the [prompts] of a prompts file, with the overrides of the
+// query's tag applied.
+type prompts map[string]string
+
+// loadPrompts reads the prompts file at path and applies the keys of its
+// [prompts.tags.<tag>] table, as the Python agent does.
+func loadPrompts(path, tag string) (prompts, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the prompts (run 'og init'): %w", err)
+	}
+	var file struct {
+		Prompts map[string]interface{} `toml:"prompts"`
+	}
+	if err := toml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	p := prompts{}
+	for k, v := range file.Prompts {
+		if s, ok := v.(string); ok {
+			p[k] = s
+		}
+	}
+	if tags, ok := file.Prompts["tags"].(map[string]interface{}); ok && tag != "" {
+		if overrides, ok := tags[tag].(map[string]interface{}); ok {
+			for k, v := range overrides {
+				if s, ok := v.(string); ok {
+					p[k] = s
+				}
+			}
+		}
+	}
+	return p, nil
+}
+
+// format fills the named fields of the prompt key, like Python's str.format,
+// which the prompts are written for: {name} is replaced and {{ and }} stand
+// for single braces.
+func (p prompts) format(key string, fields map[string]string) (string, error) {
+	template, ok := p[key]
+	if !ok {
+		return "", fmt.Errorf("the prompts have no %s", key)
+	}
+	pairs := []string{"{{", "{", "}}", "}"}
+	for name, value := range fields {
+		pairs = append(pairs, "{"+name+"}", value)
+	}
+	return strings.NewReplacer(pairs...).Replace(template), nil
+}
@@ -0,0 +1,138 @@
+// Package notify shows desktop notifications ([notifications]) when a session
+// needs the user or ended while they look elsewhere, with notify-send on
+// Linux, osascript on macOS and a toast on Windows. Nothing is shown while the
+// terminal og runs in has the focus, as far as that can be told.
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// sendTimeout bounds how long showing a notification may take.
+const sendTimeout = 5 * time.Second
+
+// Notifier shows the notifications of a session.
+type Notifier struct {
+	cfg config.NotificationsCfg
+	log *slog.Logger // Gets the notifications that could not be shown
+}
+
+// New returns a Notifier for cfg; it shows nothing unless cfg.Enabled.
+func New(cfg config.NotificationsCfg, log *slog.Logger) *Notifier {
+	return &Notifier{cfg: cfg, log: log}
+}
+
+// Waiting is called when a prompt starts to wait for an answer. Unless the
+// returned function, called with the answer, is called within
+// approval_delay_seconds, the user is told that og waits.
+func (n *Notifier) Waiting(message string) (answered func()) {
+	if !n.cfg.Enabled || !n.cfg.OnApproval {
+		return func() {}
+	}
+	timer := time.AfterFunc(time.Duration(n.cfg.ApprovalDelaySeconds)*time.Second, func() {
+		n.show("og is waiting for you", message)
+	})
+	return func() { timer.Stop() }
+}
+
+// Finished tells the user that a session that ran for d ended with status,
+// if it ran long enough to be worth it.
+func (n *Notifier) Finished(query, status string, d time.Duration) {
+	if !n.cfg.Enabled || !n.cfg.OnFinish || d < time.Duration(n.cfg.MinSessionSeconds)*time.Second {
+		return
+	}
+	n.show(fmt.Sprintf("og session %s after %s", status, d.Round(time.Second)), query)
+}
+
+// show shows a notification unless the terminal has the focus.
+func (n *Notifier) show(title, body string) {
+	if focused() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	cmd := command(ctx, title, body)
+	if cmd == nil {
+		n.log.Warn("notifications are not supported on "+runtime.GOOS, "title", title)
+		return
+	}
+	if out, err := cmd.CombinedOutput(); err != nil {
+		n.log.Warn("failed to show a notification", "title", title, "error", err.Error(), "output", strings.TrimSpace(string(out)))
+		return
+	}
+	n.log.Debug("notification shown", "title", title)
+}
+
+// toastScript shows a toast with the title and body of the environment
+// variables OG_NOTIFY_TITLE and OG_NOTIFY_BODY, which spares quoting them.
+const toastScript = `[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$xml = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $xml.GetElementsByTagName('text')
+$text.Item(0).AppendChild($xml.CreateTextNode($env:OG_NOTIFY_TITLE)) > $null
+$text.Item(1).AppendChild($xml.CreateTextNode($env:OG_NOTIFY_BODY)) > $null
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('og').Show([Windows.UI.Notifications.ToastNotification]::new($xml))`
+
+// command returns the command that shows a notification on this OS, or nil.
+func command(ctx context.Context, title, body string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "linux", "freebsd", "openbsd", "netbsd":
+		return exec.CommandContext(ctx, "notify-send", "--app-name=og", "--", title, body)
+	case "darwin":
+		// The texts are passed as arguments, so that AppleScript does not parse them
+		return exec.CommandContext(ctx, "osascript",
+			"-e", "on run argv", "-e", "display notification (item 2 of argv) with title (item 1 of argv)", "-e", "end run",
+			title, body)
+	case "windows":
+		cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-Command", toastScript)
+		cmd.Env = append(os.Environ(), "OG_NOTIFY_TITLE="+title, "OG_NOTIFY_BODY="+body)
+		return cmd
+	}
+	return nil
+}
+
+// focused reports whether the terminal og runs in has the focus. It can tell
+// under X11 when xdotool is installed and the terminal sets WINDOWID, and on
+// macOS for the terminals that set TERM_PROGRAM; otherwise it assumes the
+// terminal does not.
+func focused() bool {
+	ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+	defer cancel()
+	switch runtime.GOOS {
+	case "darwin":
+		program := normalize(os.Getenv("TERM_PROGRAM"))
+		if program == "" {
+			return false
+		}
+		out, err := exec.CommandContext(ctx, "osascript", "-e",
+			`tell application "System Events" to get name of first application process whose frontmost is true`).Output()
+		if err != nil {
+			return false
+		}
+		front := normalize(string(out))
+		return front != "" && (strings.Contains(front, program) || strings.Contains(program, front))
+	default:
+		window := os.Getenv("WINDOWID")
+		if window == "" || os.Getenv("DISPLAY") == "" {
+			return false
+		}
+		out, err := exec.CommandContext(ctx, "xdotool", "getactivewindow").Output()
+		return err == nil && strings.TrimSpace(string(out)) == window
+	}
+}
+
+// normalize reduces the name of a terminal application to compare it, e.g.
+// "Apple_Terminal" and "Terminal" to "terminal", "iTerm.app" to "iterm".
+func normalize(name string) string {
+	name = strings.ToLower(strings.TrimSpace(name))
+	name = strings.TrimSuffix(strings.TrimPrefix(name, "apple_"), ".app")
+	return name
+}
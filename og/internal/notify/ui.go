@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// UI returns u with its prompts reported to Waiting, so that the user hears
+// of a prompt left unanswered.
+func (n *Notifier) UI(u ui.UI) ui.UI {
+	if !n.cfg.Enabled || !n.cfg.OnApproval {
+		return u
+	}
+	return promptUI{u, n}
+}
+
+// promptUI notifies of the prompts of the UI it wraps. Each prompt defers the
+// function Waiting returns, which stops the notification once answered.
+type promptUI struct {
+	ui.UI
+	n *Notifier
+}
+
+func (p promptUI) PromptForApproval(message string) bool {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForApproval(message)
+}
+
+func (p promptUI) PromptForApprovalChoice(message string, allowAlways bool) ui.ApprovalChoice {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForApprovalChoice(message, allowAlways)
+}
+
+func (p promptUI) PromptForFailureChoice(message string) ui.FailureChoice {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForFailureChoice(message)
+}
+
+func (p promptUI) PromptForTimeoutChoice(message string) ui.TimeoutChoice {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForTimeoutChoice(message)
+}
+
+func (p promptUI) PromptForTypedConfirmation(message, command, phrase string) bool {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForTypedConfirmation(message, command, phrase)
+}
+
+func (p promptUI) PromptForInput(message string) (string, bool) {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForInput(message)
+}
+
+func (p promptUI) PromptForPathSelection(message string, paths []string) ([]string, bool) {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForPathSelection(message, paths)
+}
+
+func (p promptUI) PromptForRecipeSelection(message string, steps int) ([]int, bool) {
+	defer p.n.Waiting(message)()
+	return p.UI.PromptForRecipeSelection(message, steps)
+}
+
+func (p promptUI) PromptForEditor(files []string, editors []string) int {
+	defer p.n.Waiting("Open the written files in an editor?")()
+	return p.UI.PromptForEditor(files, editors)
+}
@@ -0,0 +1,274 @@
+package patch
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// FilePatch is the set of hunks for a single file in a unified diff.
+type FilePatch struct {
+	OldPath string // "" for a newly created file
+	NewPath string // "" for a deleted file
+	Hunks   []Hunk
+}
+
+// Path returns the path the patch applies to.
+func (fp FilePatch) Path() string {
+	if fp.NewPath != "" {
+		return fp.NewPath
+	}
+	return fp.OldPath
+}
+
+// Hunk is one @@ section of a unified diff.
+type Hunk struct {
+	OldStart int
+	Lines    []string // Each line keeps its ' ', '-' or '+' prefix
+}
+
+// Parse splits a unified diff into per-file patches.
+func Parse(diff string) ([]FilePatch, error) {
+	var patches []FilePatch
+	var cur *FilePatch
+	var hunk *Hunk
+
+	flushHunk := func() {
+		if cur != nil && hunk != nil {
+			cur.Hunks = append(cur.Hunks, *hunk)
+		}
+		hunk = nil
+	}
+	flushFile := func() {
+		flushHunk()
+		if cur != nil {
+			patches = append(patches, *cur)
+		}
+		cur = nil
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			flushFile()
+			cur = &FilePatch{OldPath: headerPath(line[4:])}
+		case strings.HasPrefix(line, "+++ ") && cur != nil && hunk == nil:
+			cur.NewPath = headerPath(line[4:])
+		case strings.HasPrefix(line, "@@"):
+			if cur == nil {
+				return nil, fmt.Errorf("hunk header without file header: %q", line)
+			}
+			flushHunk()
+			start, err := parseHunkHeader(line)
+			if err != nil {
+				return nil, err
+			}
+			hunk = &Hunk{OldStart: start}
+		case hunk != nil && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "+")):
+			hunk.Lines = append(hunk.Lines, line)
+		case hunk != nil && line == "":
+			hunk.Lines = append(hunk.Lines, " ") // Some generators drop the space on empty context lines
+		case strings.HasPrefix(line, `\ No newline at end of file`):
+			// Trailing-newline markers are ignored; files are always written with a final newline.
+		default:
+			// "diff --git", "index ..." and other preamble lines carry nothing we need.
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read diff: %w", err)
+	}
+	flushFile()
+	if len(patches) == 0 {
+		return nil, fmt.Errorf("no file changes found in diff")
+	}
+	return patches, nil
+}
+
+// headerPath strips timestamps and a/ b/ prefixes from a ---/+++ header; /dev/null becomes "".
+func headerPath(s string) string {
+	if i := strings.IndexByte(s, '\t'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSpace(s)
+	if s == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(s, "a/") || strings.HasPrefix(s, "b/") {
+		s = s[2:]
+	}
+	return s
+}
+
+// parseHunkHeader extracts the old start line from "@@ -l,s +l,s @@".
+func parseHunkHeader(line string) (int, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 3 || !strings.HasPrefix(fields[1], "-") {
+		return 0, fmt.Errorf("malformed hunk header: %q", line)
+	}
+	startStr, _, _ := strings.Cut(fields[1][1:], ",")
+	start, err := strconv.Atoi(startStr)
+	if err != nil {
+		return 0, fmt.Errorf("malformed hunk header: %q", line)
+	}
+	return start, nil
+}
+
+// Paths lists the files touched by a diff.
+func Paths(patches []FilePatch) []string {
+	paths := make([]string, 0, len(patches))
+	for _, p := range patches {
+		paths = append(paths, p.Path())
+	}
+	return paths
+}
+
+// Select returns the patches whose path is in paths, in their original order.
+func Select(patches []FilePatch, paths []string) []FilePatch {
+	var selected []FilePatch
+	for _, p := range patches {
+		for _, path := range paths {
+			if p.Path() == path {
+				selected = append(selected, p)
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// Apply applies all file patches relative to root. Every file is patched in memory
+// first, so nothing is written unless the whole diff applies cleanly.
+func Apply(root string, patches []FilePatch) error {
+	type result struct {
+		path    string
+		content []string
+		remove  bool
+	}
+	var results []result
+
+	for _, fp := range patches {
+		path, err := resolve(root, fp.Path())
+		if err != nil {
+			return err
+		}
+		var lines []string
+		if fp.OldPath != "" {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", fp.Path(), err)
+			}
+			lines = splitLines(string(data))
+		}
+		for i, h := range fp.Hunks {
+			lines, err = applyHunk(lines, h)
+			if err != nil {
+				return fmt.Errorf("%s: hunk %d: %w", fp.Path(), i+1, err)
+			}
+		}
+		results = append(results, result{path: path, content: lines, remove: fp.NewPath == ""})
+	}
+
+	for _, r := range results {
+		if r.remove {
+			if err := os.Remove(r.path); err != nil {
+				return fmt.Errorf("failed to delete %s: %w", r.path, err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", r.path, err)
+		}
+		mode := os.FileMode(0o644)
+		if info, err := os.Stat(r.path); err == nil {
+			mode = info.Mode().Perm()
+		}
+		content := strings.Join(r.content, "\n")
+		if len(r.content) > 0 {
+			content += "\n"
+		}
+		if err := os.WriteFile(r.path, []byte(content), mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", r.path, err)
+		}
+	}
+	return nil
+}
+
+// resolve joins a patch path to root, refusing paths that escape it.
+func resolve(root, p string) (string, error) {
+	if p == "" {
+		return "", fmt.Errorf("patch has no target path")
+	}
+	if filepath.IsAbs(p) {
+		return "", fmt.Errorf("refusing to patch absolute path %s", p)
+	}
+	full := filepath.Join(root, p)
+	rel, err := filepath.Rel(root, full)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("refusing to patch %s outside of %s", p, root)
+	}
+	return full, nil
+}
+
+func splitLines(s string) []string {
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// maxFuzz is how far from the stated line a hunk's context may be found.
+const maxFuzz = 200
+
+// applyHunk locates the hunk's old lines near OldStart and replaces them with its new lines.
+func applyHunk(lines []string, h Hunk) ([]string, error) {
+	var oldLines, newLines []string
+	for _, l := range h.Lines {
+		switch l[0] {
+		case ' ':
+			oldLines = append(oldLines, l[1:])
+			newLines = append(newLines, l[1:])
+		case '-':
+			oldLines = append(oldLines, l[1:])
+		case '+':
+			newLines = append(newLines, l[1:])
+		}
+	}
+
+	want := h.OldStart - 1
+	if len(oldLines) == 0 {
+		// Pure insertion (e.g. a new file): OldStart names the line after which to insert.
+		want = h.OldStart
+	}
+	if want < 0 {
+		want = 0
+	}
+	for delta := 0; delta <= maxFuzz; delta++ {
+		for _, pos := range []int{want - delta, want + delta} {
+			if pos < 0 || pos+len(oldLines) > len(lines) || !matchAt(lines, oldLines, pos) {
+				continue
+			}
+			out := make([]string, 0, len(lines)-len(oldLines)+len(newLines))
+			out = append(out, lines[:pos]...)
+			out = append(out, newLines...)
+			out = append(out, lines[pos+len(oldLines):]...)
+			return out, nil
+		}
+	}
+	return nil, fmt.Errorf("context does not match (expected near line %d)", h.OldStart)
+}
+
+func matchAt(lines, want []string, pos int) bool {
+	for i, w := range want {
+		if strings.TrimRight(lines[pos+i], "\r") != w {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,216 @@
+// Package plugins runs og's tool plugins ([plugins]): programs built with the
+// toolplugin package, whose tools og offers to the agent and calls on its
+// behalf once they were approved. The agent calls them as it calls the tools
+// of MCP servers, so they are offered in the same form.
+package plugins
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/go-plugin"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/mcp"
+	"github.com/robbiemu/original_gangster/og/toolplugin"
+)
+
+// DefaultTimeout bounds starting a plugin and each call when the plugin's
+// timeout_seconds is unset.
+const DefaultTimeout = 60 * time.Second
+
+// Plugin is a running tool plugin.
+type Plugin struct {
+	name    string
+	client  *plugin.Client
+	tool    *toolplugin.Client
+	info    mcp.Tool
+	timeout time.Duration
+}
+
+// Start starts the plugin name of cfg in workdir and asks it about its tool.
+func Start(name string, cfg config.PluginCfg, workdir string) (*Plugin, error) {
+	timeout := DefaultTimeout
+	if cfg.TimeoutSeconds > 0 {
+		timeout = time.Duration(cfg.TimeoutSeconds) * time.Second
+	}
+	cmd := exec.Command(cfg.Command[0], cfg.Command[1:]...)
+	cmd.Dir = workdir
+	cmd.Env = os.Environ()
+	for k, v := range cfg.Env {
+		cmd.Env = append(cmd.Env, k+"="+v)
+	}
+	client := plugin.NewClient(&plugin.ClientConfig{
+		HandshakeConfig:  toolplugin.Handshake,
+		Plugins:          plugin.PluginSet{toolplugin.PluginName: &toolplugin.Plugin{}},
+		Cmd:              cmd,
+		SkipHostEnv:      true, // cmd.Env has it, before cfg.Env
+		AllowedProtocols: []plugin.Protocol{plugin.ProtocolNetRPC},
+		StartTimeout:     timeout,
+		Logger:           hclog.NewNullLogger(),
+	})
+	p := &Plugin{name: name, client: client, timeout: timeout}
+	if err := p.connect(cfg.ReadOnly); err != nil {
+		client.Kill()
+		return nil, err
+	}
+	return p, nil
+}
+
+// connect dispenses the plugin's tool and reads what to offer the agent.
+func (p *Plugin) connect(readOnly bool) error {
+	rpcClient, err := p.client.Client()
+	if err != nil {
+		return fmt.Errorf("failed to start: %w", err)
+	}
+	raw, err := rpcClient.Dispense(toolplugin.PluginName)
+	if err != nil {
+		return fmt.Errorf("the plugin serves no tool: %w", err)
+	}
+	p.tool = raw.(*toolplugin.Client)
+	name, err := p.tool.Name()
+	if err != nil {
+		return err
+	}
+	if name == "" {
+		return fmt.Errorf("the plugin's tool has no name")
+	}
+	description, err := p.tool.Description()
+	if err != nil {
+		return err
+	}
+	if description == "" {
+		description = fmt.Sprintf("Tool %s of og plugin %s.", name, p.name)
+	}
+	schema, err := p.tool.Schema()
+	if err != nil {
+		return err
+	}
+	if len(schema) == 0 {
+		schema = json.RawMessage(`{"type":"object","properties":{}}`)
+	} else if !json.Valid(schema) {
+		return fmt.Errorf("the schema of tool %s is not JSON", name)
+	}
+	qualified := mcp.QualifiedName(p.name, name)
+	if name == p.name {
+		qualified = mcp.Identifier(name)
+	}
+	p.info = mcp.Tool{
+		Name:        qualified,
+		Server:      p.name,
+		Tool:        name,
+		Description: description,
+		InputSchema: schema,
+		ReadOnly:    readOnly,
+	}
+	return nil
+}
+
+// Name returns the plugin's name in [plugins].
+func (p *Plugin) Name() string {
+	return p.name
+}
+
+// Tool returns the plugin's tool as it is offered to the agent.
+func (p *Plugin) Tool() mcp.Tool {
+	return p.info
+}
+
+// Call runs the tool with args, a JSON object. A plugin that does not answer
+// in time is killed, so later calls fail.
+func (p *Plugin) Call(args json.RawMessage) (string, error) {
+	if len(args) == 0 {
+		args = json.RawMessage("{}")
+	}
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		output, err := p.tool.Execute(args)
+		done <- result{output, err}
+	}()
+	select {
+	case r := <-done:
+		return r.output, r.err
+	case <-time.After(p.timeout):
+		p.client.Kill()
+		return "", fmt.Errorf("plugin %s gave no answer within %s and was stopped", p.name, p.timeout)
+	}
+}
+
+// Close stops the plugin.
+func (p *Plugin) Close() {
+	p.client.Kill()
+}
+
+// Plugins are the tool plugins of a session.
+type Plugins struct {
+	plugins map[string]*Plugin // By the qualified name of their tool
+}
+
+// StartAll starts the plugins of cfgs in workdir. A plugin that cannot be
+// started is reported to failed and left out.
+func StartAll(cfgs map[string]config.PluginCfg, workdir string, failed func(name string, err error)) *Plugins {
+	all := &Plugins{plugins: make(map[string]*Plugin)}
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		p, err := Start(name, cfgs[name], workdir)
+		if err != nil {
+			failed(name, err)
+			continue
+		}
+		if _, ok := all.plugins[p.info.Name]; ok {
+			failed(name, fmt.Errorf("its tool's name %s is taken", p.info.Name))
+			p.Close()
+			continue
+		}
+		all.plugins[p.info.Name] = p
+	}
+	return all
+}
+
+// Tools returns the tool of every plugin, ordered by name.
+func (all *Plugins) Tools() []mcp.Tool {
+	tools := make([]mcp.Tool, 0, len(all.plugins))
+	for _, p := range all.plugins {
+		tools = append(tools, p.info)
+	}
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+	return tools
+}
+
+// Lookup returns the tool the agent calls name.
+func (all *Plugins) Lookup(name string) (mcp.Tool, bool) {
+	p, ok := all.plugins[name]
+	if !ok {
+		return mcp.Tool{}, false
+	}
+	return p.info, true
+}
+
+// Call calls tool with args, see Plugin.Call.
+func (all *Plugins) Call(tool mcp.Tool, args json.RawMessage) (string, error) {
+	p, ok := all.plugins[tool.Name]
+	if !ok {
+		return "", fmt.Errorf("unknown plugin tool %q", tool.Name)
+	}
+	return p.Call(args)
+}
+
+// Close stops every plugin.
+func (all *Plugins) Close() {
+	for _, p := range all.plugins {
+		p.Close()
+	}
+}
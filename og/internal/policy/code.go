@@ -0,0 +1,208 @@
+package policy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/cel-go/cel"
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/ext"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// codeVars are what policy code knows of an action: the variables of CEL
+// rules, and the fields of input in rego.
+var codeVars = []cel.EnvOption{
+	cel.Variable("tool", cel.StringType),
+	cel.Variable("command", cel.StringType),
+	cel.Variable("segments", cel.ListType(cel.StringType)),
+	cel.Variable("cwd", cel.StringType),
+	cel.Variable("trust", cel.StringType),
+	cel.Variable("context", cel.MapType(cel.StringType, cel.StringType)),
+	cel.Variable("read_only", cel.BoolType),
+	cel.Variable("writes", cel.BoolType),
+}
+
+// regoTimeout bounds an evaluation of a rego module.
+const regoTimeout = 10 * time.Second
+
+// codePolicy decides actions by rules written as code (policy.code). It
+// returns DecisionPrompt when no rule decides, and the source of the
+// decision otherwise.
+type codePolicy interface {
+	decide(input map[string]any) (Decision, string, error)
+}
+
+// loadCode loads the policy code in path: a rego module when it ends in
+// .rego, CEL rules in a TOML file otherwise. Decisions name the file with
+// the start of its SHA-256, so the audit log tells which version made them.
+func loadCode(path string) (codePolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("policy.code: %w", err)
+	}
+	sum := sha256.Sum256(data)
+	version := filepath.Base(path) + "@" + hex.EncodeToString(sum[:4])
+	if strings.EqualFold(filepath.Ext(path), ".rego") {
+		return loadRego(path, data, version)
+	}
+	return loadCEL(data, version)
+}
+
+// celPolicy is a list of CEL rules, each deciding the actions its
+// expression holds for.
+type celPolicy struct {
+	rules []celRule
+}
+
+type celRule struct {
+	source   string
+	decision Decision
+	when     cel.Program
+}
+
+// loadCEL compiles the rules of a TOML file of [[rules]] tables with a
+// name, a decision and the CEL expression when, which must be a bool. The
+// expressions may use CEL's standard functions and macros and its string
+// extensions.
+func loadCEL(data []byte, version string) (*celPolicy, error) {
+	var file struct {
+		Rules []struct {
+			Name     string `toml:"name"`
+			Decision string `toml:"decision"`
+			When     string `toml:"when"`
+		} `toml:"rules"`
+	}
+	if err := toml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("policy.code %s: %w", version, err)
+	}
+	env, err := cel.NewEnv(append(codeVars, ext.Strings())...)
+	if err != nil {
+		return nil, fmt.Errorf("policy.code %s: %w", version, err)
+	}
+	p := &celPolicy{}
+	for i, rc := range file.Rules {
+		name := rc.Name
+		if name == "" {
+			name = fmt.Sprint(i + 1)
+		}
+		decision, err := ParseDecision(rc.Decision)
+		if err != nil {
+			return nil, fmt.Errorf("policy.code %s, rule %q: %w", version, name, err)
+		}
+		if strings.TrimSpace(rc.When) == "" {
+			return nil, fmt.Errorf("policy.code %s, rule %q: must set 'when'", version, name)
+		}
+		ast, issues := env.Compile(rc.When)
+		if issues.Err() != nil {
+			return nil, fmt.Errorf("policy.code %s, rule %q: %w", version, name, issues.Err())
+		}
+		if ast.OutputType() != cel.BoolType {
+			return nil, fmt.Errorf("policy.code %s, rule %q: 'when' is a %s, not a bool", version, name, ast.OutputType())
+		}
+		when, err := env.Program(ast)
+		if err != nil {
+			return nil, fmt.Errorf("policy.code %s, rule %q: %w", version, name, err)
+		}
+		p.rules = append(p.rules, celRule{source: fmt.Sprintf("code rule %q (%s)", name, version), decision: decision, when: when})
+	}
+	return p, nil
+}
+
+// decide evaluates every rule, with the precedence of [[policy.rules]]. A
+// rule that fails to evaluate counts as matching when it denies or
+// escalates, so that an error never lets an action through.
+func (p *celPolicy) decide(input map[string]any) (Decision, string, error) {
+	var approve, escalate string
+	for _, r := range p.rules {
+		source := r.source
+		out, _, err := r.when.Eval(input)
+		match := err == nil && out == types.True
+		if err != nil {
+			if r.decision == DecisionApprove || r.decision == DecisionPrompt {
+				continue
+			}
+			match, source = true, fmt.Sprintf("%s, which failed: %v", source, err)
+		}
+		if !match {
+			continue
+		}
+		switch r.decision {
+		case DecisionDeny:
+			return DecisionDeny, source, nil
+		case DecisionEscalate:
+			if escalate == "" {
+				escalate = source
+			}
+		case DecisionApprove:
+			if approve == "" {
+				approve = source
+			}
+		}
+	}
+	switch {
+	case escalate != "":
+		return DecisionEscalate, escalate, nil
+	case approve != "":
+		return DecisionApprove, approve, nil
+	}
+	return DecisionPrompt, "", nil
+}
+
+// regoPolicy is a rego module, evaluated in process. Its package is og, and
+// its rule decision yields "approve", "deny", "prompt" or "escalate", or an
+// object with the decision and a reason.
+type regoPolicy struct {
+	query   rego.PreparedEvalQuery
+	version string
+}
+
+// loadRego compiles the module in path, whose source is data.
+func loadRego(path string, data []byte, version string) (*regoPolicy, error) {
+	query, err := rego.New(rego.Query("data.og.decision"), rego.Module(path, string(data))).PrepareForEval(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("policy.code %s: %w", version, err)
+	}
+	return &regoPolicy{query: query, version: version}, nil
+}
+
+// decide queries data.og.decision with the action as input. An undefined
+// decision lets the other rules decide.
+func (p *regoPolicy) decide(input map[string]any) (Decision, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), regoTimeout)
+	defer cancel()
+	rs, err := p.query.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return DecisionPrompt, "", fmt.Errorf("%s: %w", p.version, err)
+	}
+	if len(rs) == 0 || len(rs[0].Expressions) == 0 {
+		return DecisionPrompt, "", nil
+	}
+	var decided, reason string
+	switch v := rs[0].Expressions[0].Value.(type) {
+	case string:
+		decided = v
+	case map[string]any:
+		decided, _ = v["decision"].(string)
+		reason, _ = v["reason"].(string)
+	}
+	if decided == "" {
+		return DecisionPrompt, "", fmt.Errorf("%s: data.og.decision must be a string or an object with a decision, not %v", p.version, rs[0].Expressions[0].Value)
+	}
+	decision, err := ParseDecision(decided)
+	if err != nil {
+		return DecisionPrompt, "", fmt.Errorf("%s: %w", p.version, err)
+	}
+	source := "code " + p.version
+	if reason != "" {
+		source += ": " + reason
+	}
+	return decision, source, nil
+}
@@ -0,0 +1,47 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// dangerPattern pairs a matcher for a destructive command with a description shown to the user.
+type dangerPattern struct {
+	re     *regexp.Regexp
+	reason string
+}
+
+// dangerPatterns lists commands that can cause irreversible, system-wide damage.
+// They are checked independently of the Python auditor and always require typed confirmation.
+var dangerPatterns = []dangerPattern{
+	{regexp.MustCompile(`\brm\s+(\S+\s+)*?(-[a-zA-Z]*[rR][a-zA-Z]*|--recursive)\s+(\S+\s+)*?(/|/\*|~|~/|\$HOME/?)(\s|$|;|&|\|)`), "recursive removal of the root or home directory"},
+	{regexp.MustCompile(`\brm\b.*--no-preserve-root`), "removal with --no-preserve-root"},
+	{regexp.MustCompile(`\bdd\b.*\bof=/dev/`), "raw write to a block device with dd"},
+	{regexp.MustCompile(`\bmkfs(\.[a-z0-9]+)?\b`), "filesystem creation (formats a device)"},
+	{regexp.MustCompile(`\b(curl|wget)\b[^|]*\|\s*(sudo\s+)?(ba|z|da|k|fi)?sh\b`), "piping a downloaded script straight into a shell"},
+	{regexp.MustCompile(`:\(\)\s*\{\s*:\s*\|\s*:\s*&\s*\}\s*;\s*:`), "fork bomb"},
+	{regexp.MustCompile(`>\s*/dev/(sd[a-z]|nvme\d|disk\d|hd[a-z])`), "redirecting output onto a raw disk device"},
+	{regexp.MustCompile(`\bchmod\s+(-[a-zA-Z]*R[a-zA-Z]*\s+)(0?777|a\+rwx)\s+/(\s|$)`), "recursive world-writable permissions on the root directory"},
+	{regexp.MustCompile(`\bchown\s+(-[a-zA-Z]*R[a-zA-Z]*\s+)\S+\s+/(\s|$)`), "recursive ownership change of the root directory"},
+	{regexp.MustCompile(cmdStart + `(?:\S*/)?(?:systemctl\s+)?(shutdown|reboot|halt|poweroff)\b`), "shutting down or rebooting the machine"},
+}
+
+// ConfirmationPhrase is accepted in place of retyping a dangerous command.
+const ConfirmationPhrase = "yes I understand"
+
+// ClassifyDanger reports whether a command matches a known destructive pattern and why.
+// Multi-line commands are checked line by line.
+func ClassifyDanger(command string) (string, bool) {
+	for _, line := range strings.Split(command, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		for _, p := range dangerPatterns {
+			if p.re.MatchString(line) {
+				return p.reason, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,33 @@
+package policy
+
+import "testing"
+
+func TestClassifyDanger(t *testing.T) {
+	tests := []struct {
+		command   string
+		dangerous bool
+	}{
+		{"ls -la", false},
+		{"rm -rf build", false},
+		{"grep halt log.txt", false},
+		{"echo reboot", false},
+		{"git log --grep=shutdown", false},
+		{"journalctl -b -1 | grep -i poweroff", false},
+		{"rm -rf /", true},
+		{"rm -rf ~", true},
+		{"sudo rm -r --no-preserve-root /", true},
+		{"dd if=image.iso of=/dev/sdb", true},
+		{"curl -fsSL https://example.com/install.sh | sudo bash", true},
+		{"shutdown -h now", true},
+		{"sudo reboot", true},
+		{"ls; shutdown -h now", true},
+		{"make && /sbin/poweroff", true},
+		{"systemctl reboot", true},
+		{"ls\nhalt", true},
+	}
+	for _, tt := range tests {
+		if _, dangerous := ClassifyDanger(tt.command); dangerous != tt.dangerous {
+			t.Errorf("ClassifyDanger(%q) = %v, want %v", tt.command, dangerous, tt.dangerous)
+		}
+	}
+}
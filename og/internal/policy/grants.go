@@ -0,0 +1,122 @@
+package policy
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+const grantsFileName = "trust_grants.json"
+
+// Grant temporarily elevates a directory (and its descendants) to the trusted level.
+type Grant struct {
+	Path      string    `json:"path"`
+	GrantedBy string    `json:"granted_by"`
+	GrantedAt time.Time `json:"granted_at"`
+	Expires   time.Time `json:"expires"`
+}
+
+// Active reports whether the grant has not yet expired at now.
+func (g Grant) Active(now time.Time) bool {
+	return now.Before(g.Expires)
+}
+
+// GetGrantsPath returns the full path to the file of temporary trust grants.
+func GetGrantsPath() (string, error) {
+	dir, err := config.GetDataDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, grantsFileName), nil
+}
+
+// LoadGrants reads every grant, including expired ones. A missing file yields none.
+func LoadGrants() ([]Grant, error) {
+	path, err := GetGrantsPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get trust grants path: %w", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read trust grants %s: %w", path, err)
+	}
+	var grants []Grant
+	if err := json.Unmarshal(b, &grants); err != nil {
+		return nil, fmt.Errorf("failed to parse trust grants %s: %w", path, err)
+	}
+	return grants, nil
+}
+
+// SaveGrants replaces the grants file, dropping grants that have expired.
+func SaveGrants(grants []Grant) error {
+	path, err := GetGrantsPath()
+	if err != nil {
+		return fmt.Errorf("failed to get trust grants path: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create trust grants directory: %w", err)
+	}
+	now := time.Now()
+	active := []Grant{}
+	for _, g := range grants {
+		if g.Active(now) {
+			active = append(active, g)
+		}
+	}
+	b, err := json.MarshalIndent(active, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal trust grants: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o600); err != nil {
+		return fmt.Errorf("failed to write trust grants %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace trust grants %s: %w", path, err)
+	}
+	return nil
+}
+
+// NewGrant creates a grant for dir lasting d, with the path normalized the same
+// way as the [trust] path lists.
+func NewGrant(dir, user string, d time.Duration) Grant {
+	now := time.Now()
+	return Grant{Path: normalizePath(dir), GrantedBy: user, GrantedAt: now, Expires: now.Add(d)}
+}
+
+// ActiveGrant returns the active grant covering dir, if any. When several apply,
+// the most specific one wins.
+func ActiveGrant(grants []Grant, dir string) (Grant, bool) {
+	dir = normalizePath(dir)
+	now := time.Now()
+	var best Grant
+	found := false
+	for _, g := range grants {
+		if !g.Active(now) || !isWithin(dir, g.Path) {
+			continue
+		}
+		if !found || len(g.Path) > len(best.Path) {
+			best, found = g, true
+		}
+	}
+	return best, found
+}
+
+// Elevate applies temporary grants to a resolved trust level. Directories configured
+// as untrusted are never elevated.
+func Elevate(level TrustLevel, grants []Grant, dir string) (TrustLevel, *Grant) {
+	if level != TrustDefault {
+		return level, nil
+	}
+	if g, ok := ActiveGrant(grants, dir); ok {
+		return TrustTrusted, &g
+	}
+	return level, nil
+}
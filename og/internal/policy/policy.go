@@ -0,0 +1,390 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/cloud"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// Decision is the outcome of evaluating an action against the policy.
+type Decision int
+
+const (
+	DecisionPrompt   Decision = iota // Fall back to asking the user
+	DecisionApprove                  // Approve without asking
+	DecisionDeny                     // Deny without asking
+	DecisionEscalate                 // Ask the user, then require a second, designated approver
+)
+
+// String returns the string representation of the Decision.
+func (d Decision) String() string {
+	switch d {
+	case DecisionPrompt:
+		return "prompt"
+	case DecisionApprove:
+		return "approve"
+	case DecisionDeny:
+		return "deny"
+	case DecisionEscalate:
+		return "escalate"
+	default:
+		return fmt.Sprintf("UNKNOWN_DECISION(%d)", d)
+	}
+}
+
+// ParseDecision converts a string from the config into a Decision.
+func ParseDecision(s string) (Decision, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "prompt", "ask":
+		return DecisionPrompt, nil
+	case "approve", "allow":
+		return DecisionApprove, nil
+	case "deny":
+		return DecisionDeny, nil
+	case "escalate", "second_approver":
+		return DecisionEscalate, nil
+	default:
+		return DecisionPrompt, fmt.Errorf("unknown policy decision '%s'", s)
+	}
+}
+
+// Action is a single proposed tool invocation.
+type Action struct {
+	Tool    string
+	Command string
+}
+
+// Result carries the decision together with a human-readable reason.
+type Result struct {
+	Decision Decision
+	Reason   string
+}
+
+// rule is a compiled matcher. An empty tool matches any tool, a nil pattern matches any command.
+// Shorthand rules match when either the tool name or the command matches. Context patterns
+// must all match the active cloud contexts; a context that is not set never matches.
+type rule struct {
+	tool      string
+	pattern   *regexp.Regexp
+	context   map[string]*regexp.Regexp
+	decision  Decision
+	source    string
+	shorthand bool
+}
+
+func (r rule) matches(a Action, cloudContext map[string]string) bool {
+	if r.shorthand {
+		return r.tool == a.Tool || r.pattern.MatchString(strings.TrimSpace(a.Command))
+	}
+	if r.tool != "" && r.tool != a.Tool {
+		return false
+	}
+	if r.pattern != nil && !r.pattern.MatchString(strings.TrimSpace(a.Command)) {
+		return false
+	}
+	for key, pattern := range r.context {
+		v, ok := cloudContext[key]
+		if !ok || !pattern.MatchString(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// Engine evaluates actions against the configured policy rules.
+type Engine struct {
+	rules    []rule
+	trust    TrustLevel
+	cloud    map[string]string // Active cloud contexts, see SetCloudContext
+	readOnly bool              // See SetReadOnly
+	code     codePolicy        // Rules written as code (policy.code)
+	workdir  string            // The directory steps run in, as policy code sees it, see SetWorkdir
+}
+
+// New compiles the policy section of the config into an Engine for a workspace
+// with the given trust level.
+func New(cfg config.PolicyCfg, trust TrustLevel) (*Engine, error) {
+	e := &Engine{trust: trust}
+
+	// Shorthand lists: a bare word is a tool name, anything else is a command glob.
+	for _, entry := range cfg.AlwaysDeny {
+		e.rules = append(e.rules, shorthandRule(entry, DecisionDeny, "always_deny"))
+	}
+	for _, entry := range cfg.RequireSecondApprover {
+		e.rules = append(e.rules, shorthandRule(entry, DecisionEscalate, "require_second_approver"))
+	}
+	for _, entry := range cfg.AutoApprove {
+		e.rules = append(e.rules, shorthandRule(entry, DecisionApprove, "auto_approve"))
+	}
+	if trust == TrustTrusted {
+		for _, entry := range cfg.TrustedAutoApprove {
+			e.rules = append(e.rules, shorthandRule(entry, DecisionApprove, "trusted_auto_approve"))
+		}
+	}
+
+	for i, rc := range cfg.Rules {
+		decision, err := ParseDecision(rc.Decision)
+		if err != nil {
+			return nil, fmt.Errorf("policy rule %d: %w", i+1, err)
+		}
+		r := rule{tool: rc.Tool, decision: decision, source: fmt.Sprintf("rule %d", i+1)}
+		switch {
+		case rc.Regex != "" && rc.Command != "":
+			return nil, fmt.Errorf("policy rule %d: 'command' and 'regex' are mutually exclusive", i+1)
+		case rc.Regex != "":
+			re, err := regexp.Compile(rc.Regex)
+			if err != nil {
+				return nil, fmt.Errorf("policy rule %d: invalid regex '%s': %w", i+1, rc.Regex, err)
+			}
+			r.pattern = re
+		case rc.Command != "":
+			r.pattern = globToRegexp(rc.Command)
+		}
+		if len(rc.Context) > 0 {
+			r.context = make(map[string]*regexp.Regexp, len(rc.Context))
+			var conditions []string
+			for _, key := range cloud.Keys {
+				if glob, ok := rc.Context[key]; ok {
+					r.context[key] = globToRegexp(glob)
+					conditions = append(conditions, key+" '"+glob+"'")
+				}
+			}
+			if len(r.context) != len(rc.Context) {
+				return nil, fmt.Errorf("policy rule %d: unknown context key (use %s)", i+1, strings.Join(cloud.Keys, ", "))
+			}
+			r.source += " (" + strings.Join(conditions, ", ") + ")"
+		}
+		if r.tool == "" && r.pattern == nil && r.context == nil {
+			return nil, fmt.Errorf("policy rule %d: must set at least one of 'tool', 'command', 'regex' or 'context'", i+1)
+		}
+		e.rules = append(e.rules, r)
+	}
+	if cfg.Code != "" {
+		code, err := loadCode(cfg.Code)
+		if err != nil {
+			return nil, err
+		}
+		e.code = code
+	}
+	return e, nil
+}
+
+// shorthandRule builds a rule from an auto_approve/always_deny list entry,
+// which matches either a tool name exactly or a command glob.
+func shorthandRule(entry string, decision Decision, list string) rule {
+	entry = strings.TrimSpace(entry)
+	return rule{
+		tool:      entry,
+		pattern:   globToRegexp(entry),
+		decision:  decision,
+		source:    fmt.Sprintf("%s '%s'", list, entry),
+		shorthand: true,
+	}
+}
+
+// globToRegexp converts a command glob ('*' any run of characters, '?' a single character)
+// into an anchored regular expression.
+func globToRegexp(glob string) *regexp.Regexp {
+	var sb strings.Builder
+	sb.WriteString("^")
+	for _, r := range strings.TrimSpace(glob) {
+		switch r {
+		case '*':
+			sb.WriteString(".*")
+		case '?':
+			sb.WriteString(".")
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	sb.WriteString("$")
+	return regexp.MustCompile(sb.String())
+}
+
+// SetCloudContext sets the active cloud contexts (see cloud.Context.Values) that
+// rules with a context condition are matched against.
+func (e *Engine) SetCloudContext(values map[string]string) {
+	if e != nil {
+		e.cloud = values
+	}
+}
+
+// SetWorkdir sets the directory steps run in, which policy code sees as cwd.
+func (e *Engine) SetWorkdir(dir string) {
+	if e != nil {
+		e.workdir = dir
+	}
+}
+
+// SetReadOnly makes the engine deny every action that writes, deletes or
+// changes state elsewhere (see ClassifyWrite), before any rule is consulted.
+func (e *Engine) SetReadOnly(on bool) {
+	if e != nil {
+		e.readOnly = on
+	}
+}
+
+// ReadOnly reports whether the engine is in read-only mode.
+func (e *Engine) ReadOnly() bool {
+	return e != nil && e.readOnly
+}
+
+// TrustLevel returns the trust level the engine was created for.
+func (e *Engine) TrustLevel() TrustLevel {
+	if e == nil {
+		return TrustDefault
+	}
+	return e.trust
+}
+
+// Evaluate returns the policy decision for a single action.
+// Deny rules always win, then escalation, then approve rules; if nothing matches,
+// the user is prompted. Policy code counts as rules after the others, and an
+// action it fails to evaluate is denied. In untrusted workspaces approve rules are
+// ignored. In read-only mode, actions that write are denied first.
+// A shell command is decided by the simple commands it chains (see Segments):
+// it is denied if any of them is, escalated if any is, and approved only if
+// every one is. Deny and escalate rules are matched against the whole command
+// too, so that a rule spanning a pipe or a chain, such as curl .*\| *sh,
+// still matches. Policy code decides the action as a whole, given both the
+// command and its parts; its approval approves every part.
+func (e *Engine) Evaluate(a Action) Result {
+	if e == nil {
+		return Result{Decision: DecisionPrompt}
+	}
+	if e.readOnly {
+		if reason, writes := classifyReadOnly(a); writes {
+			return Result{Decision: DecisionDeny, Reason: "denied in read-only mode: " + reason}
+		}
+	}
+	var escalated *Result
+	switch deny, escalate, _ := e.matchRules(Action{Tool: a.Tool, Command: strings.TrimSpace(a.Command)}); {
+	case deny != "":
+		return Result{Decision: DecisionDeny, Reason: "denied by policy " + deny}
+	case escalate != "":
+		escalated = &Result{Decision: DecisionEscalate, Reason: "second approver required by policy " + escalate}
+	}
+	segs := segments(a)
+	var approved []string
+	approvedAll := escalated == nil
+	for _, seg := range segs {
+		deny, escalate, approve := e.matchRules(Action{Tool: a.Tool, Command: seg})
+		switch {
+		case deny != "":
+			return Result{Decision: DecisionDeny, Reason: "denied by policy " + deny}
+		case escalate != "":
+			if escalated == nil {
+				escalated = &Result{Decision: DecisionEscalate, Reason: "second approver required by policy " + escalate}
+			}
+			approvedAll = false
+		case approve != "":
+			if reason := "approved by policy " + approve; !slices.Contains(approved, reason) {
+				approved = append(approved, reason)
+			}
+		default:
+			approvedAll = false
+		}
+	}
+	var codeApproved string
+	if e.code != nil {
+		decision, source, err := e.code.decide(e.codeInput(a, segs))
+		switch {
+		case err != nil:
+			return Result{Decision: DecisionDeny, Reason: "denied as policy code failed: " + err.Error()}
+		case decision == DecisionDeny:
+			return Result{Decision: DecisionDeny, Reason: "denied by policy " + source}
+		case decision == DecisionEscalate && escalated == nil:
+			escalated = &Result{Decision: DecisionEscalate, Reason: "second approver required by policy " + source}
+		case decision == DecisionApprove:
+			codeApproved = source
+		}
+	}
+	switch {
+	case escalated != nil:
+		return *escalated
+	case e.trust == TrustUntrusted:
+		// Approvals are ignored
+	case approvedAll:
+		return Result{Decision: DecisionApprove, Reason: strings.Join(approved, "; ")}
+	case codeApproved != "":
+		return Result{Decision: DecisionApprove, Reason: "approved by policy " + codeApproved}
+	}
+	return Result{Decision: DecisionPrompt}
+}
+
+// matchRules returns the sources of the first deny, escalate and approve
+// rules that match a, each "" when none does.
+func (e *Engine) matchRules(a Action) (deny, escalate, approve string) {
+	for _, r := range e.rules {
+		if !r.matches(a, e.cloud) {
+			continue
+		}
+		switch r.decision {
+		case DecisionDeny:
+			return r.source, "", ""
+		case DecisionEscalate:
+			if escalate == "" {
+				escalate = r.source
+			}
+		case DecisionApprove:
+			if approve == "" {
+				approve = r.source
+			}
+		}
+	}
+	return "", escalate, approve
+}
+
+// codeInput is what policy code knows of a, whose command has the parts
+// segs, see codeVars.
+func (e *Engine) codeInput(a Action, segs []string) map[string]any {
+	cloudContext := make(map[string]string, len(cloud.Keys))
+	for _, k := range cloud.Keys {
+		cloudContext[k] = e.cloud[k] // "" when not set
+	}
+	_, writes := classifyReadOnly(a)
+	return map[string]any{
+		"tool":      a.Tool,
+		"command":   strings.TrimSpace(a.Command),
+		"segments":  segs,
+		"cwd":       e.workdir,
+		"trust":     e.trust.String(),
+		"context":   cloudContext,
+		"read_only": e.readOnly,
+		"writes":    writes,
+	}
+}
+
+// EvaluateAll evaluates a group of actions (e.g. the steps of a recipe) as a whole.
+// Any denial denies the group and any escalation escalates it; the group is approved
+// only if every action is approved. Each action is decided by Evaluate, so
+// multi-line and chained commands are decided by every command they run.
+func (e *Engine) EvaluateAll(actions []Action) Result {
+	approvedAll := len(actions) > 0
+	var escalated *Result
+	for _, a := range actions {
+		res := e.Evaluate(a)
+		switch res.Decision {
+		case DecisionDeny:
+			return res
+		case DecisionEscalate:
+			if escalated == nil {
+				escalated = &res
+			}
+			approvedAll = false
+		case DecisionPrompt:
+			approvedAll = false
+		}
+	}
+	if escalated != nil {
+		return *escalated
+	}
+	if approvedAll {
+		return Result{Decision: DecisionApprove, Reason: "every step approved by policy"}
+	}
+	return Result{Decision: DecisionPrompt}
+}
@@ -0,0 +1,106 @@
+package policy
+
+import (
+	"regexp"
+	"strings"
+)
+
+// cmdStart matches where a command starts: at the beginning of the line or
+// after a separator, and behind wrappers such as sudo or xargs.
+const cmdStart = `(?:^|[;&|(` + "`" + `]|\$\()\s*(?:(?:sudo|doas|xargs|env|nohup|time|command|exec)\s+(?:-\S+\s+)*)*`
+
+// writePatterns lists commands that change files, installed software, services
+// or remote state. They are what --read-only denies; like dangerPatterns they
+// are heuristics, matched line by line with quoted text left out. Because
+// of that, a shell or interpreter given code to run, as with bash -c or a
+// pipe into sh, counts as writing whatever the code does.
+var writePatterns = []dangerPattern{
+	{regexp.MustCompile(cmdStart + `(rm|rmdir|unlink|shred|mv|cp|install|rsync|ln|mkdir|touch|truncate|chmod|chown|chgrp|chattr|setfacl|patch|mkfs(\.\w+)?)\b`), "changes or removes files"},
+	{regexp.MustCompile(cmdStart + `tee(\s+-\S+)*\s+[^\s|;&-]`), "writes a file with tee"},
+	{regexp.MustCompile(`\b(sed|perl|ruby)\s+(\S+\s+)*?(-[a-zA-Z]*i|--in-place)\b`), "edits files in place"},
+	{regexp.MustCompile(`\bdd\b.*\bof=`), "writes with dd"},
+	{regexp.MustCompile(`\bfind\b.*\s-(delete|exec(dir)?\s+(rm|mv|chmod|chown)\b)`), "removes or changes the files find finds"},
+	{regexp.MustCompile(`\bgit\s+(-[Cc]\s+\S+\s+|--?\S+\s+)*(add|commit|push|pull|fetch|merge|rebase|reset|checkout|switch|restore|rm|mv|clean|stash|tag|cherry-pick|revert|am|apply|init|clone|gc|prune)\b`), "changes a git repository"},
+	{regexp.MustCompile(`\b(apt|apt-get|aptitude|yum|dnf|zypper|pacman|apk|brew|port|snap|flatpak|pip3?|pipx|uv|poetry|npm|pnpm|yarn|gem|cargo|go|conda|mamba)\s+(\S+\s+)*?(install|uninstall|reinstall|remove|purge|upgrade|update|add|get|sync|-S\w*|-R\w*)\b`), "installs or removes software"},
+	{regexp.MustCompile(`\bcurl\b.*\s(-X\s*(POST|PUT|PATCH|DELETE)|--request\s+(POST|PUT|PATCH|DELETE)|-d\b|--data(-\w+)?\b|-F\b|--form\b|-T\b|--upload-file\b|-o\b|-O\b|--output\b|--remote-name\b)`), "sends data with curl or saves a download"},
+	{regexp.MustCompile(`\bwget\b`), "downloads files with wget"},
+	{regexp.MustCompile(`\bhttps?\s+(POST|PUT|PATCH|DELETE)\b`), "sends a mutating HTTP request"},
+	{regexp.MustCompile(`\bkubectl\s+(\S+\s+)*?(apply|create|delete|patch|replace|scale|edit|label|annotate|set|drain|cordon|uncordon|taint|rollout\s+(restart|undo|pause|resume))\b`), "changes a Kubernetes cluster"},
+	{regexp.MustCompile(`\bhelm\s+(install|upgrade|uninstall|delete|rollback)\b`), "changes a Helm release"},
+	{regexp.MustCompile(`\b(terraform|tofu|terragrunt)\s+(\S+\s+)*?(apply|destroy|import|taint|untaint|state\s+(rm|mv|push))\b`), "changes infrastructure"},
+	{regexp.MustCompile(`\bpulumi\s+(up|destroy|import|refresh)\b`), "changes infrastructure"},
+	{regexp.MustCompile(`\b(docker|podman)\s+(compose\s+(up|down|rm|build|pull|push|restart|stop|start)|run|rm|rmi|build|push|pull|stop|kill|start|restart|create|exec|commit|tag|prune|system\s+prune|volume\s+(rm|create|prune)|network\s+(rm|create|prune))\b`), "changes containers or images"},
+	{regexp.MustCompile(`\bsystemctl\s+(\S+\s+)*?(start|stop|restart|reload|enable|disable|mask|unmask|kill)\b`), "changes a service"},
+	{regexp.MustCompile(`\bservice\s+\S+\s+(start|stop|restart|reload)\b`), "changes a service"},
+	{regexp.MustCompile(cmdStart + `(kill|pkill|killall|crontab\s+(-\S+\s+)*-[er]|shutdown|reboot|halt|poweroff|useradd|userdel|usermod|passwd)\b`), "changes processes or the system"},
+	{regexp.MustCompile(cmdStart + `(?:\S*/)?(sh|bash|zsh|dash|ksh|fish|csh|tcsh)\s+(-\S+\s+)*-[a-zA-Z]*c\b`), "runs a shell command"},
+	{regexp.MustCompile(cmdStart + `(?:\S*/)?(python[0-9.]*|perl|ruby|node|deno|bun|php|lua)\s+(-\S+\s+)*(-[a-zA-Z]*[ceEr]\b|--eval\b)`), "runs inline code"},
+	{regexp.MustCompile(cmdStart + `eval\b`), "runs a command built at run time"},
+	{regexp.MustCompile(`\|\s*(?:(?:sudo|doas|env|exec)\s+(?:-\S+\s+)*)*(?:\S*/)?((sh|bash|zsh|dash|ksh|fish|csh|tcsh)\b|(python[0-9.]*|perl|ruby|node|php|lua)\s*(-\s*)?($|[;&|)]))`), "pipes commands into a shell or interpreter"},
+	{regexp.MustCompile(`\baws\s+\S+\s+(create|delete|put|update|terminate|run|start|stop|reboot|modify|attach|detach|remove|cp|mv|rm|sync|mb|rb|invoke|deploy)\b`), "changes AWS resources"},
+	{regexp.MustCompile(`\b(gcloud|az)\s+(\S+\s+)+?(create|delete|update|deploy|set|start|stop|restart|reset|resize|remove|add)\b`), "changes cloud resources"},
+}
+
+// quoted matches quoted strings, whose contents are not commands, except
+// for the command substitutions of double-quoted ones.
+var quoted = regexp.MustCompile(`'[^']*'|"(?:[^"\\]|\\.)*"`)
+
+// unquote blanks a quoted string out, unless it substitutes a command.
+func unquote(s string) string {
+	if s[0] == '"' && (strings.Contains(s, "$(") || strings.Contains(s, "`")) {
+		return " " + s[1:len(s)-1] + " "
+	}
+	return "''"
+}
+
+// redirect matches an output redirection and its target.
+var redirect = regexp.MustCompile(`(^|[^<>&0-9])[0-9&]?>>?\|?\s*([^\s;&|)]+)`)
+
+// ClassifyWrite reports whether a command looks like it writes, deletes or
+// changes state elsewhere, and how. Multi-line commands are checked line by
+// line.
+func ClassifyWrite(command string) (string, bool) {
+	for _, line := range strings.Split(command, "\n") {
+		line = strings.TrimSpace(quoted.ReplaceAllStringFunc(line, unquote))
+		if line == "" {
+			continue
+		}
+		for _, p := range writePatterns {
+			if p.re.MatchString(line) {
+				return p.reason, true
+			}
+		}
+		for _, m := range redirect.FindAllStringSubmatch(line, -1) {
+			switch target := m[2]; {
+			case strings.HasPrefix(target, "&"), target == "/dev/null", target == "/dev/stdout", target == "/dev/stderr":
+			default:
+				return "redirects output into a file", true
+			}
+		}
+	}
+	return "", false
+}
+
+// writingTools are tools that only change files.
+var writingTools = map[string]string{
+	"patch_tool":  "patches files",
+	"apply_patch": "patches files",
+	"mcp_tool":    "calls an MCP tool that is not marked read-only",
+	"plugin_tool": "calls a plugin tool that is not marked read-only",
+}
+
+// classifyReadOnly reports why an action is not allowed in read-only mode.
+// SQL queries are not classified: read-only mode runs them in read-only
+// transactions instead. MCP tools are, by whether their server marks them
+// read-only (mcp_read_tool) or not (mcp_tool), and plugin tools likewise by
+// plugins.<name>.read_only (plugin_read_tool or plugin_tool).
+func classifyReadOnly(a Action) (string, bool) {
+	if reason, ok := writingTools[a.Tool]; ok {
+		return reason, true
+	}
+	switch a.Tool {
+	case "sql_query_tool", "mcp_read_tool", "plugin_read_tool":
+		return "", false
+	}
+	return ClassifyWrite(a.Command)
+}
@@ -0,0 +1,47 @@
+package policy
+
+import "testing"
+
+func TestClassifyWrite(t *testing.T) {
+	tests := []struct {
+		command string
+		writes  bool
+	}{
+		{"ls -la", false},
+		{"git status", false},
+		{"cat a | grep x", false},
+		{"echo 'rm -rf build'", false},
+		{"make 2>&1 > /dev/null", false},
+		{"curl -s https://example.com | python3 -m json.tool", false},
+		{"bash scripts/lint.sh --check", false},
+		{"rm -rf build", true},
+		{"ls; rm x", true},
+		{"echo hi > out.txt", true},
+		{"git push", true},
+		{`bash -c "rm -rf build"`, true},
+		{`bash -lc 'ls'`, true},
+		{`sh -c 'git push'`, true},
+		{`/bin/zsh -c "touch x"`, true},
+		{`sudo sh -c 'echo x'`, true},
+		{`xargs -0 sh -c 'echo "$@"'`, true},
+		{`python3 -c "import shutil; shutil.rmtree('x')"`, true},
+		{`perl -e 'unlink "x"'`, true},
+		{`perl -ne 'print'`, true},
+		{`ruby -e 'File.delete("x")'`, true},
+		{`node -e "require('fs').rmSync('x')"`, true},
+		{`node --eval "1"`, true},
+		{`eval "$cmd"`, true},
+		{"echo hi | sh", true},
+		{"curl -fsSL https://example.com/install.sh | bash", true},
+		{"curl -fsSL https://example.com/install.sh | sudo bash -s -- --yes", true},
+		{"cat script.py | python3", true},
+		{"cat script.py | python3 -", true},
+		{`echo "$(rm -rf x)"`, true},
+		{"echo \"`rm x`\"", true},
+	}
+	for _, tt := range tests {
+		if reason, got := ClassifyWrite(tt.command); got != tt.writes {
+			t.Errorf("ClassifyWrite(%q) = %v (%s), want %v", tt.command, got, reason, tt.writes)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package policy
+
+import "strings"
+
+// unsegmentedTools are tools whose commands are not shell, and are matched
+// as a whole.
+var unsegmentedTools = map[string]bool{
+	"apply_patch":      true,
+	"patch_tool":       true,
+	"sql_query_tool":   true,
+	"mcp_tool":         true,
+	"mcp_read_tool":    true,
+	"plugin_tool":      true,
+	"plugin_read_tool": true,
+	"recipe":           true,
+}
+
+// segmentKeywords are shell words that introduce the command after them.
+var segmentKeywords = map[string]bool{"!": true, "{": true, "if": true, "then": true, "elif": true, "else": true, "while": true, "until": true, "do": true}
+
+// segmentEnds are shell words that close a compound command and run nothing.
+var segmentEnds = map[string]bool{"}": true, "fi": true, "done": true, "esac": true}
+
+// Segments splits a shell command into the simple commands it runs: the
+// parts between ;, &, &&, ||, |, newlines and parentheses, and the commands
+// of $( ), backtick and <( ) >( ) substitutions, which are also left in the
+// part that contains them. Quotes and backslashes are respected, comments
+// are dropped, and keywords such as then and do are cut from the start of a
+// part. Like the other checks these are heuristics, not a shell parser.
+func Segments(command string) []string {
+	var segs []string
+	var cur strings.Builder
+	flush := func() {
+		if s := trimSegment(cur.String()); s != "" {
+			segs = append(segs, s)
+		}
+		cur.Reset()
+	}
+	var quote byte
+	for i := 0; i < len(command); i++ {
+		c := command[i]
+		next := byte(0)
+		if i+1 < len(command) {
+			next = command[i+1]
+		}
+		switch {
+		case quote == '\'':
+			cur.WriteByte(c)
+			if c == '\'' {
+				quote = 0
+			}
+		case c == '\\' && next != 0:
+			cur.WriteString(command[i : i+2])
+			i++
+		case next == '(' && (c == '$' || quote == 0 && (c == '<' || c == '>')):
+			end := closingParen(command, i+2)
+			segs = append(segs, Segments(command[i+2:end])...)
+			cur.WriteString(command[i:min(end+1, len(command))])
+			i = end
+		case c == '`':
+			end := strings.IndexByte(command[i+1:], '`')
+			if end < 0 {
+				end = len(command) - i - 1
+			}
+			segs = append(segs, Segments(command[i+1:i+1+end])...)
+			cur.WriteString(command[i:min(i+2+end, len(command))])
+			i += end + 1
+		case quote == '"':
+			cur.WriteByte(c)
+			if c == '"' {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+			cur.WriteByte(c)
+		case c == '#' && (cur.Len() == 0 || strings.HasSuffix(cur.String(), " ") || strings.HasSuffix(cur.String(), "\t")):
+			for i+1 < len(command) && command[i+1] != '\n' {
+				i++
+			}
+		case c == '&' && (next == '>' || strings.HasSuffix(cur.String(), ">") || strings.HasSuffix(cur.String(), "<")),
+			c == '|' && strings.HasSuffix(cur.String(), ">"):
+			cur.WriteByte(c) // A redirection: &>, >&2, <&3, >|
+		case strings.IndexByte(";&|\n()", c) >= 0:
+			flush()
+		default:
+			cur.WriteByte(c)
+		}
+	}
+	flush()
+	return segs
+}
+
+// closingParen returns the index of the parenthesis that closes the one
+// before start, or len(s) when there is none.
+func closingParen(s string, start int) int {
+	depth := 1
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case c == '\\':
+			i++
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth == 0 {
+				return i
+			}
+		}
+	}
+	return len(s)
+}
+
+// trimSegment trims a part of a command and the keywords it starts with,
+// and drops the parts that only close a compound command.
+func trimSegment(s string) string {
+	s = strings.TrimSpace(s)
+	for {
+		word, rest, _ := strings.Cut(s, " ")
+		if !segmentKeywords[word] {
+			break
+		}
+		s = strings.TrimSpace(rest)
+	}
+	if segmentEnds[s] {
+		return ""
+	}
+	return s
+}
+
+// segments returns the parts of a's command the rules are matched against:
+// the simple commands of a shell command, or the whole command of a tool
+// that does not run shell.
+func segments(a Action) []string {
+	command := strings.TrimSpace(a.Command)
+	if unsegmentedTools[a.Tool] {
+		return []string{command}
+	}
+	segs := Segments(command)
+	if len(segs) == 0 {
+		return []string{command}
+	}
+	return segs
+}
@@ -0,0 +1,117 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// commandTools are tools whose action is a shell command line; for them the
+// remembered pattern is a program and its subcommand, or the exact command,
+// rather than the whole tool.
+var commandTools = map[string]bool{"shell_tool": true}
+
+// unrememberedPrograms run whatever their arguments say: wrappers, shells and
+// interpreters. Choosing "always" for one of them remembers only the exact
+// command.
+var unrememberedPrograms = map[string]bool{
+	"sudo": true, "doas": true, "su": true, "env": true, "nohup": true, "time": true, "nice": true, "timeout": true,
+	"command": true, "exec": true, "eval": true, "xargs": true, "parallel": true, "watch": true, "ssh": true,
+	"sh": true, "bash": true, "zsh": true, "dash": true, "ksh": true, "fish": true, "csh": true, "tcsh": true,
+	"python": true, "perl": true, "ruby": true, "node": true, "deno": true, "bun": true, "php": true, "lua": true,
+	"find": true, "awk": true, "gawk": true, "make": true, "npx": true, "uv": true,
+}
+
+// shellOperators are the characters that chain, substitute or redirect
+// commands. Commands containing any of them only match exactly.
+const shellOperators = ";&|<>`$(){}\n\\"
+
+// subcommand matches a word that names a subcommand, as in git status.
+var subcommand = regexp.MustCompile(`^[a-z][a-z0-9-]*$`)
+
+// allowEntry is one "always approve" choice made during the session.
+type allowEntry struct {
+	tool    string
+	prefix  []string // The program and subcommand a command must start with
+	command string   // The exact command, when there is no prefix
+}
+
+// SessionAllowList remembers actions the user chose to always approve for the
+// rest of the current session. It is never persisted.
+type SessionAllowList struct {
+	entries []allowEntry
+}
+
+// NewSessionAllowList creates an empty allow list.
+func NewSessionAllowList() *SessionAllowList {
+	return &SessionAllowList{}
+}
+
+// Remember records the pattern for an action and returns a description of it.
+// For a shell command the pattern is its program and subcommand, such as
+// git status; a command with shell operators, environment assignments, a
+// program that runs other commands or no subcommand is remembered exactly.
+func (s *SessionAllowList) Remember(a Action) string {
+	e := allowEntry{tool: a.Tool}
+	if commandTools[a.Tool] {
+		e.command = strings.TrimSpace(a.Command)
+		if prefix, ok := commandPrefix(e.command); ok {
+			e.prefix, e.command = prefix, ""
+		}
+	}
+	s.entries = append(s.entries, e)
+	return e.String()
+}
+
+// Match returns the description of the remembered pattern that covers the action, if any.
+func (s *SessionAllowList) Match(a Action) (string, bool) {
+	if s == nil {
+		return "", false
+	}
+	command := strings.TrimSpace(a.Command)
+	for _, e := range s.entries {
+		if e.tool != a.Tool {
+			continue
+		}
+		if !commandTools[a.Tool] || e.command != "" && e.command == command {
+			return e.String(), true
+		}
+		if e.prefix == nil || strings.ContainsAny(command, shellOperators) {
+			continue
+		}
+		if words := strings.Fields(command); len(words) >= len(e.prefix) && slices.Equal(words[:len(e.prefix)], e.prefix) {
+			return e.String(), true
+		}
+	}
+	return "", false
+}
+
+// String describes the remembered pattern.
+func (e allowEntry) String() string {
+	switch {
+	case e.prefix != nil:
+		return fmt.Sprintf("%s: %s *", e.tool, strings.Join(e.prefix, " "))
+	case e.command != "":
+		return fmt.Sprintf("%s: %s", e.tool, e.command)
+	}
+	return fmt.Sprintf("%s (any action)", e.tool)
+}
+
+// commandPrefix returns the program and subcommand command starts with, when
+// commands starting with them may be approved alike.
+func commandPrefix(command string) ([]string, bool) {
+	if strings.ContainsAny(command, shellOperators) {
+		return nil, false
+	}
+	words := strings.Fields(command)
+	if len(words) < 2 || strings.Contains(words[0], "=") || !subcommand.MatchString(words[1]) {
+		return nil, false
+	}
+	program := strings.TrimSuffix(filepath.Base(words[0]), ".exe")
+	if unrememberedPrograms[program] || unrememberedPrograms[strings.TrimRight(program, "0123456789.")] {
+		return nil, false
+	}
+	return words[:2], true
+}
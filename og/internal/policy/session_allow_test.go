@@ -0,0 +1,106 @@
+package policy
+
+import "testing"
+
+func TestSessionAllowList(t *testing.T) {
+	tests := []struct {
+		remembered string
+		pattern    string
+		matches    map[string]bool
+	}{
+		{
+			remembered: "git status",
+			pattern:    "shell_tool: git status *",
+			matches: map[string]bool{
+				"git status":             true,
+				"git status --short":     true,
+				"git push --force":       false,
+				"git clean -fdx":         false,
+				"git status; rm -rf x":   false,
+				"git status && git push": false,
+				"git status $(rm x)":     false,
+				"FOO=1 git status":       false,
+			},
+		},
+		{
+			remembered: "kubectl get pods -n web",
+			pattern:    "shell_tool: kubectl get *",
+			matches: map[string]bool{
+				"kubectl get svc":      true,
+				"kubectl delete pod x": false,
+			},
+		},
+		{
+			remembered: "ls",
+			pattern:    "shell_tool: ls",
+			matches: map[string]bool{
+				"ls":              true,
+				"ls ; rm -rf src": false,
+				"ls -la":          false,
+			},
+		},
+		{
+			remembered: "ls -la",
+			pattern:    "shell_tool: ls -la",
+			matches:    map[string]bool{"ls -la": true, "ls -la /": false},
+		},
+		{
+			remembered: "sudo apt update",
+			pattern:    "shell_tool: sudo apt update",
+			matches:    map[string]bool{"sudo apt update": true, "sudo apt remove x": false, "sudo rm -rf /": false},
+		},
+		{
+			remembered: "sh build.sh",
+			pattern:    "shell_tool: sh build.sh",
+			matches:    map[string]bool{"sh build.sh": true, "sh evil.sh": false},
+		},
+		{
+			remembered: "python3.12 manage migrate",
+			pattern:    "shell_tool: python3.12 manage migrate",
+			matches:    map[string]bool{"python3.12 manage flush": false},
+		},
+		{
+			remembered: "env FOO=1 make",
+			pattern:    "shell_tool: env FOO=1 make",
+			matches:    map[string]bool{"env rm -rf x": false},
+		},
+		{
+			remembered: "xargs rm",
+			pattern:    "shell_tool: xargs rm",
+			matches:    map[string]bool{"xargs rm -rf": false},
+		},
+		{
+			remembered: "make test > log",
+			pattern:    "shell_tool: make test > log",
+			matches:    map[string]bool{"make test > log": true, "make test > /etc/passwd": false},
+		},
+	}
+	for _, tt := range tests {
+		s := NewSessionAllowList()
+		if got := s.Remember(Action{Tool: "shell_tool", Command: tt.remembered}); got != tt.pattern {
+			t.Errorf("Remember(%q) = %q, want %q", tt.remembered, got, tt.pattern)
+		}
+		for command, want := range tt.matches {
+			if _, got := s.Match(Action{Tool: "shell_tool", Command: command}); got != want {
+				t.Errorf("after %q, Match(%q) = %v, want %v", tt.remembered, command, got, want)
+			}
+		}
+	}
+}
+
+func TestSessionAllowListTools(t *testing.T) {
+	s := NewSessionAllowList()
+	if got := s.Remember(Action{Tool: "apply_patch", Command: "a.go"}); got != "apply_patch (any action)" {
+		t.Errorf("Remember(apply_patch) = %q", got)
+	}
+	if _, ok := s.Match(Action{Tool: "apply_patch", Command: "b.go"}); !ok {
+		t.Error("apply_patch is not remembered for any action")
+	}
+	if _, ok := s.Match(Action{Tool: "shell_tool", Command: "a.go"}); ok {
+		t.Error("a remembered tool matches another tool")
+	}
+	var nilList *SessionAllowList
+	if _, ok := nilList.Match(Action{Tool: "shell_tool", Command: "ls"}); ok {
+		t.Error("a nil list matches")
+	}
+}
@@ -0,0 +1,78 @@
+package policy
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// TrustLevel describes how much latitude the policy gives in a workspace.
+type TrustLevel int
+
+const (
+	TrustDefault   TrustLevel = iota // Normal policy evaluation
+	TrustTrusted                     // Relaxed: trusted_auto_approve entries also apply
+	TrustUntrusted                   // Strict: nothing is auto-approved, every step is prompted
+)
+
+// String returns the string representation of the TrustLevel.
+func (t TrustLevel) String() string {
+	switch t {
+	case TrustDefault:
+		return "default"
+	case TrustTrusted:
+		return "trusted"
+	case TrustUntrusted:
+		return "untrusted"
+	default:
+		return fmt.Sprintf("UNKNOWN_TRUST_LEVEL(%d)", t)
+	}
+}
+
+// ResolveTrust determines the trust level of dir from the configured path lists.
+// The most specific (longest) matching path wins; ties go to untrusted.
+func ResolveTrust(cfg config.TrustCfg, dir string) TrustLevel {
+	dir = normalizePath(dir)
+	level, best := TrustDefault, -1
+	consider := func(paths []string, l TrustLevel) {
+		for _, p := range paths {
+			p = normalizePath(p)
+			if p == "" || !isWithin(dir, p) {
+				continue
+			}
+			if len(p) > best || (len(p) == best && l == TrustUntrusted) {
+				level, best = l, len(p)
+			}
+		}
+	}
+	consider(cfg.TrustedPaths, TrustTrusted)
+	consider(cfg.UntrustedPaths, TrustUntrusted)
+	return level
+}
+
+// normalizePath expands a leading ~ and returns an absolute, cleaned path.
+func normalizePath(p string) string {
+	p = strings.TrimSpace(p)
+	if p == "" {
+		return ""
+	}
+	p = config.ExpandPath(p)
+	if abs, err := filepath.Abs(p); err == nil {
+		p = abs
+	}
+	if resolved, err := filepath.EvalSymlinks(p); err == nil {
+		p = resolved
+	}
+	return filepath.Clean(p)
+}
+
+// isWithin reports whether dir is root or a descendant of it.
+func isWithin(dir, root string) bool {
+	rel, err := filepath.Rel(root, dir)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
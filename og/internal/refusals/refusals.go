@@ -0,0 +1,105 @@
+// Package refusals remembers the actions the user refused in a directory, and
+// why, so that later sessions there can tell the agent not to propose them
+// again (see general.remember_refusals). They are kept in the store's memory,
+// one scope per directory.
+package refusals
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/store"
+)
+
+// MaxInContext bounds how many refusals, the most recent, are given to the agent.
+const MaxInContext = 20
+
+// maxCommandBytes bounds the part of a refused command that is remembered.
+const maxCommandBytes = 400
+
+// Refusal is an action the user refused.
+type Refusal struct {
+	Tool    string `json:"tool"`
+	Command string `json:"command"`
+	Reason  string `json:"reason"`
+	TS      string `json:"ts"` // When it was refused, RFC3339
+	Session string `json:"session,omitempty"`
+}
+
+// scope returns the memory scope of dir's refusals.
+func scope(dir string) string {
+	return "refusals:" + filepath.Clean(dir)
+}
+
+// key identifies a refusal within its directory; refusing the same action
+// again replaces the earlier reason.
+func key(r Refusal) string {
+	return r.Tool + "\x00" + r.Command
+}
+
+// Remember records a refusal in dir.
+func Remember(mem store.MemoryStore, dir string, r Refusal) error {
+	if len(r.Command) > maxCommandBytes {
+		r.Command = strings.ToValidUTF8(r.Command[:maxCommandBytes], "") + "..."
+	}
+	if r.TS == "" {
+		r.TS = time.Now().Format(time.RFC3339)
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode refusal: %w", err)
+	}
+	return mem.Put(scope(dir), key(r), string(b))
+}
+
+// List returns the refusals remembered in dir, oldest first.
+func List(mem store.MemoryStore, dir string) ([]Refusal, error) {
+	entries, err := mem.List(scope(dir))
+	if err != nil {
+		return nil, err
+	}
+	var out []Refusal
+	for _, v := range entries {
+		var r Refusal
+		if json.Unmarshal([]byte(v), &r) == nil {
+			out = append(out, r)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].TS < out[j].TS })
+	return out, nil
+}
+
+// Forget removes a refusal from dir.
+func Forget(mem store.MemoryStore, dir string, r Refusal) error {
+	return mem.Delete(scope(dir), key(r))
+}
+
+// Context describes the most recent refusals for the agent, one per line, or
+// returns "" when there are none.
+func Context(refused []Refusal) string {
+	if len(refused) > MaxInContext {
+		refused = refused[len(refused)-MaxInContext:]
+	}
+	var b strings.Builder
+	for _, r := range refused {
+		what := strings.ReplaceAll(r.Command, "\n", "; ")
+		if r.Tool != "shell_tool" {
+			what = r.Tool + ": " + what
+		}
+		fmt.Fprintf(&b, "- The user refused `%s`", what)
+		if r.Reason != "" {
+			fmt.Fprintf(&b, " because: %s", r.Reason)
+		}
+		if r.TS != "" {
+			if t, err := time.Parse(time.RFC3339, r.TS); err == nil {
+				fmt.Fprintf(&b, " (%s)", t.Format("2006-01-02"))
+			}
+		}
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
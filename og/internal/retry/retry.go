@@ -0,0 +1,99 @@
+// Package retry decides whether a failed model call is worth retrying and how
+// long to wait before each retry. Errors that usually clear up on their own
+// (an endpoint that is starting, rate limits, overloaded servers) are retried
+// with exponential backoff; everything else fails the session at once.
+package retry
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// Policy bounds the retries of a failed model call.
+type Policy struct {
+	MaxRetries   int           // Retries after the first failure; 0 disables retrying
+	InitialDelay time.Duration // Wait before the first retry, doubled for each further one
+	MaxDelay     time.Duration // Upper bound of the wait
+}
+
+// FromConfig returns the policy configured in [retry].
+func FromConfig(cfg config.RetryCfg) Policy {
+	return Policy{
+		MaxRetries:   cfg.MaxRetries,
+		InitialDelay: time.Duration(cfg.InitialDelaySeconds) * time.Second,
+		MaxDelay:     time.Duration(cfg.MaxDelaySeconds) * time.Second,
+	}
+}
+
+// Delay returns how long to wait before the given retry (1 for the first).
+func (p Policy) Delay(retry int) time.Duration {
+	d := p.InitialDelay
+	if d <= 0 {
+		d = time.Second
+	}
+	for i := 1; i < retry; i++ {
+		d *= 2
+		if p.MaxDelay > 0 && d >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// transientStatus are the HTTP statuses of errors that may clear up on their own.
+var transientStatus = map[int]string{
+	408: "request timeout",
+	429: "rate limited",
+	502: "bad gateway",
+	503: "service unavailable",
+	504: "gateway timeout",
+}
+
+// transientTypes are LiteLLM exception classes of transient errors.
+var transientTypes = map[string]string{
+	"RateLimitError":          "rate limited",
+	"ServiceUnavailableError": "service unavailable",
+	"APIConnectionError":      "connection failed",
+	"Timeout":                 "timed out",
+	"APITimeoutError":         "timed out",
+}
+
+// transientMessages are fragments of error messages of transient errors, for
+// providers that report them without a status or a specific exception class.
+var transientMessages = []struct{ fragment, reason string }{
+	{"connection refused", "connection refused"},
+	{"connection reset", "connection reset"},
+	{"connection aborted", "connection aborted"},
+	{"timed out", "timed out"},
+	{"temporarily unavailable", "service unavailable"},
+	{"overloaded", "server overloaded"},
+	{"too many requests", "rate limited"},
+}
+
+// Transient classifies a model call failure by its HTTP status (0 when unknown),
+// the class of the exception and its message. It returns a short reason for
+// transient errors, and ok false for errors that retrying will not fix.
+func Transient(statusCode int, errType, message string) (reason string, ok bool) {
+	if reason, ok := transientStatus[statusCode]; ok {
+		return fmt.Sprintf("%s, HTTP %d", reason, statusCode), true
+	}
+	if statusCode >= 400 {
+		return "", false // Authentication, bad requests, unknown models, ...
+	}
+	if reason, ok := transientTypes[errType]; ok {
+		return reason, true
+	}
+	lower := strings.ToLower(message)
+	for _, m := range transientMessages {
+		if strings.Contains(lower, m.fragment) {
+			return m.reason, true
+		}
+	}
+	return "", false
+}
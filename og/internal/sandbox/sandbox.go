@@ -0,0 +1,354 @@
+// Package sandbox runs sessions in a throwaway copy of the working directory,
+// so their effects can be reviewed as a diff before they reach the real one.
+package sandbox
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Copy methods, in order of preference.
+const (
+	MethodWorktree = "git worktree" // A detached worktree of the enclosing repository plus uncommitted changes
+	MethodRsync    = "rsync"
+	MethodCopy     = "copy" // A plain recursive copy when rsync is unavailable
+)
+
+// Copy is a throwaway copy of a working directory.
+type Copy struct {
+	Source string // The real working directory
+	Dir    string // The copy of Source that the session runs in
+	Method string
+
+	root string // Temporary directory holding the copy
+	repo string // Top level of the repository the worktree belongs to, for MethodWorktree
+}
+
+// Change kinds reported by Changes.
+const (
+	Added    = "added"
+	Modified = "modified"
+	Deleted  = "deleted"
+)
+
+// Change is a file that differs between the copy and the real working directory.
+type Change struct {
+	Path string // Relative to the working directory, slash-separated
+	Kind string
+}
+
+// New copies source into a new temporary directory under parent, or under the
+// system temp directory when parent is empty. Inside a git repository it checks
+// out a detached worktree of HEAD and carries over uncommitted and untracked
+// (but not ignored) files; otherwise it copies the whole directory.
+func New(source, parent string) (*Copy, error) {
+	if parent != "" {
+		if err := os.MkdirAll(parent, 0o700); err != nil {
+			return nil, fmt.Errorf("failed to create %s: %w", parent, err)
+		}
+	}
+	root, err := os.MkdirTemp(parent, "og-sandbox-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create sandbox directory: %w", err)
+	}
+	c := &Copy{Source: source, root: root}
+	if repo, err := git(source, "rev-parse", "--show-toplevel"); err == nil {
+		err = c.worktree(strings.TrimSpace(repo))
+		if err == nil {
+			return c, nil
+		}
+		c.Remove()
+		return nil, fmt.Errorf("failed to create git worktree: %w", err)
+	}
+
+	c.Dir = filepath.Join(root, filepath.Base(source))
+	if _, err := exec.LookPath("rsync"); err == nil {
+		c.Method = MethodRsync
+		out, err := exec.Command("rsync", "-a", source+string(filepath.Separator), c.Dir).CombinedOutput()
+		if err != nil {
+			c.Remove()
+			return nil, fmt.Errorf("rsync failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+		return c, nil
+	}
+	c.Method = MethodCopy
+	if err := copyTree(source, c.Dir); err != nil {
+		c.Remove()
+		return nil, fmt.Errorf("failed to copy working directory: %w", err)
+	}
+	return c, nil
+}
+
+// worktree sets up the copy as a detached worktree of repo.
+func (c *Copy) worktree(repo string) error {
+	rel, err := filepath.Rel(repo, c.Source)
+	if err != nil {
+		return err
+	}
+	tree := filepath.Join(c.root, filepath.Base(repo))
+	if _, err := git(repo, "worktree", "add", "--detach", "--quiet", tree, "HEAD"); err != nil {
+		return err
+	}
+	c.Method, c.repo, c.Dir = MethodWorktree, repo, filepath.Join(tree, rel)
+
+	// HEAD alone would lose work in progress: bring over changed and new files
+	changed, err := git(repo, "diff", "--name-only", "-z", "HEAD")
+	if err != nil {
+		return err
+	}
+	untracked, err := git(repo, "ls-files", "-z", "--others", "--exclude-standard")
+	if err != nil {
+		return err
+	}
+	for _, p := range append(splitNul(changed), splitNul(untracked)...) {
+		src, dst := filepath.Join(repo, p), filepath.Join(tree, p)
+		if _, err := os.Lstat(src); errors.Is(err, fs.ErrNotExist) {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		if err := copyFile(src, dst); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Changes compares the copy with the real working directory. With a git
+// worktree, ignored files are not compared since they were never copied.
+func (c *Copy) Changes() ([]Change, error) {
+	before, err := c.files(c.Source)
+	if err != nil {
+		return nil, err
+	}
+	after, err := c.files(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+	var changes []Change
+	for p := range after {
+		if !before[p] {
+			changes = append(changes, Change{Path: p, Kind: Added})
+			continue
+		}
+		same, err := sameFile(filepath.Join(c.Source, p), filepath.Join(c.Dir, p))
+		if err != nil {
+			return nil, err
+		}
+		if !same {
+			changes = append(changes, Change{Path: p, Kind: Modified})
+		}
+	}
+	for p := range before {
+		if !after[p] {
+			changes = append(changes, Change{Path: p, Kind: Deleted})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// Diff returns a unified diff of the changes, with paths relative to the
+// working directory. Binary files are reported without content.
+func (c *Copy) Diff(changes []Change) string {
+	var b strings.Builder
+	for _, ch := range changes {
+		oldPath, newPath := filepath.Join(c.Source, ch.Path), filepath.Join(c.Dir, ch.Path)
+		oldLabel, newLabel := "a/"+ch.Path, "b/"+ch.Path
+		switch ch.Kind {
+		case Added:
+			oldPath, oldLabel = os.DevNull, "/dev/null"
+		case Deleted:
+			newPath, newLabel = os.DevNull, "/dev/null"
+		}
+		out, err := exec.Command("diff", "-u", "--label", oldLabel, "--label", newLabel, oldPath, newPath).Output()
+		var exitErr *exec.ExitError
+		if err != nil && !(errors.As(err, &exitErr) && exitErr.ExitCode() == 1) {
+			fmt.Fprintf(&b, "--- %s\n+++ %s\n(diff unavailable: %v)\n", oldLabel, newLabel, err)
+			continue
+		}
+		b.Write(out)
+	}
+	return b.String()
+}
+
+// Apply copies the given changes from the copy into the real working directory.
+func (c *Copy) Apply(changes []Change) error {
+	for _, ch := range changes {
+		dst := filepath.Join(c.Source, filepath.FromSlash(ch.Path))
+		if ch.Kind == Deleted {
+			if err := os.Remove(dst); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return fmt.Errorf("failed to delete %s: %w", ch.Path, err)
+			}
+			continue
+		}
+		if err := copyFile(filepath.Join(c.Dir, filepath.FromSlash(ch.Path)), dst); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", ch.Path, err)
+		}
+	}
+	return nil
+}
+
+// Remove deletes the copy, unregistering the git worktree if there is one.
+func (c *Copy) Remove() error {
+	if c.Method == MethodWorktree {
+		tree := filepath.Join(c.root, filepath.Base(c.repo))
+		if _, err := git(c.repo, "worktree", "remove", "--force", tree); err != nil {
+			os.RemoveAll(c.root)
+			git(c.repo, "worktree", "prune")
+			return err
+		}
+	}
+	return os.RemoveAll(c.root)
+}
+
+// files lists the files of dir that take part in the comparison, relative to dir.
+func (c *Copy) files(dir string) (map[string]bool, error) {
+	files := map[string]bool{}
+	if c.Method == MethodWorktree {
+		out, err := git(dir, "ls-files", "-z", "--cached", "--others", "--exclude-standard", "--", ".")
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range splitNul(out) {
+			// ls-files still lists tracked files that were deleted from the working tree
+			if _, err := os.Lstat(filepath.Join(dir, p)); err == nil {
+				files[p] = true
+			}
+		}
+		return files, nil
+	}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(rel)] = true
+		return nil
+	})
+	return files, err
+}
+
+// git runs a git command in dir and returns its stdout.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return string(out), nil
+}
+
+func splitNul(s string) []string {
+	var out []string
+	for _, p := range strings.Split(s, "\x00") {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func sameFile(a, b string) (bool, error) {
+	ia, err := os.Lstat(a)
+	if err != nil {
+		return false, err
+	}
+	ib, err := os.Lstat(b)
+	if err != nil {
+		return false, err
+	}
+	if ia.Mode() != ib.Mode() || ia.Size() != ib.Size() {
+		return false, nil
+	}
+	if ia.Mode()&fs.ModeSymlink != 0 {
+		ta, _ := os.Readlink(a)
+		tb, _ := os.Readlink(b)
+		return ta == tb, nil
+	}
+	da, err := os.ReadFile(a)
+	if err != nil {
+		return false, err
+	}
+	db, err := os.ReadFile(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(da, db), nil
+}
+
+// copyFile copies a regular file or symlink, creating parent directories and keeping the mode.
+func copyFile(src, dst string) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return err
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		target, err := os.Readlink(src)
+		if err != nil {
+			return err
+		}
+		os.Remove(dst)
+		return os.Symlink(target, dst)
+	}
+	if !info.Mode().IsRegular() {
+		return nil // Sockets, devices and the like are not copied
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// copyTree recursively copies src to dst.
+func copyTree(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0o755)
+		}
+		return copyFile(path, filepath.Join(dst, rel))
+	})
+}
@@ -0,0 +1,133 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/cloud"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+)
+
+// printBanner summarizes the mode OG runs in before the agent starts: the config
+// in use, the model of each role, the working directory with its git branch and
+// trust level, the approval policy and the active cloud contexts.
+func (s *Session) printBanner(trustLevel policy.TrustLevel, grant *policy.Grant, cloudContext cloud.Context) {
+	row := func(label, value string) {
+		fmt.Printf("   %s %s\n", s.ui.Blue(fmt.Sprintf("%-8s", label)), value)
+	}
+	fmt.Printf("%s %s\n", s.ui.Magenta("🕶️  OG"), s.ui.Cyan("session "+s.currentHash))
+	if path, err := config.GetConfigPath(); err == nil {
+		row("Config", tildePath(path))
+	}
+	row("Models", fmt.Sprintf("planner %s, executor %s, auditor %s",
+		s.ui.Cyan(s.cfg.PlannerAgent.Model), s.ui.Cyan(s.cfg.ExecutorAgent.Model), s.ui.Cyan(s.cfg.AuditorAgent.Model)))
+	workdir := tildePath(s.cwd)
+	if branch := gitBranch(s.cwd); branch != "" {
+		workdir += " (git " + s.ui.Cyan(branch) + ")"
+	}
+	row("Workdir", workdir)
+	if s.tag != "" {
+		row("Query", fmt.Sprintf("tagged %s (%s)", s.ui.Cyan(s.tag), s.tagSource))
+	}
+	trust := trustLevel.String()
+	switch {
+	case grant != nil:
+		trust = s.ui.Yellow(trust) + fmt.Sprintf(" until %s (granted by %s with `og trust`)", grant.Expires.Format("15:04"), grant.GrantedBy)
+	case trustLevel == policy.TrustUntrusted:
+		trust = s.ui.Red(trust)
+	case trustLevel == policy.TrustTrusted:
+		trust = s.ui.Yellow(trust)
+	}
+	if s.tagStrictness != "" {
+		trust = "default, " + s.ui.Yellow(s.tagStrictness) + " for " + s.tag + " queries"
+	}
+	row("Trust", trust)
+	row("Policy", policyMode(s.cfg.Policy, trustLevel))
+	if !cloudContext.Empty() {
+		row("Cloud", s.ui.Cyan(cloudContext.Describe()))
+	}
+}
+
+// policyMode describes how approvals will be decided at a trust level.
+func policyMode(cfg config.PolicyCfg, trustLevel policy.TrustLevel) string {
+	if trustLevel == policy.TrustUntrusted {
+		return fmt.Sprintf("strict, every step is prompted (%d deny entries still apply)", len(cfg.AlwaysDeny))
+	}
+	autoApprove := len(cfg.AutoApprove)
+	mode := "standard"
+	if trustLevel == policy.TrustTrusted {
+		autoApprove += len(cfg.TrustedAutoApprove)
+		mode = "relaxed"
+	}
+	parts := []string{
+		fmt.Sprintf("%d auto-approve", autoApprove),
+		fmt.Sprintf("%d deny", len(cfg.AlwaysDeny)),
+		fmt.Sprintf("%d rule(s)", len(cfg.Rules)),
+	}
+	if n := len(cfg.RequireSecondApprover); n > 0 {
+		parts = append(parts, fmt.Sprintf("%d need a second approver", n))
+	}
+	if cfg.Code != "" {
+		parts = append(parts, "code "+filepath.Base(cfg.Code))
+	}
+	return mode + ": " + strings.Join(parts, ", ")
+}
+
+// tildePath abbreviates the home directory in path to "~".
+func tildePath(path string) string {
+	home, err := os.UserHomeDir()
+	if err != nil || home == "" {
+		return path
+	}
+	if path == home {
+		return "~"
+	}
+	if rest, ok := strings.CutPrefix(path, home+string(filepath.Separator)); ok {
+		return filepath.Join("~", rest)
+	}
+	return path
+}
+
+// gitBranch returns the branch checked out in the repository containing dir (a
+// short commit for a detached HEAD), or "" outside a repository. It reads .git
+// directly instead of running git, so the banner costs nothing.
+func gitBranch(dir string) string {
+	for {
+		gitPath := filepath.Join(dir, ".git")
+		if info, err := os.Stat(gitPath); err == nil {
+			gitDir := gitPath
+			if !info.IsDir() {
+				// Worktrees and submodules have a .git file pointing at the real directory
+				b, err := os.ReadFile(gitPath)
+				target, ok := strings.CutPrefix(strings.TrimSpace(string(b)), "gitdir: ")
+				if err != nil || !ok {
+					return ""
+				}
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(dir, target)
+				}
+				gitDir = target
+			}
+			head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+			if err != nil {
+				return ""
+			}
+			ref := strings.TrimSpace(string(head))
+			if branch, ok := strings.CutPrefix(ref, "ref: refs/heads/"); ok {
+				return branch
+			}
+			if len(ref) >= 7 {
+				return ref[:7] + " (detached)"
+			}
+			return ""
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
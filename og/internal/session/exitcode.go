@@ -0,0 +1,41 @@
+package session
+
+import "github.com/robbiemu/original_gangster/og/internal/agent"
+
+// Exit codes of `og <prompt>`, by how the session ended, so that scripts and
+// wrappers can tell a denied plan from an unreachable model.
+const (
+	ExitCompleted        = 0   // The agent delivered its final summary
+	ExitFailed           = 1   // The agent failed or crashed, or og itself failed
+	ExitModelUnreachable = 3   // A model call failed, after any retries
+	ExitToolFailed       = 4   // A tool raised instead of returning a result
+	ExitProtocolError    = 5   // og and the agent could not understand each other
+	ExitDenied           = 6   // A plan or step was denied, or the user quit at a prompt
+	ExitUnsafe           = 7   // The auditor flagged the request as unsafe
+	ExitHookFailed       = 8   // A step hook failed and ended the session
+	ExitAborted          = 130 // The user interrupted the session (128 + SIGINT)
+)
+
+// ExitCode returns the exit code for a session status (see Session.Status).
+func ExitCode(status string) int {
+	switch status {
+	case agent.OutcomeCompleted:
+		return ExitCompleted
+	case agent.OutcomeModelUnreachable:
+		return ExitModelUnreachable
+	case agent.OutcomeToolFailed:
+		return ExitToolFailed
+	case agent.OutcomeProtocolError:
+		return ExitProtocolError
+	case agent.OutcomeDenied, agent.OutcomeQuit:
+		return ExitDenied
+	case agent.OutcomeUnsafe:
+		return ExitUnsafe
+	case agent.OutcomeHookFailed:
+		return ExitHookFailed
+	case agent.OutcomeAborted:
+		return ExitAborted
+	default:
+		return ExitFailed
+	}
+}
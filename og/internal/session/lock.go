@@ -0,0 +1,71 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/filelock"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Values of general.concurrent_sessions, what a session does when another one
+// is running on the same data directory.
+const (
+	ConcurrentQueue  = "queue"  // Wait for the other session to end
+	ConcurrentRefuse = "refuse" // Fail, naming the other session
+	ConcurrentAllow  = "allow"  // Run alongside it
+)
+
+// lockOwner is what the session holding the data directory's session lock
+// records in it, for the sessions waiting on it.
+type lockOwner struct {
+	Pid     int       `json:"pid"`
+	Query   string    `json:"query"`
+	CWD     string    `json:"cwd"`
+	Started time.Time `json:"started"`
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("pid %d, since %s in %s: %q", o.Pid, o.Started.Format("15:04:05"), o.CWD, ui.TruncateLine(o.Query, 60))
+}
+
+// lockSession takes the session lock of the data directory, according to
+// general.concurrent_sessions, and returns the function that releases it.
+// Sessions that cannot create the lock file run without it.
+func (s *Session) lockSession(query string) (func(), error) {
+	noop := func() {}
+	if s.cfg.General.ConcurrentSessions == ConcurrentAllow {
+		return noop, nil
+	}
+	dir, err := config.GetDataDir()
+	if err != nil {
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Not checking for other og sessions: %v\n", err)
+		return noop, nil
+	}
+	path := filepath.Join(dir, "session.lock")
+	lock, err := filelock.TryLock(path)
+	if errors.Is(err, filelock.ErrLocked) {
+		other := "owner unknown"
+		var owner lockOwner
+		if json.Unmarshal(filelock.Owner(path), &owner) == nil && owner.Pid != 0 {
+			other = owner.String()
+		}
+		if s.cfg.General.ConcurrentSessions == ConcurrentRefuse {
+			return nil, fmt.Errorf("another og session is running on %s (%s); set general.concurrent_sessions = %q to wait for it", dir, other, ConcurrentQueue)
+		}
+		s.ui.PrintColored(s.ui.Yellow, "⏳ Another og session is running on %s (%s). Waiting for it to finish; Ctrl-C to give up.\n", dir, other)
+		lock, err = filelock.Acquire(path)
+	}
+	if err != nil {
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Not checking for other og sessions: %v\n", err)
+		return noop, nil
+	}
+	owner, _ := json.Marshal(lockOwner{Pid: os.Getpid(), Query: s.redactor.String(query), CWD: s.cwd, Started: time.Now()})
+	lock.SetOwner(owner)
+	return func() { lock.Unlock() }, nil
+}
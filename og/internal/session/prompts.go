@@ -0,0 +1,65 @@
+package session
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"golang.org/x/term"
+)
+
+// SetDefaultPrompts sets the prompts built into og, used when the user's prompts
+// file is missing or older than them.
+func (s *Session) SetDefaultPrompts(data []byte) {
+	s.defaultPrompts = data
+}
+
+// resolvePrompts returns the prompts file the agent should load, or "" for the
+// user's. A missing file is replaced by the built-in prompts; an outdated one
+// only if the user agrees. The built-in prompts are written to dir, never over
+// the user's file.
+func (s *Session) resolvePrompts(dir string) string {
+	if len(s.defaultPrompts) == 0 {
+		return ""
+	}
+	path, err := config.GetPromptsPath()
+	if err != nil {
+		return ""
+	}
+	builtin, err := config.PromptsVersion(s.defaultPrompts)
+	if err != nil {
+		return ""
+	}
+
+	data, err := os.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  %s is missing; using the built-in prompts for this session (`og init` writes them).\n", tildePath(path))
+	case err != nil:
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Cannot read %s (%v); using the built-in prompts for this session.\n", tildePath(path), err)
+	default:
+		version, err := config.PromptsVersion(data)
+		if err != nil {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  %s is invalid (%v); using the built-in prompts for this session.\n", tildePath(path), err)
+			break
+		}
+		if version >= builtin {
+			return ""
+		}
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  %s is version %d, but this og ships version %d of the prompts.\n", tildePath(path), version, builtin)
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !s.ui.PromptForApproval("Use the built-in prompts for this session? (your file is left unchanged)") {
+			return ""
+		}
+	}
+
+	builtinPath := filepath.Join(dir, "prompts.toml")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to write the built-in prompts: %v\n", err)
+		return ""
+	}
+	if err := os.WriteFile(builtinPath, s.defaultPrompts, 0o644); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to write the built-in prompts: %v\n", err)
+		return ""
+	}
+	return builtinPath
+}
@@ -0,0 +1,40 @@
+package session
+
+import (
+	"slices"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/checkpoint"
+)
+
+// unresumable are the statuses of sessions that ended by completing or by a
+// decision, which og resume does not take up.
+var unresumable = []string{agent.OutcomeCompleted, agent.OutcomeDenied, agent.OutcomeQuit, agent.OutcomeUnsafe}
+
+// Resumable returns why og resume cannot take up a session whose checkpoint
+// is state, or "" when it can: when the session was cut short, by a crash,
+// Ctrl-C or an error, after its recipe was approved.
+func Resumable(state *checkpoint.State) string {
+	switch {
+	case state.Phase == "":
+		return "it ended before its recipe was approved"
+	case slices.Contains(unresumable, state.Status):
+		return "it ended " + state.Status
+	}
+	return ""
+}
+
+// suggestResume points the user to og resume when the session was cut short
+// before every step of its approved recipe ran.
+func (s *Session) suggestResume() {
+	if s.checkpoint == nil {
+		return
+	}
+	state, err := checkpoint.Load(s.cacheCfg.Directory, s.currentHash)
+	if err != nil || Resumable(state) != "" {
+		return
+	}
+	if next := state.Next(); next > 0 {
+		s.ui.PrintColored(s.ui.Blue, "⏯️  Steps %d to %d of the recipe did not run; `og resume %s` takes the session up from step %d.\n", next, len(state.Steps), s.currentHash, next)
+	}
+}
@@ -1,16 +1,57 @@
 package session
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/robbiemu/original_gangster/og/internal/agent"   // Import the agent package
-	"github.com/robbiemu/original_gangster/og/internal/config"  // Import the config package
-	"github.com/robbiemu/original_gangster/og/internal/history" // Import the history package
-	"github.com/robbiemu/original_gangster/og/internal/ui"      // Import the ui package
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"         // Import the agent package
+	"github.com/robbiemu/original_gangster/og/internal/approval"      // Import the approval package
+	"github.com/robbiemu/original_gangster/og/internal/attach"        // Import the attach package
+	"github.com/robbiemu/original_gangster/og/internal/audit"         // Import the audit package
+	"github.com/robbiemu/original_gangster/og/internal/checkpoint"    // Import the checkpoint package
+	"github.com/robbiemu/original_gangster/og/internal/classify"      // Import the classify package
+	"github.com/robbiemu/original_gangster/og/internal/cloud"         // Import the cloud package
+	"github.com/robbiemu/original_gangster/og/internal/config"        // Import the config package
+	"github.com/robbiemu/original_gangster/og/internal/container"     // Import the container package
+	"github.com/robbiemu/original_gangster/og/internal/diag"          // Import the diag package
+	"github.com/robbiemu/original_gangster/og/internal/distill"       // Import the distill package
+	"github.com/robbiemu/original_gangster/og/internal/executor"      // Import the executor package
+	"github.com/robbiemu/original_gangster/og/internal/history"       // Import the history package
+	"github.com/robbiemu/original_gangster/og/internal/hooks"         // Import the hooks package
+	"github.com/robbiemu/original_gangster/og/internal/jail"          // Import the jail package
+	"github.com/robbiemu/original_gangster/og/internal/limits"        // Import the limits package
+	"github.com/robbiemu/original_gangster/og/internal/maintenance"   // Import the maintenance package
+	"github.com/robbiemu/original_gangster/og/internal/mcp"           // Import the mcp package
+	"github.com/robbiemu/original_gangster/og/internal/modelcheck"    // Import the modelcheck package
+	"github.com/robbiemu/original_gangster/og/internal/notify"        // Import the notify package
+	"github.com/robbiemu/original_gangster/og/internal/plugins"       // Import the plugins package
+	"github.com/robbiemu/original_gangster/og/internal/policy"        // Import the policy package
+	"github.com/robbiemu/original_gangster/og/internal/redact"        // Import the redact package
+	"github.com/robbiemu/original_gangster/og/internal/refusals"      // Import the refusals package
+	"github.com/robbiemu/original_gangster/og/internal/retry"         // Import the retry package
+	"github.com/robbiemu/original_gangster/og/internal/sandbox"       // Import the sandbox package
+	"github.com/robbiemu/original_gangster/og/internal/secondopinion" // Import the secondopinion package
+	"github.com/robbiemu/original_gangster/og/internal/sessionlog"    // Import the sessionlog package
+	"github.com/robbiemu/original_gangster/og/internal/store"         // Import the store package
+	"github.com/robbiemu/original_gangster/og/internal/telemetry"     // Import the telemetry package
+	"github.com/robbiemu/original_gangster/og/internal/ui"            // Import the ui package
+	"github.com/robbiemu/original_gangster/og/internal/undo"          // Import the undo package
+	"github.com/robbiemu/original_gangster/og/internal/usage"         // Import the usage package
 )
 
 // Session manages the overall interaction flow with the agent.
@@ -23,40 +64,483 @@ type Session struct {
 	ui               ui.UI
 	minGoLogLevel    ui.LogLevel
 	cacheCfg         config.CacheCfg
+	store            store.Store
+	redactor         *redact.Redactor
+	log              *slog.Logger     // The session log, see [log]
+	notifier         *notify.Notifier // See [notifications]
+	span             trace.Span       // The session's span, see [telemetry]
+	stopTracing      func(context.Context) error
+	endTraceOnce     sync.Once
+	cwd              string
+	sandboxCopy      bool
+	readOnly         bool              // See UseReadOnly
+	usage            usage.Tally       // Tokens consumed by the agent's models
+	status           string            // How the session ended, see Status
+	defaultPrompts   []byte            // Built-in prompts, see SetDefaultPrompts
+	tag              string            // What kind of query the session runs, see classifyQuery
+	tagSource        string            // The classifier that chose tag
+	tagStrictness    string            // The policy strictness tag's route set, if it changed the trust level
+	aborting         atomic.Bool       // Set once Ctrl-C was pressed; abort then ends the session
+	parent           string            // The session this one continues, see Continue
+	context          string            // What the earlier sessions did, see Continue
+	stdin            *StdinContext     // Input piped to og, see AttachStdin
+	files            *attach.Document  // Files and listings attached with --file and --dir, see AttachFiles
+	lastCommand      *LastCommand      // The shell's last command, see AttachLastCommand
+	recordPath       string            // Where to record the agent's protocol, see Record
+	replay           *agent.Recording  // The recording that stands in for the agent, see Replay
+	echo             bool              // See UseEchoAgent
+	resumed          *checkpoint.State // The recipe og resume takes up, see Resume
+	checkpoint       *checkpoint.Log   // The state of the recipe as it runs, with cache.json_logs
+	undo             *undo.Journal     // Backups of the files steps change, with undo.enabled
+}
+
+// StdinContext is input piped to og, which the agent gets as a document
+// attached to the query.
+type StdinContext struct {
+	Data []byte // The input, cut to its first and last bytes when it was too long
+	Size int64  // Length of the whole input
+}
+
+// LastCommand is the command the user ran in their shell before og, as the
+// shell hook of og hook exports it.
+type LastCommand struct {
+	Command string
+	Status  string // Its exit status, or "" when unknown
 }
 
-// NewSession creates and initializes a new Session.
-func NewSession(cfg *config.OGConfig, ui ui.UI, cacheCfg config.CacheCfg) *Session {
+// cancelDeadline is how long an interrupted session waits for the agent to
+// report that it cancelled, before stopping it.
+const cancelDeadline = 10 * time.Second
+
+// NewSession creates and initializes a new Session. redactor may be nil to disable redaction.
+func NewSession(cfg *config.OGConfig, ui ui.UI, cacheCfg config.CacheCfg, st store.Store, redactor *redact.Redactor) *Session {
 	return &Session{
 		cfg:           cfg,
 		ui:            ui,
 		minGoLogLevel: cfg.General.VerbosityLevel,
 		cacheCfg:      cacheCfg,
+		store:         st,
+		redactor:      redactor,
+		log:           sessionlog.Discard(),
+	}
+}
+
+// UseSandboxCopy makes Run execute the session in a throwaway copy of the
+// working directory and ask before applying the resulting changes to it.
+func (s *Session) UseSandboxCopy() {
+	s.sandboxCopy = true
+}
+
+// UseReadOnly makes Run deny every step that writes, deletes or changes state
+// elsewhere, and tells the agent so before it plans.
+func (s *Session) UseReadOnly() {
+	s.readOnly = true
+}
+
+// Continue makes the session a stage of a pipeline (`og ... --then ...`): it is
+// recorded in the history as a child of the parent session, and context, what
+// the earlier stages did, is given to the agent ahead of the query.
+func (s *Session) Continue(parent, context string) {
+	s.parent = parent
+	s.context = context
+}
+
+// Record makes Run write every message exchanged with the agent to a
+// recording at path (og --record), which og --replay can play back.
+func (s *Session) Record(path string) {
+	s.recordPath = path
+}
+
+// Replay makes Run play rec back instead of starting an agent (og --replay),
+// so a session can be reproduced without models. og still decides and runs
+// what it runs itself, such as approvals, hooks and the Go executor's steps.
+func (s *Session) Replay(rec *agent.Recording) {
+	s.replay = rec
+}
+
+// UseEchoAgent makes Run drive og's echo agent instead of the configured one
+// (og selftest): canned replies stand in for the models, and og runs the one
+// step it plans.
+func (s *Session) UseEchoAgent() {
+	s.echo = true
+}
+
+// Resume makes Run take up session hash where its checkpoint state left it
+// (og resume), instead of starting a new session: the agent reloads the state
+// it saved and runs the steps that did not run yet, under the approval the
+// recipe was given. The session keeps its history record and tag.
+func (s *Session) Resume(hash, tag string, state *checkpoint.State) {
+	s.currentHash, s.tag, s.resumed = hash, tag, state
+}
+
+// AttachStdin gives the agent input piped to og as context for the query.
+func (s *Session) AttachStdin(doc StdinContext) {
+	s.stdin = &doc
+}
+
+// AttachFiles gives the agent the files and directory listings attached with
+// og --file and --dir as context for the query.
+func (s *Session) AttachFiles(doc *attach.Document) {
+	s.files = doc
+}
+
+// AttachLastCommand gives the agent the command the user ran before og, and
+// its exit status, as context for the query, e.g. "why did that fail?".
+func (s *Session) AttachLastCommand(c LastCommand) {
+	s.lastCommand = &c
+}
+
+// Hash returns the session's hash, once Run started it.
+func (s *Session) Hash() string {
+	return s.currentHash
+}
+
+// attachStdin writes the piped input to the session's temporary directory,
+// with secrets masked, for the agent to read.
+func (s *Session) attachStdin(tempDirPath string) {
+	path := filepath.Join(tempDirPath, "stdin.txt")
+	if err := os.MkdirAll(tempDirPath, 0o700); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the piped input: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(s.redactor.String(string(s.stdin.Data))), 0o600); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the piped input: %v\n", err)
+		return
+	}
+	s.processManager.SetStdinContext(path)
+	if int64(len(s.stdin.Data)) < s.stdin.Size {
+		s.ui.PrintColored(s.ui.Blue, "📎 Piped input attached as context: %s, cut to its beginning and end (general.stdin_max_bytes = %d)\n", maintenance.FormatSize(s.stdin.Size), s.cfg.General.StdinMaxBytes)
+	} else {
+		s.ui.PrintColored(s.ui.Blue, "📎 Piped input attached as context: %s\n", maintenance.FormatSize(s.stdin.Size))
 	}
 }
 
+// attachFiles writes the attached files and listings to the session's
+// temporary directory, with secrets masked, for the agent to read.
+func (s *Session) attachFiles(tempDirPath string) {
+	path := filepath.Join(tempDirPath, "attachments.txt")
+	if err := os.MkdirAll(tempDirPath, 0o700); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the files: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(s.redactor.String(string(s.files.Text))), 0o600); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the files: %v\n", err)
+		return
+	}
+	s.processManager.SetFilesContext(path)
+	files, dirs := 0, 0
+	for _, it := range s.files.Items {
+		switch {
+		case it.Skipped != "":
+		case it.Dir:
+			dirs++
+		default:
+			files++
+		}
+	}
+	s.ui.PrintColored(s.ui.Blue, "📎 Attached as context: %d file(s), %d directory listing(s), %s\n", files, dirs, maintenance.FormatSize(s.files.Bytes()))
+}
+
+// attachLastCommand writes the shell's last command to the session's temporary
+// directory, with secrets masked, for the agent to read.
+func (s *Session) attachLastCommand(tempDirPath string) {
+	path := filepath.Join(tempDirPath, "last_command.txt")
+	if err := os.MkdirAll(tempDirPath, 0o700); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the last command: %v\n", err)
+		return
+	}
+	text := "$ " + s.lastCommand.Command + "\n"
+	status := ""
+	if s.lastCommand.Status != "" {
+		text += "exit status " + s.lastCommand.Status + "\n"
+		status = " (exit status " + s.lastCommand.Status + ")"
+	}
+	if err := os.WriteFile(path, []byte(s.redactor.String(text)), 0o600); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the last command: %v\n", err)
+		return
+	}
+	s.processManager.SetLastCommandContext(path)
+	s.ui.PrintColored(s.ui.Blue, "🐚 Last command attached as context: %s%s\n", s.ui.Cyan(s.redactor.String(s.lastCommand.Command)), status)
+}
+
+// attachRefusals writes the actions the user refused in earlier sessions in the
+// working directory to the session's temporary directory, for the agent to
+// read, and has the actions refused in this session remembered too.
+func (s *Session) attachRefusals(tempDirPath string) {
+	s.messageProcessor.OnRefusal(func(action policy.Action, reason string) {
+		err := refusals.Remember(s.store.Memory(), s.cwd, refusals.Refusal{Tool: action.Tool, Command: action.Command, Reason: reason, Session: s.currentHash})
+		if err != nil {
+			s.ui.PrintColored(s.ui.Red, "Failed to remember the refusal: %v\n", err)
+			return
+		}
+		s.ui.PrintColored(s.ui.Blue, "🙅 Refusal remembered for this directory (og refusals lists and forgets them).\n")
+	})
+	refused, err := refusals.List(s.store.Memory(), s.cwd)
+	if err != nil {
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Ignoring earlier refusals: %v\n", err)
+		return
+	}
+	if len(refused) == 0 {
+		return
+	}
+	path := filepath.Join(tempDirPath, "refusals.txt")
+	if err := os.MkdirAll(tempDirPath, 0o700); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the earlier refusals: %v\n", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(refusals.Context(refused)), 0o600); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to attach the earlier refusals: %v\n", err)
+		return
+	}
+	s.processManager.SetRefusalsContext(path)
+	s.ui.PrintColored(s.ui.Blue, "🙅 %d earlier refusal(s) in this directory attached as context (og refusals lists them)\n", min(len(refused), refusals.MaxInContext))
+}
+
+// Summary returns the nutshell of the agent's final summary, or "" if the
+// session did not complete.
+func (s *Session) Summary() string {
+	if s.messageProcessor == nil {
+		return ""
+	}
+	return s.messageProcessor.Summary()
+}
+
 // Run executes the main session logic.
 func (s *Session) Run(query string) error {
-	s.sessionStart = time.Now()
 	cwd, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current working directory: %w", err)
 	}
-	s.currentHash = history.GenerateSessionHash(query, s.sessionStart)
+	s.cwd = cwd
+	unlock, err := s.lockSession(query)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	s.sessionStart = time.Now()
+	if s.resumed == nil {
+		s.currentHash = history.GenerateSessionHash(query, s.sessionStart)
+	}
+	if s.cfg.Log.File {
+		log, f, err := sessionlog.Open(s.cfg.Log, s.cacheCfg.Directory, s.currentHash, s.redactor)
+		if err != nil {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  The session is not logged: %v\n", err)
+		} else {
+			s.log = log
+			defer f.Close()
+		}
+	}
+	if s.cacheCfg.JSONLogs {
+		// The agent only saves its own state with JSON logs, without which the recipe cannot be resumed
+		cp, err := checkpoint.Open(s.cacheCfg.Directory, s.currentHash)
+		if err != nil {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  The session cannot be resumed: %v\n", err)
+		} else {
+			s.checkpoint = cp
+			defer cp.Close()
+		}
+	}
+	s.notifier = notify.New(s.cfg.Notifications, s.log)
+	s.ui = s.notifier.UI(s.ui)
+	stopTracing, err := telemetry.Start(context.Background(), s.cfg.Telemetry, func(err error) {
+		s.log.Warn("failed to export traces", "error", err.Error())
+	})
+	if err != nil {
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  The session is not traced: %v\n", err)
+		stopTracing = func(context.Context) error { return nil }
+	}
+	traceCtx, span := telemetry.Tracer().Start(context.Background(), "session", trace.WithAttributes(attribute.String("og.session", s.currentHash)))
+	s.span, s.stopTracing = span, stopTracing
+	defer s.endTrace()
+
+	trustLevel := policy.ResolveTrust(s.cfg.Trust, cwd)
+	grants, err := policy.LoadGrants()
+	if err != nil {
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Ignoring temporary trust grants: %v\n", err)
+	}
+	trustLevel, grant := policy.Elevate(trustLevel, grants, cwd)
+	if strictness := s.classifyQuery(query); strictness != "" && trustLevel == policy.TrustDefault && grant == nil {
+		// A tag sets the strictness only where the directory's trust does not
+		trustLevel = strictnessTrust[strictness]
+		s.tagStrictness = strictness
+	}
+	policyEngine, err := policy.New(s.cfg.Policy, trustLevel)
+	if err != nil {
+		return fmt.Errorf("invalid approval policy: %w", err)
+	}
+	cloudContext := cloud.Detect()
+	policyEngine.SetCloudContext(cloudContext.Values())
+	policyEngine.SetReadOnly(s.readOnly)
+	policyEngine.SetWorkdir(cwd)
 
 	rec := history.HistoryRecord{
-		TS:    s.sessionStart.Format(time.RFC3339),
-		Hash:  s.currentHash,
-		CWD:   cwd,
-		Query: query,
+		TS:     s.sessionStart.Format(time.RFC3339),
+		Hash:   s.currentHash,
+		CWD:    cwd,
+		Query:  s.redactor.String(query),
+		User:   s.cfg.Storage.User,
+		Tag:    s.tag,
+		Parent: s.parent,
+	}
+	var historyOffset int64
+	var historyErr error
+	if s.resumed == nil { // A resumed session has its record
+		historyOffset, historyErr = s.store.History().Append(rec)
 	}
-	if err := history.AppendRecord(rec); err != nil {
-		s.ui.PrintColored(s.ui.Red, "Failed to append history: %v\n", err)
+	if historyErr != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to append history: %v\n", historyErr)
+		s.log.Error("failed to append history", "error", historyErr.Error())
+	}
+	span.SetAttributes(attribute.String("og.trust", trustLevel.String()), attribute.String("og.tag", s.tag), attribute.String("og.executor", s.cfg.General.Executor))
+	s.log.Info("session started", "query", query, "cwd", cwd, "user", s.cfg.Storage.User, "trust", trustLevel.String(), "tag", s.tag, "parent", s.parent,
+		"read_only", s.readOnly, "sandbox_copy", s.sandboxCopy, "executor", s.cfg.General.Executor, "sandbox", s.cfg.General.Sandbox)
+
+	// The agent works in a throwaway copy when requested; trust is still that of the real directory
+	workdir := cwd
+	if s.sandboxCopy {
+		sb, err := sandbox.New(cwd, s.cfg.General.TempRoot)
+		if err != nil {
+			return fmt.Errorf("failed to create sandbox copy: %w", err)
+		}
+		defer func() {
+			if err := sb.Remove(); err != nil {
+				s.ui.PrintColored(s.ui.Red, "Error removing sandbox copy %s: %v\n", sb.Dir, err)
+			}
+		}()
+		workdir = sb.Dir
+		s.ui.PrintColored(s.ui.Yellow, "🧪 Running in a throwaway copy (%s): %s\n", sb.Method, s.ui.Cyan(sb.Dir))
+		defer s.reviewSandbox(sb)
+	}
+
+	// Without anyone at the terminal, approvals may be decided through a webhook
+	if remote := approval.NewRemote(s.cfg.RemoteApproval, s.currentHash, s.cfg.Storage.User, workdir); remote != nil {
+		defer func() {
+			if err := remote.Close(); err != nil {
+				s.log.Warn("failed to stop the remote approval listener", "error", err.Error())
+			}
+		}()
+		s.ui = remote.UI(s.ui)
+		s.ui.PrintColored(s.ui.Blue, "📨 Approvals are decided remotely ([remote_approval]).\n")
+		s.log.Info("remote approval", "webhook", s.cfg.RemoteApproval.WebhookURL, "callback", s.cfg.RemoteApproval.Callback)
 	}
 
 	// Initialize process and message managers
 	s.processManager = agent.NewProcessManager(s.ui, s.minGoLogLevel)
-	s.messageProcessor = agent.NewMessageProcessor(s.processManager, s.ui, s.minGoLogLevel)
+	s.processManager.SetLogger(s.log)
+	s.processManager.SetTraceContext(traceCtx)
+	s.messageProcessor = agent.NewMessageProcessor(s.processManager, s.ui, s.minGoLogLevel, policyEngine, approval.NewDelegator(s.cfg.Delegation), s.redactor, agent.SessionInfo{
+		Hash:    s.currentHash,
+		User:    s.cfg.Storage.User,
+		Workdir: workdir,
+	})
+	databases := s.cfg.Databases
+	if s.readOnly {
+		databases = readOnlyDatabases(databases)
+	}
+	s.messageProcessor.SetLogger(s.log)
+	s.messageProcessor.SetDatabases(databases)
+	s.messageProcessor.SetCloudContext(cloudContext)
+	var proxiedTools []mcp.Tool
+	if len(s.cfg.MCPServers) > 0 {
+		servers := mcp.StartAll(s.cfg.MCPServers, workdir, func(name string, err error) {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  MCP server %s is not available: %v\n", name, err)
+			s.log.Warn("MCP server failed to start", "server", name, "error", err.Error())
+		})
+		defer servers.Close()
+		if tools := servers.Tools(); len(tools) > 0 {
+			s.ui.PrintColored(s.ui.Blue, "🔌 %d MCP tools are offered to the agent ([mcp_servers]).\n", len(tools))
+			proxiedTools = append(proxiedTools, tools...)
+			s.messageProcessor.SetMCP(servers)
+		}
+	}
+	if len(s.cfg.Plugins) > 0 {
+		loaded := plugins.StartAll(s.cfg.Plugins, workdir, func(name string, err error) {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  Plugin %s is not available: %v\n", name, err)
+			s.log.Warn("plugin failed to start", "plugin", name, "error", err.Error())
+		})
+		defer loaded.Close()
+		if tools := loaded.Tools(); len(tools) > 0 {
+			s.ui.PrintColored(s.ui.Blue, "🧩 %d plugin tools are offered to the agent ([plugins]).\n", len(tools))
+			proxiedTools = append(proxiedTools, tools...)
+			s.messageProcessor.SetPlugins(loaded)
+		}
+	}
+	if len(proxiedTools) > 0 {
+		s.processManager.SetMCPTools(proxiedTools)
+	}
+	s.messageProcessor.SetHooks(hooks.New(s.cfg.Hooks, s.currentHash, workdir))
+	s.messageProcessor.SetRetryPolicy(retry.FromConfig(s.cfg.Retry))
+	s.messageProcessor.SetLoopCap(s.cfg.Policy.MaxLoopIterations)
+	s.messageProcessor.SetMaxParallelSteps(s.cfg.General.MaxParallelSteps)
+	s.messageProcessor.SetCheckpoint(s.checkpoint)
+	s.messageProcessor.SetSecondOpinion(secondopinion.New(s.cfg.SecondOpinion))
+	stepTimeout := time.Duration(s.cfg.General.StepTimeoutSeconds) * time.Second
+	s.messageProcessor.SetStepTimeout(stepTimeout)
+	stepEnv := s.stepEnv(workdir)
+	if s.cfg.Limits.Any() && runtime.GOOS == "linux" {
+		s.ui.PrintColored(s.ui.Blue, "🧯 The agent and the shell steps are limited to %s ([limits]).\n", limits.Describe(s.cfg.Limits))
+	}
+	useNative, why := agent.NativeBackend(s.cfg)
+	if s.echo {
+		useNative = true
+		s.processManager.SetEchoAgent()
+	} else if useNative && s.replay == nil {
+		if why != "" {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  %s; og's native agent runs the session (general.backend = \"auto\").\n", why)
+		}
+		s.log.Info("native agent", "reason", why)
+		s.processManager.SetNative(true)
+	}
+	// The native agent leaves shell steps to og
+	if s.cfg.General.Executor == "go" || useNative {
+		runner := executor.New(workdir, stepTimeout, s.redactor.String)
+		runner.Env = stepEnv
+		runner.SpillDir = s.store.Artifacts().Dir(s.currentHash) // Output above [output] spill_to_file_above_bytes is kept there
+		runner.SpillAboveBytes = s.cfg.Output.SpillToFileAboveBytes
+		if s.cfg.Limits.Any() {
+			runner.Wrap = func(path string, args []string) (string, []string, error) {
+				return limits.Wrap(s.cfg.Limits, path, args)
+			}
+		}
+		switch runtime := s.cfg.General.Sandbox; runtime {
+		case "docker", "podman":
+			s.ui.PrintColored(s.ui.Blue, "📦 Starting a %s for the shell steps...\n", container.Describe(runtime, s.cfg.Container))
+			c, err := container.Start(runtime, s.cfg.Container, workdir, s.currentHash)
+			if err != nil {
+				return fmt.Errorf("cannot run the steps in a container: %w", err)
+			}
+			defer func() {
+				if err := c.Remove(); err != nil {
+					s.ui.PrintColored(s.ui.Red, "Error removing the container: %v\n", err)
+				}
+			}()
+			runner.Sandbox = c
+			runner.Wrap = nil // Limits would apply to the runtime's client, not to the steps
+		case "bwrap", "firejail":
+			trust := trustLevel.String()
+			profile := s.cfg.Jail.Profile(trust)
+			j, err := jail.New(runtime, profile)
+			if err != nil {
+				return fmt.Errorf("cannot run the steps in a sandbox: %w", err)
+			}
+			s.ui.PrintColored(s.ui.Blue, "🔒 Shell steps run in %s\n", jail.Describe(runtime, trust, profile))
+			runner.Sandbox = j
+		}
+		s.messageProcessor.SetExecutor(runner)
+	}
+	if s.cfg.Editor.FollowUp {
+		s.messageProcessor.EnableEditorFollowUp(s.cfg.Editor.Command)
+	}
+	if s.cfg.IaC.PlanBeforeApply {
+		s.messageProcessor.EnableIaCPlans(time.Duration(s.cfg.IaC.PlanTimeoutSeconds) * time.Second)
+	}
+	sessions := store.Sessions(s.store)
+	if sessions != nil {
+		s.messageProcessor.OnExecution(func(entry audit.Entry, output string) {
+			s.recordStep(sessions, entry, output)
+		})
+	}
+	s.messageProcessor.OnTokenUsage(s.usage.Add)
 
 	// Clean up old cache files before starting a new session
 	if err := s.cleanupCacheFiles(); err != nil {
@@ -64,8 +548,31 @@ func (s *Session) Run(query string) error {
 	}
 
 	// Set up temporary directory cleanup
-	tempDirPath := filepath.Join(os.TempDir(), "og", s.currentHash)
+	tempDirPath := s.store.Artifacts().Dir(s.currentHash)
+	if historyErr == nil && !s.echo && s.resumed == nil { // The echo agent's sessions are kept in a throwaway store
+		s.indexSession(historyOffset, tempDirPath)
+	}
+
+	if !s.sandboxCopy { // A sandbox copy is reviewed before any change is applied
+		if s.cfg.Undo.Enabled {
+			if j, err := undo.Open(undo.Dir(tempDirPath), s.cfg.Undo.MaxBytes); err != nil {
+				s.ui.PrintColored(s.ui.Yellow, "⚠️  %v; og undo cannot restore what the session changes.\n", err)
+			} else {
+				s.undo = j
+				s.messageProcessor.SetUndo(j)
+			}
+		}
+		s.messageProcessor.SetGitCheckpoint(s.cfg.Undo.GitCheckpoint)
+	}
+
 	defer func() {
+		if len(s.undo.Changes()) > 0 {
+			// Kept for og undo, like the artifacts of an aborted session
+			if err := undo.Prune(tempDirPath); err != nil {
+				s.ui.PrintColored(s.ui.Red, "Error cleaning up temporary directory %s: %v\n", tempDirPath, err)
+			}
+			return
+		}
 		if err := os.RemoveAll(tempDirPath); err != nil {
 			s.ui.PrintColored(s.ui.Red, "Error cleaning up temporary directory %s: %v\n", tempDirPath, err)
 		} else {
@@ -73,22 +580,535 @@ func (s *Session) Run(query string) error {
 		}
 	}()
 
-	// Start Python agent
-	if err := s.processManager.Start(s.cfg, s.currentHash, query, cwd, s.cacheCfg.JSONLogs, s.cacheCfg.Directory); err != nil {
+	if s.cfg.UI.Banner {
+		s.printBanner(trustLevel, grant, cloudContext)
+	} else {
+		if grant != nil {
+			s.ui.PrintColored(s.ui.Yellow, "🔓 Temporarily trusted until %s (granted by %s with `og trust`)\n", grant.Expires.Format("15:04"), grant.GrantedBy)
+		} else if s.tagStrictness != "" {
+			s.ui.PrintColored(s.ui.Blue, "Policy: %s for %s queries\n", s.ui.Cyan(s.tagStrictness), s.tag)
+		} else if trustLevel != policy.TrustDefault {
+			s.ui.PrintColored(s.ui.Blue, "Workspace trust level: %s\n", s.ui.Cyan(trustLevel.String()))
+		}
+		if !cloudContext.Empty() {
+			s.ui.PrintColored(s.ui.Blue, "☁️  Cloud context: %s\n", s.ui.Cyan(cloudContext.Describe()))
+		}
+	}
+
+	if s.readOnly {
+		s.ui.PrintColored(s.ui.Blue, "📖 Read-only mode: steps that write, delete or change state elsewhere are denied.\n")
+	}
+
+	if s.cfg.General.CheckModels && s.replay == nil && !s.echo {
+		for _, w := range modelcheck.Check(context.Background(), []modelcheck.Role{
+			{Name: "planner", Model: s.cfg.PlannerAgent},
+			{Name: "executor", Model: s.cfg.ExecutorAgent},
+			{Name: "auditor", Model: s.cfg.AuditorAgent},
+		}) {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  %s\n", w)
+		}
+	}
+
+	if promptsFile := s.resolvePrompts(tempDirPath); promptsFile != "" {
+		s.processManager.SetPromptsFile(promptsFile)
+	}
+	s.processManager.SetQueryTag(s.tag)
+	s.processManager.SetReadOnly(s.readOnly)
+	s.processManager.SetStepEnv(stepEnv)
+	s.processManager.SetArtifactsDir(tempDirPath)
+	if s.stdin != nil {
+		s.attachStdin(tempDirPath)
+	}
+	if s.files != nil {
+		s.attachFiles(tempDirPath)
+	}
+	if s.lastCommand != nil {
+		s.attachLastCommand(tempDirPath)
+	}
+	if s.cfg.General.RememberRefusals {
+		s.attachRefusals(tempDirPath)
+	}
+
+	if s.recordPath != "" {
+		f, err := os.OpenFile(s.recordPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			return fmt.Errorf("cannot record the session: %w", err)
+		}
+		defer f.Close()
+		s.processManager.SetRecording(f)
+		s.ui.PrintColored(s.ui.Blue, "⏺️  Recording the agent's messages to %s\n", s.ui.Cyan(s.recordPath))
+	}
+	if s.replay != nil {
+		s.processManager.SetReplay(s.replay)
+		s.ui.PrintColored(s.ui.Blue, "⏯️  Replaying a recording instead of running the agent (protocol version %d)\n", s.replay.Protocol)
+	}
+
+	// Start Python agent; the context of earlier stages is only for the agent
+	agentQuery := query
+	if s.context != "" {
+		agentQuery = s.context + "\n\n" + query
+	}
+	if s.resumed != nil {
+		s.processManager.SetResume()
+		s.messageProcessor.Restore(s.resumed)
+	}
+	if err := s.processManager.Start(s.cfg, s.currentHash, agentQuery, workdir, trustLevel.String(), s.cacheCfg.JSONLogs, s.cacheCfg.Directory); err != nil {
+		s.log.Error("failed to start the agent", "error", err.Error())
 		return fmt.Errorf("failed to start python agent: %w", err)
 	}
 	defer s.processManager.Stop() // Ensure Python agent is stopped
+	if s.resumed != nil {
+		s.log.Info("session resumed", "phase", s.resumed.Phase, "next_step", s.resumed.Next())
+		if err := s.messageProcessor.Resume(); err != nil {
+			return fmt.Errorf("failed to resume the session: %w", err)
+		}
+	}
+
+	// Ctrl-C ends the session as aborted, even while waiting at a prompt
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	defer signal.Stop(interrupts)
+	go func() {
+		if _, ok := <-interrupts; ok {
+			s.abort(sessions, interrupts, tempDirPath)
+		}
+	}()
 
 	// Run the main loop to process messages from Python
-	if err := s.messageProcessor.ProcessMessages(); err != nil {
-		return fmt.Errorf("error during agent message processing loop: %w", err)
+	processErr := s.messageProcessor.ProcessMessages()
+	for restarts := 1; s.restartAgent(processErr, restarts); restarts++ {
+		processErr = s.messageProcessor.ProcessMessages()
+	}
+	if s.aborting.Load() {
+		select {} // abort ends the session and exits
+	}
+	s.processManager.Stop()
+	status := s.messageProcessor.Outcome()
+	if importErr := s.processManager.ImportErr(); importErr != nil {
+		// A missing dependency is a setup problem, not a crash worth a bundle
+		s.ui.PrintColored(s.ui.Red, "❌ Cannot run the agent: %v\n", importErr)
+		status = "failed"
+	} else if exitErr := s.processManager.ExitErr(); processErr != nil || exitErr != nil {
+		// Errors the agent classified are explained on the console; the rest are crashes
+		if !slices.Contains([]string{agent.OutcomeModelUnreachable, agent.OutcomeToolFailed, agent.OutcomeAborted}, status) {
+			s.writeCrashBundle(query, processErr, exitErr)
+		}
+		if status == "" || (processErr != nil && status != agent.OutcomeProtocolError) {
+			status = "failed"
+		}
+	}
+	if status == "" {
+		status = "incomplete"
+	}
+	s.recordStatus(sessions, status)
+	s.recordUsage(sessions)
+	s.recordDuration(sessions)
+	s.storeTranscript()
+	s.notifier.Finished(s.redactor.String(query), status, time.Since(s.sessionStart))
+	if processErr != nil {
+		s.log.Error("session ended", "status", status, "duration_ms", time.Since(s.sessionStart).Milliseconds(), "error", processErr.Error())
+		return fmt.Errorf("error during agent message processing loop: %w", processErr)
+	}
+	if status == agent.OutcomeCompleted && s.cfg.General.DistillAfter > 0 && sessions != nil {
+		s.suggestDistill()
 	}
 
+	s.log.Info("session ended", "status", status, "duration_ms", time.Since(s.sessionStart).Milliseconds())
+	s.suggestResume()
+	s.offerUndo()
+	s.reportGitCheckpoint()
 	s.ui.PrintColored(s.ui.Blue, "🚀 OG session ended.\n")
 	return nil
 }
 
-// cleanupCacheFiles removes old session JSON files based on expiration.
+// strictnessTrust maps the strictness of a [classifier.tags] route to the trust
+// level whose policy mode it names.
+var strictnessTrust = map[string]policy.TrustLevel{
+	"strict":   policy.TrustUntrusted,
+	"standard": policy.TrustDefault,
+	"relaxed":  policy.TrustTrusted,
+}
+
+// classifyQuery tags the query and applies the models of the tag's route in
+// [classifier.tags]. It returns the route's policy strictness, if any. A
+// resumed session keeps the tag it was given.
+func (s *Session) classifyQuery(query string) string {
+	if s.resumed == nil {
+		c := classify.New(s.cfg.Classifier)
+		tag, source, err := classify.Classify(c, query, time.Duration(s.cfg.Classifier.TimeoutSeconds)*time.Second)
+		if err != nil {
+			s.ui.PrintColored(s.ui.Yellow, "⚠️  %v; the query was classified by keywords instead.\n", err)
+		}
+		s.tag, s.tagSource = tag, source
+	}
+	route, ok := s.cfg.Classifier.Tags[s.tag]
+	if !ok {
+		return ""
+	}
+	cfg := *s.cfg // The routed models only apply to this session
+	if route.PlannerAgent.Model != "" {
+		cfg.PlannerAgent = route.PlannerAgent
+	}
+	if route.ExecutorAgent.Model != "" {
+		cfg.ExecutorAgent = route.ExecutorAgent
+	}
+	s.cfg = &cfg
+	return route.Strictness
+}
+
+// restartAgent restarts an agent that exited unexpectedly (processErr is an
+// *agent.ExitError) for the given restart, after the [retry] backoff, and tells
+// it to resume. It reports whether ProcessMessages should run again.
+func (s *Session) restartAgent(processErr error, restart int) bool {
+	var exitErr *agent.ExitError
+	if !errors.As(processErr, &exitErr) || s.processManager.ImportErr() != nil || s.aborting.Load() {
+		return false
+	}
+	s.processManager.Stop()
+	msg := exitErr.Error()
+	s.ui.PrintColored(s.ui.Red, "💥 %s%s\n", strings.ToUpper(msg[:1]), msg[1:])
+	if restart > s.cfg.Retry.AgentRestarts {
+		return false
+	}
+	resume := exitErr.Phase != ""
+	if resume && !s.cacheCfg.JSONLogs {
+		// The agent only saves its progress with JSON logs; planning again could repeat steps
+		s.ui.PrintColored(s.ui.Yellow, "⚠️  Not restarting the agent: it cannot resume without cache.json_logs.\n")
+		return false
+	}
+	delay := retry.FromConfig(s.cfg.Retry).Delay(restart)
+	s.ui.PrintColored(s.ui.Yellow, "🔁 Restarting the agent in %s (restart %d/%d)...\n", delay, restart, s.cfg.Retry.AgentRestarts)
+	time.Sleep(delay)
+	if err := s.processManager.Restart(resume); err != nil {
+		s.ui.PrintColored(s.ui.Red, "❌ Failed to restart the agent: %v\n", err)
+		return false
+	}
+	s.log.Info("agent restarted", "restart", restart, "resume", resume)
+	if err := s.messageProcessor.Resume(); err != nil {
+		s.ui.PrintColored(s.ui.Red, "❌ Failed to resume the session: %v\n", err)
+		return false
+	}
+	return true
+}
+
+// Status returns how the session ended (an agent.Outcome*, "failed" or
+// "incomplete"), or "" before Run has finished.
+func (s *Session) Status() string {
+	return s.status
+}
+
+// recordStatus stores how the session ended in the session index and the store.
+func (s *Session) recordStatus(sessions store.SessionStore, status string) {
+	s.status = status
+	s.checkpoint.Ended(status)
+	if err := history.SetIndexStatus(s.currentHash, status); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to update session index: %v\n", err)
+	}
+	if sessions != nil {
+		if err := sessions.SetStatus(s.currentHash, status); err != nil {
+			s.ui.PrintColored(s.ui.Red, "Failed to store session status: %v\n", err)
+		}
+	}
+}
+
+// suggestDistill points the user to og distill once sessions in the directory
+// ran the commands this one did general.distill_after times, unless og distill
+// already made a target of them.
+func (s *Session) suggestDistill() {
+	statuses, err := history.Statuses()
+	if err != nil {
+		return
+	}
+	runs, err := distill.Runs(s.store, statuses, s.cwd, s.cfg.Storage.User)
+	if err != nil {
+		return
+	}
+	i := slices.IndexFunc(runs, func(r distill.Run) bool { return r.Session == s.currentHash })
+	if i < 0 {
+		return
+	}
+	n := distill.Count(runs, runs[i])
+	if n < s.cfg.General.DistillAfter || distill.Distilled(s.store.Memory(), s.cwd, runs[i].Commands) {
+		return
+	}
+	s.ui.PrintColored(s.ui.Blue, "💡 Sessions here have run these commands %d times; `og distill` can make them a Makefile target or Taskfile task.\n", n)
+}
+
+// artifactFiles lists the files in a session's artifacts directory, besides
+// the backups of the files its steps changed.
+func artifactFiles(dir string) []string {
+	var files []string
+	filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() && path == undo.Dir(dir) {
+			return filepath.SkipDir
+		}
+		if err == nil && !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files
+}
+
+// abort ends an interrupted session and exits, since the main loop may be
+// blocked reading a prompt. It asks the agent to cancel, so that the session's
+// transcript is saved and its partial results are reported, and waits for that
+// up to cancelDeadline or another Ctrl-C before stopping the agent. The session
+// is recorded as aborted, and its artifacts directory is kept if there are
+// files in it.
+func (s *Session) abort(sessions store.SessionStore, interrupts <-chan os.Signal, artifactsDir string) {
+	s.aborting.Store(true)
+	s.ui.PrintColored(s.ui.Yellow, "\n🛑 Cancelling the session (Ctrl-C again to stop at once)...\n")
+	ctx, cancel := context.WithTimeout(context.Background(), cancelDeadline)
+	defer cancel()
+	go func() {
+		select {
+		case <-interrupts:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	report := s.messageProcessor.Cancel(ctx)
+	if report == nil {
+		s.ui.PrintColored(s.ui.Yellow, "The agent did not confirm the cancellation; stopping it.\n")
+		s.processManager.Interrupt()
+	}
+	s.processManager.Stop()
+	s.recordStatus(sessions, agent.OutcomeAborted)
+	s.recordUsage(sessions)
+	s.recordDuration(sessions)
+	s.storeTranscript()
+	s.log.Warn("session ended", "status", agent.OutcomeAborted, "duration_ms", time.Since(s.sessionStart).Milliseconds(), "confirmed", report != nil)
+	s.endTrace()
+	artifacts := artifactFiles(artifactsDir)
+	if len(artifacts) == 0 && len(s.undo.Changes()) == 0 {
+		os.RemoveAll(artifactsDir)
+	} else if report == nil {
+		s.ui.PrintColored(s.ui.Blue, "Artifacts kept:\n")
+		for _, a := range artifacts {
+			fmt.Printf("  %s\n", s.ui.Cyan(a))
+		}
+	}
+	s.ui.PrintColored(s.ui.Yellow, "🛑 Session aborted.\n")
+	s.suggestResume()
+	s.suggestUndo()
+	s.reportGitCheckpoint()
+	os.Exit(ExitCode(agent.OutcomeAborted))
+}
+
+// failedStatuses are the session statuses whose span is marked as failed.
+var failedStatuses = []string{"failed", agent.OutcomeError, agent.OutcomeModelUnreachable, agent.OutcomeToolFailed, agent.OutcomeProtocolError, agent.OutcomeHookFailed}
+
+// endTrace ends the session's span with how the session ended, and exports
+// the spans not exported yet.
+func (s *Session) endTrace() {
+	s.endTraceOnce.Do(func() {
+		s.span.SetAttributes(attribute.String("og.status", s.status))
+		if slices.Contains(failedStatuses, s.status) {
+			s.span.SetStatus(codes.Error, s.status)
+		}
+		s.span.End()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := s.stopTracing(ctx); err != nil {
+			s.log.Warn("failed to export traces", "error", err.Error())
+		}
+	})
+}
+
+// reviewSandbox shows what the session changed in its sandbox copy and applies
+// the files the user selects to the real working directory.
+func (s *Session) reviewSandbox(sb *sandbox.Copy) {
+	changes, err := sb.Changes()
+	if err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to compare sandbox copy: %v\n", err)
+		return
+	}
+	if len(changes) == 0 {
+		s.ui.PrintColored(s.ui.Blue, "🧪 The session made no changes in the sandbox copy.\n")
+		return
+	}
+	fmt.Printf("\n%s\n\n%s\n", s.ui.Yellow("🧪 Changes made in the sandbox copy"), ui.FormatDiff(sb.Diff(changes)))
+
+	byPath := make(map[string]sandbox.Change, len(changes))
+	paths := make([]string, len(changes))
+	for i, ch := range changes {
+		byPath[ch.Path] = ch
+		paths[i] = ch.Path
+	}
+	selected, _ := s.ui.PromptForPathSelection(fmt.Sprintf("Apply these changes to %s?", s.cwd), paths)
+	if len(selected) == 0 {
+		s.ui.PrintColored(s.ui.Yellow, "Sandbox changes discarded.\n")
+		return
+	}
+	apply := make([]sandbox.Change, len(selected))
+	for i, p := range selected {
+		apply[i] = byPath[p]
+	}
+
+	start := time.Now()
+	err = sb.Apply(apply)
+	entry := audit.Entry{
+		Session:    s.currentHash,
+		Event:      audit.EventExecution,
+		Tool:       "sandbox_copy",
+		Command:    strings.Join(selected, " "),
+		Identity:   s.cfg.Storage.User,
+		Role:       "user",
+		Decision:   "approved",
+		Status:     "success",
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Status, entry.Reason = "failure", err.Error()
+	}
+	if auditErr := audit.Append(entry); auditErr != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to write audit log: %v\n", auditErr)
+	}
+	if err != nil {
+		s.ui.PrintColored(s.ui.Red, "❌ Failed to apply sandbox changes: %v\n", err)
+		return
+	}
+	s.ui.PrintColored(s.ui.Green, "✅ Applied %d of %d changed file(s) to %s\n", len(apply), len(changes), s.ui.Cyan(s.cwd))
+}
+
+// storeTranscript hands the session JSON written by the agent to the storage backend.
+func (s *Session) storeTranscript() {
+	if !s.cacheCfg.JSONLogs {
+		return
+	}
+	data, err := os.ReadFile(filepath.Join(s.cacheCfg.Directory, s.currentHash+".json"))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			s.ui.PrintColored(s.ui.Red, "Failed to read session transcript: %v\n", err)
+		}
+		return
+	}
+	if err := s.store.Transcripts().Put(s.currentHash, s.redactor.Bytes(data)); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to store session transcript: %v\n", err)
+	}
+}
+
+// recordDuration stores how long the session ran with its history.
+func (s *Session) recordDuration(sessions store.SessionStore) {
+	d := time.Since(s.sessionStart)
+	if err := history.SetIndexDuration(s.currentHash, d); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to update session index: %v\n", err)
+	}
+	if sessions != nil {
+		if err := sessions.SetDuration(s.currentHash, d); err != nil {
+			s.ui.PrintColored(s.ui.Red, "Failed to store session duration: %v\n", err)
+		}
+	}
+}
+
+// recordUsage shows the tokens the session consumed and their cost, and stores
+// the totals with the session's history.
+func (s *Session) recordUsage(sessions store.SessionStore) {
+	if s.usage.Empty() {
+		return
+	}
+	s.ui.PrintColored(s.ui.Blue, "💰 Tokens: %s\n", s.usage.Format(s.cfg.Pricing))
+	totals := s.usage.Usage(s.cfg.Pricing)
+	s.log.Info("usage", "prompt_tokens", totals.PromptTokens, "completion_tokens", totals.CompletionTokens, "cost", totals.Cost)
+	if err := history.SetIndexUsage(s.currentHash, totals); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to update session index: %v\n", err)
+	}
+	if sessions != nil {
+		if err := sessions.SetUsage(s.currentHash, totals); err != nil {
+			s.ui.PrintColored(s.ui.Red, "Failed to store session usage: %v\n", err)
+		}
+	}
+}
+
+// recordStep stores an executed action and its result with the backend's session metadata.
+func (s *Session) recordStep(sessions store.SessionStore, entry audit.Entry, output string) {
+	err := sessions.AddStep(store.Step{
+		Session:    s.currentHash,
+		TS:         entry.TS,
+		Tool:       entry.Tool,
+		Command:    entry.Command,
+		Approver:   entry.Identity,
+		Status:     entry.Status,
+		ExitCode:   entry.ExitCode,
+		DurationMs: entry.DurationMs,
+		Output:     output,
+	})
+	if err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to store session step: %v\n", err)
+	}
+}
+
+// stepEnv returns the variables added to the environment of the commands the
+// session runs, so that scripts can tell that og drives them: the session's
+// hash, the directory the steps run in, and whether their changes stay out of
+// the real directory unless reviewed (--sandbox-copy) or are denied
+// (--read-only). OG_STEP_ID is added per step.
+func (s *Session) stepEnv(workdir string) []string {
+	dryRun := "0"
+	if s.sandboxCopy || s.readOnly {
+		dryRun = "1"
+	}
+	return []string{"OG_SESSION_HASH=" + s.currentHash, "OG_WORKDIR=" + workdir, "OG_DRY_RUN=" + dryRun}
+}
+
+// readOnlyDatabases returns databases with writes disallowed, so that queries
+// run in read-only transactions.
+func readOnlyDatabases(databases map[string]config.DatabaseCfg) map[string]config.DatabaseCfg {
+	out := make(map[string]config.DatabaseCfg, len(databases))
+	for name, db := range databases {
+		db.AllowWrites = false
+		out[name] = db
+	}
+	return out
+}
+
+// indexSession records where this session's files live in the session index.
+func (s *Session) indexSession(historyOffset int64, artifactsDir string) {
+	entry := history.IndexEntry{
+		Hash:          s.currentHash,
+		HistoryOffset: historyOffset,
+		ArtifactsDir:  artifactsDir,
+	}
+	if s.cacheCfg.JSONLogs {
+		entry.TranscriptPath = filepath.Join(s.cacheCfg.Directory, s.currentHash+".json")
+		entry.AgentLogPath = agent.AgentLogPath(s.cacheCfg.Directory, s.currentHash)
+	}
+	if s.cfg.Log.File {
+		entry.LogPath = sessionlog.Path(s.cacheCfg.Directory, s.currentHash)
+	}
+	if err := history.PutIndexEntry(entry); err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to update session index: %v\n", err)
+	}
+}
+
+// writeCrashBundle packages the agent log, session log and session JSON of a failed session for bug reports.
+func (s *Session) writeCrashBundle(query string, processErr, exitErr error) {
+	cause := processErr
+	if cause == nil {
+		cause = exitErr
+	}
+	extra := map[string]string{"cwd": s.cwd}
+	if exitErr != nil {
+		extra["agent_exit"] = exitErr.Error()
+	}
+	query = s.redactor.String(query)
+	files := []string{
+		agent.AgentLogPath(s.cacheCfg.Directory, s.currentHash),
+		sessionlog.Path(s.cacheCfg.Directory, s.currentHash),
+		filepath.Join(s.cacheCfg.Directory, s.currentHash+".json"),
+	}
+	report := diag.FormatReport(s.currentHash, query, cause, extra)
+	path, err := diag.WriteCrashBundle(s.cacheCfg.Directory, s.currentHash, report, files)
+	if err != nil {
+		s.ui.PrintColored(s.ui.Red, "Failed to write crash bundle: %v\n", err)
+		return
+	}
+	s.log.Error("crash bundle written", "path", path, "error", cause.Error())
+	s.ui.PrintColored(s.ui.Yellow, "The agent exited abnormally. A crash bundle was written to: %s\n", s.ui.Cyan(path))
+}
+
+// cleanupCacheFiles removes old session JSON files, logs and crash bundles based on expiration.
 func (s *Session) cleanupCacheFiles() error {
 	if s.cacheCfg.Expiration <= 0 {
 		s.ui.PrintColored(s.ui.Blue, "Cache expiration not set or invalid (<=0 days). Skipping old session file cleanup.\n")
@@ -109,36 +1129,16 @@ func (s *Session) cleanupCacheFiles() error {
 
 	s.ui.PrintColored(s.ui.Blue, "Cleaning up cache files in %s older than %s...\n", s.ui.Cyan(cacheDir), expirationThreshold.Format("2006-01-02 15:04:05"))
 
-	files, err := os.ReadDir(cacheDir)
+	expired, err := maintenance.ExpiredCacheFiles(cacheDir, expirationThreshold)
 	if err != nil {
-		if os.IsNotExist(err) {
-			s.ui.PrintColored(s.ui.Yellow, "Cache directory %s does not exist, no files to clean.\n", cacheDir)
-			return nil
-		}
-		return fmt.Errorf("failed to read cache directory %s: %w", cacheDir, err)
-	}
-
-	for _, file := range files {
-		if strings.HasSuffix(file.Name(), ".json") && !file.IsDir() {
-			s.deleteFileIfExpired(filepath.Join(cacheDir, file.Name()), expirationThreshold)
-		}
+		return err
 	}
-	return nil
-}
-
-// deleteFileIfExpired checks a file's modification time and deletes it if it's older than the threshold.
-func (s *Session) deleteFileIfExpired(filePath string, threshold time.Time) {
-	fileInfo, err := os.Stat(filePath)
-	if err != nil {
-		s.ui.PrintColored(s.ui.Red, "Error stat-ing file %s: %v\n", filePath, err)
-		return
-	}
-
-	if fileInfo.ModTime().Before(threshold) {
-		if err := os.Remove(filePath); err != nil {
-			s.ui.PrintColored(s.ui.Red, "Error deleting expired file %s: %v\n", filePath, err)
+	for _, item := range expired {
+		if err := maintenance.Remove(item); err != nil {
+			s.ui.PrintColored(s.ui.Red, "Error deleting expired file: %v\n", err)
 		} else {
-			s.ui.PrintColored(s.ui.Green, "Deleted expired file: %s\n", s.ui.Cyan(filepath.Base(filePath)))
+			s.ui.PrintColored(s.ui.Green, "Deleted expired file: %s\n", s.ui.Cyan(filepath.Base(item.Path)))
 		}
 	}
+	return nil
 }
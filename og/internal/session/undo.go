@@ -0,0 +1,103 @@
+package session
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/undo"
+)
+
+// offerUndo, when steps of the session changed files og backed up, asks
+// which of the changes to undo (undo.ask_at_end, in a terminal), or points
+// the user to og undo.
+func (s *Session) offerUndo() {
+	changes := s.undo.Changes()
+	if len(changes) == 0 {
+		return
+	}
+	if s.cfg.Undo.AskAtEnd && term.IsTerminal(int(os.Stdin.Fd())) {
+		UndoChanges(s.ui, s.currentHash, s.cfg.Storage.User, s.undo.Dir(), changes)
+	}
+	s.suggestUndo()
+}
+
+// suggestUndo points the user to og undo when steps of the session changed
+// files og backed up.
+func (s *Session) suggestUndo() {
+	if changes := s.undo.Changes(); len(changes) > 0 {
+		s.ui.PrintColored(s.ui.Blue, "↩️  Steps changed %d file(s) og backed up; `og undo %s` restores them as the session found them.\n", len(changes), s.currentHash)
+	}
+}
+
+// reportGitCheckpoint tells how the git repository was recorded before the
+// first step that wrote (undo.git_checkpoint), and how to recover from it.
+func (s *Session) reportGitCheckpoint() {
+	if s.messageProcessor == nil {
+		return
+	}
+	if c := s.messageProcessor.GitCheckpoint(); c != nil {
+		s.ui.PrintColored(s.ui.Blue, "🛟 Git checkpoint: %s\n", c.Describe())
+	}
+}
+
+// UndoChanges describes changes, which the session hash made to files it
+// backed up in dir, asks which to undo, and restores those as they were.
+// The restore is recorded in the audit log, and its error returned.
+func UndoChanges(consoleUI ui.UI, hash, user, dir string, changes []undo.Entry) error {
+	byPath := make(map[string]undo.Entry, len(changes))
+	paths := make([]string, len(changes))
+	counts := map[string]int{}
+	for i, e := range changes {
+		byPath[e.Path] = e
+		paths[i] = e.Path
+		counts[e.Change()]++
+	}
+	var described []string
+	for _, change := range []string{"changed", "deleted", "created"} {
+		if counts[change] > 0 {
+			described = append(described, fmt.Sprintf("%d %s", counts[change], change))
+		}
+	}
+	consoleUI.PrintColored(consoleUI.Blue, "↩️  Files og backed up before steps changed them: %s.\n", strings.Join(described, ", "))
+	selected, _ := consoleUI.PromptForPathSelection("Restore these files as the session found them? (created ones are removed)", paths)
+	if len(selected) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "Nothing restored.\n")
+		return nil
+	}
+	restore := make([]undo.Entry, len(selected))
+	for i, p := range selected {
+		restore[i] = byPath[p]
+	}
+
+	start := time.Now()
+	err := undo.Restore(dir, restore)
+	entry := audit.Entry{
+		Session:    hash,
+		Event:      audit.EventExecution,
+		Tool:       "undo",
+		Command:    strings.Join(selected, " "),
+		Identity:   user,
+		Role:       "user",
+		Decision:   "approved",
+		Status:     "success",
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if err != nil {
+		entry.Status, entry.Reason = "failure", err.Error()
+	}
+	if auditErr := audit.Append(entry); auditErr != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to write audit log: %v\n", auditErr)
+	}
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "❌ Failed to undo the changes: %v\n", err)
+		return err
+	}
+	consoleUI.PrintColored(consoleUI.Green, "✅ Restored %d of %d file(s).\n", len(restore), len(changes))
+	return nil
+}
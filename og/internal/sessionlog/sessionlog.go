@@ -0,0 +1,112 @@
+// Package sessionlog writes og's own log of a session with log/slog: what it
+// decided, ran and heard from the agent, at the levels of ui.LogLevel. The log
+// is kept in the cache directory as <hash>.og.log, next to the session JSON and
+// the agent log, and is written at its own level ([log]), whatever the console
+// shows.
+package sessionlog
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Suffix ends the name of a session's log file.
+const Suffix = ".og.log"
+
+// Path returns where the log of session hash is written in cacheDir.
+func Path(cacheDir, hash string) string {
+	return filepath.Join(cacheDir, hash+Suffix)
+}
+
+// Level returns the slog level of an og log level; "none" logs errors only.
+func Level(l ui.LogLevel) slog.Level {
+	switch l {
+	case ui.LogLevelDebug:
+		return slog.LevelDebug
+	case ui.LogLevelWarn:
+		return slog.LevelWarn
+	case ui.LogLevelNone:
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Open opens the log of session hash in cacheDir as cfg says, appending to it
+// when an earlier run of the session left one. Secrets in what is logged are
+// masked with redactor, which may be nil. The caller closes the returned file
+// once the session ended.
+func Open(cfg config.LogCfg, cacheDir, hash string, redactor *redact.Redactor) (*slog.Logger, io.Closer, error) {
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return nil, nil, fmt.Errorf("failed to create cache directory %s: %w", cacheDir, err)
+	}
+	path := Path(cacheDir, hash)
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open session log %s: %w", path, err)
+	}
+	level, _ := ui.ParseLogLevel(cfg.Level) // Validated by LoadConfig
+	opts := &slog.HandlerOptions{Level: Level(level)}
+	var h slog.Handler = slog.NewTextHandler(f, opts)
+	if cfg.Format == "json" {
+		h = slog.NewJSONHandler(f, opts)
+	}
+	if redactor != nil {
+		h = redacting{h, redactor}
+	}
+	return slog.New(h), f, nil
+}
+
+// redacting masks secrets in the messages and string attributes it passes on.
+type redacting struct {
+	slog.Handler
+	redactor *redact.Redactor
+}
+
+func (h redacting) Handle(ctx context.Context, r slog.Record) error {
+	out := slog.NewRecord(r.Time, r.Level, h.redactor.String(r.Message), r.PC)
+	r.Attrs(func(a slog.Attr) bool {
+		out.AddAttrs(h.attr(a))
+		return true
+	})
+	return h.Handler.Handle(ctx, out)
+}
+
+func (h redacting) WithAttrs(attrs []slog.Attr) slog.Handler {
+	masked := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		masked[i] = h.attr(a)
+	}
+	return redacting{h.Handler.WithAttrs(masked), h.redactor}
+}
+
+func (h redacting) WithGroup(name string) slog.Handler {
+	return redacting{h.Handler.WithGroup(name), h.redactor}
+}
+
+func (h redacting) attr(a slog.Attr) slog.Attr {
+	switch a.Value.Kind() {
+	case slog.KindString:
+		a.Value = slog.StringValue(h.redactor.String(a.Value.String()))
+	case slog.KindGroup:
+		group := a.Value.Group()
+		masked := make([]slog.Attr, len(group))
+		for i, g := range group {
+			masked[i] = h.attr(g)
+		}
+		a.Value = slog.GroupValue(masked...)
+	}
+	return a
+}
+
+// Discard returns a logger that logs nothing, for sessions without a log file.
+func Discard() *slog.Logger {
+	return slog.New(slog.DiscardHandler)
+}
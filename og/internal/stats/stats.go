@@ -0,0 +1,151 @@
+// Package stats aggregates the session history, session index and audit log
+// into a usage report.
+package stats
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+// Report summarizes a set of sessions.
+type Report struct {
+	From     string `json:"from,omitempty"` // Timestamp of the first session
+	To       string `json:"to,omitempty"`   // Timestamp of the last session
+	Sessions int    `json:"sessions"`
+
+	Weeks    []Count        `json:"weeks"`    // Sessions per ISO week, oldest first, including empty weeks
+	Statuses map[string]int `json:"statuses"` // Sessions per outcome; unknown outcomes count as "-"
+	Tags     []Count        `json:"tags"`     // Sessions per query tag, most frequent first; untagged sessions count as "-"
+	Tools    []Count        `json:"tools"`    // Executed actions per tool, most used first
+
+	Approvals    Approvals `json:"approvals"`
+	AvgDuration  float64   `json:"avg_duration_seconds"` // Over the sessions with a recorded duration
+	WithDuration int       `json:"sessions_with_duration"`
+
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Cost             float64 `json:"cost"` // Estimated from [pricing], in its currency
+	WithUsage        int     `json:"sessions_with_usage"`
+}
+
+// Count is the number of occurrences of a key, e.g. sessions in a week.
+type Count struct {
+	Key   string `json:"key"`
+	Count int    `json:"count"`
+}
+
+// Approvals counts approval decisions.
+type Approvals struct {
+	Approved int `json:"approved"`
+	Denied   int `json:"denied"`
+}
+
+// Ratio returns the share of decisions that approved, or 0 without decisions.
+func (a Approvals) Ratio() float64 {
+	if a.Approved+a.Denied == 0 {
+		return 0
+	}
+	return float64(a.Approved) / float64(a.Approved+a.Denied)
+}
+
+// Compute builds a report over records. Index entries and audit entries of
+// other sessions are ignored.
+func Compute(records []history.HistoryRecord, index map[string]history.IndexEntry, entries []audit.Entry) Report {
+	r := Report{Sessions: len(records), Weeks: []Count{}, Statuses: map[string]int{}}
+	selected := make(map[string]bool, len(records))
+	weeks, tags := map[string]int{}, map[string]int{}
+	var first, last time.Time
+	var totalDurationMs int64
+	for _, rec := range records {
+		selected[rec.Hash] = true
+		if t := rec.Time(); !t.IsZero() {
+			weeks[week(t)]++
+			if first.IsZero() || t.Before(first) {
+				first, r.From = t, rec.TS
+			}
+			if t.After(last) {
+				last, r.To = t, rec.TS
+			}
+		}
+
+		tag := rec.Tag
+		if tag == "" {
+			tag = "-"
+		}
+		tags[tag]++
+
+		e := index[rec.Hash]
+		status := e.Status
+		if status == "" {
+			status = "-"
+		}
+		r.Statuses[status]++
+		if e.DurationMs > 0 {
+			totalDurationMs += e.DurationMs
+			r.WithDuration++
+		}
+		if e.Usage != nil {
+			r.PromptTokens += e.Usage.PromptTokens
+			r.CompletionTokens += e.Usage.CompletionTokens
+			r.Cost += e.Usage.Cost
+			r.WithUsage++
+		}
+	}
+	r.Tags = sorted(tags)
+	if r.WithDuration > 0 {
+		r.AvgDuration = float64(totalDurationMs) / float64(r.WithDuration) / 1000
+	}
+	if !first.IsZero() {
+		for t := first; ; t = t.AddDate(0, 0, 7) {
+			r.Weeks = append(r.Weeks, Count{Key: week(t), Count: weeks[week(t)]})
+			if week(t) == week(last) {
+				break
+			}
+		}
+	}
+
+	tools := map[string]int{}
+	for _, e := range entries {
+		if !selected[e.Session] {
+			continue
+		}
+		switch e.Event {
+		case audit.EventExecution:
+			tools[e.Tool]++
+		case audit.EventApproval:
+			switch e.Decision {
+			case "approved":
+				r.Approvals.Approved++
+			case "denied":
+				r.Approvals.Denied++
+			}
+		}
+	}
+	r.Tools = sorted(tools)
+	return r
+}
+
+// week names the ISO week of t, e.g. "2025-W07".
+func week(t time.Time) string {
+	y, w := t.ISOWeek()
+	return fmt.Sprintf("%d-W%02d", y, w)
+}
+
+// sorted returns counts by descending count, then key.
+func sorted(m map[string]int) []Count {
+	counts := make([]Count, 0, len(m))
+	for k, n := range m {
+		counts = append(counts, Count{Key: k, Count: n})
+	}
+	sort.Slice(counts, func(i, j int) bool {
+		if counts[i].Count != counts[j].Count {
+			return counts[i].Count > counts[j].Count
+		}
+		return counts[i].Key < counts[j].Key
+	})
+	return counts
+}
@@ -0,0 +1,218 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/filelock"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+func init() {
+	Register("filesystem", openFS)
+	Register("shared", openShared)
+}
+
+// fsStore keeps everything in plain files: a JSON-lines history file, one JSON
+// transcript per session, a temp directory per session, and a JSON file for memory.
+type fsStore struct {
+	historyPath   string
+	transcriptDir string
+	tempRoot      string
+	memPath       string
+}
+
+// openFS opens the default, single-user layout under the data directory.
+func openFS(cfg *config.OGConfig) (Store, error) {
+	dataDir, err := config.GetDataDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get data directory: %w", err)
+	}
+	historyPath, err := history.GetHistoryPath()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get history path: %w", err)
+	}
+	return &fsStore{
+		historyPath:   historyPath,
+		transcriptDir: cfg.Cache.Directory,
+		tempRoot:      cfg.General.TempDir(),
+		memPath:       filepath.Join(dataDir, "memory.json"),
+	}, nil
+}
+
+// openShared opens a team layout rooted at storage.path (e.g. a network mount):
+// one history file for everyone, with transcripts and memory kept per user.
+// The agent still writes transcripts to the local cache directory; they are
+// copied to the shared location at the end of each session.
+func openShared(cfg *config.OGConfig) (Store, error) {
+	root := cfg.Storage.Path
+	if root == "" {
+		return nil, fmt.Errorf("storage backend 'shared' requires 'storage.path'")
+	}
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create shared store %s: %w", root, err)
+	}
+	user := cfg.Storage.User
+	return &fsStore{
+		historyPath:   filepath.Join(root, "history.json"),
+		transcriptDir: filepath.Join(root, "transcripts", user),
+		tempRoot:      cfg.General.TempDir(),
+		memPath:       filepath.Join(root, "memory", user+".json"),
+	}, nil
+}
+
+func (s *fsStore) History() HistoryStore        { return fsHistory{path: s.historyPath} }
+func (s *fsStore) Transcripts() TranscriptStore { return fsTranscripts{dir: s.transcriptDir} }
+func (s *fsStore) Artifacts() ArtifactStore     { return fsArtifacts{root: s.tempRoot} }
+func (s *fsStore) Memory() MemoryStore          { return fsMemory{path: s.memPath} }
+func (s *fsStore) Close() error                 { return nil }
+
+// fsHistory is an append-only JSON-lines history file.
+type fsHistory struct{ path string }
+
+func (h fsHistory) Append(rec history.HistoryRecord) (int64, error) {
+	return history.AppendRecordTo(h.path, rec)
+}
+
+func (h fsHistory) List() ([]history.HistoryRecord, error) {
+	return history.ReadRecords(h.path)
+}
+
+// Delete rewrites the history file without the given sessions, keeping the
+// offsets in the session index in step with it.
+func (h fsHistory) Delete(hashes []string) error {
+	remove := make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		remove[hash] = true
+	}
+	offsets, err := history.RemoveRecords(h.path, remove)
+	if err != nil {
+		return err
+	}
+	return history.SetIndexOffsets(offsets)
+}
+
+// fsTranscripts reads and writes <hash>.json in the cache directory, which is
+// where the agent writes them.
+type fsTranscripts struct{ dir string }
+
+func (t fsTranscripts) path(hash string) string { return filepath.Join(t.dir, hash+".json") }
+
+func (t fsTranscripts) Get(hash string) ([]byte, error) {
+	return os.ReadFile(t.path(hash))
+}
+
+func (t fsTranscripts) Put(hash string, data []byte) error {
+	if existing, err := os.ReadFile(t.path(hash)); err == nil && string(existing) == string(data) {
+		return nil // Already in place (the agent wrote it)
+	}
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return fmt.Errorf("failed to create transcript directory %s: %w", t.dir, err)
+	}
+	return os.WriteFile(t.path(hash), data, 0o644)
+}
+
+func (t fsTranscripts) Delete(hash string) error {
+	if err := os.Remove(t.path(hash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// fsArtifacts keeps one directory per session under the temp root.
+type fsArtifacts struct{ root string }
+
+func (a fsArtifacts) Dir(hash string) string { return filepath.Join(a.root, hash) }
+
+func (a fsArtifacts) Delete(hash string) error { return os.RemoveAll(a.Dir(hash)) }
+
+// fsMemory stores all scopes in a single JSON document.
+type fsMemory struct{ path string }
+
+func (m fsMemory) load() (map[string]map[string]string, error) {
+	all := map[string]map[string]string{}
+	data, err := os.ReadFile(m.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return all, nil
+		}
+		return nil, fmt.Errorf("failed to read memory file %s: %w", m.path, err)
+	}
+	if err := json.Unmarshal(data, &all); err != nil {
+		return nil, fmt.Errorf("failed to parse memory file %s: %w", m.path, err)
+	}
+	return all, nil
+}
+
+func (m fsMemory) save(all map[string]map[string]string) error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create memory directory: %w", err)
+	}
+	b, err := json.MarshalIndent(all, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal memory: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("failed to write memory file %s: %w", tmp, err)
+	}
+	return os.Rename(tmp, m.path)
+}
+
+func (m fsMemory) Get(scope, key string) (string, bool, error) {
+	all, err := m.load()
+	if err != nil {
+		return "", false, err
+	}
+	v, ok := all[scope][key]
+	return v, ok, nil
+}
+
+// update applies change to the stored memory under the memory file's lock, so
+// concurrent sessions don't lose each other's entries.
+func (m fsMemory) update(change func(all map[string]map[string]string)) error {
+	if err := os.MkdirAll(filepath.Dir(m.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create memory directory: %w", err)
+	}
+	return filelock.With(m.path, func() error {
+		all, err := m.load()
+		if err != nil {
+			return err
+		}
+		change(all)
+		return m.save(all)
+	})
+}
+
+func (m fsMemory) Put(scope, key, value string) error {
+	return m.update(func(all map[string]map[string]string) {
+		if all[scope] == nil {
+			all[scope] = map[string]string{}
+		}
+		all[scope][key] = value
+	})
+}
+
+func (m fsMemory) Delete(scope, key string) error {
+	return m.update(func(all map[string]map[string]string) {
+		delete(all[scope], key)
+		if len(all[scope]) == 0 {
+			delete(all, scope)
+		}
+	})
+}
+
+func (m fsMemory) List(scope string) (map[string]string, error) {
+	all, err := m.load()
+	if err != nil {
+		return nil, err
+	}
+	out := map[string]string{}
+	for k, v := range all[scope] {
+		out[k] = v
+	}
+	return out, nil
+}
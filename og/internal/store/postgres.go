@@ -0,0 +1,176 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+
+	"github.com/lib/pq" // Also registers the "postgres" database/sql driver
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+func init() {
+	Register("postgres", openPostgres)
+}
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS og_history (
+	id       BIGSERIAL PRIMARY KEY,
+	ts       TEXT NOT NULL,
+	hash     TEXT NOT NULL,
+	cwd      TEXT NOT NULL,
+	query    TEXT NOT NULL,
+	username TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS og_history_hash ON og_history(hash);
+CREATE INDEX IF NOT EXISTS og_history_username ON og_history(username);
+ALTER TABLE og_history ADD COLUMN IF NOT EXISTS tag TEXT NOT NULL DEFAULT '';
+ALTER TABLE og_history ADD COLUMN IF NOT EXISTS parent TEXT NOT NULL DEFAULT '';
+CREATE TABLE IF NOT EXISTS og_transcripts (
+	hash     TEXT PRIMARY KEY,
+	username TEXT NOT NULL DEFAULT '',
+	data     BYTEA NOT NULL
+);
+CREATE TABLE IF NOT EXISTS og_memory (
+	username TEXT NOT NULL,
+	scope    TEXT NOT NULL,
+	key      TEXT NOT NULL,
+	value    TEXT NOT NULL,
+	PRIMARY KEY (username, scope, key)
+);
+`
+
+// postgresStore is a team backend: history and transcripts are shared and
+// attributed to a user, memory is private to each user.
+type postgresStore struct {
+	db        *sql.DB
+	user      string
+	artifacts fsArtifacts
+}
+
+func openPostgres(cfg *config.OGConfig) (Store, error) {
+	if cfg.Storage.DSN == "" {
+		return nil, fmt.Errorf("storage backend 'postgres' requires 'storage.dsn'")
+	}
+	db, err := sql.Open("postgres", cfg.Storage.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open postgres connection: %w", err)
+	}
+	if _, err := db.Exec(postgresSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize postgres schema: %w", err)
+	}
+	return &postgresStore{
+		db:        db,
+		user:      cfg.Storage.User,
+		artifacts: fsArtifacts{root: cfg.General.TempDir()},
+	}, nil
+}
+
+func (s *postgresStore) History() HistoryStore        { return postgresHistory{s} }
+func (s *postgresStore) Transcripts() TranscriptStore { return postgresTranscripts{s} }
+func (s *postgresStore) Artifacts() ArtifactStore     { return s.artifacts }
+func (s *postgresStore) Memory() MemoryStore          { return postgresMemory{s} }
+func (s *postgresStore) Close() error                 { return s.db.Close() }
+
+type postgresHistory struct{ s *postgresStore }
+
+func (h postgresHistory) Append(rec history.HistoryRecord) (int64, error) {
+	var id int64
+	err := h.s.db.QueryRow(`INSERT INTO og_history (ts, hash, cwd, query, username, tag, parent) VALUES ($1, $2, $3, $4, $5, $6, $7) RETURNING id`,
+		rec.TS, rec.Hash, rec.CWD, rec.Query, rec.User, rec.Tag, rec.Parent).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert history record: %w", err)
+	}
+	return id, nil
+}
+
+func (h postgresHistory) List() ([]history.HistoryRecord, error) {
+	rows, err := h.s.db.Query(`SELECT ts, hash, cwd, query, username, tag, parent FROM og_history ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+	var records []history.HistoryRecord
+	for rows.Next() {
+		var rec history.HistoryRecord
+		if err := rows.Scan(&rec.TS, &rec.Hash, &rec.CWD, &rec.Query, &rec.User, &rec.Tag, &rec.Parent); err != nil {
+			return nil, fmt.Errorf("failed to read history row: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+func (h postgresHistory) Delete(hashes []string) error {
+	if _, err := h.s.db.Exec(`DELETE FROM og_history WHERE hash = ANY($1)`, pq.Array(hashes)); err != nil {
+		return fmt.Errorf("failed to delete history records: %w", err)
+	}
+	return nil
+}
+
+type postgresTranscripts struct{ s *postgresStore }
+
+func (t postgresTranscripts) Get(hash string) ([]byte, error) {
+	var data []byte
+	err := t.s.db.QueryRow(`SELECT data FROM og_transcripts WHERE hash = $1`, hash).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no transcript stored for session %s: %w", hash, os.ErrNotExist)
+	}
+	return data, err
+}
+
+func (t postgresTranscripts) Put(hash string, data []byte) error {
+	_, err := t.s.db.Exec(`INSERT INTO og_transcripts (hash, username, data) VALUES ($1, $2, $3)
+		ON CONFLICT (hash) DO UPDATE SET data = EXCLUDED.data`, hash, t.s.user, data)
+	return err
+}
+
+func (t postgresTranscripts) Delete(hash string) error {
+	_, err := t.s.db.Exec(`DELETE FROM og_transcripts WHERE hash = $1`, hash)
+	return err
+}
+
+type postgresMemory struct{ s *postgresStore }
+
+func (m postgresMemory) Get(scope, key string) (string, bool, error) {
+	var v string
+	err := m.s.db.QueryRow(`SELECT value FROM og_memory WHERE username = $1 AND scope = $2 AND key = $3`, m.s.user, scope, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (m postgresMemory) Put(scope, key, value string) error {
+	_, err := m.s.db.Exec(`INSERT INTO og_memory (username, scope, key, value) VALUES ($1, $2, $3, $4)
+		ON CONFLICT (username, scope, key) DO UPDATE SET value = EXCLUDED.value`, m.s.user, scope, key, value)
+	return err
+}
+
+func (m postgresMemory) Delete(scope, key string) error {
+	_, err := m.s.db.Exec(`DELETE FROM og_memory WHERE username = $1 AND scope = $2 AND key = $3`, m.s.user, scope, key)
+	return err
+}
+
+func (m postgresMemory) List(scope string) (map[string]string, error) {
+	rows, err := m.s.db.Query(`SELECT key, value FROM og_memory WHERE username = $1 AND scope = $2`, m.s.user, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,357 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite" // Registers the pure Go "sqlite" database/sql driver, so og builds without cgo
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+func init() {
+	Register("sqlite", openSQLite)
+}
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS history (
+	id    INTEGER PRIMARY KEY AUTOINCREMENT,
+	ts    TEXT NOT NULL,
+	hash  TEXT NOT NULL,
+	cwd   TEXT NOT NULL,
+	query TEXT NOT NULL,
+	user  TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS history_hash ON history(hash);
+CREATE TABLE IF NOT EXISTS steps (
+	id       INTEGER PRIMARY KEY AUTOINCREMENT,
+	hash     TEXT NOT NULL,
+	ts       TEXT NOT NULL,
+	tool     TEXT NOT NULL,
+	command  TEXT NOT NULL,
+	approver TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS steps_hash ON steps(hash);
+CREATE TABLE IF NOT EXISTS results (
+	step_id     INTEGER PRIMARY KEY REFERENCES steps(id) ON DELETE CASCADE,
+	status      TEXT NOT NULL,
+	exit_code   INTEGER,
+	duration_ms INTEGER NOT NULL DEFAULT 0,
+	output      TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS results_status ON results(status);
+CREATE TABLE IF NOT EXISTS transcripts (
+	hash TEXT PRIMARY KEY,
+	data BLOB NOT NULL
+);
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS memory (
+	scope TEXT NOT NULL,
+	key   TEXT NOT NULL,
+	value TEXT NOT NULL,
+	PRIMARY KEY (scope, key)
+);
+`
+
+// maxStepOutput caps the output stored per step; longer output is truncated.
+const maxStepOutput = 64 << 10
+
+// sqliteStore keeps history, session steps and results, transcripts and memory
+// in a single SQLite file.
+// Artifacts remain plain directories since they are consumed by external tools.
+type sqliteStore struct {
+	db        *sql.DB
+	artifacts fsArtifacts
+}
+
+func openSQLite(cfg *config.OGConfig) (Store, error) {
+	path := cfg.Storage.Path
+	if path == "" {
+		dataDir, err := config.GetDataDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get data directory: %w", err)
+		}
+		path = filepath.Join(dataDir, "og.db")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	db, err := sql.Open("sqlite", path+"?_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_pragma=foreign_keys(1)")
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sqlite database %s: %w", path, err)
+	}
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize sqlite schema: %w", err)
+	}
+	if err := migrateSQLite(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate sqlite schema: %w", err)
+	}
+	if err := importJSONLHistory(db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to import history.json: %w", err)
+	}
+	return &sqliteStore{db: db, artifacts: fsArtifacts{root: cfg.General.TempDir()}}, nil
+}
+
+// migrateSQLite brings databases created by older versions up to the current schema.
+func migrateSQLite(db *sql.DB) error {
+	for _, col := range []struct{ name, def string }{
+		{"user", "TEXT NOT NULL DEFAULT ''"},
+		{"status", "TEXT NOT NULL DEFAULT ''"},
+		{"prompt_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		{"completion_tokens", "INTEGER NOT NULL DEFAULT 0"},
+		{"cost", "REAL NOT NULL DEFAULT 0"},
+		{"duration_ms", "INTEGER NOT NULL DEFAULT 0"},
+		{"tag", "TEXT NOT NULL DEFAULT ''"},
+		{"parent", "TEXT NOT NULL DEFAULT ''"},
+	} {
+		var n int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info('history') WHERE name = ?`, col.name).Scan(&n); err != nil {
+			return err
+		}
+		if n == 0 {
+			if _, err := db.Exec(`ALTER TABLE history ADD COLUMN ` + col.name + ` ` + col.def); err != nil {
+				return err
+			}
+		}
+	}
+	// Indexes on migrated columns can only be created once the columns exist
+	_, err := db.Exec(`
+CREATE INDEX IF NOT EXISTS history_ts ON history(ts);
+CREATE INDEX IF NOT EXISTS history_cwd ON history(cwd);
+CREATE INDEX IF NOT EXISTS history_user ON history(user);
+CREATE INDEX IF NOT EXISTS history_status ON history(status);
+`)
+	return err
+}
+
+// importJSONLHistory copies the records of the filesystem backend's history.json,
+// with their statuses from the session index, into a new database, so switching
+// backends keeps past sessions. It runs once per database, and not at all for
+// databases that already had history.
+func importJSONLHistory(db *sql.DB) error {
+	var done, n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM meta WHERE key = 'history_json_imported'`).Scan(&done); err != nil || done > 0 {
+		return err
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM history`).Scan(&n); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if n == 0 {
+		path, err := history.GetHistoryPath()
+		if err != nil {
+			return err
+		}
+		records, err := history.ReadRecords(path)
+		if err != nil {
+			return err
+		}
+		statuses, err := history.Statuses()
+		if err != nil {
+			return err
+		}
+		for _, rec := range records {
+			if _, err := tx.Exec(`INSERT INTO history (ts, hash, cwd, query, user, tag, parent, status) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`, rec.TS, rec.Hash, rec.CWD, rec.Query, rec.User, rec.Tag, rec.Parent, statuses[rec.Hash]); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := tx.Exec(`INSERT INTO meta (key, value) VALUES ('history_json_imported', ?)`, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) History() HistoryStore        { return sqliteHistory{s.db} }
+func (s *sqliteStore) Transcripts() TranscriptStore { return sqliteTranscripts{s.db} }
+func (s *sqliteStore) Artifacts() ArtifactStore     { return s.artifacts }
+func (s *sqliteStore) Memory() MemoryStore          { return sqliteMemory{s.db} }
+func (s *sqliteStore) Sessions() SessionStore       { return sqliteSessions{s.db} }
+func (s *sqliteStore) Close() error                 { return s.db.Close() }
+
+type sqliteHistory struct{ db *sql.DB }
+
+func (h sqliteHistory) Append(rec history.HistoryRecord) (int64, error) {
+	res, err := h.db.Exec(`INSERT INTO history (ts, hash, cwd, query, user, tag, parent) VALUES (?, ?, ?, ?, ?, ?, ?)`, rec.TS, rec.Hash, rec.CWD, rec.Query, rec.User, rec.Tag, rec.Parent)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert history record: %w", err)
+	}
+	return res.LastInsertId()
+}
+
+func (h sqliteHistory) List() ([]history.HistoryRecord, error) {
+	rows, err := h.db.Query(`SELECT ts, hash, cwd, query, user, tag, parent FROM history ORDER BY id`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query history: %w", err)
+	}
+	defer rows.Close()
+	var records []history.HistoryRecord
+	for rows.Next() {
+		var rec history.HistoryRecord
+		if err := rows.Scan(&rec.TS, &rec.Hash, &rec.CWD, &rec.Query, &rec.User, &rec.Tag, &rec.Parent); err != nil {
+			return nil, fmt.Errorf("failed to read history row: %w", err)
+		}
+		records = append(records, rec)
+	}
+	return records, rows.Err()
+}
+
+// Delete removes the sessions' history records together with their steps and results.
+func (h sqliteHistory) Delete(hashes []string) error {
+	tx, err := h.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, hash := range hashes {
+		if _, err := tx.Exec(`DELETE FROM history WHERE hash = ?`, hash); err != nil {
+			return fmt.Errorf("failed to delete history record: %w", err)
+		}
+		if _, err := tx.Exec(`DELETE FROM steps WHERE hash = ?`, hash); err != nil {
+			return fmt.Errorf("failed to delete session steps: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+type sqliteSessions struct{ db *sql.DB }
+
+func (ss sqliteSessions) AddStep(step Step) error {
+	if len(step.Output) > maxStepOutput {
+		step.Output = strings.ToValidUTF8(step.Output[:maxStepOutput], "") + "\n[output truncated]"
+	}
+	tx, err := ss.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	res, err := tx.Exec(`INSERT INTO steps (hash, ts, tool, command, approver) VALUES (?, ?, ?, ?, ?)`, step.Session, step.TS, step.Tool, step.Command, step.Approver)
+	if err != nil {
+		return fmt.Errorf("failed to insert step: %w", err)
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`INSERT INTO results (step_id, status, exit_code, duration_ms, output) VALUES (?, ?, ?, ?, ?)`, id, step.Status, step.ExitCode, step.DurationMs, step.Output); err != nil {
+		return fmt.Errorf("failed to insert step result: %w", err)
+	}
+	return tx.Commit()
+}
+
+func (ss sqliteSessions) Steps(hash string) ([]Step, error) {
+	rows, err := ss.db.Query(`SELECT s.hash, s.ts, s.tool, s.command, s.approver, r.status, r.exit_code, r.duration_ms, r.output
+		FROM steps s JOIN results r ON r.step_id = s.id WHERE s.hash = ? ORDER BY s.id`, hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query steps: %w", err)
+	}
+	defer rows.Close()
+	var steps []Step
+	for rows.Next() {
+		var step Step
+		var exitCode sql.NullInt64
+		if err := rows.Scan(&step.Session, &step.TS, &step.Tool, &step.Command, &step.Approver, &step.Status, &exitCode, &step.DurationMs, &step.Output); err != nil {
+			return nil, fmt.Errorf("failed to read step row: %w", err)
+		}
+		if exitCode.Valid {
+			code := int(exitCode.Int64)
+			step.ExitCode = &code
+		}
+		steps = append(steps, step)
+	}
+	return steps, rows.Err()
+}
+
+func (ss sqliteSessions) SetStatus(hash, status string) error {
+	_, err := ss.db.Exec(`UPDATE history SET status = ? WHERE hash = ?`, status, hash)
+	return err
+}
+
+func (ss sqliteSessions) SetUsage(hash string, usage history.Usage) error {
+	_, err := ss.db.Exec(`UPDATE history SET prompt_tokens = ?, completion_tokens = ?, cost = ? WHERE hash = ?`,
+		usage.PromptTokens, usage.CompletionTokens, usage.Cost, hash)
+	return err
+}
+
+func (ss sqliteSessions) SetDuration(hash string, d time.Duration) error {
+	_, err := ss.db.Exec(`UPDATE history SET duration_ms = ? WHERE hash = ?`, d.Milliseconds(), hash)
+	return err
+}
+
+type sqliteTranscripts struct{ db *sql.DB }
+
+func (t sqliteTranscripts) Get(hash string) ([]byte, error) {
+	var data []byte
+	err := t.db.QueryRow(`SELECT data FROM transcripts WHERE hash = ?`, hash).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, fmt.Errorf("no transcript stored for session %s: %w", hash, os.ErrNotExist)
+	}
+	return data, err
+}
+
+func (t sqliteTranscripts) Put(hash string, data []byte) error {
+	_, err := t.db.Exec(`INSERT INTO transcripts (hash, data) VALUES (?, ?) ON CONFLICT(hash) DO UPDATE SET data = excluded.data`, hash, data)
+	return err
+}
+
+func (t sqliteTranscripts) Delete(hash string) error {
+	_, err := t.db.Exec(`DELETE FROM transcripts WHERE hash = ?`, hash)
+	return err
+}
+
+type sqliteMemory struct{ db *sql.DB }
+
+func (m sqliteMemory) Get(scope, key string) (string, bool, error) {
+	var v string
+	err := m.db.QueryRow(`SELECT value FROM memory WHERE scope = ? AND key = ?`, scope, key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return v, true, nil
+}
+
+func (m sqliteMemory) Put(scope, key, value string) error {
+	_, err := m.db.Exec(`INSERT INTO memory (scope, key, value) VALUES (?, ?, ?) ON CONFLICT(scope, key) DO UPDATE SET value = excluded.value`, scope, key, value)
+	return err
+}
+
+func (m sqliteMemory) Delete(scope, key string) error {
+	_, err := m.db.Exec(`DELETE FROM memory WHERE scope = ? AND key = ?`, scope, key)
+	return err
+}
+
+func (m sqliteMemory) List(scope string) (map[string]string, error) {
+	rows, err := m.db.Query(`SELECT key, value FROM memory WHERE scope = ?`, scope)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var k, v string
+		if err := rows.Scan(&k, &v); err != nil {
+			return nil, err
+		}
+		out[k] = v
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,123 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+// HistoryStore persists query-level history records.
+type HistoryStore interface {
+	// Append stores a record and returns its position (a byte offset or row id,
+	// depending on the backend), which is recorded in the session index.
+	Append(rec history.HistoryRecord) (int64, error)
+	// List returns all records in insertion order.
+	List() ([]history.HistoryRecord, error)
+	// Delete removes the records of the given sessions.
+	Delete(hashes []string) error
+}
+
+// TranscriptStore persists the session JSON written by the agent.
+type TranscriptStore interface {
+	Get(hash string) ([]byte, error)
+	Put(hash string, data []byte) error
+	Delete(hash string) error
+}
+
+// ArtifactStore manages per-session artifact directories (spilled output, backups, ...).
+type ArtifactStore interface {
+	// Dir returns the local directory for a session's artifacts without creating it.
+	Dir(hash string) string
+	Delete(hash string) error
+}
+
+// MemoryStore is a small namespaced key/value store for things OG remembers
+// across sessions (e.g. per-directory preferences).
+type MemoryStore interface {
+	Get(scope, key string) (string, bool, error)
+	Put(scope, key, value string) error
+	Delete(scope, key string) error
+	List(scope string) (map[string]string, error)
+}
+
+// Step is one executed action of a session together with its result.
+type Step struct {
+	Session    string
+	TS         string // When the action finished, RFC3339
+	Tool       string
+	Command    string
+	Approver   string // Who allowed the action to run, if anyone was asked
+	Status     string // success or failure
+	ExitCode   *int   // Set for shell steps
+	DurationMs int64
+	Output     string
+}
+
+// SessionStore keeps per-session metadata beyond the history record: the
+// steps a session executed, their results and how it ended. It is optional;
+// use Sessions to get it from a Store.
+type SessionStore interface {
+	AddStep(step Step) error
+	Steps(hash string) ([]Step, error)
+	SetStatus(hash, status string) error
+	SetUsage(hash string, usage history.Usage) error
+	SetDuration(hash string, d time.Duration) error
+}
+
+// Sessions returns st's SessionStore, or nil if the backend does not keep session metadata.
+func Sessions(st Store) SessionStore {
+	if ss, ok := st.(interface{ Sessions() SessionStore }); ok {
+		return ss.Sessions()
+	}
+	return nil
+}
+
+// Store bundles the storage facets used by a session.
+type Store interface {
+	History() HistoryStore
+	Transcripts() TranscriptStore
+	Artifacts() ArtifactStore
+	Memory() MemoryStore
+	Close() error
+}
+
+// Factory opens a Store backend from the config.
+type Factory func(cfg *config.OGConfig) (Store, error)
+
+var backends = map[string]Factory{}
+
+// Register makes a backend available under a name usable in `storage.backend`.
+// Backends register themselves from an init function.
+func Register(name string, factory Factory) {
+	backends[name] = factory
+}
+
+// DefaultBackend is used when `storage.backend` is not set.
+const DefaultBackend = "sqlite"
+
+// BackendName returns the backend selected in the config.
+func BackendName(cfg *config.OGConfig) string {
+	if cfg.Storage.Backend == "" {
+		return DefaultBackend
+	}
+	return cfg.Storage.Backend
+}
+
+// Open opens the backend selected in the config.
+func Open(cfg *config.OGConfig) (Store, error) {
+	name := BackendName(cfg)
+	factory, ok := backends[name]
+	if !ok {
+		names := make([]string, 0, len(backends))
+		for n := range backends {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return nil, fmt.Errorf("unknown storage backend '%s' (available: %s)", name, strings.Join(names, ", "))
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,48 @@
+// Package telemetry exports OpenTelemetry traces of sessions to an OTLP/HTTP
+// collector ([telemetry]), so that where a session spends its time (starting
+// the agent, planning, auditing, executing steps) can be seen.
+package telemetry
+
+import (
+	"context"
+	"fmt"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+)
+
+// instrumentation names og's spans in the traces.
+const instrumentation = "github.com/robbiemu/original_gangster/og"
+
+// Tracer returns the tracer og's spans are started with. Until Start installed
+// an exporter, its spans are not recorded.
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentation)
+}
+
+// Start has the spans of Tracer exported to cfg's endpoint, in batches, and
+// returns a function that exports what is left and stops. Errors of the
+// exports are passed to onError rather than printed. Without an endpoint it
+// does nothing.
+func Start(ctx context.Context, cfg config.TelemetryCfg, onError func(error)) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(cfg.Endpoint), otlptracehttp.WithHeaders(cfg.Headers))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the OTLP exporter for %s: %w", cfg.Endpoint, err)
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(onError))
+	return provider.Shutdown, nil
+}
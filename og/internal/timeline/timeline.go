@@ -0,0 +1,145 @@
+// Package timeline exports when sessions ran and how long they took, as an
+// iCalendar feed or JSON, e.g. to bill AI-assisted work.
+package timeline
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+// Formats lists the output formats Render accepts.
+var Formats = []string{"ical", "json"}
+
+// Event is one session on the timeline.
+type Event struct {
+	Hash       string    `json:"hash"`
+	Start      time.Time `json:"start"`
+	End        time.Time `json:"end,omitzero"`          // Unset when the duration was not recorded
+	DurationMs int64     `json:"duration_ms,omitempty"` // 0 when not recorded
+	Query      string    `json:"query"`
+	CWD        string    `json:"cwd"`
+	User       string    `json:"user,omitempty"`
+	Status     string    `json:"status,omitempty"`
+}
+
+// Timeline is the sessions of a period, oldest first.
+type Timeline struct {
+	Sessions        []Event `json:"sessions"`
+	TotalDurationMs int64   `json:"total_duration_ms"` // Of the sessions with a recorded duration
+	Unrecorded      int     `json:"sessions_without_duration"`
+}
+
+// Build places records on a timeline using the durations and outcomes recorded
+// in the session index. Records with unparseable timestamps are left out.
+func Build(records []history.HistoryRecord, index map[string]history.IndexEntry) Timeline {
+	t := Timeline{Sessions: []Event{}}
+	for _, rec := range records {
+		start := rec.Time()
+		if start.IsZero() {
+			continue
+		}
+		e := index[rec.Hash]
+		ev := Event{Hash: rec.Hash, Start: start.UTC(), Query: rec.Query, CWD: rec.CWD, User: rec.User, Status: e.Status}
+		if e.DurationMs > 0 {
+			ev.DurationMs = e.DurationMs
+			ev.End = ev.Start.Add(time.Duration(e.DurationMs) * time.Millisecond)
+			t.TotalDurationMs += e.DurationMs
+		} else {
+			t.Unrecorded++
+		}
+		t.Sessions = append(t.Sessions, ev)
+	}
+	return t
+}
+
+// Render writes the timeline in one of Formats. now stamps iCalendar events.
+func (t Timeline) Render(format string, now time.Time) ([]byte, error) {
+	switch format {
+	case "json":
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(t); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case "ical":
+		return t.iCal(now), nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// iCal renders the timeline as an RFC 5545 calendar with one event per session.
+// Sessions without a recorded duration have no end and show as instants.
+func (t Timeline) iCal(now time.Time) []byte {
+	var b bytes.Buffer
+	line := func(name, value string) {
+		fold(&b, name+":"+value)
+	}
+	line("BEGIN", "VCALENDAR")
+	line("VERSION", "2.0")
+	line("PRODID", "-//original_gangster//og timeline//EN")
+	line("CALSCALE", "GREGORIAN")
+	line("X-WR-CALNAME", "OG sessions")
+	stamp := now.UTC().Format(icalTime)
+	for _, ev := range t.Sessions {
+		line("BEGIN", "VEVENT")
+		line("UID", ev.Hash+"@og")
+		line("DTSTAMP", stamp)
+		line("DTSTART", ev.Start.Format(icalTime))
+		if !ev.End.IsZero() {
+			line("DTEND", ev.End.Format(icalTime))
+		}
+		line("SUMMARY", escape("OG: "+strings.Join(strings.Fields(ev.Query), " ")))
+		line("LOCATION", escape(ev.CWD))
+		desc := []string{"Session " + ev.Hash}
+		if ev.Status != "" {
+			desc = append(desc, "Outcome: "+ev.Status)
+		}
+		if ev.DurationMs > 0 {
+			desc = append(desc, "Duration: "+(time.Duration(ev.DurationMs)*time.Millisecond).Round(time.Second).String())
+		} else {
+			desc = append(desc, "Duration: not recorded")
+		}
+		if ev.User != "" {
+			desc = append(desc, "User: "+ev.User)
+		}
+		desc = append(desc, "", ev.Query)
+		line("DESCRIPTION", escape(strings.Join(desc, "\n")))
+		line("END", "VEVENT")
+	}
+	line("END", "VCALENDAR")
+	return b.Bytes()
+}
+
+const icalTime = "20060102T150405Z"
+
+// escape escapes a TEXT value.
+func escape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`).Replace(s)
+}
+
+// fold writes a content line, folding it at 75 octets without splitting UTF-8
+// sequences, and terminates it with CRLF.
+func fold(b *bytes.Buffer, s string) {
+	limit := 75
+	for len(s) > limit {
+		cut := limit
+		for cut > 0 && s[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(s[:cut])
+		b.WriteString("\r\n ")
+		s = s[cut:]
+		limit = 74 // Continuation lines start with a space
+	}
+	b.WriteString(s)
+	b.WriteString("\r\n")
+}
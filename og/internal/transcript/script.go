@@ -0,0 +1,155 @@
+package transcript
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Input is a value a plan step asked the user for when it ran, written {Name}
+// in its action.
+type Input struct {
+	Name    string `json:"name"`
+	Prompt  string `json:"prompt,omitempty"`
+	Pattern string `json:"pattern,omitempty"`
+}
+
+// Script renders the shell commands the session ran as a standalone, commented
+// shell script, so the workflow can be rerun without og. Values the user typed
+// in when asked for a step's inputs become variables the script requires; steps
+// that failed, and those of other tools, are left in as comments only.
+func (d *Document) Script() string {
+	var body strings.Builder
+	var inputs []Input
+	seen := map[string]bool{}
+	shell := 0
+	for i, s := range d.Steps {
+		line, step, used := d.scriptLine(s.Command)
+		title := fmt.Sprintf("# %d. %s", i+1, s.Tool)
+		if step != nil && step.Description != "" {
+			title = fmt.Sprintf("# %d. %s", i+1, oneLine(step.Description))
+		}
+		if meta := s.meta(); meta != "" {
+			title += " (" + meta + ")"
+		}
+		body.WriteString("\n" + title + "\n")
+		switch {
+		case s.Tool != "shell_tool":
+			fmt.Fprintf(&body, "# Ran with %s, which has no shell equivalent:\n", s.Tool)
+			body.WriteString(comment(s.Command))
+		case s.Status != "" && s.Status != "success":
+			body.WriteString("# Failed when og ran it, so it is left out:\n")
+			body.WriteString(comment(s.Command))
+		default:
+			shell++
+			body.WriteString(strings.TrimRight(line, "\n") + "\n")
+			for _, in := range used {
+				if !seen[in.Name] {
+					seen[in.Name] = true
+					inputs = append(inputs, in)
+				}
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	fmt.Fprintf(&b, "# Exported with `og export --script` from OG session %s (%s).\n", d.Session, d.TS)
+	b.WriteString("# Request:\n" + comment(d.Query))
+	fmt.Fprintf(&b, "# It ran in %s.\n", d.CWD)
+	b.WriteString("# The shell commands below were approved and ran then; review them before\n# running this script, which does not involve og or any model.\n")
+	if len(inputs) > 0 {
+		b.WriteString("\n# Values you were asked for during the session; set them when running it,\n")
+		fmt.Fprintf(&b, "# e.g. %s=... sh script.sh\n", shellVar(inputs[0].Name))
+		for _, in := range inputs {
+			if in.Pattern != "" {
+				fmt.Fprintf(&b, "# %s must match %s\n", shellVar(in.Name), in.Pattern)
+			}
+			fmt.Fprintf(&b, ": \"${%s:?%s}\"\n", shellVar(in.Name), promptText(in))
+		}
+	}
+	if shell == 0 {
+		b.WriteString("\n# The session ran no shell commands that succeeded.\n")
+	}
+	b.WriteString("\nset -e\n")
+	b.WriteString(body.String())
+	return b.String()
+}
+
+// scriptLine returns the line of the script for a command the session ran: the
+// line of the plan it came from, with the variables of the inputs it used, or
+// the command itself. It also returns that plan step, if any.
+func (d *Document) scriptLine(command string) (string, *PlanStep, []Input) {
+	command = strings.TrimSpace(command)
+	steps := d.Plan
+	if d.Fallback != nil {
+		steps = append(steps[:len(steps):len(steps)], *d.Fallback)
+	}
+	for i := range steps {
+		p := &steps[i]
+		if strings.TrimSpace(p.Action) == command {
+			return command, p, nil
+		}
+		for _, line := range strings.Split(p.Action, "\n") {
+			line = strings.TrimSpace(line)
+			if line == command {
+				return command, p, nil
+			}
+			var used []Input
+			for _, in := range p.Inputs {
+				if strings.Contains(line, "{"+in.Name+"}") {
+					used = append(used, in)
+				}
+			}
+			if len(used) == 0 || !templateRegexp(line, used).MatchString(command) {
+				continue
+			}
+			for _, in := range used {
+				line = strings.ReplaceAll(line, "{"+in.Name+"}", "${"+shellVar(in.Name)+"}")
+			}
+			return line, p, used
+		}
+	}
+	return command, nil, nil
+}
+
+// templateRegexp matches the commands that line yields with any values of its
+// inputs.
+func templateRegexp(line string, inputs []Input) *regexp.Regexp {
+	pattern := regexp.QuoteMeta(line)
+	for _, in := range inputs {
+		pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta("{"+in.Name+"}"), `.+?`)
+	}
+	return regexp.MustCompile(`^` + pattern + `$`)
+}
+
+var notShellIdent = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+// shellVar returns the shell variable for an input's name.
+func shellVar(name string) string {
+	v := notShellIdent.ReplaceAllString(name, "_")
+	if v == "" || v[0] >= '0' && v[0] <= '9' {
+		v = "_" + v
+	}
+	return v
+}
+
+// promptText describes an input in the error the script stops with when it is
+// not set, without characters the shell would interpret there.
+func promptText(in Input) string {
+	text := in.Prompt
+	if text == "" {
+		text = "set " + shellVar(in.Name)
+	}
+	text = strings.NewReplacer(`"`, "'", "$", "", "`", "'", "}", ")", "\n", " ").Replace(text)
+	return strings.TrimRight(text, `\`)
+}
+
+// comment turns s into shell comment lines.
+func comment(s string) string {
+	return indent(s, "#   ")
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
@@ -0,0 +1,400 @@
+// Package transcript combines what OG keeps about a session (its history record,
+// the session JSON written by the agent, recorded steps and audit entries) into
+// a document that can be shared as Markdown, JSON or HTML, or rerun as a shell
+// script.
+package transcript
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+)
+
+// Formats are the formats a Document can be rendered in.
+var Formats = []string{"md", "json", "html", "sh"}
+
+// Document is a shareable transcript of one session.
+type Document struct {
+	Session   string     `json:"session"`
+	TS        string     `json:"ts"`
+	CWD       string     `json:"cwd"`
+	User      string     `json:"user,omitempty"`
+	Query     string     `json:"query"`
+	Status    string     `json:"status,omitempty"` // How the session ended, from the session index
+	Plan      []PlanStep `json:"plan,omitempty"`
+	Fallback  *PlanStep  `json:"fallback,omitempty"`
+	Approvals []Approval `json:"approvals,omitempty"`
+	Steps     []Step     `json:"steps,omitempty"`
+	Summary   *Summary   `json:"summary,omitempty"`
+}
+
+// PlanStep is a step of the plan the agent proposed.
+type PlanStep struct {
+	Description string  `json:"description,omitempty"`
+	Tool        string  `json:"tool"`
+	Action      string  `json:"action"`
+	Inputs      []Input `json:"inputs,omitempty"` // Values the user was asked for when it ran
+}
+
+// Approval is a decision on whether an action may run, from the audit log.
+type Approval struct {
+	TS       string `json:"ts"`
+	Tool     string `json:"tool,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Decision string `json:"decision"`
+	Approver string `json:"approver,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Step is an action the session executed and its output.
+type Step struct {
+	TS         string `json:"ts,omitempty"`
+	Tool       string `json:"tool"`
+	Command    string `json:"command"`
+	Approver   string `json:"approver,omitempty"`
+	Status     string `json:"status,omitempty"`
+	ExitCode   *int   `json:"exit_code,omitempty"`
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Output     string `json:"output,omitempty"`
+}
+
+// Summary is the agent's final answer.
+type Summary struct {
+	Status string `json:"status,omitempty"` // "success" or "cancelled"
+	Text   string `json:"text"`
+}
+
+// sessionJSON is the part of the session JSON written by the agent that goes into a transcript.
+type sessionJSON struct {
+	CurrentRecipe   []PlanStep `json:"current_recipe"`
+	FallbackAction  *PlanStep  `json:"fallback_action"`
+	ExecutedActions []struct {
+		Tool      string `json:"tool"`
+		Action    string `json:"action"`
+		Result    string `json:"result"`
+		Timestamp string `json:"timestamp"` // Unix seconds
+	} `json:"executed_actions"`
+	FinalSummary *struct {
+		Summary string `json:"summary"`
+		Status  string `json:"status"`
+	} `json:"final_summary"`
+}
+
+// Build assembles the transcript of a session. session is the stored session
+// JSON and may be nil when it was not kept; steps are the steps recorded by
+// the storage backend, which are preferred over the executed actions in the
+// session JSON because they carry exit codes and approvers; entries are audit
+// log entries, of which only the session's approvals are used.
+func Build(rec history.HistoryRecord, status string, session []byte, steps []store.Step, entries []audit.Entry) (*Document, error) {
+	doc := &Document{Session: rec.Hash, TS: rec.TS, CWD: rec.CWD, User: rec.User, Query: rec.Query, Status: status}
+
+	var sj sessionJSON
+	if len(session) > 0 {
+		if err := json.Unmarshal(session, &sj); err != nil {
+			return nil, fmt.Errorf("failed to parse session JSON: %w", err)
+		}
+	}
+	doc.Plan = sj.CurrentRecipe
+	doc.Fallback = sj.FallbackAction
+	if sj.FinalSummary != nil && sj.FinalSummary.Summary != "" {
+		doc.Summary = &Summary{Status: sj.FinalSummary.Status, Text: sj.FinalSummary.Summary}
+	}
+
+	for _, e := range entries {
+		if e.Session != rec.Hash || e.Event != audit.EventApproval {
+			continue
+		}
+		doc.Approvals = append(doc.Approvals, Approval{
+			TS: e.TS, Tool: e.Tool, Command: e.Command, Decision: e.Decision,
+			Approver: approver(e), Reason: e.Reason,
+		})
+	}
+
+	if len(steps) > 0 {
+		for _, s := range steps {
+			doc.Steps = append(doc.Steps, Step{
+				TS: s.TS, Tool: s.Tool, Command: s.Command, Approver: s.Approver,
+				Status: s.Status, ExitCode: s.ExitCode, DurationMs: s.DurationMs, Output: s.Output,
+			})
+		}
+	} else {
+		for _, a := range sj.ExecutedActions {
+			doc.Steps = append(doc.Steps, Step{TS: unixTS(a.Timestamp), Tool: a.Tool, Command: a.Action, Output: a.Result})
+		}
+	}
+	return doc, nil
+}
+
+// Redact replaces every free-text field of the document with fn's result.
+func (d *Document) Redact(fn func(string) string) {
+	d.CWD, d.Query = fn(d.CWD), fn(d.Query)
+	for i := range d.Plan {
+		d.Plan[i].redact(fn)
+	}
+	if d.Fallback != nil {
+		d.Fallback.redact(fn)
+	}
+	for i := range d.Approvals {
+		a := &d.Approvals[i]
+		a.Command, a.Reason = fn(a.Command), fn(a.Reason)
+	}
+	for i := range d.Steps {
+		s := &d.Steps[i]
+		s.Command, s.Output = fn(s.Command), fn(s.Output)
+	}
+	if d.Summary != nil {
+		d.Summary.Text = fn(d.Summary.Text)
+	}
+}
+
+func (p *PlanStep) redact(fn func(string) string) {
+	p.Description, p.Action = fn(p.Description), fn(p.Action)
+}
+
+// Render renders the document in one of Formats.
+func (d *Document) Render(format string) ([]byte, error) {
+	switch format {
+	case "md":
+		return []byte(d.Markdown()), nil
+	case "json":
+		var b bytes.Buffer
+		enc := json.NewEncoder(&b)
+		enc.SetEscapeHTML(false)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(d); err != nil {
+			return nil, err
+		}
+		return b.Bytes(), nil
+	case "sh":
+		return []byte(d.Script()), nil
+	case "html":
+		var b strings.Builder
+		if err := htmlTemplate.Execute(&b, d); err != nil {
+			return nil, fmt.Errorf("failed to render HTML: %w", err)
+		}
+		return []byte(b.String()), nil
+	default:
+		return nil, fmt.Errorf("unknown format '%s' (use %s)", format, strings.Join(Formats, ", "))
+	}
+}
+
+// Markdown renders the document as Markdown.
+func (d *Document) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# OG session %s\n\n", d.Session)
+	fmt.Fprintf(&b, "- **Started:** %s\n", d.TS)
+	fmt.Fprintf(&b, "- **Directory:** `%s`\n", d.CWD)
+	if d.User != "" {
+		fmt.Fprintf(&b, "- **User:** %s\n", d.User)
+	}
+	if d.Status != "" {
+		fmt.Fprintf(&b, "- **Status:** %s\n", d.Status)
+	}
+	b.WriteString("\n## Request\n\n")
+	b.WriteString(quote(d.Query) + "\n")
+
+	if len(d.Plan) > 0 || d.Fallback != nil {
+		b.WriteString("\n## Plan\n\n")
+		for i, p := range d.Plan {
+			if i > 0 {
+				b.WriteString("\n")
+			}
+			fmt.Fprintf(&b, "%d. %s\n\n", i+1, p.title())
+			b.WriteString(indent(codeBlock(p.Action, "sh"), "   "))
+		}
+		if d.Fallback != nil {
+			fmt.Fprintf(&b, "\nFallback: %s\n\n", d.Fallback.title())
+			b.WriteString(codeBlock(d.Fallback.Action, "sh"))
+		}
+	}
+
+	if len(d.Approvals) > 0 {
+		b.WriteString("\n## Approvals\n\n")
+		b.WriteString("| Time | Decision | By | Action |\n|---|---|---|---|\n")
+		for _, a := range d.Approvals {
+			action := a.Command
+			if a.Tool != "" {
+				action = a.Tool + ": " + action
+			}
+			if a.Reason != "" {
+				action += " (" + a.Reason + ")"
+			}
+			fmt.Fprintf(&b, "| %s | %s | %s | %s |\n", a.TS, a.Decision, tableCell(a.Approver), tableCell(action))
+		}
+	}
+
+	if len(d.Steps) > 0 {
+		b.WriteString("\n## Steps\n")
+		for i, s := range d.Steps {
+			fmt.Fprintf(&b, "\n### %d. %s\n\n", i+1, s.Tool)
+			if meta := s.meta(); meta != "" {
+				b.WriteString(meta + "\n\n")
+			}
+			b.WriteString(codeBlock(s.Command, "sh"))
+			if s.Output != "" {
+				b.WriteString("\nOutput:\n\n" + codeBlock(s.Output, "text"))
+			}
+		}
+	}
+
+	if d.Summary != nil {
+		b.WriteString("\n## Summary\n\n")
+		b.WriteString(strings.TrimRight(d.Summary.Text, "\n") + "\n")
+	}
+	return b.String()
+}
+
+func (p PlanStep) title() string {
+	if p.Description != "" {
+		return p.Description + " (" + p.Tool + ")"
+	}
+	return p.Tool
+}
+
+// meta describes how a step ran, e.g. "success, exit 0, 120ms, approved by alice".
+func (s Step) meta() string {
+	var parts []string
+	if s.Status != "" {
+		parts = append(parts, s.Status)
+	}
+	if s.ExitCode != nil {
+		parts = append(parts, fmt.Sprintf("exit %d", *s.ExitCode))
+	}
+	if s.DurationMs > 0 {
+		parts = append(parts, fmt.Sprintf("%dms", s.DurationMs))
+	}
+	if s.Approver != "" {
+		parts = append(parts, "approved by "+s.Approver)
+	}
+	if s.TS != "" {
+		parts = append(parts, s.TS)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// approver describes who (or what) made an audited decision.
+func approver(e audit.Entry) string {
+	switch {
+	case e.Identity != "" && e.Role != "" && e.Identity != e.Role:
+		return e.Identity + " (" + e.Role + ")"
+	case e.Identity != "":
+		return e.Identity
+	default:
+		return e.Role
+	}
+}
+
+// unixTS converts the agent's Unix-seconds timestamps to RFC3339.
+func unixTS(s string) string {
+	var secs float64
+	if _, err := fmt.Sscan(s, &secs); err != nil || secs <= 0 {
+		return s
+	}
+	return time.Unix(int64(secs), 0).Format(time.RFC3339)
+}
+
+// codeBlock fences s with more backticks than it contains in a row.
+func codeBlock(s, lang string) string {
+	fence := "```"
+	for strings.Contains(s, fence) {
+		fence += "`"
+	}
+	return fence + lang + "\n" + strings.TrimRight(s, "\n") + "\n" + fence + "\n"
+}
+
+func quote(s string) string {
+	return "> " + strings.ReplaceAll(strings.TrimRight(s, "\n"), "\n", "\n> ")
+}
+
+func indent(s, prefix string) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	for i, l := range lines {
+		lines[i] = prefix + l
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func tableCell(s string) string {
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", "<br>")
+}
+
+var htmlTemplate = template.Must(template.New("transcript").Funcs(template.FuncMap{
+	"inc":  func(i int) int { return i + 1 },
+	"meta": Step.meta,
+}).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>OG session {{.Session}}</title>
+<style>
+body { font-family: sans-serif; max-width: 60rem; margin: 2rem auto; padding: 0 1rem; line-height: 1.4; }
+pre { background: #f4f4f4; padding: .75rem; overflow-x: auto; white-space: pre-wrap; }
+table { border-collapse: collapse; }
+td, th { border: 1px solid #ccc; padding: .25rem .5rem; text-align: left; vertical-align: top; }
+.meta { color: #555; }
+.denied, .failure { color: #b00; }
+</style>
+</head>
+<body>
+<h1>OG session <code>{{.Session}}</code></h1>
+<ul class="meta">
+<li>Started: {{.TS}}</li>
+<li>Directory: <code>{{.CWD}}</code></li>
+{{- if .User}}
+<li>User: {{.User}}</li>
+{{- end}}
+{{- if .Status}}
+<li>Status: {{.Status}}</li>
+{{- end}}
+</ul>
+<h2>Request</h2>
+<blockquote><pre>{{.Query}}</pre></blockquote>
+{{- if or .Plan .Fallback}}
+<h2>Plan</h2>
+<ol>
+{{- range .Plan}}
+<li>{{.Description}} <span class="meta">({{.Tool}})</span><pre>{{.Action}}</pre></li>
+{{- end}}
+</ol>
+{{- with .Fallback}}
+<p>Fallback: {{.Description}} <span class="meta">({{.Tool}})</span></p>
+<pre>{{.Action}}</pre>
+{{- end}}
+{{- end}}
+{{- if .Approvals}}
+<h2>Approvals</h2>
+<table>
+<tr><th>Time</th><th>Decision</th><th>By</th><th>Action</th><th>Reason</th></tr>
+{{- range .Approvals}}
+<tr><td>{{.TS}}</td><td class="{{.Decision}}">{{.Decision}}</td><td>{{.Approver}}</td><td>{{if .Tool}}{{.Tool}}: {{end}}<code>{{.Command}}</code></td><td>{{.Reason}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- if .Steps}}
+<h2>Steps</h2>
+{{- range $i, $s := .Steps}}
+<h3>{{inc $i}}. {{$s.Tool}}</h3>
+{{- with meta $s}}
+<p class="meta {{$s.Status}}">{{.}}</p>
+{{- end}}
+<pre>{{$s.Command}}</pre>
+{{- if $s.Output}}
+<details open><summary>Output</summary><pre>{{$s.Output}}</pre></details>
+{{- end}}
+{{- end}}
+{{- end}}
+{{- with .Summary}}
+<h2>Summary</h2>
+<pre>{{.Text}}</pre>
+{{- end}}
+</body>
+</html>
+`))
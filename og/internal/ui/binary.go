@@ -0,0 +1,40 @@
+package ui
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// hexdumpBytes is how much of a binary output is shown as a hexdump.
+const hexdumpBytes = 128
+
+// BinaryOutput is the output of a step that is not text, which the agent
+// sends base64-encoded instead of printing it, carried by "result".
+type BinaryOutput struct {
+	Data []byte `json:"data,omitempty"` // The output, or its head when File has it
+	File string `json:"file,omitempty"` // Where the whole output was saved, when it was too long to send
+	Size int64  `json:"size"`           // Length of the whole output
+}
+
+// Mime returns the media type of the output, guessed from its first bytes.
+func (b *BinaryOutput) Mime() string {
+	return http.DetectContentType(b.Data)
+}
+
+// formatBinary describes a binary output: its size and media type, a hexdump
+// of its first bytes and where it was saved.
+func (c *ConsoleUI) formatBinary(b *BinaryOutput) string {
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s %d bytes, %s\n", c.summary("📦 Binary output:"), b.Size, b.Mime())
+	dump := b.Data[:min(len(b.Data), hexdumpBytes)]
+	out.WriteString(formatOutput(strings.TrimSuffix(hex.Dump(dump), "\n")))
+	if int64(len(dump)) < b.Size {
+		out.WriteString("\n" + yellow(fmt.Sprintf("    [... %d more bytes not shown]", b.Size-int64(len(dump)))))
+	}
+	if b.File != "" {
+		fmt.Fprintf(&out, "\n%s %s", blue("Saved in:"), b.File)
+	}
+	return out.String()
+}
@@ -0,0 +1,114 @@
+package ui
+
+import (
+	"fmt"
+	"strings"
+)
+
+// asciiIcons are the ASCII tags the icons og prints are replaced with in
+// ASCII mode (ui.ascii), for terminals and logs that render emoji as
+// mojibake. An empty tag drops an icon that needs no tag, as in
+// "🔌 [MODEL UNREACHABLE]" or the banner's "🕶️  OG".
+var asciiIcons = map[rune]string{
+	'✅': "[OK]",
+	'❌': "[FAIL]",
+	'⚠': "[WARN]",
+	'🚫': "[DENIED]",
+	'⛔': "[LIMIT]",
+	'🔁': "[RETRY]",
+	'⏭': "[SKIP]",
+	'🔀': "[RUN]",
+	'⏳': "[WAIT]",
+	'⏱': "[TIMEOUT]",
+	'🛑': "[STOP]",
+	'✨': "[OK]",
+	'☁': "[CLOUD]",
+	'📎': "[ATTACHED]",
+	'🐚': "[ATTACHED]",
+	'🙅': "[REFUSAL]",
+	'🧪': "[SANDBOX]",
+	'↩': "[UNDO]",
+	'🛟': "[CHECKPOINT]",
+	'🔒': "[LOCKED]",
+	'🔓': "[TRUSTED]",
+	'⛓': "[PIPELINE]",
+	'🏁': "[DONE]",
+	'✏': "[EDIT]",
+	'✍': "[INPUT]",
+	'✂': "[STEPS]",
+	'🏗': "[IAC]",
+	'📊': "[STATS]",
+	'🔥': "[DAEMON]",
+	'📁': "[DIR]",
+	'🔌': "",
+	'🛠': "",
+	'🧠': "[PLAN]",
+	'🤖': "[APPROVAL]",
+	'📝': "[PATCH]",
+	'🗄': "[SQL]",
+	'🔍': "[SECOND OPINION]",
+	'👥': "[SECOND APPROVER]",
+	'🕶': "",
+	'🧯': "[LIMITS]",
+	'📦': "[CONTAINER]",
+	'📖': "[READ-ONLY]",
+	'🚀': "[END]",
+	'💥': "[CRASH]",
+	'💡': "[HINT]",
+	'💰': "[COST]",
+}
+
+// SetASCII switches the icons of the console output to ASCII tags.
+func (c *ConsoleUI) SetASCII(on bool) {
+	c.ascii = on
+}
+
+// Text returns s as the console prints it: in ASCII mode, with each icon
+// replaced by its tag and the spaces after it by one.
+func (c *ConsoleUI) Text(s string) string {
+	if !c.ascii {
+		return s
+	}
+	return asciiText(s)
+}
+
+func asciiText(s string) string {
+	var b strings.Builder
+	runes := []rune(s)
+	for i := 0; i < len(runes); i++ {
+		tag, ok := asciiIcons[runes[i]]
+		if !ok {
+			b.WriteRune(runes[i])
+			continue
+		}
+		b.WriteString(tag)
+		if i+1 < len(runes) && runes[i+1] == '\uFE0F' { // Emoji presentation selector
+			i++
+		}
+		spaced := false
+		for i+1 < len(runes) && runes[i+1] == ' ' {
+			spaced = true
+			i++
+		}
+		if spaced && tag != "" {
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// printf, println and print print to stdout as Text.
+func (c *ConsoleUI) printf(format string, a ...interface{}) {
+	c.clearProgress()
+	fmt.Print(c.Text(fmt.Sprintf(format, a...)))
+}
+
+func (c *ConsoleUI) println(a ...interface{}) {
+	c.clearProgress()
+	fmt.Print(c.Text(fmt.Sprintln(a...)))
+}
+
+func (c *ConsoleUI) print(a ...interface{}) {
+	c.clearProgress()
+	fmt.Print(c.Text(fmt.Sprint(a...)))
+}
@@ -0,0 +1,32 @@
+//go:build !windows
+
+package ui
+
+import (
+	"errors"
+	"os"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// waitForInput waits up to timeout for a line on stdin, or for its end. A
+// terminal's line discipline makes stdin readable only once Enter is pressed.
+func waitForInput(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	fds := []unix.PollFd{{Fd: int32(os.Stdin.Fd()), Events: unix.POLLIN}}
+	for {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return false
+		}
+		n, err := unix.Poll(fds, int(left.Milliseconds())+1)
+		switch {
+		case errors.Is(err, unix.EINTR):
+			continue
+		case err != nil:
+			return true // Let the read report the problem
+		}
+		return n > 0
+	}
+}
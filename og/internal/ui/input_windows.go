@@ -0,0 +1,17 @@
+//go:build windows
+
+package ui
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// waitForInput waits up to timeout for input on stdin. A console handle is
+// signaled by any input event, so a key press without Enter ends the wait too.
+func waitForInput(timeout time.Duration) bool {
+	event, err := windows.WaitForSingleObject(windows.Handle(os.Stdin.Fd()), uint32(timeout.Milliseconds()))
+	return err != nil || event != uint32(windows.WAIT_TIMEOUT)
+}
@@ -0,0 +1,96 @@
+package ui
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/term"
+)
+
+// progressBarWidth is the number of cells of the bar of a progress line.
+const progressBarWidth = 20
+
+// showProgress shows a "progress" message as a line that the next progress
+// message of the step replaces, and that is cleared before anything else is
+// printed. Without a terminal on stdout it shows nothing; the session log
+// records the messages.
+func (c *ConsoleUI) showProgress(msg AgentMessage) {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		return
+	}
+	var line strings.Builder
+	line.WriteString("⏳ ")
+	if msg.StepID > 0 {
+		fmt.Fprintf(&line, "Step %d: ", msg.StepID)
+	}
+	if msg.Percent != nil {
+		p := min(max(*msg.Percent, 0), 100)
+		done := int(p / 100 * progressBarWidth)
+		fmt.Fprintf(&line, "[%s%s] %.0f%%", strings.Repeat("#", done), strings.Repeat("-", progressBarWidth-done), p)
+	} else {
+		line.WriteString("running")
+	}
+	if ran := (time.Duration(msg.DurationMs) * time.Millisecond).Round(time.Second); ran > 0 && msg.Percent != nil {
+		fmt.Fprintf(&line, " after %s", ran)
+	} else if ran > 0 {
+		fmt.Fprintf(&line, " for %s", ran)
+	}
+	if msg.Message != "" {
+		line.WriteString(", " + msg.Message)
+	}
+	c.showLine(cyan(TruncateLine(c.redact(line.String()), TerminalWidth()-1)))
+}
+
+// showLine shows line in place of the progress line, unless a prompt waits
+// for an answer.
+func (c *ConsoleUI) showLine(line string) {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	if c.prompting {
+		return
+	}
+	fmt.Print("\r" + c.Text(line) + "\033[K")
+	c.progress = true
+}
+
+// PrintCountdown waits for delay. When stdout is a terminal it shows what line
+// returns for the time left on a progress line updated every second, and
+// clears it once delay has passed; otherwise it prints line(delay) once.
+func (c *ConsoleUI) PrintCountdown(delay time.Duration, line func(left time.Duration) string) {
+	if !term.IsTerminal(int(os.Stdout.Fd())) {
+		c.println(line(delay))
+		time.Sleep(delay)
+		return
+	}
+	deadline := time.Now().Add(delay)
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for left := delay; left > 0; left = time.Until(deadline) {
+		c.showLine(TruncateLine(line(left), TerminalWidth()-1))
+		select {
+		case <-ticker.C:
+		case <-time.After(left):
+		}
+	}
+	c.clearProgress()
+}
+
+// clearProgress clears the progress line, if one is shown, so that what is
+// printed next starts on a line of its own.
+func (c *ConsoleUI) clearProgress() {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	if c.progress {
+		fmt.Print("\r\033[K")
+		c.progress = false
+	}
+}
+
+// setPrompting records whether a prompt waits for an answer.
+func (c *ConsoleUI) setPrompting(on bool) {
+	c.progressMu.Lock()
+	defer c.progressMu.Unlock()
+	c.prompting = on
+}
@@ -0,0 +1,115 @@
+package ui
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+// Theme holds the colors of the kinds of console output ([ui.theme]).
+type Theme struct {
+	Plan     *color.Color // Headings of plans
+	Approval *color.Color // Approval prompts and what they ask about: steps, patches, SQL queries
+	Error    *color.Color // Errors and blocked steps
+	Summary  *color.Color // The final summary and the results of steps
+	Logs     *color.Color // Tags of log lines; nil colors them by level
+}
+
+// Themes are the presets of ui.theme.preset.
+var Themes = map[string]Theme{
+	"default": {
+		Plan:     color.New(color.FgYellow),
+		Approval: color.New(color.FgYellow),
+		Error:    color.New(color.FgRed),
+		Summary:  color.New(color.FgGreen),
+	},
+	"high-contrast": {
+		Plan:     color.New(color.Bold, color.FgHiCyan),
+		Approval: color.New(color.Bold, color.FgHiYellow),
+		Error:    color.New(color.Bold, color.FgHiRed),
+		Summary:  color.New(color.Bold, color.FgHiGreen),
+		Logs:     color.New(color.Bold, color.FgHiWhite),
+	},
+}
+
+// colorNames are the names ParseColor knows; "bright-" or "hi-" before one
+// selects its bright variant.
+var colorNames = map[string]color.Attribute{
+	"black":   color.FgBlack,
+	"red":     color.FgRed,
+	"green":   color.FgGreen,
+	"yellow":  color.FgYellow,
+	"blue":    color.FgBlue,
+	"magenta": color.FgMagenta,
+	"cyan":    color.FgCyan,
+	"white":   color.FgWhite,
+}
+
+// ParseColor parses a color of ui.theme: a name such as "cyan" or
+// "bright-cyan", an index of the 256-color palette such as "208", or a
+// truecolor "#rrggbb", optionally preceded by "bold" or "underline", e.g.
+// "bold #ff8700".
+func ParseColor(s string) (*color.Color, error) {
+	words := strings.Fields(strings.ToLower(s))
+	if len(words) == 0 {
+		return nil, fmt.Errorf("empty color")
+	}
+	c := color.New()
+	for _, w := range words[:len(words)-1] {
+		switch w {
+		case "bold":
+			c.Add(color.Bold)
+		case "underline":
+			c.Add(color.Underline)
+		default:
+			return nil, fmt.Errorf("unknown style %q in %q; use bold or underline", w, s)
+		}
+	}
+	w := words[len(words)-1]
+	bright := strings.TrimPrefix(strings.TrimPrefix(w, "bright-"), "hi-")
+	switch {
+	case colorNames[w] != 0:
+		c.Add(colorNames[w])
+	case bright != w && colorNames[bright] != 0:
+		c.Add(colorNames[bright] + color.FgHiBlack - color.FgBlack)
+	case strings.HasPrefix(w, "#"):
+		rgb, err := strconv.ParseUint(w[1:], 16, 32)
+		if err != nil || len(w) != 7 {
+			return nil, fmt.Errorf("%q is not a #rrggbb color", w)
+		}
+		c.AddRGB(int(rgb>>16), int(rgb>>8&0xff), int(rgb&0xff))
+	default:
+		n, err := strconv.Atoi(w)
+		if err != nil || n < 0 || n > 255 {
+			return nil, fmt.Errorf("unknown color %q; use a name, 0-255 or #rrggbb", w)
+		}
+		c.Add(38, 5, color.Attribute(n)) // 256-color foreground
+	}
+	return c, nil
+}
+
+// paint renders a in c, or with fallback when c is nil.
+func paint(c *color.Color, fallback func(a ...interface{}) string, a ...interface{}) string {
+	if c == nil {
+		return fallback(a...)
+	}
+	return c.Sprint(a...)
+}
+
+// SetTheme sets the colors the console output is printed in.
+func (c *ConsoleUI) SetTheme(theme Theme) {
+	c.theme = theme
+}
+
+func (c *ConsoleUI) plan(a ...interface{}) string     { return paint(c.theme.Plan, yellow, a...) }
+func (c *ConsoleUI) approval(a ...interface{}) string { return paint(c.theme.Approval, yellow, a...) }
+func (c *ConsoleUI) error(a ...interface{}) string    { return paint(c.theme.Error, red, a...) }
+func (c *ConsoleUI) summary(a ...interface{}) string  { return paint(c.theme.Summary, green, a...) }
+
+// logTag renders the tag of a log line, in level's color unless the theme
+// colors all log tags alike.
+func (c *ConsoleUI) logTag(level func(a ...interface{}) string, tag string) string {
+	return paint(c.theme.Logs, level, tag)
+}
@@ -2,9 +2,17 @@ package ui
 
 import (
 	"bufio"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
 
 	"github.com/fatih/color"
 )
@@ -61,41 +69,125 @@ var (
 	magenta = color.New(color.FgMagenta).SprintFunc()
 )
 
+// ApprovalChoice is the user's answer to a step approval prompt.
+type ApprovalChoice int
+
+const (
+	ApprovalNo     ApprovalChoice = iota // Deny this step
+	ApprovalYes                          // Approve this step
+	ApprovalAlways                       // Approve this step and matching steps for the rest of the session
+	ApprovalQuit                         // Deny this step and end the session
+)
+
+// FailureChoice is the user's answer when a recipe step fails.
+type FailureChoice int
+
+const (
+	FailureAbort FailureChoice = iota // Stop the recipe and end the session
+	FailureRetry                      // Run the step again
+	FailureSkip                       // Go on with the next step
+)
+
+// TimeoutChoice is the user's answer when a step runs longer than
+// general.step_timeout_seconds.
+type TimeoutChoice int
+
+const (
+	TimeoutAbort  TimeoutChoice = iota // Kill the step and end the session
+	TimeoutExtend                      // Let the step run for another timeout
+	TimeoutRetry                       // Kill the step and run it again
+)
+
 // AgentMessage represents the structure of messages from the Python agent.
 type AgentMessage struct {
-	Type             string        `json:"type"`
-	Message          string        `json:"message,omitempty"`
-	Request          string        `json:"request,omitempty"`
-	RecipeSteps      []AgentAction `json:"recipe_steps,omitempty"`
-	FallbackAction   *AgentAction  `json:"fallback_action,omitempty"`
-	Description      string        `json:"description,omitempty"`
-	Action           string        `json:"action,omitempty"`
-	Tool             string        `json:"tool,omitempty"`
-	Output           string        `json:"output,omitempty"`
-	Status           string        `json:"status,omitempty"`
-	InterpretMessage string        `json:"interpret_message,omitempty"`
-	Summary          string        `json:"summary,omitempty"`
-	Nutshell         string        `json:"nutshell,omitempty"`
-	Reason           string        `json:"reason,omitempty"`
-	Explanation      string        `json:"explanation,omitempty"`
-	Approved         bool          `json:"approved,omitempty"`
-	Location         string        `json:"location,omitempty"`
+	Type             string          `json:"type"`
+	Message          string          `json:"message,omitempty"`
+	Kind             string          `json:"kind,omitempty"` // Class of an "error": model_unreachable, tool_failed, protocol, aborted or internal
+	Request          string          `json:"request,omitempty"`
+	RecipeSteps      []AgentAction   `json:"recipe_steps,omitempty"`
+	FallbackAction   *AgentAction    `json:"fallback_action,omitempty"`
+	Description      string          `json:"description,omitempty"`
+	Action           string          `json:"action,omitempty"`
+	Tool             string          `json:"tool,omitempty"`
+	Output           string          `json:"output,omitempty"`
+	Status           string          `json:"status,omitempty"`
+	InterpretMessage string          `json:"interpret_message,omitempty"`
+	Summary          string          `json:"summary,omitempty"`
+	Nutshell         string          `json:"nutshell,omitempty"`
+	Reason           string          `json:"reason,omitempty"`
+	Explanation      string          `json:"explanation,omitempty"`
+	Approved         bool            `json:"approved,omitempty"`
+	Location         string          `json:"location,omitempty"`
+	Patch            string          `json:"patch,omitempty"`             // Unified diff carried by "proposed_patch"
+	Database         string          `json:"database,omitempty"`          // Configured database name carried by "sql_query"
+	Query            string          `json:"query,omitempty"`             // SQL statement carried by "sql_query"
+	Arguments        json.RawMessage `json:"arguments,omitempty"`         // JSON object of the arguments of an MCP tool, carried by "mcp_call"
+	ExitCode         *int            `json:"exit_code,omitempty"`         // Exit status of a shell step, carried by "result" and "post_step"
+	DurationMs       int64           `json:"duration_ms,omitempty"`       // How long a step ran, carried by "result" and "post_step"
+	Role             string          `json:"role,omitempty"`              // Agent role (planner, executor, auditor) the message comes from
+	Model            string          `json:"model,omitempty"`             // Model ID, carried by "token_usage"
+	PromptTokens     int64           `json:"prompt_tokens,omitempty"`     // Carried by "token_usage"
+	CompletionTokens int64           `json:"completion_tokens,omitempty"` // Carried by "token_usage"
+	ErrorType        string          `json:"error_type,omitempty"`        // Exception class of a failed model call, carried by "model_error"
+	StatusCode       int             `json:"status_code,omitempty"`       // HTTP status of a failed model call, carried by "model_error"
+	Attempt          int             `json:"attempt,omitempty"`           // How many times the model call has failed, carried by "model_error"
+	Steps            int             `json:"steps,omitempty"`             // Steps executed before the session was cancelled, carried by "cancelled"
+	Artifacts        []string        `json:"artifacts,omitempty"`         // Files left in the session's artifacts directory, carried by "cancelled"
+	Overridable      bool            `json:"overridable,omitempty"`       // The user may run the action an "unsafe" message blocks; og answers with "override_result"
+	Step             int             `json:"step,omitempty"`              // Number of the recipe step, carried by "result", "check_condition", "check_iteration", "step_started", "step_failed", "pre_step" and "post_step"
+	Condition        string          `json:"condition,omitempty"`         // Condition of a recipe step, carried by "check_condition"
+	Loop             string          `json:"loop,omitempty"`              // Loop of a recipe step, carried by "check_iteration"
+	Input            *StepInput      `json:"input,omitempty"`             // Value a recipe step needs from the user, carried by "request_input"
+	StepID           int             `json:"step_id,omitempty"`           // Number of the action in the session, counting retries, carried by "step_started", "pre_step", "post_step" and "progress"
+	Percent          *float64        `json:"percent,omitempty"`           // How far a running step got, 0 to 100, carried by "progress" when the step printed a percentage
+	Binary           *BinaryOutput   `json:"binary,omitempty"`            // Output of a step that is not text, carried by "result"
+	Commands         []StepCommand   `json:"commands,omitempty"`          // Commands of independent recipe steps og runs at once, carried by "run_commands"
 }
 
 // AgentAction models a single step in a recipe or fallback.
 type AgentAction struct {
-	Description string `json:"description"`
-	Action      string `json:"action"`
-	Tool        string `json:"tool"`
+	Description string      `json:"description"`
+	Action      string      `json:"action"`
+	Tool        string      `json:"tool"`
+	Condition   string      `json:"condition,omitempty"` // The step only runs if it holds, see agent.Condition
+	Loop        string      `json:"loop,omitempty"`      // The step may run more than once, see agent.Loop
+	Inputs      []StepInput `json:"inputs,omitempty"`    // Values the user provides when the step runs
+	Needs       []int       `json:"needs,omitempty"`     // Steps that must have run before it; nil means the step before, empty none
+}
+
+// StepCommand is the command of a recipe step that og runs at the same time
+// as others, see "run_commands".
+type StepCommand struct {
+	Step   int    `json:"step"`    // Number of the recipe step
+	StepID int    `json:"step_id"` // Number of the action in the session
+	Action string `json:"action"`
+}
+
+// StepInput is a placeholder in the commands of a recipe step, {Name}, whose
+// value the user is asked for when the agent reaches the step.
+type StepInput struct {
+	Name    string `json:"name"`
+	Prompt  string `json:"prompt"`            // What to ask the user
+	Pattern string `json:"pattern,omitempty"` // Regular expression the whole value must match
 }
 
 // UI interface defines methods for user interaction.
 type UI interface {
 	PrintHelp()
 	PromptForApproval(message string) bool
+	PromptForApprovalChoice(message string, allowAlways bool) ApprovalChoice
+	PromptForFailureChoice(message string) FailureChoice
+	PromptForTimeoutChoice(message string) TimeoutChoice
+	PromptForTypedConfirmation(message, command, phrase string) bool
+	PromptForInput(message string) (string, bool)
+	PromptForPathSelection(message string, paths []string) (selected []string, quit bool)
+	PromptForRecipeSelection(message string, steps int) (selected []int, approved bool)
+	PromptForEditor(files []string, editors []string) int
 	PrintAgentMessage(msg AgentMessage, minGoLogLevel LogLevel)
 	PrintColored(c func(a ...interface{}) string, format string, a ...interface{})
 	PrintStderr(line string, minGoLogLevel LogLevel)
+	PrintStepLine(step int, line string)
+	PrintCountdown(delay time.Duration, line func(left time.Duration) string)
 	// Expose color functions directly for external use
 	Green(a ...interface{}) string
 	Blue(a ...interface{}) string
@@ -106,27 +198,127 @@ type UI interface {
 }
 
 // ConsoleUI implements the UI interface for console output.
-type ConsoleUI struct{}
+type ConsoleUI struct {
+	inlineMaxBytes int                 // 0 prints tool output in full
+	redact         func(string) string // Masks secrets in tool output and agent stderr
+	speaker        string              // Role of the last agent message printed
+	theme          Theme
+	ascii          bool          // Print icons as ASCII tags, see Text
+	promptTimeout  time.Duration // How long prompts wait for an answer; 0 waits forever
+	approveTimeout bool          // Approve what an unanswered approval prompt asks about, rather than deny it
+
+	progressMu sync.Mutex // Progress is shown from the goroutines that run steps
+	progress   bool       // A progress line is shown, see showProgress
+	prompting  bool       // A prompt waits for an answer, which progress lines must not overwrite
+}
 
 // NewConsoleUI creates a new ConsoleUI instance.
 func NewConsoleUI() *ConsoleUI {
-	return &ConsoleUI{}
+	return &ConsoleUI{redact: func(s string) string { return s }, theme: Themes["default"]}
+}
+
+// SetInlineMaxBytes limits how much of a tool's output is printed inline.
+func (c *ConsoleUI) SetInlineMaxBytes(n int) {
+	c.inlineMaxBytes = n
+}
+
+// SetRedactor sets the function used to mask secrets before tool output and
+// agent stderr are printed.
+func (c *ConsoleUI) SetRedactor(redact func(string) string) {
+	c.redact = redact
+}
+
+// SetPromptTimeout makes prompts give up waiting for an answer after timeout
+// (general.approval_timeout_seconds). An approval prompt left unanswered then
+// approves what it asks about when approve is set, and denies it otherwise;
+// typed confirmations of dangerous commands are always denied, and the other
+// prompts are answered as when the input ends.
+func (c *ConsoleUI) SetPromptTimeout(timeout time.Duration, approve bool) {
+	c.promptTimeout, c.approveTimeout = timeout, approve
+}
+
+// errNoAnswer is returned by readAnswer when a prompt timed out.
+var errNoAnswer = errors.New("no answer")
+
+// readAnswer reads the answer to a prompt from reader. When no answer came
+// within the prompt timeout, it says what is done instead and returns
+// errNoAnswer.
+func (c *ConsoleUI) readAnswer(reader *bufio.Reader, instead string) (string, error) {
+	c.setPrompting(true)
+	defer c.setPrompting(false)
+	if c.promptTimeout > 0 && reader.Buffered() == 0 && !waitForInput(c.promptTimeout) {
+		c.printf("\n%s\n", yellow(fmt.Sprintf("No answer within %s; %s.", c.promptTimeout, instead)))
+		return "", errNoAnswer
+	}
+	return reader.ReadString('\n')
+}
+
+// timeoutApproval describes what an unanswered approval prompt does.
+func (c *ConsoleUI) timeoutApproval() string {
+	if c.approveTimeout {
+		return "approved (general.approval_timeout_default)"
+	}
+	return "denied"
 }
 
 // PrintHelp prints the application's help message.
 func (c *ConsoleUI) PrintHelp() {
-	fmt.Print(`OG: Command-line AI agent
+	c.print(`OG: Command-line AI agent
 
 Usage:
   og <prompt>             Run OG agent on a prompt (natural language or shell-like)
+  og -- <prompt>          Treat every word as the prompt, even a line that parses as a subcommand (or -p, --prompt)
+  og <prompt> --then <prompt>  Run prompts in turn, each once the previous one completed
+  og continue <prompt>    Follow up on the most recent session, with what it did as context
+  og resume <hash>        Take up a session that was cut short mid-recipe, from the first step that did not run
+  og undo <hash>          Restore the files a session's steps changed as the session found them (--list)
   og init                 Write default config to ~/.local/share/og/og_config.toml
+  og selftest             Check og and this terminal with a session of a built-in echo agent, no model needed
+  og daemon               Keep an agent warm so sessions start faster (status, stop)
+  og debug tail <hash>    Show the agent log of a session (-n lines, -f to follow, --og for og's log)
+  og history list         List past sessions (--user <name> or --all-users for shared stores)
+  og history search <q>   Search past sessions (--since 7d, --until, --cwd, --status)
+  og history show <hash>  Print the stored transcript of a session
+  og export <hash>        Export a session as a shareable transcript (--format md|json|html, -o file), or --script to rerun its commands
+  og trust --for <dur>    Temporarily trust the current directory (--list, --revoke)
+  og refusals             List the refusals in this directory that the agent is told about (--forget <n>, --clear)
+  og distill              Turn commands sessions here ran several times into a Makefile target or Taskfile task (--min <n>, --name, --taskfile)
+  og policy test <file>   Replay stored sessions through a proposed policy and report changes
+  og clean --cache --history  Remove old cache files and sessions (--older-than 30d, --dry-run)
+  og db list              List databases for sql_query_tool (set-dsn <name>, query <name> <sql>)
+  og stats                Report sessions per week, top tools, approvals, durations and spend (--since 30d, --json)
+  og bench --models <m,m>  Have planner models plan recent read-only requests again and compare their plans (--replay-last 10, --json)
+  og timeline             Export when sessions ran and how long they took (--since 30d, --format ical|json, -o file)
+  og audit                Query the audit log of approved and executed actions (--session, --tool, --since, --failed, --json)
+  og config diff          Show how og_config.toml differs from this version's defaults (--all)
+  og config rollback      Restore og_config.toml from its newest backup, or the one named (--list)
+  og prompts diff         Show how prompts.toml differs from this version's built-in prompts (--all)
+  og completion <shell>   Print a completion script for bash, zsh or fish
+  og hook <shell>         Print a hook for bash, zsh or fish that lets og see your last command and its exit status
+  og version              Show version, commit, build date and agent protocol compatibility (--json)
   og --help, -h           Show this help message
-  og --verbosity <level>  Set log verbosity (debug, info, warn, none)
+  og --verbosity <level>  Set log verbosity (debug, info, warn, none; overrides verbosity_level)
+  og --ascii <prompt>     Print ASCII tags such as [OK] and [WARN] instead of emoji (or set ui.ascii)
+  og --sandbox-copy <prompt>  Run in a throwaway copy of the directory, then review the diff before applying it
+  og --strict-config <prompt>  Treat warnings about og_config.toml as errors
+  og --read-only <prompt>  Deny every step that writes, deletes or changes state elsewhere, and tell the agent so
+  og --no-stdin <prompt>  Ignore piped input instead of attaching it to the prompt as context
+  og --file <glob> <prompt>  Attach the contents of matching files to the prompt (repeatable; ** spans directories)
+  og --dir <path> <prompt>   Attach a directory's listing to the prompt (repeatable; honors .ogignore)
+  og --no-last-command <prompt>  Do not attach the last shell command exported by og hook
+  og --record <file> <prompt>  Record every message exchanged with the agent, for bug reports and tests
+  og --replay <file> [prompt]  Play a recording back instead of running the agent (no models are called)
 
 Examples:
   og "summarize this repo"
   og "generate a gitignore for Rust"
   og "list files modified in last commit"
+  og "run the tests" --then "fix the first failing test" --then "re-run it"
+  cat error.log | og "explain this"
+  og "why did that fail?"   (after a failed command, with og hook loaded)
+  og history of this repo   (a prompt: the words after history are not its arguments)
+  og -- history list        (a prompt, not og history list)
+  og --file 'internal/**/*.go' --dir docs "where is the retry logic documented?"
 
 Config:
   Config file: ~/.local/share/og/og_config.toml
@@ -140,61 +332,393 @@ Tips:
 
 // PromptForApproval shows a yes/no prompt and returns true if approved.
 func (c *ConsoleUI) PromptForApproval(message string) bool {
-	fmt.Printf("\n%s\n", yellow(message))
-	fmt.Printf("%s [y/N]: ", blue("Approve?"))
-	reader := bufio.NewReader(os.Stdin)
-	input, _ := reader.ReadString('\n')
+	c.printf("\n%s\n", c.approval(message))
+	c.printf("%s [y/N]: ", blue("Approve?"))
+	input, err := c.readAnswer(bufio.NewReader(os.Stdin), c.timeoutApproval())
+	if err == errNoAnswer {
+		return c.approveTimeout
+	}
 	return strings.ToLower(strings.TrimSpace(input)) == "y"
 }
 
-// PrintAgentMessage processes and prints each JSON message from Python.
+// PromptForApprovalChoice shows a y/n/a/q prompt for a single step. When allowAlways
+// is false the "always" option is not offered.
+func (c *ConsoleUI) PromptForApprovalChoice(message string, allowAlways bool) ApprovalChoice {
+	c.printf("\n%s\n", c.approval(message))
+	if allowAlways {
+		c.printf("%s [y/N/a(lways)/q(uit)]: ", blue("Approve?"))
+	} else {
+		c.printf("%s [y/N/q(uit)]: ", blue("Approve?"))
+	}
+	input, err := c.readAnswer(bufio.NewReader(os.Stdin), c.timeoutApproval())
+	if err == errNoAnswer && c.approveTimeout {
+		return ApprovalYes
+	}
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "y", "yes":
+		return ApprovalYes
+	case "a", "always":
+		if allowAlways {
+			return ApprovalAlways
+		}
+		return ApprovalNo
+	case "q", "quit":
+		return ApprovalQuit
+	default:
+		return ApprovalNo
+	}
+}
+
+// PromptForFailureChoice asks whether to retry a failed recipe step, skip it or
+// abort the recipe, which is the answer when the input ends.
+func (c *ConsoleUI) PromptForFailureChoice(message string) FailureChoice {
+	c.printf("\n%s\n", red(message))
+	c.printf("%s [r(etry)/s(kip)/A(bort)]: ", blue("Go on?"))
+	input, _ := c.readAnswer(bufio.NewReader(os.Stdin), "aborting")
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "r", "retry":
+		return FailureRetry
+	case "s", "skip":
+		return FailureSkip
+	default:
+		return FailureAbort
+	}
+}
+
+// PromptForTimeoutChoice asks whether to give a step that runs too long more
+// time, run it again or abort, which is the answer when the input ends.
+func (c *ConsoleUI) PromptForTimeoutChoice(message string) TimeoutChoice {
+	c.printf("\n%s\n", yellow(message))
+	c.printf("%s [e(xtend)/r(etry)/A(bort)]: ", blue("Go on?"))
+	input, _ := c.readAnswer(bufio.NewReader(os.Stdin), "aborting")
+	switch strings.ToLower(strings.TrimSpace(input)) {
+	case "e", "extend":
+		return TimeoutExtend
+	case "r", "retry":
+		return TimeoutRetry
+	default:
+		return TimeoutAbort
+	}
+}
+
+// PromptForTypedConfirmation requires the user to retype the command (when given)
+// or phrase (policy.ConfirmationPhrase) to approve. Anything else is a denial.
+func (c *ConsoleUI) PromptForTypedConfirmation(message, command, phrase string) bool {
+	c.printf("\n%s\n", red(message))
+	if command != "" {
+		c.printf("%s\n", yellow(fmt.Sprintf("Type the command exactly as shown, or '%s', to approve:", phrase)))
+		c.printf("  %s\n", command)
+	} else {
+		c.printf("%s\n", yellow(fmt.Sprintf("Type '%s' to approve:", phrase)))
+	}
+	c.printf("%s ", blue(">"))
+	input, _ := c.readAnswer(bufio.NewReader(os.Stdin), "denied")
+	input = strings.TrimSpace(input)
+	if strings.EqualFold(input, phrase) {
+		return true
+	}
+	return command != "" && input == strings.TrimSpace(command)
+}
+
+// PromptForInput asks the user for a line of text. It returns false when the
+// input ended, e.g. in a non-interactive session.
+func (c *ConsoleUI) PromptForInput(message string) (string, bool) {
+	c.printf("\n%s\n%s ", yellow(message), blue(">"))
+	input, err := c.readAnswer(bufio.NewReader(os.Stdin), "going on without it")
+	if err != nil && input == "" {
+		c.println()
+		return "", false
+	}
+	return strings.TrimSpace(input), true
+}
+
+// PromptForPathSelection lists paths grouped by directory, numbered, and lets the
+// user approve all of them, none, or all but some ("e 2 5" excludes paths 2 and 5).
+// It returns the approved paths in their original order.
+func (c *ConsoleUI) PromptForPathSelection(message string, paths []string) ([]string, bool) {
+	c.printf("\n%s\n", yellow(message))
+
+	// Group by directory, keeping directories in order of first appearance
+	var dirs []string
+	byDir := map[string][]int{}
+	for i, p := range paths {
+		dir := filepath.Dir(p)
+		if _, ok := byDir[dir]; !ok {
+			dirs = append(dirs, dir)
+		}
+		byDir[dir] = append(byDir[dir], i)
+	}
+	// Paths are numbered in display order; order maps a number back to its path
+	var order []int
+	for _, dir := range dirs {
+		c.printf("  %s %s (%d)\n", blue("📁"), cyan(dir+string(filepath.Separator)), len(byDir[dir]))
+		for _, i := range byDir[dir] {
+			order = append(order, i)
+			c.printf("     %2d. %s\n", len(order), filepath.Base(paths[i]))
+		}
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		c.printf("%s [y(es, all %d)/N/e <numbers> (exclude)/q(uit)]: ", blue("Approve?"), len(paths))
+		input, err := c.readAnswer(reader, c.timeoutApproval())
+		if err == errNoAnswer && c.approveTimeout {
+			return paths, false
+		}
+		input = strings.ToLower(strings.TrimSpace(input))
+		switch {
+		case input == "y" || input == "yes":
+			return paths, false
+		case input == "q" || input == "quit":
+			return nil, true
+		case strings.HasPrefix(input, "e"):
+			excluded := map[int]bool{}
+			valid := true
+			for _, f := range strings.FieldsFunc(strings.TrimPrefix(input, "e"), func(r rune) bool { return r == ' ' || r == ',' }) {
+				n, err := strconv.Atoi(f)
+				if err != nil || n < 1 || n > len(paths) {
+					c.println(red(fmt.Sprintf("Not a path number: %s", f)))
+					valid = false
+					break
+				}
+				excluded[order[n-1]] = true
+			}
+			if !valid || len(excluded) == 0 {
+				continue
+			}
+			var selected []string
+			for i, p := range paths {
+				if !excluded[i] {
+					selected = append(selected, p)
+				}
+			}
+			return selected, false
+		default:
+			return nil, false
+		}
+	}
+}
+
+// PromptForRecipeSelection asks whether to run a recipe of n steps, offering to
+// run only some of them, in another order ("e 1 3 2" runs step 3 before step 2
+// and skips the rest). It returns the numbers of the steps to run, in order.
+func (c *ConsoleUI) PromptForRecipeSelection(message string, n int) ([]int, bool) {
+	c.printf("\n%s\n", yellow(message))
+	reader := bufio.NewReader(os.Stdin)
+	for {
+		c.printf("%s [y/N/e <steps> (edit: run these steps, in this order)]: ", blue("Approve?"))
+		input, err := c.readAnswer(reader, c.timeoutApproval())
+		if err == errNoAnswer && c.approveTimeout {
+			input = "y"
+		}
+		input = strings.ToLower(strings.TrimSpace(input))
+		switch {
+		case input == "y" || input == "yes":
+			all := make([]int, n)
+			for i := range all {
+				all[i] = i + 1
+			}
+			return all, true
+		case strings.HasPrefix(input, "e"):
+			var selected []int
+			valid := true
+			for _, f := range strings.FieldsFunc(strings.TrimPrefix(strings.TrimPrefix(input, "edit"), "e"), func(r rune) bool { return r == ' ' || r == ',' }) {
+				step, err := strconv.Atoi(f)
+				if err != nil || step < 1 || step > n || slices.Contains(selected, step) {
+					c.println(red(fmt.Sprintf("Not a step number, or given twice: %s", f)))
+					valid = false
+					break
+				}
+				selected = append(selected, step)
+			}
+			if !valid {
+				continue
+			}
+			if len(selected) == 0 {
+				c.println(yellow("Name the steps to run, e.g. e 1 3 2."))
+				continue
+			}
+			return selected, true
+		default:
+			return nil, false
+		}
+	}
+}
+
+// PromptForEditor offers to open files a step wrote in one of editors. It returns
+// the index of the chosen editor, or -1 when the user skips.
+func (c *ConsoleUI) PromptForEditor(files []string, editors []string) int {
+	c.printf("\n%s %s\n", yellow("✏️  Written:"), strings.Join(files, ", "))
+	keys := make([]string, len(editors))
+	for i, name := range editors {
+		keys[i] = fmt.Sprintf("%d %s", i+1, name)
+	}
+	c.printf("%s [%s/Enter skip]: ", blue("Open in editor?"), strings.Join(keys, "/"))
+	input, _ := c.readAnswer(bufio.NewReader(os.Stdin), "skipped")
+	input = strings.ToLower(strings.TrimSpace(input))
+	if n, err := strconv.Atoi(input); err == nil && n >= 1 && n <= len(editors) {
+		return n - 1
+	}
+	if (input == "e" || input == "y" || input == "yes") && len(editors) > 0 {
+		return 0
+	}
+	return -1
+}
+
+// roleBadges color the badge of each agent role; other roles get roleBadgeOther.
+var (
+	roleBadges = map[string]func(a ...interface{}) string{
+		"planner":  color.New(color.BgCyan, color.FgBlack).SprintFunc(),
+		"executor": color.New(color.BgGreen, color.FgBlack).SprintFunc(),
+		"auditor":  color.New(color.BgMagenta, color.FgBlack).SprintFunc(),
+	}
+	roleBadgeOther = color.New(color.BgYellow, color.FgBlack).SprintFunc()
+)
+
+// RoleBadge renders the name of an agent role as a colored badge.
+func RoleBadge(role string) string {
+	badge, ok := roleBadges[role]
+	if !ok {
+		badge = roleBadgeOther
+	}
+	return badge("[" + role + "]")
+}
+
+// printed reports whether PrintAgentMessage shows anything for msg.
+func printed(msg AgentMessage, minGoLogLevel LogLevel) bool {
+	switch msg.Type {
+	case "error", "unsafe", "plan", "request_approval", "proposed_patch", "sql_query", "mcp_call",
+		"final_summary", "result", "cancelling", "cancelled":
+		return true
+	case "check_condition", "check_iteration", "request_input", "step_started", "run_command", "pre_step", "post_step", "deny_current_action", "progress", "run_commands":
+		return false
+	case "token_usage", "model_error", "debug_log":
+		return minGoLogLevel <= LogLevelDebug
+	case "warn_log":
+		return minGoLogLevel <= LogLevelWarn
+	}
+	return minGoLogLevel <= LogLevelInfo
+}
+
+// PrintAgentMessage processes and prints each JSON message from Python. When
+// the message comes from another agent role than the last one printed, the new
+// role's badge is printed first.
 func (c *ConsoleUI) PrintAgentMessage(msg AgentMessage, minGoLogLevel LogLevel) {
+	if msg.Role != "" && msg.Role != c.speaker && printed(msg, minGoLogLevel) {
+		c.speaker = msg.Role
+		c.printf("\n%s\n", RoleBadge(msg.Role))
+	}
 	// Core messages always print regardless of Go verbosity level
 	switch msg.Type {
 	case "error":
-		fmt.Printf("%s %s\n", red("[ERROR]"), msg.Message)
+		switch msg.Kind {
+		case "model_unreachable":
+			c.printf("%s %s\n", c.error("🔌 [MODEL UNREACHABLE]"), msg.Message)
+			c.println(yellow("Check that the model endpoint is running and that its credentials are valid; `check_models` in [general] verifies the models when a session starts."))
+		case "tool_failed":
+			c.printf("%s %s\n", c.error("🛠️  [TOOL FAILED]"), msg.Message)
+		case "protocol":
+			c.printf("%s %s\n", c.error("[PROTOCOL ERROR]"), msg.Message)
+			c.println(yellow("og and the agent disagree about the protocol; `og version` checks that they match."))
+		case "aborted":
+			c.printf("%s %s\n", yellow("[ABORTED]"), msg.Message)
+		default:
+			c.printf("%s %s\n", c.error("[ERROR]"), msg.Message)
+		}
 	case "unsafe":
-		fmt.Printf("%s %s\n", red("[UNSAFE]"), msg.Reason)
+		c.printf("%s %s\n", c.error("[UNSAFE]"), msg.Reason)
+		if msg.Action != "" {
+			c.printf("  %s %s (%s)\n", yellow("Cmd:"), msg.Action, msg.Tool)
+		}
 		exp := strings.TrimSpace(msg.Explanation)
 		if exp != "" {
-			fmt.Println(yellow("Explanation:"))
-			fmt.Println(exp)
+			c.println(yellow("Explanation:"))
+			c.println(exp)
 		}
 	case "plan":
-		fmt.Printf("\n%s\n%s %s\n", yellow("🧠 Plan:"), blue("Request:"), msg.Request)
+		c.printf("\n%s\n%s %s\n", c.plan("🧠 Plan:"), blue("Request:"), msg.Request)
 
 		isMultiStepRecipe := len(msg.RecipeSteps) > 1 || msg.FallbackAction != nil
 
+		// Descriptions are truncated to the terminal width; commands are always shown in full.
+		descWidth := TerminalWidth() - DisplayWidth("  Step 00. ")
+
 		if isMultiStepRecipe {
-			fmt.Printf("\n%s\n", blue("Steps:"))
+			c.printf("\n%s\n", blue("Steps:"))
 			for i, s := range msg.RecipeSteps {
-				fmt.Printf("  %s %d. %s\n      %s: %s (%s)\n", cyan("Step"), i+1, s.Description, yellow("Act"), s.Action, s.Tool)
+				c.printf("  %s %d. %s\n", cyan("Step"), i+1, TruncateLine(s.Description, descWidth))
+				if s.Condition != "" {
+					c.printf("      %s: %s\n", magenta("Only if"), s.Condition)
+				}
+				if s.Loop != "" {
+					c.printf("      %s: %s\n", magenta("Repeats"), s.Loop)
+				}
+				for _, in := range s.Inputs {
+					c.printf("      %s: {%s} %s\n", magenta("Asks"), in.Name, in.Prompt)
+				}
+				if s.Needs != nil {
+					c.printf("      %s: %s\n", magenta("After"), formatNeeds(s.Needs))
+				}
+				c.printf("      %s: %s (%s)\n", yellow("Act"), s.Action, s.Tool)
 			}
 			if msg.FallbackAction != nil {
-				fmt.Printf("\n%s %s (%s)\n", yellow("Fallback:"), msg.FallbackAction.Action, msg.FallbackAction.Tool)
+				c.printf("\n%s %s (%s)\n", yellow("Fallback:"), msg.FallbackAction.Action, msg.FallbackAction.Tool)
 			}
 		} else {
-			fmt.Printf("\n%s\n", blue("Proposed Action:"))
+			c.printf("\n%s\n", blue("Proposed Action:"))
 			s := msg.RecipeSteps[0]
-			fmt.Printf("  %s 1. %s\n      %s: %s (%s)\n", cyan("Action"), s.Description, yellow("Act"), s.Action, s.Tool)
-			fmt.Println(yellow("Auto-proceeding to execution for individual step approval."))
+			c.printf("  %s 1. %s\n      %s: %s (%s)\n", cyan("Action"), TruncateLine(s.Description, descWidth), yellow("Act"), s.Action, s.Tool)
+			c.println(yellow("Auto-proceeding to execution for individual step approval."))
 		}
 
 	case "request_approval":
-		fmt.Printf("\n%s\n  %s %s\n  %s %s (%s)\n", yellow("🤖 Approval Needed"),
+		c.printf("\n%s\n  %s %s\n  %s %s (%s)\n", c.approval("🤖 Approval Needed"),
 			cyan("Desc:"), msg.Description,
 			yellow("Cmd:"), msg.Action, msg.Tool)
+	case "proposed_patch":
+		c.printf("\n%s\n  %s %s\n\n%s\n", c.approval("📝 Proposed Changes"), cyan("Desc:"), msg.Description, FormatDiff(msg.Patch))
+	case "check_condition", "check_iteration", "request_input", "step_started", "run_command", "pre_step", "post_step", "run_commands":
+		// The message processor reports whether the step runs
+		return
+	case "sql_query":
+		c.printf("\n%s %s\n  %s\n", c.approval("🗄️  SQL query on"), cyan(msg.Database), msg.Query)
+	case "mcp_call":
+		c.printf("\n%s %s\n  %s\n", c.approval("🔌 MCP tool"), cyan(msg.Tool), string(msg.Arguments))
 	case "final_summary":
-		fmt.Printf("\n%s\n  %s %s\n  %s %s\n", green("🏁 Summary:"), cyan("Nutshell:"), msg.Nutshell, cyan("Details:"), msg.Summary)
+		c.printf("\n%s\n  %s %s\n  %s %s\n", c.summary("🏁 Summary:"), cyan("Nutshell:"), msg.Nutshell, cyan("Details:"), msg.Summary)
 	case "result":
-		fmt.Printf("\n%s %s%s\n%s %s\n", green("Result:"), getStatusEmoji(msg.Status), msg.Status,
+		c.printf("\n%s %s%s\n%s %s\n", c.summary("Result:"), getStatusEmoji(msg.Status), msg.Status,
 			blue("Info:"), msg.InterpretMessage)
 		if trimmed := strings.TrimSpace(msg.Output); trimmed != "" {
-			fmt.Printf("\n%s\n%s\n", green("Output:"), formatOutput(msg.Output))
+			c.printf("\n%s\n%s\n", c.summary("Output:"), formatOutput(c.limitOutput(c.redact(msg.Output))))
+		}
+		if msg.Binary != nil {
+			c.printf("\n%s\n", c.formatBinary(msg.Binary))
+		}
+	case "progress":
+		c.showProgress(msg)
+	case "cancelling":
+		c.printf("%s %s\n", yellow("🛑 Cancelling:"), msg.Message)
+	case "cancelled":
+		c.printf("%s %s\n", yellow("🛑 [CANCELLED]"), msg.Message)
+		if len(msg.Artifacts) > 0 {
+			c.println(blue("Artifacts kept:"))
+			for _, a := range msg.Artifacts {
+				c.printf("  %s\n", cyan(a))
+			}
 		}
 	case "deny_current_action":
 		// This message just signals Go to terminate, Python already handles the user-facing output
 		return
+	case "token_usage":
+		if minGoLogLevel <= LogLevelDebug {
+			c.printf("%s %s (%s): %d prompt + %d completion tokens\n", c.logTag(magenta, "[TOKENS]"), msg.Role, msg.Model, msg.PromptTokens, msg.CompletionTokens)
+		}
+	case "model_error":
+		// The message processor reports retries; the error itself is only for debugging
+		if minGoLogLevel <= LogLevelDebug {
+			c.printf("%s %s (%s): %s: %s\n", c.logTag(magenta, "[MODEL ERROR]"), msg.Role, msg.Model, msg.ErrorType, msg.Message)
+		}
 	default:
 		// Categorized log messages, filtered by minGoLogLevel
 		var msgLevel LogLevel
@@ -226,7 +750,7 @@ func (c *ConsoleUI) PrintAgentMessage(msg AgentMessage, minGoLogLevel LogLevel)
 			if msg.Location != "" {
 				location = fmt.Sprintf(" {%s}", msg.Location)
 			}
-			fmt.Printf("%s%s %s\n", colorFunc(fmt.Sprintf("[%s]", levelTag)), location, msg.Message)
+			c.printf("%s%s %s\n", c.logTag(colorFunc, fmt.Sprintf("[%s]", levelTag)), location, msg.Message)
 		}
 	}
 }
@@ -245,6 +769,24 @@ func getStatusEmoji(status string) string {
 	}
 }
 
+// FormatDiff colorizes a unified diff: additions green, removals red, hunk headers cyan.
+func FormatDiff(diff string) string {
+	lines := strings.Split(strings.TrimRight(diff, "\n"), "\n")
+	for i, l := range lines {
+		switch {
+		case strings.HasPrefix(l, "+++") || strings.HasPrefix(l, "---"):
+			lines[i] = blue(l)
+		case strings.HasPrefix(l, "@@"):
+			lines[i] = cyan(l)
+		case strings.HasPrefix(l, "+"):
+			lines[i] = green(l)
+		case strings.HasPrefix(l, "-"):
+			lines[i] = red(l)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
 // formatOutput indents multi-line tool output.
 func formatOutput(output string) string {
 	lines := strings.Split(output, "\n")
@@ -254,22 +796,55 @@ func formatOutput(output string) string {
 	return strings.Join(lines, "\n")
 }
 
+// limitOutput cuts output down to inlineMaxBytes without splitting a UTF-8 sequence.
+func (c *ConsoleUI) limitOutput(output string) string {
+	if c.inlineMaxBytes <= 0 || len(output) <= c.inlineMaxBytes {
+		return output
+	}
+	cut := c.inlineMaxBytes
+	for cut > 0 && !utf8.RuneStart(output[cut]) {
+		cut--
+	}
+	return fmt.Sprintf("%s\n%s", output[:cut], yellow(fmt.Sprintf("[... %d more bytes not shown]", len(output)-cut)))
+}
+
 // PrintColored prints a formatted message with a specific color.
 func (c *ConsoleUI) PrintColored(colorFunc func(a ...interface{}) string, format string, a ...interface{}) {
-	fmt.Print(colorFunc(fmt.Sprintf(format, a...)))
+	c.print(colorFunc(fmt.Sprintf(format, a...)))
+}
+
+// PrintStepLine prints a line of the output of a recipe step that runs at
+// the same time as others, prefixed with the step's number.
+func (c *ConsoleUI) PrintStepLine(step int, line string) {
+	c.printf("%s %s\n", cyan(fmt.Sprintf("[step %d]", step)), c.redact(line))
+}
+
+// formatNeeds describes the steps a recipe step runs after.
+func formatNeeds(needs []int) string {
+	if len(needs) == 0 {
+		return "nothing; it may run with the steps before it"
+	}
+	steps := make([]string, len(needs))
+	for i, n := range needs {
+		steps[i] = strconv.Itoa(n)
+	}
+	if len(needs) == 1 {
+		return "step " + steps[0]
+	}
+	return "steps " + strings.Join(steps, ", ")
 }
 
 // PrintStderr prints messages from the Python agent's stderr stream.
 func (c *ConsoleUI) PrintStderr(line string, minGoLogLevel LogLevel) {
 	if minGoLogLevel <= LogLevelDebug { // Only print stderr at debug level
-		fmt.Fprintln(os.Stderr, magenta("[PY STDERR]"), line)
+		fmt.Fprintln(os.Stderr, c.logTag(magenta, "[PY STDERR]"), c.redact(line))
 	}
 }
 
 // Expose color functions
-func (c *ConsoleUI) Green(a ...interface{}) string   { return green(a...) }
-func (c *ConsoleUI) Blue(a ...interface{}) string    { return blue(a...) }
-func (c *ConsoleUI) Yellow(a ...interface{}) string  { return yellow(a...) }
-func (c *ConsoleUI) Red(a ...interface{}) string     { return red(a...) }
-func (c *ConsoleUI) Cyan(a ...interface{}) string    { return cyan(a...) }
-func (c *ConsoleUI) Magenta(a ...interface{}) string { return magenta(a...) }
+func (c *ConsoleUI) Green(a ...interface{}) string   { return c.Text(green(a...)) }
+func (c *ConsoleUI) Blue(a ...interface{}) string    { return c.Text(blue(a...)) }
+func (c *ConsoleUI) Yellow(a ...interface{}) string  { return c.Text(yellow(a...)) }
+func (c *ConsoleUI) Red(a ...interface{}) string     { return c.Text(red(a...)) }
+func (c *ConsoleUI) Cyan(a ...interface{}) string    { return c.Text(cyan(a...)) }
+func (c *ConsoleUI) Magenta(a ...interface{}) string { return c.Text(magenta(a...)) }
@@ -0,0 +1,58 @@
+package ui
+
+import (
+	"os"
+	"strings"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+const (
+	defaultTerminalWidth = 100 // Used when stdout is not a terminal
+	ellipsis             = "…"
+)
+
+// DisplayWidth returns the number of terminal cells needed to display s,
+// accounting for East Asian wide characters, emoji and zero-width runes.
+func DisplayWidth(s string) int {
+	return runewidth.StringWidth(s)
+}
+
+// Truncate shortens s so that it occupies at most width cells, appending an
+// ellipsis when anything was cut. It never splits a codepoint.
+func Truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	if DisplayWidth(s) <= width {
+		return s
+	}
+	return runewidth.Truncate(s, width, ellipsis)
+}
+
+// TruncateLine collapses s to its first line before truncating it, marking the
+// cut with an ellipsis if there were further lines.
+func TruncateLine(s string, width int) string {
+	first, _, multi := strings.Cut(s, "\n")
+	if multi {
+		first = strings.TrimRight(first, " \t\r") + " " + ellipsis
+	}
+	return Truncate(first, width)
+}
+
+// PadRight pads s with spaces to exactly width cells, truncating it if needed.
+// Useful for aligning table columns that contain wide characters.
+func PadRight(s string, width int) string {
+	s = Truncate(s, width)
+	return s + strings.Repeat(" ", width-DisplayWidth(s))
+}
+
+// TerminalWidth returns the width of the attached terminal, or a sensible
+// default when output is redirected.
+func TerminalWidth() int {
+	if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+		return w
+	}
+	return defaultTerminalWidth
+}
@@ -0,0 +1,297 @@
+package undo
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// wrappers run the command that follows them.
+var wrappers = map[string]bool{"sudo": true, "doas": true, "env": true, "nohup": true, "time": true, "command": true, "exec": true, "nice": true}
+
+// valueFlags are the flags of commands that take the next word as their
+// value, which is then not a file.
+var valueFlags = map[string][]string{
+	"cp":       {"-t", "-S", "--target-directory", "--suffix"},
+	"mv":       {"-t", "-S", "--target-directory", "--suffix"},
+	"install":  {"-t", "-m", "-o", "-g", "-S", "--target-directory", "--mode", "--owner", "--group", "--suffix"},
+	"touch":    {"-d", "-t", "-r", "--date", "--reference"},
+	"truncate": {"-s", "-r", "--size", "--reference"},
+	"shred":    {"-n", "-s", "--iterations", "--size"},
+	"sed":      {"-e", "-f", "-l", "--expression", "--file"},
+	"perl":     {"-e", "-E", "-I", "-M", "-m"},
+	"ruby":     {"-e", "-I", "-r"},
+}
+
+// Targets returns the files and directories inside workdir that a shell
+// command looks like it writes, moves or removes, as absolute paths: the
+// targets of output redirections and tee, the operands of rm, unlink, shred,
+// truncate, touch and chmod, the destinations of cp and install, both ends of
+// mv, the files sed, perl and ruby edit in place and dd's of=. Like the
+// policy's patterns they are heuristics; words with variables or command
+// substitutions are left out, and globs are expanded in workdir.
+func Targets(command, workdir string) []string {
+	root, err := filepath.Abs(workdir)
+	if err != nil {
+		return nil
+	}
+	var candidates []string
+	var heredoc string
+	for _, line := range strings.Split(command, "\n") {
+		if heredoc != "" {
+			if strings.TrimSpace(line) == heredoc {
+				heredoc = ""
+			}
+			continue
+		}
+		var words []string
+		toks := lex(line)
+		for i := 0; i < len(toks); i++ {
+			t := toks[i]
+			if !t.op {
+				words = append(words, t.text)
+				continue
+			}
+			if !strings.ContainsAny(t.text, "<>") {
+				candidates = append(candidates, operands(words, root)...)
+				words = nil
+				continue
+			}
+			// The word after a redirection is its target, not an operand
+			next := ""
+			if i+1 < len(toks) && !toks[i+1].op {
+				i++
+				next = toks[i].text
+			}
+			switch {
+			case strings.HasPrefix(t.text, "<<") && t.text != "<<<":
+				heredoc = strings.TrimPrefix(next, "-")
+			case strings.HasSuffix(t.text, ">&"), strings.HasPrefix(t.text, "<"):
+				// A file descriptor or an input
+			default:
+				candidates = append(candidates, next)
+			}
+		}
+		candidates = append(candidates, operands(words, root)...)
+	}
+
+	var targets []string
+	seen := map[string]bool{}
+	for _, c := range candidates {
+		for _, path := range resolve(c, root) {
+			rel, err := filepath.Rel(root, path)
+			if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) || seen[path] {
+				continue // Only files in the working directory
+			}
+			seen[path] = true
+			targets = append(targets, path)
+		}
+	}
+	return targets
+}
+
+// resolve returns the absolute paths word names in root: none when it has a
+// variable or command substitution or is a device, the matches when it is a
+// glob.
+func resolve(word, root string) []string {
+	if word == "" || word == "-" || strings.ContainsAny(word, "$`") || strings.HasPrefix(word, "/dev/") {
+		return nil
+	}
+	if rest, ok := strings.CutPrefix(word, "~/"); ok {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		word = filepath.Join(home, rest)
+	}
+	path := word
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	if strings.ContainsAny(word, "*?[") {
+		matches, _ := filepath.Glob(path)
+		return matches
+	}
+	return []string{filepath.Clean(path)}
+}
+
+// operands returns the files the simple command words changes.
+func operands(words []string, root string) []string {
+	for len(words) > 0 && (wrappers[words[0]] || strings.Contains(words[0], "=") && !strings.HasPrefix(words[0], "-")) {
+		words = words[1:]
+		for len(words) > 0 && strings.HasPrefix(words[0], "-") {
+			words = words[1:]
+		}
+	}
+	if len(words) == 0 {
+		return nil
+	}
+	name := filepath.Base(words[0])
+	args, flags := split(words[1:], valueFlags[name])
+	switch name {
+	case "rm", "unlink", "shred", "truncate", "touch", "tee":
+		return args
+	case "chmod":
+		if flags["--reference"] == "" && len(args) > 0 {
+			return args[1:] // The mode
+		}
+		return args
+	case "sed", "perl", "ruby":
+		if !inPlace(words[1:]) {
+			return nil
+		}
+		script := flags["-e"] + flags["--expression"] + flags["-f"] + flags["--file"] + flags["-E"]
+		if script == "" && len(args) > 0 {
+			return args[1:] // The script
+		}
+		return args
+	case "cp", "install":
+		sources, dest := destination(args, flags)
+		return destinations(sources, dest, root)
+	case "mv":
+		sources, dest := destination(args, flags)
+		return append(sources, destinations(sources, dest, root)...)
+	case "dd":
+		for _, a := range args {
+			if out, ok := strings.CutPrefix(a, "of="); ok {
+				return []string{out}
+			}
+		}
+	}
+	return nil
+}
+
+// split separates words into operands and flags, with the values of those
+// in valued. Words after "--" are operands.
+func split(words []string, valued []string) (args []string, flags map[string]string) {
+	flags = map[string]string{}
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case w == "--":
+			return append(args, words[i+1:]...), flags
+		case strings.HasPrefix(w, "-") && len(w) > 1:
+			name, value, ok := strings.Cut(w, "=")
+			if !ok && i+1 < len(words) && slices.Contains(valued, name) {
+				i++
+				value = words[i]
+			}
+			flags[name] = value + " "
+		case w != "":
+			args = append(args, w)
+		}
+	}
+	return args, flags
+}
+
+// inPlace reports whether words have sed's, perl's or ruby's flag to edit
+// files in place, such as -i, -i.bak, -pi or --in-place.
+func inPlace(words []string) bool {
+	for _, w := range words {
+		if w == "--in-place" || strings.HasPrefix(w, "--in-place=") {
+			return true
+		}
+		if strings.HasPrefix(w, "-") && !strings.HasPrefix(w, "--") {
+			flags, _, _ := strings.Cut(w[1:], ".")
+			if strings.Contains(flags, "i") && !strings.ContainsAny(flags, "eE") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// destination splits the operands of cp, mv or install into the sources and
+// the destination, given by -t or last.
+func destination(args []string, flags map[string]string) ([]string, string) {
+	if dir := strings.TrimSpace(flags["-t"] + flags["--target-directory"]); dir != "" {
+		return args, dir + string(filepath.Separator)
+	}
+	if len(args) < 2 {
+		return nil, ""
+	}
+	return args[:len(args)-1], args[len(args)-1]
+}
+
+// destinations returns what copying or moving sources to dest writes: dest,
+// or the sources' names in it when it is a directory.
+func destinations(sources []string, dest, root string) []string {
+	if dest == "" {
+		return nil
+	}
+	path := dest
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	info, err := os.Stat(path)
+	if (err != nil || !info.IsDir()) && !strings.HasSuffix(dest, string(filepath.Separator)) {
+		return []string{dest}
+	}
+	var targets []string
+	for _, s := range sources {
+		targets = append(targets, filepath.Join(dest, filepath.Base(s)))
+	}
+	return targets
+}
+
+// token is a word of a line of shell, unquoted, or an operator.
+type token struct {
+	text string
+	op   bool
+}
+
+// lex splits a line of shell into words and operators (;, &&, |, >, 2>&,
+// <<, ...). Quotes are removed from words; a comment ends the line.
+func lex(line string) []token {
+	var toks []token
+	var word strings.Builder
+	inWord := false
+	flush := func() {
+		if inWord {
+			toks = append(toks, token{text: word.String()})
+		}
+		word.Reset()
+		inWord = false
+	}
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(line[i+1:], c)
+			if end < 0 {
+				end = len(line) - i - 1
+			}
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case c == '\\' && i+1 < len(line):
+			word.WriteByte(line[i+1])
+			inWord = true
+			i++
+		case c == ' ' || c == '\t':
+			flush()
+		case c == '#' && !inWord:
+			flush()
+			return toks
+		case strings.IndexByte(";&|()<>", c) >= 0:
+			// The file descriptor of a redirection, as in 2>, is not a word
+			if inWord && (c == '>' || c == '<') && strings.Trim(word.String(), "0123456789") == "" {
+				word.Reset()
+				inWord = false
+			}
+			flush()
+			j := i
+			for j < len(line) && strings.IndexByte(";&|()<>", line[j]) >= 0 {
+				j++
+			}
+			toks = append(toks, token{text: line[i:j], op: true})
+			i = j - 1
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	flush()
+	return toks
+}
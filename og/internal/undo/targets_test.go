@@ -0,0 +1,60 @@
+package undo
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTargets(t *testing.T) {
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "dir"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a.log", "b.log", "c.txt"} {
+		if err := os.WriteFile(filepath.Join(root, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	tests := []struct {
+		command string
+		want    []string // Relative to root
+	}{
+		{"ls -la", nil},
+		{"echo hi > out.txt", []string{"out.txt"}},
+		{"echo hi >> 'my file.txt' 2>&1", []string{"my file.txt"}},
+		{"make 2> err.log", []string{"err.log"}},
+		{"cat < c.txt", nil},
+		{"echo hi | tee -a x.txt y.txt", []string{"x.txt", "y.txt"}},
+		{"rm -rf build dist", []string{"build", "dist"}},
+		{"rm *.log", []string{"a.log", "b.log"}},
+		{"sudo rm -f c.txt", []string{"c.txt"}},
+		{"chmod 644 c.txt", []string{"c.txt"}},
+		{"touch -d yesterday c.txt", []string{"c.txt"}},
+		{"cp c.txt d.txt", []string{"d.txt"}},
+		{"cp a.log b.log dir", []string{"dir/a.log", "dir/b.log"}},
+		{"cp -t dir c.txt", []string{"dir/c.txt"}},
+		{"mv c.txt e.txt", []string{"c.txt", "e.txt"}},
+		{"sed -i 's/a/b/' c.txt", []string{"c.txt"}},
+		{"sed -e 's/a/b/' -i.bak c.txt", []string{"c.txt"}},
+		{"sed 's/a/b/' c.txt", nil},
+		{"perl -pi -e 's/a/b/' c.txt", []string{"c.txt"}},
+		{"dd if=/dev/zero of=disk.img bs=1M", []string{"disk.img"}},
+		{"echo x > /dev/null", nil},
+		{"rm $FILE", nil},
+		{"rm ../outside /tmp/elsewhere", nil},
+		{"cat <<EOF > f.txt\nrm g.txt\nEOF\nrm h.txt", []string{"f.txt", "h.txt"}},
+		{"rm i.txt # and rm j.txt", []string{"i.txt"}},
+		{"ls && rm k.txt; touch l.txt", []string{"k.txt", "l.txt"}},
+	}
+	for _, tt := range tests {
+		var want []string
+		for _, w := range tt.want {
+			want = append(want, filepath.Join(root, w))
+		}
+		if got := Targets(tt.command, root); !slices.Equal(got, want) {
+			t.Errorf("Targets(%q) = %v, want %v", tt.command, got, want)
+		}
+	}
+}
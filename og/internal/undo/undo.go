@@ -0,0 +1,324 @@
+// Package undo backs up the files a session's steps are about to change, in
+// the session's temp directory, so that the changes can be rolled back when
+// the session ends or later with og undo. Only the first backup of a file is
+// kept: undoing restores files as the session found them.
+package undo
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DirName is the directory of a session's temp directory that holds its
+// backups.
+const DirName = "undo"
+
+// manifestName is the file of the backup directory that lists its backups,
+// one JSON entry per line.
+const manifestName = "manifest.jsonl"
+
+// Dir returns where the backups of the session whose temp directory is
+// tempDir are kept.
+func Dir(tempDir string) string {
+	return filepath.Join(tempDir, DirName)
+}
+
+// Entry is a file a step was about to change, as it was before.
+type Entry struct {
+	Path   string      `json:"path"`             // Absolute
+	Backup string      `json:"backup,omitempty"` // Name of its copy in the backup directory, for regular files
+	Link   string      `json:"link,omitempty"`   // Target, for symbolic links
+	Mode   fs.FileMode `json:"mode,omitempty"`
+	SHA256 string      `json:"sha256,omitempty"` // Of the copy
+	Size   int64       `json:"size,omitempty"`
+	Absent bool        `json:"absent,omitempty"` // The file did not exist; undoing removes it
+	Tool   string      `json:"tool"`             // The tool of the step
+	TS     string      `json:"ts"`
+}
+
+// Change describes how the file of e differs from its backup: "changed",
+// "deleted" or "created", or "" when it does not.
+func (e Entry) Change() string {
+	info, err := os.Lstat(e.Path)
+	switch {
+	case e.Absent && err != nil:
+		return ""
+	case e.Absent:
+		return "created"
+	case err != nil:
+		return "deleted"
+	case e.Link != "":
+		if target, err := os.Readlink(e.Path); err != nil || target != e.Link {
+			return "changed"
+		}
+	case !info.Mode().IsRegular() || info.Mode().Perm() != e.Mode.Perm() || fingerprint(e.Path) != e.SHA256:
+		return "changed"
+	}
+	return ""
+}
+
+// Journal backs up files before steps change them, and lists them in the
+// manifest of its directory. A nil *Journal backs up nothing.
+type Journal struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	used     int64
+	seen     map[string]bool
+	entries  []Entry
+}
+
+// Open opens the backup directory dir, keeping the backups an earlier run of
+// the session made there. Backups are not made once they take maxBytes; 0
+// leaves them unbounded.
+func Open(dir string, maxBytes int64) (*Journal, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create backup directory %s: %w", dir, err)
+	}
+	entries, err := Load(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	j := &Journal{dir: dir, maxBytes: maxBytes, seen: map[string]bool{}, entries: entries}
+	for _, e := range entries {
+		j.seen[e.Path] = true
+		j.used += e.Size
+	}
+	return j, nil
+}
+
+// Snapshot backs up paths, and the files inside those that are directories,
+// before a step of tool changes them. Paths backed up earlier in the session
+// are left as they were backed up. It returns the files it could not back up,
+// because they are neither regular files nor symbolic links or would take the
+// backups past their bound, whose changes cannot be undone.
+func (j *Journal) Snapshot(tool string, paths []string) (skipped []string, err error) {
+	if j == nil {
+		return nil, nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, path := range paths {
+		err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if j.seen[p] {
+				if d != nil && d.IsDir() {
+					return filepath.SkipDir // Made by the session; undoing removes it
+				}
+				return nil
+			}
+			if os.IsNotExist(err) && p == path {
+				return j.add(Entry{Path: p, Absent: true, Tool: tool})
+			} else if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				return nil
+			}
+			ok, err := j.backUp(tool, p, d)
+			if err == nil && !ok {
+				skipped = append(skipped, p)
+			}
+			return err
+		})
+		if err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// backUp copies the file p to the backup directory, or records where the
+// symbolic link p points. It reports false when it leaves p out.
+func (j *Journal) backUp(tool, p string, d fs.DirEntry) (bool, error) {
+	info, err := d.Info()
+	if err != nil {
+		return false, err
+	}
+	e := Entry{Path: p, Mode: info.Mode(), Tool: tool}
+	switch {
+	case info.Mode()&fs.ModeSymlink != 0:
+		if e.Link, err = os.Readlink(p); err != nil {
+			return false, err
+		}
+		return true, j.add(e)
+	case !info.Mode().IsRegular():
+		j.seen[p] = true
+		return false, nil
+	case j.maxBytes > 0 && j.used+info.Size() > j.maxBytes:
+		j.seen[p] = true
+		return false, nil
+	}
+	e.Backup = strconv.Itoa(len(j.entries) + 1)
+	if e.SHA256, err = copyFile(p, filepath.Join(j.dir, e.Backup), 0o600); err != nil {
+		return false, fmt.Errorf("failed to back up %s: %w", p, err)
+	}
+	e.Size = info.Size()
+	j.used += e.Size
+	return true, j.add(e)
+}
+
+// add records e in the manifest.
+func (j *Journal) add(e Entry) error {
+	e.TS = time.Now().UTC().Format(time.RFC3339)
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(j.dir, manifestName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	j.seen[e.Path] = true
+	j.entries = append(j.entries, e)
+	return nil
+}
+
+// Changes returns the backed-up files that changed since.
+func (j *Journal) Changes() []Entry {
+	if j == nil {
+		return nil
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return Changes(j.entries)
+}
+
+// Dir returns the backup directory.
+func (j *Journal) Dir() string {
+	return j.dir
+}
+
+// Load reads the manifest of the backup directory dir. It returns an error
+// that os.IsNotExist recognizes when there are no backups.
+func Load(dir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var entries []Entry
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil || seen[e.Path] {
+			continue // A line cut short by a crash
+		}
+		seen[e.Path] = true
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Changes returns the entries whose files changed since they were backed up.
+func Changes(entries []Entry) []Entry {
+	var changed []Entry
+	for _, e := range entries {
+		if e.Change() != "" {
+			changed = append(changed, e)
+		}
+	}
+	return changed
+}
+
+// Restore puts the files of entries, backed up in dir, back as they were:
+// it copies back files that existed, re-creates links and removes what did
+// not exist. Entries are restored newest first.
+func Restore(dir string, entries []Entry) error {
+	for i := len(entries) - 1; i >= 0; i-- {
+		if err := restore(dir, entries[i]); err != nil {
+			return fmt.Errorf("failed to restore %s: %w", entries[i].Path, err)
+		}
+	}
+	return nil
+}
+
+func restore(dir string, e Entry) error {
+	if e.Absent {
+		return os.RemoveAll(e.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(e.Path), 0o755); err != nil {
+		return err
+	}
+	if info, err := os.Lstat(e.Path); err == nil && (info.IsDir() || e.Link != "" || info.Mode()&fs.ModeSymlink != 0) {
+		if err := os.RemoveAll(e.Path); err != nil {
+			return err
+		}
+	}
+	if e.Link != "" {
+		return os.Symlink(e.Link, e.Path)
+	}
+	if _, err := copyFile(filepath.Join(dir, e.Backup), e.Path, e.Mode.Perm()); err != nil {
+		return err
+	}
+	return os.Chmod(e.Path, e.Mode.Perm())
+}
+
+// Prune removes what the session's temp directory tempDir holds besides its
+// backups.
+func Prune(tempDir string) error {
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.Name() == DirName {
+			continue
+		}
+		if err := os.RemoveAll(filepath.Join(tempDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// copyFile copies src to dst, created with perm, and returns the SHA-256 of
+// what it copied.
+func copyFile(src, dst string, perm fs.FileMode) (string, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return "", err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(out, h), in); err != nil {
+		out.Close()
+		return "", err
+	}
+	if err := out.Close(); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// fingerprint returns the SHA-256 of the file path, or "" when it cannot be
+// read.
+func fingerprint(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
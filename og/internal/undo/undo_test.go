@@ -0,0 +1,258 @@
+package undo
+
+import (
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// writeFile writes content to root/name with perm, creating its directory.
+func writeFile(t *testing.T, root, name, content string, perm os.FileMode) {
+	t.Helper()
+	path := filepath.Join(root, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	tests := []struct {
+		name     string
+		before   func(t *testing.T, root string) // The files the session finds
+		snapshot []string                        // Backed up before the step
+		step     func(t *testing.T, root string) // What the step does
+		changes  map[string]string               // Entry.Change of each changed file
+	}{
+		{
+			name:     "edited file",
+			before:   func(t *testing.T, root string) { writeFile(t, root, "a.txt", "old", 0o644) },
+			snapshot: []string{"a.txt"},
+			step:     func(t *testing.T, root string) { writeFile(t, root, "a.txt", "new", 0o644) },
+			changes:  map[string]string{"a.txt": "changed"},
+		},
+		{
+			name:     "changed mode",
+			before:   func(t *testing.T, root string) { writeFile(t, root, "run.sh", "echo", 0o644) },
+			snapshot: []string{"run.sh"},
+			step:     func(t *testing.T, root string) { os.Chmod(filepath.Join(root, "run.sh"), 0o755) },
+			changes:  map[string]string{"run.sh": "changed"},
+		},
+		{
+			name:     "deleted file",
+			before:   func(t *testing.T, root string) { writeFile(t, root, "a.txt", "old", 0o600) },
+			snapshot: []string{"a.txt"},
+			step:     func(t *testing.T, root string) { os.Remove(filepath.Join(root, "a.txt")) },
+			changes:  map[string]string{"a.txt": "deleted"},
+		},
+		{
+			name:     "created file",
+			before:   func(t *testing.T, root string) {},
+			snapshot: []string{"new.txt"},
+			step:     func(t *testing.T, root string) { writeFile(t, root, "new.txt", "new", 0o644) },
+			changes:  map[string]string{"new.txt": "created"},
+		},
+		{
+			name: "removed directory",
+			before: func(t *testing.T, root string) {
+				writeFile(t, root, "build/a.o", "a", 0o644)
+				writeFile(t, root, "build/sub/b.o", "b", 0o644)
+			},
+			snapshot: []string{"build"},
+			step:     func(t *testing.T, root string) { os.RemoveAll(filepath.Join(root, "build")) },
+			changes:  map[string]string{"build/a.o": "deleted", "build/sub/b.o": "deleted"},
+		},
+		{
+			name: "retargeted link",
+			before: func(t *testing.T, root string) {
+				if err := os.Symlink("a.txt", filepath.Join(root, "link")); err != nil {
+					t.Fatal(err)
+				}
+			},
+			snapshot: []string{"link"},
+			step: func(t *testing.T, root string) {
+				os.Remove(filepath.Join(root, "link"))
+				os.Symlink("b.txt", filepath.Join(root, "link"))
+			},
+			changes: map[string]string{"link": "changed"},
+		},
+		{
+			name:     "untouched file",
+			before:   func(t *testing.T, root string) { writeFile(t, root, "a.txt", "old", 0o644) },
+			snapshot: []string{"a.txt"},
+			step:     func(t *testing.T, root string) {},
+			changes:  map[string]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root, dir := t.TempDir(), Dir(t.TempDir())
+			tt.before(t, root)
+			before := tree(t, root)
+
+			j, err := Open(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var paths []string
+			for _, p := range tt.snapshot {
+				paths = append(paths, filepath.Join(root, p))
+			}
+			if skipped, err := j.Snapshot("shell_tool", paths); err != nil || len(skipped) > 0 {
+				t.Fatalf("Snapshot() = %v, %v", skipped, err)
+			}
+			tt.step(t, root)
+
+			changes := map[string]string{}
+			for _, e := range j.Changes() {
+				rel, _ := filepath.Rel(root, e.Path)
+				changes[filepath.ToSlash(rel)] = e.Change()
+			}
+			if !maps.Equal(changes, tt.changes) {
+				t.Errorf("changes = %v, want %v", changes, tt.changes)
+			}
+
+			// Undoing later, as og undo does, reads the manifest back
+			entries, err := Load(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := Restore(dir, entries); err != nil {
+				t.Fatal(err)
+			}
+			if after := tree(t, root); !maps.Equal(after, before) {
+				t.Errorf("restored %v, want %v", after, before)
+			}
+			if changed := Changes(entries); len(changed) > 0 {
+				t.Errorf("%d files still changed after Restore", len(changed))
+			}
+		})
+	}
+}
+
+func TestSnapshotKeepsFirstBackup(t *testing.T) {
+	root, dir := t.TempDir(), Dir(t.TempDir())
+	writeFile(t, root, "a.txt", "first", 0o644)
+	path := filepath.Join(root, "a.txt")
+	j, err := Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Snapshot("shell_tool", []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, root, "a.txt", "second", 0o644)
+
+	// A resumed session opens the journal again and backs the file up again
+	j, err = Open(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Snapshot("edit_file_tool", []string{path}); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, root, "a.txt", "third", 0o644)
+
+	entries, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Tool != "shell_tool" {
+		t.Fatalf("entries = %+v, want the first backup only", entries)
+	}
+	if err := Restore(dir, entries); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(path); string(got) != "first" {
+		t.Errorf("restored %q, want %q", got, "first")
+	}
+}
+
+func TestSnapshotBound(t *testing.T) {
+	root, dir := t.TempDir(), Dir(t.TempDir())
+	writeFile(t, root, "small.txt", "1234", 0o644)
+	writeFile(t, root, "large.txt", "123456789", 0o644)
+	j, err := Open(dir, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	skipped, err := j.Snapshot("shell_tool", []string{filepath.Join(root, "small.txt"), filepath.Join(root, "large.txt")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(root, "large.txt")}; !slices.Equal(skipped, want) {
+		t.Errorf("skipped = %v, want %v", skipped, want)
+	}
+}
+
+func TestLoadSkipsCutLines(t *testing.T) {
+	dir := t.TempDir()
+	manifest := `{"path":"/a","backup":"1","tool":"shell_tool","ts":"2026-01-01T00:00:00Z"}` + "\n" +
+		`{"path":"/a","backup":"2","tool":"shell_tool","ts":"2026-01-01T00:00:01Z"}` + "\n" +
+		`{"path":"/b","bac`
+	if err := os.WriteFile(filepath.Join(dir, manifestName), []byte(manifest), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Backup != "1" {
+		t.Errorf("entries = %+v, want the first backup of /a only", entries)
+	}
+	if _, err := Load(t.TempDir()); !os.IsNotExist(err) {
+		t.Errorf("Load of a directory without backups: %v, want a not-exist error", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	tempDir := t.TempDir()
+	writeFile(t, tempDir, "output/step1.txt", "x", 0o644)
+	writeFile(t, tempDir, filepath.Join(DirName, manifestName), "", 0o600)
+	if err := Prune(tempDir); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != DirName {
+		t.Errorf("left %v, want only %s", entries, DirName)
+	}
+}
+
+// tree describes the files under root: the content of regular files with
+// their mode, and the target of links.
+func tree(t *testing.T, root string) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	err := filepath.WalkDir(root, func(p string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(root, p)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(p)
+			files[rel] = "-> " + target
+			return err
+		}
+		content, err := os.ReadFile(p)
+		files[rel] = info.Mode().String() + " " + string(content)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
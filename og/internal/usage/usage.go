@@ -0,0 +1,139 @@
+// Package usage accounts for the tokens a session's models consume and what
+// they cost according to the configured prices.
+package usage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+)
+
+// Tokens is a count of prompt and completion tokens, and of the model calls
+// that consumed them.
+type Tokens struct {
+	Prompt     int64
+	Completion int64
+	Calls      int64
+}
+
+func (t *Tokens) add(prompt, completion, calls int64) {
+	t.Prompt += prompt
+	t.Completion += completion
+	t.Calls += calls
+}
+
+// Tally accumulates token counts per agent role (planner, executor, auditor) and
+// per model. The zero value is ready to use.
+type Tally struct {
+	byRole  map[string]Tokens
+	byModel map[string]Tokens
+	roles   []string // In the order they were first seen
+}
+
+// Add records the tokens of one model call.
+func (t *Tally) Add(role, model string, prompt, completion int64) {
+	if t.byRole == nil {
+		t.byRole = map[string]Tokens{}
+		t.byModel = map[string]Tokens{}
+	}
+	if _, ok := t.byRole[role]; !ok {
+		t.roles = append(t.roles, role)
+	}
+	r := t.byRole[role]
+	r.add(prompt, completion, 1)
+	t.byRole[role] = r
+	m := t.byModel[model]
+	m.add(prompt, completion, 1)
+	t.byModel[model] = m
+}
+
+// Empty reports whether no model call was recorded.
+func (t *Tally) Empty() bool {
+	return len(t.roles) == 0
+}
+
+// Total returns the tokens of every call.
+func (t *Tally) Total() Tokens {
+	var total Tokens
+	for _, r := range t.byRole {
+		total.add(r.Prompt, r.Completion, r.Calls)
+	}
+	return total
+}
+
+// Cost prices the recorded tokens. It also returns the models that consumed
+// tokens but have no price, whose tokens are not included in the cost.
+func (t *Tally) Cost(pricing map[string]config.PricingCfg) (float64, []string) {
+	var cost float64
+	var unpriced []string
+	for model, tokens := range t.byModel {
+		price, ok := pricing[model]
+		if !ok {
+			unpriced = append(unpriced, model)
+			continue
+		}
+		cost += float64(tokens.Prompt)/1e6*price.InputPerMillion + float64(tokens.Completion)/1e6*price.OutputPerMillion
+	}
+	sort.Strings(unpriced)
+	return cost, unpriced
+}
+
+// Usage returns the totals that are persisted with the session's history.
+func (t *Tally) Usage(pricing map[string]config.PricingCfg) history.Usage {
+	total := t.Total()
+	cost, _ := t.Cost(pricing)
+	return history.Usage{PromptTokens: total.Prompt, CompletionTokens: total.Completion, Cost: cost}
+}
+
+// Format describes the tally on one line, e.g.
+// "planner (1 call) 1.2k in / 310 out, executor (6 calls) 8.4k in / 950 out;
+// total 9.6k in / 1.3k out; cost 0.0185".
+func (t *Tally) Format(pricing map[string]config.PricingCfg) string {
+	parts := make([]string, len(t.roles))
+	for i, role := range t.roles {
+		r := t.byRole[role]
+		calls := "calls"
+		if r.Calls == 1 {
+			calls = "call"
+		}
+		parts[i] = fmt.Sprintf("%s (%d %s) %s", role, r.Calls, calls, formatTokens(r))
+	}
+	line := strings.Join(parts, ", ") + "; total " + formatTokens(t.Total())
+	cost, unpriced := t.Cost(pricing)
+	switch {
+	case len(unpriced) == len(t.byModel):
+		line += "; no pricing for " + strings.Join(unpriced, ", ")
+	case len(unpriced) > 0:
+		line += fmt.Sprintf("; cost %s (excluding %s)", FormatCost(cost), strings.Join(unpriced, ", "))
+	default:
+		line += "; cost " + FormatCost(cost)
+	}
+	return line
+}
+
+func formatTokens(t Tokens) string {
+	return FormatCount(t.Prompt) + " in / " + FormatCount(t.Completion) + " out"
+}
+
+// FormatCount abbreviates a token count, e.g. 950, 1.2k or 3.4M.
+func FormatCount(n int64) string {
+	switch {
+	case n >= 1_000_000:
+		return fmt.Sprintf("%.1fM", float64(n)/1e6)
+	case n >= 1_000:
+		return fmt.Sprintf("%.1fk", float64(n)/1e3)
+	default:
+		return fmt.Sprint(n)
+	}
+}
+
+// FormatCost renders a cost with enough precision for cheap sessions.
+func FormatCost(cost float64) string {
+	if cost > 0 && cost < 1 {
+		return fmt.Sprintf("%.4f", cost)
+	}
+	return fmt.Sprintf("%.2f", cost)
+}
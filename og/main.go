@@ -3,12 +3,17 @@ package main
 import (
 	"embed"
 	"flag"
+	"fmt"
 	"os"
 	"path/filepath"
-	"strings"
+	"time"
 
+	"github.com/robbiemu/original_gangster/og/internal/agent"
 	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/limits"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
 	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/store"
 	"github.com/robbiemu/original_gangster/og/internal/ui"
 )
 
@@ -21,11 +26,26 @@ func main() {
 
 	helpFlag := flag.Bool("help", false, "show help message")
 	hFlag := flag.Bool("h", false, "show help message (shorthand)")
-	verbosityStr := flag.String("verbosity", "warn", "set log verbosity level (debug, info, warn, none)")
+	verbosityStr := flag.String("verbosity", "", "set log verbosity level (debug, info, warn, none), overriding general.verbosity_level")
+	versionFlag := flag.Bool("version", false, "print version and build information")
+	sandboxCopy := flag.Bool("sandbox-copy", false, "run the session in a throwaway copy of the working directory and review its changes before applying them")
+	strictConfig := flag.Bool("strict-config", false, "refuse to run with a config that has warnings, such as deprecated keys")
+	readOnly := flag.Bool("read-only", false, "deny every step that writes, deletes or changes state elsewhere, and tell the agent so")
+	noStdin := flag.Bool("no-stdin", false, "ignore piped input instead of attaching it to the prompt as context")
+	noLastCommand := flag.Bool("no-last-command", false, "do not attach the last shell command exported by `og hook` to the prompt")
+	recordPath := flag.String("record", "", "write every message exchanged with the agent to a recording at this path")
+	replayPath := flag.String("replay", "", "play back a recording of og --record instead of running the agent")
+	promptFlag := flag.Bool("prompt", false, "treat every argument as the prompt, even one starting with a subcommand's name, as -- does")
+	pFlag := flag.Bool("p", false, "treat every argument as the prompt (shorthand)")
+	asciiFlag := flag.Bool("ascii", false, "print ASCII tags such as [OK] and [WARN] instead of emoji, as ui.ascii = true does")
+	var attachFiles, attachDirs pathList
+	flag.Var(&attachFiles, "file", "attach the contents of the files matching a glob to the prompt (repeatable)")
+	flag.Var(&attachDirs, "dir", "attach the listing of a directory to the prompt (repeatable)")
 
 	// Set the custom help function to use the UI component
 	flag.Usage = consoleUI.PrintHelp
 	flag.Parse()
+	consoleUI.SetASCII(*asciiFlag)
 
 	// If help is requested, show help and exit
 	if *helpFlag || *hFlag {
@@ -35,10 +55,58 @@ func main() {
 
 	args := flag.Args() // Everything after flags
 
+	// The subcommand, such as history in og history list. A line that does not
+	// parse as one, as og history of this repo does not, is a prompt, and so is
+	// every line after -p, --prompt or --.
+	command := ""
+	if len(args) >= 1 && !*promptFlag && !*pFlag && !afterTerminator() &&
+		(args[0] == limits.Command || args[0] == "__complete" || subcommandLine(args)) {
+		command = args[0]
+	}
+
+	// og __limit runs a step or the agent under [limits]; see the limits package
+	if command == limits.Command {
+		err := limits.Run(args[1:])
+		fmt.Fprintf(os.Stderr, "og %s: %v\n", limits.Command, err)
+		os.Exit(126)
+	}
+
+	// Handle shell completion before loading the config, which may not exist yet
+	if command != "" && isCompletionCommand(args) {
+		if command == "completion" {
+			os.Exit(runCompletion(consoleUI, args[1:]))
+		}
+		cfg, _, _ := config.LoadConfig() // Only used to complete session hashes and database names
+		os.Exit(runComplete(cfg, args[1:]))
+	}
+
+	// Handle "og hook" before loading the config, which may not exist yet
+	if command == "hook" {
+		os.Exit(runHook(consoleUI, args[1:]))
+	}
+
+	// Handle "og version" before loading the config, which may not exist yet
+	if *versionFlag || command == "version" {
+		if command == "version" {
+			args = args[1:]
+		}
+		cfg, _, _ := config.LoadConfig() // Only used to check the agent, when there is one
+		os.Exit(runVersion(consoleUI, cfg, args))
+	}
+
+	// Handle "og config diff" and "og prompts diff" before loading the config, which may be invalid
+	if command == "config" || command == "prompts" {
+		os.Exit(subcommands[command](consoleUI, nil, args[1:]))
+	}
+
 	// Handle "og init" command
-	if len(args) >= 1 && args[0] == "init" {
+	if command == "init" {
 		if path, err := config.GetConfigPath(); err == nil {
-			if err := config.SaveDefaultConfig(path, embeddedPromptsFS); err != nil {
+			backup, err := config.SaveDefaultConfig(path, embeddedPromptsFS)
+			if backup != "" {
+				consoleUI.PrintColored(consoleUI.Yellow, "Your previous config was backed up to %s (`og config rollback` restores it).\n", consoleUI.Cyan(backup))
+			}
+			if err != nil {
 				consoleUI.PrintColored(consoleUI.Red, "Failed to write default config: %v\n", err)
 				os.Exit(1)
 			}
@@ -56,20 +124,58 @@ func main() {
 	}
 
 	// Load configuration
-	cfg, err := config.LoadConfig()
+	cfg, warnings, err := config.LoadConfig()
 	if err != nil {
 		consoleUI.PrintColored(consoleUI.Red, "Failed to load config: %v\n", err)
 		consoleUI.PrintColored(consoleUI.Yellow, "Run `og init` first to create a default configuration.\n")
 		os.Exit(1)
 	}
+	if !printConfigWarnings(consoleUI, warnings, *strictConfig) {
+		os.Exit(1)
+	}
+
+	consoleUI.SetInlineMaxBytes(cfg.Output.InlineMaxBytes)
+	consoleUI.SetTheme(cfg.UI.Theme.Theme())
+	consoleUI.SetASCII(cfg.UI.ASCII || *asciiFlag)
+	consoleUI.SetPromptTimeout(time.Duration(cfg.General.ApprovalTimeoutSeconds)*time.Second, cfg.General.ApprovalTimeoutDefault == "approve")
 
-	// Override config verbosity setting if CLI flag is present
-	parsedVerbosityLevel, err := ui.ParseLogLevel(*verbosityStr)
+	redactor, err := redact.New(cfg.Redaction)
 	if err != nil {
-		consoleUI.PrintColored(consoleUI.Yellow, "%s\n", err.Error())
-		// Continue with default from config if parsing fails, or 'info' if config also failed.
-	} else {
-		cfg.General.VerbosityLevel = parsedVerbosityLevel
+		consoleUI.PrintColored(consoleUI.Red, "Invalid [redaction] config: %v\n", err)
+		os.Exit(1)
+	}
+	consoleUI.SetRedactor(redactor.String)
+
+	// --verbosity overrides general.verbosity_level for og, the agent and its logs
+	if *verbosityStr != "" {
+		level, err := ui.ParseLogLevel(*verbosityStr)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Invalid --verbosity %q: use debug, info, warn or none.\n", *verbosityStr)
+			os.Exit(1)
+		}
+		cfg.General.VerbosityLevel = level
+		cfg.General.VerbosityLevelStr = level.String()
+	}
+
+	// Handle maintenance subcommands (og debug, ...)
+	if run, ok := subcommands[command]; ok {
+		os.Exit(run(consoleUI, cfg, args[1:]))
+	}
+
+	// A replay reruns the recorded query unless it is given another
+	var replay *agent.Recording
+	if *replayPath != "" {
+		if *recordPath != "" {
+			consoleUI.PrintColored(consoleUI.Yellow, "--record and --replay cannot be combined\n")
+			os.Exit(1)
+		}
+		if replay, err = agent.LoadRecording(*replayPath); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Cannot replay: %v\n", err)
+			os.Exit(1)
+		}
+		if len(args) == 0 {
+			args = []string{replay.Query}
+		}
 	}
 
 	// Check if a query was provided
@@ -78,12 +184,80 @@ func main() {
 		os.Exit(1)
 	}
 
-	query := strings.Join(args, " ")
+	// og continue <prompt> follows up on the most recent session
+	continuing := command == continueCommand
+	if continuing {
+		args = args[1:]
+		if len(args) == 0 {
+			consoleUI.PrintColored(consoleUI.Yellow, "Usage: og continue <prompt>\n")
+			os.Exit(1)
+		}
+	}
+
+	stages, err := splitStages(args)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Yellow, "%v\nUsage: og <prompt> [--then <prompt>]...\n", err)
+		os.Exit(1)
+	}
+	if len(stages) > 1 && (*recordPath != "" || replay != nil) {
+		consoleUI.PrintColored(consoleUI.Yellow, "--record and --replay take a single prompt, without --then\n")
+		os.Exit(1)
+	}
+
+	// Piped input, as in `cat error.log | og "explain this"`, is context for the prompt
+	var stdinDoc *session.StdinContext
+	if !*noStdin && cfg.General.StdinMaxBytes > 0 {
+		doc, size, err := readPipedStdin(consoleUI, cfg.General.StdinMaxBytes)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			os.Exit(1)
+		}
+		if size > 0 {
+			stdinDoc = &session.StdinContext{Data: doc, Size: size}
+			reopenTerminal()
+		}
+	}
+
+	attachments, err := collectAttachments(consoleUI, attachFiles, attachDirs, cfg.General.AttachMaxBytes)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to attach files: %v\n", err)
+		os.Exit(1)
+	}
+
+	// The last command the shell hook (og hook) exported is context too, for prompts like "why did that fail?"
+	var lastCommand *session.LastCommand
+	if !*noLastCommand {
+		lastCommand = lastShellCommand()
+	}
 
-	// Create and run the session
-	s := session.NewSession(cfg, consoleUI, cfg.Cache)
-	if err := s.Run(query); err != nil {
-		consoleUI.PrintColored(consoleUI.Red, "OG session failed: %v\n", err)
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage: %v\n", err)
 		os.Exit(1)
 	}
+
+	var cont *continuation
+	if continuing {
+		if cont, err = lastSession(cfg, st, redactor); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Cannot continue: %v\n", err)
+			os.Exit(1)
+		}
+		consoleUI.PrintColored(consoleUI.Blue, "↪️  Continuing session %s: %s\n", consoleUI.Cyan(shortHash(cont.rec.Hash)), cont.rec.Query)
+		if wd, err := os.Getwd(); err == nil && wd != cont.rec.CWD {
+			consoleUI.PrintColored(consoleUI.Yellow, "⚠️  It ran in %s, not in this directory.\n", cont.rec.CWD)
+		}
+	}
+
+	// Create and run the sessions
+	defaultPrompts, _ := embeddedPromptsFS.ReadFile("prompts/prompts.toml")
+	exitCode := runPipeline(consoleUI, cfg, st, redactor, stages, *sandboxCopy, *readOnly, *recordPath, replay, defaultPrompts, stdinDoc, attachments, lastCommand, cont)
+	st.Close()
+	os.Exit(exitCode)
+}
+
+// afterTerminator reports whether the arguments left after the flags follow
+// --, which ends the flags.
+func afterTerminator() bool {
+	i := len(os.Args) - flag.NArg() - 1
+	return i >= 1 && os.Args[i] == "--"
 }
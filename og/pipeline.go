@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/attach"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// thenFlag separates the stages of a pipeline: og "run the tests" --then "fix the first failing test".
+const thenFlag = "--then"
+
+// splitStages splits the arguments of og <prompt> into the queries of the
+// pipeline's stages.
+func splitStages(args []string) ([]string, error) {
+	var stages []string
+	var words []string
+	for _, arg := range append(args, thenFlag) {
+		if arg != thenFlag && arg != "-then" {
+			words = append(words, arg)
+			continue
+		}
+		query := strings.TrimSpace(strings.Join(words, " "))
+		if query == "" {
+			return nil, fmt.Errorf("%s needs a prompt on both sides", thenFlag)
+		}
+		stages = append(stages, query)
+		words = nil
+	}
+	return stages, nil
+}
+
+// stageResult is how a stage of a pipeline ended.
+type stageResult struct {
+	query   string
+	hash    string
+	status  string
+	summary string
+}
+
+// runPipeline runs each query as a session, the next one starting only if the
+// previous one completed. Later stages are told what the earlier ones did and
+// recorded as children of the first in the history. It returns the exit code
+// of the last stage that ran. Input piped to og, stdinDoc, the files attached
+// with --file and --dir, files, and the shell's last command, lastCommand, are
+// context for every stage. With og continue, cont is the earlier session the
+// pipeline follows up on, whose child the first stage is. recordPath and
+// replay are those of --record and --replay, for pipelines of one stage.
+func runPipeline(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, st store.Store, redactor *redact.Redactor, stages []string, sandboxCopy, readOnly bool, recordPath string, replay *agent.Recording, defaultPrompts []byte, stdinDoc *session.StdinContext, files *attach.Document, lastCommand *session.LastCommand, cont *continuation) int {
+	var results []stageResult
+	exitCode := session.ExitCompleted
+	for i, query := range stages {
+		if len(stages) > 1 {
+			consoleUI.PrintColored(consoleUI.Blue, "\n⛓️  Stage %d/%d: %s\n", i+1, len(stages), query)
+		}
+		s := session.NewSession(cfg, consoleUI, cfg.Cache, st, redactor)
+		if sandboxCopy {
+			s.UseSandboxCopy()
+		}
+		if readOnly {
+			s.UseReadOnly()
+		}
+		if recordPath != "" {
+			s.Record(recordPath)
+		}
+		if replay != nil {
+			s.Replay(replay)
+		}
+		if defaultPrompts != nil {
+			s.SetDefaultPrompts(defaultPrompts)
+		}
+		if stdinDoc != nil {
+			s.AttachStdin(*stdinDoc)
+		}
+		if files != nil {
+			s.AttachFiles(files)
+		}
+		if lastCommand != nil {
+			s.AttachLastCommand(*lastCommand)
+		}
+		switch {
+		case len(results) > 0 && cont != nil:
+			s.Continue(results[0].hash, cont.context+"\n"+stageContext(results))
+		case len(results) > 0:
+			s.Continue(results[0].hash, stageContext(results))
+		case cont != nil:
+			s.Continue(cont.rec.Hash, cont.context+"\nThe follow-up request:")
+		}
+		err := s.Run(query)
+		result := stageResult{query: query, hash: s.Hash(), status: s.Status(), summary: s.Summary()}
+		exitCode = session.ExitCode(result.status)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "OG session failed: %v\n", err)
+			exitCode = session.ExitFailed
+			if result.status == "" {
+				result.status = "failed"
+			}
+		}
+		results = append(results, result)
+		if err != nil || result.status != agent.OutcomeCompleted {
+			break
+		}
+	}
+	if len(stages) > 1 {
+		printPipelineSummary(consoleUI, stages, results)
+	}
+	return exitCode
+}
+
+// stageContext describes the stages that ran so far to the agent of the next one.
+func stageContext(results []stageResult) string {
+	var b strings.Builder
+	b.WriteString("This request is a stage of a pipeline. The earlier stages ran in the same directory:\n")
+	for i, r := range results {
+		fmt.Fprintf(&b, "%d. %q (%s)", i+1, r.query, r.status)
+		if r.summary != "" {
+			fmt.Fprintf(&b, ": %s", r.summary)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("\nThe request of this stage:")
+	return b.String()
+}
+
+// printPipelineSummary prints how each stage of a pipeline ended.
+func printPipelineSummary(consoleUI *ui.ConsoleUI, stages []string, results []stageResult) {
+	consoleUI.PrintColored(consoleUI.Blue, "\n⛓️  Pipeline summary:\n")
+	for i, query := range stages {
+		if i >= len(results) {
+			fmt.Printf("  %s %d. %s  %s\n", consoleUI.Text("⏭️ "), i+1, consoleUI.Yellow("skipped"), query)
+			continue
+		}
+		r := results[i]
+		icon, color := "❌", consoleUI.Red
+		if r.status == agent.OutcomeCompleted {
+			icon, color = "✅", consoleUI.Green
+		}
+		fmt.Printf("  %s %d. %s  %s  %s\n", consoleUI.Text(icon), i+1, color(r.status), consoleUI.Cyan(r.hash), query)
+		if r.summary != "" {
+			fmt.Printf("       %s\n", r.summary)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const policyUsage = "Usage: og policy test <policy.toml> [-n sessions] [-v]\n"
+
+// runPolicy implements `og policy <action>`.
+func runPolicy(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	if len(args) < 1 {
+		consoleUI.PrintColored(consoleUI.Yellow, policyUsage)
+		return 1
+	}
+	switch args[0] {
+	case "test":
+		return runPolicyTest(consoleUI, cfg, args[1:])
+	default:
+		consoleUI.PrintColored(consoleUI.Red, "Unknown policy action '%s'\n", args[0])
+		return 1
+	}
+}
+
+// transcriptActions is the part of a stored session transcript that policy tests replay.
+type transcriptActions struct {
+	ExecutedActions []struct {
+		Tool   string `json:"tool"`
+		Action string `json:"action"`
+	} `json:"executed_actions"`
+}
+
+// policyOutcome is what the Go CLI would do with an action: the policy decision,
+// downgraded to a prompt for dangerous commands that are never auto-approved.
+func policyOutcome(e *policy.Engine, a policy.Action) policy.Result {
+	res := e.Evaluate(a)
+	if reason, dangerous := policy.ClassifyDanger(a.Command); dangerous && res.Decision == policy.DecisionApprove {
+		return policy.Result{Decision: policy.DecisionPrompt, Reason: "dangerous command: " + reason}
+	}
+	return res
+}
+
+// runPolicyTest replays the actions of stored sessions through the current and a
+// proposed policy and reports how the decisions would change.
+func runPolicyTest(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("policy test", flag.ContinueOnError)
+	count := fs.Int("n", 0, "only replay the most recent sessions (0 for all)")
+	verbose := fs.Bool("v", false, "list every action, not only those whose decision changes")
+	path, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if path == "" && fs.NArg() > 0 {
+		path = fs.Arg(0)
+	}
+	if path == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, policyUsage)
+		return 1
+	}
+
+	proposedPolicy, proposedTrust, err := config.LoadPolicyFile(path)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	trustCfg := cfg.Trust
+	if proposedTrust != nil {
+		trustCfg = *proposedTrust
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	if *count > 0 && len(records) > *count {
+		records = records[len(records)-*count:]
+	}
+
+	// Engines are compiled per trust level since trusted_auto_approve depends on it
+	type engines struct{ current, proposed *policy.Engine }
+	byTrust := map[policy.TrustLevel]engines{}
+	enginesFor := func(level policy.TrustLevel) (engines, error) {
+		if e, ok := byTrust[level]; ok {
+			return e, nil
+		}
+		current, err := policy.New(cfg.Policy, level)
+		if err != nil {
+			return engines{}, fmt.Errorf("current policy: %w", err)
+		}
+		proposed, err := policy.New(proposedPolicy, level)
+		if err != nil {
+			return engines{}, fmt.Errorf("proposed policy: %w", err)
+		}
+		byTrust[level] = engines{current, proposed}
+		return byTrust[level], nil
+	}
+
+	totals := map[policy.Decision][2]int{} // decision -> [current, proposed]
+	sessions, actions, changed := 0, 0, 0
+	for _, rec := range records {
+		data, err := st.Transcripts().Get(rec.Hash)
+		if err != nil {
+			continue // Sessions without a transcript (e.g. json_logs disabled) cannot be replayed
+		}
+		var t transcriptActions
+		if err := json.Unmarshal(data, &t); err != nil || len(t.ExecutedActions) == 0 {
+			continue
+		}
+		e, err := enginesFor(policy.ResolveTrust(trustCfg, rec.CWD))
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Invalid %v\n", err)
+			return 1
+		}
+		e.current.SetWorkdir(rec.CWD)
+		e.proposed.SetWorkdir(rec.CWD)
+		sessions++
+		for _, executed := range t.ExecutedActions {
+			a := policy.Action{Tool: executed.Tool, Command: executed.Action}
+			before, after := policyOutcome(e.current, a), policyOutcome(e.proposed, a)
+			actions++
+			c := totals[before.Decision]
+			c[0]++
+			totals[before.Decision] = c
+			p := totals[after.Decision]
+			p[1]++
+			totals[after.Decision] = p
+
+			if before.Decision != after.Decision {
+				changed++
+			} else if !*verbose {
+				continue
+			}
+			line := fmt.Sprintf("%s  %-8s -> %-8s  %s: %s", shortHash(rec.Hash), before.Decision, after.Decision, a.Tool, strings.ReplaceAll(a.Command, "\n", "; "))
+			color := consoleUI.Blue
+			switch {
+			case before.Decision == after.Decision:
+				color = fmt.Sprint
+			case after.Decision == policy.DecisionDeny:
+				color = consoleUI.Red
+			case after.Decision == policy.DecisionApprove:
+				color = consoleUI.Green
+			}
+			consoleUI.PrintColored(color, "%s\n", ui.Truncate(line, ui.TerminalWidth()))
+			if after.Reason != "" && before.Decision != after.Decision {
+				fmt.Printf("          %s\n", after.Reason)
+			}
+		}
+	}
+
+	if sessions == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No stored transcripts to replay (transcripts are only kept when 'cache.json_logs' is enabled).\n")
+		return 0
+	}
+	fmt.Printf("\nReplayed %d action(s) from %d session(s); %d would be decided differently.\n\n", actions, sessions, changed)
+	fmt.Printf("  %-10s %8s %9s\n", "decision", "current", "proposed")
+	for _, d := range []policy.Decision{policy.DecisionApprove, policy.DecisionPrompt, policy.DecisionEscalate, policy.DecisionDeny} {
+		fmt.Printf("  %-10s %8d %9d\n", d, totals[d][0], totals[d][1])
+	}
+	return 0
+}
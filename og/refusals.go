@@ -0,0 +1,99 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/refusals"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const refusalsUsage = "Usage: og refusals [dir]\n       og refusals --forget <n> [dir]\n       og refusals --clear [dir]\n"
+
+// runRefusals implements `og refusals`, which lists the actions refused in a
+// directory that later sessions there tell the agent about, and forgets them.
+func runRefusals(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("refusals", flag.ContinueOnError)
+	forget := fs.Int("forget", 0, "forget the refusal with this number in the list")
+	clearAll := fs.Bool("clear", false, "forget all refusals in the directory")
+	dir, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to get current working directory: %v\n", err)
+			return 1
+		}
+		dir = wd
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	refused, err := refusals.List(st.Memory(), dir)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+
+	switch {
+	case *clearAll:
+		for _, r := range refused {
+			if err := refusals.Forget(st.Memory(), dir, r); err != nil {
+				consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+				return 1
+			}
+		}
+		consoleUI.PrintColored(consoleUI.Green, "Forgot %d refusal(s) in %s.\n", len(refused), dir)
+		return 0
+
+	case *forget != 0:
+		if *forget < 1 || *forget > len(refused) {
+			consoleUI.PrintColored(consoleUI.Yellow, "No refusal %d in %s; `og refusals` lists them.\n%s", *forget, dir, refusalsUsage)
+			return 1
+		}
+		r := refused[*forget-1]
+		if err := refusals.Forget(st.Memory(), dir, r); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		consoleUI.PrintColored(consoleUI.Green, "Forgot the refusal of %s.\n", consoleUI.Cyan(oneLine(r.Command)))
+		return 0
+	}
+
+	if len(refused) == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No refusals remembered in %s.\n", dir)
+		return 0
+	}
+	for i, r := range refused {
+		fmt.Printf("%3d. %s  %s: %s\n", i+1, r.TS, r.Tool, oneLine(r.Command))
+		fmt.Printf("     because: %s\n", r.Reason)
+	}
+	if len(refused) > refusals.MaxInContext {
+		consoleUI.PrintColored(consoleUI.Yellow, "Only the last %d are given to the agent.\n", refusals.MaxInContext)
+	}
+	return 0
+}
+
+// oneLine joins the lines of a recipe's commands.
+func oneLine(command string) string {
+	return strings.ReplaceAll(command, "\n", "; ")
+}
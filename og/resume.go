@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/checkpoint"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const resumeUsage = "Usage: og resume <hash>\n"
+
+// runResume implements `og resume`: taking up a session that a crash, Ctrl-C
+// or an error cut short in the middle of its recipe, from the first step that
+// did not complete, under the approval the recipe was given. The agent
+// reloads the state it saved, so the session must have run with
+// cache.json_logs. It runs in the session's directory.
+func runResume(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("resume", flag.ContinueOnError)
+	hash, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if hash == "" && fs.NArg() > 0 {
+		hash = fs.Arg(0)
+	}
+	if hash == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, resumeUsage)
+		return 1
+	}
+	if !cfg.Cache.JSONLogs {
+		consoleUI.PrintColored(consoleUI.Red, "Cannot resume: the agent only saves the state of sessions with cache.json_logs.\n")
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	rec, err := findSession(records, hash)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	state, err := checkpoint.Load(cfg.Cache.Directory, rec.Hash)
+	if os.IsNotExist(err) {
+		consoleUI.PrintColored(consoleUI.Red, "Cannot resume session %s: it left no checkpoint.\n", shortHash(rec.Hash))
+		return 1
+	} else if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read the checkpoint of session %s: %v\n", shortHash(rec.Hash), err)
+		return 1
+	}
+	if why := session.Resumable(state); why != "" {
+		consoleUI.PrintColored(consoleUI.Yellow, "Cannot resume session %s: %s.\n", shortHash(rec.Hash), why)
+		return 1
+	}
+	redactor, err := redact.New(cfg.Redaction)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Invalid [redaction] config: %v\n", err)
+		return 1
+	}
+
+	if wd, err := os.Getwd(); err != nil || wd != rec.CWD {
+		if err := os.Chdir(rec.CWD); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Cannot enter %s, where the session ran: %v\n", rec.CWD, err)
+			return 1
+		}
+		consoleUI.PrintColored(consoleUI.Yellow, "📂 Resuming in %s, where the session ran.\n", rec.CWD)
+	}
+	consoleUI.PrintColored(consoleUI.Blue, "⏯️  Resuming session %s: %s\n", consoleUI.Cyan(shortHash(rec.Hash)), rec.Query)
+	printCheckpoint(consoleUI, state, redactor)
+
+	s := session.NewSession(cfg, consoleUI, cfg.Cache, st, redactor)
+	s.Resume(rec.Hash, rec.Tag, state)
+	if defaultPrompts, err := embeddedPromptsFS.ReadFile("prompts/prompts.toml"); err == nil {
+		s.SetDefaultPrompts(defaultPrompts)
+	}
+	if err := s.Run(rec.Query); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "OG session failed: %v\n", err)
+		return session.ExitFailed
+	}
+	return session.ExitCode(s.Status())
+}
+
+// printCheckpoint lists the steps of a recipe to resume, with those that ran
+// and how, and the one it is resumed from.
+func printCheckpoint(consoleUI *ui.ConsoleUI, state *checkpoint.State, redactor *redact.Redactor) {
+	next := state.Next()
+	for i, step := range state.Steps {
+		icon, note := "  ", consoleUI.Yellow(step.State)
+		switch {
+		case step.State == checkpoint.StateExecuted && step.Status == "success":
+			icon, note = "✅", consoleUI.Green("done")
+		case step.State == checkpoint.StateExecuted:
+			icon, note = "❌", consoleUI.Red("failed")
+			if step.ExitCode != nil {
+				note = consoleUI.Red(fmt.Sprintf("failed, exit %d", *step.ExitCode))
+			}
+		case i+1 == next:
+			icon, note = "⏩", consoleUI.Cyan("resumes here")
+		}
+		fmt.Printf("  %s %d. %s  %s\n", consoleUI.Text(icon), i+1, redactor.String(strings.ReplaceAll(step.Action, "\n", "; ")), note)
+	}
+}
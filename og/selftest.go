@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/native"
+	"github.com/robbiemu/original_gangster/og/internal/redact"
+	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const selftestUsage = "Usage: og selftest\n"
+
+// selftestQuery is the query of the self-test's session.
+const selftestQuery = "Check that og works (og selftest)"
+
+// runSelftest implements `og selftest`: a session of og's echo agent, which
+// goes through the plan, the approval prompt, the step and the summary like
+// any session but needs no model, so an install and its terminal can be checked
+// before a model is configured. It runs in a throwaway directory with a
+// throwaway store, and leaves out the parts of the config that reach beyond
+// og: sandboxes, hooks, tools, notifications and remote approvals.
+func runSelftest(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() > 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, selftestUsage)
+		return 1
+	}
+
+	dir, err := os.MkdirTemp(cfg.General.TempRoot, "og-selftest-")
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Cannot create a directory for the self-test: %v\n", err)
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	workdir := filepath.Join(dir, "work")
+	if err := os.Mkdir(workdir, 0o755); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Cannot create a directory for the self-test: %v\n", err)
+		return 1
+	}
+	cwd, err := os.Getwd()
+	if err == nil {
+		err = os.Chdir(workdir)
+	}
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Cannot enter the self-test's directory: %v\n", err)
+		return 1
+	}
+	defer os.Chdir(cwd)
+
+	test := selftestConfig(cfg, dir)
+	st, err := store.Open(test)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open the self-test's storage: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	redactor, err := redact.New(test.Redaction)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Invalid [redaction] config: %v\n", err)
+		return 1
+	}
+
+	consoleUI.PrintColored(consoleUI.Blue, "🩺 og selftest: og's echo agent stands in for the models and plans `%s`.\n", native.EchoCommand)
+	consoleUI.PrintColored(consoleUI.Blue, "   Approve it when asked; the test is not added to your history, only to the audit log.\n\n")
+	s := session.NewSession(test, consoleUI, test.Cache, st, redactor)
+	s.UseEchoAgent()
+	if defaultPrompts, err := embeddedPromptsFS.ReadFile("prompts/prompts.toml"); err == nil {
+		s.SetDefaultPrompts(defaultPrompts)
+	}
+	err = s.Run(selftestQuery)
+	switch status := s.Status(); {
+	case err != nil:
+		consoleUI.PrintColored(consoleUI.Red, "\n❌ The self-test failed: %v\n", err)
+		return session.ExitFailed
+	case status != agent.OutcomeCompleted:
+		consoleUI.PrintColored(consoleUI.Red, "\n❌ The self-test's session ended %s instead of completing; approve its step to pass.\n", status)
+		return session.ExitCode(status)
+	}
+	consoleUI.PrintColored(consoleUI.Green, "\n✅ og, its agent protocol and this terminal work. Set your models in og_config.toml to run real sessions.\n")
+	return session.ExitCompleted
+}
+
+// selftestConfig returns the config of the self-test's session: cfg, with its
+// store in dir and without what reaches beyond og.
+func selftestConfig(cfg *config.OGConfig, dir string) *config.OGConfig {
+	test := *cfg
+	test.General.Executor = "go"
+	test.General.Sandbox = "none"
+	test.General.CheckModels = false
+	test.General.InteractiveFollowups = false
+	test.General.RememberRefusals = false
+	test.General.DistillAfter = 0
+	test.General.ConcurrentSessions = session.ConcurrentAllow
+	test.Cache.Directory = filepath.Join(dir, "cache")
+	test.Storage = config.StorageCfg{Backend: "shared", Path: filepath.Join(dir, "store"), User: cfg.Storage.User}
+	test.Policy = config.PolicyCfg{MaxLoopIterations: config.DefaultMaxLoopIterations, AuditorStrictness: "standard"}
+	test.Trust = config.TrustCfg{}
+	test.Delegation = config.DelegationCfg{}
+	test.RemoteApproval = config.DefaultRemoteApprovalCfg()
+	test.Editor.FollowUp = false
+	test.Limits = config.LimitsCfg{}
+	test.Telemetry = config.TelemetryCfg{ServiceName: "og"}
+	test.Notifications = config.DefaultNotificationsCfg()
+	test.Hooks = config.DefaultHooksCfg()
+	test.Classifier = config.ClassifierCfg{Method: "off"}
+	test.SecondOpinion = config.DefaultSecondOpinionCfg()
+	test.Databases, test.MCPServers, test.Plugins = nil, nil, nil
+	return &test
+}
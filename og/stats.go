@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/stats"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/usage"
+)
+
+const statsUsage = "Usage: og stats [--since 30d] [--until date] [--user name | --all-users] [--json]\n"
+
+// statsTopTools is how many tools the text report lists.
+const statsTopTools = 10
+
+// runStats implements `og stats`, which reports how OG has been used.
+func runStats(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ContinueOnError)
+	since := fs.String("since", "", "only sessions newer than a duration (e.g. 30d, 12w) or date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only sessions older than a duration or date")
+	userName := fs.String("user", cfg.Storage.User, "only sessions run by this user")
+	allUsers := fs.Bool("all-users", false, "include sessions from every user of the store")
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() > 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, statsUsage)
+		return 1
+	}
+
+	q := history.Query{}
+	if err := setTimeBounds(&q, *since, *until); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if !*allUsers {
+		q.User = *userName
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	for i := range records {
+		if records[i].User == "" {
+			records[i].User = cfg.Storage.User
+		}
+	}
+	index, err := history.LoadIndex()
+	if err != nil {
+		fmt.Fprint(os.Stderr, consoleUI.Text(fmt.Sprintf("⚠️  Session index unavailable, outcomes, durations and spend are missing: %v\n", err)))
+	}
+	entries, err := audit.ReadEntries()
+	if err != nil {
+		fmt.Fprint(os.Stderr, consoleUI.Text(fmt.Sprintf("⚠️  Audit log unavailable, tools and approvals are missing: %v\n", err)))
+	}
+
+	report := stats.Compute(history.Search(records, q, nil), index, entries)
+	if *asJSON {
+		b, _ := json.MarshalIndent(report, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	if report.Sessions == 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, "No sessions found.\n")
+		return 0
+	}
+	printStats(consoleUI, report)
+	return 0
+}
+
+// printStats renders a report as text sections.
+func printStats(consoleUI *ui.ConsoleUI, r stats.Report) {
+	fmt.Printf("%s %d session(s) from %s to %s\n", consoleUI.Blue("📊"), r.Sessions, r.From, r.To)
+
+	fmt.Println(consoleUI.Yellow("\nSessions per week:"))
+	peak := 0
+	for _, w := range r.Weeks {
+		peak = max(peak, w.Count)
+	}
+	for _, w := range r.Weeks {
+		fmt.Printf("  %s  %4d  %s\n", w.Key, w.Count, strings.Repeat("█", w.Count*30/max(peak, 1)))
+	}
+
+	fmt.Println(consoleUI.Yellow("\nOutcomes:"))
+	for _, s := range []string{"completed", "denied", "quit", "unsafe", "model_unreachable", "tool_failed", "protocol_error", "hook_failed", "aborted", "error", "failed", "incomplete", "-"} {
+		if n := r.Statuses[s]; n > 0 {
+			fmt.Printf("  %s %4d\n", ui.PadRight(s, 17), n)
+		}
+	}
+
+	fmt.Println(consoleUI.Yellow("\nQuery tags:"))
+	for _, t := range r.Tags {
+		fmt.Printf("  %s %4d\n", ui.PadRight(t.Key, 17), t.Count)
+	}
+
+	fmt.Println(consoleUI.Yellow("\nMost used tools:"))
+	if len(r.Tools) == 0 {
+		fmt.Println("  (no executions in the audit log)")
+	}
+	for i, t := range r.Tools {
+		if i == statsTopTools {
+			fmt.Printf("  ... and %d more\n", len(r.Tools)-i)
+			break
+		}
+		fmt.Printf("  %s %4d\n", ui.PadRight(ui.Truncate(t.Key, 24), 24), t.Count)
+	}
+
+	fmt.Println(consoleUI.Yellow("\nTotals:"))
+	decisions := r.Approvals.Approved + r.Approvals.Denied
+	if decisions > 0 {
+		fmt.Printf("  Approvals:         %d approved / %d denied (%.0f%% approved)\n", r.Approvals.Approved, r.Approvals.Denied, r.Approvals.Ratio()*100)
+	} else {
+		fmt.Println("  Approvals:         none recorded")
+	}
+	if r.WithDuration > 0 {
+		avg := time.Duration(r.AvgDuration * float64(time.Second)).Round(time.Second)
+		fmt.Printf("  Average duration:  %s (%d of %d sessions)\n", avg, r.WithDuration, r.Sessions)
+	} else {
+		fmt.Println("  Average duration:  not recorded")
+	}
+	if r.WithUsage > 0 {
+		fmt.Printf("  Tokens:            %s in / %s out (%d of %d sessions)\n", usage.FormatCount(r.PromptTokens), usage.FormatCount(r.CompletionTokens), r.WithUsage, r.Sessions)
+		fmt.Printf("  Estimated spend:   %s\n", usage.FormatCost(r.Cost))
+	} else {
+		fmt.Println("  Tokens:            not recorded")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// stdinWaitNotice is how long og reads piped input before telling the user
+// what it waits for, e.g. when stdin is a pipe nothing is ever written to.
+const stdinWaitNotice = 2 * time.Second
+
+// readPipedStdin reads what was piped or redirected to og, as in
+// `cat error.log | og "explain this"`. Input longer than max bytes is cut to
+// its first and last max/2 bytes, where a log's context and its errors are;
+// size is the length of the whole input. It returns no input when stdin is a
+// terminal or another device.
+func readPipedStdin(consoleUI *ui.ConsoleUI, max int) (doc []byte, size int64, err error) {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeNamedPipe == 0 && !info.Mode().IsRegular() {
+		return nil, 0, nil
+	}
+	type result struct {
+		doc  []byte
+		size int64
+		err  error
+	}
+	done := make(chan result, 1)
+	go func() {
+		doc, size, err := readHeadTail(os.Stdin, max)
+		done <- result{doc, size, err}
+	}()
+	select {
+	case r := <-done:
+		return r.doc, r.size, r.err
+	case <-time.After(stdinWaitNotice):
+		consoleUI.PrintColored(consoleUI.Yellow, "⏳ Waiting for the piped input to end (run og with --no-stdin to ignore it)...\n")
+	}
+	r := <-done
+	return r.doc, r.size, r.err
+}
+
+// readHeadTail reads r to the end, keeping its first and last max/2 bytes.
+func readHeadTail(r io.Reader, max int) ([]byte, int64, error) {
+	headMax, tailMax := max/2, max-max/2
+	var head, tail []byte
+	var size int64
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := r.Read(buf)
+		chunk := buf[:n]
+		size += int64(n)
+		if len(head) < headMax {
+			k := min(headMax-len(head), len(chunk))
+			head = append(head, chunk[:k]...)
+			chunk = chunk[k:]
+		}
+		tail = append(tail, chunk...)
+		if len(tail) > tailMax {
+			tail = append(tail[:0], tail[len(tail)-tailMax:]...)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, size, fmt.Errorf("failed to read stdin: %w", err)
+		}
+	}
+	if size <= int64(max) {
+		return append(head, tail...), size, nil
+	}
+	omitted := size - int64(len(head)) - int64(len(tail))
+	return bytes.Join([][]byte{head, []byte(fmt.Sprintf("\n-- %d bytes omitted from the middle of the piped input --\n", omitted)), tail}, nil), size, nil
+}
+
+// reopenTerminal points os.Stdin at the terminal after the piped input was
+// read, so that approval prompts can still be answered. Without a terminal,
+// e.g. in CI, prompts find no input and deny, as with stdin at its end.
+func reopenTerminal() {
+	name := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		name = "CONIN$"
+	}
+	if tty, err := os.Open(name); err == nil {
+		os.Stdin = tty
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// subcommand runs an `og <name> ...` command instead of an agent session and
+// returns the process exit code.
+type subcommand func(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int
+
+// subcommands maps the first positional argument to the command that handles it.
+var subcommands = map[string]subcommand{
+	"audit":    runAudit,
+	"bench":    runBench,
+	"clean":    runClean,
+	"config":   runConfig,
+	"db":       runDB,
+	"daemon":   runDaemon,
+	"debug":    runDebug,
+	"distill":  runDistill,
+	"export":   runExport,
+	"history":  runHistory,
+	"policy":   runPolicy,
+	"prompts":  runPrompts,
+	"refusals": runRefusals,
+	"resume":   runResume,
+	"selftest": runSelftest,
+	"stats":    runStats,
+	"timeline": runTimeline,
+	"trust":    runTrust,
+	"undo":     runUndo,
+}
+
+// operands says which positional arguments a subcommand takes besides its
+// actions and flags.
+type operands int
+
+const (
+	noOperands  operands = iota
+	oneOperand           // At most one, such as a directory or a file
+	hashOperand          // At most one session hash or prefix of one
+	argOperand           // At most one of the values its arg completer offers
+	anyOperands          // Any words, such as a search or a prompt
+)
+
+// sessionHash matches a session hash or a prefix of one.
+var sessionHash = regexp.MustCompile(`^[0-9a-f]{4,64}$`)
+
+// subcommandLine reports whether args name a subcommand and the rest of them
+// parse as its actions, flags and operands. A prompt that merely starts with a
+// subcommand's name, as og history of this repo does, does not, and is run as
+// a prompt.
+func subcommandLine(args []string) bool {
+	if len(args) == 0 {
+		return false
+	}
+	cmd, ok := completionSpec.actions[args[0]]
+	if !ok {
+		return false
+	}
+	var positional []string
+	for i := 1; i < len(args); i++ {
+		w := args[i]
+		if w == "--" {
+			positional = append(positional, args[i+1:]...)
+			break
+		}
+		if name, ok := flagName(w); ok {
+			value, known := cmd.flags[name]
+			if !known && name != "h" && name != "help" {
+				return false
+			}
+			if value != nil && !strings.Contains(w, "=") {
+				if i++; i == len(args) {
+					return false // A flag missing its value
+				}
+			}
+			continue
+		}
+		if len(positional) == 0 && len(cmd.actions) > 0 {
+			if cmd, ok = cmd.actions[w]; !ok {
+				return false
+			}
+			continue
+		}
+		positional = append(positional, w)
+	}
+
+	switch {
+	case cmd.operands == anyOperands:
+		return true
+	case len(positional) == 0:
+		return true
+	case len(positional) > 1 || cmd.operands == noOperands:
+		return false
+	case cmd.operands == hashOperand:
+		return sessionHash.MatchString(positional[0])
+	case cmd.operands == argOperand:
+		return slices.Contains(cmd.arg(nil), positional[0])
+	}
+	return true
+}
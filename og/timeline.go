@@ -0,0 +1,101 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/history"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/timeline"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const timelineUsage = "Usage: og timeline [--since 30d] [--until date] [--cwd dir] [--user name | --all-users] [--format ical|json] [-o file]\n"
+
+// runTimeline implements `og timeline`, which exports when sessions ran and how
+// long they took.
+func runTimeline(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("timeline", flag.ContinueOnError)
+	since := fs.String("since", "", "only sessions newer than a duration (e.g. 30d, 12w) or date (YYYY-MM-DD)")
+	until := fs.String("until", "", "only sessions older than a duration or date")
+	cwd := fs.String("cwd", "", "only sessions run in this directory or below it ('.' for the current one)")
+	userName := fs.String("user", cfg.Storage.User, "only sessions run by this user")
+	allUsers := fs.Bool("all-users", false, "include sessions from every user of the store")
+	format := fs.String("format", "ical", "output format: "+strings.Join(timeline.Formats, ", "))
+	output := fs.String("o", "", "write to this file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+	if fs.NArg() > 0 {
+		consoleUI.PrintColored(consoleUI.Yellow, timelineUsage)
+		return 1
+	}
+	if !slices.Contains(timeline.Formats, *format) {
+		consoleUI.PrintColored(consoleUI.Red, "Unknown format '%s' (use %s)\n", *format, strings.Join(timeline.Formats, ", "))
+		return 1
+	}
+
+	q := history.Query{}
+	if !*allUsers {
+		q.User = *userName
+	}
+	if err := setTimeBounds(&q, *since, *until); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	if *cwd != "" {
+		dir, err := filepath.Abs(*cwd)
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Invalid --cwd: %v\n", err)
+			return 1
+		}
+		q.CWD = dir
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	for i := range records {
+		if records[i].User == "" {
+			records[i].User = cfg.Storage.User
+		}
+	}
+	index, err := history.LoadIndex()
+	if err != nil {
+		fmt.Fprint(os.Stderr, consoleUI.Text(fmt.Sprintf("⚠️  Session index unavailable, durations are missing: %v\n", err)))
+	}
+
+	tl := timeline.Build(history.Search(records, q, nil), index)
+	if tl.Unrecorded > 0 {
+		fmt.Fprint(os.Stderr, consoleUI.Text(fmt.Sprintf("⚠️  %d of %d session(s) have no recorded duration and are exported without an end time.\n", tl.Unrecorded, len(tl.Sessions))))
+	}
+	out, err := tl.Render(*format, time.Now())
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to render timeline: %v\n", err)
+		return 1
+	}
+	if *output == "" {
+		os.Stdout.Write(out)
+		return 0
+	}
+	if err := os.WriteFile(*output, out, 0o644); err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to write %s: %v\n", *output, err)
+		return 1
+	}
+	consoleUI.PrintColored(consoleUI.Green, "✅ Exported %d session(s), %s in total, to %s\n", len(tl.Sessions), (time.Duration(tl.TotalDurationMs) * time.Millisecond).Round(time.Second), *output)
+	return 0
+}
@@ -0,0 +1,154 @@
+// Package toolplugin lets Go programs add tools to og's agent. A plugin is a
+// program whose main calls Serve with its Tool; og starts the plugins of its
+// [plugins] configuration, offers their tools to the agent, and calls Execute
+// once the agent's call was approved. og and its plugins talk over
+// hashicorp/go-plugin's net/rpc transport, so a plugin that crashes does not
+// take og down.
+//
+// A minimal plugin:
+//
+//	type echo struct{}
+//
+//	func (echo) Name() string                { return "echo" }
+//	func (echo) Description() string         { return "Returns its text argument." }
+//	func (echo) Schema() json.RawMessage     { return json.RawMessage(`{"type":"object","properties":{"text":{"type":"string"}},"required":["text"]}`) }
+//	func (echo) Execute(args json.RawMessage) (string, error) {
+//		var in struct{ Text string }
+//		err := json.Unmarshal(args, &in)
+//		return in.Text, err
+//	}
+//
+//	func main() { toolplugin.Serve(echo{}) }
+package toolplugin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/rpc"
+
+	"github.com/hashicorp/go-plugin"
+)
+
+// Tool is a tool a plugin offers to og's agent.
+type Tool interface {
+	// Name is the tool's name, which og prefixes with the plugin's name in
+	// [plugins] unless they are the same.
+	Name() string
+	// Description tells the agent what the tool does and when to use it.
+	Description() string
+	// Schema is the JSON Schema of the tool's arguments, an object.
+	Schema() json.RawMessage
+	// Execute runs the tool with the arguments the agent gave, a JSON object
+	// matching Schema, and returns the text the agent is shown. An error
+	// reports that the tool failed; the text, if any, is shown with it.
+	Execute(args json.RawMessage) (string, error)
+}
+
+// Handshake is what og and its plugins check before they talk: a plugin run
+// by hand, rather than by og, exits with a message saying so.
+var Handshake = plugin.HandshakeConfig{
+	ProtocolVersion:  1,
+	MagicCookieKey:   "OG_TOOL_PLUGIN",
+	MagicCookieValue: "7b1d3c0e-og-tool",
+}
+
+// PluginName is the name under which a plugin serves its Tool.
+const PluginName = "tool"
+
+// Serve serves t to og and returns when og is done with the plugin. It is
+// meant to be called from the plugin's main.
+func Serve(t Tool) {
+	plugin.Serve(&plugin.ServeConfig{
+		HandshakeConfig: Handshake,
+		Plugins:         plugin.PluginSet{PluginName: &Plugin{Impl: t}},
+	})
+}
+
+// Plugin is the go-plugin plugin of a Tool. Plugins only set Impl, through
+// Serve; og dispenses the client side.
+type Plugin struct {
+	Impl Tool
+}
+
+func (p *Plugin) Server(*plugin.MuxBroker) (interface{}, error) {
+	return &rpcServer{impl: p.Impl}, nil
+}
+
+func (p *Plugin) Client(_ *plugin.MuxBroker, c *rpc.Client) (interface{}, error) {
+	return &Client{c: c}, nil
+}
+
+// Result is what Execute returned, as sent back to og.
+type Result struct {
+	Output string
+	Error  string // Empty when the tool succeeded
+}
+
+// rpcServer runs a plugin's Tool for og.
+type rpcServer struct {
+	impl Tool
+}
+
+func (s *rpcServer) Name(_ interface{}, name *string) error {
+	*name = s.impl.Name()
+	return nil
+}
+
+func (s *rpcServer) Description(_ interface{}, description *string) error {
+	*description = s.impl.Description()
+	return nil
+}
+
+func (s *rpcServer) Schema(_ interface{}, schema *json.RawMessage) error {
+	*schema = s.impl.Schema()
+	return nil
+}
+
+func (s *rpcServer) Execute(args json.RawMessage, result *Result) error {
+	output, err := s.impl.Execute(args)
+	result.Output = output
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return nil
+}
+
+// Client is og's side of a plugin's Tool. Unlike a Tool's, its methods report
+// the plugin failing to answer, such as when it crashed.
+type Client struct {
+	c *rpc.Client
+}
+
+// Name returns the tool's name.
+func (c *Client) Name() (string, error) {
+	var name string
+	err := c.c.Call("Plugin.Name", new(interface{}), &name)
+	return name, err
+}
+
+// Description returns the tool's description.
+func (c *Client) Description() (string, error) {
+	var description string
+	err := c.c.Call("Plugin.Description", new(interface{}), &description)
+	return description, err
+}
+
+// Schema returns the JSON Schema of the tool's arguments.
+func (c *Client) Schema() (json.RawMessage, error) {
+	var schema json.RawMessage
+	err := c.c.Call("Plugin.Schema", new(interface{}), &schema)
+	return schema, err
+}
+
+// Execute runs the tool with args. The tool failing is reported as an error
+// along with its output.
+func (c *Client) Execute(args json.RawMessage) (string, error) {
+	var result Result
+	if err := c.c.Call("Plugin.Execute", args, &result); err != nil {
+		return "", err
+	}
+	if result.Error != "" {
+		return result.Output, errors.New(result.Error)
+	}
+	return result.Output, nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/robbiemu/original_gangster/og/internal/audit"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/policy"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+const trustUsage = "Usage: og trust --for <duration> [dir]\n       og trust --list\n       og trust --revoke [dir]\n"
+
+// runTrust implements `og trust`, which grants a directory temporary trusted status
+// so `policy.trusted_auto_approve` applies there until the grant expires.
+func runTrust(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("trust", flag.ContinueOnError)
+	forDuration := fs.Duration("for", 0, "how long the directory stays trusted (e.g. 30m, 2h)")
+	list := fs.Bool("list", false, "list active grants")
+	revoke := fs.Bool("revoke", false, "end the grant covering the directory now")
+	dir, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if dir == "" && fs.NArg() > 0 {
+		dir = fs.Arg(0)
+	}
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "Failed to get current working directory: %v\n", err)
+			return 1
+		}
+		dir = wd
+	}
+
+	grants, err := policy.LoadGrants()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+
+	switch {
+	case *list:
+		now := time.Now()
+		shown := 0
+		for _, g := range grants {
+			if !g.Active(now) {
+				continue
+			}
+			fmt.Printf("%s  until %s (%s left), granted by %s\n", g.Path, g.Expires.Format("2006-01-02 15:04"), g.Expires.Sub(now).Round(time.Minute), g.GrantedBy)
+			shown++
+		}
+		if shown == 0 {
+			consoleUI.PrintColored(consoleUI.Yellow, "No active trust grants.\n")
+		}
+		return 0
+
+	case *revoke:
+		g, ok := policy.ActiveGrant(grants, dir)
+		if !ok {
+			consoleUI.PrintColored(consoleUI.Yellow, "No active trust grant covers %s.\n", dir)
+			return 0
+		}
+		var kept []policy.Grant
+		for _, other := range grants {
+			if other != g {
+				kept = append(kept, other)
+			}
+		}
+		if err := policy.SaveGrants(kept); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		recordTrust(consoleUI, cfg, g, "revoked", "grant ran until "+g.Expires.Format(time.RFC3339))
+		consoleUI.PrintColored(consoleUI.Green, "🔒 Trust grant for %s revoked.\n", g.Path)
+		return 0
+
+	case *forDuration > 0:
+		limit := time.Duration(cfg.Trust.MaxElevationMinutes) * time.Minute
+		if limit > 0 && *forDuration > limit {
+			consoleUI.PrintColored(consoleUI.Red, "Requested %s exceeds 'trust.max_elevation_minutes' (%s).\n", *forDuration, limit)
+			return 1
+		}
+		if policy.ResolveTrust(cfg.Trust, dir) == policy.TrustUntrusted {
+			consoleUI.PrintColored(consoleUI.Red, "%s is configured as untrusted and cannot be elevated.\n", dir)
+			return 1
+		}
+		g := policy.NewGrant(dir, cfg.Storage.User, *forDuration)
+		if err := policy.SaveGrants(append(grants, g)); err != nil {
+			consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+			return 1
+		}
+		recordTrust(consoleUI, cfg, g, "granted", "trusted until "+g.Expires.Format(time.RFC3339))
+		consoleUI.PrintColored(consoleUI.Green, "🔓 %s is trusted until %s.\n", g.Path, g.Expires.Format("15:04"))
+		if len(cfg.Policy.TrustedAutoApprove) == 0 {
+			consoleUI.PrintColored(consoleUI.Yellow, "Note: 'policy.trusted_auto_approve' is empty, so nothing extra will be auto-approved.\n")
+		}
+		return 0
+
+	default:
+		consoleUI.PrintColored(consoleUI.Yellow, trustUsage)
+		return 1
+	}
+}
+
+// recordTrust writes a grant or revocation to the audit log.
+func recordTrust(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, g policy.Grant, decision, reason string) {
+	err := audit.Append(audit.Entry{
+		Event:    audit.EventTrust,
+		Command:  g.Path,
+		Decision: decision,
+		Identity: cfg.Storage.User,
+		Role:     "user",
+		Reason:   reason,
+	})
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to write audit log: %v\n", err)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/session"
+	"github.com/robbiemu/original_gangster/og/internal/store"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+	"github.com/robbiemu/original_gangster/og/internal/undo"
+)
+
+const undoUsage = "Usage: og undo <hash> [--list]\n"
+
+// runUndo implements `og undo`: restoring files the steps of a session
+// changed as the session found them, from the backups og made before each
+// step (undo.enabled). The backups stay in the session's temp directory
+// until every change is undone, or og clean removes them.
+func runUndo(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("undo", flag.ContinueOnError)
+	list := fs.Bool("list", false, "List the changes without restoring any")
+	hash, rest := splitPositional(args)
+	if err := fs.Parse(rest); err != nil {
+		return 1
+	}
+	if hash == "" && fs.NArg() > 0 {
+		hash = fs.Arg(0)
+	}
+	if hash == "" {
+		consoleUI.PrintColored(consoleUI.Yellow, undoUsage)
+		return 1
+	}
+
+	st, err := store.Open(cfg)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to open storage backend: %v\n", err)
+		return 1
+	}
+	defer st.Close()
+	records, err := st.History().List()
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read history: %v\n", err)
+		return 1
+	}
+	rec, err := findSession(records, hash)
+	if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "%v\n", err)
+		return 1
+	}
+	tempDir := st.Artifacts().Dir(rec.Hash)
+	dir := undo.Dir(tempDir)
+	entries, err := undo.Load(dir)
+	if os.IsNotExist(err) {
+		consoleUI.PrintColored(consoleUI.Yellow, "Session %s left no backups: its steps changed no files og backed up, or they were cleaned up.\n", shortHash(rec.Hash))
+		return 1
+	} else if err != nil {
+		consoleUI.PrintColored(consoleUI.Red, "Failed to read the backups of session %s: %v\n", shortHash(rec.Hash), err)
+		return 1
+	}
+	changes := undo.Changes(entries)
+	if len(changes) == 0 {
+		consoleUI.PrintColored(consoleUI.Green, "The files session %s backed up are as it found them.\n", shortHash(rec.Hash))
+		return 0
+	}
+	if *list {
+		for _, e := range changes {
+			fmt.Printf("  %-8s %s\n", e.Change(), e.Path)
+		}
+		return 0
+	}
+
+	consoleUI.PrintColored(consoleUI.Blue, "Session %s: %s\n", consoleUI.Cyan(shortHash(rec.Hash)), rec.Query)
+	if err := session.UndoChanges(consoleUI, rec.Hash, cfg.Storage.User, dir, changes); err != nil {
+		return 1
+	}
+	if len(undo.Changes(entries)) == 0 {
+		os.RemoveAll(tempDir) // Nothing is left to undo
+	}
+	return 0
+}
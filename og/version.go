@@ -0,0 +1,129 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/robbiemu/original_gangster/og/internal/agent"
+	"github.com/robbiemu/original_gangster/og/internal/config"
+	"github.com/robbiemu/original_gangster/og/internal/ui"
+)
+
+// Build information, injected with
+// -ldflags "-X main.version=v1.2.3 -X main.commit=abc1234 -X main.date=2025-01-31T12:00:00Z".
+// Builds without them fall back to what the Go toolchain recorded.
+var (
+	version = "dev"
+	commit  = ""
+	date    = ""
+)
+
+// buildInfo describes this binary for `og version`.
+type buildInfo struct {
+	Version              string `json:"version"`
+	Commit               string `json:"commit,omitempty"`
+	Date                 string `json:"date,omitempty"`
+	GoVersion            string `json:"go_version"`
+	Platform             string `json:"platform"`
+	ProtocolVersion      int    `json:"protocol_version"`
+	AgentPath            string `json:"agent_path,omitempty"`
+	AgentProtocolVersion *int   `json:"agent_protocol_version,omitempty"`
+	AgentError           string `json:"agent_error,omitempty"`
+	Python               string `json:"python,omitempty"`
+	PythonSource         string `json:"python_source,omitempty"`
+	PythonError          string `json:"python_error,omitempty"`
+}
+
+func currentBuildInfo() buildInfo {
+	info := buildInfo{
+		Version:         version,
+		Commit:          commit,
+		Date:            date,
+		GoVersion:       runtime.Version(),
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+		ProtocolVersion: agent.ProtocolVersion,
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		if info.Version == "dev" && bi.Main.Version != "" && bi.Main.Version != "(devel)" {
+			info.Version = bi.Main.Version // Installed with `go install ...@version`
+		}
+		for _, s := range bi.Settings {
+			switch {
+			case s.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = s.Value
+			case s.Key == "vcs.time" && info.Date == "":
+				info.Date = s.Value
+			case s.Key == "vcs.modified" && s.Value == "true" && commit == "" && info.Commit != "":
+				info.Commit += "-dirty"
+			}
+		}
+	}
+	if len(info.Commit) > 12 && commit == "" {
+		info.Commit = info.Commit[:12]
+	}
+	return info
+}
+
+// runVersion implements `og version` (and `og --version`). It works without a
+// config; with one, it also checks the protocol version of the configured agent.
+func runVersion(consoleUI *ui.ConsoleUI, cfg *config.OGConfig, args []string) int {
+	fs := flag.NewFlagSet("version", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print build information as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 1
+	}
+
+	info := currentBuildInfo()
+	if cfg != nil && cfg.General.PythonAgentPath != "" {
+		info.AgentPath = cfg.General.PythonAgentPath
+		if v, err := agent.AgentProtocolVersion(info.AgentPath); err != nil {
+			info.AgentError = err.Error()
+		} else {
+			info.AgentProtocolVersion = &v
+		}
+		if interpreter, err := agent.FindInterpreter(cfg.General.PythonInterpreter, info.AgentPath); err != nil {
+			info.PythonError = err.Error()
+		} else {
+			info.Python, info.PythonSource = interpreter.String(), interpreter.Source
+		}
+	}
+
+	if *asJSON {
+		b, _ := json.MarshalIndent(info, "", "  ")
+		fmt.Println(string(b))
+		return 0
+	}
+	fmt.Printf("og %s\n", info.Version)
+	if info.Commit != "" {
+		fmt.Printf("  commit:    %s\n", info.Commit)
+	}
+	if info.Date != "" {
+		fmt.Printf("  built:     %s\n", info.Date)
+	}
+	fmt.Printf("  go:        %s %s\n", info.GoVersion, info.Platform)
+	fmt.Printf("  protocol:  %d\n", info.ProtocolVersion)
+	switch {
+	case info.AgentPath == "":
+		return 0
+	case info.PythonError != "":
+		consoleUI.PrintColored(consoleUI.Yellow, "  python:    %s\n", info.PythonError)
+	default:
+		fmt.Printf("  python:    %s (%s)\n", info.Python, info.PythonSource)
+	}
+	switch {
+	case info.AgentError != "":
+		consoleUI.PrintColored(consoleUI.Yellow, "  agent:     %s (%s)\n", info.AgentPath, info.AgentError)
+	case *info.AgentProtocolVersion == info.ProtocolVersion:
+		consoleUI.PrintColored(consoleUI.Green, "  agent:     %s (protocol %d, compatible)\n", info.AgentPath, *info.AgentProtocolVersion)
+	case *info.AgentProtocolVersion == 0:
+		consoleUI.PrintColored(consoleUI.Red, "  agent:     %s (no protocol version; it predates this og)\n", info.AgentPath)
+		return 1
+	default:
+		consoleUI.PrintColored(consoleUI.Red, "  agent:     %s (protocol %d, incompatible)\n", info.AgentPath, *info.AgentProtocolVersion)
+		return 1
+	}
+	return 0
+}